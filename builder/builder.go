@@ -0,0 +1,143 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package builder exposes the image build pipeline of ccloudvm to Go
+// programs.  ccloudvm does not provide a Packer plugin.
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// BuilderID identifies the artifacts of the ccloudvm builder.
+const BuilderID = "intel.ccloudvm"
+
+// Config describes the image to be built.
+type Config struct {
+	Workload       string            `mapstructure:"workload"`
+	Template       string            `mapstructure:"template"`
+	Output         string            `mapstructure:"output"`
+	Params         map[string]string `mapstructure:"params"`
+	Mirrors        map[string]string `mapstructure:"mirrors"`
+	CPUs           int               `mapstructure:"cpus"`
+	MemMiB         int               `mapstructure:"mem_mib"`
+	DiskGiB        int               `mapstructure:"disk_gib"`
+	PackageUpgrade bool              `mapstructure:"package_upgrade"`
+	Debug          bool              `mapstructure:"debug"`
+}
+
+// Prepare checks c and sets the default values of its fields.
+func (c *Config) Prepare() error {
+	if c.Workload == "" {
+		return errors.New("workload must be specified")
+	}
+	if c.Template == "" {
+		c.Template = strings.TrimSuffix(filepath.Base(c.Workload), ".yaml")
+	}
+	if c.Output == "" {
+		c.Output = c.Template + ".qcow2"
+	}
+
+	output, err := filepath.Abs(c.Output)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid output %s", c.Output)
+	}
+	if _, err := os.Stat(output); err == nil {
+		return errors.Errorf("Output %s already exists", output)
+	}
+	c.Output = output
+	return nil
+}
+
+// Artifact is the image built by Build.
+type Artifact struct {
+	Info types.TemplateInfo
+}
+
+// BuilderId returns BuilderID.  Its name is the one required by Packer.
+func (a *Artifact) BuilderId() string {
+	return BuilderID
+}
+
+// Files returns the path of the qcow2 image.
+func (a *Artifact) Files() []string {
+	return []string{a.Info.Image}
+}
+
+// Id returns the name of the template.  Its name is the one required by
+// Packer.
+func (a *Artifact) Id() string {
+	return a.Info.Name
+}
+
+func (a *Artifact) String() string {
+	return fmt.Sprintf("ccloudvm template %s: %s", a.Info.Name, a.Info.Image)
+}
+
+// State returns the values describing the artifact, "sha256", the SHA-256
+// digest of the image, and "workload", the path of the workload of the
+// template, or nil.
+func (a *Artifact) State(name string) interface{} {
+	switch name {
+	case "sha256":
+		return a.Info.ImageSHA256
+	case "workload":
+		return a.Info.Workload
+	}
+	return nil
+}
+
+// Destroy removes the qcow2 image.  The template, from which instances can
+// still be created, is left in place.
+func (a *Artifact) Destroy() error {
+	if err := os.Remove(a.Info.Image); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "Unable to remove %s", a.Info.Image)
+	}
+	return nil
+}
+
+// Build runs the image build pipeline described by c, which must have been
+// prepared, and returns the image it has built.  The progress of the
+// provisioning of the image is printed to the standard output.
+func Build(ctx context.Context, c *Config) (*Artifact, error) {
+	args := &types.CreateArgs{
+		WorkloadName: c.Workload,
+		Debug:        c.Debug,
+		Update:       c.PackageUpgrade,
+		Params:       c.Params,
+		Mirrors:      c.Mirrors,
+		NoProfile:    true,
+		CustomSpec: types.VMSpec{
+			CPUs:    c.CPUs,
+			MemMiB:  c.MemMiB,
+			DiskGiB: c.DiskGiB,
+		},
+	}
+
+	info, err := client.BuildImage(ctx, args, c.Template, c.Output)
+	if err != nil {
+		return nil, err
+	}
+	return &Artifact{Info: *info}, nil
+}
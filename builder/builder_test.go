@@ -0,0 +1,111 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestPrepare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builder-tests")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	existing := filepath.Join(dir, "existing.qcow2")
+	if err := ioutil.WriteFile(existing, nil, 0600); err != nil {
+		t.Fatalf("Unable to create %s: %v", existing, err)
+	}
+
+	if err := (&Config{}).Prepare(); err == nil {
+		t.Errorf("Expected Prepare to fail without a workload")
+	}
+
+	c := &Config{Workload: "/home/user/workloads/ciao.yaml"}
+	if err := c.Prepare(); err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	if c.Template != "ciao" {
+		t.Errorf("Unexpected template %s", c.Template)
+	}
+	if output, _ := filepath.Abs("ciao.qcow2"); c.Output != output {
+		t.Errorf("Unexpected output %s, expected %s", c.Output, output)
+	}
+
+	c = &Config{
+		Workload: "https://example.com/workloads/ciao.yaml",
+		Template: "ciao-base",
+		Output:   filepath.Join(dir, "base.qcow2"),
+	}
+	if err := c.Prepare(); err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	if c.Template != "ciao-base" || c.Output != filepath.Join(dir, "base.qcow2") {
+		t.Errorf("Prepare overrode template %s or output %s", c.Template, c.Output)
+	}
+
+	c = &Config{Workload: "ciao", Output: existing}
+	if err := c.Prepare(); err == nil {
+		t.Errorf("Expected Prepare to fail when the output exists")
+	}
+}
+
+func TestArtifact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builder-tests")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	image := filepath.Join(dir, "ciao.qcow2")
+	if err := ioutil.WriteFile(image, []byte("qcow2"), 0600); err != nil {
+		t.Fatalf("Unable to create %s: %v", image, err)
+	}
+
+	a := &Artifact{
+		Info: types.TemplateInfo{
+			Name:        "ciao",
+			Image:       image,
+			ImageSHA256: "0123",
+		},
+	}
+	if a.BuilderId() != BuilderID || a.Id() != "ciao" {
+		t.Errorf("Unexpected builder %s or id %s", a.BuilderId(), a.Id())
+	}
+	if files := a.Files(); len(files) != 1 || files[0] != image {
+		t.Errorf("Unexpected files %v", files)
+	}
+	if a.State("sha256") != "0123" || a.State("unknown") != nil {
+		t.Errorf("Unexpected state")
+	}
+
+	if err := a.Destroy(); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	if _, err := os.Stat(image); !os.IsNotExist(err) {
+		t.Errorf("%s not removed", image)
+	}
+	if err := a.Destroy(); err != nil {
+		t.Errorf("Destroy of a removed image failed: %v", err)
+	}
+}
@@ -19,7 +19,6 @@ package main
 import (
 	"context"
 	"errors"
-	"fmt"
 	"os"
 
 	"github.com/intel/ccloudvm/types"
@@ -40,15 +39,16 @@ import (
 // StartResult(2)
 //
 type ServerAPI struct {
-	signalCh chan os.Signal
-	actionCh chan interface{}
+	signalCh   chan os.Signal
+	actionCh   chan interface{}
+	finishedCh chan struct{}
+	caller     *caller
+	audit      *auditLog
 }
 
-func (s *ServerAPI) sendStartAction(fn func(context.Context, service, chan interface{}), id *int) error {
-	action := startAction{
-		action:  fn,
-		transCh: make(chan int),
-	}
+func (s *ServerAPI) sendAction(action startAction, id *int) error {
+	action.transCh = make(chan int)
+	action.owner = s.caller.owner()
 
 	select {
 	case s.actionCh <- action:
@@ -57,15 +57,29 @@ func (s *ServerAPI) sendStartAction(fn func(context.Context, service, chan inter
 	}
 
 	*id = <-action.transCh
+	if *id == noTransaction {
+		return errors.New("Daemon is draining, no new commands are accepted")
+	}
 
 	return nil
 }
 
-func (s *ServerAPI) voidResult(id int, reply *struct{}) error {
-	result := getResult{
-		ID:  id,
-		res: make(chan interface{}),
+// resultRequest returns a request for the result of the transaction id on
+// behalf of the caller served by s.
+func (s *ServerAPI) resultRequest(id int) getResult {
+	return getResult{
+		ID:    id,
+		owner: s.caller.owner(),
+		res:   make(chan interface{}),
 	}
+}
+
+func (s *ServerAPI) sendStartAction(fn func(context.Context, service, chan interface{}), id *int) error {
+	return s.sendAction(startAction{action: fn}, id)
+}
+
+func (s *ServerAPI) voidResult(id int, reply *struct{}) error {
+	result := s.resultRequest(id)
 
 	select {
 	case s.actionCh <- result:
@@ -90,12 +104,62 @@ func (s *ServerAPI) voidResult(id int, reply *struct{}) error {
 	return err
 }
 
+// queuedResult retrieves the result of a command that may be queued behind
+// other commands on the same instance, calling queued with its position
+// while it waits and finished with its result.
+func (s *ServerAPI) queuedResult(id int, queued func(types.CommandResult),
+	finished func(interface{})) error {
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		return v
+	}
+
+	resultCh := r.(chan interface{})
+	v := <-resultCh
+	if pos, ok := v.(types.CommandResult); ok {
+		queued(pos)
+		return nil
+	}
+	finished(v)
+	err, _ := v.(error)
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	return err
+}
+
+// commandResult retrieves the result of a command that modifies an
+// instance.  While the command is queued behind other commands on the same
+// instance, it returns the command's position in the queue, leaving the
+// transaction open.
+func (s *ServerAPI) commandResult(id int, reply *types.CommandResult) error {
+	return s.queuedResult(id, func(pos types.CommandResult) {
+		*reply = pos
+	}, func(interface{}) {
+		*reply = types.CommandResult{Finished: true}
+	})
+}
+
 // Cancel can be used to cancel any command that has been issued but not
 // yet completed.
 func (s *ServerAPI) Cancel(arg int, reply *struct{}) error {
-	fmt.Printf("Cancel(%d) called\n", arg)
+	logDebugf("Cancel(%d) called", arg)
+	if err := s.authorize("Cancel"); err != nil {
+		return err
+	}
 	select {
-	case s.actionCh <- cancelAction(arg):
+	case s.actionCh <- cancelAction{ID: arg, owner: s.caller.owner()}:
 	case <-s.signalCh:
 		return errors.New("Operation cancelled")
 	}
@@ -107,7 +171,10 @@ func (s *ServerAPI) Cancel(arg int, reply *struct{}) error {
 // args parameter. The value pointed to by id is set to the transaction ID of the request
 // if no error occurs.
 func (s *ServerAPI) Create(args *types.CreateArgs, id *int) error {
-	fmt.Printf("Create %+v called\n", *args)
+	logDebugf("Create %+v called", redactCreateArgs(args))
+	if err := s.authorize("Create", args.Name); err != nil {
+		return err
+	}
 
 	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
 		svc.create(ctx, resultCh, args)
@@ -117,24 +184,19 @@ func (s *ServerAPI) Create(args *types.CreateArgs, id *int) error {
 		return err
 	}
 
-	fmt.Printf("Transaction ID %d\n", *id)
+	logDebugf("Transaction ID %d", *id)
 	return nil
 }
 
 // CreateResult blocks until information about the instance creation request has
-// been received.  This information could be an error, signalling that the
-// request has failed or a types.CreateResult.  CreateResult should be called continually
-// until res.Finished == true.  If successful, the final types.CreateResult returned will
-// have its Finished field set to true and its Name field set to the name of the instance.
+// been received.  CreateResult should be called continually until
+// res.Finished == true.
 func (s *ServerAPI) CreateResult(id int, res *types.CreateResult) error {
 	var err error
 
-	fmt.Printf("CreateResult(%d) called\n", id)
+	logDebugf("CreateResult(%d) called", id)
 
-	result := getResult{
-		ID:  id,
-		res: make(chan interface{}),
-	}
+	result := s.resultRequest(id)
 
 	select {
 	case s.actionCh <- result:
@@ -144,7 +206,7 @@ func (s *ServerAPI) CreateResult(id int, res *types.CreateResult) error {
 
 	r := <-result.res
 	if v, ok := r.(error); ok {
-		fmt.Printf("CreateResult(%d) finished: %v\n", id, err)
+		logDebugf("CreateResult(%d) finished: %v", id, err)
 		return v
 	}
 
@@ -164,66 +226,355 @@ func (s *ServerAPI) CreateResult(id int, res *types.CreateResult) error {
 	case <-s.signalCh:
 	}
 
-	fmt.Printf("CreateResult(%d) finished: %v\n", id, err)
+	logDebugf("CreateResult(%d) finished: %v", id, err)
 
 	return err
 }
 
 // Stop initiates a request to stop an instance.
-func (s *ServerAPI) Stop(instanceName string, id *int) error {
-	fmt.Printf("Stop [%s] called\n", instanceName)
+func (s *ServerAPI) Stop(args *types.StopArgs, id *int) error {
+	logDebugf("Stop [%s] called", args.Name)
+	if err := s.authorize("Stop", args.Name); err != nil {
+		return err
+	}
 
 	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
-		svc.stop(ctx, instanceName, resultCh)
+		svc.stop(ctx, args, resultCh)
 	}, id)
 
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Transaction ID %d\n", *id)
+	logDebugf("Transaction ID %d", *id)
 	return nil
 }
 
-// StopResult blocks until the instance has been stopped or an error has occurred.
-func (s *ServerAPI) StopResult(id int, reply *struct{}) error {
-	fmt.Printf("StopResult(%d) called\n", id)
-
-	err := s.voidResult(id, reply)
+// StopResult blocks until the instance has been stopped or an error has
+// occurred.  Like CommandResult, it returns before the instance has been
+// stopped, with Finished set to false, if the request is queued behind
+// other requests for the same instance.
+func (s *ServerAPI) StopResult(id int, reply *types.StopResult) error {
+	logDebugf("StopResult(%d) called", id)
+
+	err := s.queuedResult(id, func(pos types.CommandResult) {
+		*reply = types.StopResult{Position: pos.Position}
+	}, func(v interface{}) {
+		if res, ok := v.(types.StopResult); ok {
+			*reply = res
+		}
+		reply.Finished = true
+	})
 
-	fmt.Printf("StopResult(%d) finished: %v\n", id, err)
+	if reply.Finished {
+		logDebugf("StopResult(%d) finished: %v", id, err)
+	}
 	return err
 }
 
 // Start initiates a request to start an instance.
 func (s *ServerAPI) Start(args *types.StartArgs, id *int) error {
-	fmt.Printf("Start [%s] called\n", args.Name)
+	logDebugf("Start [%s] called", args.Name)
+	if err := s.authorize("Start", args.Name); err != nil {
+		return err
+	}
 
 	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
-		svc.start(ctx, args.Name, &args.VMSpec, resultCh)
+		svc.start(withPassphrase(ctx, args.Passphrase), args.Name, &args.VMSpec, args.Force, resultCh)
 	}, id)
 
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Transaction ID %d\n", *id)
+	logDebugf("Transaction ID %d", *id)
 	return nil
 }
 
 // StartResult blocks until the instance has been started or an error occurs.
-func (s *ServerAPI) StartResult(id int, reply *struct{}) error {
-	fmt.Printf("StartResult(%d) called\n", id)
+func (s *ServerAPI) StartResult(id int, reply *types.CommandResult) error {
+	logDebugf("StartResult(%d) called", id)
 
-	err := s.voidResult(id, reply)
+	err := s.commandResult(id, reply)
+
+	logDebugf("StartResult(%d) finished: %v", id, err)
+	return err
+}
+
+// Restart initiates a request to restart an instance.
+func (s *ServerAPI) Restart(args *types.RestartArgs, id *int) error {
+	logDebugf("Restart [%s] called", args.Name)
+	if err := s.authorize("Restart", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.restart(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// RestartResult blocks until the instance has been restarted or an error
+// occurs.
+func (s *ServerAPI) RestartResult(id int, reply *types.CommandResult) error {
+	logDebugf("RestartResult(%d) called", id)
+
+	err := s.commandResult(id, reply)
+
+	logDebugf("RestartResult(%d) finished: %v", id, err)
+	return err
+}
+
+// Resize initiates a request to change the resources assigned to an instance.
+func (s *ServerAPI) Resize(args *types.ResizeArgs, id *int) error {
+	logDebugf("Resize %+v called", *args)
+	if err := s.authorize("Resize", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.resize(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ResizeResult blocks until the instance has been resized or an error occurs.
+func (s *ServerAPI) ResizeResult(id int, reply *types.ResizeResult) error {
+	logDebugf("ResizeResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("ResizeResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case types.ResizeResult:
+		*reply = res
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("ResizeResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// AddForward initiates a request to add a port mapping to an instance.
+func (s *ServerAPI) AddForward(args *types.ForwardArgs, id *int) error {
+	logDebugf("AddForward %+v called", *args)
+	if err := s.authorize("AddForward", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.forward(ctx, args, true, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
 
-	fmt.Printf("StartResult(%d) finished: %v\n", id, err)
+// AddForwardResult blocks until the port mapping has been added or an error
+// occurs.
+func (s *ServerAPI) AddForwardResult(id int, reply *types.CommandResult) error {
+	logDebugf("AddForwardResult(%d) called", id)
+
+	err := s.commandResult(id, reply)
+
+	logDebugf("AddForwardResult(%d) finished: %v", id, err)
+	return err
+}
+
+// RemoveForward initiates a request to remove a port mapping from an
+// instance.
+func (s *ServerAPI) RemoveForward(args *types.ForwardArgs, id *int) error {
+	logDebugf("RemoveForward %+v called", *args)
+	if err := s.authorize("RemoveForward", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.forward(ctx, args, false, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// RemoveForwardResult blocks until the port mapping has been removed or an
+// error occurs.
+func (s *ServerAPI) RemoveForwardResult(id int, reply *types.CommandResult) error {
+	logDebugf("RemoveForwardResult(%d) called", id)
+
+	err := s.commandResult(id, reply)
+
+	logDebugf("RemoveForwardResult(%d) finished: %v", id, err)
+	return err
+}
+
+// Mount initiates a request to share a host directory with an instance.
+func (s *ServerAPI) Mount(args *types.MountArgs, id *int) error {
+	logDebugf("Mount %+v called", *args)
+	if err := s.authorize("Mount", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.mount(ctx, args, true, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// MountResult blocks until the directory has been shared or an error occurs.
+func (s *ServerAPI) MountResult(id int, reply *types.CommandResult) error {
+	logDebugf("MountResult(%d) called", id)
+
+	err := s.commandResult(id, reply)
+
+	logDebugf("MountResult(%d) finished: %v", id, err)
+	return err
+}
+
+// Unmount initiates a request to stop sharing a host directory with an
+// instance.
+func (s *ServerAPI) Unmount(args *types.MountArgs, id *int) error {
+	logDebugf("Unmount %+v called", *args)
+	if err := s.authorize("Unmount", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.mount(ctx, args, false, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// UnmountResult blocks until the directory is no longer shared or an error
+// occurs.
+func (s *ServerAPI) UnmountResult(id int, reply *types.CommandResult) error {
+	logDebugf("UnmountResult(%d) called", id)
+
+	err := s.commandResult(id, reply)
+
+	logDebugf("UnmountResult(%d) finished: %v", id, err)
+	return err
+}
+
+// AttachDisk initiates a request to attach a data disk to an instance.
+func (s *ServerAPI) AttachDisk(args *types.DiskArgs, id *int) error {
+	logDebugf("AttachDisk %+v called", *args)
+	if err := s.authorize("AttachDisk", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.disk(ctx, args, true, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// AttachDiskResult blocks until the disk has been attached or an error
+// occurs.
+func (s *ServerAPI) AttachDiskResult(id int, reply *types.CommandResult) error {
+	logDebugf("AttachDiskResult(%d) called", id)
+
+	err := s.commandResult(id, reply)
+
+	logDebugf("AttachDiskResult(%d) finished: %v", id, err)
+	return err
+}
+
+// DetachDisk initiates a request to detach a data disk from an instance.
+func (s *ServerAPI) DetachDisk(args *types.DiskArgs, id *int) error {
+	logDebugf("DetachDisk %+v called", *args)
+	if err := s.authorize("DetachDisk", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.disk(ctx, args, false, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// DetachDiskResult blocks until the disk has been detached or an error
+// occurs.
+func (s *ServerAPI) DetachDiskResult(id int, reply *types.CommandResult) error {
+	logDebugf("DetachDiskResult(%d) called", id)
+
+	err := s.commandResult(id, reply)
+
+	logDebugf("DetachDiskResult(%d) finished: %v", id, err)
 	return err
 }
 
 // Quit initiates a request to forcefully quit an instance.
 func (s *ServerAPI) Quit(instanceName string, id *int) error {
-	fmt.Printf("Quit [%s] called\n", instanceName)
+	logDebugf("Quit [%s] called", instanceName)
+	if err := s.authorize("Quit", instanceName); err != nil {
+		return err
+	}
 
 	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
 		svc.quit(ctx, instanceName, resultCh)
@@ -233,23 +584,26 @@ func (s *ServerAPI) Quit(instanceName string, id *int) error {
 		return nil
 	}
 
-	fmt.Printf("Transaction ID %d\n", *id)
+	logDebugf("Transaction ID %d", *id)
 	return nil
 }
 
 // QuitResult blocks until the instance has been quit or an error occurs.
-func (s *ServerAPI) QuitResult(id int, reply *struct{}) error {
-	fmt.Printf("QuitResult(%d) called\n", id)
+func (s *ServerAPI) QuitResult(id int, reply *types.CommandResult) error {
+	logDebugf("QuitResult(%d) called", id)
 
-	err := s.voidResult(id, reply)
+	err := s.commandResult(id, reply)
 
-	fmt.Printf("QuitResult(%d) finished: %v\n", id, err)
+	logDebugf("QuitResult(%d) finished: %v", id, err)
 	return err
 }
 
 // Delete initiates a request to delete an instance.
 func (s *ServerAPI) Delete(instanceName string, id *int) error {
-	fmt.Printf("Delete [%s] called\n", instanceName)
+	logDebugf("Delete [%s] called", instanceName)
+	if err := s.authorize("Delete", instanceName); err != nil {
+		return err
+	}
 
 	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
 		svc.delete(ctx, instanceName, resultCh)
@@ -259,45 +613,65 @@ func (s *ServerAPI) Delete(instanceName string, id *int) error {
 		return nil
 	}
 
-	fmt.Printf("Transaction ID %d\n", *id)
+	logDebugf("Transaction ID %d", *id)
 	return nil
 }
 
 // DeleteResult blocks until the instance has been deleted or an error has occurred.
-func (s *ServerAPI) DeleteResult(id int, reply *struct{}) error {
-	fmt.Printf("DeleteResult(%d) called\n", id)
+func (s *ServerAPI) DeleteResult(id int, reply *types.CommandResult) error {
+	logDebugf("DeleteResult(%d) called", id)
 
-	err := s.voidResult(id, reply)
+	err := s.commandResult(id, reply)
 
-	fmt.Printf("DeleteResult(%d) finished: %v\n", id, err)
+	logDebugf("DeleteResult(%d) finished: %v", id, err)
 	return err
 }
 
-// GetInstanceDetails initiates a request to retrieve information about an instance.
-func (s *ServerAPI) GetInstanceDetails(instanceName string, id *int) error {
-	fmt.Printf("GetInstanceDetails [%s] called\n", instanceName)
+// Batch initiates a request to start, stop, quit or delete several
+// instances in parallel.  Names that are patterns only match the instances
+// on which the caller may perform the action.  The outcome of the action
+// for each instance is retrieved with BatchResult.
+func (s *ServerAPI) Batch(args *types.BatchArgs, id *int) error {
+	logDebugf("Batch %s %v (all=%v) called", args.Action, args.Names, args.All)
+	op, err := batchOperation(args.Action)
+	if err != nil {
+		return err
+	}
 
-	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
-		svc.status(ctx, instanceName, resultCh)
+	var named []string
+	for _, name := range args.Names {
+		if !isBatchPattern(name) {
+			named = append(named, name)
+		}
+	}
+	if err := s.authorize(op, named...); err != nil {
+		return err
+	}
+	c := s.caller
+	allowed := func(name string) bool {
+		return c.allowed(op, []string{name}) == nil
+	}
+
+	err = s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.batch(withPassphrase(ctx, args.Passphrase), args, allowed, resultCh)
 	}, id)
 
 	if err != nil {
-		return nil
+		return err
 	}
 
-	fmt.Printf("Transaction ID %d\n", *id)
+	logDebugf("Transaction ID %d", *id)
 	return nil
 }
 
-// GetInstanceDetailsResult blocks until the instance's details have been received or
-// an error occurs.
-func (s *ServerAPI) GetInstanceDetailsResult(id int, reply *types.InstanceDetails) error {
-	fmt.Printf("GetInstanceDetailsResult(%d) called\n", id)
+// BatchResult blocks until the batch action has completed for all the
+// instances to which it applies and returns its outcome for each of them.
+// An error is only returned if the request as a whole failed, e.g., because
+// a name did not identify an instance.
+func (s *ServerAPI) BatchResult(id int, reply *types.BatchResult) error {
+	logDebugf("BatchResult(%d) called", id)
 
-	result := getResult{
-		ID:  id,
-		res: make(chan interface{}),
-	}
+	result := s.resultRequest(id)
 
 	select {
 	case s.actionCh <- result:
@@ -307,7 +681,7 @@ func (s *ServerAPI) GetInstanceDetailsResult(id int, reply *types.InstanceDetail
 
 	r := <-result.res
 	if v, ok := r.(error); ok {
-		fmt.Printf("GetInstanceDetailsResult(%d) finished: %v\n", id, v)
+		logDebugf("BatchResult(%d) finished: %v", id, v)
 		return v
 	}
 
@@ -317,43 +691,48 @@ func (s *ServerAPI) GetInstanceDetailsResult(id int, reply *types.InstanceDetail
 	switch res := (<-resultCh).(type) {
 	case error:
 		err = res
-	case types.InstanceDetails:
+	case types.BatchResult:
 		*reply = res
+	default:
+		err = errors.New("Operation cancelled")
 	}
+
 	select {
 	case s.actionCh <- completeAction(id):
 	case <-s.signalCh:
 	}
 
-	fmt.Printf("GetInstanceDetailsResult(%d) finished: %v\n", id, err)
+	logDebugf("BatchResult(%d) finished: %v", id, err)
 
 	return err
 }
 
-// GetInstances initiates a request to retrieve the names of the existing instances.
-func (s *ServerAPI) GetInstances(arg struct{}, id *int) error {
-	fmt.Println("GetInstances called")
+// Fsck initiates a request to check, and optionally repair, the disk of a
+// stopped instance.
+func (s *ServerAPI) Fsck(args *types.FsckArgs, id *int) error {
+	logDebugf("Fsck %+v called", *args)
+	if err := s.authorize("Fsck", args.Name); err != nil {
+		return err
+	}
 
 	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
-		svc.getInstances(ctx, resultCh)
+		svc.fsck(ctx, args, resultCh)
 	}, id)
 
 	if err != nil {
-		return nil
+		return err
 	}
 
-	fmt.Printf("Transaction ID %d\n", *id)
+	logDebugf("Transaction ID %d", *id)
 	return nil
 }
 
-// GetInstancesResult blocks until the names of all the instances have been received.
-func (s *ServerAPI) GetInstancesResult(id int, reply *[]string) error {
-	fmt.Printf("GetInstancesResult(%d) called\n", id)
+// FsckResult blocks until the instance's disk has been checked or an error
+// occurs.
+func (s *ServerAPI) FsckResult(id int, reply *types.FsckResult) error {
+	logDebugf("FsckResult(%d) called", id)
 
-	result := getResult{
-		ID:  id,
-		res: make(chan interface{}),
-	}
+	result := s.resultRequest(id)
 
 	select {
 	case s.actionCh <- result:
@@ -363,7 +742,7 @@ func (s *ServerAPI) GetInstancesResult(id int, reply *[]string) error {
 
 	r := <-result.res
 	if v, ok := r.(error); ok {
-		fmt.Printf("GetInstancesResult(%d) finished: %v\n", id, v)
+		logDebugf("FsckResult(%d) finished: %v", id, v)
 		return v
 	}
 
@@ -373,7 +752,7 @@ func (s *ServerAPI) GetInstancesResult(id int, reply *[]string) error {
 	switch res := (<-resultCh).(type) {
 	case error:
 		err = res
-	case []string:
+	case types.FsckResult:
 		*reply = res
 	}
 
@@ -382,7 +761,2416 @@ func (s *ServerAPI) GetInstancesResult(id int, reply *[]string) error {
 	case <-s.signalCh:
 	}
 
-	fmt.Printf("GetInstancesResult(%d) finished: %v\n", id, err)
+	logDebugf("FsckResult(%d) finished: %v", id, err)
 
 	return err
 }
+
+// Flatten initiates a request to copy the base image of a stopped
+// instance into its disk, so that the disk no longer depends on the image.
+func (s *ServerAPI) Flatten(instanceName string, id *int) error {
+	logDebugf("Flatten [%s] called", instanceName)
+	if err := s.authorize("Flatten", instanceName); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.flatten(ctx, instanceName, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// FlattenResult blocks until the disk of the instance has been flattened or
+// an error occurs.
+func (s *ServerAPI) FlattenResult(id int, reply *types.CommandResult) error {
+	logDebugf("FlattenResult(%d) called", id)
+
+	err := s.commandResult(id, reply)
+
+	logDebugf("FlattenResult(%d) finished: %v", id, err)
+	return err
+}
+
+// Rename initiates a request to rename a stopped instance.  Both the
+// current and the new name must be allowed by the caller's token.
+func (s *ServerAPI) Rename(args *types.RenameArgs, id *int) error {
+	logDebugf("Rename %+v called", *args)
+	if err := s.authorize("Rename", args.Name, args.NewName); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.rename(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// RenameResult blocks until the instance has been renamed or an error
+// occurs.
+func (s *ServerAPI) RenameResult(id int, reply *types.CommandResult) error {
+	logDebugf("RenameResult(%d) called", id)
+
+	err := s.commandResult(id, reply)
+
+	logDebugf("RenameResult(%d) finished: %v", id, err)
+	return err
+}
+
+// Backup initiates a request to back up the disk of an instance.
+func (s *ServerAPI) Backup(args *types.BackupArgs, id *int) error {
+	logDebugf("Backup %+v called", *args)
+	if err := s.authorize("Backup", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.backup(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// BackupResult blocks until the instance has been backed up and returns a
+// description of the backup.
+func (s *ServerAPI) BackupResult(id int, reply *types.BackupInfo) error {
+	logDebugf("BackupResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("BackupResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case types.BackupInfo:
+		*reply = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("BackupResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// ListBackups initiates a request to list the backups of an instance.
+func (s *ServerAPI) ListBackups(args *types.BackupListArgs, id *int) error {
+	logDebugf("ListBackups %+v called", *args)
+	if err := s.authorize("ListBackups", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.listBackups(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ListBackupsResult blocks until the backups of the instance have been
+// listed and returns them, along with the instance's backup policy.
+func (s *ServerAPI) ListBackupsResult(id int, reply *types.BackupList) error {
+	logDebugf("ListBackupsResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("ListBackupsResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case types.BackupList:
+		*reply = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("ListBackupsResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// RestoreBackup initiates a request to replace the disk of a stopped
+// instance with one of its backups.
+func (s *ServerAPI) RestoreBackup(args *types.RestoreArgs, id *int) error {
+	logDebugf("RestoreBackup %+v called", *args)
+	if err := s.authorize("RestoreBackup", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.restoreBackup(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// RestoreBackupResult blocks until the backup has been restored or an error
+// has occurred.
+func (s *ServerAPI) RestoreBackupResult(id int, reply *struct{}) error {
+	logDebugf("RestoreBackupResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("RestoreBackupResult(%d) finished: %v", id, err)
+	return err
+}
+
+// SetBackupPolicy initiates a request to set, or remove, the policy
+// scheduling the backups of an instance.
+func (s *ServerAPI) SetBackupPolicy(args *types.BackupPolicy, id *int) error {
+	logDebugf("SetBackupPolicy %+v called", *args)
+	if err := s.authorize("SetBackupPolicy", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.setBackupPolicy(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// SetBackupPolicyResult blocks until the backup policy has been updated or
+// an error has occurred.
+func (s *ServerAPI) SetBackupPolicyResult(id int, reply *struct{}) error {
+	logDebugf("SetBackupPolicyResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("SetBackupPolicyResult(%d) finished: %v", id, err)
+	return err
+}
+
+// Suspend initiates a request to save the state of the VM of an instance,
+// or of all the running instances, to disk and to quit the VM.
+func (s *ServerAPI) Suspend(args *types.SuspendArgs, id *int) error {
+	logDebugf("Suspend %+v called", *args)
+	if err := s.authorize("Suspend", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.suspend(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// SuspendResult blocks until the instances have been suspended or an error has
+// occurred and returns the names of the suspended instances.
+func (s *ServerAPI) SuspendResult(id int, reply *[]string) error {
+	logDebugf("SuspendResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("SuspendResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case []string:
+		*reply = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("SuspendResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// ResumeFromDisk initiates a request to boot the VM of a suspended instance,
+// or of all the suspended instances, from its saved state.
+func (s *ServerAPI) ResumeFromDisk(args *types.ResumeArgs, id *int) error {
+	logDebugf("ResumeFromDisk [%s] called", args.Name)
+	if err := s.authorize("ResumeFromDisk", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.resumeFromDisk(withPassphrase(ctx, args.Passphrase), args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ResumeFromDiskResult blocks until the instances have been resumed or an error
+// has occurred and returns the names of the resumed instances.
+func (s *ServerAPI) ResumeFromDiskResult(id int, reply *[]string) error {
+	logDebugf("ResumeFromDiskResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("ResumeFromDiskResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case []string:
+		*reply = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("ResumeFromDiskResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// Set initiates a request to change the settings of an instance.
+func (s *ServerAPI) Set(args *types.SetArgs, id *int) error {
+	logDebugf("Set %+v called", *args)
+	if err := s.authorize("Set", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.set(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// SetResult blocks until the settings of the instance have been changed or
+// an error has occurred.
+func (s *ServerAPI) SetResult(id int, reply *struct{}) error {
+	logDebugf("SetResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("SetResult(%d) finished: %v", id, err)
+	return err
+}
+
+// SyncStatus initiates a request to retrieve the state of the directories
+// synced into an instance.
+func (s *ServerAPI) SyncStatus(name string, id *int) error {
+	logDebugf("SyncStatus [%s] called", name)
+	if err := s.authorize("SyncStatus", name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.syncStatus(ctx, name, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// SyncStatusResult blocks until the state of the syncs of the instance has
+// been retrieved or an error has occurred.
+func (s *ServerAPI) SyncStatusResult(id int, reply *types.SyncStatusResult) error {
+	logDebugf("SyncStatusResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("SyncStatusResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case *types.SyncStatusResult:
+		*reply = *res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("SyncStatusResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// PauseSync initiates a request to pause the syncing of directories into
+// an instance.
+func (s *ServerAPI) PauseSync(name string, id *int) error {
+	logDebugf("PauseSync [%s] called", name)
+	if err := s.authorize("PauseSync", name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.pauseSync(ctx, name, true, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// PauseSyncResult blocks until the syncs of the instance have been paused
+// or an error has occurred.
+func (s *ServerAPI) PauseSyncResult(id int, reply *struct{}) error {
+	logDebugf("PauseSyncResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("PauseSyncResult(%d) finished: %v", id, err)
+	return err
+}
+
+// ResumeSync initiates a request to resume the syncing of directories into
+// an instance.
+func (s *ServerAPI) ResumeSync(name string, id *int) error {
+	logDebugf("ResumeSync [%s] called", name)
+	if err := s.authorize("ResumeSync", name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.pauseSync(ctx, name, false, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ResumeSyncResult blocks until the syncs of the instance have been resumed
+// or an error has occurred.
+func (s *ServerAPI) ResumeSyncResult(id int, reply *struct{}) error {
+	logDebugf("ResumeSyncResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("ResumeSyncResult(%d) finished: %v", id, err)
+	return err
+}
+
+// GetInstanceDetails initiates a request to retrieve information about an instance.
+func (s *ServerAPI) GetInstanceDetails(instanceName string, id *int) error {
+	logDebugf("GetInstanceDetails [%s] called", instanceName)
+	if err := s.authorize("GetInstanceDetails", instanceName); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.status(ctx, instanceName, resultCh)
+	}, id)
+
+	if err != nil {
+		return nil
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// GetInstanceDetailsResult blocks until the instance's details have been received or
+// an error occurs.
+func (s *ServerAPI) GetInstanceDetailsResult(id int, reply *types.InstanceDetails) error {
+	logDebugf("GetInstanceDetailsResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("GetInstanceDetailsResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case types.InstanceDetails:
+		*reply = res
+	}
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("GetInstanceDetailsResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// GetSSHKey initiates a request to retrieve the SSH key used to access an
+// instance.
+func (s *ServerAPI) GetSSHKey(instanceName string, id *int) error {
+	logDebugf("GetSSHKey [%s] called", instanceName)
+	if err := s.authorize("GetSSHKey", instanceName); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.sshKey(ctx, instanceName, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// GetSSHKeyResult blocks until the instance's SSH key has been retrieved or
+// an error occurs.
+func (s *ServerAPI) GetSSHKeyResult(id int, reply *types.SSHKeyResult) error {
+	logDebugf("GetSSHKeyResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("GetSSHKeyResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case types.SSHKeyResult:
+		*reply = res
+	}
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("GetSSHKeyResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// GetKubeconfig initiates a request to retrieve the kubeconfig of the
+// Kubernetes cluster run by an instance.
+func (s *ServerAPI) GetKubeconfig(instanceName string, id *int) error {
+	logDebugf("GetKubeconfig [%s] called", instanceName)
+	if err := s.authorize("GetKubeconfig", instanceName); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.kubeconfig(ctx, instanceName, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// GetKubeconfigResult blocks until the kubeconfig of the instance has been
+// retrieved or an error occurs.
+func (s *ServerAPI) GetKubeconfigResult(id int, reply *types.KubeconfigResult) error {
+	logDebugf("GetKubeconfigResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("GetKubeconfigResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case *types.KubeconfigResult:
+		*reply = *res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("GetKubeconfigResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// RefreshStatus initiates a request to probe the status of one or more
+// instances.
+func (s *ServerAPI) RefreshStatus(args *types.RefreshStatusArgs, id *int) error {
+	logDebugf("RefreshStatus %+v called", *args)
+	if err := s.authorize("RefreshStatus", args.Names...); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.refreshStatus(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// RefreshStatusResult blocks until the status of the requested instances has
+// been refreshed or an error occurs.
+func (s *ServerAPI) RefreshStatusResult(id int, reply *types.RefreshStatusResult) error {
+	logDebugf("RefreshStatusResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("RefreshStatusResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case types.RefreshStatusResult:
+		*reply = res
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("RefreshStatusResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// GetInstances initiates a request to retrieve summaries of the existing
+// instances whose labels match args.Selector.
+func (s *ServerAPI) GetInstances(args *types.InstancesArgs, id *int) error {
+	logDebugf("GetInstances %+v called", args)
+
+	// The instances that the caller is not allowed to access are removed
+	// from the result by GetInstancesResult.
+
+	if err := s.authorize("GetInstances"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.getInstances(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return nil
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// GetInstancesResult blocks until the summaries of the selected instances
+// have been received.
+func (s *ServerAPI) GetInstancesResult(id int, reply *[]types.InstanceSummary) error {
+	logDebugf("GetInstancesResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("GetInstancesResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case []types.InstanceSummary:
+		visible := make([]types.InstanceSummary, 0, len(res))
+		for _, i := range res {
+			if s.caller.allowed("GetInstances", []string{i.Name}) == nil {
+				visible = append(visible, i)
+			}
+		}
+		*reply = visible
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("GetInstancesResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// CreateGroup initiates a request to create the instances of a group
+// workload.  args.Name is the name of the group and args.WorkloadName the
+// name of the group workload.  The progress of the request is retrieved
+// with CreateGroupResult.
+func (s *ServerAPI) CreateGroup(args *types.CreateArgs, id *int) error {
+	logDebugf("CreateGroup %+v called", redactCreateArgs(args))
+
+	// The names of the instances of the group start with the name of the
+	// group.
+
+	if err := s.authorize("CreateGroup", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.createGroup(ctx, resultCh, args)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// CreateGroupResult blocks until information about the group creation
+// request has been received.  It behaves exactly as CreateResult.
+func (s *ServerAPI) CreateGroupResult(id int, res *types.CreateResult) error {
+	return s.CreateResult(id, res)
+}
+
+func (s *ServerAPI) groupAction(op string, args *types.GroupArgs, action int, id *int) error {
+	if err := s.authorize(op); err != nil {
+		return err
+	}
+	c := s.caller
+	allowed := func(name string) bool {
+		return c.allowed(op, []string{name}) == nil
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.groupAction(ctx, args.Name, action, allowed, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// StartGroup initiates a request to start all the instances of a group.
+func (s *ServerAPI) StartGroup(args *types.GroupArgs, id *int) error {
+	logDebugf("StartGroup [%s] called", args.Name)
+	return s.groupAction("StartGroup", args, groupStart, id)
+}
+
+// StartGroupResult blocks until all the instances of the group have been
+// started or an error has occurred.
+func (s *ServerAPI) StartGroupResult(id int, reply *struct{}) error {
+	logDebugf("StartGroupResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("StartGroupResult(%d) finished: %v", id, err)
+	return err
+}
+
+// StopGroup initiates a request to stop all the instances of a group.
+func (s *ServerAPI) StopGroup(args *types.GroupArgs, id *int) error {
+	logDebugf("StopGroup [%s] called", args.Name)
+	return s.groupAction("StopGroup", args, groupStop, id)
+}
+
+// StopGroupResult blocks until all the instances of the group have been
+// stopped or an error has occurred.
+func (s *ServerAPI) StopGroupResult(id int, reply *struct{}) error {
+	logDebugf("StopGroupResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("StopGroupResult(%d) finished: %v", id, err)
+	return err
+}
+
+// QuitGroup initiates a request to quit all the instances of a group.
+func (s *ServerAPI) QuitGroup(args *types.GroupArgs, id *int) error {
+	logDebugf("QuitGroup [%s] called", args.Name)
+	return s.groupAction("QuitGroup", args, groupQuit, id)
+}
+
+// QuitGroupResult blocks until all the instances of the group have quit
+// or an error has occurred.
+func (s *ServerAPI) QuitGroupResult(id int, reply *struct{}) error {
+	logDebugf("QuitGroupResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("QuitGroupResult(%d) finished: %v", id, err)
+	return err
+}
+
+// DeleteGroup initiates a request to delete all the instances of a group.
+func (s *ServerAPI) DeleteGroup(args *types.GroupArgs, id *int) error {
+	logDebugf("DeleteGroup [%s] called", args.Name)
+	return s.groupAction("DeleteGroup", args, groupDelete, id)
+}
+
+// DeleteGroupResult blocks until all the instances of the group have been
+// deleted or an error has occurred.
+func (s *ServerAPI) DeleteGroupResult(id int, reply *struct{}) error {
+	logDebugf("DeleteGroupResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("DeleteGroupResult(%d) finished: %v", id, err)
+	return err
+}
+
+// WatchEvents initiates a subscription to the lifecycle events of the
+// instances listed in args.Names, or of all the instances the caller is
+// allowed to access if args.Names is empty.  The subscription lasts until
+// it is cancelled.
+func (s *ServerAPI) WatchEvents(args *types.WatchEventsArgs, id *int) error {
+	logDebugf("WatchEvents %+v called", *args)
+	if err := s.authorize("WatchEvents", args.Names...); err != nil {
+		return err
+	}
+
+	err := s.sendAction(startAction{
+		action: func(ctx context.Context, svc service, resultCh chan interface{}) {
+			svc.watchEvents(ctx, args, resultCh)
+		},
+		interruptible: true,
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// WatchEventsResult blocks until the next event is delivered to the
+// subscription.  It should be called repeatedly until it returns an error,
+// which it does once the subscription has been cancelled.
+func (s *ServerAPI) WatchEventsResult(id int, reply *types.InstanceEvent) error {
+	logDebugf("WatchEventsResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("WatchEventsResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	resultCh := r.(chan interface{})
+	for {
+		v, ok := (<-resultCh).(types.InstanceEvent)
+		if !ok {
+			break
+		}
+		if s.caller.allowed("WatchEvents", []string{v.Name}) == nil {
+			*reply = v
+			return nil
+		}
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	err := errors.New("Subscription cancelled")
+	logDebugf("WatchEventsResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// Report initiates a request to retrieve the resources consumed by instances
+// since args.Since.
+func (s *ServerAPI) Report(args *types.ReportArgs, id *int) error {
+	logDebugf("Report %+v called", *args)
+	if err := s.authorize("Report"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.report(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// visibleReport returns report without the instances that the caller is not
+// allowed to access, whose consumption is not included in the total.
+func (s *ServerAPI) visibleReport(report types.ReportResult) types.ReportResult {
+	visible := types.ReportResult{Since: report.Since}
+	for _, i := range report.Instances {
+		if s.caller.allowed("Report", []string{i.Name}) == nil {
+			visible.Instances = append(visible.Instances, i)
+			visible.Total.CPUSeconds += i.Usage.CPUSeconds
+			visible.Total.EnergyJoules += i.Usage.EnergyJoules
+		}
+	}
+	if len(visible.Instances) == len(report.Instances) {
+		return report
+	}
+	return visible
+}
+
+// ReportResult blocks until the resources consumed by instances have been
+// retrieved or an error occurs.
+func (s *ServerAPI) ReportResult(id int, reply *types.ReportResult) error {
+	logDebugf("ReportResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("ReportResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case types.ReportResult:
+		*reply = s.visibleReport(res)
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("ReportResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// Preflight initiates a request to check that the host has everything
+// needed to create instances.
+func (s *ServerAPI) Preflight(args *types.PreflightArgs, id *int) error {
+	logDebugf("Preflight called")
+	if err := s.authorize("Preflight"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.preflight(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// PreflightResult blocks until the host has been checked or an error
+// occurs.
+func (s *ServerAPI) PreflightResult(id int, reply *types.PreflightResult) error {
+	logDebugf("PreflightResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("PreflightResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case types.PreflightResult:
+		*reply = res
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("PreflightResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// GetAuditLog initiates a request to retrieve the records of the audit log
+// matching args.
+func (s *ServerAPI) GetAuditLog(args *types.AuditLogArgs, id *int) error {
+	logDebugf("GetAuditLog %+v called", *args)
+	if err := s.authorize("GetAuditLog", args.Instance); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.auditLog(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// GetAuditLogResult blocks until the records of the audit log have been
+// retrieved or an error occurs.
+func (s *ServerAPI) GetAuditLogResult(id int, reply *[]types.AuditRecord) error {
+	logDebugf("GetAuditLogResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("GetAuditLogResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case []types.AuditRecord:
+		*reply = res
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("GetAuditLogResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// GetConsoleLog initiates a request to retrieve the serial console log of
+// an instance and, if args.Follow is true, to follow the console's output.
+func (s *ServerAPI) GetConsoleLog(args *types.ConsoleLogArgs, id *int) error {
+	logDebugf("GetConsoleLog %+v called", *args)
+	if err := s.authorize("GetConsoleLog", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendAction(startAction{
+		action: func(ctx context.Context, svc service, resultCh chan interface{}) {
+			svc.consoleLog(ctx, args, resultCh)
+		},
+		interruptible: args.Follow,
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// GetConsoleLogResult blocks until the next piece of console output is
+// available.  It should be called repeatedly until it returns an error or
+// a result whose Finished field is true.  When following a console, the
+// request lasts until it is cancelled or the instance is deleted.
+func (s *ServerAPI) GetConsoleLogResult(id int, reply *types.ConsoleOutput) error {
+	logDebugf("GetConsoleLogResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("GetConsoleLogResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case types.ConsoleOutput:
+		*reply = res
+		if !res.Finished {
+			return nil
+		}
+	case error:
+		err = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("GetConsoleLogResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// SendConsoleInput initiates a request to write to the serial console of an
+// instance.
+func (s *ServerAPI) SendConsoleInput(args *types.ConsoleInputArgs, id *int) error {
+	// The input is not logged as it may contain passwords.
+	logDebugf("SendConsoleInput [%s] called", args.Name)
+	if err := s.authorize("SendConsoleInput", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.consoleInput(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// SendConsoleInputResult blocks until the input has been written to the
+// console or an error occurs.
+func (s *ServerAPI) SendConsoleInputResult(id int, reply *struct{}) error {
+	logDebugf("SendConsoleInputResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("SendConsoleInputResult(%d) finished: %v", id, err)
+	return err
+}
+
+// CreateNetwork initiates a request to create a named network.
+func (s *ServerAPI) CreateNetwork(args *types.NetworkSpec, id *int) error {
+	logDebugf("CreateNetwork %+v called", *args)
+	if err := s.authorize("CreateNetwork"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.createNetwork(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// CreateNetworkResult blocks until the network has been created or an error
+// has occurred.
+func (s *ServerAPI) CreateNetworkResult(id int, reply *struct{}) error {
+	logDebugf("CreateNetworkResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("CreateNetworkResult(%d) finished: %v", id, err)
+	return err
+}
+
+// DeleteNetwork initiates a request to delete a named network.  Networks
+// cannot be deleted while instances are connected to them.
+func (s *ServerAPI) DeleteNetwork(networkName string, id *int) error {
+	logDebugf("DeleteNetwork [%s] called", networkName)
+	if err := s.authorize("DeleteNetwork"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.deleteNetwork(ctx, networkName, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// DeleteNetworkResult blocks until the network has been deleted or an error
+// has occurred.
+func (s *ServerAPI) DeleteNetworkResult(id int, reply *struct{}) error {
+	logDebugf("DeleteNetworkResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("DeleteNetworkResult(%d) finished: %v", id, err)
+	return err
+}
+
+// ListNetworks initiates a request to retrieve the networks and the
+// instances connected to them.
+func (s *ServerAPI) ListNetworks(arg struct{}, id *int) error {
+	logDebugf("ListNetworks called")
+	if err := s.authorize("ListNetworks"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.listNetworks(ctx, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ListNetworksResult blocks until the networks have been retrieved.
+func (s *ServerAPI) ListNetworksResult(id int, reply *[]types.NetworkInfo) error {
+	logDebugf("ListNetworksResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("ListNetworksResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case []types.NetworkInfo:
+		*reply = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("ListNetworksResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// CreateVolume initiates a request to create a named volume.
+func (s *ServerAPI) CreateVolume(args *types.VolumeSpec, id *int) error {
+	logDebugf("CreateVolume %+v called", *args)
+	if err := s.authorize("CreateVolume"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.createVolume(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// CreateVolumeResult blocks until the volume has been created or an error
+// has occurred.
+func (s *ServerAPI) CreateVolumeResult(id int, reply *struct{}) error {
+	logDebugf("CreateVolumeResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("CreateVolumeResult(%d) finished: %v", id, err)
+	return err
+}
+
+// DeleteVolume initiates a request to delete a named volume.  Volumes
+// cannot be deleted while they are attached to instances.
+func (s *ServerAPI) DeleteVolume(volumeName string, id *int) error {
+	logDebugf("DeleteVolume [%s] called", volumeName)
+	if err := s.authorize("DeleteVolume"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.deleteVolume(ctx, volumeName, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// DeleteVolumeResult blocks until the volume has been deleted or an error
+// has occurred.
+func (s *ServerAPI) DeleteVolumeResult(id int, reply *struct{}) error {
+	logDebugf("DeleteVolumeResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("DeleteVolumeResult(%d) finished: %v", id, err)
+	return err
+}
+
+// ListVolumes initiates a request to retrieve the volumes and the
+// instances to which they are attached.
+func (s *ServerAPI) ListVolumes(arg struct{}, id *int) error {
+	logDebugf("ListVolumes called")
+	if err := s.authorize("ListVolumes"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.listVolumes(ctx, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ListVolumesResult blocks until the volumes have been retrieved.
+func (s *ServerAPI) ListVolumesResult(id int, reply *[]types.VolumeInfo) error {
+	logDebugf("ListVolumesResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("ListVolumesResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case []types.VolumeInfo:
+		*reply = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("ListVolumesResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// ListCaches initiates a request to retrieve the shared caches and the
+// instances that use them.
+func (s *ServerAPI) ListCaches(arg struct{}, id *int) error {
+	logDebugf("ListCaches called")
+	if err := s.authorize("ListCaches"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.listCaches(ctx, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ListCachesResult blocks until the caches have been retrieved.
+func (s *ServerAPI) ListCachesResult(id int, reply *[]types.CacheInfo) error {
+	logDebugf("ListCachesResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("ListCachesResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case []types.CacheInfo:
+		*reply = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("ListCachesResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// ClearCache initiates a request to delete the contents of a shared cache.
+// The caches used by running instances cannot be cleared.
+func (s *ServerAPI) ClearCache(cacheName string, id *int) error {
+	logDebugf("ClearCache [%s] called", cacheName)
+	if err := s.authorize("ClearCache"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.clearCache(ctx, cacheName, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ClearCacheResult blocks until the cache has been cleared or an error has
+// occurred.
+func (s *ServerAPI) ClearCacheResult(id int, reply *struct{}) error {
+	logDebugf("ClearCacheResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("ClearCacheResult(%d) finished: %v", id, err)
+	return err
+}
+
+// Prefetch initiates a request to download the workload args.WorkloadName
+// and all the files needed to create instances of it, so that they can be
+// created offline.  The progress of the request is retrieved with
+// PrefetchResult.
+func (s *ServerAPI) Prefetch(args *types.CreateArgs, id *int) error {
+	logDebugf("Prefetch %+v called", redactCreateArgs(args))
+	if err := s.authorize("Prefetch"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.prefetch(ctx, resultCh, args)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// PrefetchResult blocks until information about the prefetch request has
+// been received.  It behaves exactly as CreateResult, except that the
+// Name of the final types.CreateResult is empty.
+func (s *ServerAPI) PrefetchResult(id int, res *types.CreateResult) error {
+	return s.CreateResult(id, res)
+}
+
+// UpdateWorkloads initiates a request to download again the cached remote
+// workloads identified by URLs, or all of them if URLs is empty.
+func (s *ServerAPI) UpdateWorkloads(URLs []string, id *int) error {
+	logDebugf("UpdateWorkloads %v called", URLs)
+	if err := s.authorize("UpdateWorkloads"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.updateWorkloads(ctx, URLs, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// UpdateWorkloadsResult blocks until the workloads have been updated and
+// returns the URLs of the updated workloads.
+func (s *ServerAPI) UpdateWorkloadsResult(id int, reply *[]string) error {
+	logDebugf("UpdateWorkloadsResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("UpdateWorkloadsResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case []string:
+		*reply = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("UpdateWorkloadsResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// GetWorkloads initiates a request to retrieve the workloads from which
+// instances can be created.
+func (s *ServerAPI) GetWorkloads(arg struct{}, id *int) error {
+	logDebugf("GetWorkloads called")
+	if err := s.authorize("GetWorkloads"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.getWorkloads(ctx, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// GetWorkloadsResult blocks until the workloads have been retrieved.
+func (s *ServerAPI) GetWorkloadsResult(id int, reply *[]types.WorkloadInfo) error {
+	logDebugf("GetWorkloadsResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("GetWorkloadsResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case []types.WorkloadInfo:
+		*reply = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("GetWorkloadsResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// ShowWorkload initiates a request to render the instance specification and
+// the cloud-init document of a workload.
+func (s *ServerAPI) ShowWorkload(workloadName string, id *int) error {
+	logDebugf("ShowWorkload [%s] called", workloadName)
+	if err := s.authorize("ShowWorkload"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.showWorkload(ctx, workloadName, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ShowWorkloadResult blocks until the workload has been rendered.
+func (s *ServerAPI) ShowWorkloadResult(id int, reply *types.WorkloadDetails) error {
+	logDebugf("ShowWorkloadResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("ShowWorkloadResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case *types.WorkloadDetails:
+		*reply = *res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("ShowWorkloadResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// ValidateWorkload initiates a request to check a workload for errors.
+func (s *ServerAPI) ValidateWorkload(args *types.ValidateWorkloadArgs, id *int) error {
+	logDebugf("ValidateWorkload [%s] called", args.Name)
+	if err := s.authorize("ValidateWorkload"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.validateWorkload(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ValidateWorkloadResult blocks until the workload has been checked and
+// returns the errors found in the workload, if any.
+func (s *ServerAPI) ValidateWorkloadResult(id int, reply *[]types.WorkloadProblem) error {
+	logDebugf("ValidateWorkloadResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("ValidateWorkloadResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case []types.WorkloadProblem:
+		*reply = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("ValidateWorkloadResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// Export initiates a request to export a stopped instance to an archive.
+func (s *ServerAPI) Export(args *types.ExportArgs, id *int) error {
+	logDebugf("Export %+v called", *args)
+	if err := s.authorize("Export", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.exportInstance(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ExportResult blocks until the instance has been exported or an error has
+// occurred.
+func (s *ServerAPI) ExportResult(id int, reply *struct{}) error {
+	logDebugf("ExportResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("ExportResult(%d) finished: %v", id, err)
+	return err
+}
+
+// BuildTemplate initiates a request to seal a running instance and turn it
+// into a template.
+func (s *ServerAPI) BuildTemplate(args *types.BuildTemplateArgs, id *int) error {
+	logDebugf("BuildTemplate %+v called", *args)
+	if err := s.authorize("BuildTemplate", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.buildTemplate(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// BuildTemplateResult blocks until the template has been built or an error
+// has occurred.  It returns a description of the template.
+func (s *ServerAPI) BuildTemplateResult(id int, reply *types.TemplateInfo) error {
+	logDebugf("BuildTemplateResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("BuildTemplateResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case *types.TemplateInfo:
+		*reply = *res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("BuildTemplateResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// Import initiates a request to create a workload from an archive created by
+// exporting an instance.
+func (s *ServerAPI) Import(args *types.ImportArgs, id *int) error {
+	logDebugf("Import %+v called", *args)
+	if err := s.authorize("Import"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.importWorkload(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ImportResult blocks until the archive has been imported and returns the
+// name of the new workload.
+func (s *ServerAPI) ImportResult(id int, reply *string) error {
+	logDebugf("ImportResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("ImportResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case string:
+		*reply = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("ImportResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// ImportVagrant initiates a request to create a workload from a
+// Vagrantfile.
+func (s *ServerAPI) ImportVagrant(args *types.ImportVagrantArgs, id *int) error {
+	logDebugf("ImportVagrant %+v called", *args)
+	if err := s.authorize("ImportVagrant"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.importVagrant(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ImportVagrantResult blocks until the Vagrantfile has been imported and
+// returns the name of the new workload.
+func (s *ServerAPI) ImportVagrantResult(id int, reply *types.ImportVagrantResult) error {
+	logDebugf("ImportVagrantResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("ImportVagrantResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case *types.ImportVagrantResult:
+		*reply = *res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("ImportVagrantResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// Push initiates a request to push an exported instance to an OCI registry.
+func (s *ServerAPI) Push(args *types.PushArgs, id *int) error {
+	logDebugf("Push %+v called", *args)
+	if err := s.authorize("Push"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.pushWorkload(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// PushResult blocks until the exported instance has been pushed or an error
+// has occurred.
+func (s *ServerAPI) PushResult(id int, reply *struct{}) error {
+	logDebugf("PushResult(%d) called", id)
+
+	err := s.voidResult(id, reply)
+
+	logDebugf("PushResult(%d) finished: %v", id, err)
+	return err
+}
+
+// Pull initiates a request to create a workload from an exported instance
+// stored in an OCI registry.
+func (s *ServerAPI) Pull(args *types.PullArgs, id *int) error {
+	logDebugf("Pull %+v called", *args)
+	if err := s.authorize("Pull"); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.pullWorkload(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// PullResult blocks until the exported instance has been pulled and
+// imported and returns the name of the new workload.
+func (s *ServerAPI) PullResult(id int, reply *string) error {
+	logDebugf("PullResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("PullResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		err = res
+	case string:
+		*reply = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("PullResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// Exec initiates a request to execute a command in an instance over SSH.
+func (s *ServerAPI) Exec(args *types.ExecArgs, id *int) error {
+	logDebugf("Exec %+v called", *args)
+	if err := s.authorize("Exec", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.exec(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ExecResult blocks until the next piece of the command's output is
+// available.  It should be called repeatedly until it returns an error or
+// a result whose Finished field is true, which contains the command's exit
+// code.
+func (s *ServerAPI) ExecResult(id int, reply *types.ExecOutput) error {
+	logDebugf("ExecResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("ExecResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case types.ExecOutput:
+		*reply = res
+		if !res.Finished {
+			return nil
+		}
+	case error:
+		err = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("ExecResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// Proxy initiates a request to run a SOCKS proxy, and optionally an HTTP
+// proxy, through an instance.  The proxies run until the request is
+// cancelled.
+func (s *ServerAPI) Proxy(args *types.ProxyArgs, id *int) error {
+	logDebugf("Proxy %+v called", *args)
+	if err := s.authorize("Proxy", args.Name); err != nil {
+		return err
+	}
+
+	err := s.sendStartAction(func(ctx context.Context, svc service, resultCh chan interface{}) {
+		svc.proxy(ctx, args, resultCh)
+	}, id)
+
+	if err != nil {
+		return err
+	}
+
+	logDebugf("Transaction ID %d", *id)
+	return nil
+}
+
+// ProxyResult blocks until the proxies have started or stopped.  It should
+// be called repeatedly until it returns an error or a result whose Finished
+// field is true.
+func (s *ServerAPI) ProxyResult(id int, reply *types.ProxyStatus) error {
+	logDebugf("ProxyResult(%d) called", id)
+
+	result := s.resultRequest(id)
+
+	select {
+	case s.actionCh <- result:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	r := <-result.res
+	if v, ok := r.(error); ok {
+		logDebugf("ProxyResult(%d) finished: %v", id, v)
+		return v
+	}
+
+	var err error
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case types.ProxyStatus:
+		*reply = res
+		if !res.Finished {
+			return nil
+		}
+	case error:
+		err = res
+	default:
+		err = errors.New("Operation cancelled")
+	}
+
+	select {
+	case s.actionCh <- completeAction(id):
+	case <-s.signalCh:
+	}
+
+	logDebugf("ProxyResult(%d) finished: %v", id, err)
+
+	return err
+}
+
+// Drain asks the daemon to stop accepting new commands and to exit once the
+// commands in progress have completed, cancelling those that are still
+// running after args.Timeout.
+func (s *ServerAPI) Drain(args *types.DrainArgs, id *int) error {
+	logDebugf("Drain %+v called", *args)
+	if err := s.authorize("Drain"); err != nil {
+		return err
+	}
+
+	select {
+	case s.actionCh <- drainAction{timeout: args.Timeout}:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	*id = noTransaction
+	return nil
+}
+
+// DrainResult blocks until the daemon has finished draining.  The daemon
+// exits shortly afterwards.
+func (s *ServerAPI) DrainResult(id int, reply *struct{}) error {
+	logDebugf("DrainResult called")
+
+	select {
+	case <-s.finishedCh:
+	case <-s.signalCh:
+		return errors.New("Operation cancelled")
+	}
+
+	*reply = struct{}{}
+	return nil
+}
+
+// SetLogLevel changes the minimum level of the messages logged by the
+// daemon.  The change lasts until the daemon exits.  SetLogLevel does not
+// start a transaction.  The value pointed to by id is set to -1.
+func (s *ServerAPI) SetLogLevel(args *types.SetLogLevelArgs, id *int) error {
+	logDebugf("SetLogLevel %+v called", *args)
+	if err := s.authorize("SetLogLevel"); err != nil {
+		return err
+	}
+
+	if args.Level != "" {
+		level, err := parseLogLevel(args.Level)
+		if err != nil {
+			return err
+		}
+		daemonSink.setLevel(level)
+		logInfof("Log level set to %s", level)
+	}
+
+	*id = noTransaction
+	return nil
+}
+
+// SetLogLevelResult returns the current log level of the daemon.
+func (s *ServerAPI) SetLogLevelResult(id int, reply *string) error {
+	logDebugf("SetLogLevelResult called")
+
+	*reply = daemonSink.getLevel().String()
+	return nil
+}
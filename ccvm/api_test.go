@@ -57,16 +57,16 @@ func (s *testService) create(ctx context.Context, resultCh chan interface{}, arg
 	}
 }
 
-func (s *testService) stop(ctx context.Context, name string, resultCh chan interface{}) {
+func (s *testService) stop(ctx context.Context, args *types.StopArgs, resultCh chan interface{}) {
 	if s.fail {
-		resultCh <- fmt.Errorf("Stop %s Failed", name)
+		resultCh <- fmt.Errorf("Stop %s Failed", args.Name)
 		return
 	}
 
-	resultCh <- nil
+	resultCh <- types.StopResult{Method: types.StopACPI}
 }
 
-func (s *testService) start(ctx context.Context, name string, args *types.VMSpec, resultCh chan interface{}) {
+func (s *testService) start(ctx context.Context, name string, args *types.VMSpec, force bool, resultCh chan interface{}) {
 	if s.fail {
 		resultCh <- fmt.Errorf("Start %s Failed", name)
 		return
@@ -75,6 +75,15 @@ func (s *testService) start(ctx context.Context, name string, args *types.VMSpec
 	resultCh <- nil
 }
 
+func (s *testService) restart(ctx context.Context, args *types.RestartArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Restart %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- nil
+}
+
 func (s *testService) quit(ctx context.Context, name string, resultCh chan interface{}) {
 	if s.fail {
 		resultCh <- fmt.Errorf("Quit %s Failed", name)
@@ -84,6 +93,167 @@ func (s *testService) quit(ctx context.Context, name string, resultCh chan inter
 	resultCh <- nil
 }
 
+func (s *testService) resize(ctx context.Context, args *types.ResizeArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Resize %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- types.ResizeResult{}
+}
+
+func (s *testService) forward(ctx context.Context, args *types.ForwardArgs, add bool, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Forward %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) mount(ctx context.Context, args *types.MountArgs, add bool, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Mount %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) disk(ctx context.Context, args *types.DiskArgs, attach bool, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Disk %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) fsck(ctx context.Context, args *types.FsckArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Fsck %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- types.FsckResult{}
+}
+
+func (s *testService) flatten(ctx context.Context, name string, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Flatten %s Failed", name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) rename(ctx context.Context, args *types.RenameArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Rename %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) backup(ctx context.Context, args *types.BackupArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Backup %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- types.BackupInfo{Instance: args.Name, ID: "20181010T101010Z"}
+}
+
+func (s *testService) listBackups(ctx context.Context, args *types.BackupListArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("ListBackups %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- types.BackupList{
+		Backups: []types.BackupInfo{{Instance: args.Name, ID: "20181010T101010Z"}},
+	}
+}
+
+func (s *testService) restoreBackup(ctx context.Context, args *types.RestoreArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("RestoreBackup %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) setBackupPolicy(ctx context.Context, args *types.BackupPolicy, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("SetBackupPolicy %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) suspend(ctx context.Context, args *types.SuspendArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Suspend %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- []string{args.Name}
+}
+
+func (s *testService) resumeFromDisk(ctx context.Context, args *types.ResumeArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("ResumeFromDisk %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- []string{args.Name}
+}
+
+func (s *testService) set(ctx context.Context, args *types.SetArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Set %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) kubeconfig(ctx context.Context, name string, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("GetKubeconfig %s Failed", name)
+		return
+	}
+
+	resultCh <- &types.KubeconfigResult{
+		Context:    "ccloudvm-" + name,
+		Kubeconfig: []byte("apiVersion: v1\nkind: Config\n"),
+	}
+}
+
+func (s *testService) syncStatus(ctx context.Context, name string, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("SyncStatus %s Failed", name)
+		return
+	}
+
+	resultCh <- &types.SyncStatusResult{
+		Active: true,
+		Syncs:  []types.SyncStatus{{Source: "/home/user/src", Target: "/home/user/src"}},
+	}
+}
+
+func (s *testService) pauseSync(ctx context.Context, name string, paused bool, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("PauseSync %s Failed", name)
+		return
+	}
+
+	resultCh <- nil
+}
+
 func (s *testService) delete(ctx context.Context, name string, resultCh chan interface{}) {
 	if s.fail {
 		resultCh <- fmt.Errorf("Delete %s Failed", name)
@@ -103,327 +273,2253 @@ func (s *testService) status(ctx context.Context, name string, resultCh chan int
 	}
 }
 
-func (s *testService) getInstances(ctx context.Context, resultCh chan interface{}) {
+func (s *testService) sshKey(ctx context.Context, name string, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("SSH key %s Failed", name)
+		return
+	}
+	resultCh <- types.SSHKeyResult{}
+}
+
+func (s *testService) refreshStatus(ctx context.Context, args *types.RefreshStatusArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("RefreshStatus %v Failed", args.Names)
+		return
+	}
+	resultCh <- types.RefreshStatusResult{
+		Instances: []types.InstanceDetails{{Name: "testInstance"}},
+	}
+}
+
+func (s *testService) getInstances(ctx context.Context, args *types.InstancesArgs, resultCh chan interface{}) {
 	if s.fail {
 		resultCh <- fmt.Errorf("GetInstances Failed")
 		return
 	}
 
-	resultCh <- []string{
-		"vague-nimue",
-		"worred-margawse",
+	resultCh <- []types.InstanceSummary{
+		{Name: "vague-nimue"},
+		{Name: "worred-margawse"},
 	}
 }
 
-func startTestAPIServer(ts *testService, s *ServerAPI, wg *sync.WaitGroup, t *testing.T) {
-	var transWg sync.WaitGroup
-	transactions := make(map[int]testTrans)
-	id := 0
+func (s *testService) createGroup(ctx context.Context, resultCh chan interface{}, args *types.CreateArgs) {
+	s.create(ctx, resultCh, args)
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
+func (s *testService) batch(ctx context.Context, args *types.BatchArgs, allowed func(string) bool,
+	resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Batch %s Failed", args.Action)
+		return
+	}
 
-DONE:
-	for {
-		select {
-		case <-s.signalCh:
-			cancel()
-			break DONE
-		case action := <-s.actionCh:
-			switch action := action.(type) {
-			case startAction:
-				transWg.Add(1)
-				tCtx, tCancel := context.WithCancel(ctx)
-				transactions[id] = testTrans{
-					cancelFn: tCancel,
-					resultCh: make(chan interface{}),
-				}
-				go func(ctx context.Context, tt testTrans) {
-					select {
-					case <-ctx.Done():
-						tt.resultCh <- errCancelled
-					case <-time.After(time.Millisecond * 100):
-						action.action(ctx, ts, tt.resultCh)
-					}
-					tt.cancelFn()
-					transWg.Done()
-				}(tCtx, transactions[id])
-				action.transCh <- id
-				id++
-			case cancelAction:
-				tt, ok := transactions[int(action)]
-				if !ok {
-					t.Errorf("Unknown transaction %d", int(action))
-				} else {
-					tt.cancelFn()
-				}
-			case getResult:
-				tt, ok := transactions[action.ID]
-				if !ok {
-					t.Errorf("Unknown transaction %d", action.ID)
-					action.res <- fmt.Errorf("Unknown transaction %d", action.ID)
-				} else {
-					action.res <- tt.resultCh
-				}
-			case completeAction:
-				_, ok := transactions[int(action)]
-				if !ok {
-					t.Errorf("Unknown transaction %d", int(action))
-				} else {
-					delete(transactions, int(action))
-				}
-			}
-		}
+	resultCh <- types.BatchResult{
+		Instances: []types.BatchInstanceResult{
+			{Name: "test-instance"},
+			{Name: "test-instance-2", Error: "Instance is not running"},
+		},
+	}
+}
+
+func (s *testService) prefetch(ctx context.Context, resultCh chan interface{}, args *types.CreateArgs) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Prefetch %s Failed", args.WorkloadName)
+		return
+	}
+
+	resultCh <- types.CreateResult{
+		Line: "Downloading xenial-server-cloudimg-amd64-disk1.img",
+	}
+
+	resultCh <- types.CreateResult{
+		Finished: true,
+	}
+}
+
+func (s *testService) groupAction(ctx context.Context, name string, action int, allowed func(string) bool,
+	resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Group action %d on %s Failed", action, name)
+		return
+	}
+	if member := name + "-web-1"; !allowed(member) {
+		resultCh <- fmt.Errorf("Access to instance %s of group %s denied", member, name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) watchEvents(ctx context.Context, args *types.WatchEventsArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("WatchEvents %v Failed", args.Names)
+		return
+	}
+
+	resultCh <- types.InstanceEvent{
+		Name: "testInstance",
+		Type: types.EventStarted,
+	}
+	resultCh <- errCancelled
+}
+
+func (s *testService) report(ctx context.Context, args *types.ReportArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Report %v Failed", args.Since)
+		return
+	}
+
+	resultCh <- types.ReportResult{
+		Since: args.Since,
+		Instances: []types.InstanceReport{
+			{Name: "testInstance", Usage: types.ResourceUsage{CPUSeconds: 60}},
+		},
+	}
+}
+
+func (s *testService) preflight(ctx context.Context, args *types.PreflightArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Preflight %s Failed", args.HTTPProxy)
+		return
+	}
+
+	resultCh <- types.PreflightResult{
+		Checks: []types.PreflightCheck{{Name: "KVM", Status: types.PreflightOK}},
+	}
+}
+
+func (s *testService) auditLog(ctx context.Context, args *types.AuditLogArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("GetAuditLog %v Failed", args.Since)
+		return
+	}
+
+	resultCh <- []types.AuditRecord{
+		{Time: args.Since, User: "alice", Operation: "Create", Instance: "testInstance"},
+	}
+}
+
+func (s *testService) consoleLog(ctx context.Context, args *types.ConsoleLogArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("GetConsoleLog %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- types.ConsoleOutput{Data: "login: "}
+	resultCh <- types.ConsoleOutput{Finished: true}
+}
+
+func (s *testService) consoleInput(ctx context.Context, args *types.ConsoleInputArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("SendConsoleInput %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) createNetwork(ctx context.Context, spec *types.NetworkSpec, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("CreateNetwork %s Failed", spec.Name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) deleteNetwork(ctx context.Context, name string, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("DeleteNetwork %s Failed", name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) createVolume(ctx context.Context, spec *types.VolumeSpec, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("CreateVolume %s Failed", spec.Name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) deleteVolume(ctx context.Context, name string, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("DeleteVolume %s Failed", name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) listVolumes(ctx context.Context, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("ListVolumes Failed")
+		return
+	}
+
+	resultCh <- []types.VolumeInfo{
+		{
+			VolumeSpec: types.VolumeSpec{Name: "cache", SizeGiB: 50},
+			Instance:   "testInstance",
+			Disk:       "cache",
+		},
+	}
+}
+
+func (s *testService) listCaches(ctx context.Context, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("ListCaches Failed")
+		return
+	}
+
+	resultCh <- []types.CacheInfo{
+		{
+			Name:      "apt",
+			SizeBytes: 1 << 20,
+			Files:     3,
+			Instances: []string{"testInstance"},
+		},
+	}
+}
+
+func (s *testService) clearCache(ctx context.Context, name string, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("ClearCache %s Failed", name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) updateWorkloads(ctx context.Context, URLs []string, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("UpdateWorkloads Failed")
+		return
+	}
+
+	resultCh <- URLs
+}
+
+func (s *testService) getWorkloads(ctx context.Context, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("GetWorkloads Failed")
+		return
+	}
+
+	resultCh <- []types.WorkloadInfo{{Name: "xenial", Source: "bundled"}}
+}
+
+func (s *testService) showWorkload(ctx context.Context, name string, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("ShowWorkload %s Failed", name)
+		return
+	}
+
+	resultCh <- &types.WorkloadDetails{Name: name, Spec: "vm:\n"}
+}
+
+func (s *testService) validateWorkload(ctx context.Context, args *types.ValidateWorkloadArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("ValidateWorkload %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- []types.WorkloadProblem{{Line: 3, Message: "Invalid"}}
+}
+
+func (s *testService) exportInstance(ctx context.Context, args *types.ExportArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Export %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) buildTemplate(ctx context.Context, args *types.BuildTemplateArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("BuildTemplate %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- &types.TemplateInfo{Name: args.Template}
+}
+
+func (s *testService) importWorkload(ctx context.Context, args *types.ImportArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Import %s Failed", args.Path)
+		return
+	}
+
+	resultCh <- args.Name
+}
+
+func (s *testService) importVagrant(ctx context.Context, args *types.ImportVagrantArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("ImportVagrant %s Failed", args.Path)
+		return
+	}
+
+	resultCh <- &types.ImportVagrantResult{Name: args.Name, Inherits: "xenial"}
+}
+
+func (s *testService) pushWorkload(ctx context.Context, args *types.PushArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Push %s Failed", args.Reference)
+		return
+	}
+
+	resultCh <- nil
+}
+
+func (s *testService) pullWorkload(ctx context.Context, args *types.PullArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Pull %s Failed", args.Reference)
+		return
+	}
+
+	resultCh <- args.Name
+}
+
+func (s *testService) listNetworks(ctx context.Context, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("ListNetworks Failed")
+		return
+	}
+
+	resultCh <- []types.NetworkInfo{
+		{
+			NetworkSpec: types.NetworkSpec{Name: "lab", Subnet: "192.168.50.0/24"},
+			Instances:   []string{"testInstance"},
+		},
+	}
+}
+
+func (s *testService) exec(ctx context.Context, args *types.ExecArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Exec %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- types.ExecOutput{Stdout: "hello\n"}
+	resultCh <- types.ExecOutput{Stderr: "warning\n"}
+	resultCh <- types.ExecOutput{Finished: true, ExitCode: 3}
+}
+
+func (s *testService) proxy(ctx context.Context, args *types.ProxyArgs, resultCh chan interface{}) {
+	if s.fail {
+		resultCh <- fmt.Errorf("Proxy %s Failed", args.Name)
+		return
+	}
+
+	resultCh <- types.ProxyStatus{SOCKS: "127.0.0.1:1080"}
+	resultCh <- types.ProxyStatus{Finished: true}
+}
+
+func startTestAPIServer(ts *testService, s *ServerAPI, wg *sync.WaitGroup, t *testing.T) {
+	var transWg sync.WaitGroup
+	transactions := make(map[int]testTrans)
+	id := 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+DONE:
+	for {
+		select {
+		case <-s.signalCh:
+			cancel()
+			break DONE
+		case action := <-s.actionCh:
+			switch action := action.(type) {
+			case startAction:
+				transWg.Add(1)
+				tCtx, tCancel := context.WithCancel(ctx)
+				transactions[id] = testTrans{
+					cancelFn: tCancel,
+					resultCh: make(chan interface{}),
+				}
+				go func(ctx context.Context, tt testTrans) {
+					select {
+					case <-ctx.Done():
+						tt.resultCh <- errCancelled
+					case <-time.After(time.Millisecond * 100):
+						action.action(ctx, ts, tt.resultCh)
+					}
+					tt.cancelFn()
+					transWg.Done()
+				}(tCtx, transactions[id])
+				action.transCh <- id
+				id++
+			case cancelAction:
+				tt, ok := transactions[action.ID]
+				if !ok {
+					t.Errorf("Unknown transaction %d", action.ID)
+				} else {
+					tt.cancelFn()
+				}
+			case getResult:
+				tt, ok := transactions[action.ID]
+				if !ok {
+					t.Errorf("Unknown transaction %d", action.ID)
+					action.res <- fmt.Errorf("Unknown transaction %d", action.ID)
+				} else {
+					action.res <- tt.resultCh
+				}
+			case completeAction:
+				_, ok := transactions[int(action)]
+				if !ok {
+					t.Errorf("Unknown transaction %d", int(action))
+				} else {
+					delete(transactions, int(action))
+				}
+			}
+		}
+	}
+
+	if len(transactions) != 0 {
+		t.Errorf("Transactions remaining %d", len(transactions))
+	}
+
+	transWg.Wait()
+	wg.Done()
+}
+
+func testCreate(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Create(&types.CreateArgs{}, &id)
+	if err != nil {
+		t.Errorf("Failed to Create instance %v", err)
+		return
+	}
+
+	for {
+		var res types.CreateResult
+		if err := api.CreateResult(id, &res); err != nil {
+			t.Errorf("CreateResult failed %v", err)
+			break
+		}
+
+		if res.Finished {
+			break
+		}
+	}
+}
+
+func testPrefetch(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Prefetch(&types.CreateArgs{WorkloadName: "xenial"}, &id)
+	if err != nil {
+		t.Errorf("Failed to Prefetch workload %v", err)
+		return
+	}
+
+	for {
+		var res types.CreateResult
+		if err := api.PrefetchResult(id, &res); err != nil {
+			t.Errorf("PrefetchResult failed %v", err)
+			break
+		}
+
+		if res.Finished {
+			break
+		}
+	}
+}
+
+func testPrefetchFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Prefetch(&types.CreateArgs{WorkloadName: "xenial"}, &id)
+	if err != nil {
+		t.Errorf("Failed to Prefetch workload %v", err)
+		return
+	}
+
+	var res types.CreateResult
+	if err := api.PrefetchResult(id, &res); err == nil {
+		t.Errorf("PrefetchResult expected to fail")
+	}
+}
+
+func testBatch(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Batch(&types.BatchArgs{Action: types.BatchStop, All: true}, &id)
+	if err != nil {
+		t.Errorf("Failed to start batch %v", err)
+		return
+	}
+
+	var res types.BatchResult
+	if err := api.BatchResult(id, &res); err != nil {
+		t.Errorf("BatchResult failed %v", err)
+	}
+	if len(res.Instances) != 2 || res.Instances[1].Error == "" {
+		t.Errorf("Unexpected batch result %+v", res)
+	}
+
+	if err := api.Batch(&types.BatchArgs{Action: "reboot", All: true}, &id); err == nil {
+		t.Errorf("Batch with unknown action expected to fail")
+	}
+}
+
+func testBatchFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Batch(&types.BatchArgs{Action: types.BatchDelete, Names: []string{"test-*"}}, &id)
+	if err != nil {
+		t.Errorf("Failed to start batch %v", err)
+		return
+	}
+
+	var res types.BatchResult
+	if err := api.BatchResult(id, &res); err == nil {
+		t.Errorf("BatchResult expected to fail")
+	}
+}
+
+func testDelete(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Delete("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to Delete instance %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.DeleteResult(id, &res); err != nil {
+		t.Errorf("DeleteResult failed %v", err)
+	}
+}
+
+func testStop(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Stop(&types.StopArgs{Name: "test-instance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to Stop instance %v", err)
+		return
+	}
+
+	var res types.StopResult
+	if err := api.StopResult(id, &res); err != nil {
+		t.Errorf("StopResult failed %v", err)
+	}
+}
+
+func testQuit(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Quit("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to Quit instance %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.QuitResult(id, &res); err != nil {
+		t.Errorf("QuitResult failed %v", err)
+	}
+}
+
+func testFlatten(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Flatten("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to Flatten instance %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.FlattenResult(id, &res); err != nil {
+		t.Errorf("FlattenResult failed %v", err)
+	}
+}
+
+func testRename(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Rename(&types.RenameArgs{Name: "test-instance", NewName: "new-instance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to Rename instance %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.RenameResult(id, &res); err != nil {
+		t.Errorf("RenameResult failed %v", err)
+	}
+}
+
+func testBackup(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Backup(&types.BackupArgs{Name: "test-instance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to back up instance %v", err)
+		return
+	}
+	var info types.BackupInfo
+	if err := api.BackupResult(id, &info); err != nil {
+		t.Errorf("BackupResult failed %v", err)
+	} else if info.ID != "20181010T101010Z" {
+		t.Errorf("Unexpected backup %+v", info)
+	}
+
+	err = api.ListBackups(&types.BackupListArgs{Name: "test-instance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to list backups %v", err)
+		return
+	}
+	var list types.BackupList
+	if err := api.ListBackupsResult(id, &list); err != nil {
+		t.Errorf("ListBackupsResult failed %v", err)
+	} else if len(list.Backups) != 1 {
+		t.Errorf("Unexpected backups %+v", list)
+	}
+
+	err = api.RestoreBackup(&types.RestoreArgs{Name: "test-instance", ID: info.ID}, &id)
+	if err != nil {
+		t.Errorf("Failed to restore backup %v", err)
+		return
+	}
+	if err := api.RestoreBackupResult(id, &struct{}{}); err != nil {
+		t.Errorf("RestoreBackupResult failed %v", err)
+	}
+
+	err = api.SetBackupPolicy(&types.BackupPolicy{Name: "test-instance", Interval: "daily"}, &id)
+	if err != nil {
+		t.Errorf("Failed to set backup policy %v", err)
+		return
+	}
+	if err := api.SetBackupPolicyResult(id, &struct{}{}); err != nil {
+		t.Errorf("SetBackupPolicyResult failed %v", err)
+	}
+}
+
+func testSuspend(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Suspend(&types.SuspendArgs{Name: "test-instance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to suspend instance %v", err)
+		return
+	}
+	var names []string
+	if err := api.SuspendResult(id, &names); err != nil {
+		t.Errorf("SuspendResult failed %v", err)
+	} else if len(names) != 1 || names[0] != "test-instance" {
+		t.Errorf("Unexpected suspended instances %v", names)
+	}
+
+	err = api.ResumeFromDisk(&types.ResumeArgs{Name: "test-instance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to resume instance %v", err)
+		return
+	}
+	if err := api.ResumeFromDiskResult(id, &names); err != nil {
+		t.Errorf("ResumeFromDiskResult failed %v", err)
+	} else if len(names) != 1 || names[0] != "test-instance" {
+		t.Errorf("Unexpected resumed instances %v", names)
+	}
+}
+
+func testSet(t *testing.T, api *ServerAPI) {
+	var id int
+	args := &types.SetArgs{
+		Name:     "test-instance",
+		Settings: map[string]string{"autostart": "on"},
+	}
+	err := api.Set(args, &id)
+	if err != nil {
+		t.Errorf("Failed to change settings %v", err)
+		return
+	}
+	if err := api.SetResult(id, &struct{}{}); err != nil {
+		t.Errorf("SetResult failed %v", err)
+	}
+}
+
+func testGetKubeconfig(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.GetKubeconfig("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve kubeconfig %v", err)
+		return
+	}
+	var res types.KubeconfigResult
+	if err := api.GetKubeconfigResult(id, &res); err != nil {
+		t.Errorf("GetKubeconfigResult failed %v", err)
+	} else if res.Context != "ccloudvm-test-instance" || len(res.Kubeconfig) == 0 {
+		t.Errorf("Unexpected kubeconfig %+v", res)
+	}
+}
+
+func testSyncStatus(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.SyncStatus("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve sync status %v", err)
+		return
+	}
+	var res types.SyncStatusResult
+	if err := api.SyncStatusResult(id, &res); err != nil {
+		t.Errorf("SyncStatusResult failed %v", err)
+	} else if !res.Active || len(res.Syncs) != 1 {
+		t.Errorf("Unexpected sync status %+v", res)
+	}
+}
+
+func testPauseSync(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.PauseSync("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to pause syncs %v", err)
+		return
+	}
+	if err := api.PauseSyncResult(id, &struct{}{}); err != nil {
+		t.Errorf("PauseSyncResult failed %v", err)
+	}
+}
+
+func testResumeSync(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.ResumeSync("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to resume syncs %v", err)
+		return
+	}
+	if err := api.ResumeSyncResult(id, &struct{}{}); err != nil {
+		t.Errorf("ResumeSyncResult failed %v", err)
+	}
+}
+
+func testStart(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Start(&types.StartArgs{}, &id)
+	if err != nil {
+		t.Errorf("Failed to Start instance %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.StartResult(id, &res); err != nil {
+		t.Errorf("StartResult failed %v", err)
+	}
+}
+
+func testRestart(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Restart(&types.RestartArgs{
+		Name:   "test-instance",
+		VMSpec: types.VMSpec{Kernel: "/home/user/linux/arch/x86/boot/bzImage"},
+	}, &id)
+	if err != nil {
+		t.Errorf("Failed to Restart instance %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.RestartResult(id, &res); err != nil {
+		t.Errorf("RestartResult failed %v", err)
+	}
+}
+
+func testResize(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Resize(&types.ResizeArgs{Name: "test-instance", CPUs: 2}, &id)
+	if err != nil {
+		t.Errorf("Failed to Resize instance %v", err)
+		return
+	}
+
+	var res types.ResizeResult
+	if err := api.ResizeResult(id, &res); err != nil {
+		t.Errorf("ResizeResult failed %v", err)
+	}
+}
+
+func testForward(t *testing.T, api *ServerAPI) {
+	args := &types.ForwardArgs{
+		Name:    "test-instance",
+		Mapping: types.PortMapping{Host: 10080, Guest: 80},
+	}
+
+	var id int
+	err := api.AddForward(args, &id)
+	if err != nil {
+		t.Errorf("Failed to add port mapping %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.AddForwardResult(id, &res); err != nil {
+		t.Errorf("AddForwardResult failed %v", err)
+	}
+
+	err = api.RemoveForward(args, &id)
+	if err != nil {
+		t.Errorf("Failed to remove port mapping %v", err)
+		return
+	}
+
+	if err := api.RemoveForwardResult(id, &res); err != nil {
+		t.Errorf("RemoveForwardResult failed %v", err)
+	}
+}
+
+func testFsck(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Fsck(&types.FsckArgs{Name: "test-instance", Repair: true}, &id)
+	if err != nil {
+		t.Errorf("Failed to check instance %v", err)
+		return
+	}
+
+	var res types.FsckResult
+	if err := api.FsckResult(id, &res); err != nil {
+		t.Errorf("FsckResult failed %v", err)
+	}
+}
+
+func testMount(t *testing.T, api *ServerAPI) {
+	args := &types.MountArgs{
+		Name:  "test-instance",
+		Mount: types.Mount{Tag: "docs", Path: "/tmp"},
+	}
+
+	var id int
+	err := api.Mount(args, &id)
+	if err != nil {
+		t.Errorf("Failed to add mount %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.MountResult(id, &res); err != nil {
+		t.Errorf("MountResult failed %v", err)
+	}
+
+	err = api.Unmount(args, &id)
+	if err != nil {
+		t.Errorf("Failed to remove mount %v", err)
+		return
+	}
+
+	if err := api.UnmountResult(id, &res); err != nil {
+		t.Errorf("UnmountResult failed %v", err)
+	}
+}
+
+func testDisk(t *testing.T, api *ServerAPI) {
+	args := &types.DiskArgs{
+		Name: "test-instance",
+		Disk: types.Disk{Name: "data", SizeGiB: 50},
+	}
+
+	var id int
+	err := api.AttachDisk(args, &id)
+	if err != nil {
+		t.Errorf("Failed to attach disk %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.AttachDiskResult(id, &res); err != nil {
+		t.Errorf("AttachDiskResult failed %v", err)
+	}
+
+	err = api.DetachDisk(args, &id)
+	if err != nil {
+		t.Errorf("Failed to detach disk %v", err)
+		return
+	}
+
+	if err := api.DetachDiskResult(id, &res); err != nil {
+		t.Errorf("DetachDiskResult failed %v", err)
+	}
+}
+
+func testGetInstanceDetails(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.GetInstanceDetails("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve instance details %v", err)
+		return
+	}
+
+	if err := api.GetInstanceDetailsResult(id, &types.InstanceDetails{}); err != nil {
+		t.Errorf("GetInstanceDetailsResult failed %v", err)
+	}
+}
+
+func testGetSSHKey(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.GetSSHKey("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve SSH key %v", err)
+		return
+	}
+
+	if err := api.GetSSHKeyResult(id, &types.SSHKeyResult{}); err != nil {
+		t.Errorf("GetSSHKeyResult failed %v", err)
+	}
+}
+
+func testRefreshStatus(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.RefreshStatus(&types.RefreshStatusArgs{Names: []string{"test-instance"}}, &id)
+	if err != nil {
+		t.Errorf("Failed to refresh instance status %v", err)
+		return
+	}
+
+	var res types.RefreshStatusResult
+	if err := api.RefreshStatusResult(id, &res); err != nil {
+		t.Errorf("RefreshStatusResult failed %v", err)
+	}
+}
+
+func testGetInstances(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.GetInstances(&types.InstancesArgs{}, &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve instances %v", err)
+		return
+	}
+
+	if err := api.GetInstancesResult(id, &[]types.InstanceSummary{}); err != nil {
+		t.Errorf("GetInstancesResult failed %v", err)
+	}
+}
+
+func testCreateGroup(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.CreateGroup(&types.CreateArgs{Name: "test-group"}, &id)
+	if err != nil {
+		t.Errorf("Failed to Create group %v", err)
+		return
+	}
+
+	for {
+		var res types.CreateResult
+		if err := api.CreateGroupResult(id, &res); err != nil {
+			t.Errorf("CreateGroupResult failed %v", err)
+			break
+		}
+
+		if res.Finished {
+			break
+		}
+	}
+}
+
+type groupActionFns struct {
+	name   string
+	action func(*types.GroupArgs, *int) error
+	result func(int, *struct{}) error
+}
+
+func groupActions(api *ServerAPI) []groupActionFns {
+	return []groupActionFns{
+		{"StartGroup", api.StartGroup, api.StartGroupResult},
+		{"StopGroup", api.StopGroup, api.StopGroupResult},
+		{"QuitGroup", api.QuitGroup, api.QuitGroupResult},
+		{"DeleteGroup", api.DeleteGroup, api.DeleteGroupResult},
+	}
+}
+
+func testGroupActions(t *testing.T, api *ServerAPI) {
+	for _, ga := range groupActions(api) {
+		var id int
+		err := ga.action(&types.GroupArgs{Name: "test-group"}, &id)
+		if err != nil {
+			t.Errorf("%s failed %v", ga.name, err)
+			continue
+		}
+
+		var res struct{}
+		if err := ga.result(id, &res); err != nil {
+			t.Errorf("%sResult failed %v", ga.name, err)
+		}
+	}
+}
+
+func testWatchEvents(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.WatchEvents(&types.WatchEventsArgs{}, &id)
+	if err != nil {
+		t.Errorf("Failed to watch events %v", err)
+		return
+	}
+
+	var event types.InstanceEvent
+	if err := api.WatchEventsResult(id, &event); err != nil {
+		t.Errorf("WatchEventsResult failed %v", err)
+		return
+	}
+	if event.Type != types.EventStarted {
+		t.Errorf("Unexpected event %v", event)
+	}
+
+	if err := api.WatchEventsResult(id, &event); err == nil {
+		t.Errorf("WatchEventsResult expected to fail once cancelled")
+	}
+}
+
+func testReport(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Report(&types.ReportArgs{Since: time.Now()}, &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve report %v", err)
+		return
+	}
+
+	var res types.ReportResult
+	if err := api.ReportResult(id, &res); err != nil {
+		t.Errorf("ReportResult failed %v", err)
+	} else if len(res.Instances) != 1 {
+		t.Errorf("Expected 1 instance, got %v", res.Instances)
+	}
+}
+
+func testPreflight(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Preflight(&types.PreflightArgs{}, &id)
+	if err != nil {
+		t.Errorf("Failed to run preflight checks %v", err)
+		return
+	}
+
+	var res types.PreflightResult
+	if err := api.PreflightResult(id, &res); err != nil {
+		t.Errorf("PreflightResult failed %v", err)
+	} else if len(res.Checks) != 1 {
+		t.Errorf("Expected 1 check, got %v", res.Checks)
+	}
+}
+
+func testGetAuditLog(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.GetAuditLog(&types.AuditLogArgs{Since: time.Now()}, &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve audit log %v", err)
+		return
+	}
+
+	var records []types.AuditRecord
+	if err := api.GetAuditLogResult(id, &records); err != nil {
+		t.Errorf("GetAuditLogResult failed %v", err)
+	} else if len(records) != 1 {
+		t.Errorf("Expected 1 record, got %v", records)
+	}
+}
+
+func testConsoleLog(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.GetConsoleLog(&types.ConsoleLogArgs{Name: "testInstance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve console log %v", err)
+		return
+	}
+
+	var out types.ConsoleOutput
+	if err := api.GetConsoleLogResult(id, &out); err != nil {
+		t.Errorf("GetConsoleLogResult failed %v", err)
+		return
+	}
+	if out.Data != "login: " || out.Finished {
+		t.Errorf("Unexpected console output %+v", out)
+	}
+
+	if err := api.GetConsoleLogResult(id, &out); err != nil {
+		t.Errorf("GetConsoleLogResult failed %v", err)
+	} else if !out.Finished {
+		t.Errorf("Expected console output to be finished")
+	}
+}
+
+func testConsoleInput(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.SendConsoleInput(&types.ConsoleInputArgs{Name: "testInstance", Data: "root\r"}, &id)
+	if err != nil {
+		t.Errorf("Failed to send console input %v", err)
+		return
+	}
+
+	if err := api.SendConsoleInputResult(id, &struct{}{}); err != nil {
+		t.Errorf("SendConsoleInputResult failed %v", err)
+	}
+}
+
+func testExec(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Exec(&types.ExecArgs{Name: "testInstance", Command: "echo hello"}, &id)
+	if err != nil {
+		t.Errorf("Failed to execute command %v", err)
+		return
+	}
+
+	var stdout, stderr string
+	for {
+		var out types.ExecOutput
+		if err := api.ExecResult(id, &out); err != nil {
+			t.Errorf("ExecResult failed %v", err)
+			return
+		}
+		stdout += out.Stdout
+		stderr += out.Stderr
+		if out.Finished {
+			if out.ExitCode != 3 {
+				t.Errorf("Unexpected exit code %d", out.ExitCode)
+			}
+			break
+		}
+	}
+
+	if stdout != "hello\n" || stderr != "warning\n" {
+		t.Errorf("Unexpected output %q %q", stdout, stderr)
+	}
+}
+
+func testProxy(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Proxy(&types.ProxyArgs{Name: "testInstance", SOCKSPort: 1080}, &id)
+	if err != nil {
+		t.Errorf("Failed to start proxy %v", err)
+		return
+	}
+
+	var status types.ProxyStatus
+	if err := api.ProxyResult(id, &status); err != nil {
+		t.Errorf("ProxyResult failed %v", err)
+		return
+	}
+	if status.SOCKS != "127.0.0.1:1080" || status.Finished {
+		t.Errorf("Unexpected proxy status %+v", status)
+	}
+	if err := api.ProxyResult(id, &status); err != nil {
+		t.Errorf("ProxyResult failed %v", err)
+	} else if !status.Finished {
+		t.Errorf("Proxy expected to finish")
+	}
+}
+
+func testNetworks(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.CreateNetwork(&types.NetworkSpec{Name: "lab", Subnet: "192.168.50.0/24"}, &id)
+	if err != nil {
+		t.Errorf("Failed to create network %v", err)
+		return
+	}
+	if err := api.CreateNetworkResult(id, &struct{}{}); err != nil {
+		t.Errorf("CreateNetworkResult failed %v", err)
+	}
+
+	err = api.ListNetworks(struct{}{}, &id)
+	if err != nil {
+		t.Errorf("Failed to list networks %v", err)
+		return
+	}
+	var networks []types.NetworkInfo
+	if err := api.ListNetworksResult(id, &networks); err != nil {
+		t.Errorf("ListNetworksResult failed %v", err)
+	} else if len(networks) != 1 || networks[0].Name != "lab" ||
+		len(networks[0].Instances) != 1 {
+		t.Errorf("Unexpected networks %+v", networks)
+	}
+
+	err = api.DeleteNetwork("lab", &id)
+	if err != nil {
+		t.Errorf("Failed to delete network %v", err)
+		return
+	}
+	if err := api.DeleteNetworkResult(id, &struct{}{}); err != nil {
+		t.Errorf("DeleteNetworkResult failed %v", err)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	var wg sync.WaitGroup
+	api := &ServerAPI{
+		signalCh: make(chan os.Signal),
+		actionCh: make(chan interface{}),
+	}
+	ts := &testService{}
+
+	wg.Add(1)
+	go startTestAPIServer(ts, api, &wg, t)
+
+	t.Run("create", func(t *testing.T) {
+		testCreate(t, api)
+	})
+	t.Run("delete", func(t *testing.T) {
+		testDelete(t, api)
+	})
+	t.Run("quit", func(t *testing.T) {
+		testQuit(t, api)
+	})
+	t.Run("flatten", func(t *testing.T) {
+		testFlatten(t, api)
+	})
+	t.Run("rename", func(t *testing.T) {
+		testRename(t, api)
+	})
+	t.Run("backup", func(t *testing.T) {
+		testBackup(t, api)
+	})
+	t.Run("suspend", func(t *testing.T) {
+		testSuspend(t, api)
+	})
+	t.Run("set", func(t *testing.T) {
+		testSet(t, api)
+	})
+	t.Run("syncStatus", func(t *testing.T) {
+		testSyncStatus(t, api)
+	})
+	t.Run("getKubeconfig", func(t *testing.T) {
+		testGetKubeconfig(t, api)
+	})
+	t.Run("pauseSync", func(t *testing.T) {
+		testPauseSync(t, api)
+	})
+	t.Run("resumeSync", func(t *testing.T) {
+		testResumeSync(t, api)
+	})
+	t.Run("stop", func(t *testing.T) {
+		testStop(t, api)
+	})
+	t.Run("start", func(t *testing.T) {
+		testStart(t, api)
+	})
+	t.Run("restart", func(t *testing.T) {
+		testRestart(t, api)
+	})
+	t.Run("resize", func(t *testing.T) {
+		testResize(t, api)
+	})
+	t.Run("forward", func(t *testing.T) {
+		testForward(t, api)
+	})
+	t.Run("mount", func(t *testing.T) {
+		testMount(t, api)
+	})
+	t.Run("disk", func(t *testing.T) {
+		testDisk(t, api)
+	})
+	t.Run("fsck", func(t *testing.T) {
+		testFsck(t, api)
+	})
+	t.Run("getinstancedetails", func(t *testing.T) {
+		testGetInstanceDetails(t, api)
+	})
+	t.Run("getsshkey", func(t *testing.T) {
+		testGetSSHKey(t, api)
+	})
+	t.Run("refreshstatus", func(t *testing.T) {
+		testRefreshStatus(t, api)
+	})
+	t.Run("creategroup", func(t *testing.T) {
+		testCreateGroup(t, api)
+	})
+	t.Run("groupactions", func(t *testing.T) {
+		testGroupActions(t, api)
+	})
+	t.Run("watchevents", func(t *testing.T) {
+		testWatchEvents(t, api)
+	})
+	t.Run("report", func(t *testing.T) {
+		testReport(t, api)
+	})
+	t.Run("getauditlog", func(t *testing.T) {
+		testGetAuditLog(t, api)
+	})
+	t.Run("preflight", func(t *testing.T) {
+		testPreflight(t, api)
+	})
+	t.Run("consolelog", func(t *testing.T) {
+		testConsoleLog(t, api)
+	})
+	t.Run("consoleinput", func(t *testing.T) {
+		testConsoleInput(t, api)
+	})
+	t.Run("networks", func(t *testing.T) {
+		testNetworks(t, api)
+	})
+	t.Run("volumes", func(t *testing.T) {
+		testVolumes(t, api)
+	})
+	t.Run("caches", func(t *testing.T) {
+		testCaches(t, api)
+	})
+	t.Run("prefetch", func(t *testing.T) {
+		testPrefetch(t, api)
+	})
+	t.Run("batch", func(t *testing.T) {
+		testBatch(t, api)
+	})
+	t.Run("workloads", func(t *testing.T) {
+		testUpdateWorkloads(t, api)
+		testWorkloads(t, api)
+	})
+	t.Run("export", func(t *testing.T) {
+		testExport(t, api)
+	})
+	t.Run("registry", func(t *testing.T) {
+		testRegistry(t, api)
+	})
+	t.Run("exec", func(t *testing.T) {
+		testExec(t, api)
+	})
+	t.Run("proxy", func(t *testing.T) {
+		testProxy(t, api)
+	})
+	t.Run("getinstances", func(t *testing.T) {
+		testGetInstances(t, api)
+	})
+
+	close(api.signalCh)
+
+	wg.Wait()
+}
+
+func testCreateFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Create(&types.CreateArgs{}, &id)
+	if err != nil {
+		t.Errorf("Failed to Create instance %v", err)
+		return
+	}
+
+	for {
+		var res types.CreateResult
+		if err := api.CreateResult(id, &res); err == nil {
+			t.Errorf("CreateResult expected to fail")
+			if res.Finished {
+				break
+			}
+		} else {
+			break
+		}
+	}
+}
+
+func testDeleteFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Delete("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to Delete instance %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.DeleteResult(id, &res); err == nil {
+		t.Errorf("DeleteResult expected to fail")
+	}
+}
+
+func testStopFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Stop(&types.StopArgs{Name: "test-instance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to Stop instance %v", err)
+		return
+	}
+
+	var res types.StopResult
+	if err := api.StopResult(id, &res); err == nil {
+		t.Errorf("StopResult expected to fail")
+	}
+}
+
+func testQuitFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Quit("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to Quit instance %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.QuitResult(id, &res); err == nil {
+		t.Errorf("QuitResult expected to fail")
+	}
+}
+
+func testFlattenFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Flatten("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to Flatten instance %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.FlattenResult(id, &res); err == nil {
+		t.Errorf("FlattenResult expected to fail")
+	}
+}
+
+func testRenameFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Rename(&types.RenameArgs{Name: "test-instance", NewName: "new-instance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to Rename instance %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.RenameResult(id, &res); err == nil {
+		t.Errorf("RenameResult expected to fail")
+	}
+}
+
+func testBackupFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Backup(&types.BackupArgs{Name: "test-instance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to back up instance %v", err)
+		return
+	}
+	var info types.BackupInfo
+	if err := api.BackupResult(id, &info); err == nil {
+		t.Errorf("BackupResult expected to fail")
+	}
+
+	err = api.ListBackups(&types.BackupListArgs{Name: "test-instance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to list backups %v", err)
+		return
+	}
+	var list types.BackupList
+	if err := api.ListBackupsResult(id, &list); err == nil {
+		t.Errorf("ListBackupsResult expected to fail")
+	}
+
+	err = api.RestoreBackup(&types.RestoreArgs{Name: "test-instance", ID: "20181010T101010Z"}, &id)
+	if err != nil {
+		t.Errorf("Failed to restore backup %v", err)
+		return
+	}
+	if err := api.RestoreBackupResult(id, &struct{}{}); err == nil {
+		t.Errorf("RestoreBackupResult expected to fail")
+	}
+
+	err = api.SetBackupPolicy(&types.BackupPolicy{Name: "test-instance", Interval: "daily"}, &id)
+	if err != nil {
+		t.Errorf("Failed to set backup policy %v", err)
+		return
+	}
+	if err := api.SetBackupPolicyResult(id, &struct{}{}); err == nil {
+		t.Errorf("SetBackupPolicyResult expected to fail")
+	}
+}
+
+func testSuspendFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Suspend(&types.SuspendArgs{Name: "test-instance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to suspend instance %v", err)
+		return
+	}
+	var names []string
+	if err := api.SuspendResult(id, &names); err == nil {
+		t.Errorf("SuspendResult expected to fail")
+	}
+
+	err = api.ResumeFromDisk(&types.ResumeArgs{Name: "test-instance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to resume instance %v", err)
+		return
+	}
+	if err := api.ResumeFromDiskResult(id, &names); err == nil {
+		t.Errorf("ResumeFromDiskResult expected to fail")
+	}
+}
+
+func testSetFail(t *testing.T, api *ServerAPI) {
+	var id int
+	args := &types.SetArgs{
+		Name:     "test-instance",
+		Settings: map[string]string{"autostart": "on"},
+	}
+	err := api.Set(args, &id)
+	if err != nil {
+		t.Errorf("Failed to change settings %v", err)
+		return
+	}
+	if err := api.SetResult(id, &struct{}{}); err == nil {
+		t.Errorf("SetResult expected to fail")
+	}
+}
+
+func testGetKubeconfigFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.GetKubeconfig("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve kubeconfig %v", err)
+		return
+	}
+	if err := api.GetKubeconfigResult(id, &types.KubeconfigResult{}); err == nil {
+		t.Errorf("GetKubeconfigResult expected to fail")
+	}
+}
+
+func testSyncStatusFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.SyncStatus("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve sync status %v", err)
+		return
+	}
+	if err := api.SyncStatusResult(id, &types.SyncStatusResult{}); err == nil {
+		t.Errorf("SyncStatusResult expected to fail")
+	}
+}
+
+func testPauseSyncFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.PauseSync("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to pause syncs %v", err)
+		return
+	}
+	if err := api.PauseSyncResult(id, &struct{}{}); err == nil {
+		t.Errorf("PauseSyncResult expected to fail")
+	}
+}
+
+func testResumeSyncFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.ResumeSync("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to resume syncs %v", err)
+		return
+	}
+	if err := api.ResumeSyncResult(id, &struct{}{}); err == nil {
+		t.Errorf("ResumeSyncResult expected to fail")
+	}
+}
+
+func testStartFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Start(&types.StartArgs{}, &id)
+	if err != nil {
+		t.Errorf("Failed to Start instance %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.StartResult(id, &res); err == nil {
+		t.Errorf("StartResult expected to fail")
+	}
+}
+
+func testRestartFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Restart(&types.RestartArgs{Name: "test-instance"}, &id)
+	if err != nil {
+		t.Errorf("Failed to Restart instance %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.RestartResult(id, &res); err == nil {
+		t.Errorf("RestartResult expected to fail")
+	}
+}
+
+func testResizeFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Resize(&types.ResizeArgs{Name: "test-instance", CPUs: 2}, &id)
+	if err != nil {
+		t.Errorf("Failed to Resize instance %v", err)
+		return
+	}
+
+	var res types.ResizeResult
+	if err := api.ResizeResult(id, &res); err == nil {
+		t.Errorf("ResizeResult expected to fail")
+	}
+}
+
+func testForwardFail(t *testing.T, api *ServerAPI) {
+	args := &types.ForwardArgs{
+		Name:    "test-instance",
+		Mapping: types.PortMapping{Host: 10080, Guest: 80},
+	}
+
+	var id int
+	err := api.AddForward(args, &id)
+	if err != nil {
+		t.Errorf("Failed to add port mapping %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.AddForwardResult(id, &res); err == nil {
+		t.Errorf("AddForwardResult expected to fail")
+	}
+
+	err = api.RemoveForward(args, &id)
+	if err != nil {
+		t.Errorf("Failed to remove port mapping %v", err)
+		return
+	}
+
+	if err := api.RemoveForwardResult(id, &res); err == nil {
+		t.Errorf("RemoveForwardResult expected to fail")
+	}
+}
+
+func testFsckFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Fsck(&types.FsckArgs{Name: "test-instance", Repair: true}, &id)
+	if err != nil {
+		t.Errorf("Failed to check instance %v", err)
+		return
+	}
+
+	var res types.FsckResult
+	if err := api.FsckResult(id, &res); err == nil {
+		t.Errorf("FsckResult expected to fail")
+	}
+}
+
+func testMountFail(t *testing.T, api *ServerAPI) {
+	args := &types.MountArgs{
+		Name:  "test-instance",
+		Mount: types.Mount{Tag: "docs", Path: "/tmp"},
+	}
+
+	var id int
+	err := api.Mount(args, &id)
+	if err != nil {
+		t.Errorf("Failed to add mount %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.MountResult(id, &res); err == nil {
+		t.Errorf("MountResult expected to fail")
+	}
+
+	err = api.Unmount(args, &id)
+	if err != nil {
+		t.Errorf("Failed to remove mount %v", err)
+		return
+	}
+
+	if err := api.UnmountResult(id, &res); err == nil {
+		t.Errorf("UnmountResult expected to fail")
+	}
+}
+
+func testDiskFail(t *testing.T, api *ServerAPI) {
+	args := &types.DiskArgs{
+		Name: "test-instance",
+		Disk: types.Disk{Name: "data", SizeGiB: 50},
+	}
+
+	var id int
+	err := api.AttachDisk(args, &id)
+	if err != nil {
+		t.Errorf("Failed to attach disk %v", err)
+		return
+	}
+
+	var res types.CommandResult
+	if err := api.AttachDiskResult(id, &res); err == nil {
+		t.Errorf("AttachDiskResult expected to fail")
+	}
+
+	err = api.DetachDisk(args, &id)
+	if err != nil {
+		t.Errorf("Failed to detach disk %v", err)
+		return
+	}
+
+	if err := api.DetachDiskResult(id, &res); err == nil {
+		t.Errorf("DetachDiskResult expected to fail")
+	}
+}
+
+func testGetInstanceDetailsFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.GetInstanceDetails("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve instance details %v", err)
+		return
+	}
+
+	if err := api.GetInstanceDetailsResult(id, &types.InstanceDetails{}); err == nil {
+		t.Errorf("GetInstanceDetailsResult expected to fail")
+	}
+}
+
+func testGetSSHKeyFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.GetSSHKey("test-instance", &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve SSH key %v", err)
+		return
+	}
+
+	if err := api.GetSSHKeyResult(id, &types.SSHKeyResult{}); err == nil {
+		t.Errorf("GetSSHKeyResult expected to fail")
+	}
+}
+
+func testRefreshStatusFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.RefreshStatus(&types.RefreshStatusArgs{Names: []string{"test-instance"}}, &id)
+	if err != nil {
+		t.Errorf("Failed to refresh instance status %v", err)
+		return
+	}
+
+	var res types.RefreshStatusResult
+	if err := api.RefreshStatusResult(id, &res); err == nil {
+		t.Errorf("RefreshStatusResult expected to fail")
+	}
+}
+
+func testGetInstancesFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.GetInstances(&types.InstancesArgs{}, &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve instances %v", err)
+		return
+	}
+
+	if err := api.GetInstancesResult(id, &[]types.InstanceSummary{}); err == nil {
+		t.Errorf("GetInstancesResult expected to fail")
+	}
+}
+
+func testCreateGroupFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.CreateGroup(&types.CreateArgs{Name: "test-group"}, &id)
+	if err != nil {
+		t.Errorf("Failed to Create group %v", err)
+		return
+	}
+
+	for {
+		var res types.CreateResult
+		if err := api.CreateGroupResult(id, &res); err == nil {
+			t.Errorf("CreateGroupResult expected to fail")
+			if res.Finished {
+				break
+			}
+		} else {
+			break
+		}
+	}
+}
+
+func testGroupActionsFail(t *testing.T, api *ServerAPI) {
+	for _, ga := range groupActions(api) {
+		var id int
+		err := ga.action(&types.GroupArgs{Name: "test-group"}, &id)
+		if err != nil {
+			t.Errorf("%s failed %v", ga.name, err)
+			continue
+		}
+
+		var res struct{}
+		if err := ga.result(id, &res); err == nil {
+			t.Errorf("%sResult expected to fail", ga.name)
+		}
+	}
+}
+
+func testWatchEventsFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.WatchEvents(&types.WatchEventsArgs{}, &id)
+	if err != nil {
+		t.Errorf("Failed to watch events %v", err)
+		return
+	}
+
+	var event types.InstanceEvent
+	if err := api.WatchEventsResult(id, &event); err == nil {
+		t.Errorf("WatchEventsResult expected to fail")
+	}
+}
+
+func testReportFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Report(&types.ReportArgs{Since: time.Now()}, &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve report %v", err)
+		return
+	}
+
+	var res types.ReportResult
+	if err := api.ReportResult(id, &res); err == nil {
+		t.Errorf("ReportResult expected to fail")
+	}
+}
+
+func testPreflightFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.Preflight(&types.PreflightArgs{}, &id)
+	if err != nil {
+		t.Errorf("Failed to run preflight checks %v", err)
+		return
+	}
+
+	var res types.PreflightResult
+	if err := api.PreflightResult(id, &res); err == nil {
+		t.Errorf("PreflightResult expected to fail")
+	}
+}
+
+func testGetAuditLogFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.GetAuditLog(&types.AuditLogArgs{Since: time.Now()}, &id)
+	if err != nil {
+		t.Errorf("Failed to retrieve audit log %v", err)
+		return
 	}
 
-	if len(transactions) != 0 {
-		t.Errorf("Transactions remaining %d", len(transactions))
+	var records []types.AuditRecord
+	if err := api.GetAuditLogResult(id, &records); err == nil {
+		t.Errorf("GetAuditLogResult expected to fail")
 	}
-
-	transWg.Wait()
-	wg.Done()
 }
 
-func testCreate(t *testing.T, api *ServerAPI) {
+func testConsoleLogFail(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.Create(&types.CreateArgs{}, &id)
+	err := api.GetConsoleLog(&types.ConsoleLogArgs{Name: "testInstance"}, &id)
 	if err != nil {
-		t.Errorf("Failed to Create instance %v", err)
+		t.Errorf("Failed to retrieve console log %v", err)
 		return
 	}
 
-	for {
-		var res types.CreateResult
-		if err := api.CreateResult(id, &res); err != nil {
-			t.Errorf("CreateResult failed %v", err)
-			break
-		}
+	var out types.ConsoleOutput
+	if err := api.GetConsoleLogResult(id, &out); err == nil {
+		t.Errorf("GetConsoleLogResult expected to fail")
+	}
+}
 
-		if res.Finished {
-			break
-		}
+func testConsoleInputFail(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.SendConsoleInput(&types.ConsoleInputArgs{Name: "testInstance", Data: "root\r"}, &id)
+	if err != nil {
+		t.Errorf("Failed to send console input %v", err)
+		return
+	}
+
+	if err := api.SendConsoleInputResult(id, &struct{}{}); err == nil {
+		t.Errorf("SendConsoleInputResult expected to fail")
 	}
 }
 
-func testDelete(t *testing.T, api *ServerAPI) {
+func testExecFail(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.Delete("test-instance", &id)
+	err := api.Exec(&types.ExecArgs{Name: "testInstance", Command: "echo hello"}, &id)
 	if err != nil {
-		t.Errorf("Failed to Delete instance %v", err)
+		t.Errorf("Failed to execute command %v", err)
 		return
 	}
 
-	var res struct{}
-	if err := api.DeleteResult(id, &res); err != nil {
-		t.Errorf("DeleteResult failed %v", err)
+	var out types.ExecOutput
+	if err := api.ExecResult(id, &out); err == nil {
+		t.Errorf("ExecResult expected to fail")
 	}
 }
 
-func testStop(t *testing.T, api *ServerAPI) {
+func testProxyFail(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.Stop("test-instance", &id)
+	err := api.Proxy(&types.ProxyArgs{Name: "testInstance", SOCKSPort: 1080}, &id)
 	if err != nil {
-		t.Errorf("Failed to Stop instance %v", err)
+		t.Errorf("Failed to start proxy %v", err)
 		return
 	}
 
-	var res struct{}
-	if err := api.StopResult(id, &res); err != nil {
-		t.Errorf("StopResult failed %v", err)
+	var status types.ProxyStatus
+	if err := api.ProxyResult(id, &status); err == nil {
+		t.Errorf("ProxyResult expected to fail")
 	}
 }
 
-func testQuit(t *testing.T, api *ServerAPI) {
+func testVolumes(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.Quit("test-instance", &id)
+	err := api.CreateVolume(&types.VolumeSpec{Name: "cache", SizeGiB: 50}, &id)
 	if err != nil {
-		t.Errorf("Failed to Quit instance %v", err)
+		t.Errorf("Failed to create volume %v", err)
 		return
 	}
+	if err := api.CreateVolumeResult(id, &struct{}{}); err != nil {
+		t.Errorf("CreateVolumeResult failed %v", err)
+	}
 
-	var res struct{}
-	if err := api.QuitResult(id, &res); err != nil {
-		t.Errorf("QuitResult failed %v", err)
+	err = api.ListVolumes(struct{}{}, &id)
+	if err != nil {
+		t.Errorf("Failed to list volumes %v", err)
+		return
+	}
+	var volumes []types.VolumeInfo
+	if err := api.ListVolumesResult(id, &volumes); err != nil {
+		t.Errorf("ListVolumesResult failed %v", err)
+	} else if len(volumes) != 1 || volumes[0].Name != "cache" ||
+		volumes[0].Instance != "testInstance" {
+		t.Errorf("Unexpected volumes %+v", volumes)
+	}
+
+	err = api.DeleteVolume("cache", &id)
+	if err != nil {
+		t.Errorf("Failed to delete volume %v", err)
+		return
+	}
+	if err := api.DeleteVolumeResult(id, &struct{}{}); err != nil {
+		t.Errorf("DeleteVolumeResult failed %v", err)
 	}
 }
 
-func testStart(t *testing.T, api *ServerAPI) {
+func testVolumesFail(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.Start(&types.StartArgs{}, &id)
+	err := api.CreateVolume(&types.VolumeSpec{Name: "cache", SizeGiB: 50}, &id)
 	if err != nil {
-		t.Errorf("Failed to Start instance %v", err)
+		t.Errorf("Failed to create volume %v", err)
 		return
 	}
+	if err := api.CreateVolumeResult(id, &struct{}{}); err == nil {
+		t.Errorf("CreateVolumeResult expected to fail")
+	}
 
-	var res struct{}
-	if err := api.StartResult(id, &res); err != nil {
-		t.Errorf("StartResult failed %v", err)
+	err = api.ListVolumes(struct{}{}, &id)
+	if err != nil {
+		t.Errorf("Failed to list volumes %v", err)
+		return
+	}
+	var volumes []types.VolumeInfo
+	if err := api.ListVolumesResult(id, &volumes); err == nil {
+		t.Errorf("ListVolumesResult expected to fail")
+	}
+
+	err = api.DeleteVolume("cache", &id)
+	if err != nil {
+		t.Errorf("Failed to delete volume %v", err)
+		return
+	}
+	if err := api.DeleteVolumeResult(id, &struct{}{}); err == nil {
+		t.Errorf("DeleteVolumeResult expected to fail")
 	}
 }
 
-func testGetInstanceDetails(t *testing.T, api *ServerAPI) {
+func testCaches(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.GetInstanceDetails("test-instance", &id)
+	err := api.ListCaches(struct{}{}, &id)
 	if err != nil {
-		t.Errorf("Failed to retrieve instance details %v", err)
+		t.Errorf("Failed to list caches %v", err)
 		return
 	}
+	var caches []types.CacheInfo
+	if err := api.ListCachesResult(id, &caches); err != nil {
+		t.Errorf("ListCachesResult failed %v", err)
+	} else if len(caches) != 1 || caches[0].Name != "apt" ||
+		len(caches[0].Instances) != 1 {
+		t.Errorf("Unexpected caches %+v", caches)
+	}
 
-	if err := api.GetInstanceDetailsResult(id, &types.InstanceDetails{}); err != nil {
-		t.Errorf("GetInstanceDetailsResult failed %v", err)
+	err = api.ClearCache("apt", &id)
+	if err != nil {
+		t.Errorf("Failed to clear cache %v", err)
+		return
+	}
+	if err := api.ClearCacheResult(id, &struct{}{}); err != nil {
+		t.Errorf("ClearCacheResult failed %v", err)
 	}
 }
 
-func testGetInstances(t *testing.T, api *ServerAPI) {
+func testCachesFail(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.GetInstances(struct{}{}, &id)
+	err := api.ListCaches(struct{}{}, &id)
 	if err != nil {
-		t.Errorf("Failed to retrieve instances %v", err)
+		t.Errorf("Failed to list caches %v", err)
 		return
 	}
+	var caches []types.CacheInfo
+	if err := api.ListCachesResult(id, &caches); err == nil {
+		t.Errorf("ListCachesResult expected to fail")
+	}
 
-	if err := api.GetInstancesResult(id, &[]string{}); err != nil {
-		t.Errorf("GetInstancesResult failed %v", err)
+	err = api.ClearCache("apt", &id)
+	if err != nil {
+		t.Errorf("Failed to clear cache %v", err)
+		return
+	}
+	if err := api.ClearCacheResult(id, &struct{}{}); err == nil {
+		t.Errorf("ClearCacheResult expected to fail")
 	}
 }
 
-func TestAPI(t *testing.T) {
-	var wg sync.WaitGroup
-	api := &ServerAPI{
-		signalCh: make(chan os.Signal),
-		actionCh: make(chan interface{}),
+func testUpdateWorkloads(t *testing.T, api *ServerAPI) {
+	var id int
+	URLs := []string{"https://example.com/dev.yaml"}
+	err := api.UpdateWorkloads(URLs, &id)
+	if err != nil {
+		t.Errorf("Failed to update workloads %v", err)
+		return
 	}
-	ts := &testService{}
 
-	wg.Add(1)
-	go startTestAPIServer(ts, api, &wg, t)
+	var updated []string
+	if err := api.UpdateWorkloadsResult(id, &updated); err != nil {
+		t.Errorf("UpdateWorkloadsResult failed %v", err)
+	} else if len(updated) != 1 || updated[0] != URLs[0] {
+		t.Errorf("Unexpected updated workloads %v", updated)
+	}
+}
 
-	t.Run("create", func(t *testing.T) {
-		testCreate(t, api)
-	})
-	t.Run("delete", func(t *testing.T) {
-		testDelete(t, api)
-	})
-	t.Run("quit", func(t *testing.T) {
-		testQuit(t, api)
-	})
-	t.Run("stop", func(t *testing.T) {
-		testStop(t, api)
-	})
-	t.Run("start", func(t *testing.T) {
-		testStart(t, api)
-	})
-	t.Run("getinstancedetails", func(t *testing.T) {
-		testGetInstanceDetails(t, api)
-	})
-	t.Run("getinstances", func(t *testing.T) {
-		testGetInstances(t, api)
-	})
+func testWorkloads(t *testing.T, api *ServerAPI) {
+	var id int
+	err := api.GetWorkloads(struct{}{}, &id)
+	if err != nil {
+		t.Errorf("Failed to get workloads %v", err)
+		return
+	}
+	var workloads []types.WorkloadInfo
+	if err := api.GetWorkloadsResult(id, &workloads); err != nil {
+		t.Errorf("GetWorkloadsResult failed %v", err)
+	} else if len(workloads) != 1 || workloads[0].Name != "xenial" {
+		t.Errorf("Unexpected workloads %+v", workloads)
+	}
 
-	close(api.signalCh)
+	err = api.ShowWorkload("xenial", &id)
+	if err != nil {
+		t.Errorf("Failed to show workload %v", err)
+		return
+	}
+	var details types.WorkloadDetails
+	if err := api.ShowWorkloadResult(id, &details); err != nil {
+		t.Errorf("ShowWorkloadResult failed %v", err)
+	} else if details.Name != "xenial" || details.Spec == "" {
+		t.Errorf("Unexpected workload details %+v", details)
+	}
 
-	wg.Wait()
+	err = api.ValidateWorkload(&types.ValidateWorkloadArgs{Name: "dev.yaml"}, &id)
+	if err != nil {
+		t.Errorf("Failed to validate workload %v", err)
+		return
+	}
+	var problems []types.WorkloadProblem
+	if err := api.ValidateWorkloadResult(id, &problems); err != nil {
+		t.Errorf("ValidateWorkloadResult failed %v", err)
+	} else if len(problems) != 1 || problems[0].Line != 3 {
+		t.Errorf("Unexpected workload problems %+v", problems)
+	}
 }
 
-func testCreateFail(t *testing.T, api *ServerAPI) {
+func testExport(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.Create(&types.CreateArgs{}, &id)
+	err := api.Export(&types.ExportArgs{Name: "test-instance", Path: "/tmp/dev.tar"}, &id)
 	if err != nil {
-		t.Errorf("Failed to Create instance %v", err)
+		t.Errorf("Failed to export instance %v", err)
 		return
 	}
+	if err := api.ExportResult(id, &struct{}{}); err != nil {
+		t.Errorf("ExportResult failed %v", err)
+	}
 
-	for {
-		var res types.CreateResult
-		if err := api.CreateResult(id, &res); err == nil {
-			t.Errorf("CreateResult expected to fail")
-			if res.Finished {
-				break
-			}
-		} else {
-			break
-		}
+	err = api.BuildTemplate(&types.BuildTemplateArgs{Name: "test-instance", Template: "dev"}, &id)
+	if err != nil {
+		t.Errorf("Failed to build template %v", err)
+		return
+	}
+	var info types.TemplateInfo
+	if err := api.BuildTemplateResult(id, &info); err != nil {
+		t.Errorf("BuildTemplateResult failed %v", err)
+	} else if info.Name != "dev" {
+		t.Errorf("Unexpected template %+v", info)
+	}
+
+	err = api.Import(&types.ImportArgs{Path: "/tmp/dev.tar", Name: "dev"}, &id)
+	if err != nil {
+		t.Errorf("Failed to import workload %v", err)
+		return
+	}
+	var name string
+	if err := api.ImportResult(id, &name); err != nil {
+		t.Errorf("ImportResult failed %v", err)
+	} else if name != "dev" {
+		t.Errorf("Unexpected workload name %s", name)
+	}
+
+	err = api.ImportVagrant(&types.ImportVagrantArgs{Path: "/tmp/dev/Vagrantfile", Name: "dev"}, &id)
+	if err != nil {
+		t.Errorf("Failed to import Vagrantfile %v", err)
+		return
+	}
+	var res types.ImportVagrantResult
+	if err := api.ImportVagrantResult(id, &res); err != nil {
+		t.Errorf("ImportVagrantResult failed %v", err)
+	} else if res.Name != "dev" || res.Inherits != "xenial" {
+		t.Errorf("Unexpected import result %+v", res)
 	}
 }
 
-func testDeleteFail(t *testing.T, api *ServerAPI) {
+func testExportFail(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.Delete("test-instance", &id)
+	err := api.Export(&types.ExportArgs{Name: "test-instance", Path: "/tmp/dev.tar"}, &id)
 	if err != nil {
-		t.Errorf("Failed to Delete instance %v", err)
+		t.Errorf("Failed to export instance %v", err)
 		return
 	}
+	if err := api.ExportResult(id, &struct{}{}); err == nil {
+		t.Errorf("ExportResult expected to fail")
+	}
 
-	var res struct{}
-	if err := api.DeleteResult(id, &res); err == nil {
-		t.Errorf("DeleteResult expected to fail")
+	err = api.BuildTemplate(&types.BuildTemplateArgs{Name: "test-instance", Template: "dev"}, &id)
+	if err != nil {
+		t.Errorf("Failed to build template %v", err)
+		return
+	}
+	if err := api.BuildTemplateResult(id, &types.TemplateInfo{}); err == nil {
+		t.Errorf("BuildTemplateResult expected to fail")
+	}
+
+	err = api.Import(&types.ImportArgs{Path: "/tmp/dev.tar"}, &id)
+	if err != nil {
+		t.Errorf("Failed to import workload %v", err)
+		return
+	}
+	var name string
+	if err := api.ImportResult(id, &name); err == nil {
+		t.Errorf("ImportResult expected to fail")
+	}
+
+	err = api.ImportVagrant(&types.ImportVagrantArgs{Path: "/tmp/dev/Vagrantfile"}, &id)
+	if err != nil {
+		t.Errorf("Failed to import Vagrantfile %v", err)
+		return
+	}
+	if err := api.ImportVagrantResult(id, &types.ImportVagrantResult{}); err == nil {
+		t.Errorf("ImportVagrantResult expected to fail")
 	}
 }
 
-func testStopFail(t *testing.T, api *ServerAPI) {
+func testRegistry(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.Stop("test-instance", &id)
+	ref := "ghcr.io/org/devvm:1.2"
+	err := api.Push(&types.PushArgs{Reference: ref, Path: "/tmp/dev.tar"}, &id)
 	if err != nil {
-		t.Errorf("Failed to Stop instance %v", err)
+		t.Errorf("Failed to push workload %v", err)
 		return
 	}
+	if err := api.PushResult(id, &struct{}{}); err != nil {
+		t.Errorf("PushResult failed %v", err)
+	}
 
-	var res struct{}
-	if err := api.StopResult(id, &res); err == nil {
-		t.Errorf("StopResult expected to fail")
+	err = api.Pull(&types.PullArgs{Reference: ref, Name: "devvm"}, &id)
+	if err != nil {
+		t.Errorf("Failed to pull workload %v", err)
+		return
+	}
+	var name string
+	if err := api.PullResult(id, &name); err != nil {
+		t.Errorf("PullResult failed %v", err)
+	} else if name != "devvm" {
+		t.Errorf("Unexpected workload name %s", name)
 	}
 }
 
-func testQuitFail(t *testing.T, api *ServerAPI) {
+func testRegistryFail(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.Quit("test-instance", &id)
+	ref := "ghcr.io/org/devvm:1.2"
+	err := api.Push(&types.PushArgs{Reference: ref, Path: "/tmp/dev.tar"}, &id)
 	if err != nil {
-		t.Errorf("Failed to Quit instance %v", err)
+		t.Errorf("Failed to push workload %v", err)
 		return
 	}
+	if err := api.PushResult(id, &struct{}{}); err == nil {
+		t.Errorf("PushResult expected to fail")
+	}
 
-	var res struct{}
-	if err := api.QuitResult(id, &res); err == nil {
-		t.Errorf("QuitResult expected to fail")
+	err = api.Pull(&types.PullArgs{Reference: ref}, &id)
+	if err != nil {
+		t.Errorf("Failed to pull workload %v", err)
+		return
+	}
+	var name string
+	if err := api.PullResult(id, &name); err == nil {
+		t.Errorf("PullResult expected to fail")
 	}
 }
 
-func testStartFail(t *testing.T, api *ServerAPI) {
+func testWorkloadsFail(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.Start(&types.StartArgs{}, &id)
+	err := api.GetWorkloads(struct{}{}, &id)
 	if err != nil {
-		t.Errorf("Failed to Start instance %v", err)
+		t.Errorf("Failed to get workloads %v", err)
 		return
 	}
+	var workloads []types.WorkloadInfo
+	if err := api.GetWorkloadsResult(id, &workloads); err == nil {
+		t.Errorf("GetWorkloadsResult expected to fail")
+	}
 
-	var res struct{}
-	if err := api.StartResult(id, &res); err == nil {
-		t.Errorf("StartResult expected to fail")
+	err = api.ShowWorkload("xenial", &id)
+	if err != nil {
+		t.Errorf("Failed to show workload %v", err)
+		return
+	}
+	var details types.WorkloadDetails
+	if err := api.ShowWorkloadResult(id, &details); err == nil {
+		t.Errorf("ShowWorkloadResult expected to fail")
+	}
+
+	err = api.ValidateWorkload(&types.ValidateWorkloadArgs{Name: "dev.yaml"}, &id)
+	if err != nil {
+		t.Errorf("Failed to validate workload %v", err)
+		return
+	}
+	var problems []types.WorkloadProblem
+	if err := api.ValidateWorkloadResult(id, &problems); err == nil {
+		t.Errorf("ValidateWorkloadResult expected to fail")
 	}
 }
 
-func testGetInstanceDetailsFail(t *testing.T, api *ServerAPI) {
+func testUpdateWorkloadsFail(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.GetInstanceDetails("test-instance", &id)
+	err := api.UpdateWorkloads(nil, &id)
 	if err != nil {
-		t.Errorf("Failed to retrieve instance details %v", err)
+		t.Errorf("Failed to update workloads %v", err)
 		return
 	}
 
-	if err := api.GetInstanceDetailsResult(id, &types.InstanceDetails{}); err == nil {
-		t.Errorf("GetInstanceDetailsResult expected to fail")
+	var updated []string
+	if err := api.UpdateWorkloadsResult(id, &updated); err == nil {
+		t.Errorf("UpdateWorkloadsResult expected to fail")
 	}
 }
 
-func testGetInstancesFail(t *testing.T, api *ServerAPI) {
+func testNetworksFail(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.GetInstances(struct{}{}, &id)
+	err := api.CreateNetwork(&types.NetworkSpec{Name: "lab"}, &id)
 	if err != nil {
-		t.Errorf("Failed to retrieve instances %v", err)
+		t.Errorf("Failed to create network %v", err)
 		return
 	}
+	if err := api.CreateNetworkResult(id, &struct{}{}); err == nil {
+		t.Errorf("CreateNetworkResult expected to fail")
+	}
 
-	if err := api.GetInstancesResult(id, &[]string{}); err == nil {
-		t.Errorf("GetInstancesResult expected to fail")
+	err = api.ListNetworks(struct{}{}, &id)
+	if err != nil {
+		t.Errorf("Failed to list networks %v", err)
+		return
+	}
+	var networks []types.NetworkInfo
+	if err := api.ListNetworksResult(id, &networks); err == nil {
+		t.Errorf("ListNetworksResult expected to fail")
+	}
+
+	err = api.DeleteNetwork("lab", &id)
+	if err != nil {
+		t.Errorf("Failed to delete network %v", err)
+		return
+	}
+	if err := api.DeleteNetworkResult(id, &struct{}{}); err == nil {
+		t.Errorf("DeleteNetworkResult expected to fail")
 	}
 }
 
@@ -449,15 +2545,121 @@ func TestAPIFail(t *testing.T) {
 	t.Run("quit", func(t *testing.T) {
 		testQuitFail(t, api)
 	})
+	t.Run("flatten", func(t *testing.T) {
+		testFlattenFail(t, api)
+	})
+	t.Run("rename", func(t *testing.T) {
+		testRenameFail(t, api)
+	})
+	t.Run("backup", func(t *testing.T) {
+		testBackupFail(t, api)
+	})
+	t.Run("suspend", func(t *testing.T) {
+		testSuspendFail(t, api)
+	})
+	t.Run("set", func(t *testing.T) {
+		testSetFail(t, api)
+	})
+	t.Run("syncStatus", func(t *testing.T) {
+		testSyncStatusFail(t, api)
+	})
+	t.Run("getKubeconfig", func(t *testing.T) {
+		testGetKubeconfigFail(t, api)
+	})
+	t.Run("pauseSync", func(t *testing.T) {
+		testPauseSyncFail(t, api)
+	})
+	t.Run("resumeSync", func(t *testing.T) {
+		testResumeSyncFail(t, api)
+	})
 	t.Run("stop", func(t *testing.T) {
 		testStopFail(t, api)
 	})
 	t.Run("start", func(t *testing.T) {
 		testStartFail(t, api)
 	})
+	t.Run("restart", func(t *testing.T) {
+		testRestartFail(t, api)
+	})
+	t.Run("resize", func(t *testing.T) {
+		testResizeFail(t, api)
+	})
+	t.Run("forward", func(t *testing.T) {
+		testForwardFail(t, api)
+	})
+	t.Run("mount", func(t *testing.T) {
+		testMountFail(t, api)
+	})
+	t.Run("disk", func(t *testing.T) {
+		testDiskFail(t, api)
+	})
+	t.Run("fsck", func(t *testing.T) {
+		testFsckFail(t, api)
+	})
 	t.Run("getinstancedetails", func(t *testing.T) {
 		testGetInstanceDetailsFail(t, api)
 	})
+	t.Run("getsshkey", func(t *testing.T) {
+		testGetSSHKeyFail(t, api)
+	})
+	t.Run("refreshstatus", func(t *testing.T) {
+		testRefreshStatusFail(t, api)
+	})
+	t.Run("creategroup", func(t *testing.T) {
+		testCreateGroupFail(t, api)
+	})
+	t.Run("groupactions", func(t *testing.T) {
+		testGroupActionsFail(t, api)
+	})
+	t.Run("watchevents", func(t *testing.T) {
+		testWatchEventsFail(t, api)
+	})
+	t.Run("report", func(t *testing.T) {
+		testReportFail(t, api)
+	})
+	t.Run("getauditlog", func(t *testing.T) {
+		testGetAuditLogFail(t, api)
+	})
+	t.Run("preflight", func(t *testing.T) {
+		testPreflightFail(t, api)
+	})
+	t.Run("consolelog", func(t *testing.T) {
+		testConsoleLogFail(t, api)
+	})
+	t.Run("consoleinput", func(t *testing.T) {
+		testConsoleInputFail(t, api)
+	})
+	t.Run("networks", func(t *testing.T) {
+		testNetworksFail(t, api)
+	})
+	t.Run("volumes", func(t *testing.T) {
+		testVolumesFail(t, api)
+	})
+	t.Run("caches", func(t *testing.T) {
+		testCachesFail(t, api)
+	})
+	t.Run("prefetch", func(t *testing.T) {
+		testPrefetchFail(t, api)
+	})
+	t.Run("batch", func(t *testing.T) {
+		testBatchFail(t, api)
+	})
+	t.Run("workloads", func(t *testing.T) {
+		testUpdateWorkloadsFail(t, api)
+		testWorkloadsFail(t, api)
+	})
+	t.Run("export", func(t *testing.T) {
+		testExportFail(t, api)
+	})
+	t.Run("registry", func(t *testing.T) {
+		testRegistryFail(t, api)
+	})
+	t.Run("exec", func(t *testing.T) {
+		testExecFail(t, api)
+	})
+	t.Run("proxy", func(t *testing.T) {
+		testProxyFail(t, api)
+	})
 	t.Run("getinstances", func(t *testing.T) {
 		testGetInstancesFail(t, api)
 	})
@@ -498,7 +2700,7 @@ func testDeleteCancel(t *testing.T, api *ServerAPI) {
 
 	_ = api.Cancel(id, &struct{}{})
 
-	var res struct{}
+	var res types.CommandResult
 	if err := api.DeleteResult(id, &res); err != nil && err != errCancelled {
 		t.Errorf("Expected Cancelled")
 	}
@@ -506,7 +2708,7 @@ func testDeleteCancel(t *testing.T, api *ServerAPI) {
 
 func testStopCancel(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.Stop("test-instance", &id)
+	err := api.Stop(&types.StopArgs{Name: "test-instance"}, &id)
 	if err != nil {
 		t.Errorf("Failed to Stop instance %v", err)
 		return
@@ -514,7 +2716,7 @@ func testStopCancel(t *testing.T, api *ServerAPI) {
 
 	_ = api.Cancel(id, &struct{}{})
 
-	var res struct{}
+	var res types.StopResult
 	if err := api.StopResult(id, &res); err != nil && err != errCancelled {
 		t.Errorf("Expected Cancelled")
 	}
@@ -530,7 +2732,7 @@ func testQuitCancel(t *testing.T, api *ServerAPI) {
 
 	_ = api.Cancel(id, &struct{}{})
 
-	var res struct{}
+	var res types.CommandResult
 	if err := api.QuitResult(id, &res); err != nil && err != errCancelled {
 		t.Errorf("Expected Cancelled")
 	}
@@ -546,7 +2748,7 @@ func testStartCancel(t *testing.T, api *ServerAPI) {
 
 	_ = api.Cancel(id, &struct{}{})
 
-	var res struct{}
+	var res types.CommandResult
 	if err := api.StartResult(id, &res); err != nil && err != errCancelled {
 		t.Errorf("Expected Cancelled")
 	}
@@ -570,7 +2772,7 @@ func testGetInstanceDetailsCancel(t *testing.T, api *ServerAPI) {
 
 func testGetInstancesCancel(t *testing.T, api *ServerAPI) {
 	var id int
-	err := api.GetInstances(struct{}{}, &id)
+	err := api.GetInstances(&types.InstancesArgs{}, &id)
 	if err != nil {
 		t.Errorf("Failed to retrieve instances %v", err)
 		return
@@ -578,7 +2780,7 @@ func testGetInstancesCancel(t *testing.T, api *ServerAPI) {
 
 	_ = api.Cancel(id, &struct{}{})
 
-	if err := api.GetInstancesResult(id, &[]string{}); err == nil && err != errCancelled {
+	if err := api.GetInstancesResult(id, &[]types.InstanceSummary{}); err == nil && err != errCancelled {
 		t.Errorf("Expected Cancelled")
 	}
 }
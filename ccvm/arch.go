@@ -0,0 +1,222 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Guests whose architecture is not x86_64 are booted by the qemu binary of
+// their architecture on its virt machine type, and are emulated by TCG
+// unless their architecture is the host's.
+
+// tcgAccelArgs are the qemu arguments that emulate guests with TCG, using
+// one host thread per guest CPU.
+var tcgAccelArgs = []string{"-accel", "tcg,thread=multi"}
+
+// tcgCPUModel is the CPU model of emulated guests, which cannot use the
+// host's model.
+const tcgCPUModel = "max"
+
+// aavmfDirs lists the directories in which distributions install AAVMF,
+// the aarch64 build of OVMF.
+var aavmfDirs = []string{
+	"/usr/share/AAVMF",
+	"/usr/share/edk2/aarch64",
+	"/usr/share/qemu",
+}
+
+// aavmfCandidates lists the names of the AAVMF code and variable store
+// images, in order of preference.
+var aavmfCandidates = []ovmfFiles{
+	{"AAVMF_CODE.fd", "AAVMF_VARS.fd"},
+	{"QEMU_EFI-pflash.raw", "vars-template-pflash.raw"},
+	{"edk2-aarch64-code.fd", "edk2-arm-vars.fd"},
+}
+
+// ubootRISCV64Paths lists the locations of the S-mode U-Boot image that
+// boots riscv64 guests from their disk.
+var ubootRISCV64Paths = []string{
+	"/usr/lib/u-boot/qemu-riscv64_smode/uboot.elf",
+	"/usr/lib/u-boot/qemu-riscv64_smode/u-boot.bin",
+	"/usr/share/uboot/qemu-riscv64_smode/u-boot.bin",
+}
+
+// guestArch returns the architecture of a VM, defaulting to ArchX86_64.
+func guestArch(in *types.VMSpec) string {
+	if in.Arch == "" {
+		return types.ArchX86_64
+	}
+	return in.Arch
+}
+
+// hostArch returns the architecture of the host.
+func hostArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return types.ArchX86_64
+	case "arm64":
+		return types.ArchAarch64
+	}
+	return runtime.GOARCH
+}
+
+// qemuAccelerated returns true if guests of architecture arch can be
+// accelerated by the host's hypervisor.
+func qemuAccelerated(arch string) bool {
+	return arch == hostArch() && hostAccelAvailable()
+}
+
+// checkArch verifies that the architecture of the VM of spec is known and
+// that the VM does not use devices that only x86_64 guests support.
+func checkArch(spec *workloadSpec) error {
+	in := &spec.VM
+	arch := guestArch(in)
+	switch arch {
+	case types.ArchX86_64:
+		return nil
+	case types.ArchAarch64, types.ArchRiscv64:
+	default:
+		return errors.Errorf("Unknown architecture %s, expected %s, %s or %s", in.Arch,
+			types.ArchX86_64, types.ArchAarch64, types.ArchRiscv64)
+	}
+
+	switch {
+	case in.TPM:
+		return errors.Errorf("TPMs are not supported by %s guests", arch)
+	case len(in.VGPUs) > 0 || len(in.GPUs) > 0:
+		return errors.Errorf("GPUs are not supported by %s guests", arch)
+	case in.NestedVirt || spec.NeedsNestedVM:
+		return errors.Errorf("Nested virtualization is not supported by %s guests", arch)
+	}
+	return nil
+}
+
+// checkArchFirmware verifies that the firmware of the VM of spec is
+// supported by its architecture.
+func checkArchFirmware(spec *workloadSpec) error {
+	arch := guestArch(&spec.VM)
+	if arch == types.ArchX86_64 {
+		return nil
+	}
+
+	if spec.BIOS != "" {
+		return errors.Errorf("The bios field is not supported by %s guests", arch)
+	}
+
+	expected := types.FirmwareBIOS
+	if arch == types.ArchAarch64 {
+		expected = types.FirmwareUEFI
+	}
+	if firmware := firmwareType(&spec.VM); firmware != expected {
+		return errors.Errorf("%s guests cannot be booted with %s firmware", arch, firmware)
+	}
+	return nil
+}
+
+// qemuBinary returns the qemu binary that runs guests of architecture
+// arch.  The binaries of other architectures are looked for in the
+// directory of the configured x86_64 binary, if any.
+func qemuBinary(cfg *qemuConfig, arch string) string {
+	if arch == "" || arch == types.ArchX86_64 {
+		if cfg.Path != "" {
+			return cfg.Path
+		}
+		return defaultQemuBinary
+	}
+
+	binary := "qemu-system-" + arch
+	if cfg.Path != "" {
+		return filepath.Join(filepath.Dir(cfg.Path), binary)
+	}
+	return binary
+}
+
+// archArgs returns the machine type, accelerator and -cpu option of a VM,
+// given whether it can be accelerated by the host's hypervisor.
+func archArgs(in *types.VMSpec, accelerated bool) []string {
+	var args []string
+	switch guestArch(in) {
+	case types.ArchAarch64:
+		args = append(args, "-machine", "virt,gic-version=max")
+	case types.ArchRiscv64:
+		args = append(args, "-machine", "virt")
+	}
+
+	cpu := qemuCPUParam(in)
+	if accelerated {
+		args = append(args, qemuAccelArgs...)
+	} else {
+		args = append(args, tcgAccelArgs...)
+		if in.CPUModel == "" {
+			cpu = tcgCPUModel + strings.TrimPrefix(cpu, defaultCPUModel)
+		}
+	}
+	return append(args, "-cpu", cpu)
+}
+
+// archSerialArgs returns the qemu arguments that connect the VM's first
+// serial port to the chardev ccld0.
+func archSerialArgs(in *types.VMSpec) []string {
+	if guestArch(in) == types.ArchX86_64 {
+		return []string{"-device", "isa-serial,chardev=ccld0"}
+	}
+	return []string{"-serial", "chardev:ccld0"}
+}
+
+// archFirmwareArgs returns the qemu arguments that boot a VM with the
+// firmware of its architecture.
+func archFirmwareArgs(instanceDir string, in *types.VMSpec) ([]string, error) {
+	switch guestArch(in) {
+	case types.ArchAarch64:
+		for _, dir := range aavmfDirs {
+			for _, c := range aavmfCandidates {
+				code, vars := path.Join(dir, c.code), path.Join(dir, c.vars)
+				if _, err := os.Stat(code); err != nil {
+					continue
+				}
+				if _, err := os.Stat(vars); err != nil {
+					continue
+				}
+				return pflashArgs(instanceDir, code, vars)
+			}
+		}
+		return nil, errors.Errorf("Unable to find AAVMF images in %s.  "+
+			"Install the qemu-efi-aarch64 package, or edk2-aarch64 on Fedora",
+			strings.Join(aavmfDirs, ", "))
+	case types.ArchRiscv64:
+		// Kernels booted directly are started by OpenSBI itself.
+		if in.Kernel != "" {
+			return nil, nil
+		}
+		for _, p := range ubootRISCV64Paths {
+			if _, err := os.Stat(p); err == nil {
+				return []string{"-kernel", p}, nil
+			}
+		}
+		return nil, errors.Errorf("Unable to find U-Boot in %s.  Install the u-boot-qemu package",
+			strings.Join(ubootRISCV64Paths, ", "))
+	}
+	return firmwareArgs(instanceDir, firmwareType(in))
+}
@@ -0,0 +1,167 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestCheckArch(t *testing.T) {
+	valid := []workloadSpec{
+		{},
+		{VM: types.VMSpec{Arch: types.ArchX86_64, TPM: true}},
+		{VM: types.VMSpec{Arch: types.ArchAarch64}},
+		{VM: types.VMSpec{Arch: types.ArchRiscv64, Firmware: types.FirmwareBIOS}},
+	}
+	for i := range valid {
+		if err := checkArch(&valid[i]); err != nil {
+			t.Errorf("Valid architecture rejected: %v", err)
+		}
+		if err := checkFirmware(&valid[i]); err != nil {
+			t.Errorf("Valid firmware rejected: %v", err)
+		}
+	}
+
+	invalid := []workloadSpec{
+		{VM: types.VMSpec{Arch: "arm64"}},
+		{VM: types.VMSpec{Arch: types.ArchAarch64, TPM: true}},
+		{VM: types.VMSpec{Arch: types.ArchRiscv64, NestedVirt: true}},
+		{VM: types.VMSpec{Arch: types.ArchAarch64, GPUs: []types.GPU{{Address: "0000:01:00.0"}}}},
+	}
+	for i := range invalid {
+		if err := checkArch(&invalid[i]); err == nil {
+			t.Errorf("Expected error for %+v", invalid[i])
+		}
+	}
+
+	invalid = []workloadSpec{
+		{VM: types.VMSpec{Arch: types.ArchAarch64, Firmware: types.FirmwareBIOS}},
+		{VM: types.VMSpec{Arch: types.ArchAarch64, Firmware: types.FirmwareUEFISecureBoot}},
+		{VM: types.VMSpec{Arch: types.ArchRiscv64, Firmware: types.FirmwareUEFI}},
+		{BIOS: "file:///tmp/QEMU_EFI.fd", VM: types.VMSpec{Arch: types.ArchAarch64}},
+	}
+	for i := range invalid {
+		if err := checkFirmware(&invalid[i]); err == nil {
+			t.Errorf("Expected firmware error for %+v", invalid[i])
+		}
+	}
+}
+
+func TestQemuBinary(t *testing.T) {
+	binaries := []struct {
+		cfg    qemuConfig
+		arch   string
+		binary string
+	}{
+		{qemuConfig{}, "", defaultQemuBinary},
+		{qemuConfig{}, types.ArchAarch64, "qemu-system-aarch64"},
+		{qemuConfig{Path: "/opt/qemu/bin/qemu-system-x86_64"}, types.ArchX86_64,
+			"/opt/qemu/bin/qemu-system-x86_64"},
+		{qemuConfig{Path: "/opt/qemu/bin/qemu-system-x86_64"}, types.ArchRiscv64,
+			"/opt/qemu/bin/qemu-system-riscv64"},
+	}
+	for _, b := range binaries {
+		if binary := qemuBinary(&b.cfg, b.arch); binary != b.binary {
+			t.Errorf("Expected %s for %s, found %s", b.binary, b.arch, binary)
+		}
+	}
+}
+
+func TestArchArgs(t *testing.T) {
+	in := types.VMSpec{Profiling: true}
+	args := strings.Join(archArgs(&in, true), " ")
+	if !strings.HasPrefix(args, strings.Join(qemuAccelArgs, " ")) ||
+		!strings.HasSuffix(args, "-cpu host,pmu=on") {
+		t.Errorf("Unexpected accelerated arguments %s", args)
+	}
+
+	in = types.VMSpec{Arch: types.ArchAarch64}
+	args = strings.Join(archArgs(&in, false), " ")
+	if args != "-machine virt,gic-version=max -accel tcg,thread=multi -cpu max" {
+		t.Errorf("Unexpected emulated arguments %s", args)
+	}
+
+	in = types.VMSpec{Arch: types.ArchRiscv64, CPUModel: "rv64"}
+	args = strings.Join(archArgs(&in, false), " ")
+	if args != "-machine virt -accel tcg,thread=multi -cpu rv64" {
+		t.Errorf("Unexpected emulated arguments %s", args)
+	}
+
+	if serial := archSerialArgs(&in); serial[0] != "-serial" {
+		t.Errorf("Unexpected serial arguments %v", serial)
+	}
+
+	in = types.VMSpec{Arch: types.ArchRiscv64, CPUs: 4}
+	if param := qemuSMPParam(&in); param != "cpus=4" {
+		t.Errorf("Unexpected -smp option %s", param)
+	}
+}
+
+func TestArchFirmwareArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	savedDirs := aavmfDirs
+	aavmfDirs = []string{path.Join(dir, "AAVMF")}
+	defer func() { aavmfDirs = savedDirs }()
+
+	instanceDir := path.Join(dir, "instance")
+	if err := os.MkdirAll(instanceDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	in := types.VMSpec{Arch: types.ArchAarch64}
+	if _, err := archFirmwareArgs(instanceDir, &in); err == nil {
+		t.Errorf("Expected error when AAVMF is not installed")
+	}
+
+	if err := os.MkdirAll(aavmfDirs[0], 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"AAVMF_CODE.fd", "AAVMF_VARS.fd"} {
+		if err := ioutil.WriteFile(path.Join(aavmfDirs[0], f), []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	args, err := archFirmwareArgs(instanceDir, &in)
+	if err != nil {
+		t.Fatalf("Unable to find AAVMF: %v", err)
+	}
+	cmdLine := strings.Join(args, " ")
+	if !strings.Contains(cmdLine, "readonly=on,file="+path.Join(aavmfDirs[0], "AAVMF_CODE.fd")) ||
+		!strings.Contains(cmdLine, "file="+path.Join(instanceDir, nvramFile)) {
+		t.Errorf("Unexpected firmware arguments %s", cmdLine)
+	}
+	if _, err := os.Stat(path.Join(instanceDir, nvramFile)); err != nil {
+		t.Errorf("NVRAM not created: %v", err)
+	}
+
+	in = types.VMSpec{Arch: types.ArchRiscv64, Kernel: "/boot/Image"}
+	if args, err := archFirmwareArgs(instanceDir, &in); err != nil || len(args) != 0 {
+		t.Errorf("Unexpected arguments %v for direct kernel boot: %v", args, err)
+	}
+}
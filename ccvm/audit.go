@@ -0,0 +1,109 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The daemon records who asked it to create, stop, rename and delete
+// instances, and when, in ~/.ccloudvm/audit.log, one JSON object per line.
+// Records are only ever appended to the log.  Requests that are denied are
+// recorded as well.
+
+const auditFile = "audit.log"
+
+// auditedOperations are the operations recorded in the audit log.
+var auditedOperations = map[string]bool{
+	"Create": true,
+	"Stop":   true,
+	"Delete": true,
+	"Rename": true,
+}
+
+type auditLog struct {
+	sync.Mutex
+	path string
+}
+
+func newAuditLog(ccvmDir string) *auditLog {
+	return &auditLog{path: filepath.Join(ccvmDir, auditFile)}
+}
+
+func (a *auditLog) record(rec *types.AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode audit record")
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to open %s", a.path)
+	}
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "Unable to write to %s", a.path)
+	}
+	return f.Close()
+}
+
+// query returns the records matching args, oldest first.  Lines that cannot
+// be decoded, e.g., one truncated by a crash, are skipped.
+func (a *auditLog) query(args *types.AuditLogArgs) ([]types.AuditRecord, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	f, err := os.Open(a.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Unable to open %s", a.path)
+	}
+	defer func() { _ = f.Close() }()
+
+	var records []types.AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec types.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if rec.Time.Before(args.Since) {
+			continue
+		}
+		if args.Instance != "" && rec.Instance != args.Instance {
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "Unable to read %s", a.path)
+	}
+
+	return records, nil
+}
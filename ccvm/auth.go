@@ -0,0 +1,206 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/rpc"
+	"strings"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Daemons shared by several people can require their clients to present a
+// token.  Each token allows its holder to perform a set of operations on
+// the instances whose names start with one of a set of prefixes.
+
+// tokenConfig describes a token.  SHA256 is the hex encoded hash of the
+// token.  Operations lists the operations the token allows, * allowing all
+// of them.  If Prefixes is not empty, the operations on instances are only
+// allowed on instances whose names start with one of the prefixes.
+type tokenConfig struct {
+	Name       string   `yaml:"name"`
+	SHA256     string   `yaml:"sha256"`
+	Operations []string `yaml:"operations"`
+	Prefixes   []string `yaml:"prefixes"`
+}
+
+type authConfig struct {
+	Tokens []tokenConfig `yaml:"tokens"`
+}
+
+func (c *authConfig) validate() error {
+	for _, t := range c.Tokens {
+		if t.Name == "" {
+			return errors.New("Token without a name")
+		}
+		if sum, err := hex.DecodeString(t.SHA256); err != nil || len(sum) != sha256.Size {
+			return errors.Errorf("Invalid SHA-256 hash for token %s", t.Name)
+		}
+	}
+	return nil
+}
+
+// authenticate returns the token presented in header, the value of an
+// Authorization header.  It returns nil if no tokens are configured.
+func (c *authConfig) authenticate(header string) (*tokenConfig, error) {
+	if len(c.Tokens) == 0 {
+		return nil, nil
+	}
+
+	const scheme = "Bearer "
+	if !strings.HasPrefix(header, scheme) {
+		return nil, errors.New("A token is required")
+	}
+	sum := sha256.Sum256([]byte(strings.TrimPrefix(header, scheme)))
+
+	for i := range c.Tokens {
+		want, _ := hex.DecodeString(c.Tokens[i].SHA256)
+		if subtle.ConstantTimeCompare(sum[:], want) == 1 {
+			return &c.Tokens[i], nil
+		}
+	}
+	return nil, errors.New("Invalid token")
+}
+
+// caller identifies the client served by a ServerAPI.  token is nil if no
+// tokens are configured.
+type caller struct {
+	user   string
+	remote string
+	token  *tokenConfig
+}
+
+// owner identifies the transactions started by c, whose results can only
+// be retrieved by callers of the same user using the same token.
+func (c *caller) owner() string {
+	if c == nil {
+		return ""
+	}
+	o := c.user
+	if c.token != nil {
+		o += "/" + c.token.Name
+	}
+	return o
+}
+
+func (c *caller) allowed(op string, instanceNames []string) error {
+	if c == nil || c.token == nil {
+		return nil
+	}
+	t := c.token
+
+	opAllowed := false
+	for _, o := range t.Operations {
+		if o == "*" || o == op {
+			opAllowed = true
+			break
+		}
+	}
+	if !opAllowed {
+		return errors.Errorf("Token %s does not allow %s", t.Name, op)
+	}
+
+	if len(t.Prefixes) == 0 {
+		return nil
+	}
+	for _, name := range instanceNames {
+		if name == "" {
+			return errors.Errorf("Token %s requires instances to be named", t.Name)
+		}
+		nameAllowed := false
+		for _, p := range t.Prefixes {
+			if strings.HasPrefix(name, p) {
+				nameAllowed = true
+				break
+			}
+		}
+		if !nameAllowed {
+			return errors.Errorf("Token %s does not allow access to %s", t.Name, name)
+		}
+	}
+	return nil
+}
+
+// authorize checks that the client served by s may perform op on the
+// instances named, recording the request in the audit log if op is audited.
+// Requests that cannot be recorded are denied.
+func (s *ServerAPI) authorize(op string, instanceNames ...string) error {
+	err := s.caller.allowed(op, instanceNames)
+	if !auditedOperations[op] || s.audit == nil {
+		return err
+	}
+
+	rec := types.AuditRecord{
+		Time:      time.Now(),
+		Operation: op,
+		Instance:  strings.Join(instanceNames, ","),
+		Denied:    err != nil,
+	}
+	if s.caller != nil {
+		rec.User = s.caller.user
+		rec.Remote = s.caller.remote
+		if s.caller.token != nil {
+			rec.Token = s.caller.token.Name
+		}
+	}
+	if auditErr := s.audit.record(&rec); auditErr != nil {
+		logErrorf("Unable to record %s: %v", op, auditErr)
+		if err == nil {
+			err = errors.Wrap(auditErr, "Unable to record request in audit log")
+		}
+	}
+	return err
+}
+
+// withCaller returns a copy of s that serves c.
+func (s *ServerAPI) withCaller(c *caller) *ServerAPI {
+	api := *s
+	api.caller = c
+	return &api
+}
+
+// serveAPI serves an RPC connection to api, made on behalf of user, once the
+// client has presented a valid token, if tokens are required.
+func serveAPI(w http.ResponseWriter, r *http.Request, api *ServerAPI, cfg *authConfig, user string) {
+	c := &caller{user: user}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		c.remote = fmt.Sprintf("%s (%s)", r.RemoteAddr,
+			r.TLS.PeerCertificates[0].Subject.CommonName)
+	}
+
+	token, err := cfg.authenticate(r.Header.Get("Authorization"))
+	if err != nil {
+		logWarningf("Rejecting connection of %s: %v", user, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	c.token = token
+
+	srv := rpc.NewServer()
+	if err := srv.Register(api.withCaller(c)); err != nil {
+		http.Error(w, "Unable to register RPC API", http.StatusInternalServerError)
+		return
+	}
+	srv.ServeHTTP(w, r)
+}
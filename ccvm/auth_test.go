@@ -0,0 +1,214 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAuthenticate(t *testing.T) {
+	var cfg authConfig
+	if token, err := cfg.authenticate(""); err != nil || token != nil {
+		t.Errorf("Token required without tokens being configured")
+	}
+
+	cfg.Tokens = []tokenConfig{
+		{Name: "ci", SHA256: tokenHash("secret"), Operations: []string{"*"}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("Valid configuration rejected: %v", err)
+	}
+	if token, err := cfg.authenticate("Bearer secret"); err != nil || token == nil || token.Name != "ci" {
+		t.Errorf("Valid token rejected: %v", err)
+	}
+	if _, err := cfg.authenticate("Bearer wrong"); err == nil {
+		t.Errorf("Invalid token accepted")
+	}
+	if _, err := cfg.authenticate(""); err == nil {
+		t.Errorf("Missing token accepted")
+	}
+
+	cfg.Tokens[0].SHA256 = "secret"
+	if err := cfg.validate(); err == nil {
+		t.Errorf("Invalid hash accepted")
+	}
+}
+
+func TestCallerOwner(t *testing.T) {
+	var anonymous *caller
+	alice := &caller{user: "alice"}
+	ci := &caller{user: "alice", token: &tokenConfig{Name: "ci"}}
+	if anonymous.owner() != "" || alice.owner() == ci.owner() {
+		t.Errorf("Callers not distinguished: %q %q %q", anonymous.owner(), alice.owner(), ci.owner())
+	}
+	if ci.owner() != (&caller{user: "alice", remote: "10.0.0.1:4242", token: &tokenConfig{Name: "ci"}}).owner() {
+		t.Errorf("Connections of the same caller distinguished")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	start := time.Now()
+	token := &tokenConfig{
+		Name:       "ci",
+		Operations: []string{"Create", "Delete", "GetInstanceDetails"},
+		Prefixes:   []string{"ci-"},
+	}
+	api := (&ServerAPI{audit: newAuditLog(ccvmDir)}).withCaller(&caller{user: "alice", token: token})
+
+	if err := api.authorize("Create", "ci-1"); err != nil {
+		t.Errorf("Allowed operation denied: %v", err)
+	}
+	if err := api.authorize("Delete", "dev"); err == nil {
+		t.Errorf("Operation on instance without prefix allowed")
+	}
+	if err := api.authorize("GetInstanceDetails", ""); err == nil {
+		t.Errorf("Operation on unnamed instance allowed")
+	}
+	if err := api.authorize("Stop", "ci-1"); err == nil {
+		t.Errorf("Operation not allowed by token permitted")
+	}
+	if err := api.authorize("GetInstanceDetails", "ci-1"); err != nil {
+		t.Errorf("Allowed operation denied: %v", err)
+	}
+
+	records, err := api.audit.query(&types.AuditLogArgs{Since: start})
+	if err != nil {
+		t.Fatalf("Unable to query audit log: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 audit records, got %+v", records)
+	}
+	for i, denied := range []bool{false, true, true} {
+		r := records[i]
+		if r.Denied != denied || r.User != "alice" || r.Token != "ci" {
+			t.Errorf("Unexpected audit record %+v", r)
+		}
+	}
+
+	records, err = api.audit.query(&types.AuditLogArgs{Since: start, Instance: "dev"})
+	if err != nil || len(records) != 1 || records[0].Operation != "Delete" {
+		t.Errorf("Audit records not filtered by instance: %+v %v", records, err)
+	}
+	records, err = api.audit.query(&types.AuditLogArgs{Since: time.Now().Add(time.Hour)})
+	if err != nil || len(records) != 0 {
+		t.Errorf("Audit records not filtered by time: %+v %v", records, err)
+	}
+}
+
+func TestAuthorizePrefixes(t *testing.T) {
+	var wg sync.WaitGroup
+	api := &ServerAPI{
+		signalCh: make(chan os.Signal),
+		actionCh: make(chan interface{}),
+	}
+	ts := &testService{}
+
+	wg.Add(1)
+	go startTestAPIServer(ts, api, &wg, t)
+	defer func() {
+		close(api.signalCh)
+		wg.Wait()
+	}()
+
+	token := &tokenConfig{
+		Name:       "ci",
+		Operations: []string{"*"},
+		Prefixes:   []string{"vague-"},
+	}
+	limited := api.withCaller(&caller{user: "alice", token: token})
+
+	var id int
+	if err := limited.GetInstances(&types.InstancesArgs{}, &id); err != nil {
+		t.Fatalf("GetInstances failed: %v", err)
+	}
+	var instances []types.InstanceSummary
+	if err := limited.GetInstancesResult(id, &instances); err != nil {
+		t.Errorf("GetInstancesResult failed: %v", err)
+	} else if len(instances) != 1 || instances[0].Name != "vague-nimue" {
+		t.Errorf("Instances not filtered by prefix: %+v", instances)
+	}
+
+	for _, name := range []string{"", "dev"} {
+		if err := limited.CreateGroup(&types.CreateArgs{Name: name}, &id); err == nil {
+			t.Errorf("Creation of group %q allowed", name)
+		}
+	}
+	if err := limited.CreateGroup(&types.CreateArgs{Name: "vague-lab"}, &id); err != nil {
+		t.Errorf("Creation of group denied: %v", err)
+	} else {
+		for {
+			var res types.CreateResult
+			if err := limited.CreateGroupResult(id, &res); err != nil {
+				t.Errorf("CreateGroupResult failed: %v", err)
+				break
+			}
+			if res.Finished {
+				break
+			}
+		}
+	}
+
+	for _, ga := range groupActions(limited) {
+		if err := ga.action(&types.GroupArgs{Name: "dev"}, &id); err != nil {
+			t.Errorf("%s failed: %v", ga.name, err)
+		} else if err := ga.result(id, &struct{}{}); err == nil {
+			t.Errorf("%s allowed on group of other instances", ga.name)
+		}
+		if err := ga.action(&types.GroupArgs{Name: "vague-lab"}, &id); err != nil {
+			t.Errorf("%s failed: %v", ga.name, err)
+		} else if err := ga.result(id, &struct{}{}); err != nil {
+			t.Errorf("%s denied: %v", ga.name, err)
+		}
+	}
+
+	if err := limited.WatchEvents(&types.WatchEventsArgs{}, &id); err != nil {
+		t.Fatalf("WatchEvents failed: %v", err)
+	}
+	var event types.InstanceEvent
+	if err := limited.WatchEventsResult(id, &event); err == nil {
+		t.Errorf("Event of other instance delivered: %+v", event)
+	}
+
+	if err := limited.Report(&types.ReportArgs{}, &id); err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	var report types.ReportResult
+	if err := limited.ReportResult(id, &report); err != nil {
+		t.Errorf("ReportResult failed: %v", err)
+	} else if len(report.Instances) != 0 || report.Total.CPUSeconds != 0 {
+		t.Errorf("Report not filtered by prefix: %+v", report)
+	}
+}
@@ -0,0 +1,264 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The VMs of the instances whose autostart setting is on are started when
+// the service starts for the first time after the host has booted, as
+// recorded by the host's boot ID in the state store.
+
+const bootIDKey = "boot_id"
+
+// bootIDPath is the file from which the ID of the current boot of the host
+// is read.
+var bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// autostartEntry describes an instance to be started automatically.
+type autostartEntry struct {
+	name  string
+	order int
+	delay time.Duration
+}
+
+func newAutostartEntry(name string, in *types.VMSpec) autostartEntry {
+	delay, err := types.ParseAutostartDelay(in.AutostartDelay)
+	if err != nil {
+		instanceLog(name).Warningf("%v", err)
+	}
+	return autostartEntry{
+		name:  name,
+		order: in.AutostartOrder,
+		delay: delay,
+	}
+}
+
+// sortAutostart sorts entries in the order in which the instances are
+// started, by increasing order and then by name.
+func sortAutostart(entries []autostartEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].order != entries[j].order {
+			return entries[i].order < entries[j].order
+		}
+		return entries[i].name < entries[j].name
+	})
+}
+
+// autostartAction asks the service to start the VM of the instance name, if
+// it is not already running.  The result is sent to resultCh, which is
+// then closed.
+type autostartAction struct {
+	name     string
+	resultCh chan interface{}
+}
+
+// autostarter starts the VMs of the instances whose autostart setting is
+// on, once per boot of the host.
+type autostarter struct {
+	ccvmDir string
+
+	// done is closed once the VMs have been started.  It is nil if
+	// there were no VMs to start.
+	done chan struct{}
+}
+
+func newAutostarter(ccvmDir string) *autostarter {
+	return &autostarter{ccvmDir: ccvmDir}
+}
+
+func readBootID() (string, error) {
+	data, err := ioutil.ReadFile(bootIDPath)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to read boot ID")
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// booted returns the ID of the current boot of the host and whether the
+// VMs of the instances have yet to be started automatically since the host
+// booted.
+func (a *autostarter) booted() (string, bool) {
+	bootID, err := readBootID()
+	if err != nil {
+		logDebugf("Not starting instances automatically: %v", err)
+		return "", false
+	}
+
+	st, err := stateStore(a.ccvmDir)
+	if err != nil {
+		logErrorf("Unable to open state store: %v", err)
+		return "", false
+	}
+	var last string
+	_ = st.view(func(tx *storeTx) error {
+		_, err := tx.get(bucketDaemon, bootIDKey, &last)
+		return err
+	})
+	return bootID, last != bootID
+}
+
+// recordBoot records that the VMs of the instances have been started
+// automatically during the boot of the host bootID.
+func (a *autostarter) recordBoot(bootID string) {
+	st, err := stateStore(a.ccvmDir)
+	if err == nil {
+		err = st.update(func(tx *storeTx) error {
+			return tx.put(bucketDaemon, bootIDKey, bootID)
+		})
+	}
+	if err != nil {
+		logErrorf("Unable to record boot ID: %v", err)
+	}
+}
+
+// start starts the VMs of the instances described by entries, in order,
+// if the host has booted since they were last started.
+func (a *autostarter) start(ctx context.Context, actionCh chan<- interface{}, entries []autostartEntry,
+	wg *sync.WaitGroup) {
+	bootID, booted := a.booted()
+	if !booted {
+		return
+	}
+	if len(entries) == 0 {
+		a.recordBoot(bootID)
+		return
+	}
+
+	sortAutostart(entries)
+	a.done = make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(a.done)
+		if a.run(ctx, actionCh, entries) {
+			a.recordBoot(bootID)
+		}
+	}()
+}
+
+// run starts the VMs of the instances described by entries, one at a time,
+// and returns false if it was cancelled before they were all started.
+func (a *autostarter) run(ctx context.Context, actionCh chan<- interface{}, entries []autostartEntry) bool {
+	for i, e := range entries {
+		resultCh := make(chan interface{}, 1)
+		select {
+		case actionCh <- autostartAction{name: e.name, resultCh: resultCh}:
+		case <-ctx.Done():
+			return false
+		}
+
+		var err error
+		select {
+		case r := <-resultCh:
+			err, _ = r.(error)
+		case <-ctx.Done():
+			return false
+		}
+		if err != nil {
+			instanceLog(e.name).Warningf("Unable to start VM automatically: %v", err)
+			continue
+		}
+
+		if e.delay == 0 || i == len(entries)-1 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(e.delay):
+		}
+	}
+	return true
+}
+
+// starting returns true while the VMs of the instances are being started.
+func (a *autostarter) starting() bool {
+	if a.done == nil {
+		return false
+	}
+	select {
+	case <-a.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (c ccvmBackend) set(ctx context.Context, name string, settings map[string]string) error {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load instance state")
+	}
+
+	// autostart is applied first as turning it off resets the other
+	// autostart settings.
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := wkld.spec.VM.Set(k, settings[k]); err != nil {
+			return err
+		}
+	}
+
+	if err := wkld.save(ws.instanceDir); err != nil {
+		return errors.Wrap(err, "Unable to save instance state")
+	}
+
+	for _, k := range keys {
+		instanceLog(name).Infof("%s set to %s", k, settings[k])
+	}
+
+	return nil
+}
+
+func (s *ccvmService) set(ctx context.Context, args *types.SetArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:  instanceCmdOther,
+		resultCh: resultCh,
+		ctx:      ctx,
+		fn: func() error {
+			resultCh <- s.b.set(ctx, instanceName, args.Settings)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,96 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestSortAutostart(t *testing.T) {
+	entries := []autostartEntry{
+		newAutostartEntry("web", &types.VMSpec{AutostartOrder: 2}),
+		newAutostartEntry("db", &types.VMSpec{AutostartOrder: 1, AutostartDelay: "30s"}),
+		newAutostartEntry("cache", &types.VMSpec{AutostartOrder: 2}),
+		newAutostartEntry("dev", &types.VMSpec{}),
+	}
+	sortAutostart(entries)
+
+	expected := []string{"dev", "db", "cache", "web"}
+	for i := range expected {
+		if entries[i].name != expected[i] {
+			t.Fatalf("Unexpected order %v", entries)
+		}
+	}
+	if entries[1].delay != 30*time.Second {
+		t.Errorf("Unexpected delay %v", entries[1].delay)
+	}
+}
+
+func TestAutostartOncePerBoot(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	savedBootIDPath := bootIDPath
+	defer func() { bootIDPath = savedBootIDPath }()
+	bootIDPath = path.Join(ccvmDir, "boot_id")
+	writeBootID := func(id string) {
+		if err := ioutil.WriteFile(bootIDPath, []byte(id+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := newAutostarter(ccvmDir)
+	writeBootID("5f4b3b5e-first")
+	bootID, booted := a.booted()
+	if !booted || bootID != "5f4b3b5e-first" {
+		t.Fatalf("First boot not detected: %s", bootID)
+	}
+
+	entries := []autostartEntry{{name: "db"}, {name: "web"}}
+	actionCh := make(chan interface{})
+	go func() {
+		for a := range actionCh {
+			action := a.(autostartAction)
+			action.resultCh <- nil
+			close(action.resultCh)
+		}
+	}()
+	if !a.run(context.Background(), actionCh, entries) {
+		t.Fatalf("Autostart cancelled")
+	}
+	close(actionCh)
+	a.recordBoot(bootID)
+
+	if _, booted := a.booted(); booted {
+		t.Errorf("Instances started twice during the same boot")
+	}
+
+	writeBootID("5f4b3b5e-second")
+	if _, booted := a.booted(); !booted {
+		t.Errorf("Reboot not detected")
+	}
+}
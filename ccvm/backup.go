@@ -0,0 +1,765 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// A backup is a copy of the root disk of an instance, stored in
+// <dir>/<instance>/<id>, where id is the UTC time at which it was taken.
+
+const (
+	backupsDir        = "backups"
+	backupImageFile   = "image.qcow2"
+	backupRecordFile  = "backup.yaml"
+	backupPolicyFile  = "backup-policy.yaml"
+	backupIDFormat    = "20060102T150405Z"
+	backupJobID       = "ccloudvm-backup"
+	minBackupInterval = time.Hour
+	defaultBackupKeep = 7
+)
+
+var (
+	// backupCheckInterval is the interval at which the daemon looks for
+	// instances due to be backed up.
+	backupCheckInterval = time.Minute
+
+	// backupRetryInterval is the time after which a scheduled backup
+	// that failed is attempted again.
+	backupRetryInterval = time.Hour
+
+	// backupJobPollInterval is the interval at which the progress of
+	// the block jobs backing up running instances is checked.
+	backupJobPollInterval = time.Second
+)
+
+type backupRecord struct {
+	Created   time.Time `yaml:"created"`
+	Scheduled bool      `yaml:"scheduled"`
+	Encrypted bool      `yaml:"encrypted"`
+}
+
+type backupPolicy struct {
+	Interval string `yaml:"interval"`
+	Keep     int    `yaml:"keep"`
+	Dir      string `yaml:"dir,omitempty"`
+}
+
+// parseBackupInterval parses the interval of a backup policy.
+func parseBackupInterval(s string) (time.Duration, error) {
+	switch s {
+	case "hourly":
+		return time.Hour, nil
+	case "daily":
+		return 24 * time.Hour, nil
+	case "weekly":
+		return 7 * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.Errorf("Invalid backup interval %s, expected hourly, daily, weekly or a duration", s)
+	}
+	if d < minBackupInterval {
+		return 0, errors.Errorf("Backup interval %s is shorter than %v", s, minBackupInterval)
+	}
+	return d, nil
+}
+
+// loadBackupPolicy returns the backup policy of the instance whose directory
+// is instanceDir, or nil if the instance has no valid policy.
+func loadBackupPolicy(instanceDir string) *backupPolicy {
+	data, err := ioutil.ReadFile(path.Join(instanceDir, backupPolicyFile))
+	if err != nil {
+		return nil
+	}
+
+	var p backupPolicy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		logWarningf("Ignoring invalid backup policy in %s: %v", instanceDir, err)
+		return nil
+	}
+	if _, err := parseBackupInterval(p.Interval); err != nil || p.Keep < 1 {
+		logWarningf("Ignoring invalid backup policy in %s", instanceDir)
+		return nil
+	}
+	return &p
+}
+
+func (p *backupPolicy) interval() time.Duration {
+	d, _ := parseBackupInterval(p.Interval)
+	return d
+}
+
+// backupRoot returns the directory in which the backups of the instances
+// of ccvmDir are stored when dir is not specified.
+func backupRoot(ccvmDir, dir string) string {
+	if dir != "" {
+		return dir
+	}
+	return path.Join(ccvmDir, backupsDir)
+}
+
+// listInstanceBackups returns the backups of the instance name stored in
+// root, oldest first.
+func listInstanceBackups(root, name string) ([]types.BackupInfo, error) {
+	instanceBackups := path.Join(root, name)
+	entries, err := ioutil.ReadDir(instanceBackups)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read %s", instanceBackups)
+	}
+
+	backups := make([]types.BackupInfo, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		backupDir := path.Join(instanceBackups, e.Name())
+		data, err := ioutil.ReadFile(path.Join(backupDir, backupRecordFile))
+		if err != nil {
+			continue
+		}
+		var rec backupRecord
+		if err := yaml.Unmarshal(data, &rec); err != nil {
+			logWarningf("Ignoring invalid backup %s: %v", backupDir, err)
+			continue
+		}
+		fi, err := os.Stat(path.Join(backupDir, backupImageFile))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, types.BackupInfo{
+			Instance:  name,
+			ID:        e.Name(),
+			Path:      backupDir,
+			Created:   rec.Created,
+			SizeBytes: fi.Size(),
+			Scheduled: rec.Scheduled,
+			Encrypted: rec.Encrypted,
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Created.Before(backups[j].Created)
+	})
+	return backups, nil
+}
+
+// lastScheduledBackup returns the time of the most recent scheduled backup
+// of the instance name stored in root.
+func lastScheduledBackup(root, name string) (time.Time, bool) {
+	backups, err := listInstanceBackups(root, name)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for i := len(backups) - 1; i >= 0; i-- {
+		if backups[i].Scheduled {
+			return backups[i].Created, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// pruneBackups deletes the oldest scheduled backups of the instance name
+// stored in root so that only keep of them are left.  Backups taken on
+// request are never deleted.
+func pruneBackups(root, name string, keep int) error {
+	backups, err := listInstanceBackups(root, name)
+	if err != nil {
+		return err
+	}
+
+	scheduled := make([]types.BackupInfo, 0, len(backups))
+	for _, b := range backups {
+		if b.Scheduled {
+			scheduled = append(scheduled, b)
+		}
+	}
+
+	for i := 0; i < len(scheduled)-keep; i++ {
+		instanceLog(name).Infof("Deleting backup %s", scheduled[i].ID)
+		if err := os.RemoveAll(scheduled[i].Path); err != nil {
+			return errors.Wrapf(err, "Unable to delete backup %s", scheduled[i].ID)
+		}
+	}
+	return nil
+}
+
+// copyDisk copies the disk image src to dst, preserving its holes.
+func copyDisk(ctx context.Context, src, dst string) error {
+	out, err := exec.CommandContext(ctx, "cp", "--sparse=always", src, dst).CombinedOutput()
+	if err != nil {
+		_ = os.Remove(dst)
+		return errors.Wrapf(err, "Unable to copy %s: %s", src, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// qmpBlockDevice returns the name of the block device through which the VM
+// of the instance whose directory is instanceDir accesses image.
+func qmpBlockDevice(ctx context.Context, instanceDir, image string) (string, error) {
+	ret, err := qmpExecute(ctx, instanceDir, "query-block", nil)
+	if err != nil {
+		return "", err
+	}
+
+	var blocks []struct {
+		Device   string `json:"device"`
+		Inserted *struct {
+			File string `json:"file"`
+		} `json:"inserted"`
+	}
+	if err := json.Unmarshal(ret, &blocks); err != nil {
+		return "", errors.Wrap(err, "Unable to parse block devices")
+	}
+	for _, b := range blocks {
+		if b.Inserted != nil && b.Inserted.File == image {
+			return b.Device, nil
+		}
+	}
+	return "", errors.Errorf("No block device for %s", image)
+}
+
+// qmpBackupDisk copies the top image of the disk image of a running VM to
+// target, which refers to the same backing image, using a drive-backup
+// block job, and waits for the job to complete.
+func qmpBackupDisk(ctx context.Context, instanceDir, image, target string) error {
+	device, err := qmpBlockDevice(ctx, instanceDir, image)
+	if err != nil {
+		return err
+	}
+
+	// A job left behind by a backup that was interrupted would prevent
+	// a new job with the same ID from being started.
+	dismiss := map[string]interface{}{"id": backupJobID}
+	_, _ = qmpExecute(ctx, instanceDir, "job-dismiss", dismiss)
+
+	_, err = qmpExecute(ctx, instanceDir, "drive-backup", map[string]interface{}{
+		"job-id":       backupJobID,
+		"device":       device,
+		"target":       target,
+		"format":       "qcow2",
+		"sync":         "top",
+		"mode":         "absolute-paths",
+		"auto-dismiss": false,
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			cancelCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			_, _ = qmpExecute(cancelCtx, instanceDir, "job-cancel", dismiss)
+			cancel()
+			return ctx.Err()
+		case <-time.After(backupJobPollInterval):
+		}
+
+		ret, err := qmpExecute(ctx, instanceDir, "query-jobs", nil)
+		if err != nil {
+			return err
+		}
+		var jobs []struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		if err := json.Unmarshal(ret, &jobs); err != nil {
+			return errors.Wrap(err, "Unable to parse block jobs")
+		}
+
+		for _, j := range jobs {
+			if j.ID != backupJobID || j.Status != "concluded" {
+				continue
+			}
+			_, _ = qmpExecute(ctx, instanceDir, "job-dismiss", dismiss)
+			if j.Error != "" {
+				return errors.Errorf("Backup job failed: %s", j.Error)
+			}
+			return nil
+		}
+	}
+}
+
+func (c ccvmBackend) backup(ctx context.Context, name, dir string, scheduled bool) (*types.BackupInfo, error) {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy *backupPolicy
+	if scheduled {
+		policy = loadBackupPolicy(ws.instanceDir)
+		if policy == nil {
+			return nil, errors.New("The instance does not have a backup policy")
+		}
+		dir = policy.Dir
+	}
+	if dir != "" {
+		if err := ws.checkUserPath(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	vmImage := path.Join(ws.instanceDir, "image.qcow2")
+	if _, err := os.Stat(vmImage); err != nil {
+		return nil, errors.New("Only instances with qcow2 disks can be backed up")
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to load instance state")
+	}
+
+	hv, err := c.instanceHypervisor(ws)
+	if err != nil {
+		return nil, err
+	}
+	running := hv.running(ctx, ws.instanceDir)
+	if running {
+		if h := wkld.spec.VM.Hypervisor; h != "" && h != hypervisorQemu {
+			return nil, errors.Errorf("Running %s instances cannot be backed up", h)
+		}
+		if wkld.spec.VM.Encrypt {
+			return nil, errors.New("The instance must be stopped before its encrypted disk can be backed up")
+		}
+	}
+
+	root := backupRoot(ws.ccvmDir, dir)
+	instanceBackups := path.Join(root, name)
+	if err := os.MkdirAll(instanceBackups, 0755); err != nil {
+		return nil, errors.Wrapf(err, "Unable to create %s", instanceBackups)
+	}
+
+	now := time.Now().UTC()
+	id := now.Format(backupIDFormat)
+	backupDir := path.Join(instanceBackups, id)
+	if err := os.Mkdir(backupDir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "Unable to create backup %s", id)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(backupDir)
+		}
+	}()
+
+	instanceLog(name).Infof("Backing up to %s", backupDir)
+	image := path.Join(backupDir, backupImageFile)
+	if running {
+		err = qmpBackupDisk(ctx, ws.instanceDir, vmImage, image)
+	} else {
+		err = copyDisk(ctx, vmImage, image)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to back up %s", name)
+	}
+
+	rec := backupRecord{
+		Created:   now,
+		Scheduled: scheduled,
+		Encrypted: wkld.spec.VM.Encrypt,
+	}
+	data, err := yaml.Marshal(&rec)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to marshal backup record")
+	}
+	if err = writeFileAtomic(path.Join(backupDir, backupRecordFile), data); err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to stat %s", image)
+	}
+
+	if dir != "" {
+		err = ws.giveToUser(instanceBackups, backupDir, image,
+			path.Join(backupDir, backupRecordFile))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if scheduled {
+		if err := pruneBackups(root, name, policy.Keep); err != nil {
+			instanceLog(name).Warningf("%v", err)
+		}
+	}
+
+	return &types.BackupInfo{
+		Instance:  name,
+		ID:        id,
+		Path:      backupDir,
+		Created:   now,
+		SizeBytes: fi.Size(),
+		Scheduled: scheduled,
+		Encrypted: rec.Encrypted,
+	}, nil
+}
+
+func (c ccvmBackend) listBackups(ctx context.Context, name, dir string) (*types.BackupList, error) {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &types.BackupList{}
+	if p := loadBackupPolicy(ws.instanceDir); p != nil {
+		list.Policy = &types.BackupPolicy{
+			Name:     name,
+			Interval: p.Interval,
+			Keep:     p.Keep,
+			Dir:      p.Dir,
+		}
+		if dir == "" {
+			dir = p.Dir
+		}
+	}
+
+	if dir != "" {
+		if err := ws.checkUserPath(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	list.Backups, err = listInstanceBackups(backupRoot(ws.ccvmDir, dir), name)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (c ccvmBackend) restoreBackup(ctx context.Context, args *types.RestoreArgs) error {
+	ws, err := prepareEnv(ctx, args.Name)
+	if err != nil {
+		return err
+	}
+
+	dir := args.Dir
+	if dir == "" {
+		if p := loadBackupPolicy(ws.instanceDir); p != nil {
+			dir = p.Dir
+		}
+	}
+	if dir != "" {
+		if err := ws.checkUserPath(dir); err != nil {
+			return err
+		}
+	}
+
+	hv, err := c.instanceHypervisor(ws)
+	if err != nil {
+		return err
+	}
+	if hv.running(ctx, ws.instanceDir) {
+		return errors.New("The instance must be stopped before a backup can be restored")
+	}
+	if err := checkNotSuspended(ws.instanceDir); err != nil {
+		return err
+	}
+
+	vmImage := path.Join(ws.instanceDir, "image.qcow2")
+	if _, err := os.Stat(vmImage); err != nil {
+		return errors.New("Only instances with qcow2 disks can be restored")
+	}
+
+	if args.ID == "" || filepath.Base(args.ID) != args.ID {
+		return errors.Errorf("Invalid backup %s", args.ID)
+	}
+	backupDir := path.Join(backupRoot(ws.ccvmDir, dir), args.Name, args.ID)
+	data, err := ioutil.ReadFile(path.Join(backupDir, backupRecordFile))
+	if err != nil {
+		return errors.Errorf("Backup %s of %s does not exist", args.ID, args.Name)
+	}
+	var rec backupRecord
+	if err := yaml.Unmarshal(data, &rec); err != nil {
+		return errors.Wrapf(err, "Invalid backup %s", args.ID)
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load instance state")
+	}
+	if rec.Encrypted != wkld.spec.VM.Encrypt {
+		return errors.Errorf("The encryption of backup %s does not match the disk of %s",
+			args.ID, args.Name)
+	}
+
+	// The backup is only usable if the base image it refers to is still
+	// in the cache.
+	image := path.Join(backupDir, backupImageFile)
+	if _, err := imageChain(ctx, image); err != nil {
+		return errors.Wrapf(err, "Backup %s cannot be restored", args.ID)
+	}
+
+	tmp := vmImage + ".restore"
+	if err := copyDisk(ctx, image, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, vmImage); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrapf(err, "Unable to restore backup %s", args.ID)
+	}
+	_ = os.Remove(path.Join(ws.instanceDir, diskCheckFile))
+
+	instanceLog(args.Name).Infof("Restored backup %s", args.ID)
+	return nil
+}
+
+func (c ccvmBackend) setBackupPolicy(ctx context.Context, p *types.BackupPolicy) error {
+	ws, err := prepareEnv(ctx, p.Name)
+	if err != nil {
+		return err
+	}
+
+	policyPath := path.Join(ws.instanceDir, backupPolicyFile)
+	if p.Off {
+		if err := os.Remove(policyPath); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "Unable to remove backup policy")
+		}
+		return nil
+	}
+
+	if _, err := parseBackupInterval(p.Interval); err != nil {
+		return err
+	}
+	keep := p.Keep
+	if keep == 0 {
+		keep = defaultBackupKeep
+	}
+	if keep < 1 {
+		return errors.Errorf("Invalid number of backups to keep %d", p.Keep)
+	}
+	if p.Dir != "" {
+		if !filepath.IsAbs(p.Dir) {
+			return errors.Errorf("Backup directory %s is not an absolute path", p.Dir)
+		}
+		if err := ws.checkUserPath(p.Dir); err != nil {
+			return err
+		}
+	}
+
+	data, err := yaml.Marshal(&backupPolicy{
+		Interval: p.Interval,
+		Keep:     keep,
+		Dir:      p.Dir,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal backup policy")
+	}
+	return writeFileAtomic(policyPath, data)
+}
+
+// backupAction asks the service to take a scheduled backup of the instance
+// name.  The result of the backup is sent to resultCh, which is then
+// closed.
+type backupAction struct {
+	name     string
+	resultCh chan interface{}
+}
+
+// backupScheduler takes the backups of the instances whose backup policies
+// require it, one at a time.
+type backupScheduler struct {
+	ccvmDir string
+
+	// failed contains the times at which the last scheduled backups of
+	// instances failed.
+	failed map[string]time.Time
+}
+
+func newBackupScheduler(ccvmDir string) *backupScheduler {
+	return &backupScheduler{
+		ccvmDir: ccvmDir,
+		failed:  make(map[string]time.Time),
+	}
+}
+
+// due returns the names of the instances that are due to be backed up.
+func (b *backupScheduler) due(now time.Time) []string {
+	instancesDir := path.Join(b.ccvmDir, "instances")
+	entries, err := ioutil.ReadDir(instancesDir)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		p := loadBackupPolicy(path.Join(instancesDir, name))
+		if p == nil {
+			continue
+		}
+		if t, ok := b.failed[name]; ok && now.Sub(t) < backupRetryInterval {
+			continue
+		}
+		last, ok := lastScheduledBackup(backupRoot(b.ccvmDir, p.Dir), name)
+		if !ok || now.Sub(last) >= p.interval() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (b *backupScheduler) run(ctx context.Context, actionCh chan<- interface{}) {
+	for {
+		for _, name := range b.due(time.Now()) {
+			resultCh := make(chan interface{}, 1)
+			select {
+			case actionCh <- backupAction{name: name, resultCh: resultCh}:
+			case <-ctx.Done():
+				return
+			}
+
+			var err error
+			select {
+			case r := <-resultCh:
+				err, _ = r.(error)
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				instanceLog(name).Warningf("Scheduled backup failed: %v", err)
+				b.failed[name] = time.Now()
+			} else {
+				delete(b.failed, name)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backupCheckInterval):
+		}
+	}
+}
+
+func (s *ccvmService) backup(ctx context.Context, args *types.BackupArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:  instanceCmdOther,
+		resultCh: resultCh,
+		ctx:      ctx,
+		fn: func() error {
+			info, err := s.b.backup(ctx, instanceName, args.Dir, false)
+			if err != nil {
+				resultCh <- err
+			} else {
+				resultCh <- *info
+			}
+			return nil
+		},
+	}
+}
+
+func (s *ccvmService) listBackups(ctx context.Context, args *types.BackupListArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:  instanceCmdOther,
+		resultCh: resultCh,
+		ctx:      ctx,
+		fn: func() error {
+			list, err := s.b.listBackups(ctx, instanceName, args.Dir)
+			if err != nil {
+				resultCh <- err
+			} else {
+				resultCh <- *list
+			}
+			return nil
+		},
+	}
+}
+
+func (s *ccvmService) restoreBackup(ctx context.Context, args *types.RestoreArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	restoreArgs := *args
+	restoreArgs.Name = instanceName
+	instanceCh <- instanceCmd{
+		cmdType:  instanceCmdOther,
+		resultCh: resultCh,
+		ctx:      ctx,
+		fn: func() error {
+			resultCh <- s.b.restoreBackup(ctx, &restoreArgs)
+			return nil
+		},
+	}
+}
+
+func (s *ccvmService) setBackupPolicy(ctx context.Context, args *types.BackupPolicy, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	policy := *args
+	policy.Name = instanceName
+	instanceCh <- instanceCmd{
+		cmdType:  instanceCmdOther,
+		resultCh: resultCh,
+		ctx:      ctx,
+		fn: func() error {
+			resultCh <- s.b.setBackupPolicy(ctx, &policy)
+			return nil
+		},
+	}
+}
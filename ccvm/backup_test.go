@@ -0,0 +1,150 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestParseBackupInterval(t *testing.T) {
+	tests := []struct {
+		interval string
+		expected time.Duration
+		valid    bool
+	}{
+		{"hourly", time.Hour, true},
+		{"daily", 24 * time.Hour, true},
+		{"weekly", 7 * 24 * time.Hour, true},
+		{"12h", 12 * time.Hour, true},
+		{"10m", 0, false},
+		{"monthly", 0, false},
+	}
+	for _, test := range tests {
+		d, err := parseBackupInterval(test.interval)
+		if (err == nil) != test.valid {
+			t.Errorf("Unexpected result for %s: %v", test.interval, err)
+		} else if d != test.expected {
+			t.Errorf("%s parsed as %v, expected %v", test.interval, d, test.expected)
+		}
+	}
+}
+
+func writeTestBackup(t *testing.T, root, name string, created time.Time, scheduled bool) {
+	backupDir := path.Join(root, name, created.UTC().Format(backupIDFormat))
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := yaml.Marshal(&backupRecord{Created: created, Scheduled: scheduled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(backupDir, backupRecordFile), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(backupDir, backupImageFile), []byte("QFI"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	root, err := ioutil.TempDir("", "ccloudvm-backups-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(root) }()
+
+	now := time.Now()
+	writeTestBackup(t, root, "dev", now.Add(-72*time.Hour), true)
+	writeTestBackup(t, root, "dev", now.Add(-60*time.Hour), false)
+	writeTestBackup(t, root, "dev", now.Add(-48*time.Hour), true)
+	writeTestBackup(t, root, "dev", now.Add(-24*time.Hour), true)
+
+	if err := pruneBackups(root, "dev", 2); err != nil {
+		t.Fatalf("Unable to prune backups: %v", err)
+	}
+
+	backups, err := listInstanceBackups(root, "dev")
+	if err != nil {
+		t.Fatalf("Unable to list backups: %v", err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("Expected 3 backups, found %d", len(backups))
+	}
+	if backups[0].Scheduled {
+		t.Errorf("Backup taken on request was deleted")
+	}
+	for i := 1; i < len(backups); i++ {
+		if !backups[i-1].Created.Before(backups[i].Created) {
+			t.Errorf("Backups are not sorted")
+		}
+	}
+
+	last, ok := lastScheduledBackup(root, "dev")
+	if !ok || !last.Equal(backups[2].Created) {
+		t.Errorf("Unexpected last scheduled backup %v", last)
+	}
+}
+
+func TestBackupSchedulerDue(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	policy, err := yaml.Marshal(&backupPolicy{Interval: "daily", Keep: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"fresh", "stale", "never", "manual"} {
+		instanceDir := path.Join(ccvmDir, "instances", name)
+		if err := os.MkdirAll(instanceDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if name == "manual" {
+			continue
+		}
+		err := ioutil.WriteFile(path.Join(instanceDir, backupPolicyFile), policy, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	root := backupRoot(ccvmDir, "")
+	writeTestBackup(t, root, "fresh", now.Add(-time.Hour), true)
+	writeTestBackup(t, root, "stale", now.Add(-25*time.Hour), true)
+	writeTestBackup(t, root, "stale", now.Add(-time.Hour), false)
+
+	b := newBackupScheduler(ccvmDir)
+	due := b.due(now)
+	if len(due) != 2 || due[0] != "never" || due[1] != "stale" {
+		t.Errorf("Unexpected instances due to be backed up %v", due)
+	}
+
+	b.failed["stale"] = now.Add(-time.Minute)
+	due = b.due(now)
+	if len(due) != 1 || due[0] != "never" {
+		t.Errorf("Failed backup retried too early %v", due)
+	}
+}
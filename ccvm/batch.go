@@ -0,0 +1,176 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Batch requests apply an action to several instances at once, by queuing
+// it on the loop of each instance, and report the outcome for each of them.
+
+// batchOperation returns the operation, as named by the tokens that allow
+// it, performed on each instance by a batch action.
+func batchOperation(action string) (string, error) {
+	switch action {
+	case types.BatchStart:
+		return "Start", nil
+	case types.BatchStop:
+		return "Stop", nil
+	case types.BatchQuit:
+		return "Quit", nil
+	case types.BatchDelete:
+		return "Delete", nil
+	}
+	return "", errors.Errorf("Unknown batch action %s", action)
+}
+
+// isBatchPattern indicates whether name is a shell pattern rather than the
+// name of an instance.
+func isBatchPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// batchInstances returns the sorted names of the instances, among
+// instances, selected by args.  Patterns only match the instances for which
+// allowed returns true.  Names of instances that do not exist and patterns
+// that match no instance are errors.
+func batchInstances(instances []string, args *types.BatchArgs, allowed func(string) bool) ([]string, error) {
+	if args.All && len(args.Names) > 0 {
+		return nil, errors.New("Instance names cannot be given with --all")
+	}
+	if !args.All && len(args.Names) == 0 {
+		return nil, errors.New("Please specify instance names or --all")
+	}
+
+	exists := make(map[string]bool, len(instances))
+	for _, name := range instances {
+		exists[name] = true
+	}
+
+	selected := make(map[string]bool)
+	if args.All {
+		for _, name := range instances {
+			if allowed(name) {
+				selected[name] = true
+			}
+		}
+	}
+	for _, n := range args.Names {
+		if !isBatchPattern(n) {
+			if !exists[n] {
+				return nil, errors.Errorf("Instance %s does not exist", n)
+			}
+			selected[n] = true
+			continue
+		}
+
+		matched := false
+		for _, name := range instances {
+			ok, err := path.Match(n, name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid pattern %s", n)
+			}
+			if ok && allowed(name) {
+				selected[name] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, errors.Errorf("No instance matches %s", n)
+		}
+	}
+
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// batchOutcome waits for the command queued on the loop of an instance by a
+// batch request to complete and returns its error.  The queue positions
+// reported meanwhile are ignored.
+func batchOutcome(ch chan interface{}) error {
+	var err error
+	for v := range ch {
+		if e, ok := v.(error); ok {
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *ccvmService) batch(ctx context.Context, args *types.BatchArgs, allowed func(string) bool,
+	resultCh chan interface{}) {
+	instances := make([]string, 0, len(s.instances))
+	for name := range s.instances {
+		instances = append(instances, name)
+	}
+	names, err := batchInstances(instances, args, allowed)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+
+	// The outcomes are collected before the commands are queued as the
+	// instance loops may block until the results of the commands they
+	// reject are read.
+	results := make([]types.BatchInstanceResult, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		instanceResult := make(chan interface{})
+		results[i].Name = name
+		wg.Add(1)
+		go func(r *types.BatchInstanceResult) {
+			if err := batchOutcome(instanceResult); err != nil {
+				r.Error = err.Error()
+			}
+			wg.Done()
+		}(&results[i])
+
+		switch args.Action {
+		case types.BatchStart:
+			s.start(ctx, name, &args.VMSpec, args.Force, instanceResult)
+		case types.BatchStop:
+			s.stop(ctx, &types.StopArgs{
+				Name:    name,
+				Timeout: args.Timeout,
+				Force:   args.Force,
+			}, instanceResult)
+		case types.BatchQuit:
+			s.quit(ctx, name, instanceResult)
+		case types.BatchDelete:
+			s.delete(ctx, name, instanceResult)
+		}
+	}
+
+	go func() {
+		wg.Wait()
+		resultCh <- types.BatchResult{Instances: results}
+		close(resultCh)
+	}()
+}
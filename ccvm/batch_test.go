@@ -0,0 +1,70 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestBatchInstances(t *testing.T) {
+	instances := []string{"web-2", "db-1", "web-1", "dev-box"}
+	all := func(string) bool { return true }
+	noDev := func(name string) bool { return !strings.HasPrefix(name, "dev-") }
+
+	tests := []struct {
+		args    types.BatchArgs
+		allowed func(string) bool
+		names   []string
+	}{
+		{types.BatchArgs{All: true}, all, []string{"db-1", "dev-box", "web-1", "web-2"}},
+		{types.BatchArgs{All: true}, noDev, []string{"db-1", "web-1", "web-2"}},
+		{types.BatchArgs{Names: []string{"web-*"}}, all, []string{"web-1", "web-2"}},
+		{types.BatchArgs{Names: []string{"web-1", "web-?", "db-1"}}, all, []string{"db-1", "web-1", "web-2"}},
+		{types.BatchArgs{Names: []string{"*-[12]"}}, all, []string{"db-1", "web-1", "web-2"}},
+		{types.BatchArgs{Names: []string{"dev-box"}}, noDev, []string{"dev-box"}},
+	}
+	for _, tt := range tests {
+		names, err := batchInstances(instances, &tt.args, tt.allowed)
+		if err != nil {
+			t.Errorf("Unexpected error for %+v: %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("Expected %v for %+v, got %v", tt.names, tt.args, names)
+		}
+	}
+
+	bad := []types.BatchArgs{
+		{},
+		{All: true, Names: []string{"web-1"}},
+		{Names: []string{"web-3"}},
+		{Names: []string{"app-*"}},
+		{Names: []string{"[web"}},
+	}
+	for _, args := range bad {
+		if _, err := batchInstances(instances, &args, all); err == nil {
+			t.Errorf("Expected %+v to be rejected", args)
+		}
+	}
+	if _, err := batchInstances(instances, &types.BatchArgs{Names: []string{"dev-*"}}, noDev); err == nil {
+		t.Errorf("Expected pattern matching only disallowed instances to be rejected")
+	}
+}
@@ -0,0 +1,313 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Shared caches are directories of the caches directory, shared over 9p
+// with the instances that use them.
+
+const (
+	cachesDir      = "caches"
+	cacheTagPrefix = "ccvm-cache-"
+
+	cacheScriptPath = "/usr/local/sbin/ccvm-cache"
+	cacheConfPath   = "/etc/ccloudvm/caches"
+	cacheUnitPath   = "/etc/systemd/system/ccvm-cache.service"
+)
+
+// cacheScript mounts the caches listed in cacheConfPath and publishes the
+// files added to them.  Locks, partial downloads and temporary files are
+// not published.
+const cacheScript = `#!/bin/sh
+conf=` + cacheConfPath + `
+state=/var/lib/ccloudvm/caches
+
+mount_caches() {
+	while read -r tag dir owner; do
+		[ -n "$tag" ] || continue
+		# The parents of the caches of the user's home belong to the user.
+		[ "$owner" = root ] || runuser -u "$owner" -- mkdir -p "$dir"
+		mkdir -p "$state/$tag/shared" "$state/$tag/upper" "$state/$tag/work" "$dir"
+		mountpoint -q "$state/$tag/shared" ||
+			mount -t 9p -o trans=virtio,version=9p2000.L "$tag" "$state/$tag/shared" || continue
+		chown "$owner:" "$state/$tag/upper" "$dir"
+		mountpoint -q "$dir" ||
+			mount -t overlay overlay -o "lowerdir=$state/$tag/shared,upperdir=$state/$tag/upper,workdir=$state/$tag/work" "$dir"
+		# dnf deletes the packages it has installed unless told otherwise.
+		if [ "$dir" = /var/cache/dnf ] && [ -f /etc/dnf/dnf.conf ] &&
+			! grep -q '^keepcache' /etc/dnf/dnf.conf; then
+			sed -i '/^\[main\]/a keepcache=1' /etc/dnf/dnf.conf
+		fi
+	done < "$conf"
+}
+
+publish_caches() {
+	while read -r tag dir owner; do
+		[ -n "$tag" ] || continue
+		shared=$state/$tag/shared
+		mountpoint -q "$shared" || continue
+		(cd "$state/$tag/upper" && find . -type f ! -name lock ! -name '*.lock' \
+			! -name '*.tmp*' ! -path '*/partial/*') | while read -r f; do
+			[ -e "$shared/$f" ] && continue
+			tmp="$shared/$f.ccvm-$$"
+			mkdir -p "$(dirname "$shared/$f")" &&
+				cp "$state/$tag/upper/$f" "$tmp" && mv -f "$tmp" "$shared/$f" ||
+				rm -f "$tmp"
+		done
+	done < "$conf"
+}
+
+case "$1" in
+mount) mount_caches ;;
+publish) publish_caches ;;
+*) echo "Usage: ccvm-cache mount|publish" >&2; exit 1 ;;
+esac
+`
+
+// cacheUnit mounts the caches each time the instance boots and publishes
+// the files added to them when it is shut down.
+const cacheUnit = `[Unit]
+Description=ccloudvm shared caches
+After=local-fs.target network.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=` + cacheScriptPath + ` mount
+ExecStop=` + cacheScriptPath + ` publish
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// cacheSetupCmd mounts the caches before the workload's commands are run
+// during the instance's creation.
+const cacheSetupCmd = "systemctl daemon-reload && systemctl enable --now ccvm-cache.service"
+
+// cachePublishCmd publishes the files added to the caches during the
+// instance's creation.
+const cachePublishCmd = cacheScriptPath + " publish"
+
+func cacheDir(ccvmDir, name string) string {
+	return path.Join(ccvmDir, cachesDir, name)
+}
+
+func cacheTag(name string) string {
+	return cacheTagPrefix + name
+}
+
+// cacheGuestPath returns the directory of the guest in which c appears,
+// and the user that owns it, the directories of the home directory of user
+// being owned by user.
+func cacheGuestPath(c *types.Cache, user string) (string, string) {
+	p := c.GuestPath()
+	if strings.HasPrefix(p, "~/") {
+		return path.Join("/home", user, p[2:]), user
+	}
+	return path.Clean(p), "root"
+}
+
+// checkCaches verifies that the caches of a VM are valid and that no two
+// of them share a name or a guest path.
+func checkCaches(in *types.VMSpec) error {
+	names := make(map[string]struct{})
+	paths := make(map[string]string)
+	for i := range in.Caches {
+		c := &in.Caches[i]
+		if err := c.Check(); err != nil {
+			return err
+		}
+		if _, ok := names[c.Name]; ok {
+			return errors.Errorf("Cache %s is listed twice", c.Name)
+		}
+		names[c.Name] = struct{}{}
+		p, _ := cacheGuestPath(c, "")
+		if other, ok := paths[p]; ok {
+			return errors.Errorf("Caches %s and %s have the same path %s", other, c.Name, p)
+		}
+		paths[p] = c.Name
+	}
+	return nil
+}
+
+// cacheConfig returns the contents of cacheConfPath for the caches of a VM
+// whose user is user.
+func cacheConfig(in *types.VMSpec, user string) string {
+	var b bytes.Buffer
+	for i := range in.Caches {
+		p, owner := cacheGuestPath(&in.Caches[i], user)
+		fmt.Fprintf(&b, "%s %s %s\n", cacheTag(in.Caches[i].Name), p, owner)
+	}
+	return b.String()
+}
+
+// cacheArgs returns the qemu arguments that share the caches of a VM with
+// it, creating the cache directories that do not exist yet.
+func cacheArgs(ccvmDir string, in *types.VMSpec) ([]string, error) {
+	var args []string
+	for i, c := range in.Caches {
+		dir := cacheDir(ccvmDir, c.Name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, errors.Wrapf(err, "Unable to create cache %s", c.Name)
+		}
+		args = append(args,
+			"-fsdev", fmt.Sprintf("local,security_model=none,id=fscache%d,path=%s", i, dir),
+			"-device", fmt.Sprintf("virtio-9p-pci,id=cache%[1]d,fsdev=fscache%[1]d,mount_tag=%s",
+				i, cacheTag(c.Name)))
+	}
+	return args, nil
+}
+
+// cacheUsers returns the names of the instances that use each cache,
+// sorted by name.
+func cacheUsers(ctx context.Context, ccvmDir string) map[string][]string {
+	users := make(map[string][]string)
+	files, err := ioutil.ReadDir(path.Join(ccvmDir, "instances"))
+	if err != nil {
+		return users
+	}
+
+	for _, fi := range files {
+		if !fi.IsDir() {
+			continue
+		}
+		ws, err := prepareEnv(ctx, fi.Name())
+		if err != nil {
+			continue
+		}
+		wkld, err := restoreWorkload(ws)
+		if err != nil {
+			continue
+		}
+		for _, c := range wkld.spec.VM.Caches {
+			users[c.Name] = append(users[c.Name], fi.Name())
+		}
+	}
+
+	for _, u := range users {
+		sort.Strings(u)
+	}
+	return users
+}
+
+// listCaches returns the shared caches, sorted by name.
+func (c ccvmBackend) listCaches(ctx context.Context) ([]types.CacheInfo, error) {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(path.Join(ws.ccvmDir, cachesDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "Unable to read caches directory")
+	}
+
+	users := cacheUsers(ctx, ws.ccvmDir)
+	var caches []types.CacheInfo
+	for _, fi := range files {
+		if !fi.IsDir() {
+			continue
+		}
+		info := types.CacheInfo{
+			Name:      fi.Name(),
+			Instances: users[fi.Name()],
+		}
+		_ = filepath.Walk(cacheDir(ws.ccvmDir, fi.Name()), func(p string, fi os.FileInfo, err error) error {
+			if err == nil && fi.Mode().IsRegular() {
+				info.SizeBytes += fi.Size()
+				info.Files++
+			}
+			return nil
+		})
+		caches = append(caches, info)
+	}
+
+	return caches, nil
+}
+
+// clearCache deletes the contents of the shared cache called name.  The
+// caches used by running instances cannot be cleared, as their overlays
+// would be left referring to deleted files.
+func (c ccvmBackend) clearCache(ctx context.Context, name string) error {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	if err := types.CheckCacheName(name); err != nil {
+		return err
+	}
+
+	dir := cacheDir(ws.ccvmDir, name)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return errors.Errorf("Cache %s does not exist", name)
+	}
+
+	for _, instance := range cacheUsers(ctx, ws.ccvmDir)[name] {
+		iws, err := prepareEnv(ctx, instance)
+		if err != nil {
+			return err
+		}
+		hv, err := c.instanceHypervisor(iws)
+		if err != nil {
+			return err
+		}
+		if hv.running(ctx, iws.instanceDir) {
+			return errors.Errorf("Cache %s is used by %s, which is running", name, instance)
+		}
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Wrapf(err, "Unable to clear cache %s", name)
+	}
+	return nil
+}
+
+func (s *ccvmService) listCaches(ctx context.Context, resultCh chan interface{}) {
+	go func() {
+		caches, err := s.b.listCaches(ctx)
+		if err != nil {
+			resultCh <- err
+		} else {
+			resultCh <- caches
+		}
+		close(resultCh)
+	}()
+}
+
+func (s *ccvmService) clearCache(ctx context.Context, name string, resultCh chan interface{}) {
+	go func() {
+		resultCh <- s.b.clearCache(ctx, name)
+		close(resultCh)
+	}()
+}
@@ -0,0 +1,107 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestCheckCaches(t *testing.T) {
+	valid := []types.VMSpec{
+		{},
+		{Caches: []types.Cache{{Name: "apt"}, {Name: "gomod"}}},
+		{Caches: []types.Cache{{Name: "npm", Path: "~/.npm"}}},
+	}
+	for i := range valid {
+		if err := checkCaches(&valid[i]); err != nil {
+			t.Errorf("Valid caches %+v rejected: %v", valid[i].Caches, err)
+		}
+	}
+
+	invalid := []types.VMSpec{
+		{Caches: []types.Cache{{Name: "npm"}}},
+		{Caches: []types.Cache{{Name: "Apt"}}},
+		{Caches: []types.Cache{{Name: "npm", Path: "npm"}}},
+		{Caches: []types.Cache{{Name: "root", Path: "/"}}},
+		{Caches: []types.Cache{{Name: "apt"}, {Name: "apt"}}},
+		{Caches: []types.Cache{{Name: "apt"}, {Name: "debs", Path: "/var/cache/apt/archives/"}}},
+	}
+	for i := range invalid {
+		if err := checkCaches(&invalid[i]); err == nil {
+			t.Errorf("Expected error for %+v", invalid[i].Caches)
+		}
+	}
+}
+
+func TestMergeCaches(t *testing.T) {
+	in := types.VMSpec{Caches: []types.Cache{{Name: "apt"}, {Name: "gomod"}}}
+	in.MergeCaches([]types.Cache{{Name: "gomod", Path: "/go/pkg/mod"}, {Name: "pip"}})
+
+	if len(in.Caches) != 3 {
+		t.Fatalf("Expected 3 caches, found %d", len(in.Caches))
+	}
+	if in.Caches[1].GuestPath() != "/go/pkg/mod" || in.Caches[2].Name != "pip" {
+		t.Errorf("Unexpected caches %v", in.Caches)
+	}
+}
+
+func TestCacheConfig(t *testing.T) {
+	in := types.VMSpec{Caches: []types.Cache{{Name: "apt"}, {Name: "gomod"}}}
+	expected := "ccvm-cache-apt /var/cache/apt/archives root\n" +
+		"ccvm-cache-gomod /home/user/go/pkg/mod user\n"
+	if conf := cacheConfig(&in, "user"); conf != expected {
+		t.Errorf("Unexpected cache configuration:\n%s", conf)
+	}
+}
+
+func TestCacheArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	in := types.VMSpec{Caches: []types.Cache{{Name: "apt"}}}
+	args, err := cacheArgs(dir, &in)
+	if err != nil {
+		t.Fatalf("Unable to share caches: %v", err)
+	}
+	if _, err := os.Stat(path.Join(dir, cachesDir, "apt")); err != nil {
+		t.Errorf("Cache directory not created: %v", err)
+	}
+	if cmdLine := strings.Join(args, " "); !strings.Contains(cmdLine, "mount_tag=ccvm-cache-apt") {
+		t.Errorf("Unexpected cache arguments %s", cmdLine)
+	}
+}
+
+func TestCacheScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	cmd := exec.Command("sh", "-n")
+	cmd.Stdin = strings.NewReader(cacheScript)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("Invalid cache script: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,422 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	workloadSourceBundled = "bundled"
+	workloadSourceUser    = "user"
+	workloadSourceRemote  = "remote"
+)
+
+// cloudConfigLists are the sections of cloud-init documents that must be
+// lists.
+var cloudConfigLists = []string{"bootcmd", "runcmd", "packages", "write_files", "users", "mounts"}
+
+// problemLineRegexp matches the line numbers in the errors returned by the
+// yaml and template packages.
+var problemLineRegexp = regexp.MustCompile(`(template: [^:]+:|line )(\d+)`)
+
+func bundledWorkloadsDir() (string, error) {
+	p, err := build.Default.Import(ccloudvmPkg, "", build.FindOnly)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to locate ccloudvm workload directory")
+	}
+	return filepath.Join(p.Dir, "workloads"), nil
+}
+
+// workloadInfo describes the workload name defined by data.  The fields
+// that cannot be determined, e.g., because the workload is invalid, are
+// left empty.
+func workloadInfo(ws *workspace, name, source string, data []byte) types.WorkloadInfo {
+	info := types.WorkloadInfo{
+		Name:   name,
+		Source: source,
+	}
+
+	docs := splitYaml(data)
+	if len(docs) != 2 {
+		_, err := parseGroupSpec(name, data)
+		info.Group = err == nil
+		return info
+	}
+
+	var wkld workload
+	if err := unmarshalWorkload(ws, &wkld, string(docs[0]), string(docs[1])); err == nil {
+		info.BaseImageName = wkld.spec.BaseImageName
+		info.Inherits = wkld.spec.Inherits
+	}
+	return info
+}
+
+func (c ccvmBackend) getWorkloads(ctx context.Context) ([]types.WorkloadInfo, error) {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// The user's workloads hide the bundled workloads with the same name.
+	seen := make(map[string]bool)
+	var workloads []types.WorkloadInfo
+	add := func(dir, source string) {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.yaml"))
+		for _, f := range files {
+			name := strings.TrimSuffix(filepath.Base(f), ".yaml")
+			if seen[name] {
+				continue
+			}
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				continue
+			}
+			seen[name] = true
+			workloads = append(workloads, workloadInfo(ws, name, source, data))
+		}
+	}
+
+	add(filepath.Join(ws.ccvmDir, "workloads"), workloadSourceUser)
+	if dir, err := bundledWorkloadsDir(); err == nil {
+		add(dir, workloadSourceBundled)
+	}
+	sort.Slice(workloads, func(i, j int) bool { return workloads[i].Name < workloads[j].Name })
+
+	for _, URL := range cachedRemoteWorkloads(ws.ccvmDir) {
+		data, err := ioutil.ReadFile(remoteWorkloadPath(ws.ccvmDir, URL))
+		if err != nil {
+			continue
+		}
+		workloads = append(workloads, workloadInfo(ws, URL, workloadSourceRemote, data))
+	}
+
+	return workloads, nil
+}
+
+// templateWorkspace returns a workspace suitable for rendering workloads
+// outside of the creation of an instance.
+func (c ccvmBackend) templateWorkspace(ctx context.Context) (*workspace, error) {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	ws.Hostname = "hostname"
+	ws.PackageUpgrade = "false"
+	ws.retry = c.retryPolicy()
+	return ws, nil
+}
+
+func (c ccvmBackend) showWorkload(ctx context.Context, name string) (*types.WorkloadDetails, error) {
+	ws, err := c.templateWorkspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := getHTTPTransport(ws.HTTPProxy, ws.HTTPSProxy, ws.NoProxy)
+	data, err := loadWorkloadData(ctx, ws, name, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &types.WorkloadDetails{Name: name}
+	if len(splitYaml(data)) != 2 {
+		spec, err := parseGroupSpec(name, data)
+		if err != nil {
+			return nil, err
+		}
+		out, err := yaml.Marshal(spec)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to marshal group workload")
+		}
+		details.Spec = string(out)
+		return details, nil
+	}
+
+	wkld, err := newWorkload(ctx, ws, name, data, transport, nil)
+	if err != nil {
+		return nil, err
+	}
+	ws.Mounts = wkld.spec.VM.Mounts
+	ws.Params = templateParams(wkld.spec.Params)
+	if err = wkld.generateCloudConfig(ws); err != nil {
+		return nil, err
+	}
+
+	out, err := yaml.Marshal(wkld.spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to marshal instance specification")
+	}
+	details.Spec = string(out)
+	details.CloudConfig = string(wkld.mergedUserData)
+
+	return details, nil
+}
+
+func (c ccvmBackend) validateWorkload(ctx context.Context, args *types.ValidateWorkloadArgs) ([]types.WorkloadProblem, error) {
+	ws, err := c.templateWorkspace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := getHTTPTransport(ws.HTTPProxy, ws.HTTPSProxy, ws.NoProxy)
+	return checkWorkload(ctx, ws, args.Name, args.Data, transport), nil
+}
+
+// locateProblem converts err, which was found in a document starting after
+// offset lines of the workload, into a problem whose line numbers are those
+// of the workload.
+func locateProblem(err error, offset int) types.WorkloadProblem {
+	var p types.WorkloadProblem
+	p.Message = problemLineRegexp.ReplaceAllStringFunc(err.Error(), func(m string) string {
+		sub := problemLineRegexp.FindStringSubmatch(m)
+		n, _ := strconv.Atoi(sub[2])
+		n += offset
+		if p.Line == 0 {
+			p.Line = n
+		}
+		return sub[1] + strconv.Itoa(n)
+	})
+	return p
+}
+
+// sectionLine returns the number of the line of doc at which the top level
+// section key starts, or 0 if it cannot be found.
+func sectionLine(doc []byte, key string) int {
+	for i, line := range strings.Split(string(doc), "\n") {
+		if strings.HasPrefix(line, key+":") {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// checkRendered parses rendered, the output of the template of the document
+// source, which starts after offset lines of the workload, into out.  The
+// line numbers of the errors found in rendered are only meaningful if the
+// template did not add or remove lines.
+func checkRendered(source, rendered []byte, offset int, strict bool, out interface{}) *types.WorkloadProblem {
+	unmarshal := yaml.Unmarshal
+	if strict {
+		unmarshal = yaml.UnmarshalStrict
+	}
+	err := unmarshal(rendered, out)
+	if err == nil {
+		return nil
+	}
+
+	if bytes.Count(source, []byte("\n")) == bytes.Count(rendered, []byte("\n")) {
+		p := locateProblem(err, offset)
+		return &p
+	}
+	return &types.WorkloadProblem{Message: fmt.Sprintf("In the rendered document: %v", err)}
+}
+
+// checkWorkload validates the workload name defined by data, reporting the
+// errors found in its yaml documents and templates with the lines at which
+// they were found.  The workloads it inherits and the instance
+// specification are only checked if no such errors are found.
+func checkWorkload(ctx context.Context, ws *workspace, name string, data []byte,
+	transport *http.Transport) []types.WorkloadProblem {
+	docs, starts := splitYamlLines(data)
+	if len(docs) == 1 {
+		return checkGroupWorkload(ctx, ws, name, docs[0], starts[0]-1, transport)
+	}
+	if len(docs) != 2 {
+		return []types.WorkloadProblem{{Message: "Invalid workload; two documents required"}}
+	}
+
+	var problems []types.WorkloadProblem
+	add := func(p types.WorkloadProblem) {
+		problems = append(problems, p)
+	}
+
+	specOffset, userDataOffset := starts[0]-1, starts[1]-1
+	var spec workloadSpec
+	var buf bytes.Buffer
+	tmpl, err := template.New("instance-spec").Parse(string(docs[0]))
+	if err == nil {
+		err = tmpl.Execute(&buf, ws)
+	}
+	if err != nil {
+		add(locateProblem(err, specOffset))
+	} else if p := checkRendered(docs[0], buf.Bytes(), specOffset, true, &spec); p != nil {
+		add(*p)
+	}
+	ws.Params = templateParams(spec.Params)
+
+	buf.Reset()
+	tmpl, err = template.New("user-data").Funcs(workloadFuncMap).Parse(string(docs[1]))
+	if err == nil {
+		err = tmpl.Execute(&buf, ws)
+	}
+	cc := make(cloudConfig)
+	if err != nil {
+		add(locateProblem(err, userDataOffset))
+	} else if p := checkRendered(docs[1], buf.Bytes(), userDataOffset, false, &cc); p != nil {
+		add(*p)
+	}
+	for _, key := range cloudConfigLists {
+		if v, ok := cc[key]; ok && v != nil {
+			if _, ok := v.([]interface{}); !ok {
+				line := sectionLine(docs[1], key)
+				if line != 0 {
+					line += userDataOffset
+				}
+				add(types.WorkloadProblem{Line: line, Message: fmt.Sprintf("%s must be a list", key)})
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	wkld, err := newWorkload(ctx, ws, name, data, transport, nil)
+	if err != nil {
+		return []types.WorkloadProblem{{Message: err.Error()}}
+	}
+	in := &wkld.spec.VM
+	for _, g := range in.VGPUs {
+		if err := g.Check(); err != nil {
+			add(types.WorkloadProblem{Message: err.Error()})
+		}
+	}
+	for _, g := range in.GPUs {
+		if err := g.Check(); err != nil {
+			add(types.WorkloadProblem{Message: err.Error()})
+		}
+	}
+	if err := checkArch(&wkld.spec); err != nil {
+		add(types.WorkloadProblem{Message: err.Error()})
+	}
+	if err := checkFirmware(&wkld.spec); err != nil {
+		add(types.WorkloadProblem{Message: err.Error()})
+	}
+	if err := checkDatasource(&wkld.spec); err != nil {
+		add(types.WorkloadProblem{Message: err.Error()})
+	}
+	if err := checkWindows(&wkld.spec); err != nil {
+		add(types.WorkloadProblem{Message: err.Error()})
+	}
+	if err := checkGraphics(in); err != nil {
+		add(types.WorkloadProblem{Message: err.Error()})
+	}
+	if err := checkMedia(in); err != nil {
+		add(types.WorkloadProblem{Message: err.Error()})
+	}
+	if err := checkCaches(in); err != nil {
+		add(types.WorkloadProblem{Message: err.Error()})
+	}
+	if err := checkDirectKernel(in); err != nil {
+		add(types.WorkloadProblem{Message: err.Error()})
+	}
+	if err := checkCPUs(in); err != nil {
+		add(types.WorkloadProblem{Message: err.Error()})
+	}
+	if err := checkMemoryBackend(in); err != nil {
+		add(types.WorkloadProblem{Message: err.Error()})
+	}
+	for _, d := range in.Disks {
+		if err := d.Check(); err != nil {
+			add(types.WorkloadProblem{Message: err.Error()})
+		}
+	}
+	if err := checkGuestIdentity(in); err != nil {
+		add(types.WorkloadProblem{Message: err.Error()})
+	}
+	ws.Mounts = in.Mounts
+	ws.Params = templateParams(wkld.spec.Params)
+	if err := wkld.generateCloudConfig(ws); err != nil {
+		add(types.WorkloadProblem{Message: err.Error()})
+	}
+
+	return problems
+}
+
+func checkGroupWorkload(ctx context.Context, ws *workspace, name string, doc []byte, offset int,
+	transport *http.Transport) []types.WorkloadProblem {
+	var spec types.GroupSpec
+	if err := yaml.UnmarshalStrict(doc, &spec); err != nil {
+		return []types.WorkloadProblem{locateProblem(err, offset)}
+	}
+	if _, err := parseGroupSpec(name, doc); err != nil {
+		return []types.WorkloadProblem{{Message: err.Error()}}
+	}
+
+	var problems []types.WorkloadProblem
+	for _, role := range spec.Roles {
+		if _, err := createWorkload(ctx, ws, role.Workload, transport); err != nil {
+			problems = append(problems, types.WorkloadProblem{
+				Message: fmt.Sprintf("Invalid workload %s for role %s: %v", role.Workload, role.Name, err),
+			})
+		}
+	}
+	return problems
+}
+
+func (s *ccvmService) getWorkloads(ctx context.Context, resultCh chan interface{}) {
+	go func() {
+		workloads, err := s.b.getWorkloads(ctx)
+		if err != nil {
+			resultCh <- err
+		} else {
+			resultCh <- workloads
+		}
+		close(resultCh)
+	}()
+}
+
+func (s *ccvmService) showWorkload(ctx context.Context, name string, resultCh chan interface{}) {
+	go func() {
+		details, err := s.b.showWorkload(ctx, name)
+		if err != nil {
+			resultCh <- err
+		} else {
+			resultCh <- details
+		}
+		close(resultCh)
+	}()
+}
+
+func (s *ccvmService) validateWorkload(ctx context.Context, args *types.ValidateWorkloadArgs,
+	resultCh chan interface{}) {
+	go func() {
+		problems, err := s.b.validateWorkload(ctx, args)
+		if err != nil {
+			resultCh <- err
+		} else {
+			resultCh <- problems
+		}
+		close(resultCh)
+	}()
+}
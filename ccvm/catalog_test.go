@@ -0,0 +1,115 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckWorkload(t *testing.T) {
+	tests := []struct {
+		workload string
+		line     int
+		message  string
+	}{
+		{sampleWorkload, 0, ""},
+		{"---\nvm:\n  mem_mib: {{memory}}\n...\n---\n#cloud-config\n...\n", 3, "memory"},
+		{"---\nvm:\n  mem_mib: 1024\n  cpu: 2\n...\n---\n#cloud-config\n...\n", 4, "field cpu not found"},
+		{"---\nvm:\n  mem_mib: 1024\n...\n---\n#cloud-config\nruncmd:\n - {{beginTask}}\n...\n", 8, "beginTask"},
+		{"---\nvm:\n  mem_mib: 1024\n...\n---\n#cloud-config\nruncmd: reboot\n...\n", 7, "runcmd must be a list"},
+		{"---\ninherits: missing\n...\n---\n#cloud-config\n...\n", 0, "missing"},
+		{"roles:\n  - name: master\n    workload: missing\n", 0, "role master"},
+	}
+
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+
+	defer func() {
+		if err := os.RemoveAll(ccvmDir); err != nil {
+			t.Errorf("Failed to remove %s : %v", ccvmDir, err)
+		}
+	}()
+
+	ws := &workspace{ccvmDir: ccvmDir, network: defaultNetwork(), User: "user"}
+	for _, tt := range tests {
+		problems := checkWorkload(context.Background(), ws, "test", []byte(tt.workload), nil)
+		if tt.message == "" {
+			if len(problems) != 0 {
+				t.Errorf("Unexpected problems %+v", problems)
+			}
+			continue
+		}
+		if len(problems) != 1 || problems[0].Line != tt.line ||
+			!strings.Contains(problems[0].Message, tt.message) {
+			t.Errorf("Expected %q at line %d, got %+v", tt.message, tt.line, problems)
+		}
+	}
+}
+
+func TestCheckBundledWorkloads(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+
+	defer func() {
+		if err := os.RemoveAll(ccvmDir); err != nil {
+			t.Errorf("Failed to remove %s : %v", ccvmDir, err)
+		}
+	}()
+
+	files, err := filepath.Glob(filepath.Join("..", "workloads", "*.yaml"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Unable to find bundled workloads: %v", err)
+	}
+
+	var ws *workspace
+	workloads := make(map[string][]byte)
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("Unable to read %s: %v", f, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(f), ".yaml")
+		ws, err = createMockWorkSpaceWithWorkload(string(data), name, ccvmDir)
+		if err != nil {
+			t.Fatalf("Failed to create mock workload: %v", err)
+		}
+		workloads[name] = data
+	}
+
+	ws.User = "user"
+	ws.Hostname = "hostname"
+	for name, data := range workloads {
+		problems := checkWorkload(context.Background(), ws, name, data, nil)
+		if len(problems) != 0 {
+			t.Errorf("Bundled workload %s is invalid: %+v", name, problems)
+		}
+
+		info := workloadInfo(ws, name, workloadSourceBundled, data)
+		if info.BaseImageName == "" && !info.Group && len(info.Inherits) == 0 {
+			t.Errorf("Unexpected information for %s: %+v", name, info)
+		}
+	}
+}
@@ -19,13 +19,17 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"path"
+	"reflect"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/ciao-project/ciao/deviceinfo"
 	"github.com/intel/ccloudvm/types"
@@ -34,14 +38,68 @@ import (
 
 type backend interface {
 	createInstance(context.Context, chan interface{}, chan<- downloadRequest, *types.CreateArgs) error
-	start(context.Context, string, *types.VMSpec) error
-	stop(context.Context, string) error
+	prefetch(context.Context, chan interface{}, chan<- downloadRequest, *types.CreateArgs) error
+	start(context.Context, string, *types.VMSpec, bool) error
+	restart(context.Context, *types.RestartArgs) error
+	stop(context.Context, *types.StopArgs) (*types.StopResult, error)
 	quit(context.Context, string) error
+	resize(context.Context, string, *types.ResizeArgs) (*types.ResizeResult, error)
+	forward(context.Context, string, types.PortMapping, bool) error
+	mount(context.Context, string, types.Mount, bool) error
+	disk(context.Context, string, *types.DiskArgs, bool) error
+	fsck(context.Context, string, bool) (*types.FsckResult, error)
+	flatten(context.Context, string) error
+	rename(context.Context, string, string) error
+	backup(context.Context, string, string, bool) (*types.BackupInfo, error)
+	listBackups(context.Context, string, string) (*types.BackupList, error)
+	restoreBackup(context.Context, *types.RestoreArgs) error
+	setBackupPolicy(context.Context, *types.BackupPolicy) error
+	suspend(context.Context, string) error
+	resumeFromDisk(context.Context, string, bool) error
+	set(context.Context, string, map[string]string) error
 	status(context.Context, string) (*types.InstanceDetails, error)
+	summary(context.Context, string) (*types.InstanceSummary, error)
+	sshKey(context.Context, string) (*types.SSHKeyResult, error)
+	refreshStatus(context.Context, string, time.Duration) (*types.InstanceDetails, error)
 	deleteInstance(context.Context, string) error
+	loadGroup(context.Context, *types.CreateArgs) (*types.GroupSpec, error)
+	guestRequest(context.Context, string, *guestRequest) error
+	monitor(context.Context, string) (*vmExit, error)
+	checkReady(context.Context, string) error
+	syncFiles(context.Context, string) error
+	forwardDocker(context.Context, string) error
+	syncStatus(context.Context, string) (*types.SyncStatusResult, error)
+	kubeconfig(context.Context, string) (*types.KubeconfigResult, error)
+	pauseSync(context.Context, string, bool) error
+	vmExited(context.Context, string, *vmExit) (*exitOutcome, error)
+	report(context.Context, time.Time) (*types.ReportResult, error)
+	preflight(context.Context, *types.PreflightArgs) (*types.PreflightResult, error)
+	consoleLogPath(context.Context, string) (string, error)
+	createNetwork(context.Context, *types.NetworkSpec) error
+	deleteNetwork(context.Context, string) error
+	listNetworks(context.Context) ([]types.NetworkSpec, error)
+	createVolume(context.Context, *types.VolumeSpec) error
+	deleteVolume(context.Context, string) error
+	listVolumes(context.Context) ([]types.VolumeInfo, error)
+	listCaches(context.Context) ([]types.CacheInfo, error)
+	clearCache(context.Context, string) error
+	updateWorkloads(context.Context, []string) ([]string, error)
+	getWorkloads(context.Context) ([]types.WorkloadInfo, error)
+	showWorkload(context.Context, string) (*types.WorkloadDetails, error)
+	validateWorkload(context.Context, *types.ValidateWorkloadArgs) ([]types.WorkloadProblem, error)
+	exportInstance(context.Context, string, string) error
+	buildTemplate(context.Context, string, *types.BuildTemplateArgs) (*types.TemplateInfo, error)
+	importWorkload(context.Context, string, string) (string, error)
+	importVagrant(context.Context, *types.ImportVagrantArgs) (*types.ImportVagrantResult, error)
+	pushWorkload(context.Context, *types.PushArgs) error
+	pullWorkload(context.Context, *types.PullArgs) (string, error)
+	execCommand(context.Context, string, string, io.Writer, io.Writer) (int, error)
+	proxy(context.Context, *types.ProxyArgs, func(*types.ProxyStatus)) error
 }
 
-type ccvmBackend struct{}
+type ccvmBackend struct {
+	cfg *daemonConfig
+}
 
 func checkMemAvailable(in *types.VMSpec) error {
 	_, available := deviceinfo.GetMemoryInfo()
@@ -53,6 +111,10 @@ func checkMemAvailable(in *types.VMSpec) error {
 		return fmt.Errorf("Host device has only %d MiB of RAM available", available)
 	}
 
+	if in.MemoryBackend == types.MemoryBackendHugepages {
+		return checkHugepagesAvailable(in)
+	}
+
 	return nil
 }
 
@@ -69,31 +131,203 @@ func prepareCreate(ctx context.Context, args *types.CreateArgs) (*workload, *wor
 	if args.CustomSpec.HostIP.IsLoopback() {
 		ws.HostIP = args.CustomSpec.HostIP.String()
 	}
+	ws.Group = args.Group
+
+	if !args.NoProfile {
+		ws.profile, err = loadUserProfile(ws.ccvmDir, ws.Home)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if ws.profile != nil {
+		ws.profile.applyEnv(ws)
+	}
 
 	transport := getHTTPTransport(ws.HTTPProxy, ws.HTTPSProxy, ws.NoProxy)
 
-	wkld, err := createWorkload(ctx, ws, args.WorkloadName, transport)
+	workloadName := args.WorkloadName
+	if args.Template != "" {
+		workloadName, err = templatePath(ws.ccvmDir, args.Template)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	wkld, err := createWorkload(ctx, ws, workloadName, transport)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// The parameters supplied to the creation of a group apply to the
+	// workloads of all its roles, which need not declare all of them.
+	ws.Params, err = resolveParams(wkld.spec.Params, args.Params, args.Group != nil)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
 	in := &wkld.spec.VM
 
+	if ws.profile != nil {
+		ws.profile.applyMounts(in)
+	}
+
 	err = in.MergeCustom(&args.CustomSpec)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
+	if args.Encrypt {
+		in.Encrypt = true
+	}
+	if in.Encrypt {
+		if err := checkEncryption(in, args.Passphrase); err != nil {
+			return nil, nil, nil, err
+		}
+		ws.diskKey = []byte(args.Passphrase)
+	}
+
+	for _, g := range in.VGPUs {
+		if err := g.Check(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	for _, g := range in.GPUs {
+		if err := g.Check(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	for _, d := range in.Disks {
+		if err := d.Check(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if err := checkGuestIdentity(in); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkArch(&wkld.spec); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkFirmware(&wkld.spec); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkDatasource(&wkld.spec); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkWindows(&wkld.spec); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkGraphics(in); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkMedia(in); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkCaches(in); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkDirectKernel(in); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkCPUs(in); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkMemoryBackend(in); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Networks are chosen when instances are created rather than by
+	// their workloads.
+	in.Network = args.CustomSpec.Network
+	ws.network, err = loadNetwork(ws.ccvmDir, in.Network)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := ws.network.checkHostIP(args.CustomSpec.HostIP); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := ws.network.checkPorts(in.PortMappings); err != nil {
+		return nil, nil, nil, err
+	}
+
 	ws.Mounts = in.Mounts
 	ws.Hostname = args.Name
+	if in.Hostname != "" {
+		ws.Hostname = in.Hostname
+	}
+
+	if args.Group != nil {
+		wkld.spec.Group = args.Group.Name
+		wkld.spec.Role = args.Group.Role
+	}
+
+	wkld.spec.Labels = mergeLabels(args.Labels, wkld.spec.Labels)
+	if err := types.CheckLabels(wkld.spec.Labels); err != nil {
+		return nil, nil, nil, err
+	}
+
+	ws.userData, err = parseUserData(args.UserData, args.UserDataMode)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ws.PackageUpgrade = "false"
+	if args.Update {
+		ws.PackageUpgrade = "true"
+	}
+
+	if err := checkNestedVirt(&wkld.spec); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := checkMemAvailable(in); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := ws.checkQemuConfig(&wkld.spec.Qemu); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := ws.checkDevices(in); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkKernelFiles(ws, in); err != nil {
+		return nil, nil, nil, err
+	}
+	for _, m := range in.Mounts {
+		if err := ws.checkUserPath(m.Path); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	for _, d := range in.Drives {
+		if err := ws.checkUserPath(d.Path); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	for _, s := range in.Syncs {
+		if err := ws.checkUserPath(s.Source); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	// Tap subnets are allocated once the instance has been validated, as
+	// they remain reserved until it has been created.
+	if err := ws.allocateTap(in); err != nil {
+		return nil, nil, nil, err
+	}
+	ws.network, err = ws.network.withTap(in)
+	if err != nil {
+		releaseTap(in.TapSubnet)
+		return nil, nil, nil, err
+	}
 
 	if ws.NoProxy != "" || ws.HTTPProxy != "" || ws.HTTPSProxy != "" {
 		npSet := map[string]struct{}{
-			"10.0.2.2":  {},
-			"127.0.0.1": {},
-			"10.0.2.15": {},
-			ws.Hostname: {},
-			ws.HostIP:   {},
+			ws.network.hostIP():           {},
+			ws.network.reverseForwardIP(): {},
+			hostAlias:                     {},
+			"127.0.0.1":                   {},
+			ws.network.guestIP():          {},
+			ws.Hostname:                   {},
+			ws.HostIP:                     {},
 		}
 		for _, np := range strings.Split(ws.NoProxy, ",") {
 			npSet[np] = struct{}{}
@@ -107,19 +341,6 @@ func prepareCreate(ctx context.Context, args *types.CreateArgs) (*workload, *wor
 		ws.NoProxy = strings.Join(noProxies, ",")
 	}
 
-	ws.PackageUpgrade = "false"
-	if args.Update {
-		ws.PackageUpgrade = "true"
-	}
-
-	if wkld.spec.NeedsNestedVM && !hostSupportsNestedKVM() {
-		return nil, nil, nil, fmt.Errorf("nested KVM is not enabled.  Please enable and try again")
-	}
-
-	if err := checkMemAvailable(in); err != nil {
-		return nil, nil, nil, err
-	}
-
 	return wkld, ws, transport, nil
 }
 
@@ -135,44 +356,106 @@ func downloadProgress(resultCh chan interface{}, p progress) {
 	}
 }
 
-func downloadImages(ctx context.Context, wkld *workload, transport *http.Transport,
+// reportRetry returns a function that reports retries in the output of
+// a create command.
+func reportRetry(resultCh chan interface{}) func(string) {
+	return func(line string) {
+		resultCh <- types.CreateResult{Line: line}
+	}
+}
+
+// downloadURI returns the path of the file identified by URI, downloading
+// it if necessary, after checking that its SHA-256 checksum is checksum,
+// if specified.  Local files must be readable by the user of ws.
+func downloadURI(ctx context.Context, ws *workspace, URI, checksum string, transport *http.Transport,
+	resultCh chan interface{}, downloadCh chan<- downloadRequest) (string, error) {
+	u, err := url.Parse(URI)
+	if err != nil {
+		return "", errors.Wrapf(err, "Invalid URL %s", URI)
+	}
+
+	switch u.Scheme {
+	case "file":
+		if err := ws.checkLocalFile(u.Path); err != nil {
+			return "", err
+		}
+		return u.Path, verifyImage(u.Path, checksum, false)
+	case "http", "https":
+		var path string
+		err = ws.retry.do(ctx, "Download of "+URI, reportRetry(resultCh), func() error {
+			var err error
+			path, err = downloadFile(ctx, downloadCh, transport, URI,
+				func(firstDownload bool, p progress) {
+					if firstDownload {
+						resultCh <- types.CreateResult{
+							Line: fmt.Sprintf("Downloading %s\n", URI),
+						}
+					}
+					downloadProgress(resultCh, p)
+				})
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		return path, verifyImage(path, checksum, true)
+	}
+
+	return "", errors.Errorf("Invalid URL %s", URI)
+}
+
+// downloadImages returns the paths of the BIOS and of the base image of
+// wkld, downloading them, or copying the base image if it is a local file,
+// if necessary.
+func downloadImages(ctx context.Context, wkld *workload, ws *workspace, transport *http.Transport,
 	resultCh chan interface{}, downloadCh chan<- downloadRequest) (string, string, error) {
 	var BIOSPath string
+	var err error
 
 	if wkld.spec.BIOS != "" {
-		BIOSURL, err := url.Parse(wkld.spec.BIOS)
+		BIOSURL, BIOSTransport := ws.mirrors.resolve(wkld.spec.BIOS, transport)
+		BIOSPath, err = downloadURI(ctx, ws, BIOSURL, wkld.spec.BIOSSHA256, BIOSTransport,
+			resultCh, downloadCh)
 		if err != nil {
-			return "", "", errors.Wrapf(err, "Invalid URL %s", wkld.spec.BIOS)
+			return "", "", err
 		}
-		if BIOSURL.Scheme == "file" {
-			BIOSPath = BIOSURL.Path
-		} else if BIOSURL.Scheme == "http" || BIOSURL.Scheme == "https" {
-			BIOSPath, err = downloadFile(ctx, downloadCh, transport, wkld.spec.BIOS,
-				func(firstDownload bool, p progress) {
+	}
+
+	localPath, isLocal, err := localImagePath(wkld.spec.BaseImageURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	var qcowPath string
+	if isLocal {
+		if err := ws.checkLocalFile(localPath); err != nil {
+			return "", "", err
+		}
+		resultCh <- types.CreateResult{
+			Line: fmt.Sprintf("Using local image %s\n", localPath),
+		}
+		qcowPath, err = copyLocalImage(ws.ccvmDir, localPath)
+	} else {
+		imageURL, imageTransport := ws.mirrors.resolve(wkld.spec.BaseImageURL, transport)
+		err = ws.retry.do(ctx, "Download of "+wkld.spec.BaseImageName, reportRetry(resultCh), func() error {
+			var err error
+			qcowPath, err = downloadFile(ctx, downloadCh, imageTransport,
+				imageURL, func(firstDownload bool, p progress) {
 					if firstDownload {
 						resultCh <- types.CreateResult{
-							Line: fmt.Sprintf("Downloading %s\n", wkld.spec.BIOS),
+							Line: fmt.Sprintf("Downloading %s\n", wkld.spec.BaseImageName),
 						}
 					}
 					downloadProgress(resultCh, p)
 				})
-			if err != nil {
-				return "", "", err
-			}
-		} else {
-			return "", "", errors.Errorf("Invalid URL %s", wkld.spec.BIOS)
-		}
+			return err
+		})
+	}
+	if err != nil {
+		return "", "", err
 	}
 
-	qcowPath, err := downloadFile(ctx, downloadCh, transport,
-		wkld.spec.BaseImageURL, func(firstDownload bool, p progress) {
-			if firstDownload {
-				resultCh <- types.CreateResult{
-					Line: fmt.Sprintf("Downloading %s\n", wkld.spec.BaseImageName),
-				}
-			}
-			downloadProgress(resultCh, p)
-		})
+	err = verifyImage(qcowPath, wkld.spec.BaseImageSHA256, true)
 	if err != nil {
 		return "", "", err
 	}
@@ -180,32 +463,76 @@ func downloadImages(ctx context.Context, wkld *workload, transport *http.Transpo
 	return BIOSPath, qcowPath, nil
 }
 
+func copyImage(src, instanceDir, name string) error {
+	dest := path.Join(instanceDir, name)
+	err := exec.Command("cp", src, dest).Run()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to copy %s file %s", name, src)
+	}
+	return nil
+}
+
 func createImages(ctx context.Context, wkld *workload, ws *workspace, args *types.CreateArgs,
-	transport *http.Transport, resultCh chan interface{}, downloadCh chan<- downloadRequest) error {
+	transport *http.Transport, resultCh chan interface{}, downloadCh chan<- downloadRequest,
+	hv hypervisor) error {
 
-	srcBIOSPath, qcowPath, err := downloadImages(ctx, wkld, transport, resultCh, downloadCh)
+	srcBIOSPath, qcowPath, err := downloadImages(ctx, wkld, ws, transport, resultCh, downloadCh)
 	if err != nil {
 		return err
 	}
 
 	if srcBIOSPath != "" {
-		destBIOSPath := path.Join(ws.instanceDir, "BIOS")
-		err := exec.Command("cp", srcBIOSPath, destBIOSPath).Run()
+		if err := copyImage(srcBIOSPath, ws.instanceDir, "BIOS"); err != nil {
+			return err
+		}
+	}
+
+	if wkld.spec.Kernel != "" {
+		kernelURL, kernelTransport := ws.mirrors.resolve(wkld.spec.Kernel, transport)
+		kernelPath, err := downloadURI(ctx, ws, kernelURL, wkld.spec.KernelSHA256, kernelTransport,
+			resultCh, downloadCh)
+		if err != nil {
+			return err
+		}
+		if err := copyImage(kernelPath, ws.instanceDir, "kernel"); err != nil {
+			return err
+		}
+	}
+
+	if wkld.spec.VirtioDrivers != "" {
+		virtioURL, virtioTransport := ws.mirrors.resolve(wkld.spec.VirtioDrivers, transport)
+		virtioPath, err := downloadURI(ctx, ws, virtioURL, wkld.spec.VirtioSHA256, virtioTransport,
+			resultCh, downloadCh)
 		if err != nil {
-			return errors.Wrapf(err, "Failed to copy BIOS file %s", srcBIOSPath)
+			return err
+		}
+		if err := linkImage(virtioPath, ws.instanceDir, virtioDriversImage); err != nil {
+			return err
 		}
 	}
 
-	err = buildISOImage(ctx, resultCh, wkld.mergedUserData, ws, args.Debug)
+	err = buildCloudInitData(ctx, resultCh, wkld.mergedUserData, ws, &wkld.spec, args.Debug)
 	if err != nil {
 		return err
 	}
 
-	err = createRootfs(ctx, qcowPath, ws.instanceDir, wkld.spec.VM.DiskGiB)
+	if wkld.spec.VM.Encrypt {
+		err = createEncryptedRootfs(ctx, qcowPath, ws.instanceDir, wkld.spec.VM.DiskGiB,
+			ws.diskKey)
+	} else {
+		err = hv.createRootfs(ctx, qcowPath, ws.instanceDir, wkld.spec.VM.DiskGiB)
+	}
 	if err != nil {
 		return err
 	}
 
+	for i := range wkld.spec.VM.Disks {
+		err = createDataDisk(ctx, ws, &wkld.spec.VM.Disks[i])
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -221,7 +548,7 @@ func outputBootingMessage(args *types.CreateArgs, wkld *workload, ws *workspace,
 		return
 	}
 
-	sshPort, err := spec.SSHPort()
+	sshHost, sshPort, err := sshEndpoint(spec)
 	if err != nil {
 		return
 	}
@@ -233,18 +560,61 @@ func outputBootingMessage(args *types.CreateArgs, wkld *workload, ws *workspace,
 	fstr := "\tssh -q -F /dev/null -o UserKnownHostsFile=/dev/null " +
 		"-o StrictHostKeyChecking=no -o IdentitiesOnly=yes -i %s %s -p %d\n"
 	resultCh <- types.CreateResult{
-		Line: fmt.Sprintf(fstr, ws.keyPath, spec.HostIP, sshPort),
+		Line: fmt.Sprintf(fstr, ws.keyPath, sshHost, sshPort),
+	}
+}
+
+// retryPolicy returns the policy applied to the transient failures that
+// occur while an instance is created.
+func (c ccvmBackend) retryPolicy() retryPolicy {
+	var cfg retryConfig
+	if c.cfg != nil {
+		cfg = c.cfg.Retry
+	}
+	// The configuration is validated when the daemon starts.
+	p, _ := cfg.policy()
+	return p
+}
+
+func (c ccvmBackend) mirrors() mirrorList {
+	if c.cfg == nil {
+		return nil
+	}
+	return c.cfg.Mirrors
+}
+
+func (c ccvmBackend) instanceHypervisor(ws *workspace) (hypervisor, error) {
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to load instance state")
 	}
+
+	return getHypervisor(ws, c.cfg, &wkld.spec)
 }
 
 func (c ccvmBackend) createInstance(ctx context.Context, resultCh chan interface{},
 	downloadCh chan<- downloadRequest, args *types.CreateArgs) error {
 	var err error
 
+	if args.Offline && args.Update {
+		return errors.New("Packages cannot be upgraded when creating an instance offline")
+	}
+	ctx = withOffline(ctx, args.Offline)
+
 	wkld, ws, transport, err := prepareCreate(ctx, args)
 	if err != nil {
 		return err
 	}
+	defer releaseTap(wkld.spec.VM.TapSubnet)
+
+	if err := ws.checkQuota(&c.cfg.Limits, &wkld.spec.VM); err != nil {
+		return err
+	}
+
+	hv, err := getHypervisor(ws, c.cfg, &wkld.spec)
+	if err != nil {
+		return err
+	}
 
 	_, err = os.Stat(ws.instanceDir)
 	if err == nil {
@@ -258,7 +628,11 @@ func (c ccvmBackend) createInstance(ctx context.Context, resultCh chan interface
 
 	defer func() {
 		if err != nil {
-			_ = os.RemoveAll(ws.instanceDir)
+			if releaseQuotaMounts(ws.instanceDir) == nil {
+				_ = os.RemoveAll(ws.instanceDir)
+				forgetWorkload(ws.instanceDir)
+			}
+			releaseVolumes(ws.ccvmDir, path.Base(ws.instanceDir))
 		}
 	}()
 
@@ -267,7 +641,32 @@ func (c ccvmBackend) createInstance(ctx context.Context, resultCh chan interface
 		return err
 	}
 
-	listener, port, err := createLocalListener()
+	if args.SSHCA {
+		wkld.spec.SSHCA = true
+	}
+
+	if wkld.spec.SSHCA {
+		err = prepareSSHCA(ctx, ws)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.SSHAgent {
+		wkld.spec.SSHAgent = true
+	}
+	err = checkAgentKeys(args.AgentKeys)
+	if err != nil {
+		return err
+	}
+	ws.agentKeys = args.AgentKeys
+	ws.retry = c.retryPolicy()
+	ws.mirrors, err = c.mirrors().withOverrides(args.Mirrors)
+	if err != nil {
+		return err
+	}
+
+	listener, port, err := createLocalListener(hv.listenAddress(ws.network))
 	if err != nil {
 		return err
 	}
@@ -283,24 +682,60 @@ func (c ccvmBackend) createInstance(ctx context.Context, resultCh chan interface
 		return errors.Wrap(err, "Error applying template to user-data")
 	}
 
+	if args.Offline {
+		err = checkOfflineDownloads(ctx, wkld, ws, downloadCh)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = wkld.save(ws.instanceDir)
 	if err != nil {
 		return errors.Wrap(err, "Unable to save instance state")
 	}
 
-	err = createImages(ctx, wkld, ws, args, transport, resultCh, downloadCh)
+	err = c.runHook(ctx, hookPreCreate, ws, args.Name, wkld)
+	if err != nil {
+		return err
+	}
+
+	err = createImages(ctx, wkld, ws, args, transport, resultCh, downloadCh, hv)
 	if err != nil {
 		return err
 	}
 
 	outputBootingMessage(args, wkld, ws, resultCh)
 
-	err = bootVM(ctx, ws, args.Name, &wkld.spec.VM)
+	bootSpec, err := prepareQuotaMounts(ctx, ws.instanceDir, &wkld.spec.VM)
+	if err != nil {
+		return err
+	}
+
+	// The instance is installed using the host's clock, as downloads
+	// may fail if the guest's clock is wrong.
+	if _, _, err := bootSpec.ClockSetting(); err != nil {
+		return err
+	}
+	bootSpec.ClockOffset = ""
+	bootSpec.FrozenTime = ""
+
+	// Likewise, instances on restricted networks are installed with
+	// access to external networks.
+	installNetwork := *ws.network
+	installNetwork.spec.Mode = types.NetworkModeNAT
+	ws.network = &installNetwork
+
+	err = hv.boot(ctx, ws, args.Name, bootSpec)
 	if err != nil {
 		return err
 	}
+	recordStatus(ws.instanceDir, true)
+	recordBootedSpec(ws.instanceDir, &wkld.spec.VM)
+	resetReadiness(ws.instanceDir)
+	rememberDiskKey(ws)
 
-	err = manageInstallation(ctx, resultCh, downloadCh, transport, listener, ws.instanceDir)
+	err = manageInstallation(ctx, resultCh, downloadCh, transport, ws.retry, listener,
+		ws.instanceDir, hv)
 
 	// Ownership of listener passes to manageInstallation
 	listener = nil
@@ -308,6 +743,17 @@ func (c ccvmBackend) createInstance(ctx context.Context, resultCh chan interface
 		return err
 	}
 
+	c.runHookLogged(ctx, hookPostStart, ws, args.Name, wkld)
+
+	if wkld.spec.Kubeconfig.Path != "" {
+		if _, err := c.fetchKubeconfig(ctx, ws, args.Name, wkld); err != nil {
+			instanceLog(args.Name).Warningf("Unable to retrieve kubeconfig: %v", err)
+			resultCh <- types.CreateResult{
+				Line: fmt.Sprintf("Unable to retrieve kubeconfig: %v\n", err),
+			}
+		}
+	}
+
 	resultCh <- types.CreateResult{
 		Line: fmt.Sprintf("VM successfully created!\n"),
 	}
@@ -315,22 +761,55 @@ func (c ccvmBackend) createInstance(ctx context.Context, resultCh chan interface
 	return nil
 }
 
-func (c ccvmBackend) start(ctx context.Context, name string, customSpec *types.VMSpec) error {
+func (c ccvmBackend) start(ctx context.Context, name string, customSpec *types.VMSpec, force bool) error {
 	ws, err := prepareEnv(ctx, name)
 	if err != nil {
 		return err
 	}
 
+	if !force && diskKnownBad(ws.instanceDir) {
+		return errors.Errorf("The disk of instance %s is corrupt.  Repair it with ccloudvm fsck --repair or use --force to start it anyway", name)
+	}
+
 	wkld, err := restoreWorkload(ws)
 	if err != nil {
 		return err
 	}
 	in := &wkld.spec.VM
 
-	err = in.MergeCustom(customSpec)
+	if rec := loadSuspension(ws.instanceDir); rec != nil {
+		resumeSpec := rec.VM
+		if err := resumeSpec.MergeCustom(customSpec); err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(&resumeSpec, &rec.VM) {
+			return errors.New("The resources of a suspended instance cannot be changed.  Discard its saved state with ccloudvm resume --discard first")
+		}
+		if err := c.resumeVM(ctx, ws, name, wkld, rec); err != nil {
+			return err
+		}
+		c.runHookLogged(ctx, hookPostStart, ws, name, wkld)
+		return nil
+	}
+
+	cur := *in
+	if err := applyCustomSpec(in, customSpec); err != nil {
+		return err
+	}
+	if err := checkKernelFiles(ws, in); err != nil {
+		return err
+	}
+
+	ws.network, err = instanceNetwork(ws.ccvmDir, in)
 	if err != nil {
 		return err
 	}
+	if err := ws.network.checkHostIP(in.HostIP); err != nil {
+		return err
+	}
+	if err := ws.network.checkPorts(in.PortMappings); err != nil {
+		return err
+	}
 
 	defaults := defaultVMSpec()
 	if in.MemMiB == 0 {
@@ -340,62 +819,546 @@ func (c ccvmBackend) start(ctx context.Context, name string, customSpec *types.V
 		in.CPUs = defaults.CPUs
 	}
 
-	if wkld.spec.NeedsNestedVM && !hostSupportsNestedKVM() {
-		return fmt.Errorf("nested KVM is not enabled.  Please enable and try again")
+	if err := checkNestedVirt(&wkld.spec); err != nil {
+		return err
 	}
 
 	if err := checkMemAvailable(in); err != nil {
 		return err
 	}
 
-	if err := wkld.save(ws.instanceDir); err != nil {
-		fmt.Printf("Warning: Failed to update instance state: %v", err)
+	if grows(&cur, in) {
+		if err := ws.checkQuota(&c.cfg.Limits, in); err != nil {
+			return err
+		}
 	}
 
-	fmt.Printf("Booting VM with %d MiB RAM and %d cpus\n", in.MemMiB, in.CPUs)
-
-	err = bootVM(ctx, ws, name, in)
+	hv, err := getHypervisor(ws, c.cfg, &wkld.spec)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("VM Started")
+	if err := wkld.save(ws.instanceDir); err != nil {
+		instanceLog(name).Warningf("Failed to update instance state: %v", err)
+	}
 
-	return nil
-}
+	if err := unlockDisk(ctx, ws, in); err != nil {
+		return err
+	}
 
-func (c ccvmBackend) stop(ctx context.Context, name string) error {
-	ws, err := prepareEnv(ctx, name)
+	// Apply any disk resize requested while the instance was running.
+	if in.Encrypt {
+		err = growEncryptedRootfs(ctx, ws.instanceDir, in.DiskGiB, ws.diskKey)
+	} else {
+		err = hv.growRootfs(ctx, ws.instanceDir, in.DiskGiB)
+	}
 	if err != nil {
 		return err
 	}
 
-	err = stopVM(ctx, ws.instanceDir)
+	if err := checkDataDisks(ws.instanceDir, in.Disks); err != nil {
+		return err
+	}
+
+	instanceLog(name).Infof("Booting VM with %d MiB RAM and %d cpus", in.MemMiB, in.CPUs)
+
+	bootSpec, err := prepareQuotaMounts(ctx, ws.instanceDir, in)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("VM Stopped")
+	err = hv.boot(ctx, ws, name, bootSpec)
+	if err != nil {
+		return err
+	}
+	recordStatus(ws.instanceDir, true)
+	recordBootedSpec(ws.instanceDir, in)
+	resetReadiness(ws.instanceDir)
+	rememberDiskKey(ws)
+	resolveCrash(ws.instanceDir)
+	resolvePressure(ws.instanceDir)
+
+	instanceLog(name).Infof("VM Started")
+
+	c.runHookLogged(ctx, hookPostStart, ws, name, wkld)
 
 	return nil
 }
 
+func (c ccvmBackend) stop(ctx context.Context, args *types.StopArgs) (*types.StopResult, error) {
+	ws, err := prepareEnv(ctx, args.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	hv, err := c.instanceHypervisor(ws)
+	if err != nil {
+		return nil, err
+	}
+
+	if hv.running(ctx, ws.instanceDir) {
+		c.runHookLogged(ctx, hookPreStop, ws, args.Name, nil)
+	}
+
+	if args.Timeout == 0 && !args.Force {
+		err = hv.stop(ctx, ws.instanceDir)
+		if err != nil {
+			return nil, err
+		}
+		clearStatus(ws.instanceDir)
+
+		instanceLog(args.Name).Infof("VM Stopped")
+
+		return &types.StopResult{Method: types.StopACPI}, nil
+	}
+
+	if !hv.running(ctx, ws.instanceDir) {
+		return nil, errNotRunning
+	}
+
+	poweroffCmd := "sudo poweroff"
+	if wkld, err := restoreWorkload(ws); err == nil && wkld.spec.windows() {
+		poweroffCmd = windowsPoweroffCmd
+	}
+
+	recordStatus(ws.instanceDir, false)
+	method, err := shutdownVM(ctx, hv, ws.instanceDir, args.Timeout, args.Force,
+		func(ctx context.Context) error {
+			_, err := c.execCommand(ctx, args.Name, poweroffCmd, ioutil.Discard, ioutil.Discard)
+			return err
+		})
+	if err != nil {
+		clearStatus(ws.instanceDir)
+		return nil, err
+	}
+
+	instanceLog(args.Name).Infof("VM Stopped (%s)", method)
+
+	return &types.StopResult{Method: method}, nil
+}
+
 func (c ccvmBackend) quit(ctx context.Context, name string) error {
 	ws, err := prepareEnv(ctx, name)
 	if err != nil {
 		return err
 	}
 
-	err = quitVM(ctx, ws.instanceDir)
+	hv, err := c.instanceHypervisor(ws)
+	if err != nil {
+		return err
+	}
+
+	if hv.running(ctx, ws.instanceDir) {
+		c.runHookLogged(ctx, hookPreStop, ws, name, nil)
+	}
+
+	err = hv.quit(ctx, ws.instanceDir)
 	if err != nil {
 		return err
 	}
+	recordStatus(ws.instanceDir, false)
 
-	fmt.Println("VM Quit")
+	instanceLog(name).Infof("VM Quit")
 
 	return nil
 }
 
+// applyCustomSpec merges the changes requested by customSpec into the
+// specification in of the VM of an instance and checks the result.
+func applyCustomSpec(in, customSpec *types.VMSpec) error {
+	if customSpec.Network != "" && networkName(customSpec.Network) != networkName(in.Network) {
+		return errors.New("The network of an instance cannot be changed")
+	}
+
+	if err := in.MergeCustom(customSpec); err != nil {
+		return err
+	}
+	if err := checkGuestIdentity(in); err != nil {
+		return err
+	}
+	if err := checkDirectKernel(in); err != nil {
+		return err
+	}
+	if err := checkCPUs(in); err != nil {
+		return err
+	}
+	return checkMemoryBackend(in)
+}
+
+// restart shuts down the VM of an instance, if it is running, and boots it
+// again.  The VM is quit if it has not shut down within args.Timeout.
+func (c ccvmBackend) restart(ctx context.Context, args *types.RestartArgs) error {
+	ws, err := prepareEnv(ctx, args.Name)
+	if err != nil {
+		return err
+	}
+	ctx = withPassphrase(ctx, args.Passphrase)
+
+	hv, err := c.instanceHypervisor(ws)
+	if err != nil {
+		return err
+	}
+
+	// The VM is only shut down once the changes requested are known to
+	// be valid, and the disk to be unlockable, so that a mistake does
+	// not leave it down.
+	if err := checkNotSuspended(ws.instanceDir); err != nil {
+		return err
+	}
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load instance state")
+	}
+	in := &wkld.spec.VM
+	if err := applyCustomSpec(in, &args.VMSpec); err != nil {
+		return err
+	}
+	if err := checkKernelFiles(ws, in); err != nil {
+		return err
+	}
+	if err := unlockDisk(ctx, ws, in); err != nil {
+		return err
+	}
+
+	if hv.running(ctx, ws.instanceDir) {
+		c.runHookLogged(ctx, hookPreStop, ws, args.Name, wkld)
+		recordStatus(ws.instanceDir, false)
+		if _, err := shutdownVM(ctx, hv, ws.instanceDir, args.Timeout, true, nil); err != nil {
+			return err
+		}
+		instanceLog(args.Name).Infof("VM Stopped")
+	}
+
+	return c.start(ctx, args.Name, &args.VMSpec, false)
+}
+
+// guestPoweroffTimeout is the time given to the poweroff command of the
+// guest to be issued over SSH.
+const guestPoweroffTimeout = 10 * time.Second
+
+// shutdownVM asks the VM to shut down with ACPI, and then with poweroff if
+// it is not nil, and waits timeout for it to do so.  The VM is quit if it
+// is still running after timeout and force is true.  shutdownVM returns one
+// of the types.Stop constants.
+func shutdownVM(ctx context.Context, hv hypervisor, instanceDir string, timeout time.Duration,
+	force bool, poweroff func(context.Context) error) (string, error) {
+	if timeout > 0 {
+		start := time.Now()
+		deadline := start.Add(timeout)
+		method := types.StopACPI
+		if err := hv.stop(ctx, instanceDir); err != nil {
+			logDebugf("ACPI shutdown of %s failed: %v", instanceDir, err)
+			method = ""
+		}
+		for time.Now().Before(deadline) && (method != "" || poweroff != nil) {
+			if !hv.running(ctx, instanceDir) {
+				return method, nil
+			}
+			if poweroff != nil && (method == "" || time.Since(start) >= timeout/2) {
+				pctx, cancel := context.WithTimeout(ctx, guestPoweroffTimeout)
+				if err := poweroff(pctx); err != nil {
+					logDebugf("Guest shutdown of %s failed: %v", instanceDir, err)
+				} else {
+					method = types.StopGuest
+				}
+				cancel()
+				poweroff = nil
+			}
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(time.Second / 2):
+			}
+		}
+		if !hv.running(ctx, instanceDir) && method != "" {
+			return method, nil
+		}
+		if !force {
+			return "", errors.Errorf("The VM did not shut down within %v", timeout)
+		}
+	}
+
+	if err := hv.quit(ctx, instanceDir); err != nil && hv.running(ctx, instanceDir) {
+		return "", err
+	}
+	return types.StopQuit, nil
+}
+
+func (c ccvmBackend) resize(ctx context.Context, name string, args *types.ResizeArgs) (*types.ResizeResult, error) {
+	if args.CPUs < 0 || args.MemMiB < 0 || args.DiskGiB < 0 {
+		return nil, errors.New("Resources must be positive")
+	}
+
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkNotSuspended(ws.instanceDir); err != nil {
+		return nil, err
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to load instance state")
+	}
+
+	hv, err := getHypervisor(ws, c.cfg, &wkld.spec)
+	if err != nil {
+		return nil, err
+	}
+
+	cur := wkld.spec.VM
+	next := cur
+	if args.CPUs != 0 {
+		if cur.HasTopology() && args.CPUs != cur.CPUs {
+			return nil, errors.New("The number of CPUs of an instance with a CPU topology cannot be changed")
+		}
+		next.CPUs = args.CPUs
+	}
+	if args.MemMiB != 0 {
+		next.MemMiB = args.MemMiB
+	}
+	if args.DiskGiB != 0 {
+		if args.DiskGiB < cur.DiskGiB {
+			return nil, fmt.Errorf("Disk cannot be shrunk below %d GiB", cur.DiskGiB)
+		}
+		next.DiskGiB = args.DiskGiB
+	}
+
+	if grows(&cur, &next) {
+		if err := ws.checkQuota(&c.cfg.Limits, &next); err != nil {
+			return nil, err
+		}
+	}
+
+	var res types.ResizeResult
+	if hv.running(ctx, ws.instanceDir) {
+		live, err := hv.resize(ctx, ws.instanceDir, &cur, &next)
+		if err != nil {
+			return nil, err
+		}
+		res.RestartRequired = !live
+	} else if next.DiskGiB != cur.DiskGiB {
+		err = hv.growRootfs(ctx, ws.instanceDir, next.DiskGiB)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	wkld.spec.VM = next
+	err = wkld.save(ws.instanceDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to save instance state")
+	}
+
+	return &res, nil
+}
+
+func (c ccvmBackend) forward(ctx context.Context, name string, p types.PortMapping, add bool) error {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load instance state")
+	}
+	in := &wkld.spec.VM
+
+	hv, err := getHypervisor(ws, c.cfg, &wkld.spec)
+	if err != nil {
+		return err
+	}
+
+	n, err := loadNetwork(ws.ccvmDir, in.Network)
+	if err != nil {
+		return err
+	}
+	if add {
+		if err := n.checkPorts([]types.PortMapping{p}); err != nil {
+			return err
+		}
+	}
+
+	index := -1
+	for i, m := range in.PortMappings {
+		if add && m.HostAddr == p.HostAddr && (m.Host == p.Host || m.Guest == p.Guest) {
+			return fmt.Errorf("Port mapping %s conflicts with existing mapping %s", p, m)
+		}
+		if !add && m == p {
+			index = i
+		}
+	}
+
+	if !add {
+		if index == -1 {
+			return fmt.Errorf("Port mapping %s not found", p)
+		}
+		if p.Guest == 22 && p.HostAddr == "" {
+			return errors.New("The SSH port mapping cannot be removed")
+		}
+	}
+
+	if hv.running(ctx, ws.instanceDir) {
+		err = hv.forward(ctx, ws.instanceDir, in, n, p, add)
+		if err != nil {
+			return err
+		}
+	}
+
+	if add {
+		in.PortMappings = append(in.PortMappings, p)
+	} else {
+		in.PortMappings = append(in.PortMappings[:index], in.PortMappings[index+1:]...)
+	}
+
+	err = wkld.save(ws.instanceDir)
+	if err != nil {
+		return errors.Wrap(err, "Unable to save instance state")
+	}
+
+	return nil
+}
+
+func (c ccvmBackend) mount(ctx context.Context, name string, m types.Mount, add bool) error {
+	if add {
+		if m.QuotaMiB != 0 {
+			return errors.New("Quotas are not supported for mounts added to existing instances")
+		}
+		if m.FSType() != types.MountTypeVirtiofs {
+			return errors.New("Only virtiofs mounts can be added to existing instances")
+		}
+		if err := m.Check(); err != nil {
+			return err
+		}
+	}
+
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if add {
+		if err := ws.checkUserPath(m.Path); err != nil {
+			return err
+		}
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load instance state")
+	}
+	in := &wkld.spec.VM
+
+	hv, err := getHypervisor(ws, c.cfg, &wkld.spec)
+	if err != nil {
+		return err
+	}
+
+	index := -1
+	for i := range in.Mounts {
+		if in.Mounts[i].Tag == m.Tag {
+			index = i
+			break
+		}
+	}
+
+	if add && index != -1 {
+		return fmt.Errorf("Mount %s already exists", m.Tag)
+	} else if !add && index == -1 {
+		return fmt.Errorf("Mount %s not found", m.Tag)
+	}
+
+	if hv.running(ctx, ws.instanceDir) {
+		if add {
+			err = hv.addMount(ctx, ws.instanceDir, &m)
+		} else if in.Mounts[index].FSType() != types.MountTypeVirtiofs {
+			err = errors.New("9p mounts cannot be removed from running instances")
+		} else {
+			err = hv.removeMount(ctx, ws.instanceDir, &in.Mounts[index])
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if add {
+		in.Mounts = append(in.Mounts, m)
+	} else {
+		in.Mounts = append(in.Mounts[:index], in.Mounts[index+1:]...)
+	}
+
+	err = wkld.save(ws.instanceDir)
+	if err != nil {
+		return errors.Wrap(err, "Unable to save instance state")
+	}
+
+	return nil
+}
+
+func (c ccvmBackend) fsck(ctx context.Context, name string, repair bool) (*types.FsckResult, error) {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	hv, err := c.instanceHypervisor(ws)
+	if err != nil {
+		return nil, err
+	}
+
+	if hv.running(ctx, ws.instanceDir) {
+		return nil, errors.New("The instance must be stopped before its disk can be checked")
+	}
+	if repair {
+		if err := checkNotSuspended(ws.instanceDir); err != nil {
+			return nil, err
+		}
+	}
+
+	return checkInstanceDisk(ctx, ws.instanceDir, repair)
+}
+
+func (c ccvmBackend) flatten(ctx context.Context, name string) error {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	hv, err := c.instanceHypervisor(ws)
+	if err != nil {
+		return err
+	}
+
+	if hv.running(ctx, ws.instanceDir) {
+		return errors.New("The instance must be stopped before its disk can be flattened")
+	}
+
+	vmImage := path.Join(ws.instanceDir, "image.qcow2")
+	if _, err := os.Stat(vmImage); err != nil {
+		return errors.New("Only instances with qcow2 disks can be flattened")
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load instance state")
+	}
+	if wkld.spec.VM.Encrypt {
+		return errors.New("Encrypted disks cannot be flattened")
+	}
+
+	flattened, err := flattenImage(ctx, vmImage)
+	if err != nil {
+		return err
+	}
+	if !flattened {
+		logInfof("The disk of %s does not depend on a base image", name)
+	}
+	return nil
+}
+
 func (c ccvmBackend) status(ctx context.Context, name string) (*types.InstanceDetails, error) {
 	ws, err := prepareEnv(ctx, name)
 	if err != nil {
@@ -408,33 +1371,176 @@ func (c ccvmBackend) status(ctx context.Context, name string) (*types.InstanceDe
 	}
 	in := &wkld.spec.VM
 
-	sshPort, err := in.SSHPort()
+	sshHost, sshPort, err := sshEndpoint(in)
 	if err != nil {
 		return nil, fmt.Errorf("Instance does not have SSH port open.  Unable to determine status")
 	}
 
+	var certPath string
+	if wkld.spec.SSHCA {
+		certPath = sshCertificatePath(ws)
+	}
+
+	// Only qemu's user mode networking supports IPv6.
+	var guestIPv6 []string
+	if in.Hypervisor == "" || in.Hypervisor == hypervisorQemu {
+		if n, err := loadNetwork(ws.ccvmDir, in.Network); err == nil {
+			mac := guestMACAddress(in)
+			guestIPv6 = []string{n.guestIPv6(mac), guestLinkLocalIPv6(mac)}
+		}
+	}
+
+	crash := loadCrash(ws.instanceDir)
+	pressure := loadPressure(ws.instanceDir)
+	status := loadStatus(ws.instanceDir)
+	var ready readyRecord
+	if status.Running {
+		ready = loadReadiness(ws.instanceDir)
+	}
 	return &types.InstanceDetails{
 		Name: name,
 		SSH: types.SSHDetails{
-			KeyPath: ws.keyPath,
-			Port:    sshPort,
+			KeyPath:      ws.keyPath,
+			CertPath:     certPath,
+			Host:         sshHost,
+			Port:         sshPort,
+			ForwardAgent: wkld.spec.SSHAgent,
+		},
+		Workload:     wkld.spec.WorkloadName,
+		VMSpec:       *in,
+		Status:       status,
+		Group:        wkld.spec.Group,
+		Role:         wkld.spec.Role,
+		Labels:       wkld.spec.Labels,
+		Notification: loadNotification(ws.instanceDir),
+		Usage:        instanceUsageTotal(ws.ccvmDir, name),
+		LiveUsage:    instanceLiveUsage(ctx, ws.instanceDir),
+		Crashed:      crash.Active,
+		LastCrash: types.CrashInfo{
+			Time:   crash.Time,
+			Reason: crash.Reason,
+			Output: crash.Output,
+		},
+		Degraded: pressure.Active,
+		Pressure: types.PressureInfo{
+			Since:  pressure.Since,
+			Reason: pressure.Reason,
 		},
-		Workload: wkld.spec.WorkloadName,
-		VMSpec:   *in,
+		GuestIPv6:    guestIPv6,
+		Firmware:     firmwareType(in),
+		Suspended:    loadSuspension(ws.instanceDir) != nil,
+		Ready:        ready.State,
+		ReadyError:   ready.Error,
+		DisplayPort:  in.DisplayPort(),
+		Arch:         guestArch(in),
+		Emulated:     !qemuAccelerated(guestArch(in)),
+		VsockCID:     vsockCID(in),
+		DockerSocket: dockerSocketPath(ws.instanceDir, in),
 	}, nil
 }
 
+// sshDetails returns the details of the instance called name, signing a
+// new certificate for the instances accessed with the SSH CA.
+func (c ccvmBackend) sshDetails(ctx context.Context, name string) (*types.InstanceDetails, error) {
+	details, err := c.status(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if details.SSH.CertPath != "" {
+		ws, err := prepareEnv(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if err := mintSSHCertificate(ctx, ws, name); err != nil {
+			return nil, err
+		}
+	}
+	return details, nil
+}
+
+// sshKey returns the SSH key, and the certificate, of an instance.
+func (c ccvmBackend) sshKey(ctx context.Context, name string) (*types.SSHKeyResult, error) {
+	details, err := c.sshDetails(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var res types.SSHKeyResult
+	res.PrivateKey, err = ioutil.ReadFile(details.SSH.KeyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read SSH key")
+	}
+	if details.SSH.CertPath != "" {
+		res.Certificate, err = ioutil.ReadFile(details.SSH.CertPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read SSH certificate")
+		}
+	}
+
+	return &res, nil
+}
+
+func (c ccvmBackend) refreshStatus(ctx context.Context, name string, maxStaleness time.Duration) (*types.InstanceDetails, error) {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	status := loadStatus(ws.instanceDir)
+	if status.Checked.IsZero() || time.Since(status.Checked) > maxStaleness {
+		wkld, err := restoreWorkload(ws)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to load instance state")
+		}
+
+		hv, err := getHypervisor(ws, c.cfg, &wkld.spec)
+		if err != nil {
+			return nil, err
+		}
+
+		err = saveStatus(ws.instanceDir, probeStatus(ctx, hv, ws.instanceDir, &wkld.spec.VM))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.status(ctx, name)
+}
+
 func (c ccvmBackend) deleteInstance(ctx context.Context, name string) error {
 	ws, err := prepareEnv(ctx, name)
 	if err != nil {
 		return err
 	}
 
-	_ = quitVM(ctx, ws.instanceDir)
+	if hv, err := c.instanceHypervisor(ws); err == nil {
+		if hv.running(ctx, ws.instanceDir) {
+			c.runHookLogged(ctx, hookPreStop, ws, name, nil)
+		}
+		_ = hv.quit(ctx, ws.instanceDir)
+	}
+	removeMdevs(ws.instanceDir)
+	releaseVFIO(ws.instanceDir)
+	killSwtpm(ws.instanceDir)
+
+	// The images backing mounts with quotas must be unmounted before the
+	// instance directory is removed.
+	err = releaseQuotaMounts(ws.instanceDir)
+	if err != nil {
+		return errors.Wrap(err, "unable to delete instance")
+	}
+
 	err = os.RemoveAll(ws.instanceDir)
 	if err != nil {
 		return errors.Wrap(err, "unable to delete instance")
 	}
+	forgetWorkload(ws.instanceDir)
+	forgetDiskKey(diskKeyName(ws.instanceDir))
+
+	// The named volumes used by the instance are only unlinked from its
+	// directory and can be attached to other instances.
+	releaseVolumes(ws.ccvmDir, name)
 
 	return nil
 }
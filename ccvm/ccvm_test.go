@@ -141,7 +141,7 @@ func createDownloader() (*downloader, error) {
 }
 
 func TestSystem(t *testing.T) {
-	b := ccvmBackend{}
+	b := ccvmBackend{cfg: &daemonConfig{}}
 	ctx, cancelFunc := context.WithTimeout(context.Background(), standardTimeout)
 	defer func() {
 		cancelFunc()
@@ -218,17 +218,17 @@ func TestSystem(t *testing.T) {
 	close(doneCh)
 	wg.Wait()
 
-	err = b.start(ctx, name, vmSpec)
+	err = b.start(ctx, name, vmSpec, false)
 	if err == nil || err == context.DeadlineExceeded {
 		t.Errorf("Start expected to fail")
 	}
 
-	err = b.stop(ctx, name)
+	_, err = b.stop(ctx, &types.StopArgs{Name: name})
 	if err != nil {
 		t.Errorf("Failed to Stop instance: %v", err)
 	}
 
-	err = b.start(ctx, name, vmSpec)
+	err = b.start(ctx, name, vmSpec, false)
 	if err != nil {
 		t.Errorf("Failed to Restart instance: %v", err)
 	}
@@ -0,0 +1,64 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+// Instances running under qemu can be given a clock that is offset from, or
+// frozen relative to, the host's clock.  The setting is passed to the guest
+// via fw_cfg and enforced at boot by the ccvm-guest helper.
+
+// clockFwCfgName is the name of the fw_cfg file containing the clock
+// setting.  Its content is the mode, offset or frozen, followed by the
+// time, in seconds since the epoch, at which the VM's clock is set.
+const clockFwCfgName = "opt/org.ccloudvm/clock"
+
+// clockArgs returns the qemu arguments that apply the clock setting of in to
+// a VM booted at now.  No arguments are returned if the VM uses the host's
+// clock.
+func clockArgs(in *types.VMSpec, now time.Time) ([]string, error) {
+	offset, frozen, err := in.ClockSetting()
+	if err != nil {
+		return nil, err
+	}
+
+	mode := "frozen"
+	t := frozen
+	if frozen.IsZero() {
+		if offset == 0 {
+			return nil, nil
+		}
+		mode = "offset"
+		t = now.Add(offset)
+	}
+
+	return []string{
+		"-rtc", fmt.Sprintf("base=%s,clock=vm", t.UTC().Format("2006-01-02T15:04:05")),
+		"-fw_cfg", fmt.Sprintf("name=%s,string=%s %d", clockFwCfgName, mode, t.Unix()),
+	}, nil
+}
+
+// clockSet returns true if the VM does not use the host's clock.
+func clockSet(in *types.VMSpec) bool {
+	offset, frozen, err := in.ClockSetting()
+	return err != nil || offset != 0 || !frozen.IsZero()
+}
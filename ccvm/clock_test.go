@@ -0,0 +1,96 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestClockArgs(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec types.VMSpec
+		args []string
+	}{
+		{types.VMSpec{}, nil},
+		{types.VMSpec{ClockOffset: "0"}, nil},
+		{
+			types.VMSpec{ClockOffset: "365d"},
+			[]string{
+				"-rtc", "base=2027-10-15T12:00:00,clock=vm",
+				"-fw_cfg", "name=opt/org.ccloudvm/clock,string=offset 1823601600",
+			},
+		},
+		{
+			types.VMSpec{ClockOffset: "-36h"},
+			[]string{
+				"-rtc", "base=2026-10-14T00:00:00,clock=vm",
+				"-fw_cfg", "name=opt/org.ccloudvm/clock,string=offset 1791936000",
+			},
+		},
+		{
+			types.VMSpec{FrozenTime: "2030-01-01"},
+			[]string{
+				"-rtc", "base=2030-01-01T00:00:00,clock=vm",
+				"-fw_cfg", "name=opt/org.ccloudvm/clock,string=frozen 1893456000",
+			},
+		},
+		{
+			types.VMSpec{FrozenTime: "2030-01-01T12:00:00+01:00"},
+			[]string{
+				"-rtc", "base=2030-01-01T11:00:00,clock=vm",
+				"-fw_cfg", "name=opt/org.ccloudvm/clock,string=frozen 1893495600",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		args, err := clockArgs(&tt.spec, now)
+		if err != nil {
+			t.Errorf("Unexpected error for %+v: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("Unexpected arguments for %+v: %v", tt.spec, args)
+		}
+		if clockSet(&tt.spec) != (tt.args != nil) {
+			t.Errorf("clockSet incorrect for %+v", tt.spec)
+		}
+	}
+}
+
+func TestClockArgsInvalid(t *testing.T) {
+	specs := []types.VMSpec{
+		{ClockOffset: "tomorrow"},
+		{ClockOffset: "1.5d"},
+		{FrozenTime: "next year"},
+		{ClockOffset: "1d", FrozenTime: "2030-01-01"},
+	}
+
+	for i := range specs {
+		if _, err := clockArgs(&specs[i], time.Now()); err == nil {
+			t.Errorf("Expected error for %+v", specs[i])
+		}
+		if !clockSet(&specs[i]) {
+			t.Errorf("clockSet should be true for %+v", specs[i])
+		}
+	}
+}
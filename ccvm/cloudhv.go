@@ -0,0 +1,258 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+const cloudHVCmdline = "console=hvc0 root=/dev/vda1 rw"
+
+// cloudHypervisor boots instances using cloud-hypervisor, from the kernel
+// or the firmware of the workload.  Like firecracker guests, the guest is
+// connected to a tap device.  Only virtiofs mounts are supported.
+type cloudHypervisor struct{}
+
+func (cloudHypervisor) createRootfs(ctx context.Context, backingImage, instanceDir string, disk int) error {
+	return createRootfs(ctx, backingImage, instanceDir, disk)
+}
+
+func (cloudHypervisor) growRootfs(ctx context.Context, instanceDir string, disk int) error {
+	return growImage(ctx, path.Join(instanceDir, "image.qcow2"), "qcow2", disk)
+}
+
+func (cloudHypervisor) listenAddress(n *vmNetwork) string {
+	return n.hostIP()
+}
+
+// cloudHVArgs returns the arguments of cloud-hypervisor for the VM in,
+// connected to the network n.  The NoCloud seed, if any, is appended to the
+// command line of its kernel.
+func cloudHVArgs(instanceDir, name string, in *types.VMSpec, n *vmNetwork, seed string) ([]string, error) {
+	socket := path.Join(instanceDir, "cloud-hypervisor.socket")
+	args := []string{
+		"--api-socket", socket,
+		"--cpus", fmt.Sprintf("boot=%d", in.CPUs),
+		"--disk",
+		fmt.Sprintf("path=%s", path.Join(instanceDir, "image.qcow2")),
+	}
+	if datasourceType(in) == types.DatasourceNoCloud {
+		args = append(args, fmt.Sprintf("path=%s,readonly=on", path.Join(instanceDir, "config.iso")))
+	}
+
+	for _, d := range in.Drives {
+		args = append(args, fmt.Sprintf("path=%s", d.Path))
+	}
+	for _, d := range in.Disks {
+		args = append(args, fmt.Sprintf("path=%s,id=%s,serial=%s",
+			dataDiskPath(instanceDir, d.Name), dataDiskID(d.Name), d.Name))
+	}
+
+	kernelPath := path.Join(instanceDir, "kernel")
+	BIOSPath := path.Join(instanceDir, "BIOS")
+	if _, err := os.Stat(kernelPath); err == nil {
+		cmdline := cloudHVCmdline + " " + staticIPArg(n)
+		args = append(args, "--kernel", kernelPath, "--cmdline", appendSeed(cmdline, seed))
+	} else if _, err := os.Stat(BIOSPath); err == nil {
+		args = append(args, "--kernel", BIOSPath)
+	} else {
+		return nil, errors.New("The cloud-hypervisor hypervisor requires a workload with a kernel or a bios")
+	}
+
+	// virtio-fs requires the guest memory to be shared with virtiofsd.
+	// It is always shared so that mounts can be added to running
+	// instances.
+	args = append(args, "--memory", fmt.Sprintf("size=%dM,shared=on", in.MemMiB))
+
+	netParam := fmt.Sprintf("tap=%s,ip=%s,mask=%s", firecrackerTapName(name), n.hostIP(),
+		net.IP(n.subnet.Mask))
+	if in.MACAddress != "" {
+		netParam += ",mac=" + in.MACAddress
+	}
+	args = append(args, "--net", netParam)
+	args = append(args, "--serial", "tty", "--console", "off")
+
+	return args, nil
+}
+
+func (cloudHypervisor) boot(ctx context.Context, ws *workspace, name string, in *types.VMSpec) error {
+	if processRunning(ws.instanceDir, "cloud-hypervisor") {
+		return fmt.Errorf("VM is already running")
+	}
+
+	if err := checkQemuOnlyFeatures(ws, in, hypervisorCloudHypervisor); err != nil {
+		return err
+	}
+
+	if in.MemoryBackend != "" && in.MemoryBackend != types.MemoryBackendMemfd {
+		return errors.Errorf("The %s memory backend is not supported by cloud-hypervisor", in.MemoryBackend)
+	}
+
+	for i := range in.Mounts {
+		if in.Mounts[i].FSType() != types.MountTypeVirtiofs {
+			return errors.Errorf("Mount %s must be of type virtiofs to be used with cloud-hypervisor",
+				in.Mounts[i].Tag)
+		}
+	}
+
+	args, err := cloudHVArgs(ws.instanceDir, name, in, ws.network, kernelSeed(ws, in))
+	if err != nil {
+		return err
+	}
+
+	if len(in.Mounts) > 0 {
+		args = append(args, "--fs")
+	}
+	for i := range in.Mounts {
+		m := &in.Mounts[i]
+		socket, err := startVirtiofsd(ws.instanceDir, m)
+		if err != nil {
+			killVirtiofsd(ws.instanceDir)
+			return err
+		}
+		args = append(args, fmt.Sprintf("tag=%s,socket=%s,id=%s", m.Tag, socket,
+			virtiofsDeviceID(m.Tag)))
+	}
+
+	_ = os.Remove(path.Join(ws.instanceDir, "cloud-hypervisor.socket"))
+
+	err = launchProcess(ws.instanceDir, "cloud-hypervisor", "cloud-hypervisor", args...)
+	if err != nil {
+		killVirtiofsd(ws.instanceDir)
+	}
+	return err
+}
+
+func (cloudHypervisor) stop(ctx context.Context, instanceDir string) error {
+	if !processRunning(instanceDir, "cloud-hypervisor") {
+		return errors.New("Failed to connect to VM")
+	}
+
+	return putAPIRequest(ctx, path.Join(instanceDir, "cloud-hypervisor.socket"),
+		"http://localhost/api/v1/vm.power-button", "")
+}
+
+func (cloudHypervisor) quit(ctx context.Context, instanceDir string) error {
+	defer killVirtiofsd(instanceDir)
+	return killProcess(instanceDir, "cloud-hypervisor")
+}
+
+func (cloudHypervisor) watch(ctx context.Context, instanceDir string) (*vmWatcher, error) {
+	return watchProcess(instanceDir, "cloud-hypervisor")
+}
+
+func (cloudHypervisor) monitor(ctx context.Context, instanceDir string) (*vmExit, error) {
+	return monitorProcess(ctx, instanceDir, "cloud-hypervisor")
+}
+
+func (cloudHypervisor) running(ctx context.Context, instanceDir string) bool {
+	return processRunning(instanceDir, "cloud-hypervisor")
+}
+
+func (cloudHypervisor) resize(ctx context.Context, instanceDir string, cur, next *types.VMSpec) (bool, error) {
+	return false, nil
+}
+
+func (cloudHypervisor) forward(ctx context.Context, instanceDir string, in *types.VMSpec, n *vmNetwork, p types.PortMapping,
+	add bool) error {
+	return errors.New("Port mappings are not supported by cloud-hypervisor")
+}
+
+type cloudHVFsConfig struct {
+	ID        string `json:"id"`
+	Tag       string `json:"tag"`
+	Socket    string `json:"socket"`
+	NumQueues int    `json:"num_queues"`
+	QueueSize int    `json:"queue_size"`
+}
+
+func (cloudHypervisor) addMount(ctx context.Context, instanceDir string, m *types.Mount) error {
+	socket, err := startVirtiofsd(instanceDir, m)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&cloudHVFsConfig{
+		ID:        virtiofsDeviceID(m.Tag),
+		Tag:       m.Tag,
+		Socket:    socket,
+		NumQueues: 1,
+		QueueSize: 1024,
+	})
+	if err != nil {
+		_ = killProcess(instanceDir, virtiofsdName(m.Tag))
+		return errors.Wrap(err, "Unable to marshal fs configuration")
+	}
+
+	err = putAPIRequest(ctx, path.Join(instanceDir, "cloud-hypervisor.socket"),
+		"http://localhost/api/v1/vm.add-fs", string(data))
+	if err != nil {
+		_ = killProcess(instanceDir, virtiofsdName(m.Tag))
+		return err
+	}
+
+	return nil
+}
+
+type cloudHVDiskConfig struct {
+	Path   string `json:"path"`
+	ID     string `json:"id"`
+	Serial string `json:"serial"`
+}
+
+func (cloudHypervisor) attachDisk(ctx context.Context, instanceDir string, d *types.Disk) error {
+	data, err := json.Marshal(&cloudHVDiskConfig{
+		Path:   dataDiskPath(instanceDir, d.Name),
+		ID:     dataDiskID(d.Name),
+		Serial: d.Name,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal disk configuration")
+	}
+
+	return putAPIRequest(ctx, path.Join(instanceDir, "cloud-hypervisor.socket"),
+		"http://localhost/api/v1/vm.add-disk", string(data))
+}
+
+func (cloudHypervisor) detachDisk(ctx context.Context, instanceDir string, d *types.Disk) error {
+	return putAPIRequest(ctx, path.Join(instanceDir, "cloud-hypervisor.socket"),
+		"http://localhost/api/v1/vm.remove-device",
+		fmt.Sprintf(`{"id": %q}`, dataDiskID(d.Name)))
+}
+
+func (cloudHypervisor) removeMount(ctx context.Context, instanceDir string, m *types.Mount) error {
+	err := putAPIRequest(ctx, path.Join(instanceDir, "cloud-hypervisor.socket"),
+		"http://localhost/api/v1/vm.remove-device",
+		fmt.Sprintf(`{"id": %q}`, virtiofsDeviceID(m.Tag)))
+	if err != nil {
+		return err
+	}
+
+	if processRunning(instanceDir, virtiofsdName(m.Tag)) {
+		_ = killProcess(instanceDir, virtiofsdName(m.Tag))
+	}
+
+	return nil
+}
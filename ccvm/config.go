@@ -0,0 +1,134 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// qemuConfig identifies the qemu binary used to run instances.  It can be
+// specified both in the daemon configuration file and in a workload.  Values
+// specified in the workload take precedence, except in system mode where
+// workloads cannot specify them.
+type qemuConfig struct {
+	Path       string `yaml:"path"`
+	MinVersion string `yaml:"min_version"`
+}
+
+const defaultIdleTimeout = time.Minute
+
+// serviceConfig contains the settings that control the lifetime of the
+// daemon.  IdleTimeout is the duration after which an idle daemon exits, 0
+// keeping it running.
+type serviceConfig struct {
+	IdleTimeout string `yaml:"idle_timeout"`
+}
+
+// idleTimeout returns the idle timeout described by c, or the default
+// timeout if c does not specify one.
+func (c *serviceConfig) idleTimeout() (time.Duration, error) {
+	if c.IdleTimeout == "" {
+		return defaultIdleTimeout, nil
+	}
+
+	v, err := time.ParseDuration(c.IdleTimeout)
+	if err != nil || v < 0 {
+		return 0, errors.Errorf("Invalid idle timeout %s", c.IdleTimeout)
+	}
+	return v, nil
+}
+
+// daemonConfig contains the daemon wide settings read from
+// ~/.ccloudvm/config.yaml.  The file is optional.
+type daemonConfig struct {
+	Qemu          qemuConfig           `yaml:"qemu"`
+	Guest         guestConfig          `yaml:"guest"`
+	Notifications []notificationConfig `yaml:"notifications"`
+	Accounting    accountingConfig     `yaml:"accounting"`
+	Retry         retryConfig          `yaml:"retry"`
+	Logging       logConfig            `yaml:"logging"`
+	Transactions  transactionConfig    `yaml:"transactions"`
+	Service       serviceConfig        `yaml:"service"`
+	System        systemConfig         `yaml:"system"`
+	Remote        remoteAccessConfig   `yaml:"remote"`
+	Auth          authConfig           `yaml:"auth"`
+	Limits        resourceQuota        `yaml:"limits"`
+	Mirrors       mirrorList           `yaml:"mirrors"`
+	Hooks         hooksConfig          `yaml:"hooks"`
+}
+
+func (q *qemuConfig) merge(parent *qemuConfig) {
+	if q.Path == "" {
+		q.Path = parent.Path
+	}
+	if q.MinVersion == "" {
+		q.MinVersion = parent.MinVersion
+	}
+}
+
+func loadDaemonConfig(ccvmDir string) (*daemonConfig, error) {
+	var cfg daemonConfig
+
+	cfgPath := filepath.Join(ccvmDir, "config.yaml")
+	data, err := ioutil.ReadFile(cfgPath)
+	if os.IsNotExist(err) {
+		return &cfg, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read %s", cfgPath)
+	}
+
+	err = yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse %s", cfgPath)
+	}
+
+	if _, err := cfg.Retry.policy(); err != nil {
+		return nil, errors.Wrapf(err, "Invalid retry settings in %s", cfgPath)
+	}
+	if _, err := cfg.Transactions.policy(); err != nil {
+		return nil, errors.Wrapf(err, "Invalid transaction settings in %s", cfgPath)
+	}
+	if _, err := cfg.Service.idleTimeout(); err != nil {
+		return nil, errors.Wrapf(err, "Invalid service settings in %s", cfgPath)
+	}
+	if err := cfg.Limits.validate(); err != nil {
+		return nil, errors.Wrapf(err, "Invalid limits in %s", cfgPath)
+	}
+	if err := cfg.System.Quota.validate(); err != nil {
+		return nil, errors.Wrapf(err, "Invalid quota in %s", cfgPath)
+	}
+	if err := cfg.System.checkDevices(); err != nil {
+		return nil, errors.Wrapf(err, "Invalid system settings in %s", cfgPath)
+	}
+	if err := cfg.Auth.validate(); err != nil {
+		return nil, errors.Wrapf(err, "Invalid auth settings in %s", cfgPath)
+	}
+	if err := cfg.Mirrors.validate(); err != nil {
+		return nil, errors.Wrapf(err, "Invalid mirrors in %s", cfgPath)
+	}
+	if _, err := cfg.Hooks.timeout(); err != nil {
+		return nil, errors.Wrapf(err, "Invalid hooks settings in %s", cfgPath)
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,387 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The serial console of instances running under qemu is exposed on a unix
+// socket in the instance directory, whose output the daemon copies to
+// console.log, rotated at consoleLogSize, and to the clients following it.
+
+const (
+	consoleSocket       = "console.sock"
+	consoleLogSize      = 1024 * 1024
+	consolePollInterval = 500 * time.Millisecond
+	consoleWriteTimeout = 5 * time.Second
+)
+
+// ringLog is a log file that is rotated when it grows larger than
+// consoleLogSize.
+type ringLog struct {
+	path string
+	f    *os.File
+	size int64
+}
+
+func (l *ringLog) open(flag int) error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|flag, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+func (l *ringLog) Write(p []byte) (int, error) {
+	if l.f == nil {
+		if err := l.open(os.O_APPEND); err != nil {
+			return 0, err
+		}
+	}
+
+	if l.size > 0 && l.size+int64(len(p)) > consoleLogSize {
+		l.close()
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return 0, err
+		}
+		if err := l.open(os.O_TRUNC); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *ringLog) close() {
+	if l.f != nil {
+		_ = l.f.Close()
+		l.f = nil
+	}
+}
+
+// lastLines returns the last n lines of data, or all of data if n is 0.
+func lastLines(data string, n int) string {
+	if n <= 0 {
+		return data
+	}
+
+	lines := strings.SplitAfter(data, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "")
+}
+
+// readConsoleLog returns the last lines lines of the log file logPath,
+// including the output that has been rotated out of it.
+func readConsoleLog(logPath string, lines int) (string, error) {
+	var data []byte
+	for _, p := range []string{logPath + ".1", logPath} {
+		d, err := ioutil.ReadFile(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", errors.Wrap(err, "Unable to read console log")
+		}
+		data = append(data, d...)
+	}
+
+	return lastLines(string(data), lines), nil
+}
+
+// consoleLogPath returns the path of the file to which the serial output of
+// an instance is logged.
+func (c ccvmBackend) consoleLogPath(ctx context.Context, name string) (string, error) {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to load instance state")
+	}
+
+	// The serial output of the other hypervisors is included in the
+	// output of their process.
+	logPath := path.Join(ws.instanceDir, consoleLog)
+	switch wkld.spec.VM.Hypervisor {
+	case hypervisorFirecracker, hypervisorCloudHypervisor:
+		logPath = path.Join(ws.instanceDir, wkld.spec.VM.Hypervisor+".log")
+	}
+
+	return logPath, nil
+}
+
+// consoleHub connects the serial console of an instance to the clients that
+// follow it.  Output is dropped for clients that do not keep up, as the
+// console must never block.
+type consoleHub struct {
+	sync.Mutex
+	conn   net.Conn
+	subs   map[chan string]struct{}
+	closed bool
+}
+
+// follow calls read, which reads the console log, and subscribes to the
+// console's output.  As output is logged while the hub is locked, none is
+// lost or duplicated between the two.
+func (h *consoleHub) follow(read func() error) (chan string, error) {
+	h.Lock()
+	defer h.Unlock()
+
+	if err := read(); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan string, 64)
+	if h.closed {
+		close(ch)
+		return ch, nil
+	}
+	if h.subs == nil {
+		h.subs = make(map[chan string]struct{})
+	}
+	h.subs[ch] = struct{}{}
+
+	return ch, nil
+}
+
+func (h *consoleHub) unsubscribe(ch chan string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.subs, ch)
+}
+
+// output logs data, read from the console, and delivers it to the
+// subscribers.
+func (h *consoleHub) output(log *ringLog, data []byte) {
+	h.Lock()
+	defer h.Unlock()
+
+	_, _ = log.Write(data)
+	for ch := range h.subs {
+		select {
+		case ch <- string(data):
+		default:
+		}
+	}
+}
+
+func (h *consoleHub) setConn(conn net.Conn) {
+	h.Lock()
+	h.conn = conn
+	h.Unlock()
+}
+
+// write sends data to the console.
+func (h *consoleHub) write(data string) error {
+	h.Lock()
+	conn := h.conn
+	h.Unlock()
+
+	if conn == nil {
+		return errors.New("Console is not connected.  Is the instance running?")
+	}
+
+	_ = conn.SetWriteDeadline(time.Now().Add(consoleWriteTimeout))
+	_, err := conn.Write([]byte(data))
+	if err != nil {
+		return errors.Wrap(err, "Unable to write to console")
+	}
+
+	return nil
+}
+
+// close closes the channels of all the subscribers.  It is called when the
+// instance is deleted.
+func (h *consoleHub) close() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.closed = true
+	for ch := range h.subs {
+		close(ch)
+	}
+	h.subs = nil
+}
+
+func captureConsole(ctx context.Context, hub *consoleHub, conn net.Conn, logPath string, closeCh <-chan struct{}) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-closeCh:
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+
+	log := &ringLog{path: logPath}
+	defer log.close()
+
+	hub.setConn(conn)
+	defer hub.setConn(nil)
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			hub.output(log, buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// watchConsole connects to the serial console of the instance whose
+// directory is instanceDir whenever the instance is running and captures
+// its output.  It returns when ctx is cancelled or when the instance's loop
+// quits.
+func watchConsole(ctx context.Context, hub *consoleHub, instanceDir string, closeCh <-chan struct{}) {
+	socket := path.Join(instanceDir, consoleSocket)
+	for {
+		if _, err := os.Stat(socket); err == nil {
+			conn, err := net.Dial("unix", socket)
+			if err == nil {
+				captureConsole(ctx, hub, conn, path.Join(instanceDir, consoleLog), closeCh)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-closeCh:
+			return
+		case <-time.After(consolePollInterval):
+		}
+	}
+}
+
+// consoleLog returns the console log of an instance and, if args.Follow is
+// true, the output of its console until the transaction is cancelled.
+func (s *ccvmService) consoleLog(ctx context.Context, args *types.ConsoleLogArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	hub := s.consoles[instanceName]
+
+	go func() {
+		defer close(resultCh)
+
+		send := func(v interface{}) bool {
+			select {
+			case resultCh <- v:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		logPath, err := s.b.consoleLogPath(ctx, instanceName)
+		if err != nil {
+			send(err)
+			return
+		}
+
+		var data string
+		read := func() error {
+			var err error
+			data, err = readConsoleLog(logPath, args.Lines)
+			return err
+		}
+
+		if !args.Follow {
+			if err := read(); err != nil {
+				send(err)
+			} else if send(types.ConsoleOutput{Data: data}) {
+				send(types.ConsoleOutput{Finished: true})
+			}
+			return
+		}
+
+		ch, err := hub.follow(read)
+		if err != nil {
+			send(err)
+			return
+		}
+		defer hub.unsubscribe(ch)
+
+		if !send(types.ConsoleOutput{Data: data}) {
+			return
+		}
+		for {
+			select {
+			case d, ok := <-ch:
+				if !ok {
+					send(types.ConsoleOutput{Finished: true})
+					return
+				}
+				if !send(types.ConsoleOutput{Data: d}) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *ccvmService) consoleInput(ctx context.Context, args *types.ConsoleInputArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	hub := s.consoles[instanceName]
+
+	go func() {
+		resultCh <- hub.write(args.Data)
+		close(resultCh)
+	}()
+}
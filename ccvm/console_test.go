@@ -0,0 +1,180 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLastLines(t *testing.T) {
+	tests := []struct {
+		data     string
+		n        int
+		expected string
+	}{
+		{"a\nb\nc\n", 0, "a\nb\nc\n"},
+		{"a\nb\nc\n", 2, "b\nc\n"},
+		{"a\nb\nc", 2, "b\nc"},
+		{"a\nb\n", 5, "a\nb\n"},
+		{"", 3, ""},
+	}
+
+	for _, tt := range tests {
+		if r := lastLines(tt.data, tt.n); r != tt.expected {
+			t.Errorf("lastLines(%q, %d) = %q, expected %q", tt.data, tt.n, r, tt.expected)
+		}
+	}
+}
+
+func TestRingLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	logPath := path.Join(dir, consoleLog)
+	log := &ringLog{path: logPath}
+	line := strings.Repeat("x", 1023) + "\n"
+	for i := 0; i < consoleLogSize/len(line)*3; i++ {
+		if _, err := log.Write([]byte(line)); err != nil {
+			t.Fatalf("Unable to write to log: %v", err)
+		}
+	}
+	log.close()
+
+	for _, p := range []string{logPath, logPath + ".1"} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("Unable to stat %s: %v", p, err)
+		}
+		if fi.Size() > consoleLogSize {
+			t.Errorf("%s is larger than %d bytes", p, consoleLogSize)
+		}
+	}
+
+	data, err := readConsoleLog(logPath, 0)
+	if err != nil {
+		t.Fatalf("Unable to read console log: %v", err)
+	}
+	if len(data) != 2*consoleLogSize {
+		t.Errorf("Expected %d bytes of log, found %d", 2*consoleLogSize, len(data))
+	}
+
+	data, err = readConsoleLog(logPath, 2)
+	if err != nil {
+		t.Fatalf("Unable to read console log: %v", err)
+	}
+	if data != line+line {
+		t.Errorf("Unexpected log tail %q", data)
+	}
+}
+
+func TestWatchConsole(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	listener, err := net.Listen("unix", path.Join(dir, consoleSocket))
+	if err != nil {
+		t.Fatalf("Unable to listen on console socket: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	closeCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	hub := &consoleHub{}
+	go func() {
+		watchConsole(ctx, hub, dir, closeCh)
+		close(doneCh)
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Unable to accept console connection: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	_, _ = conn.Write([]byte("boot messages\n"))
+
+	var data string
+	var ch chan string
+	for start := time.Now(); time.Since(start) < 5*time.Second; {
+		ch, err = hub.follow(func() error {
+			var err error
+			data, err = readConsoleLog(path.Join(dir, consoleLog), 0)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Unable to follow console: %v", err)
+		}
+		if data != "" {
+			break
+		}
+		hub.unsubscribe(ch)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if data != "boot messages\n" {
+		t.Fatalf("Unexpected console log %q", data)
+	}
+
+	_, _ = conn.Write([]byte("login: "))
+	select {
+	case d := <-ch:
+		if d != "login: " {
+			t.Errorf("Unexpected console output %q", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for console output")
+	}
+
+	if err := hub.write("root\r"); err != nil {
+		t.Fatalf("Unable to write to console: %v", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\r')
+	if err != nil || line != "root\r" {
+		t.Errorf("Unexpected console input %q: %v", line, err)
+	}
+
+	close(closeCh)
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for watchConsole to return")
+	}
+	cancel()
+
+	if err := hub.write("root\r"); err == nil {
+		t.Errorf("Write to disconnected console expected to fail")
+	}
+
+	hub.close()
+	if _, ok := <-ch; ok {
+		t.Errorf("Subscription not closed")
+	}
+}
@@ -0,0 +1,133 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+const defaultCPUModel = "host"
+
+var cpuModelRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// checkCPUs verifies the CPU model and topology of in.  The number of CPUs
+// of a VM with a topology must match it.
+func checkCPUs(in *types.VMSpec) error {
+	if in.CPUModel != "" && !cpuModelRegexp.MatchString(in.CPUModel) {
+		return errors.Errorf("Invalid CPU model %q", in.CPUModel)
+	}
+
+	if !in.HasTopology() {
+		return nil
+	}
+	if in.Sockets < 0 || in.Cores < 0 || in.Threads < 0 {
+		return errors.New("Sockets, cores and threads must be positive")
+	}
+	if n := in.TopologyCPUs(); in.CPUs != n {
+		sockets, cores, threads := in.TopologySize()
+		return errors.Errorf("%d CPUs requested but the topology of %d sockets, %d cores and %d threads has %d CPUs",
+			in.CPUs, sockets, cores, threads, n)
+	}
+	return nil
+}
+
+// checkNestedVirt verifies that the host can run the hypervisors of guests
+// that need nested virtualization.
+func checkNestedVirt(spec *workloadSpec) error {
+	if (spec.NeedsNestedVM || spec.VM.NestedVirt) && !hostSupportsNestedKVM() {
+		return fmt.Errorf("nested KVM is not enabled.  Please enable and try again")
+	}
+	return nil
+}
+
+// qemuCPUParam returns the value of qemu's -cpu option for in.
+func qemuCPUParam(in *types.VMSpec) string {
+	param := in.CPUModel
+	if param == "" {
+		param = defaultCPUModel
+	}
+	if in.Profiling {
+		param += ",pmu=on"
+	}
+	if in.NestedVirt {
+		if hostSupportsNestedKVMAMD() {
+			param += ",+svm"
+		} else {
+			param += ",+vmx"
+		}
+	}
+	return param
+}
+
+// qemuSMPParam returns the value of qemu's -smp option for in.  x86_64 VMs
+// without a topology can have CPUs hot-plugged, up to the number of host
+// CPUs.
+func qemuSMPParam(in *types.VMSpec) string {
+	if in.HasTopology() {
+		sockets, cores, threads := in.TopologySize()
+		return fmt.Sprintf("cpus=%d,sockets=%d,cores=%d,threads=%d", in.CPUs, sockets, cores, threads)
+	}
+	if guestArch(in) != types.ArchX86_64 {
+		return fmt.Sprintf("cpus=%d", in.CPUs)
+	}
+
+	maxCPUs := runtime.NumCPU()
+	if maxCPUs < in.CPUs {
+		maxCPUs = in.CPUs
+	}
+	return fmt.Sprintf("cpus=%d,maxcpus=%d", in.CPUs, maxCPUs)
+}
+
+// qemuNUMAArgs returns the qemu arguments that describe the memory of the
+// VM.  Each socket of the VM is given its own NUMA node and an equal share
+// of its memory, allocated by the backend object, as returned by
+// qemuMemoryBackend.
+func qemuNUMAArgs(in *types.VMSpec, backend, options string) []string {
+	sockets, cores, threads := in.TopologySize()
+	if sockets == 1 {
+		if backend == "" {
+			return nil
+		}
+		return []string{
+			"-object", fmt.Sprintf("%s,id=mem,size=%dM%s", backend, in.MemMiB, options),
+			"-numa", "node,memdev=mem",
+		}
+	}
+
+	if backend == "" {
+		backend = "memory-backend-ram"
+	}
+
+	var args []string
+	cpusPerNode := cores * threads
+	for i := 0; i < sockets; i++ {
+		size := in.MemMiB / sockets
+		if i == sockets-1 {
+			size = in.MemMiB - size*(sockets-1)
+		}
+		args = append(args,
+			"-object", fmt.Sprintf("%s,id=mem%d,size=%dM%s", backend, i, size, options),
+			"-numa", fmt.Sprintf("node,nodeid=%d,cpus=%d-%d,memdev=mem%d",
+				i, i*cpusPerNode, (i+1)*cpusPerNode-1, i))
+	}
+	return args
+}
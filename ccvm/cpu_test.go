@@ -0,0 +1,102 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestCheckCPUs(t *testing.T) {
+	valid := []types.VMSpec{
+		{CPUs: 2},
+		{CPUs: 2, CPUModel: "Skylake-Server"},
+		{CPUs: 8, Sockets: 2, Cores: 2, Threads: 2},
+		{CPUs: 4, Cores: 4},
+	}
+	for i := range valid {
+		if err := checkCPUs(&valid[i]); err != nil {
+			t.Errorf("Valid CPUs %+v rejected: %v", valid[i], err)
+		}
+	}
+
+	invalid := []types.VMSpec{
+		{CPUs: 2, CPUModel: "host,-vmx"},
+		{CPUs: 6, Sockets: 2, Cores: 2},
+		{CPUs: 2, Sockets: -2, Cores: -1},
+	}
+	for i := range invalid {
+		if err := checkCPUs(&invalid[i]); err == nil {
+			t.Errorf("Expected error for %+v", invalid[i])
+		}
+	}
+}
+
+func TestCPUTopologyMerge(t *testing.T) {
+	parent := types.VMSpec{CPUs: 2, CPUModel: "max"}
+
+	in := types.VMSpec{Sockets: 2, Cores: 4}
+	in.Merge(&parent)
+	if in.CPUs != 8 || in.CPUModel != "max" {
+		t.Errorf("Unexpected merged specification %+v", in)
+	}
+
+	in = types.VMSpec{}
+	in.Merge(&types.VMSpec{CPUs: 4, Sockets: 2, Cores: 2})
+	if in.CPUs != 4 || in.Sockets != 2 || in.Cores != 2 {
+		t.Errorf("Topology not inherited %+v", in)
+	}
+}
+
+func TestQemuCPUArgs(t *testing.T) {
+	in := types.VMSpec{CPUs: 2, MemMiB: 1024}
+	if param := qemuCPUParam(&in); param != "host" {
+		t.Errorf("Unexpected default CPU model %s", param)
+	}
+	if param := qemuSMPParam(&in); !strings.HasPrefix(param, "cpus=2,maxcpus=") {
+		t.Errorf("Unexpected SMP parameter %s", param)
+	}
+	if args := qemuNUMAArgs(&in, "", ""); len(args) != 0 {
+		t.Errorf("Unexpected NUMA arguments %v", args)
+	}
+
+	in = types.VMSpec{CPUs: 8, MemMiB: 3000, CPUModel: "Skylake-Server", Profiling: true,
+		Sockets: 2, Cores: 2, Threads: 2}
+	if param := qemuCPUParam(&in); param != "Skylake-Server,pmu=on" {
+		t.Errorf("Unexpected CPU parameter %s", param)
+	}
+	if param := qemuSMPParam(&in); param != "cpus=8,sockets=2,cores=2,threads=2" {
+		t.Errorf("Unexpected SMP parameter %s", param)
+	}
+
+	args := strings.Join(qemuNUMAArgs(&in, "memory-backend-memfd", ",share=on"), " ")
+	expected := "-object memory-backend-memfd,id=mem0,size=1500M,share=on " +
+		"-numa node,nodeid=0,cpus=0-3,memdev=mem0 " +
+		"-object memory-backend-memfd,id=mem1,size=1500M,share=on " +
+		"-numa node,nodeid=1,cpus=4-7,memdev=mem1"
+	if args != expected {
+		t.Errorf("Unexpected NUMA arguments %s", args)
+	}
+
+	in.NestedVirt = true
+	param := qemuCPUParam(&in)
+	if !strings.HasSuffix(param, ",+vmx") && !strings.HasSuffix(param, ",+svm") {
+		t.Errorf("Nested virtualization not enabled %s", param)
+	}
+}
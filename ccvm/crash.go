@@ -0,0 +1,319 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// VMs that exit without having been stopped by ccloudvm are restarted
+// according to the restart policy of their instance.
+
+const (
+	crashFile         = "crash.yaml"
+	consoleLog        = "console.log"
+	consoleTailLines  = 20
+	maxRestarts       = 3
+	restartWindow     = 10 * time.Minute
+	consoleTailLength = 16 * 1024
+)
+
+// vmExit describes how the VM of an instance exited.  Neither crashed nor
+// clean is set if the hypervisor cannot tell.
+type vmExit struct {
+	reason  string
+	crashed bool
+	clean   bool
+	console string
+}
+
+// exitOutcome describes how the daemon handled the exit of a VM.  expected
+// is true if the VM was stopped or quit by ccloudvm.
+type exitOutcome struct {
+	expected  bool
+	crashed   bool
+	restarted bool
+}
+
+// vmExitAction informs the service that the VM of the instance name has
+// exited.
+type vmExitAction struct {
+	name string
+	exit *vmExit
+}
+
+type crashRecord struct {
+	Active   bool        `yaml:"active"`
+	Time     time.Time   `yaml:"time"`
+	Reason   string      `yaml:"reason"`
+	Output   string      `yaml:"output"`
+	Restarts []time.Time `yaml:"restarts"`
+}
+
+func loadCrash(instanceDir string) crashRecord {
+	var cr crashRecord
+
+	data, err := ioutil.ReadFile(path.Join(instanceDir, crashFile))
+	if err != nil {
+		return cr
+	}
+	_ = yaml.Unmarshal(data, &cr)
+
+	return cr
+}
+
+func saveCrash(instanceDir string, cr *crashRecord) error {
+	data, err := yaml.Marshal(cr)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal crash record")
+	}
+
+	err = ioutil.WriteFile(path.Join(instanceDir, crashFile), data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to write crash record")
+	}
+
+	return nil
+}
+
+// resolveCrash records that the VM of an instance has been started since
+// it last crashed.
+func resolveCrash(instanceDir string) {
+	cr := loadCrash(instanceDir)
+	if !cr.Active {
+		return
+	}
+	cr.Active = false
+	if err := saveCrash(instanceDir, &cr); err != nil {
+		instanceDirLog(instanceDir).Warningf("%v", err)
+	}
+}
+
+// consoleTail returns the last lines written to the console log.
+func consoleTail(console string) string {
+	f, err := os.Open(console)
+	if err != nil {
+		return ""
+	}
+	defer func() { _ = f.Close() }()
+
+	if fi, err := f.Stat(); err == nil && fi.Size() > consoleTailLength {
+		_, _ = f.Seek(-consoleTailLength, os.SEEK_END)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return ""
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\r\n"), "\n")
+	if len(lines) > consoleTailLines {
+		lines = lines[len(lines)-consoleTailLines:]
+	}
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], "\r")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// allowRestart returns true if the VM may be restarted at now, i.e., if it
+// has been restarted fewer than maxRestarts times in the last
+// restartWindow.  Restarts older than restartWindow are discarded.
+func (cr *crashRecord) allowRestart(now time.Time) bool {
+	recent := cr.Restarts[:0]
+	for _, t := range cr.Restarts {
+		if now.Sub(t) < restartWindow {
+			recent = append(recent, t)
+		}
+	}
+	cr.Restarts = recent
+
+	return len(cr.Restarts) < maxRestarts
+}
+
+func shouldRestart(policy string, crashed bool) bool {
+	switch policy {
+	case types.RestartAlways:
+		return true
+	case types.RestartOnCrash:
+		return crashed
+	}
+	return false
+}
+
+func (c ccvmBackend) monitor(ctx context.Context, name string) (*vmExit, error) {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	hv, err := c.instanceHypervisor(ws)
+	if err != nil {
+		return nil, err
+	}
+
+	return hv.monitor(ctx, ws.instanceDir)
+}
+
+// vmExited is called when the VM of an instance has exited.  The exit is
+// expected if the cached status of the instance does not show it to be
+// running, as the cached status is cleared or marked as not running when
+// an instance is stopped or quit by ccloudvm.
+func (c ccvmBackend) vmExited(ctx context.Context, name string, exit *vmExit) (*exitOutcome, error) {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// The VM may already have been started again if the instance was
+	// started, or restarted, before its exit was processed, in which case
+	// the exit was expected.
+	if hv, err := c.instanceHypervisor(ws); err == nil {
+		if hv.running(ctx, ws.instanceDir) {
+			return &exitOutcome{expected: true}, nil
+		}
+		removeMdevs(ws.instanceDir)
+		releaseVFIO(ws.instanceDir)
+	}
+
+	if !loadStatus(ws.instanceDir).Running {
+		return &exitOutcome{expected: true}, nil
+	}
+	recordStatus(ws.instanceDir, false)
+
+	now := time.Now()
+	outcome := &exitOutcome{crashed: !exit.clean}
+	cr := loadCrash(ws.instanceDir)
+	if outcome.crashed {
+		reason := exit.reason
+		if reason == "" {
+			reason = "VM exited unexpectedly"
+		}
+		cr.Active = true
+		cr.Time = now
+		cr.Reason = reason
+		cr.Output = consoleTail(exit.console)
+		instanceLog(name).Errorf("Instance crashed: %s", reason)
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		_ = saveCrash(ws.instanceDir, &cr)
+		return outcome, errors.Wrap(err, "Unable to load instance state")
+	}
+
+	restart := false
+	if shouldRestart(wkld.spec.VM.RestartPolicy, outcome.crashed) {
+		restart = cr.allowRestart(now)
+		if restart {
+			cr.Restarts = append(cr.Restarts, now)
+		} else {
+			instanceLog(name).Warningf("Instance has been restarted %d times in %v.  Not restarting",
+				maxRestarts, restartWindow)
+		}
+	}
+
+	if outcome.crashed || restart {
+		if err := saveCrash(ws.instanceDir, &cr); err != nil {
+			return outcome, err
+		}
+	}
+
+	if restart {
+		if err := c.start(ctx, name, &types.VMSpec{}, false); err != nil {
+			return outcome, errors.Wrapf(err, "Unable to restart %s", name)
+		}
+		outcome.restarted = true
+	}
+
+	return outcome, nil
+}
+
+// monitorVM waits for the VM of the instance name to exit and informs the
+// service when it does.  The VM is monitored again every time a value is
+// received on kickCh.
+func monitorVM(ctx context.Context, b backend, name string, kickCh <-chan struct{},
+	closeCh <-chan struct{}, actionCh chan<- interface{}) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-closeCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		guestCtx, guestCancel := context.WithCancel(ctx)
+		var guestWg sync.WaitGroup
+		guestWg.Add(3)
+		go func() {
+			if err := b.checkReady(guestCtx, name); err != nil && guestCtx.Err() == nil {
+				instanceLog(name).Warningf("Unable to check readiness: %v", err)
+			}
+			guestWg.Done()
+		}()
+		go func() {
+			if err := b.syncFiles(guestCtx, name); err != nil && guestCtx.Err() == nil {
+				instanceLog(name).Warningf("Unable to sync files: %v", err)
+			}
+			guestWg.Done()
+		}()
+		go func() {
+			if err := b.forwardDocker(guestCtx, name); err != nil && guestCtx.Err() == nil {
+				instanceLog(name).Warningf("Unable to forward Docker socket: %v", err)
+			}
+			guestWg.Done()
+		}()
+
+		exit, err := b.monitor(ctx, name)
+		guestCancel()
+		guestWg.Wait()
+		if err == nil {
+			select {
+			case actionCh <- vmExitAction{name: name, exit: exit}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-kickCh:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// kickMonitor asks the monitor of an instance to start monitoring its VM.
+func kickMonitor(kickCh chan struct{}) {
+	select {
+	case kickCh <- struct{}{}:
+	default:
+	}
+}
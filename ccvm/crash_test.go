@@ -0,0 +1,171 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/intel/govmm/qemu"
+)
+
+func TestConsoleTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	console := path.Join(dir, consoleLog)
+	if tail := consoleTail(console); tail != "" {
+		t.Errorf("Unexpected output for missing console log: %q", tail)
+	}
+
+	var lines []string
+	for i := 0; i < consoleTailLines*2; i++ {
+		lines = append(lines, fmt.Sprintf("line %d\r", i))
+	}
+	err = ioutil.WriteFile(console, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write console log: %v", err)
+	}
+
+	tail := strings.Split(consoleTail(console), "\n")
+	if len(tail) != consoleTailLines {
+		t.Fatalf("Expected %d lines, found %d", consoleTailLines, len(tail))
+	}
+	if tail[0] != fmt.Sprintf("line %d", consoleTailLines) ||
+		tail[len(tail)-1] != fmt.Sprintf("line %d", consoleTailLines*2-1) {
+		t.Errorf("Unexpected console tail %v", tail)
+	}
+}
+
+func TestCrashRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if cr := loadCrash(dir); cr.Active {
+		t.Errorf("Missing crash record should not be active")
+	}
+
+	now := time.Now().Truncate(time.Second)
+	cr := crashRecord{
+		Active:   true,
+		Time:     now,
+		Reason:   "guest-panic",
+		Output:   "Kernel panic",
+		Restarts: []time.Time{now},
+	}
+	if err := saveCrash(dir, &cr); err != nil {
+		t.Fatalf("Unable to save crash record: %v", err)
+	}
+
+	loaded := loadCrash(dir)
+	if !loaded.Active || !loaded.Time.Equal(now) || loaded.Reason != cr.Reason ||
+		loaded.Output != cr.Output || len(loaded.Restarts) != 1 {
+		t.Errorf("Unexpected crash record %+v", loaded)
+	}
+
+	resolveCrash(dir)
+	loaded = loadCrash(dir)
+	if loaded.Active || loaded.Reason != cr.Reason {
+		t.Errorf("Crash not resolved correctly %+v", loaded)
+	}
+}
+
+func TestAllowRestart(t *testing.T) {
+	now := time.Now()
+	cr := crashRecord{
+		Restarts: []time.Time{
+			now.Add(-restartWindow * 2),
+			now.Add(-time.Minute),
+			now.Add(-time.Second),
+		},
+	}
+
+	if !cr.allowRestart(now) {
+		t.Errorf("Restart should be allowed")
+	}
+	if len(cr.Restarts) != 2 {
+		t.Errorf("Old restarts not discarded: %v", cr.Restarts)
+	}
+
+	cr.Restarts = append(cr.Restarts, now)
+	if cr.allowRestart(now) {
+		t.Errorf("Restart should not be allowed after %d restarts", maxRestarts)
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	tests := []struct {
+		policy  string
+		crashed bool
+		restart bool
+	}{
+		{"", true, false},
+		{types.RestartNever, true, false},
+		{types.RestartOnCrash, true, true},
+		{types.RestartOnCrash, false, false},
+		{types.RestartAlways, true, true},
+		{types.RestartAlways, false, true},
+	}
+
+	for _, tt := range tests {
+		if r := shouldRestart(tt.policy, tt.crashed); r != tt.restart {
+			t.Errorf("shouldRestart(%q, %v) = %v, expected %v",
+				tt.policy, tt.crashed, r, tt.restart)
+		}
+	}
+}
+
+func TestQMPExitEvent(t *testing.T) {
+	tests := []struct {
+		reason  string
+		crashed bool
+	}{
+		{"guest-shutdown", false},
+		{"host-qmp-quit", false},
+		{"", false},
+		{"host-signal", true},
+		{"guest-reset", true},
+	}
+
+	for _, tt := range tests {
+		exit := &vmExit{crashed: true}
+		e := qemu.QMPEvent{
+			Name: "SHUTDOWN",
+			Data: map[string]interface{}{},
+		}
+		if tt.reason != "" {
+			e.Data["reason"] = tt.reason
+		}
+		qmpExitEvent(context.Background(), nil, &e, exit)
+		if exit.crashed != tt.crashed || exit.clean == tt.crashed {
+			t.Errorf("Unexpected exit for reason %q: %+v", tt.reason, exit)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Instances whose workload does not use the NoCloud ISO datasource fetch
+// their cloud-init documents from the HTTP server that ccloudvm runs while
+// they are being created.
+const (
+	seedDir  = "seed"
+	seedPath = "/seed/"
+)
+
+// seedFiles lists the documents of the seed directory.
+var seedFiles = []string{"user-data", "meta-data"}
+
+func datasourceType(in *types.VMSpec) string {
+	if in.Datasource == "" {
+		return types.DatasourceNoCloud
+	}
+	return in.Datasource
+}
+
+// checkDatasource verifies that the datasource of the VM of spec is known
+// and that it can be used with its hypervisor.
+func checkDatasource(spec *workloadSpec) error {
+	in := &spec.VM
+	switch datasourceType(in) {
+	case types.DatasourceNoCloud:
+		return nil
+	case types.DatasourceSMBIOS:
+		if in.Hypervisor != "" && in.Hypervisor != hypervisorQemu {
+			return errors.Errorf("The %s datasource is not supported by %s",
+				types.DatasourceSMBIOS, in.Hypervisor)
+		}
+	case types.DatasourceHTTP:
+		switch in.Hypervisor {
+		case "", hypervisorQemu:
+			if in.Kernel == "" {
+				return errors.Errorf("The %s datasource requires a kernel booted directly",
+					types.DatasourceHTTP)
+			}
+		case hypervisorCloudHypervisor:
+			if spec.Kernel == "" {
+				return errors.Errorf("The %s datasource requires a workload with a kernel",
+					types.DatasourceHTTP)
+			}
+		}
+	default:
+		return errors.Errorf("Unknown datasource %s, expected %s, %s or %s", in.Datasource,
+			types.DatasourceNoCloud, types.DatasourceSMBIOS, types.DatasourceHTTP)
+	}
+	return nil
+}
+
+// datasourceSeed returns the NoCloud seed, which tells cloud-init where to
+// fetch the documents of the instance.  It returns an empty string when
+// the documents are not served, i.e., when the instance uses the NoCloud
+// ISO datasource or is not being created.
+func datasourceSeed(ws *workspace, in *types.VMSpec) string {
+	if datasourceType(in) == types.DatasourceNoCloud || ws.HTTPServerPort == 0 {
+		return ""
+	}
+	return fmt.Sprintf("ds=nocloud-net;s=http://%s:%d%s", ws.network.hostIP(),
+		ws.HTTPServerPort, seedPath)
+}
+
+// kernelSeed returns the NoCloud seed to add to the kernel command line of
+// instances that use the HTTP datasource.
+func kernelSeed(ws *workspace, in *types.VMSpec) string {
+	if datasourceType(in) != types.DatasourceHTTP {
+		return ""
+	}
+	return datasourceSeed(ws, in)
+}
+
+// appendSeed appends seed, if any, to the kernel command line cmdline.
+func appendSeed(cmdline, seed string) string {
+	if seed == "" {
+		return cmdline
+	}
+	return cmdline + " " + seed
+}
+
+// qemuDatasourceArgs returns the qemu arguments that give the documents of
+// the instance to cloud-init through the ISO image or the SMBIOS system
+// serial number.  The seeds of the HTTP datasource are passed to
+// directKernelArgs.
+func qemuDatasourceArgs(ws *workspace, in *types.VMSpec) []string {
+	switch datasourceType(in) {
+	case types.DatasourceNoCloud:
+		isoPath := path.Join(ws.instanceDir, "config.iso")
+		return []string{"-drive", fmt.Sprintf("file=%s,if=virtio,media=cdrom", isoPath)}
+	case types.DatasourceSMBIOS:
+		if seed := datasourceSeed(ws, in); seed != "" {
+			return []string{"-smbios", "type=1,serial=" + seed}
+		}
+	}
+	return nil
+}
+
+// writeSeed stores the documents of an instance in its seed directory.
+func writeSeed(instanceDir string, userData, metaData []byte) error {
+	dir := path.Join(instanceDir, seedDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "Unable to create seed directory")
+	}
+	for i, data := range [][]byte{userData, metaData} {
+		err := ioutil.WriteFile(path.Join(dir, seedFiles[i]), data, 0600)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to write %s", seedFiles[i])
+		}
+	}
+	return nil
+}
+
+// serveSeed serves the documents of the seed directory of the instance
+// stored in instanceDir.
+func serveSeed(instanceDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, seedPath)
+		for _, f := range seedFiles {
+			if f != name {
+				continue
+			}
+			data, err := ioutil.ReadFile(path.Join(instanceDir, seedDir, f))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write(data)
+			return
+		}
+		http.NotFound(w, r)
+	}
+}
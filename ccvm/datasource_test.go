@@ -0,0 +1,152 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestCheckDatasource(t *testing.T) {
+	tests := []struct {
+		spec  workloadSpec
+		valid bool
+	}{
+		{workloadSpec{}, true},
+		{workloadSpec{VM: types.VMSpec{Datasource: types.DatasourceSMBIOS}}, true},
+		{workloadSpec{VM: types.VMSpec{Datasource: types.DatasourceSMBIOS,
+			Hypervisor: hypervisorFirecracker}}, false},
+		{workloadSpec{VM: types.VMSpec{Datasource: types.DatasourceHTTP}}, false},
+		{workloadSpec{VM: types.VMSpec{Datasource: types.DatasourceHTTP, Kernel: "/boot/vmlinuz"}}, true},
+		{workloadSpec{VM: types.VMSpec{Datasource: types.DatasourceHTTP,
+			Hypervisor: hypervisorFirecracker}}, true},
+		{workloadSpec{VM: types.VMSpec{Datasource: types.DatasourceHTTP,
+			Hypervisor: hypervisorCloudHypervisor}}, false},
+		{workloadSpec{Kernel: "https://example.com/vmlinux", VM: types.VMSpec{Datasource: types.DatasourceHTTP,
+			Hypervisor: hypervisorCloudHypervisor}}, true},
+		{workloadSpec{VM: types.VMSpec{Datasource: "configdrive"}}, false},
+	}
+
+	for i := range tests {
+		err := checkDatasource(&tests[i].spec)
+		if tests[i].valid && err != nil {
+			t.Errorf("Valid datasource %d rejected: %v", i, err)
+		} else if !tests[i].valid && err == nil {
+			t.Errorf("Invalid datasource %d accepted", i)
+		}
+	}
+}
+
+func TestDatasourceArgs(t *testing.T) {
+	ws := &workspace{instanceDir: "/instance", network: defaultNetwork()}
+
+	args := qemuDatasourceArgs(ws, &types.VMSpec{})
+	if strings.Join(args, " ") != "-drive file=/instance/config.iso,if=virtio,media=cdrom" {
+		t.Errorf("Unexpected NoCloud arguments %v", args)
+	}
+
+	in := &types.VMSpec{Datasource: types.DatasourceSMBIOS}
+	if args = qemuDatasourceArgs(ws, in); len(args) != 0 {
+		t.Errorf("Seed passed to instance that is not being created %v", args)
+	}
+	ws.HTTPServerPort = 1234
+	seed := "ds=nocloud-net;s=http://10.0.2.2:1234/seed/"
+	args = qemuDatasourceArgs(ws, in)
+	if strings.Join(args, " ") != "-smbios type=1,serial="+seed {
+		t.Errorf("Unexpected SMBIOS arguments %v", args)
+	}
+	if kernelSeed(ws, in) != "" {
+		t.Errorf("Seed added to the kernel command line of SMBIOS datasource")
+	}
+
+	in.Datasource = types.DatasourceHTTP
+	if args = qemuDatasourceArgs(ws, in); len(args) != 0 {
+		t.Errorf("Unexpected HTTP arguments %v", args)
+	}
+	if cmdline := appendSeed("console=ttyS0", kernelSeed(ws, in)); cmdline != "console=ttyS0 "+seed {
+		t.Errorf("Unexpected kernel command line %s", cmdline)
+	}
+}
+
+func TestServeSeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seed-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	err = writeSeed(dir, []byte("#cloud-config\n"), []byte("{}\n"))
+	if err != nil {
+		t.Fatalf("Unable to write seed: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(seedPath, serveSeed(dir))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	get := func(name string) (int, string) {
+		resp, err := http.Get(server.URL + seedPath + name)
+		if err != nil {
+			t.Fatalf("Unable to get %s: %v", name, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	if code, data := get("user-data"); code != http.StatusOK || data != "#cloud-config\n" {
+		t.Errorf("Unexpected user-data %d %q", code, data)
+	}
+	if code, data := get("meta-data"); code != http.StatusOK || data != "{}\n" {
+		t.Errorf("Unexpected meta-data %d %q", code, data)
+	}
+	if code, _ := get("vendor-data"); code != http.StatusNotFound {
+		t.Errorf("Unexpected status for vendor-data %d", code)
+	}
+}
+
+func TestDatasourceCloudConfig(t *testing.T) {
+	for _, ds := range []string{"", types.DatasourceSMBIOS} {
+		ws := &workspace{HTTPServerPort: 1234, network: defaultNetwork()}
+		wkld := &workload{userData: "runcmd:\n- command 1\n"}
+		wkld.spec.VM.Datasource = ds
+
+		err := wkld.generateCloudConfig(ws)
+		if err != nil {
+			t.Fatalf("Failed to generate cloud config: %v", err)
+		}
+
+		var cc struct {
+			ManualCacheClean bool `yaml:"manual_cache_clean"`
+		}
+		err = yaml.Unmarshal(wkld.mergedUserData, &cc)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal cloud config: %v", err)
+		}
+		if cc.ManualCacheClean != (ds != "") {
+			t.Errorf("Unexpected manual_cache_clean for datasource %q", ds)
+		}
+	}
+}
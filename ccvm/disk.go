@@ -0,0 +1,207 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The volumes backing the data disks of an instance are stored in the
+// disks directory of the instance.  A volume outlives the disk it backs
+// when the disk is detached without being deleted, so that it can be
+// attached again later.  Volumes are only removed with their instance.
+
+const dataDiskDir = "disks"
+
+func dataDiskPath(instanceDir, name string) string {
+	return path.Join(instanceDir, dataDiskDir, name+".qcow2")
+}
+
+// dataDiskID returns the ID of the device presenting the disk name to the
+// guest.  The drive backing the device is identified by the same ID
+// prefixed with drive-.
+func dataDiskID(name string) string {
+	return "disk-" + name
+}
+
+// createDataDisk creates the volume backing d, if it does not already
+// exist.  Existing volumes are grown to d.SizeGiB, if necessary.  Named
+// volumes are linked into the instance's disks directory instead.
+func createDataDisk(ctx context.Context, ws *workspace, d *types.Disk) error {
+	if d.Volume != "" {
+		return linkVolume(ctx, ws, d)
+	}
+
+	instanceDir := ws.instanceDir
+	volume := dataDiskPath(instanceDir, d.Name)
+	if _, err := os.Stat(volume); err == nil {
+		return growImage(ctx, volume, "qcow2", d.SizeGiB)
+	}
+
+	err := os.MkdirAll(path.Join(instanceDir, dataDiskDir), 0755)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create disks directory")
+	}
+
+	out, err := exec.CommandContext(ctx, "qemu-img", "create", "-f", "qcow2", volume,
+		fmt.Sprintf("%dG", d.SizeGiB)).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to create volume for disk %s: %s", d.Name, string(out))
+	}
+
+	return nil
+}
+
+// checkDataDisks verifies that the volumes backing disks exist.
+func checkDataDisks(instanceDir string, disks []types.Disk) error {
+	for _, d := range disks {
+		if _, err := os.Stat(dataDiskPath(instanceDir, d.Name)); err != nil {
+			return errors.Errorf("Volume of disk %s not found", d.Name)
+		}
+	}
+	return nil
+}
+
+func (c ccvmBackend) disk(ctx context.Context, name string, args *types.DiskArgs, attach bool) error {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load instance state")
+	}
+	in := &wkld.spec.VM
+
+	hv, err := getHypervisor(ws, c.cfg, &wkld.spec)
+	if err != nil {
+		return err
+	}
+
+	d := args.Disk
+	if !attach && args.Delete {
+		for i := range in.Disks {
+			if in.Disks[i].Name == d.Name && in.Disks[i].Volume != "" {
+				return errors.Errorf("Volume %s must be deleted with ccloudvm volume delete",
+					in.Disks[i].Volume)
+			}
+		}
+	}
+
+	index := -1
+	for i := range in.Disks {
+		if in.Disks[i].Name == d.Name {
+			index = i
+			break
+		}
+	}
+
+	if attach && index != -1 {
+		return fmt.Errorf("Disk %s is already attached", d.Name)
+	} else if !attach && index == -1 {
+		return fmt.Errorf("Disk %s not found", d.Name)
+	}
+
+	running := hv.running(ctx, ws.instanceDir)
+	if attach {
+		err = attachDataDisk(ctx, hv, ws, &d, running)
+	} else {
+		d = in.Disks[index]
+		if running {
+			err = hv.detachDisk(ctx, ws.instanceDir, &d)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if attach {
+		in.Disks = append(in.Disks, d)
+	} else {
+		in.Disks = append(in.Disks[:index], in.Disks[index+1:]...)
+	}
+
+	err = wkld.save(ws.instanceDir)
+	if err != nil {
+		if attach && d.Volume != "" {
+			_ = unlinkVolume(ws, &d)
+		}
+		return errors.Wrap(err, "Unable to save instance state")
+	}
+
+	if !attach && d.Volume != "" {
+		return unlinkVolume(ws, &d)
+	}
+
+	if !attach && args.Delete {
+		err = os.Remove(dataDiskPath(ws.instanceDir, d.Name))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "Unable to delete volume of disk %s", d.Name)
+		}
+	}
+
+	return nil
+}
+
+// attachDataDisk creates the volume backing d, or reuses the volume kept
+// when a disk of the same name was detached or the named volume d.Volume,
+// and hot-plugs the disk if the instance is running.  The size of a reused
+// volume is recorded in d if none was requested.
+func attachDataDisk(ctx context.Context, hv hypervisor, ws *workspace, d *types.Disk,
+	running bool) error {
+	if err := types.CheckDiskName(d.Name); err != nil {
+		return err
+	}
+
+	volume := dataDiskPath(ws.instanceDir, d.Name)
+	if d.Volume == "" && d.SizeGiB == 0 {
+		size, err := imageVirtualSize(ctx, volume, "qcow2")
+		if err != nil {
+			if _, statErr := os.Stat(volume); os.IsNotExist(statErr) {
+				return errors.Errorf("The size of disk %s must be specified", d.Name)
+			}
+			return err
+		}
+		d.SizeGiB = int((size + (1 << 30) - 1) >> 30)
+	}
+
+	if err := d.Check(); err != nil {
+		return err
+	}
+
+	if err := createDataDisk(ctx, ws, d); err != nil {
+		return err
+	}
+
+	if !running {
+		return nil
+	}
+
+	err := hv.attachDisk(ctx, ws.instanceDir, d)
+	if err != nil && d.Volume != "" {
+		_ = unlinkVolume(ws, d)
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestDiskCheck(t *testing.T) {
+	valid := types.Disk{Name: "data_1", SizeGiB: 50}
+	if err := valid.Check(); err != nil {
+		t.Errorf("Valid disk rejected: %v", err)
+	}
+
+	for _, d := range []types.Disk{
+		{Name: "", SizeGiB: 50},
+		{Name: "../data", SizeGiB: 50},
+		{Name: "a-name-longer-than-20", SizeGiB: 50},
+		{Name: "data", SizeGiB: 0},
+	} {
+		if err := d.Check(); err == nil {
+			t.Errorf("Invalid disk %v accepted", d)
+		}
+	}
+}
+
+func TestParseDiskSize(t *testing.T) {
+	for _, test := range []struct {
+		size string
+		gib  int
+	}{
+		{"50", 50},
+		{"50G", 50},
+		{"50gib", 50},
+		{"2TB", 2048},
+	} {
+		gib, err := types.ParseDiskSize(test.size)
+		if err != nil || gib != test.gib {
+			t.Errorf("ParseDiskSize(%s) returned %d, %v, expected %d", test.size, gib,
+				err, test.gib)
+		}
+	}
+
+	for _, size := range []string{"", "0G", "-1G", "512M", "big"} {
+		if _, err := types.ParseDiskSize(size); err == nil {
+			t.Errorf("Invalid size %s accepted", size)
+		}
+	}
+}
+
+func TestCheckDataDisks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk-tests")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	disks := []types.Disk{{Name: "data", SizeGiB: 1}}
+	if err := checkDataDisks(dir, disks); err == nil {
+		t.Errorf("Missing volume not detected")
+	}
+
+	if err := os.MkdirAll(path.Join(dir, dataDiskDir), 0755); err != nil {
+		t.Fatalf("Unable to create disks directory: %v", err)
+	}
+	if err := ioutil.WriteFile(dataDiskPath(dir, "data"), nil, 0600); err != nil {
+		t.Fatalf("Unable to create volume: %v", err)
+	}
+	if err := checkDataDisks(dir, disks); err != nil {
+		t.Errorf("Volume not found: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultDistro is the distribution of the default base image and of the
+// workloads that do not specify one.
+const defaultDistro = "ubuntu"
+
+// distro describes the differences between the distributions supported by
+// the workloads that matter when configuring their guests.
+type distro struct {
+	packageManager string
+	adminGroup     string
+	defaultUser    string
+}
+
+var distros = map[string]distro{
+	"ubuntu":        {packageManager: "apt", adminGroup: "sudo", defaultUser: "ubuntu"},
+	"debian":        {packageManager: "apt", adminGroup: "sudo", defaultUser: "debian"},
+	"fedora":        {packageManager: "dnf", adminGroup: "wheel", defaultUser: "fedora"},
+	"centos-stream": {packageManager: "dnf", adminGroup: "wheel", defaultUser: "cloud-user"},
+	"opensuse":      {packageManager: "zypper", adminGroup: "wheel", defaultUser: "opensuse"},
+	distroWindows:   {adminGroup: "Administrators", defaultUser: "Administrator"},
+}
+
+func lookupDistro(name string) (distro, error) {
+	if name == "" {
+		name = defaultDistro
+	}
+	d, ok := distros[name]
+	if !ok {
+		names := make([]string, 0, len(distros))
+		for n := range distros {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return d, errors.Errorf("Unknown distro %s, expected one of %s",
+			name, strings.Join(names, ", "))
+	}
+	return d, nil
+}
+
+// refreshCmd returns a command that updates the package manager's index of
+// the available packages.
+func (d distro) refreshCmd() string {
+	switch d.packageManager {
+	case "apt":
+		return "apt-get update"
+	case "zypper":
+		return "zypper --non-interactive refresh"
+	default:
+		return d.packageManager + " makecache"
+	}
+}
+
+// installCmd returns a command that installs pkgs without prompting.
+func (d distro) installCmd(pkgs []string) string {
+	switch d.packageManager {
+	case "apt":
+		return "DEBIAN_FRONTEND=noninteractive apt-get install -y " + strings.Join(pkgs, " ")
+	case "zypper":
+		return "zypper --non-interactive install " + strings.Join(pkgs, " ")
+	default:
+		return d.packageManager + " install -y " + strings.Join(pkgs, " ")
+	}
+}
+
+// retriesFile returns a write_files entry that configures the package
+// manager to retry failed downloads.  The dnf and zypper settings are
+// appended to the [main] sections of their configuration files.
+func (d distro) retriesFile(retry retryPolicy) map[interface{}]interface{} {
+	switch d.packageManager {
+	case "apt":
+		return map[interface{}]interface{}{
+			"path":    aptRetriesPath,
+			"content": aptRetriesConf(retry),
+		}
+	case "zypper":
+		return map[interface{}]interface{}{
+			"path":    "/etc/zypp/zypp.conf",
+			"content": fmt.Sprintf("download.max_silent_tries = %d\n", retry.attempts-1),
+			"append":  true,
+		}
+	default:
+		return map[interface{}]interface{}{
+			"path":    "/etc/dnf/dnf.conf",
+			"content": fmt.Sprintf("retries=%d\n", retry.attempts-1),
+			"append":  true,
+		}
+	}
+}
+
+func (ws *workspace) distro() distro {
+	d, err := lookupDistro(ws.Distro)
+	if err != nil {
+		d = distros[defaultDistro]
+	}
+	return d
+}
+
+// PackageManager returns the name of the guest's package manager, apt,
+// dnf or zypper.
+func (ws *workspace) PackageManager() string {
+	return ws.distro().packageManager
+}
+
+// AdminGroup returns the group whose members can administer the guest.
+func (ws *workspace) AdminGroup() string {
+	return ws.distro().adminGroup
+}
+
+// DefaultUser returns the name of the account created by the cloud images
+// of the guest's distribution.
+func (ws *workspace) DefaultUser() string {
+	return ws.distro().defaultUser
+}
+
+func refreshPackagesFN(ws *workspace) string {
+	cmd := ws.distro().refreshCmd()
+	if vars := proxyVarsFN(ws); vars != "" {
+		cmd = vars + " " + cmd
+	}
+	return cmd
+}
+
+func installPackagesFN(ws *workspace, pkgs ...string) (string, error) {
+	if len(pkgs) == 0 {
+		return "", errors.New("installPackages requires at least one package")
+	}
+	cmd := ws.distro().installCmd(pkgs)
+	if vars := proxyVarsFN(ws); vars != "" {
+		cmd = vars + " " + cmd
+	}
+	return cmd, nil
+}
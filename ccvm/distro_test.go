@@ -0,0 +1,141 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestLookupDistro(t *testing.T) {
+	d, err := lookupDistro("")
+	if err != nil || d != distros[defaultDistro] {
+		t.Errorf("Expected the default distro, got %+v, %v", d, err)
+	}
+
+	if _, err := lookupDistro("gentoo"); err == nil {
+		t.Errorf("Unknown distro accepted")
+	}
+}
+
+func TestInstallPackages(t *testing.T) {
+	tests := []struct {
+		distro   string
+		proxy    string
+		expected string
+	}{
+		{"", "", "DEBIAN_FRONTEND=noninteractive apt-get install -y git curl"},
+		{"fedora", "", "dnf install -y git curl"},
+		{"centos-stream", "http://proxy:911",
+			"http_proxy=http://proxy:911 HTTP_PROXY=http://proxy:911 dnf install -y git curl"},
+		{"opensuse", "", "zypper --non-interactive install git curl"},
+	}
+
+	for _, tt := range tests {
+		ws := &workspace{Distro: tt.distro, HTTPProxy: tt.proxy}
+		cmd, err := installPackagesFN(ws, "git", "curl")
+		if err != nil {
+			t.Errorf("Failed to install packages on %s: %v", tt.distro, err)
+		} else if cmd != tt.expected {
+			t.Errorf("Expected %q for %s, got %q", tt.expected, tt.distro, cmd)
+		}
+	}
+
+	if _, err := installPackagesFN(&workspace{}); err == nil {
+		t.Errorf("installPackages without packages accepted")
+	}
+}
+
+func TestDistroWorkloads(t *testing.T) {
+	workloads := map[string]string{
+		"fedora40":        "/etc/dnf/dnf.conf",
+		"debian12":        aptRetriesPath,
+		"centos-stream9":  "/etc/dnf/dnf.conf",
+		"opensuse-leap15": "/etc/zypp/zypp.conf",
+	}
+
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+
+	defer func() {
+		if err := os.RemoveAll(ccvmDir); err != nil {
+			t.Errorf("Failed to remove %s : %v", ccvmDir, err)
+		}
+	}()
+
+	for name, retriesPath := range workloads {
+		data, err := ioutil.ReadFile(filepath.Join("..", "workloads", name+".yaml"))
+		if err != nil {
+			t.Fatalf("Unable to read workload %s: %v", name, err)
+		}
+
+		ws, err := createMockWorkSpaceWithWorkload(string(data), name, ccvmDir)
+		if err != nil {
+			t.Fatalf("Failed to create mock workload: %v", err)
+		}
+		ws.User = "user"
+		ws.HTTPProxy = "http://proxy:911"
+		ws.HTTPServerPort = 1234
+		ws.retry = retryPolicy{attempts: 3}
+
+		wkld, err := createWorkload(context.Background(), ws, name, nil)
+		if err != nil {
+			t.Errorf("Unable to create workload %s: %v", name, err)
+			continue
+		}
+		if err := wkld.generateCloudConfig(ws); err != nil {
+			t.Errorf("Unable to generate cloud config for %s: %v", name, err)
+			continue
+		}
+
+		var cc struct {
+			WriteFiles []struct {
+				Path string `yaml:"path"`
+			} `yaml:"write_files"`
+			Runcmd []string `yaml:"runcmd"`
+		}
+		if err := yaml.Unmarshal(wkld.mergedUserData, &cc); err != nil {
+			t.Errorf("Invalid cloud config for %s: %v", name, err)
+			continue
+		}
+
+		found := false
+		for _, f := range cc.WriteFiles {
+			found = found || f.Path == retriesPath
+		}
+		if !found {
+			t.Errorf("%s not written by %s", retriesPath, name)
+		}
+
+		install := ws.distro().installCmd([]string{"git", "curl", "tar"})
+		found = false
+		for _, c := range cc.Runcmd {
+			found = found || strings.HasSuffix(c, install)
+		}
+		if !found {
+			t.Errorf("%q not run by %s", install, name)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The Docker socket of the guests of the instances whose VM spec sets
+// docker is forwarded by ssh to dockerSocket, in the instance directory,
+// while their VM runs.
+
+const (
+	dockerSocket        = "docker.sock"
+	guestDockerSocket   = "/var/run/docker.sock"
+	dockerRetryInterval = 5 * time.Second
+)
+
+// dockerSocketPath returns the path of the host socket to which the Docker
+// socket of the guest described by in is forwarded, or an empty string if
+// it is not forwarded.
+func dockerSocketPath(instanceDir string, in *types.VMSpec) string {
+	if !in.Docker {
+		return ""
+	}
+	return path.Join(instanceDir, dockerSocket)
+}
+
+// dockerForwardArgs returns the arguments of the ssh command that forwards
+// the Docker socket of the instance described by details to socket.
+func dockerForwardArgs(details *types.InstanceDetails, socket string) []string {
+	return append(sshOptions(details), "-N",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "ServerAliveInterval=30",
+		"-o", "StreamLocalBindUnlink=yes",
+		"-o", "StreamLocalBindMask=0177",
+		"-L", socket+":"+guestDockerSocket,
+		details.SSH.Host.String())
+}
+
+// forwardDocker forwards the Docker socket of the guest of the instance
+// name to the host until ctx is cancelled.
+func (c ccvmBackend) forwardDocker(ctx context.Context, name string) error {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load instance state")
+	}
+	socket := dockerSocketPath(ws.instanceDir, &wkld.spec.VM)
+	if socket == "" {
+		return nil
+	}
+	defer func() { _ = os.Remove(socket) }()
+	instanceLog(name).Infof("Forwarding Docker socket to %s", socket)
+
+	var lastErr string
+	for {
+		err := c.runDockerForward(ctx, name, socket)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil && err.Error() != lastErr {
+			instanceLog(name).Warningf("Unable to forward Docker socket: %v", err)
+			lastErr = err.Error()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(dockerRetryInterval):
+		}
+	}
+}
+
+func (c ccvmBackend) runDockerForward(ctx context.Context, name, socket string) error {
+	details, err := c.sshDetails(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !sshReachable(ctx, details.SSH.Host, details.SSH.Port) {
+		return errors.New("Unable to reach the SSH server of the instance")
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ssh", dockerForwardArgs(details, socket)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "Unable to start ssh")
+	}
+	return sshError(cmd.Wait(), &stderr)
+}
@@ -0,0 +1,57 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestDockerForwardArgs(t *testing.T) {
+	details := &types.InstanceDetails{
+		VMSpec: types.VMSpec{HostIP: net.IPv4(127, 0, 0, 2), Docker: true},
+		SSH: types.SSHDetails{
+			KeyPath: "/home/user/.ccloudvm/instances/test/id_rsa",
+			Host:    net.IPv4(127, 0, 0, 2),
+			Port:    10022,
+		},
+	}
+
+	socket := dockerSocketPath("/home/user/.ccloudvm/instances/test", &details.VMSpec)
+	if socket != "/home/user/.ccloudvm/instances/test/docker.sock" {
+		t.Fatalf("Unexpected Docker socket %s", socket)
+	}
+
+	args := dockerForwardArgs(details, socket)
+	if host := args[len(args)-1]; host != "127.0.0.2" {
+		t.Errorf("Unexpected host %s", host)
+	}
+	cmdLine := strings.Join(args, " ")
+	for _, want := range []string{"-N", "-p 10022", "StreamLocalBindUnlink=yes",
+		"-L /home/user/.ccloudvm/instances/test/docker.sock:/var/run/docker.sock"} {
+		if !strings.Contains(cmdLine, want) {
+			t.Errorf("%s not found in %s", want, cmdLine)
+		}
+	}
+
+	if socket := dockerSocketPath("/tmp", &types.VMSpec{}); socket != "" {
+		t.Errorf("Unexpected Docker socket %s for a VM without docker", socket)
+	}
+}
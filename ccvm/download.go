@@ -27,6 +27,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -55,19 +56,12 @@ type updateInfo struct {
 	name string
 }
 
-type progressReader struct {
-	downloaded int64
-	totalMB    int
-	reader     io.Reader
-	progressCh chan updateInfo
-	name       string
-}
-
 type downloadRequest struct {
 	progress  chan downloadUpdate
 	URL       string
 	ctx       context.Context
 	transport *http.Transport
+	offline   bool
 }
 
 type downloadedFile struct {
@@ -83,25 +77,6 @@ type downloader struct {
 	cacheDir string
 }
 
-func (pr *progressReader) Read(p []byte) (int, error) {
-	read, err := pr.reader.Read(p)
-	if err == nil {
-		oldMB := pr.downloaded / 10000000
-		pr.downloaded += int64(read)
-		newMB := pr.downloaded / 10000000
-		if newMB > oldMB {
-			pr.progressCh <- updateInfo{
-				p: progress{
-					downloadedMB: int(newMB * 10),
-					totalMB:      pr.totalMB,
-				},
-				name: pr.name,
-			}
-		}
-	}
-	return read, err
-}
-
 func getHTTPTransport(HTTPProxy, HTTPSProxy, NoProxy string) *http.Transport {
 	proxyCfg := httpproxy.Config{
 		HTTPProxy:  HTTPProxy,
@@ -135,59 +110,170 @@ func makeFileName(URL string) (string, error) {
 	return filepath.Base(u.Path), nil
 }
 
-func getFile(ctx context.Context, name, URL string, transport *http.Transport,
-	dest io.WriteCloser, progressCh chan updateInfo) (size int, err error) {
-	defer func() {
-		err1 := dest.Close()
-		if err == nil && err1 != nil {
-			err = err1
-		}
-	}()
-	req, err := http.NewRequest("GET", URL, nil)
+// probeFile asks the server for the size and version of the file at URL
+// and whether it supports range requests.  A size of -1 is returned if the
+// server does not say.
+func probeFile(ctx context.Context, cli *http.Client, URL string) *downloadState {
+	st := &downloadState{URL: URL, Size: -1}
+
+	req, err := http.NewRequest("HEAD", URL, nil)
 	if err != nil {
-		return
+		return st
 	}
-	req = req.WithContext(ctx)
-	cli := &http.Client{
-		Transport: transport,
+	resp, err := cli.Do(req.WithContext(ctx))
+	if err != nil {
+		return st
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return st
 	}
-	resp, err := cli.Do(req)
+
+	st.Size = resp.ContentLength
+	st.Ranges = st.Size > 0 && resp.Header.Get("Accept-Ranges") == "bytes"
+	st.ETag = resp.Header.Get("ETag")
+	st.LastModified = resp.Header.Get("Last-Modified")
+	return st
+}
+
+// fetchChunk downloads the remainder of the chunk i of st and writes it to
+// f.
+func fetchChunk(ctx context.Context, cli *http.Client, st *downloadState, i int,
+	f *os.File, pc *progressCounter) error {
+	c := st.chunk(i)
+
+	req, err := http.NewRequest("GET", st.URL, nil)
 	if err != nil {
-		return
+		return err
+	}
+	expected := http.StatusOK
+	if st.Ranges {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", c.Start+c.Done, c.End-1))
+		if validator := st.validator(); validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+		expected = http.StatusPartialContent
 	}
 
+	resp, err := cli.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("Failed to download %s : %s", URL, resp.Status)
-		return
+	if st.Ranges && resp.StatusCode == http.StatusOK {
+		return errFileChanged
+	}
+	if resp.StatusCode != expected {
+		return &httpStatusError{URL: st.URL, StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 
-	pr := &progressReader{
-		reader:     resp.Body,
-		progressCh: progressCh,
-		name:       name,
+	var body io.Reader = resp.Body
+	if st.Ranges {
+		body = io.LimitReader(resp.Body, c.End-c.Start-c.Done)
 	}
-	if resp.ContentLength == -1 {
-		pr.totalMB = -1
+	offset := c.Start + c.Done
+	buf := make([]byte, 1<<20)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := f.WriteAt(buf[:n], offset); werr != nil {
+				return werr
+			}
+			offset += int64(n)
+			st.advance(i, n)
+			pc.add(n)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if st.Ranges && offset != c.End {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// getFile downloads the file at URL to partPath, resuming any previous
+// download of the same version of the file recorded in the download state
+// of partPath.  Large files are downloaded in chunks fetched in parallel.
+// It returns the size of the file in MB.
+func getFile(ctx context.Context, name, URL string, transport *http.Transport,
+	partPath string, progressCh chan updateInfo) (int, error) {
+	cli := &http.Client{
+		Transport: transport,
+	}
+
+	st := probeFile(ctx, cli, URL)
+	statePath := partPath + stateSuffix
+	prev := loadDownloadState(statePath)
+	if _, err := os.Stat(partPath); err != nil {
+		prev = nil
+	}
+	if prev != nil && prev.resumes(st) {
+		st.Chunks = prev.Chunks
+		logInfof("Resuming download of %s", URL)
 	} else {
-		pr.totalMB = int(resp.ContentLength / 1000000)
+		st.split()
 	}
 
+	flags := os.O_RDWR | os.O_CREATE
+	if !st.Ranges {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to create download file")
+	}
+	defer func() { _ = f.Close() }()
+
+	pc := &progressCounter{
+		downloaded: st.downloaded(),
+		totalMB:    -1,
+		progressCh: progressCh,
+		name:       name,
+		save: func() {
+			st.save(statePath, f)
+		},
+	}
+	if st.Size >= 0 {
+		pc.totalMB = int(st.Size / 1000000)
+	}
 	progressCh <- updateInfo{
 		p: progress{
-			totalMB: pr.totalMB,
+			downloadedMB: int(pc.downloaded / 1000000),
+			totalMB:      pc.totalMB,
 		},
-		name: pr.name,
+		name: name,
 	}
 
-	buf := make([]byte, 1<<20)
-	_, err = io.CopyBuffer(dest, pr, buf)
-	if err == nil {
-		size = pr.totalMB
+	errCh := make(chan error, len(st.Chunks))
+	for i := range st.Chunks {
+		go func(i int) {
+			errCh <- fetchChunk(ctx, cli, st, i, f, pc)
+		}(i)
+	}
+	for range st.Chunks {
+		if chunkErr := <-errCh; chunkErr != nil && err == nil {
+			err = chunkErr
+		}
 	}
 
-	return
+	if err != nil {
+		if _, ok := errors.Cause(err).(*httpStatusError); ok && !isTransient(err) ||
+			err == errFileChanged || !st.Ranges {
+			_ = os.Remove(statePath)
+		} else {
+			st.save(statePath, f)
+		}
+		return 0, err
+	}
+
+	_ = os.Remove(statePath)
+	return int(st.downloaded() / 1000000), nil
 }
 
 func renameFile(ctx context.Context, tmpImgPath, imgPath string) error {
@@ -207,22 +293,27 @@ func renameFile(ctx context.Context, tmpImgPath, imgPath string) error {
 	return nil
 }
 
+// prepareDownload downloads the file at URL to imgPath, locking it so that
+// other processes sharing the cache wait for the download.
 func prepareDownload(ctx context.Context, imgPath, name, URL string,
 	transport *http.Transport, progressCh chan updateInfo) (int, error) {
-	tmpImgPath := imgPath + ".part"
-
-	if _, err := os.Stat(tmpImgPath); err == nil {
-		_ = os.Remove(tmpImgPath)
+	lock, err := lockCacheFile(ctx, imgPath)
+	if err != nil {
+		return 0, err
 	}
+	defer func() { _ = lock.Close() }()
 
-	f, err := os.Create(tmpImgPath)
-	if err != nil {
-		return 0, errors.Wrap(err, "Unable to create download file")
+	if fi, err := os.Stat(imgPath); err == nil {
+		logInfof("%s was downloaded by another process", URL)
+		return int(fi.Size() / 1000000), nil
 	}
 
-	size, err := getFile(ctx, name, URL, transport, f, progressCh)
+	tmpImgPath := imgPath + ".part"
+	size, err := getFile(ctx, name, URL, transport, tmpImgPath, progressCh)
 	if err != nil {
-		_ = os.Remove(tmpImgPath)
+		if _, serr := os.Stat(tmpImgPath + stateSuffix); serr != nil {
+			_ = os.Remove(tmpImgPath)
+		}
 		return 0, errors.Wrapf(err, "Unable download file %s", URL)
 	}
 
@@ -237,7 +328,7 @@ func prepareDownload(ctx context.Context, imgPath, name, URL string,
 
 func initiateDownload(ctx context.Context, progressCh chan updateInfo, imgPath, name, URL string,
 	transport *http.Transport, wg *sync.WaitGroup) {
-	fmt.Printf("First download of %s\n", URL)
+	logInfof("First download of %s", URL)
 	size, err := prepareDownload(ctx, imgPath, name, URL, transport, progressCh)
 	progressCh <- updateInfo{
 		err: err,
@@ -265,9 +356,23 @@ func (d *downloader) setup(ccvmDir string) error {
 		}
 
 		fullPath := filepath.Join(d.cacheDir, info.Name())
-		if filepath.Ext(info.Name()) == ".part" {
-			fmt.Printf("Discarding partially downloaded file %s", fullPath)
+		switch filepath.Ext(info.Name()) {
+		case ".part":
+			if _, err := os.Stat(fullPath + stateSuffix); err == nil &&
+				time.Since(info.ModTime()) < partialFileLifetime {
+				logDebugf("Keeping partially downloaded file %s", fullPath)
+				return nil
+			}
+			logWarningf("Discarding partially downloaded file %s", fullPath)
 			_ = os.Remove(fullPath)
+			_ = os.Remove(fullPath + stateSuffix)
+			return nil
+		case ".part" + stateSuffix:
+			if _, err := os.Stat(strings.TrimSuffix(fullPath, stateSuffix)); err != nil {
+				_ = os.Remove(fullPath)
+			}
+			return nil
+		case ".lock":
 			return nil
 		}
 		size := int(info.Size() / (1000 * 1000))
@@ -279,7 +384,7 @@ func (d *downloader) setup(ccvmDir string) error {
 			},
 			path: filepath.Join(d.cacheDir, info.Name()),
 		}
-		fmt.Printf("Found cached file %s %dMB\n", fullPath, size)
+		logDebugf("Found cached file %s %dMB", fullPath, size)
 
 		return nil
 	})
@@ -303,7 +408,7 @@ func processUpdate(df *downloadedFile, u updateInfo) {
 	df.p = u.p
 
 	if u.p.complete {
-		fmt.Printf("Download of %s finished\n", u.name)
+		logInfof("Download of %s finished", u.name)
 	}
 	listeners := make([]downloadRequest, 0, len(df.listeners))
 	for _, l := range df.listeners {
@@ -336,7 +441,7 @@ func processUpdate(df *downloadedFile, u updateInfo) {
 	df.listeners = listeners
 	if len(df.listeners) == 0 || df.p.complete {
 		if !df.p.complete {
-			fmt.Printf("Download of %s cancelled due to lack of interested clients\n",
+			logInfof("Download of %s cancelled due to lack of interested clients",
 				u.name)
 		}
 		df.cancel()
@@ -378,13 +483,13 @@ DONE:
 			df, ok := d.files[name]
 			imgPath := filepath.Join(d.cacheDir, name)
 			if ok {
-				if !df.p.complete {
+				if !df.p.complete && !r.offline {
 					df.listeners = append(df.listeners, r)
 					continue
 				}
 
 				if _, err := os.Stat(imgPath); err == nil {
-					fmt.Printf("Download of %s finished\n", name)
+					logInfof("Download of %s finished", name)
 					r.progress <- downloadUpdate{
 						p:                 df.p,
 						err:               nil,
@@ -395,6 +500,13 @@ DONE:
 					continue
 				}
 			}
+			if r.offline {
+				r.progress <- downloadUpdate{
+					err: errNotCached(r.URL),
+				}
+				close(r.progress)
+				continue
+			}
 			ctx, cancel := context.WithCancel(context.Background())
 			d.files[name] = &downloadedFile{
 				listeners: []downloadRequest{
@@ -409,14 +521,14 @@ DONE:
 		case u := <-progressCh:
 			df, ok := d.files[u.name]
 			if !ok {
-				fmt.Printf("Warning: %s is not being downloaded\n", u.name)
+				logWarningf("%s is not being downloaded", u.name)
 				continue
 			}
 
 			processUpdate(df, u)
 
 			if u.err != nil {
-				fmt.Printf("Download of %s failed: %v\n", u.name, u.err)
+				logErrorf("Download of %s failed: %v", u.name, u.err)
 				delete(d.files, u.name)
 			}
 
@@ -431,15 +543,16 @@ DONE:
 
 func downloadFile(ctx context.Context, downloadCh chan<- downloadRequest, transport *http.Transport, URL string,
 	progress progressCB) (string, error) {
-	fmt.Printf("Downloading %s\n", URL)
+	logInfof("Downloading %s", URL)
 	progressCh := make(chan downloadUpdate)
 	downloadCh <- downloadRequest{
 		progress:  progressCh,
 		URL:       URL,
 		ctx:       ctx,
 		transport: transport,
+		offline:   offlineFromContext(ctx),
 	}
-	fmt.Printf("request sent %s\n", URL)
+	logDebugf("request sent %s", URL)
 
 	d := <-progressCh
 	if d.err != nil {
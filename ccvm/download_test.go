@@ -17,12 +17,16 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -210,10 +214,11 @@ func testDownloadImages(ctx context.Context, t *testing.T, downloadCh chan<- dow
 			BIOS:         "http://" + addr + "/download/bios",
 		},
 	}
+	ws := &workspace{ccvmDir: ccvmDir, retry: retryPolicy{attempts: 1}}
 
 	resultCh := make(chan interface{})
 	go func() {
-		img, bios, err := downloadImages(ctx, wkld, http.DefaultTransport.(*http.Transport),
+		img, bios, err := downloadImages(ctx, wkld, ws, http.DefaultTransport.(*http.Transport),
 			resultCh, downloadCh)
 		if err != nil {
 			t.Errorf("Failed to download images: %v", err)
@@ -230,7 +235,7 @@ func testDownloadImages(ctx context.Context, t *testing.T, downloadCh chan<- dow
 	wkld.spec.BIOS = "ftp://" + addr + "/download/bios"
 	resultCh = make(chan interface{})
 	go func() {
-		_, _, err := downloadImages(ctx, wkld, http.DefaultTransport.(*http.Transport),
+		_, _, err := downloadImages(ctx, wkld, ws, http.DefaultTransport.(*http.Transport),
 			resultCh, downloadCh)
 		if err == nil {
 			t.Errorf("Expected downloadImages with bad BIOS URL to fail")
@@ -242,6 +247,39 @@ func testDownloadImages(ctx context.Context, t *testing.T, downloadCh chan<- dow
 	}
 }
 
+func testDownloadOffline(ctx context.Context, t *testing.T, downloadCh chan<- downloadRequest, addr, ccvmDir string) {
+	ctx = withOffline(ctx, true)
+	_, err := downloadFile(ctx, downloadCh, nil, "http://"+addr+"/download/one",
+		func(bool, progress) {})
+	if err != nil {
+		t.Errorf("Failed to get cached file offline : %v", err)
+	}
+
+	_, err = downloadFile(ctx, downloadCh, nil, "http://"+addr+"/download/offline",
+		func(bool, progress) {})
+	if err == nil {
+		t.Errorf("Expected offline download of uncached file to fail")
+	}
+	if _, err := os.Stat(filepath.Join(ccvmDir, "cache", "offline")); err == nil {
+		t.Errorf("Uncached file downloaded offline")
+	}
+
+	wkld := &workload{
+		spec: workloadSpec{
+			BaseImageURL: "http://" + addr + "/download/image",
+			BIOS:         "http://" + addr + "/download/bios",
+		},
+	}
+	ws := &workspace{ccvmDir: ccvmDir, network: defaultNetwork()}
+	if err := checkOfflineDownloads(ctx, wkld, ws, downloadCh); err != nil {
+		t.Errorf("Cached images reported as missing : %v", err)
+	}
+	downloadFN(ws, "http://"+addr+"/download/offline", "/tmp/offline")
+	if err := checkOfflineDownloads(ctx, wkld, ws, downloadCh); err == nil {
+		t.Errorf("Expected uncached user data download to be reported")
+	}
+}
+
 func TestDownload(t *testing.T) {
 	var wg sync.WaitGroup
 
@@ -298,7 +336,172 @@ func TestDownload(t *testing.T) {
 	t.Run("downloadImages", func(t *testing.T) {
 		testDownloadImages(ctx, t, downloadCh, addr, ccvmDir)
 	})
+	t.Run("offline", func(t *testing.T) {
+		testDownloadOffline(ctx, t, downloadCh, addr, ccvmDir)
+	})
 	cancel()
 	_ = server.Shutdown(context.Background())
 	wg.Wait()
 }
+
+type rangeServer struct {
+	sync.Mutex
+	content []byte
+	ranges  []string
+}
+
+func (rs *rangeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rs.Lock()
+	if rng := r.Header.Get("Range"); rng != "" {
+		rs.ranges = append(rs.ranges, rng)
+	}
+	rs.Unlock()
+	w.Header().Set("ETag", `"v1"`)
+	http.ServeContent(w, r, "image", time.Time{}, bytes.NewReader(rs.content))
+}
+
+func newRangeServer() *rangeServer {
+	content := make([]byte, 3*1000*1000+17)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	return &rangeServer{content: content}
+}
+
+func saveTestState(t *testing.T, st *downloadState, partPath string) {
+	f, err := os.Open(partPath)
+	if err != nil {
+		t.Fatalf("Unable to open partial file: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	st.save(partPath+stateSuffix, f)
+}
+
+func getTestFile(t *testing.T, URL, partPath string) error {
+	progressCh := make(chan updateInfo)
+	done := make(chan struct{})
+	go func() {
+		for range progressCh {
+		}
+		close(done)
+	}()
+	_, err := getFile(context.Background(), "image", URL,
+		http.DefaultTransport.(*http.Transport), partPath, progressCh)
+	close(progressCh)
+	<-done
+	return err
+}
+
+func TestParallelDownload(t *testing.T) {
+	oldSize := parallelDownloadSize
+	parallelDownloadSize = 1 << 20
+	defer func() { parallelDownloadSize = oldSize }()
+
+	rs := newRangeServer()
+	server := httptest.NewServer(rs)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "ccloudvm-download-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory")
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	partPath := filepath.Join(dir, "image.part")
+	if err := getTestFile(t, server.URL, partPath); err != nil {
+		t.Fatalf("Failed to download file: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(partPath)
+	if err != nil {
+		t.Fatalf("Unable to read downloaded file: %v", err)
+	}
+	if !bytes.Equal(data, rs.content) {
+		t.Errorf("Downloaded file is corrupt")
+	}
+	if len(rs.ranges) != downloadChunks {
+		t.Errorf("Expected %d chunks, got %v", downloadChunks, rs.ranges)
+	}
+	if _, err := os.Stat(partPath + stateSuffix); err == nil {
+		t.Errorf("Download state not removed")
+	}
+}
+
+func TestResumeDownload(t *testing.T) {
+	rs := newRangeServer()
+	server := httptest.NewServer(rs)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "ccloudvm-download-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory")
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	done := int64(1000 * 1000)
+	partPath := filepath.Join(dir, "image.part")
+	if err := ioutil.WriteFile(partPath, rs.content[:done], 0644); err != nil {
+		t.Fatalf("Unable to write partial file: %v", err)
+	}
+	st := &downloadState{
+		URL:    server.URL,
+		Size:   int64(len(rs.content)),
+		ETag:   `"v1"`,
+		Ranges: true,
+		Chunks: []downloadChunk{{Start: 0, End: int64(len(rs.content)), Done: done}},
+	}
+	saveTestState(t, st, partPath)
+
+	if err := getTestFile(t, server.URL, partPath); err != nil {
+		t.Fatalf("Failed to resume download: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(partPath)
+	if err != nil {
+		t.Fatalf("Unable to read downloaded file: %v", err)
+	}
+	if !bytes.Equal(data, rs.content) {
+		t.Errorf("Resumed file is corrupt")
+	}
+	if len(rs.ranges) != 1 || !strings.HasPrefix(rs.ranges[0], fmt.Sprintf("bytes=%d-", done)) {
+		t.Errorf("Download not resumed: %v", rs.ranges)
+	}
+
+	// A partial file of another version of the file is not resumed.
+	rs.ranges = nil
+	st.ETag = `"v0"`
+	saveTestState(t, st, partPath)
+	if err := getTestFile(t, server.URL, partPath); err != nil {
+		t.Fatalf("Failed to download file: %v", err)
+	}
+	if len(rs.ranges) != 1 || !strings.HasPrefix(rs.ranges[0], "bytes=0-") {
+		t.Errorf("Download of changed file resumed: %v", rs.ranges)
+	}
+}
+
+func TestLockCacheFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-download-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory")
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	imgPath := filepath.Join(dir, "image")
+	lock, err := lockCacheFile(context.Background(), imgPath)
+	if err != nil {
+		t.Fatalf("Unable to lock %s: %v", imgPath, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := lockCacheFile(ctx, imgPath); err == nil {
+		t.Errorf("Locked file locked twice")
+	}
+
+	_ = lock.Close()
+	lock, err = lockCacheFile(context.Background(), imgPath)
+	if err != nil {
+		t.Fatalf("Unable to lock released file: %v", err)
+	}
+	_ = lock.Close()
+}
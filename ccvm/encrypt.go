@@ -0,0 +1,211 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The root disks of encrypted instances are qcow2 overlays encrypted with
+// LUKS by qemu.  The passphrase is never written to disk.  It is kept in
+// the keyring of the user running the daemon until the host is rebooted.
+
+const (
+	rootfsSecretID     = "rootfs-secret"
+	minPassphraseBytes = 8
+)
+
+// The keyring of the user running the daemon.  These are variables so
+// that tests can replace the keyring.
+var (
+	storeDiskKey  = addKeyringKey
+	readDiskKey   = readKeyringKey
+	forgetDiskKey = removeKeyringKey
+)
+
+type passphraseKey struct{}
+
+// withPassphrase attaches the passphrase supplied by the client starting an
+// instance to ctx.
+func withPassphrase(ctx context.Context, passphrase string) context.Context {
+	if passphrase == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, passphraseKey{}, passphrase)
+}
+
+func passphraseFromContext(ctx context.Context) string {
+	p, _ := ctx.Value(passphraseKey{}).(string)
+	return p
+}
+
+// redactCreateArgs returns a copy of args, without the passphrase and the
+// user data, which may contain secrets, that can be logged.
+func redactCreateArgs(args *types.CreateArgs) types.CreateArgs {
+	redacted := *args
+	if redacted.Passphrase != "" {
+		redacted.Passphrase = "<redacted>"
+	}
+	redacted.UserData = nil
+	return redacted
+}
+
+// diskKeyName identifies the key of the disk of an instance in the keyring.
+func diskKeyName(instanceDir string) string {
+	return "ccloudvm:" + instanceDir
+}
+
+// checkEncryption verifies that the root disk of the VM described by in can
+// be encrypted with passphrase.
+func checkEncryption(in *types.VMSpec, passphrase string) error {
+	if in.Hypervisor != "" && in.Hypervisor != hypervisorQemu {
+		return errors.Errorf("Encrypted disks are not supported by %s", in.Hypervisor)
+	}
+	if passphrase == "" {
+		return errors.New(types.ErrPassphraseRequired)
+	}
+	if len(passphrase) < minPassphraseBytes {
+		return errors.Errorf("The passphrase must be at least %d characters long",
+			minPassphraseBytes)
+	}
+	return nil
+}
+
+// unlockDisk retrieves the key of the encrypted disk of the instance booted
+// with in, from ctx or from the keyring, and records it in ws.
+func unlockDisk(ctx context.Context, ws *workspace, in *types.VMSpec) error {
+	if !in.Encrypt {
+		return nil
+	}
+
+	if p := passphraseFromContext(ctx); p != "" {
+		ws.diskKey = []byte(p)
+		return nil
+	}
+
+	key, err := readDiskKey(diskKeyName(ws.instanceDir))
+	if err != nil {
+		logDebugf("Key of %s not found: %v", ws.instanceDir, err)
+		return errors.New(types.ErrPassphraseRequired)
+	}
+	ws.diskKey = key
+	return nil
+}
+
+// rememberDiskKey stores the key of the disk of an instance that has been
+// booted successfully in the keyring.
+func rememberDiskKey(ws *workspace) {
+	if len(ws.diskKey) == 0 {
+		return
+	}
+	if err := storeDiskKey(diskKeyName(ws.instanceDir), ws.diskKey); err != nil {
+		logWarningf("Unable to store the key of %s in the keyring: %v", ws.instanceDir, err)
+	}
+}
+
+// secretPipe returns a pipe from which key can be read by qemu, through
+// the file /dev/fd/3 when the pipe is the first extra file of the command.
+func secretPipe(key []byte) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create pipe")
+	}
+	_, err = w.Write(key)
+	_ = w.Close()
+	if err != nil {
+		_ = r.Close()
+		return nil, errors.Wrap(err, "Unable to write key")
+	}
+	return r, nil
+}
+
+func secretObjectParam() string {
+	return fmt.Sprintf("secret,id=%s,file=/dev/fd/3,format=raw", rootfsSecretID)
+}
+
+// runWithKey runs qemu-img with args, passing it key through a pipe.
+func runWithKey(ctx context.Context, key []byte, args ...string) error {
+	r, err := secretPipe(key)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+
+	cmd := exec.CommandContext(ctx, "qemu-img", args...)
+	cmd.ExtraFiles = []*os.File{r}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "qemu-img %s failed: %s", args[0], string(out))
+	}
+	return nil
+}
+
+// createEncryptedRootfs creates the root disk of an instance as a qcow2
+// overlay of backingImage encrypted with LUKS.
+func createEncryptedRootfs(ctx context.Context, backingImage, instanceDir string, disk int,
+	key []byte) error {
+	vmImage := path.Join(instanceDir, "image.qcow2")
+	if _, err := os.Stat(vmImage); err == nil {
+		_ = os.Remove(vmImage)
+	}
+
+	chain, err := imageChain(ctx, backingImage)
+	if err != nil {
+		return err
+	}
+	if len(chain) == 0 {
+		return errors.Errorf("Unable to determine the format of %s", backingImage)
+	}
+
+	return runWithKey(ctx, key, "create", "--object", secretObjectParam(), "-f", "qcow2",
+		"-o", fmt.Sprintf("encrypt.format=luks,encrypt.key-secret=%s,backing_file=%s,backing_fmt=%s",
+			rootfsSecretID, backingImage, chain[0].Format),
+		vmImage, fmt.Sprintf("%dG", disk))
+}
+
+// growEncryptedRootfs grows the encrypted root disk of a stopped instance
+// to disk GiB.
+func growEncryptedRootfs(ctx context.Context, instanceDir string, disk int, key []byte) error {
+	vmImage := path.Join(instanceDir, "image.qcow2")
+	size, err := imageVirtualSize(ctx, vmImage, "qcow2")
+	if err != nil {
+		return err
+	}
+	if size >= int64(disk)<<30 {
+		return nil
+	}
+
+	return runWithKey(ctx, key, "resize", "--object", secretObjectParam(), "--image-opts",
+		rootfsDriveOptions(vmImage, true), fmt.Sprintf("%dG", disk))
+}
+
+// rootfsDriveOptions returns the options with which qemu opens the root
+// disk vmImage.
+func rootfsDriveOptions(vmImage string, encrypted bool) string {
+	options := fmt.Sprintf("driver=qcow2,file.filename=%s", vmImage)
+	if encrypted {
+		options += ",encrypt.key-secret=" + rootfsSecretID
+	}
+	return options
+}
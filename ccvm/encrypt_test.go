@@ -0,0 +1,140 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+type fakeKeyring map[string][]byte
+
+func (k fakeKeyring) install() func() {
+	oldStore, oldRead, oldForget := storeDiskKey, readDiskKey, forgetDiskKey
+	storeDiskKey = func(name string, key []byte) error {
+		k[name] = key
+		return nil
+	}
+	readDiskKey = func(name string) ([]byte, error) {
+		key, ok := k[name]
+		if !ok {
+			return nil, errors.New("Key not found")
+		}
+		return key, nil
+	}
+	forgetDiskKey = func(name string) {
+		delete(k, name)
+	}
+	return func() {
+		storeDiskKey, readDiskKey, forgetDiskKey = oldStore, oldRead, oldForget
+	}
+}
+
+func TestCheckEncryption(t *testing.T) {
+	tests := []struct {
+		hypervisor string
+		passphrase string
+		ok         bool
+	}{
+		{"", "correct horse", true},
+		{hypervisorQemu, "correct horse", true},
+		{hypervisorFirecracker, "correct horse", false},
+		{"", "short", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		err := checkEncryption(&types.VMSpec{Hypervisor: test.hypervisor}, test.passphrase)
+		if (err == nil) != test.ok {
+			t.Errorf("Unexpected result for %+v: %v", test, err)
+		}
+	}
+
+	err := checkEncryption(&types.VMSpec{}, "")
+	if err == nil || err.Error() != types.ErrPassphraseRequired {
+		t.Errorf("Missing passphrase not reported: %v", err)
+	}
+}
+
+func TestUnlockDisk(t *testing.T) {
+	keyring := fakeKeyring{}
+	defer keyring.install()()
+
+	ws := &workspace{instanceDir: "/tmp/instances/test"}
+	in := &types.VMSpec{Encrypt: true}
+
+	if err := unlockDisk(context.Background(), ws, in); err == nil ||
+		err.Error() != types.ErrPassphraseRequired {
+		t.Fatalf("Missing key not reported: %v", err)
+	}
+
+	ctx := withPassphrase(context.Background(), "correct horse")
+	if err := unlockDisk(ctx, ws, in); err != nil {
+		t.Fatalf("Unable to unlock disk with passphrase: %v", err)
+	}
+	rememberDiskKey(ws)
+
+	ws = &workspace{instanceDir: "/tmp/instances/test"}
+	if err := unlockDisk(context.Background(), ws, in); err != nil {
+		t.Fatalf("Unable to unlock disk with stored key: %v", err)
+	}
+	if string(ws.diskKey) != "correct horse" {
+		t.Errorf("Unexpected key %s", ws.diskKey)
+	}
+
+	forgetDiskKey(diskKeyName(ws.instanceDir))
+	if len(keyring) != 0 {
+		t.Errorf("Key not forgotten")
+	}
+
+	ws = &workspace{instanceDir: "/tmp/instances/test"}
+	if err := unlockDisk(context.Background(), ws, &types.VMSpec{}); err != nil || ws.diskKey != nil {
+		t.Errorf("Unencrypted disk unlocked: %v", err)
+	}
+}
+
+func TestSecretPipe(t *testing.T) {
+	r, err := secretPipe([]byte("correct horse"))
+	if err != nil {
+		t.Fatalf("Unable to create pipe: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil || string(data) != "correct horse" {
+		t.Errorf("Unexpected secret %s: %v", data, err)
+	}
+}
+
+func TestEncryptedDriveParams(t *testing.T) {
+	if param := rootfsDriveParam("/i/image.qcow2", false); strings.Contains(param, "encrypt") {
+		t.Errorf("Unencrypted drive has a secret: %s", param)
+	}
+	if param := rootfsDriveParam("/i/image.qcow2", true); !strings.HasSuffix(param,
+		",encrypt.key-secret="+rootfsSecretID) {
+		t.Errorf("Encrypted drive has no secret: %s", param)
+	}
+
+	args := redactCreateArgs(&types.CreateArgs{Encrypt: true, Passphrase: "correct horse"})
+	if args.Passphrase == "correct horse" {
+		t.Errorf("Passphrase not redacted")
+	}
+}
@@ -0,0 +1,94 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+// Clients subscribe to instance events by issuing a WatchEvents command,
+// whose resultCh is the subscriber's event queue.  Events are dropped for
+// subscribers that do not keep up.
+
+type eventBroker struct {
+	sync.Mutex
+	subscribers map[chan interface{}]map[string]struct{}
+}
+
+// subscribe delivers the events of the instances listed in names, or of
+// all instances if names is empty, to ch.
+func (b *eventBroker) subscribe(ch chan interface{}, names []string) {
+	var filter map[string]struct{}
+	if len(names) > 0 {
+		filter = make(map[string]struct{})
+		for _, name := range names {
+			filter[name] = struct{}{}
+		}
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan interface{}]map[string]struct{})
+	}
+	b.subscribers[ch] = filter
+}
+
+// unsubscribe stops the delivery of events to ch.  No events are sent to
+// ch once unsubscribe has returned.
+func (b *eventBroker) unsubscribe(ch chan interface{}) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.subscribers, ch)
+}
+
+func (b *eventBroker) publish(name, eventType string) {
+	event := types.InstanceEvent{
+		Name: name,
+		Type: eventType,
+		Time: time.Now(),
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	for ch, filter := range b.subscribers {
+		if filter != nil {
+			if _, ok := filter[name]; !ok {
+				continue
+			}
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// watchEvents subscribes the transaction to instance events.  The
+// subscription lasts until the transaction is cancelled.
+func (s *ccvmService) watchEvents(ctx context.Context, args *types.WatchEventsArgs, resultCh chan interface{}) {
+	s.events.subscribe(resultCh, args.Names)
+	go func() {
+		<-ctx.Done()
+		s.events.unsubscribe(resultCh)
+		close(resultCh)
+	}()
+}
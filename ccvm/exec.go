@@ -0,0 +1,135 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// sshOptions returns the options of the ssh commands that connect to the
+// instance described by details.  Host keys are not checked as they change
+// whenever an instance is recreated.
+func sshOptions(details *types.InstanceDetails) []string {
+	args := []string{
+		"-q", "-F", "/dev/null",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "IdentitiesOnly=yes",
+		"-o", "BatchMode=yes",
+		"-i", details.SSH.KeyPath,
+	}
+
+	if details.SSH.CertPath != "" {
+		args = append(args, "-o", "CertificateFile="+details.SSH.CertPath)
+	}
+
+	return append(args, "-p", strconv.Itoa(details.SSH.Port))
+}
+
+// sshArgs returns the arguments of an ssh command that executes command in
+// the instance described by details.
+func sshArgs(details *types.InstanceDetails, command string) []string {
+	return append(sshOptions(details), details.SSH.Host.String(), command)
+}
+
+// execCommand executes command in the instance called name over SSH,
+// copying its output to stdout and stderr, and returns its exit code.
+func (c ccvmBackend) execCommand(ctx context.Context, name, command string, stdout, stderr io.Writer) (int, error) {
+	details, err := c.sshDetails(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	if !sshReachable(ctx, details.SSH.Host, details.SSH.Port) {
+		return 0, errors.Errorf("Unable to reach the SSH server of %s.  Is the instance running?", name)
+	}
+
+	cmd := exec.CommandContext(ctx, "ssh", sshArgs(details, command)...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			return status.ExitStatus(), nil
+		}
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to execute command")
+	}
+
+	return 0, nil
+}
+
+// execWriter sends the output written to it to the client of an Exec
+// request.
+type execWriter struct {
+	send   func(interface{}) bool
+	stderr bool
+}
+
+func (w execWriter) Write(p []byte) (int, error) {
+	out := types.ExecOutput{Stdout: string(p)}
+	if w.stderr {
+		out = types.ExecOutput{Stderr: string(p)}
+	}
+
+	if !w.send(out) {
+		return 0, errors.New("Operation cancelled")
+	}
+
+	return len(p), nil
+}
+
+// exec executes a command in an instance.  The command is executed outside
+// of the instance's loop so that the instance can be stopped or deleted
+// while long running commands execute.
+func (s *ccvmService) exec(ctx context.Context, args *types.ExecArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+
+	go func() {
+		defer close(resultCh)
+
+		send := func(v interface{}) bool {
+			select {
+			case resultCh <- v:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		code, err := s.b.execCommand(ctx, instanceName, args.Command,
+			execWriter{send: send}, execWriter{send: send, stderr: true})
+		if err != nil {
+			send(err)
+			return
+		}
+		send(types.ExecOutput{Finished: true, ExitCode: code})
+	}()
+}
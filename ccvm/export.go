@@ -0,0 +1,426 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// An exported instance is a tar archive containing a compressed copy of the
+// instance's disk and a workload that boots from that copy.
+
+const (
+	exportedWorkloadFile = "workload.yaml"
+	exportedImageFile    = "image.qcow2"
+
+	// maxExportedWorkloadSize limits the size of the workload read from an
+	// archive.
+	maxExportedWorkloadSize = 1 << 20
+)
+
+const exportedUserData = `#cloud-config
+runcmd:
+ - {{beginTask . "Booting VM"}}
+ - {{endTaskOk . }}
+
+ - {{beginTask . (printf "Adding %s to /etc/hosts" .Hostname) }}
+ - echo "127.0.0.1 {{.Hostname}}" >> /etc/hosts
+ - {{endTaskCheck .}}
+
+{{range .Mounts}}
+ - mkdir -p {{.Path}}
+ - echo "{{.FstabEntry}}" >> /etc/fstab
+ - {{beginTask $ (printf "Mounting %s" .Path) }}
+ - mount {{.Path}}
+ - {{endTaskCheck $}}
+{{end}}
+
+users:
+  - name: {{.User}}
+    groups: {{.AdminGroup}}
+    lock-passwd: true
+    shell: /bin/bash
+    sudo: ALL=(ALL) NOPASSWD:ALL
+    ssh-authorized-keys:
+    - {{.PublicKey}}
+`
+
+// exportedSpec returns the specification of the workload of an exported
+// instance, whose instance specification is spec.  Only the resources of
+// the instance and the settings that do not depend on the host are kept.
+func exportedSpec(instanceName string, spec *workloadSpec, checksum string) workloadSpec {
+	return workloadSpec{
+		BaseImageURL:    exportedImageFile,
+		BaseImageName:   fmt.Sprintf("%s exported from %s", spec.BaseImageName, instanceName),
+		BaseImageSHA256: checksum,
+		Distro:          spec.Distro,
+		NeedsNestedVM:   spec.NeedsNestedVM,
+		BIOS:            spec.BIOS,
+		BIOSSHA256:      spec.BIOSSHA256,
+		Kernel:          spec.Kernel,
+		KernelSHA256:    spec.KernelSHA256,
+		VirtioDrivers:   spec.VirtioDrivers,
+		VirtioSHA256:    spec.VirtioSHA256,
+		SSHCA:           spec.SSHCA,
+		SSHAgent:        spec.SSHAgent,
+		Qemu:            spec.Qemu,
+		VM: types.VMSpec{
+			MemMiB:       spec.VM.MemMiB,
+			DiskGiB:      spec.VM.DiskGiB,
+			CPUs:         spec.VM.CPUs,
+			PortMappings: spec.VM.PortMappings,
+			ReversePorts: spec.VM.ReversePorts,
+			Hypervisor:   spec.VM.Hypervisor,
+		},
+	}
+}
+
+func marshalWorkload(spec *workloadSpec, userData string) ([]byte, error) {
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to marshal instance specification")
+	}
+
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("---\n")
+	_, _ = buf.Write(data)
+	_, _ = buf.WriteString("...\n---\n")
+	_, _ = buf.WriteString(userData)
+	_, _ = buf.WriteString("...\n")
+	return buf.Bytes(), nil
+}
+
+func addTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	})
+	if err == nil {
+		_, err = io.Copy(tw, r)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Unable to add %s to archive", name)
+	}
+	return nil
+}
+
+func writeExportArchive(archive, image string, workload []byte) (err error) {
+	img, err := os.Open(image)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to open %s", image)
+	}
+	defer func() { _ = img.Close() }()
+	fi, err := img.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to stat %s", image)
+	}
+
+	tmp := archive + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to create %s", archive)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	tw := tar.NewWriter(f)
+	err = addTarFile(tw, exportedWorkloadFile, int64(len(workload)), bytes.NewReader(workload))
+	if err == nil {
+		err = addTarFile(tw, exportedImageFile, fi.Size(), img)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, archive)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Unable to write %s", archive)
+	}
+
+	return nil
+}
+
+func (c ccvmBackend) exportInstance(ctx context.Context, name, archive string) error {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if err := ws.checkUserPath(archive); err != nil {
+		return err
+	}
+
+	hv, err := c.instanceHypervisor(ws)
+	if err != nil {
+		return err
+	}
+
+	if hv.running(ctx, ws.instanceDir) {
+		return errors.New("The instance must be stopped before it can be exported")
+	}
+
+	vmImage := path.Join(ws.instanceDir, "image.qcow2")
+	if _, err := os.Stat(vmImage); err != nil {
+		return errors.New("Only instances with qcow2 disks can be exported")
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load instance state")
+	}
+	if wkld.spec.VM.Encrypt {
+		return errors.New("Instances with encrypted disks cannot be exported")
+	}
+
+	dir, err := ioutil.TempDir("", "ccloudvm-export-")
+	if err != nil {
+		return errors.Wrap(err, "Unable to create temporary directory")
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	image := path.Join(dir, exportedImageFile)
+	out, err := exec.CommandContext(ctx, "qemu-img", "convert", "-c", "-O", "qcow2",
+		vmImage, image).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to convert image of %s: %s", name,
+			strings.TrimSpace(string(out)))
+	}
+
+	checksum, err := fileSHA256(image)
+	if err != nil {
+		return err
+	}
+
+	spec := exportedSpec(name, &wkld.spec, checksum)
+	data, err := marshalWorkload(&spec, exportedUserData)
+	if err != nil {
+		return err
+	}
+
+	if err := writeExportArchive(archive, image, data); err != nil {
+		return err
+	}
+	return ws.giveToUser(archive)
+}
+
+// readExportArchive extracts the image of the archive to a temporary file in
+// imagesDir and returns its path along with the workload of the archive.
+func readExportArchive(archive, imagesDir string) (image string, workload []byte, err error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "Unable to open %s", archive)
+	}
+	defer func() { _ = f.Close() }()
+
+	defer func() {
+		if err != nil && image != "" {
+			_ = os.Remove(image)
+		}
+	}()
+
+	tr := tar.NewReader(f)
+	for {
+		var hdr *tar.Header
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return image, nil, errors.Wrapf(err, "Unable to read %s", archive)
+		}
+
+		switch path.Clean(hdr.Name) {
+		case exportedWorkloadFile:
+			workload, err = ioutil.ReadAll(io.LimitReader(tr, maxExportedWorkloadSize))
+		case exportedImageFile:
+			if image != "" {
+				break
+			}
+			var img *os.File
+			img, err = ioutil.TempFile(imagesDir, exportedImageFile+".part")
+			if err != nil {
+				break
+			}
+			image = img.Name()
+			_, err = io.Copy(img, tr)
+			if cerr := img.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			return image, nil, errors.Wrapf(err, "Unable to extract %s from %s", hdr.Name, archive)
+		}
+	}
+
+	if workload == nil || image == "" {
+		err = errors.Errorf("%s is not an exported instance", archive)
+		return image, nil, err
+	}
+
+	return image, workload, nil
+}
+
+func (c ccvmBackend) importWorkload(ctx context.Context, archive, name string) (string, error) {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return "", err
+	}
+
+	if err := ws.checkUserPath(archive); err != nil {
+		return "", err
+	}
+
+	return importArchive(ws.ccvmDir, archive, name)
+}
+
+// importArchive imports the instance exported to archive as the workload
+// name, which defaults to the name of the archive without its extension.
+// The name of the workload is returned.
+func importArchive(ccvmDir, archive, name string) (string, error) {
+	if name == "" {
+		name = strings.TrimSuffix(path.Base(archive), path.Ext(archive))
+	}
+
+	err := importBundle(ccvmDir, name, archive, func(imagesDir string) (string, []byte, error) {
+		return readExportArchive(archive, imagesDir)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// importBundle creates the workload name from an exported instance read from
+// source.  extract returns the path of the image of the exported instance,
+// which it stores in imagesDir, and its workload.
+func importBundle(ccvmDir, name, source string, extract func(imagesDir string) (string, []byte, error)) error {
+	if !hostnameRegexp.MatchString(name) {
+		return errors.Errorf("Invalid workload name %s", name)
+	}
+
+	workloadsDir := path.Join(ccvmDir, "workloads")
+	workloadPath := path.Join(workloadsDir, name+".yaml")
+	if _, err := os.Stat(workloadPath); err == nil {
+		return errors.Errorf("Workload %s already exists", name)
+	}
+
+	imagesDir := path.Join(ccvmDir, localImagesDir)
+	for _, dir := range []string{workloadsDir, imagesDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errors.Wrapf(err, "Unable to create directory %s", dir)
+		}
+	}
+
+	image, data, err := extract(imagesDir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(image) }()
+
+	docs := splitYaml(data)
+	if len(docs) != 2 {
+		return errors.Errorf("Invalid workload in %s; two documents required", source)
+	}
+	var spec workloadSpec
+	if err := yaml.Unmarshal(docs[0], &spec); err != nil {
+		return errors.Wrapf(err, "Invalid workload in %s", source)
+	}
+	if spec.BaseImageURL != exportedImageFile {
+		return errors.Errorf("Workload in %s does not use the image of the exported instance", source)
+	}
+
+	checksum, err := fileSHA256(image)
+	if err != nil {
+		return err
+	}
+	if spec.BaseImageSHA256 != "" && !strings.EqualFold(spec.BaseImageSHA256, checksum) {
+		return errors.Errorf("SHA-256 checksum of the image in %s is %s, expected %s",
+			source, checksum, spec.BaseImageSHA256)
+	}
+
+	// Imported images are named after their checksums so that importing
+	// the same instance twice does not duplicate its image.
+
+	dest := path.Join(imagesDir, fmt.Sprintf("imported-%s.qcow2", checksum[:16]))
+	if err := os.Rename(image, dest); err != nil {
+		return errors.Wrapf(err, "Unable to store image of %s", source)
+	}
+
+	spec.BaseImageURL = "file://" + dest
+	spec.BaseImageSHA256 = checksum
+	data, err = marshalWorkload(&spec, string(docs[1]))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(workloadPath, data)
+}
+
+func (s *ccvmService) exportInstance(ctx context.Context, args *types.ExportArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:  instanceCmdOther,
+		resultCh: resultCh,
+		ctx:      ctx,
+		fn: func() error {
+			resultCh <- s.b.exportInstance(ctx, instanceName, args.Path)
+			return nil
+		},
+	}
+}
+
+func (s *ccvmService) importWorkload(ctx context.Context, args *types.ImportArgs, resultCh chan interface{}) {
+	go func() {
+		name, err := s.b.importWorkload(ctx, args.Path, args.Name)
+		if err != nil {
+			resultCh <- err
+		} else {
+			resultCh <- name
+		}
+		close(resultCh)
+	}()
+}
@@ -0,0 +1,127 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestExportImport(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+
+	defer func() {
+		if err := os.RemoveAll(ccvmDir); err != nil {
+			t.Errorf("Failed to remove %s : %v", ccvmDir, err)
+		}
+	}()
+
+	image := path.Join(ccvmDir, "exported.qcow2")
+	if err := ioutil.WriteFile(image, []byte("qcow2 image"), 0644); err != nil {
+		t.Fatalf("Unable to write image: %v", err)
+	}
+	checksum, err := fileSHA256(image)
+	if err != nil {
+		t.Fatalf("Unable to compute checksum: %v", err)
+	}
+
+	original := workloadSpec{
+		BaseImageName: "Ubuntu 16.04",
+		Distro:        "ubuntu",
+		Group:         "lab",
+		VM: types.VMSpec{
+			MemMiB:  2048,
+			CPUs:    2,
+			DiskGiB: 20,
+			Mounts:  []types.Mount{{Tag: "hostgo", Path: "/home/user/go"}},
+		},
+	}
+	spec := exportedSpec("dev", &original, checksum)
+	if len(spec.VM.Mounts) != 0 || spec.Group != "" || spec.VM.MemMiB != 2048 {
+		t.Errorf("Unexpected exported specification %+v", spec)
+	}
+	data, err := marshalWorkload(&spec, exportedUserData)
+	if err != nil {
+		t.Fatalf("Unable to marshal workload: %v", err)
+	}
+
+	archive := path.Join(ccvmDir, "dev-env.tar")
+	if err := writeExportArchive(archive, image, data); err != nil {
+		t.Fatalf("Unable to write archive: %v", err)
+	}
+
+	name, err := importArchive(ccvmDir, archive, "")
+	if err != nil || name != "dev-env" {
+		t.Fatalf("Unable to import archive: %s %v", name, err)
+	}
+	if _, err := importArchive(ccvmDir, archive, ""); err == nil {
+		t.Errorf("Workload imported twice")
+	}
+
+	ws := &workspace{ccvmDir: ccvmDir, network: defaultNetwork(), User: "user", Hostname: "dev"}
+	wkld, err := createWorkload(context.Background(), ws, name, nil)
+	if err != nil {
+		t.Fatalf("Unable to create imported workload: %v", err)
+	}
+	localPath, isLocal, err := localImagePath(wkld.spec.BaseImageURL)
+	if err != nil || !isLocal || path.Dir(localPath) != path.Join(ccvmDir, localImagesDir) {
+		t.Fatalf("Unexpected base image %s", wkld.spec.BaseImageURL)
+	}
+	if err := verifyChecksum(localPath, wkld.spec.BaseImageSHA256); err != nil {
+		t.Errorf("Invalid imported image: %v", err)
+	}
+	if copied, err := copyLocalImage(ccvmDir, localPath); err != nil || copied != localPath {
+		t.Errorf("Imported image copied to %s: %v", copied, err)
+	}
+	if err := wkld.generateCloudConfig(ws); err != nil {
+		t.Fatalf("Unable to generate cloud config: %v", err)
+	}
+	if !strings.Contains(string(wkld.mergedUserData), "name: user") {
+		t.Errorf("User not created by imported workload:\n%s", wkld.mergedUserData)
+	}
+
+	err = ioutil.WriteFile(archive, []byte("not an archive"), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write archive: %v", err)
+	}
+	if _, err := importArchive(ccvmDir, archive, "broken"); err == nil {
+		t.Errorf("Invalid archive imported")
+	}
+}
+
+func TestRegistryWorkloadName(t *testing.T) {
+	tests := map[string]string{
+		"ghcr.io/org/devvm:1.2":                         "devvm",
+		"localhost:5000/devvm":                          "devvm",
+		"registry.example.com/team/dev-env@sha256:0123": "dev-env",
+	}
+
+	for ref, expected := range tests {
+		if name := registryWorkloadName(ref); name != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, ref, name)
+		}
+	}
+}
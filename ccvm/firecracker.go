@@ -0,0 +1,275 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+const firecrackerBootArgs = "console=ttyS0 reboot=k panic=1 pci=off root=/dev/vda1 rw"
+
+// firecrackerHypervisor boots instances using Firecracker.  The guest is
+// connected to a tap device, created by the user, whose name is derived
+// from the instance name.
+type firecrackerHypervisor struct{}
+
+type firecrackerBootSource struct {
+	KernelImagePath string `json:"kernel_image_path"`
+	BootArgs        string `json:"boot_args"`
+}
+
+type firecrackerDrive struct {
+	DriveID      string `json:"drive_id"`
+	PathOnHost   string `json:"path_on_host"`
+	IsRootDevice bool   `json:"is_root_device"`
+	IsReadOnly   bool   `json:"is_read_only"`
+}
+
+type firecrackerMachineConfig struct {
+	VCPUCount  int `json:"vcpu_count"`
+	MemSizeMiB int `json:"mem_size_mib"`
+}
+
+type firecrackerNetworkInterface struct {
+	IfaceID     string `json:"iface_id"`
+	HostDevName string `json:"host_dev_name"`
+	GuestMAC    string `json:"guest_mac,omitempty"`
+}
+
+type firecrackerConfig struct {
+	BootSource        firecrackerBootSource         `json:"boot-source"`
+	Drives            []firecrackerDrive            `json:"drives"`
+	MachineConfig     firecrackerMachineConfig      `json:"machine-config"`
+	NetworkInterfaces []firecrackerNetworkInterface `json:"network-interfaces"`
+}
+
+func firecrackerTapName(name string) string {
+	return fmt.Sprintf("fc%08x", crc32.ChecksumIEEE([]byte(name)))
+}
+
+// staticIPArg returns the kernel parameter that configures the address of
+// the guest of an instance connected to the network n by a tap device.
+// There is no DHCP server on tap devices.
+func staticIPArg(n *vmNetwork) string {
+	return fmt.Sprintf("ip=%s::%s:%s::eth0:off", n.guestIP(), n.hostIP(),
+		net.IP(n.subnet.Mask).String())
+}
+
+// newFirecrackerConfig returns the firecracker configuration of the VM in,
+// connected to the network n.  seed is appended to the kernel command line
+// of the guest.
+func newFirecrackerConfig(instanceDir, name string, in *types.VMSpec, n *vmNetwork, seed string) *firecrackerConfig {
+	bootArgs := firecrackerBootArgs + " " + staticIPArg(n)
+	cfg := &firecrackerConfig{
+		BootSource: firecrackerBootSource{
+			KernelImagePath: path.Join(instanceDir, "kernel"),
+			BootArgs:        appendSeed(bootArgs, seed),
+		},
+		Drives: []firecrackerDrive{
+			{
+				DriveID:    "rootfs",
+				PathOnHost: path.Join(instanceDir, "image.raw"),
+			},
+		},
+		MachineConfig: firecrackerMachineConfig{
+			VCPUCount:  in.CPUs,
+			MemSizeMiB: in.MemMiB,
+		},
+		NetworkInterfaces: []firecrackerNetworkInterface{
+			{
+				IfaceID:     "eth0",
+				HostDevName: firecrackerTapName(name),
+				GuestMAC:    in.MACAddress,
+			},
+		},
+	}
+
+	if datasourceType(in) == types.DatasourceNoCloud {
+		cfg.Drives = append(cfg.Drives, firecrackerDrive{
+			DriveID:    "config",
+			PathOnHost: path.Join(instanceDir, "config.iso"),
+			IsReadOnly: true,
+		})
+	}
+	for i, d := range in.Drives {
+		cfg.Drives = append(cfg.Drives, firecrackerDrive{
+			DriveID:    fmt.Sprintf("drive%d", i),
+			PathOnHost: d.Path,
+		})
+	}
+
+	return cfg
+}
+
+// configureTap assigns the host address of the network n to the tap device
+// tap, unless it already has it.  The device must already exist.
+func configureTap(ctx context.Context, tap string, n *vmNetwork) error {
+	addr := &net.IPNet{IP: net.ParseIP(n.hostIP()), Mask: n.subnet.Mask}
+	if iface, err := net.InterfaceByName(tap); err == nil {
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			if a.String() == addr.String() {
+				return nil
+			}
+		}
+	}
+
+	out, err := exec.CommandContext(ctx, "ip", "address", "add", addr.String(), "dev", tap).CombinedOutput()
+	if err != nil {
+		return errors.Errorf("Unable to assign %s to tap device %s: %v: %s.  Assign it with ip address add %s dev %s",
+			addr, tap, err, strings.TrimSpace(string(out)), addr, tap)
+	}
+	return nil
+}
+
+func (firecrackerHypervisor) createRootfs(ctx context.Context, backingImage, instanceDir string, disk int) error {
+	vmImage := path.Join(instanceDir, "image.raw")
+	if _, err := os.Stat(vmImage); err == nil {
+		_ = os.Remove(vmImage)
+	}
+
+	out, err := exec.CommandContext(ctx, "qemu-img", "convert", "-O", "raw",
+		backingImage, vmImage).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to convert %s to raw: %s", backingImage, string(out))
+	}
+
+	out, err = exec.CommandContext(ctx, "qemu-img", "resize", "-f", "raw", vmImage,
+		fmt.Sprintf("%dG", disk)).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to resize %s: %s", vmImage, string(out))
+	}
+
+	return nil
+}
+
+func (firecrackerHypervisor) growRootfs(ctx context.Context, instanceDir string, disk int) error {
+	return growImage(ctx, path.Join(instanceDir, "image.raw"), "raw", disk)
+}
+
+func (firecrackerHypervisor) listenAddress(n *vmNetwork) string {
+	return n.hostIP()
+}
+
+func (firecrackerHypervisor) boot(ctx context.Context, ws *workspace, name string, in *types.VMSpec) error {
+	if processRunning(ws.instanceDir, "firecracker") {
+		return fmt.Errorf("VM is already running")
+	}
+
+	if err := checkQemuOnlyFeatures(ws, in, hypervisorFirecracker); err != nil {
+		return err
+	}
+
+	if in.MemoryBackend != "" {
+		return errors.New("Memory backends are not supported by firecracker")
+	}
+
+	if len(in.Disks) > 0 {
+		return errors.New("Data disks are not supported by firecracker")
+	}
+
+	kernelPath := path.Join(ws.instanceDir, "kernel")
+	if _, err := os.Stat(kernelPath); err != nil {
+		return fmt.Errorf("The firecracker hypervisor requires a workload with a kernel")
+	}
+
+	if in.TapSubnet != "" {
+		if err := configureTap(ctx, firecrackerTapName(name), ws.network); err != nil {
+			return err
+		}
+	}
+
+	cfg := newFirecrackerConfig(ws.instanceDir, name, in, ws.network, kernelSeed(ws, in))
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal firecracker configuration")
+	}
+
+	cfgPath := path.Join(ws.instanceDir, "firecracker.json")
+	err = ioutil.WriteFile(cfgPath, data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to write firecracker configuration")
+	}
+
+	socket := path.Join(ws.instanceDir, "firecracker.socket")
+	_ = os.Remove(socket)
+
+	return launchProcess(ws.instanceDir, "firecracker", "firecracker",
+		"--api-sock", socket, "--config-file", cfgPath)
+}
+
+func (firecrackerHypervisor) stop(ctx context.Context, instanceDir string) error {
+	if !processRunning(instanceDir, "firecracker") {
+		return errors.New("Failed to connect to VM")
+	}
+
+	return putAPIRequest(ctx, path.Join(instanceDir, "firecracker.socket"),
+		"http://localhost/actions", `{"action_type": "SendCtrlAltDel"}`)
+}
+
+func (firecrackerHypervisor) quit(ctx context.Context, instanceDir string) error {
+	return killProcess(instanceDir, "firecracker")
+}
+
+func (firecrackerHypervisor) watch(ctx context.Context, instanceDir string) (*vmWatcher, error) {
+	return watchProcess(instanceDir, "firecracker")
+}
+
+func (firecrackerHypervisor) monitor(ctx context.Context, instanceDir string) (*vmExit, error) {
+	return monitorProcess(ctx, instanceDir, "firecracker")
+}
+
+func (firecrackerHypervisor) running(ctx context.Context, instanceDir string) bool {
+	return processRunning(instanceDir, "firecracker")
+}
+
+func (firecrackerHypervisor) resize(ctx context.Context, instanceDir string, cur, next *types.VMSpec) (bool, error) {
+	return false, nil
+}
+
+func (firecrackerHypervisor) forward(ctx context.Context, instanceDir string, in *types.VMSpec, n *vmNetwork, p types.PortMapping,
+	add bool) error {
+	return errors.New("Port mappings are not supported by firecracker")
+}
+
+func (firecrackerHypervisor) addMount(ctx context.Context, instanceDir string, m *types.Mount) error {
+	return errors.New("Mounts are not supported by firecracker")
+}
+
+func (firecrackerHypervisor) removeMount(ctx context.Context, instanceDir string, m *types.Mount) error {
+	return errors.New("Mounts are not supported by firecracker")
+}
+
+func (firecrackerHypervisor) attachDisk(ctx context.Context, instanceDir string, d *types.Disk) error {
+	return errors.New("Data disks are not supported by firecracker")
+}
+
+func (firecrackerHypervisor) detachDisk(ctx context.Context, instanceDir string, d *types.Disk) error {
+	return errors.New("Data disks are not supported by firecracker")
+}
@@ -0,0 +1,165 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// VMs booted with UEFI firmware use the OVMF images installed on the host.
+// The variable store is copied to the instance directory when the VM is
+// first booted.
+
+const nvramFile = "OVMF_VARS.fd"
+
+// ovmfDirs lists the directories in which distributions install OVMF.
+var ovmfDirs = []string{
+	"/usr/share/OVMF",
+	"/usr/share/edk2/ovmf",
+	"/usr/share/edk2-ovmf/x64",
+	"/usr/share/edk2/x64",
+	"/usr/share/qemu",
+}
+
+type ovmfFiles struct {
+	code string
+	vars string
+}
+
+// ovmfCandidates lists, for each UEFI firmware, the names of the OVMF code
+// and variable store images that provide it, in order of preference.  The
+// variable stores used for secure boot have Microsoft's keys enrolled, so
+// that distribution shims and kernels are trusted.
+var ovmfCandidates = map[string][]ovmfFiles{
+	types.FirmwareUEFI: {
+		{"OVMF_CODE_4M.fd", "OVMF_VARS_4M.fd"},
+		{"OVMF_CODE.fd", "OVMF_VARS.fd"},
+		{"edk2-x86_64-code.fd", "edk2-i386-vars.fd"},
+	},
+	types.FirmwareUEFISecureBoot: {
+		{"OVMF_CODE_4M.secboot.fd", "OVMF_VARS_4M.ms.fd"},
+		{"OVMF_CODE.secboot.fd", "OVMF_VARS.secboot.fd"},
+		{"OVMF_CODE.secboot.fd", "OVMF_VARS.ms.fd"},
+	},
+}
+
+// firmwareType returns the firmware of a VM, defaulting to FirmwareBIOS,
+// or to FirmwareUEFI for aarch64 guests, which have no BIOS.
+func firmwareType(in *types.VMSpec) string {
+	if in.Firmware == "" {
+		if guestArch(in) == types.ArchAarch64 {
+			return types.FirmwareUEFI
+		}
+		return types.FirmwareBIOS
+	}
+	return in.Firmware
+}
+
+// checkFirmware verifies that the firmware selected by spec is known and
+// does not conflict with a custom BIOS.
+func checkFirmware(spec *workloadSpec) error {
+	if err := checkArchFirmware(spec); err != nil {
+		return err
+	}
+
+	switch firmwareType(&spec.VM) {
+	case types.FirmwareBIOS:
+		return nil
+	case types.FirmwareUEFI, types.FirmwareUEFISecureBoot:
+	default:
+		return errors.Errorf("Unknown firmware %s, expected %s, %s or %s", spec.VM.Firmware,
+			types.FirmwareBIOS, types.FirmwareUEFI, types.FirmwareUEFISecureBoot)
+	}
+
+	if spec.BIOS != "" {
+		return errors.Errorf("The bios and firmware %s fields cannot both be set", spec.VM.Firmware)
+	}
+	return nil
+}
+
+// findOVMF returns the paths of the OVMF code and variable store images
+// that provide firmware.
+func findOVMF(firmware string) (string, string, error) {
+	for _, dir := range ovmfDirs {
+		for _, c := range ovmfCandidates[firmware] {
+			code, vars := path.Join(dir, c.code), path.Join(dir, c.vars)
+			if _, err := os.Stat(code); err != nil {
+				continue
+			}
+			if _, err := os.Stat(vars); err != nil {
+				continue
+			}
+			return code, vars, nil
+		}
+	}
+
+	return "", "", errors.Errorf("Unable to find OVMF images for %s firmware in %s.  "+
+		"Install the ovmf package, or edk2-ovmf on Fedora", firmware, strings.Join(ovmfDirs, ", "))
+}
+
+// firmwareArgs returns the qemu arguments that boot a VM with firmware,
+// creating the instance's NVRAM if necessary.
+func firmwareArgs(instanceDir, firmware string) ([]string, error) {
+	if firmware == types.FirmwareBIOS {
+		return nil, nil
+	}
+
+	code, vars, err := findOVMF(firmware)
+	if err != nil {
+		return nil, err
+	}
+
+	pflash, err := pflashArgs(instanceDir, code, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+
+	// OVMF only enforces secure boot if the variable store is protected
+	// by SMM, which requires the q35 machine type.
+	if firmware == types.FirmwareUEFISecureBoot {
+		args = append(args,
+			"-machine", "q35,smm=on",
+			"-global", "driver=cfi.pflash01,property=secure,value=on")
+	}
+
+	return append(args, pflash...), nil
+}
+
+// pflashArgs returns the qemu arguments that map the UEFI firmware code
+// and the instance's NVRAM, created from the variable store vars if
+// necessary, to the VM's flash devices.
+func pflashArgs(instanceDir, code, vars string) ([]string, error) {
+	nvram := path.Join(instanceDir, nvramFile)
+	if _, err := os.Stat(nvram); os.IsNotExist(err) {
+		if err := copyImage(vars, instanceDir, nvramFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return []string{
+		"-drive", fmt.Sprintf("if=pflash,format=raw,readonly=on,file=%s", code),
+		"-drive", fmt.Sprintf("if=pflash,format=raw,file=%s", nvram),
+	}, nil
+}
@@ -0,0 +1,115 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestCheckFirmware(t *testing.T) {
+	valid := []workloadSpec{
+		{},
+		{BIOS: "file:///usr/share/OVMF/OVMF.fd"},
+		{VM: types.VMSpec{Firmware: types.FirmwareUEFI}},
+		{VM: types.VMSpec{Firmware: types.FirmwareUEFISecureBoot}},
+	}
+	for i := range valid {
+		if err := checkFirmware(&valid[i]); err != nil {
+			t.Errorf("Valid firmware rejected: %v", err)
+		}
+	}
+
+	invalid := []workloadSpec{
+		{VM: types.VMSpec{Firmware: "efi"}},
+		{BIOS: "file:///usr/share/OVMF/OVMF.fd", VM: types.VMSpec{Firmware: types.FirmwareUEFI}},
+	}
+	for i := range invalid {
+		if err := checkFirmware(&invalid[i]); err == nil {
+			t.Errorf("Expected error for %+v", invalid[i])
+		}
+	}
+}
+
+func TestFirmwareArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	savedDirs := ovmfDirs
+	ovmfDir := path.Join(dir, "OVMF")
+	ovmfDirs = []string{path.Join(dir, "missing"), ovmfDir}
+	defer func() { ovmfDirs = savedDirs }()
+
+	instanceDir := path.Join(dir, "instance")
+	for _, d := range []string{instanceDir, ovmfDir} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatalf("Unable to create %s: %v", d, err)
+		}
+	}
+
+	if args, err := firmwareArgs(instanceDir, types.FirmwareBIOS); err != nil || len(args) != 0 {
+		t.Errorf("Unexpected BIOS arguments %v: %v", args, err)
+	}
+	if _, err := firmwareArgs(instanceDir, types.FirmwareUEFI); err == nil {
+		t.Errorf("Expected error when OVMF is not installed")
+	}
+
+	for _, f := range []string{"OVMF_CODE.fd", "OVMF_VARS.fd", "OVMF_CODE.secboot.fd", "OVMF_VARS.secboot.fd"} {
+		if err := ioutil.WriteFile(path.Join(ovmfDir, f), []byte(f), 0644); err != nil {
+			t.Fatalf("Unable to write %s: %v", f, err)
+		}
+	}
+
+	args, err := firmwareArgs(instanceDir, types.FirmwareUEFI)
+	if err != nil {
+		t.Fatalf("Unable to find UEFI firmware: %v", err)
+	}
+	nvram := path.Join(instanceDir, nvramFile)
+	expected := "-drive if=pflash,format=raw,readonly=on,file=" + path.Join(ovmfDir, "OVMF_CODE.fd") +
+		" -drive if=pflash,format=raw,file=" + nvram
+	if strings.Join(args, " ") != expected {
+		t.Errorf("Unexpected UEFI arguments %v", args)
+	}
+	if data, _ := ioutil.ReadFile(nvram); string(data) != "OVMF_VARS.fd" {
+		t.Errorf("NVRAM not created from variable store: %q", data)
+	}
+
+	// The NVRAM of an instance must survive reboots.
+
+	if err := ioutil.WriteFile(nvram, []byte("boot entries"), 0644); err != nil {
+		t.Fatalf("Unable to write NVRAM: %v", err)
+	}
+	args, err = firmwareArgs(instanceDir, types.FirmwareUEFISecureBoot)
+	if err != nil {
+		t.Fatalf("Unable to find secure boot firmware: %v", err)
+	}
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, "q35,smm=on") || !strings.Contains(joined, "OVMF_CODE.secboot.fd") {
+		t.Errorf("Unexpected secure boot arguments %v", args)
+	}
+	if data, _ := ioutil.ReadFile(nvram); string(data) != "boot entries" {
+		t.Errorf("NVRAM overwritten: %q", data)
+	}
+}
@@ -0,0 +1,145 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// diskCheckFile is created in the instance directory when a check of the
+// instance's disk finds corruptions that have not been repaired.  Instances
+// with this file cannot be started unless forced.
+const diskCheckFile = "disk-corrupt"
+
+type qemuImgImage struct {
+	Filename string `json:"filename"`
+	Format   string `json:"format"`
+}
+
+type qemuImgCheck struct {
+	CheckErrors      int `json:"check-errors"`
+	Corruptions      int `json:"corruptions"`
+	Leaks            int `json:"leaks"`
+	CorruptionsFixed int `json:"corruptions-fixed"`
+	LeaksFixed       int `json:"leaks-fixed"`
+}
+
+func diskKnownBad(instanceDir string) bool {
+	_, err := os.Stat(path.Join(instanceDir, diskCheckFile))
+	return err == nil
+}
+
+// imageChain returns the images in the backing chain of image, starting with
+// image itself.
+func imageChain(ctx context.Context, image string) ([]qemuImgImage, error) {
+	out, err := exec.CommandContext(ctx, "qemu-img", "info", "--backing-chain",
+		"--output=json", image).Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to retrieve backing chain of %s", image)
+	}
+
+	var chain []qemuImgImage
+	err = json.Unmarshal(out, &chain)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse backing chain of %s", image)
+	}
+
+	return chain, nil
+}
+
+// checkImage runs qemu-img check on image.  qemu-img check exits with a
+// non-zero status if it finds corruptions or leaks, so the status is ignored
+// if it manages to output its results.
+func checkImage(ctx context.Context, image, format string, repair bool) (types.ImageCheck, error) {
+	args := []string{"check", "--output=json", "-f", format}
+	if repair {
+		args = append(args, "-r", "all")
+	}
+	args = append(args, image)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "qemu-img", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+
+	var res qemuImgCheck
+	if jsonErr := json.Unmarshal(out, &res); jsonErr != nil {
+		if err == nil {
+			err = jsonErr
+		}
+		return types.ImageCheck{}, errors.Wrapf(err, "Unable to check %s: %s", image,
+			stderr.String())
+	}
+
+	return types.ImageCheck{
+		Path:             image,
+		CheckErrors:      res.CheckErrors,
+		Corruptions:      res.Corruptions,
+		Leaks:            res.Leaks,
+		CorruptionsFixed: res.CorruptionsFixed,
+		LeaksFixed:       res.LeaksFixed,
+	}, nil
+}
+
+// checkInstanceDisk checks each image in the backing chain of the disk of an
+// instance, repairing the overlay if repair is true, and records the outcome
+// in the instance directory.
+func checkInstanceDisk(ctx context.Context, instanceDir string, repair bool) (*types.FsckResult, error) {
+	vmImage := path.Join(instanceDir, "image.qcow2")
+	if _, err := os.Stat(vmImage); err != nil {
+		return nil, errors.New("Only instances with qcow2 disks can be checked")
+	}
+
+	chain, err := imageChain(ctx, vmImage)
+	if err != nil {
+		return nil, err
+	}
+
+	var res types.FsckResult
+	for i, img := range chain {
+		check, err := checkImage(ctx, img.Filename, img.Format, repair && i == 0)
+		if err != nil {
+			return nil, err
+		}
+		if check.CheckErrors > 0 || check.Corruptions > check.CorruptionsFixed {
+			res.Corrupt = true
+		}
+		res.Images = append(res.Images, check)
+	}
+
+	markerPath := path.Join(instanceDir, diskCheckFile)
+	if res.Corrupt {
+		err = ioutil.WriteFile(markerPath, []byte(fmt.Sprintf("%+v\n", res.Images)), 0600)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to record disk corruption")
+		}
+	} else {
+		_ = os.Remove(markerPath)
+	}
+
+	return &res, nil
+}
@@ -0,0 +1,50 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCheckInstanceDiskNoImage(t *testing.T) {
+	instanceDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(instanceDir) }()
+
+	if _, err := checkInstanceDisk(context.Background(), instanceDir, false); err == nil {
+		t.Errorf("Expected check of instance without a qcow2 image to fail")
+	}
+
+	if diskKnownBad(instanceDir) {
+		t.Errorf("Instance unexpectedly marked as corrupt")
+	}
+
+	err = ioutil.WriteFile(path.Join(instanceDir, diskCheckFile), nil, 0600)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", diskCheckFile, err)
+	}
+
+	if !diskKnownBad(instanceDir) {
+		t.Errorf("Instance not marked as corrupt")
+	}
+}
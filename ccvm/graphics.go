@@ -0,0 +1,73 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// VMs whose graphics field is vnc or spice are given a virtio-gpu display
+// and a USB tablet.  The display is exposed on the host IP address of the
+// instance, without a password.
+
+// checkGraphics verifies that the graphical console of a VM is known.
+func checkGraphics(in *types.VMSpec) error {
+	switch in.Graphics {
+	case "", types.GraphicsNone, types.GraphicsVNC, types.GraphicsSPICE:
+		return nil
+	}
+	return errors.Errorf("Unknown graphics %s, expected %s, %s or %s", in.Graphics,
+		types.GraphicsNone, types.GraphicsVNC, types.GraphicsSPICE)
+}
+
+// graphicsArgs returns the qemu arguments that give a VM its display.
+func graphicsArgs(in *types.VMSpec, caps *qemuCaps) []string {
+	port := in.DisplayPort()
+	if port == 0 {
+		return []string{"-display", "none", "-vga", "none"}
+	}
+
+	args := []string{"-display", "none"}
+	if caps.hasDevice("virtio-vga") {
+		args = append(args, "-vga", "none", "-device", "virtio-vga")
+	} else if caps.hasDevice("virtio-gpu-pci") {
+		args = append(args, "-vga", "none", "-device", "virtio-gpu-pci")
+	} else {
+		args = append(args, "-vga", "std")
+	}
+	if caps.hasDevice("qemu-xhci") && caps.hasDevice("usb-tablet") {
+		args = append(args, "-device", "qemu-xhci,id=xhci", "-device", "usb-tablet,bus=xhci.0")
+	}
+
+	hostIP := in.HostIP.String()
+	if in.Graphics == types.GraphicsVNC {
+		display := strconv.Itoa(port - types.VNCPort)
+		return append(args, "-vnc", net.JoinHostPort(hostIP, display))
+	}
+
+	// The SPICE agent channel lets the guest resize its display to the
+	// size of the viewer's window and share the clipboard.
+	return append(args,
+		"-spice", fmt.Sprintf("addr=%s,port=%d,disable-ticketing=on", hostIP, port),
+		"-chardev", "spicevmc,id=vdagent,name=vdagent",
+		"-device", "virtserialport,chardev=vdagent,name=com.redhat.spice.0")
+}
@@ -0,0 +1,97 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestCheckGraphics(t *testing.T) {
+	for _, g := range []string{"", types.GraphicsNone, types.GraphicsVNC, types.GraphicsSPICE} {
+		if err := checkGraphics(&types.VMSpec{Graphics: g}); err != nil {
+			t.Errorf("Unexpected error for graphics %q: %v", g, err)
+		}
+	}
+	if err := checkGraphics(&types.VMSpec{Graphics: "sdl"}); err == nil {
+		t.Errorf("Expected sdl graphics to be rejected")
+	}
+}
+
+func TestGraphicsArgs(t *testing.T) {
+	caps := &qemuCaps{
+		devices: map[string]struct{}{
+			"virtio-vga": {},
+			"qemu-xhci":  {},
+			"usb-tablet": {},
+		},
+	}
+	hostIP := net.IPv4(127, 0, 0, 2)
+
+	tests := []struct {
+		graphics string
+		caps     *qemuCaps
+		expected []string
+	}{
+		{"", caps, []string{"-vga none"}},
+		{types.GraphicsNone, caps, []string{"-vga none"}},
+		{types.GraphicsVNC, caps, []string{"-device virtio-vga", "-device usb-tablet,bus=xhci.0",
+			"-vnc 127.0.0.2:0"}},
+		{types.GraphicsVNC, &qemuCaps{}, []string{"-vga std", "-vnc 127.0.0.2:0"}},
+		{types.GraphicsSPICE, caps, []string{"-device virtio-vga",
+			"-spice addr=127.0.0.2,port=5930,disable-ticketing=on", "name=com.redhat.spice.0"}},
+	}
+
+	for _, tt := range tests {
+		in := &types.VMSpec{Graphics: tt.graphics, HostIP: hostIP}
+		args := strings.Join(graphicsArgs(in, tt.caps), " ")
+		for _, e := range tt.expected {
+			if !strings.Contains(args, e) {
+				t.Errorf("%s not found in arguments for graphics %q: %s", e, tt.graphics, args)
+			}
+		}
+	}
+}
+
+func TestMergeGraphics(t *testing.T) {
+	in := types.VMSpec{HostIP: net.IPv4(127, 0, 0, 2)}
+	in.Merge(&types.VMSpec{Graphics: types.GraphicsVNC})
+	if in.DisplayPort() != types.VNCPort {
+		t.Errorf("Unexpected display port %d", in.DisplayPort())
+	}
+
+	if err := in.MergeCustom(&types.VMSpec{Graphics: types.GraphicsSPICE}); err != nil {
+		t.Fatal(err)
+	}
+	if in.DisplayPort() != types.SPICEPort {
+		t.Errorf("Unexpected display port %d", in.DisplayPort())
+	}
+
+	if err := in.MergeCustom(&types.VMSpec{Graphics: types.GraphicsNone}); err != nil {
+		t.Fatal(err)
+	}
+	if in.DisplayPort() != 0 {
+		t.Errorf("Unexpected display port %d", in.DisplayPort())
+	}
+
+	if err := in.MergeCustom(&types.VMSpec{Graphics: "gtk"}); err == nil {
+		t.Errorf("Expected unknown graphics to be rejected")
+	}
+}
@@ -0,0 +1,321 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// A group is a set of instances created together from a group workload,
+// whose roles are each backed by an ordinary workload.  The instances of a
+// group are named <group>-<role>-<index>.
+
+const (
+	groupStart = iota
+	groupStop
+	groupQuit
+	groupDelete
+)
+
+func parseGroupSpec(workloadName string, data []byte) (*types.GroupSpec, error) {
+	var spec types.GroupSpec
+
+	err := yaml.Unmarshal(data, &spec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse group workload %s", workloadName)
+	}
+
+	if len(spec.Roles) == 0 {
+		return nil, errors.Errorf("%s is not a group workload", workloadName)
+	}
+
+	roles := make(map[string]struct{})
+	for i := range spec.Roles {
+		role := &spec.Roles[i]
+		if role.Name == "" {
+			return nil, errors.Errorf("Role %d of %s has no name", i+1, workloadName)
+		}
+		if _, ok := roles[role.Name]; ok {
+			return nil, errors.Errorf("Role %s is declared more than once", role.Name)
+		}
+		roles[role.Name] = struct{}{}
+		if role.Workload == "" {
+			return nil, errors.Errorf("Role %s has no workload", role.Name)
+		}
+		if role.Count < 0 {
+			return nil, errors.Errorf("Invalid instance count %d for role %s", role.Count, role.Name)
+		}
+		if role.Count == 0 {
+			role.Count = 1
+		}
+		if len(role.VM.HostIP) != 0 {
+			return nil, errors.Errorf("Role %s may not specify a host IP address", role.Name)
+		}
+	}
+
+	return &spec, nil
+}
+
+func (c ccvmBackend) loadGroup(ctx context.Context, args *types.CreateArgs) (*types.GroupSpec, error) {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	transport := getHTTPTransport(args.HTTPProxy, args.HTTPSProxy, args.NoProxy)
+	data, err := loadWorkloadData(withOffline(ctx, args.Offline), ws, args.WorkloadName, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGroupSpec(args.WorkloadName, data)
+}
+
+// groupMembers returns the sorted names of the instances that belong to
+// group.
+func (s *ccvmService) groupMembers(group string) []string {
+	var names []string
+	for name, g := range s.groups {
+		if g == group {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// createGroup creates the instances of the group workload args.WorkloadName,
+// role by role.  No further instances are created once the creation of an
+// instance fails.
+func (s *ccvmService) createGroup(ctx context.Context, resultCh chan interface{}, args *types.CreateArgs) {
+	if args.Name == "" {
+		resultCh <- errors.New("A group name must be specified")
+		close(resultCh)
+		return
+	}
+
+	if args.Count > 1 || args.NameTemplate != "" || len(args.CustomSpec.HostIP) != 0 {
+		resultCh <- errors.New("Instance counts, name templates and host IP addresses cannot be specified for groups")
+		close(resultCh)
+		return
+	}
+
+	if len(s.groupMembers(args.Name)) > 0 {
+		resultCh <- errors.Errorf("Group %s already exists", args.Name)
+		close(resultCh)
+		return
+	}
+
+	spec, err := s.b.loadGroup(ctx, args)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+
+	var members []types.GroupMember
+	for _, role := range spec.Roles {
+		for i := 1; i <= role.Count; i++ {
+			name := fmt.Sprintf("%s-%s-%d", args.Name, role.Name, i)
+			if !hostnameRegexp.MatchString(name) {
+				err = errors.Errorf("Invalid hostname %s", name)
+			} else if _, ok := s.instances[name]; ok {
+				err = errors.Errorf("Instance %s already exists", name)
+			}
+			if err != nil {
+				resultCh <- err
+				close(resultCh)
+				return
+			}
+			members = append(members, types.GroupMember{Name: name, Role: role.Name})
+		}
+	}
+
+	hostIPs, flatIPs, err := s.findFreeIPs(len(members))
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	for i := range members {
+		members[i].HostIP = hostIPs[i]
+	}
+
+	var failedLock sync.Mutex
+	failed := false
+
+	var prevDone chan struct{}
+	creates := make([]pendingCreate, 0, len(members))
+	for _, role := range spec.Roles {
+		waitCh := prevDone
+		done := make(chan struct{})
+		roleWg := &sync.WaitGroup{}
+		roleWg.Add(role.Count)
+		go func() {
+			roleWg.Wait()
+			close(done)
+		}()
+
+		for i := 1; i <= role.Count; i++ {
+			member := members[len(creates)]
+			instanceArgs := *args
+			instanceArgs.Name = member.Name
+			instanceArgs.WorkloadName = role.Workload
+			instanceArgs.CustomSpec = role.VM
+			instanceArgs.CustomSpec.HostIP = member.HostIP
+			instanceArgs.Group = &types.GroupInfo{
+				Name:    args.Name,
+				Role:    role.Name,
+				Index:   i,
+				Members: members,
+			}
+
+			creates = append(creates, pendingCreate{
+				args:   &instanceArgs,
+				flatIP: flatIPs[len(creates)],
+				before: func() error {
+					if waitCh == nil {
+						return nil
+					}
+					select {
+					case <-waitCh:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+					failedLock.Lock()
+					defer failedLock.Unlock()
+					if failed {
+						return errors.New("Creation of an instance of a previous role failed")
+					}
+					return nil
+				},
+				after: func(err error) {
+					if err != nil {
+						failedLock.Lock()
+						failed = true
+						failedLock.Unlock()
+					}
+					roleWg.Done()
+				},
+			})
+			s.groups[member.Name] = args.Name
+		}
+		prevDone = done
+	}
+
+	s.launchCreates(ctx, resultCh, creates)
+}
+
+// groupAction starts, stops, quits or deletes all the instances of a group.
+// The action is applied to each instance, in parallel, in the instance's
+// loop.  A single error, listing the instances for which the action
+// failed, is returned if the action fails for any instance.
+func (s *ccvmService) groupAction(ctx context.Context, group string, action int, allowed func(string) bool,
+	resultCh chan interface{}) {
+	names := s.groupMembers(group)
+	if len(names) == 0 {
+		resultCh <- errors.Errorf("Group %s does not exist", group)
+		close(resultCh)
+		return
+	}
+
+	// The action applies to all the instances of the group or to none.
+
+	for _, name := range names {
+		if !allowed(name) {
+			resultCh <- errors.Errorf("Access to instance %s of group %s denied", name, group)
+			close(resultCh)
+			return
+		}
+	}
+
+	instanceResults := make([]chan interface{}, len(names))
+	for i := range names {
+		instanceName := names[i]
+		instanceResult := make(chan interface{}, 1)
+		instanceResults[i] = instanceResult
+
+		cmd := instanceCmd{
+			cmdType:  instanceCmdOther,
+			resultCh: instanceResult,
+			ctx:      ctx,
+		}
+		kickCh := s.monitors[instanceName]
+		var fn func() error
+		var event string
+		switch action {
+		case groupStart:
+			fn = func() error {
+				return s.b.start(ctx, instanceName, &types.VMSpec{}, false)
+			}
+			event = types.EventStarted
+		case groupStop:
+			fn = func() error {
+				_, err := s.b.stop(ctx, &types.StopArgs{Name: instanceName})
+				return err
+			}
+			event = types.EventStopped
+		case groupQuit:
+			fn = func() error {
+				return s.b.quit(ctx, instanceName)
+			}
+			event = types.EventStopped
+		case groupDelete:
+			cmd.cmdType = instanceCmdDelete
+			fn = func() error {
+				return s.b.deleteInstance(ctx, instanceName)
+			}
+			event = types.EventDeleted
+		}
+		cmd.fn = func() error {
+			err := fn()
+			if err == nil {
+				s.events.publish(instanceName, event)
+				if action == groupStart {
+					kickMonitor(kickCh)
+				}
+			}
+			if action == groupDelete {
+				return err
+			}
+			instanceResult <- err
+			return nil
+		}
+		s.instances[instanceName] <- cmd
+	}
+
+	go func() {
+		var failures []string
+		for i, instanceResult := range instanceResults {
+			if err, ok := (<-instanceResult).(error); ok && err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", names[i], err))
+			}
+		}
+		if len(failures) > 0 {
+			resultCh <- errors.Errorf("Group %s: %s", group, strings.Join(failures, ", "))
+		}
+		close(resultCh)
+	}()
+}
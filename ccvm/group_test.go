@@ -0,0 +1,93 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+const groupWorkload = `
+roles:
+  - name: master
+    workload: xenial
+    vm:
+      mem_mib: 4096
+  - name: worker
+    workload: xenial
+    count: 2
+`
+
+func TestParseGroupSpec(t *testing.T) {
+	spec, err := parseGroupSpec("cluster", []byte(groupWorkload))
+	if err != nil {
+		t.Fatalf("Failed to parse group workload: %v", err)
+	}
+
+	if len(spec.Roles) != 2 {
+		t.Fatalf("Expected 2 roles, found %d", len(spec.Roles))
+	}
+	if spec.Roles[0].Count != 1 || spec.Roles[0].VM.MemMiB != 4096 {
+		t.Errorf("Unexpected master role %+v", spec.Roles[0])
+	}
+	if spec.Roles[1].Count != 2 {
+		t.Errorf("Unexpected worker role %+v", spec.Roles[1])
+	}
+}
+
+func TestParseBadGroupSpec(t *testing.T) {
+	bad := []string{
+		"vm:\n  mem_mib: 1024\n",
+		"roles:\n  - workload: xenial\n",
+		"roles:\n  - name: master\n",
+		"roles:\n  - name: a\n    workload: xenial\n  - name: a\n    workload: xenial\n",
+		"roles:\n  - name: a\n    workload: xenial\n    count: -1\n",
+		"roles:\n  - name: a\n    workload: xenial\n    vm:\n      host_ip: 127.0.0.2\n",
+	}
+
+	for _, data := range bad {
+		if _, err := parseGroupSpec("cluster", []byte(data)); err == nil {
+			t.Errorf("Expected group workload %q to be rejected", data)
+		}
+	}
+}
+
+func TestGroupTemplate(t *testing.T) {
+	ws := &workspace{
+		Group: &types.GroupInfo{
+			Name:  "k8s",
+			Role:  "worker",
+			Index: 1,
+			Members: []types.GroupMember{
+				{Name: "k8s-master-1", Role: "master", HostIP: net.IPv4(127, 3, 232, 1)},
+				{Name: "k8s-worker-1", Role: "worker", HostIP: net.IPv4(127, 3, 232, 2)},
+			},
+		},
+	}
+
+	var spec types.VMSpec
+	data := "reverse_ports:\n  - guest: 6443\n    host: {{.Group.HostIP \"master\"}}:6443\n"
+	if err := unmarshalWithTemplate(&spec, ws, data); err != nil {
+		t.Fatalf("Failed to unmarshal spec: %v", err)
+	}
+
+	if len(spec.ReversePorts) != 1 || spec.ReversePorts[0].Host != "127.3.232.1:6443" {
+		t.Errorf("Unexpected reverse ports %+v", spec.ReversePorts)
+	}
+}
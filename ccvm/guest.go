@@ -0,0 +1,356 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Guests send requests to the daemon using the ccvm-guest helper, which
+// exchanges JSON encoded messages with the daemon over a virtio-serial port.
+// Requests are executed in the instance's loop.
+
+const (
+	guestPortName     = "org.ccloudvm.guest.0"
+	guestSocket       = "guest.sock"
+	guestPollInterval = 5 * time.Second
+	notificationFile  = "notification.yaml"
+)
+
+// guestHelperPath is the location of the ccvm-guest helper in the guest.
+const guestHelperPath = "/usr/local/bin/ccvm-guest"
+
+// guestHelperScript is the ccvm-guest helper.  Requests are serialised by
+// locking the port so that concurrent invocations do not interleave.  The
+// helper also applies the clock setting of the instance, when invoked with
+// the clock command at boot.
+const guestHelperScript = `#!/bin/sh
+port=/dev/virtio-ports/` + guestPortName + `
+clock_cfg=/sys/firmware/qemu_fw_cfg/by_name/` + clockFwCfgName + `/raw
+
+usage() {
+	echo "Usage: ccvm-guest forward guest-port [host-port]" >&2
+	echo "       ccvm-guest mount tag host-path [guest-path]" >&2
+	echo "       ccvm-guest notify message" >&2
+	exit 2
+}
+
+case "$1" in
+forward) [ $# -eq 2 ] || [ $# -eq 3 ] || usage ;;
+mount) [ $# -eq 3 ] || [ $# -eq 4 ] || usage ;;
+notify) [ $# -eq 2 ] || usage ;;
+clock) [ $# -eq 1 ] || usage ;;
+*) usage ;;
+esac
+
+[ "$(id -u)" -eq 0 ] || exec sudo "$0" "$@"
+
+if [ $1 = clock ]; then
+	modprobe qemu_fw_cfg 2> /dev/null
+	[ -r $clock_cfg ] || exit 0
+	read -r mode secs < $clock_cfg
+	for s in systemd-timesyncd chrony chronyd ntp ntpd; do
+		systemctl mask --runtime --now $s > /dev/null 2>&1
+	done
+	case $mode in
+	offset)
+		hwclock --hctosys --utc 2> /dev/null || date -s @$secs > /dev/null
+		;;
+	frozen)
+		hold="while :; do date -s @$secs > /dev/null; sleep 1; done"
+		systemd-run --unit=ccvm-frozen-clock /bin/sh -c "$hold" > /dev/null 2>&1 ||
+			nohup /bin/sh -c "$hold" > /dev/null 2>&1 &
+		;;
+	esac
+	exit 0
+fi
+
+if [ ! -c $port ]; then
+	echo "ccvm-guest: $port not found" >&2
+	exit 1
+fi
+
+cmd=$1
+shift
+guest_path=
+if [ $cmd = mount ]; then
+	guest_path=${3:-$2}
+	set -- "$1" "$2"
+fi
+
+req="{\"command\":\"$cmd\",\"args\":["
+sep=
+for a in "$@"; do
+	a=$(printf '%s' "$a" | tr '\n\t' '  ' | sed 's/\\/\\\\/g; s/"/\\"/g')
+	req="$req$sep\"$a\""
+	sep=,
+done
+req="$req]}"
+
+exec 9<>$port
+flock 9
+if ! printf '%s\n' "$req" >&9 2> /dev/null; then
+	echo "ccvm-guest: the ccloudvm daemon is not connected" >&2
+	exit 1
+fi
+IFS= read -r resp <&9
+case "$resp" in
+*'"error"'*)
+	echo "ccvm-guest: $(printf '%s' "$resp" | sed 's/^{"error":"\(.*\)"}$/\1/')" >&2
+	exit 1
+	;;
+esac
+
+if [ $cmd = mount ]; then
+	mkdir -p "$guest_path" && mount -t virtiofs "$1" "$guest_path"
+fi
+`
+
+type guestRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+type guestResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// guestAction asks the service to execute a request received from the guest
+// of the instance name.  The outcome is sent to resultCh.
+type guestAction struct {
+	name     string
+	req      guestRequest
+	resultCh chan interface{}
+}
+
+// guestConnection informs the service that the daemon has connected to, or
+// disconnected from, the guest channel of the instance name.
+type guestConnection struct {
+	name      string
+	connected bool
+}
+
+// guestConfig contains the daemon wide settings that apply to requests
+// received from guests.  Guests may only share host directories that are
+// located under one of AllowedMounts.
+type guestConfig struct {
+	AllowedMounts []string `yaml:"allowed_mounts"`
+}
+
+func (g *guestConfig) mountAllowed(hostPath string) bool {
+	p, err := filepath.EvalSymlinks(hostPath)
+	if err != nil {
+		return false
+	}
+
+	for _, root := range g.AllowedMounts {
+		r, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		if p == r || strings.HasPrefix(p, r+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type savedNotification struct {
+	Message string    `yaml:"message"`
+	Time    time.Time `yaml:"time"`
+}
+
+// loadNotification returns the last notification sent by the guest of an
+// instance.  A zero notification is returned if there is none.
+func loadNotification(instanceDir string) types.GuestNotification {
+	data, err := ioutil.ReadFile(path.Join(instanceDir, notificationFile))
+	if err != nil {
+		return types.GuestNotification{}
+	}
+
+	var sn savedNotification
+	if err := yaml.Unmarshal(data, &sn); err != nil {
+		return types.GuestNotification{}
+	}
+
+	return types.GuestNotification{
+		Message: sn.Message,
+		Time:    sn.Time,
+	}
+}
+
+func saveNotification(instanceDir, message string) error {
+	data, err := yaml.Marshal(&savedNotification{
+		Message: message,
+		Time:    time.Now(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal notification")
+	}
+
+	err = ioutil.WriteFile(path.Join(instanceDir, notificationFile), data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to write notification")
+	}
+
+	return nil
+}
+
+func (c ccvmBackend) guestRequest(ctx context.Context, name string, req *guestRequest) error {
+	switch req.Command {
+	case "forward":
+		if len(req.Args) < 1 || len(req.Args) > 2 {
+			return errors.New("forward expects a guest port and an optional host port")
+		}
+		guest, err := strconv.Atoi(req.Args[0])
+		if err != nil {
+			return errors.Errorf("Invalid guest port %s", req.Args[0])
+		}
+		host := guest
+		if len(req.Args) == 2 {
+			host, err = strconv.Atoi(req.Args[1])
+			if err != nil {
+				return errors.Errorf("Invalid host port %s", req.Args[1])
+			}
+		}
+		return c.forward(ctx, name, types.PortMapping{Host: host, Guest: guest}, true)
+	case "mount":
+		if len(req.Args) != 2 {
+			return errors.New("mount expects a tag and a host path")
+		}
+		hostPath := filepath.Clean(req.Args[1])
+		if c.cfg == nil || !c.cfg.Guest.mountAllowed(hostPath) {
+			return errors.Errorf("Guests are not permitted to mount %s", hostPath)
+		}
+		return c.mount(ctx, name, types.Mount{
+			Tag:           req.Args[0],
+			SecurityModel: "passthrough",
+			Path:          hostPath,
+			Type:          types.MountTypeVirtiofs,
+		}, true)
+	case "notify":
+		if len(req.Args) != 1 {
+			return errors.New("notify expects a single message")
+		}
+		ws, err := prepareEnv(ctx, name)
+		if err != nil {
+			return err
+		}
+		return saveNotification(ws.instanceDir, req.Args[0])
+	}
+
+	return errors.Errorf("Unknown guest request %s", req.Command)
+}
+
+// sendGuestAction asks the service to execute req and waits for the result.
+func sendGuestAction(ctx context.Context, actionCh chan<- interface{}, name string, req guestRequest) error {
+	resultCh := make(chan interface{}, 1)
+	select {
+	case actionCh <- guestAction{name: name, req: req, resultCh: resultCh}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case r := <-resultCh:
+		err, _ := r.(error)
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func serveGuest(ctx context.Context, name string, conn net.Conn, closeCh <-chan struct{}, actionCh chan<- interface{}) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-closeCh:
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req guestRequest
+		var res guestResponse
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			res.Error = "Invalid request"
+		} else if err := sendGuestAction(ctx, actionCh, name, req); err != nil {
+			res.Error = err.Error()
+		}
+		if err := enc.Encode(&res); err != nil {
+			return
+		}
+	}
+}
+
+// watchGuest connects to the guest channel of the instance name whenever the
+// instance is running and serves the requests sent by its guest.  It
+// returns when ctx is cancelled or when the instance's loop quits.
+func watchGuest(ctx context.Context, name, socket string, closeCh <-chan struct{}, actionCh chan<- interface{}) {
+	notify := func(connected bool) bool {
+		select {
+		case actionCh <- guestConnection{name: name, connected: connected}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		if _, err := os.Stat(socket); err == nil {
+			conn, err := net.Dial("unix", socket)
+			if err == nil {
+				if !notify(true) {
+					_ = conn.Close()
+					return
+				}
+				serveGuest(ctx, name, conn, closeCh, actionCh)
+				if !notify(false) {
+					return
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-closeCh:
+			return
+		case <-time.After(guestPollInterval):
+		}
+	}
+}
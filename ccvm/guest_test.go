@@ -0,0 +1,158 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGuestMountAllowed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	allowed := filepath.Join(dir, "allowed")
+	other := filepath.Join(dir, "allowed-not")
+	for _, d := range []string{filepath.Join(allowed, "sub"), other} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", d, err)
+		}
+	}
+	link := filepath.Join(allowed, "link")
+	if err := os.Symlink(other, link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	cfg := guestConfig{AllowedMounts: []string{allowed}}
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{allowed, true},
+		{filepath.Join(allowed, "sub"), true},
+		{other, false},
+		{link, false},
+		{filepath.Join(allowed, "missing"), false},
+	}
+	for _, tt := range tests {
+		if got := cfg.mountAllowed(tt.path); got != tt.allowed {
+			t.Errorf("mountAllowed(%s) = %v, expected %v", tt.path, got, tt.allowed)
+		}
+	}
+}
+
+func TestGuestNotification(t *testing.T) {
+	instanceDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(instanceDir) }()
+
+	if n := loadNotification(instanceDir); !n.Time.IsZero() {
+		t.Errorf("Expected zero notification, got %+v", n)
+	}
+
+	if err := saveNotification(instanceDir, "job finished"); err != nil {
+		t.Fatalf("Failed to save notification: %v", err)
+	}
+
+	if n := loadNotification(instanceDir); n.Message != "job finished" || n.Time.IsZero() {
+		t.Errorf("Unexpected notification %+v", n)
+	}
+}
+
+func TestBadGuestRequests(t *testing.T) {
+	var c ccvmBackend
+
+	requests := []guestRequest{
+		{Command: "reboot"},
+		{Command: "forward"},
+		{Command: "forward", Args: []string{"http"}},
+		{Command: "forward", Args: []string{"80", "http"}},
+		{Command: "mount", Args: []string{"docs"}},
+		{Command: "mount", Args: []string{"docs", "/"}},
+		{Command: "notify"},
+	}
+	for _, req := range requests {
+		if err := c.guestRequest(context.Background(), "instance", &req); err == nil {
+			t.Errorf("Expected request %+v to fail", req)
+		}
+	}
+}
+
+func TestServeGuest(t *testing.T) {
+	host, guest := net.Pipe()
+	actionCh := make(chan interface{})
+	closeCh := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		serveGuest(ctx, "instance", host, closeCh, actionCh)
+		close(done)
+	}()
+
+	go func() {
+		for a := range actionCh {
+			ga := a.(guestAction)
+			if ga.req.Command == "notify" {
+				ga.resultCh <- nil
+			} else {
+				ga.resultCh <- errors.New("Failure")
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(guest)
+	for _, tt := range []struct {
+		req  string
+		fail bool
+	}{
+		{`{"command":"notify","args":["done"]}`, false},
+		{`{"command":"forward","args":["80"]}`, true},
+		{`not json`, true},
+	} {
+		if _, err := guest.Write([]byte(tt.req + "\n")); err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if !scanner.Scan() {
+			t.Fatalf("No response to %s", tt.req)
+		}
+		var res guestResponse
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			t.Fatalf("Invalid response %s: %v", scanner.Text(), err)
+		}
+		if (res.Error != "") != tt.fail {
+			t.Errorf("Unexpected response %+v to %s", res, tt.req)
+		}
+	}
+
+	close(closeCh)
+	<-done
+	close(actionCh)
+}
@@ -0,0 +1,211 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Workloads can declare hooks, shell commands run on the host by the daemon
+// at defined points of the lifecycle of their instances.  Hooks are only run
+// if they are enabled in the daemon configuration file.
+
+const (
+	hookPreCreate = "pre_create"
+	hookPostStart = "post_start"
+	hookPreStop   = "pre_stop"
+
+	defaultHookTimeout = time.Minute
+)
+
+// workloadHooks lists the hooks declared by a workload.
+type workloadHooks struct {
+	PreCreate string `yaml:"pre_create,omitempty"`
+	PostStart string `yaml:"post_start,omitempty"`
+	PreStop   string `yaml:"pre_stop,omitempty"`
+}
+
+func (h *workloadHooks) merge(parent *workloadHooks) {
+	if h.PreCreate == "" {
+		h.PreCreate = parent.PreCreate
+	}
+	if h.PostStart == "" {
+		h.PostStart = parent.PostStart
+	}
+	if h.PreStop == "" {
+		h.PreStop = parent.PreStop
+	}
+}
+
+func (h *workloadHooks) command(hook string) string {
+	switch hook {
+	case hookPreCreate:
+		return h.PreCreate
+	case hookPostStart:
+		return h.PostStart
+	case hookPreStop:
+		return h.PreStop
+	}
+	return ""
+}
+
+// hooksConfig enables the hooks of the workloads in the daemon
+// configuration file.  Timeout is a duration, e.g., 30s, after which a
+// hook is killed.  It defaults to a minute.
+type hooksConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Timeout string `yaml:"timeout"`
+}
+
+func (c *hooksConfig) timeout() (time.Duration, error) {
+	if c.Timeout == "" {
+		return defaultHookTimeout, nil
+	}
+
+	v, err := time.ParseDuration(c.Timeout)
+	if err != nil || v <= 0 {
+		return 0, errors.Errorf("Invalid hook timeout %s", c.Timeout)
+	}
+	return v, nil
+}
+
+// hookEnv returns the environment variables that describe the instance
+// name to its hooks.
+func hookEnv(hook string, ws *workspace, name string, wkld *workload) []string {
+	in := &wkld.spec.VM
+	ports := make([]string, 0, len(in.PortMappings))
+	for _, p := range in.PortMappings {
+		ports = append(ports, fmt.Sprintf("%d:%d", p.Host, p.Guest))
+	}
+
+	env := []string{
+		"CCLOUDVM_HOOK=" + hook,
+		"CCLOUDVM_INSTANCE=" + name,
+		"CCLOUDVM_INSTANCE_DIR=" + ws.instanceDir,
+		"CCLOUDVM_WORKLOAD=" + wkld.spec.WorkloadName,
+		"CCLOUDVM_HOST_IP=" + in.HostIP.String(),
+		"CCLOUDVM_SSH_KEY=" + ws.keyPath,
+		"CCLOUDVM_PORTS=" + strings.Join(ports, " "),
+		"CCLOUDVM_GROUP=" + wkld.spec.Group,
+		"CCLOUDVM_ROLE=" + wkld.spec.Role,
+	}
+	if host, port, err := sshEndpoint(in); err == nil {
+		env = append(env, "CCLOUDVM_SSH_HOST="+host.String(),
+			"CCLOUDVM_SSH_PORT="+strconv.Itoa(port))
+	}
+	return env
+}
+
+// userHookPath is the PATH of the hooks run on behalf of the users of a
+// system daemon.
+const userHookPath = "/usr/local/bin:/usr/bin:/bin"
+
+// hookProcessEnv returns the environment of the process running the hook
+// of the instance name.  In system mode, the hook does not inherit the
+// environment of the daemon, which runs as root.
+func hookProcessEnv(hook string, ws *workspace, name string, wkld *workload) []string {
+	var env []string
+	if ws.account != nil {
+		env = []string{"PATH=" + userHookPath}
+	} else {
+		env = os.Environ()
+	}
+	env = append(env, "HOME="+ws.Home, "USER="+ws.User)
+	return append(env, hookEnv(hook, ws, name, wkld)...)
+}
+
+// runHook runs the hook of the instance name, if its workload declares one
+// and hooks are enabled.  In system mode, the hook is run as the user that
+// owns the instance.
+func (c ccvmBackend) runHook(ctx context.Context, hook string, ws *workspace, name string, wkld *workload) error {
+	command := wkld.spec.Hooks.command(hook)
+	if command == "" {
+		return nil
+	}
+	if !c.cfg.Hooks.Enabled {
+		instanceLog(name).Warningf("Not running %s hook: hooks are not enabled", hook)
+		return nil
+	}
+
+	timeout, err := c.cfg.Hooks.timeout()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Dir = ws.instanceDir
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.Env = hookProcessEnv(hook, ws, name, wkld)
+	if ws.account != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid: uint32(ws.UID),
+				Gid: uint32(ws.GID),
+			},
+		}
+	}
+
+	err = cmd.Run()
+	out := strings.TrimSpace(output.String())
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = errors.Errorf("timed out after %v", timeout)
+		}
+		if out != "" {
+			return errors.Errorf("%s hook failed: %v: %s", hook, err, out)
+		}
+		return errors.Errorf("%s hook failed: %v", hook, err)
+	}
+
+	if out != "" {
+		instanceLog(name).Infof("%s hook: %s", hook, out)
+	} else {
+		instanceLog(name).Infof("%s hook succeeded", hook)
+	}
+	return nil
+}
+
+// runHookLogged runs a hook whose failure does not prevent the operation
+// that triggered it, logging the failure.  The workload of the instance is
+// loaded if wkld is nil.
+func (c ccvmBackend) runHookLogged(ctx context.Context, hook string, ws *workspace, name string, wkld *workload) {
+	if wkld == nil {
+		var err error
+		wkld, err = restoreWorkload(ws)
+		if err != nil {
+			instanceLog(name).Warningf("Not running %s hook: %v", hook, err)
+			return
+		}
+	}
+	if err := c.runHook(ctx, hook, ws, name, wkld); err != nil {
+		instanceLog(name).Warningf("%v", err)
+	}
+}
@@ -0,0 +1,129 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestRunHook(t *testing.T) {
+	instanceDir, err := ioutil.TempDir("", "ccloudvm-hooks-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(instanceDir) }()
+
+	ws := &workspace{
+		instanceDir: instanceDir,
+		keyPath:     path.Join(instanceDir, "id_ed25519"),
+	}
+	wkld := &workload{
+		spec: workloadSpec{
+			WorkloadName: "xenial",
+			VM: types.VMSpec{
+				HostIP: net.ParseIP("127.0.0.2"),
+				PortMappings: []types.PortMapping{
+					{Host: 10022, Guest: 22},
+					{Host: 8080, Guest: 80},
+				},
+			},
+			Hooks: workloadHooks{
+				PostStart: "echo $CCLOUDVM_HOOK $CCLOUDVM_INSTANCE $CCLOUDVM_HOST_IP $CCLOUDVM_SSH_PORT $CCLOUDVM_PORTS > hook.out",
+				PreStop:   "echo unregistering; exit 3",
+			},
+		},
+	}
+	ctx := context.Background()
+	outPath := path.Join(instanceDir, "hook.out")
+
+	c := ccvmBackend{cfg: &daemonConfig{}}
+	if err := c.runHook(ctx, hookPostStart, ws, "dev", wkld); err != nil {
+		t.Fatalf("Disabled hook failed: %v", err)
+	}
+	if _, err := os.Stat(outPath); err == nil {
+		t.Fatalf("Hook run although hooks are disabled")
+	}
+
+	c.cfg.Hooks.Enabled = true
+	if err := c.runHook(ctx, hookPostStart, ws, "dev", wkld); err != nil {
+		t.Fatalf("Hook failed: %v", err)
+	}
+	data, err := ioutil.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("Hook not run: %v", err)
+	}
+	expected := "post_start dev 127.0.0.2 10022 10022:22 8080:80"
+	if strings.TrimSpace(string(data)) != expected {
+		t.Errorf("Expected %q, got %q", expected, data)
+	}
+
+	err = c.runHook(ctx, hookPreStop, ws, "dev", wkld)
+	if err == nil || !strings.Contains(err.Error(), "unregistering") {
+		t.Errorf("Failure of hook not reported with its output: %v", err)
+	}
+
+	if err := c.runHook(ctx, hookPreCreate, ws, "dev", wkld); err != nil {
+		t.Errorf("Undeclared hook failed: %v", err)
+	}
+}
+
+func TestHookProcessEnv(t *testing.T) {
+	_ = os.Setenv("CCLOUDVM_TEST_SECRET", "root")
+	defer func() { _ = os.Unsetenv("CCLOUDVM_TEST_SECRET") }()
+
+	ws := &workspace{
+		account:     &account{name: "alice"},
+		instanceDir: "/var/lib/ccloudvm/alice/instances/dev",
+		Home:        "/home/alice",
+		User:        "alice",
+	}
+	wkld := &workload{spec: workloadSpec{WorkloadName: "xenial"}}
+
+	env := hookProcessEnv(hookPostStart, ws, "dev", wkld)
+	expected := append([]string{"PATH=" + userHookPath, "HOME=/home/alice", "USER=alice"},
+		hookEnv(hookPostStart, ws, "dev", wkld)...)
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("Unexpected environment %v", env)
+	}
+
+	ws.account = nil
+	env = hookProcessEnv(hookPostStart, ws, "dev", wkld)
+	found := false
+	for _, v := range env {
+		found = found || v == "CCLOUDVM_TEST_SECRET=root"
+	}
+	if !found {
+		t.Errorf("Environment of the daemon not inherited in user mode")
+	}
+}
+
+func TestMergeHooks(t *testing.T) {
+	h := workloadHooks{PostStart: "./register.sh"}
+	h.merge(&workloadHooks{PostStart: "true", PreStop: "./unregister.sh"})
+	if h.PostStart != "./register.sh" || h.PreStop != "./unregister.sh" || h.PreCreate != "" {
+		t.Errorf("Unexpected hooks %+v", h)
+	}
+}
@@ -0,0 +1,344 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build !darwin
+// +build !darwin
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// qemuAccelArgs are the qemu arguments that select the host's hardware
+// virtualization accelerator.
+var qemuAccelArgs = []string{"-enable-kvm"}
+
+// hostAccelAvailable returns true if the daemon can use KVM.
+func hostAccelAvailable() bool {
+	f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	_ = f.Close()
+	return true
+}
+
+// The daemon is started by systemd socket activation by default.
+const defaultSystemd = true
+
+// clockTicks is the number of clock ticks per second in which CPU times are
+// reported by procfs.  It is 100 on all the architectures supported by
+// ccloudvm.
+const clockTicks = 100
+
+// raplDir contains the energy counters of the host's RAPL power zones.
+const raplDir = "/sys/class/powercap"
+
+// accelChecks verifies that the daemon can use KVM and reports whether
+// nested virtualization is enabled.
+func accelChecks() []types.PreflightCheck {
+	kvm := types.PreflightCheck{
+		Name:   "KVM",
+		Status: types.PreflightOK,
+		Detail: "/dev/kvm is accessible",
+	}
+	f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0)
+	switch {
+	case err == nil:
+		_ = f.Close()
+	case os.IsNotExist(err):
+		kvm.Status = types.PreflightFailed
+		kvm.Detail = "/dev/kvm does not exist"
+		kvm.Fix = "Enable virtualization in the firmware settings of the host and load the kvm_intel or kvm_amd module"
+	case os.IsPermission(err):
+		kvm.Status = types.PreflightFailed
+		kvm.Detail = "Permission to access /dev/kvm denied"
+		kvm.Fix = "Add the user to the group that owns /dev/kvm, e.g., with sudo usermod -aG kvm $USER, and log in again"
+	default:
+		kvm.Status = types.PreflightFailed
+		kvm.Detail = fmt.Sprintf("Unable to open /dev/kvm: %v", err)
+	}
+
+	nested := types.PreflightCheck{
+		Name:   "Nested virtualization",
+		Status: types.PreflightOK,
+		Detail: "Enabled",
+	}
+	if !hostSupportsNestedKVM() {
+		nested.Status = types.PreflightWarning
+		nested.Detail = "Disabled, workloads that run VMs in their instances cannot be created"
+		nested.Fix = "Set the nested parameter of the kvm_intel or kvm_amd module, e.g., with options kvm_intel nested=1 in /etc/modprobe.d/kvm.conf"
+	}
+
+	return []types.PreflightCheck{kvm, nested}
+}
+
+// processStat returns the fields of the statistics of the process pid that
+// follow the name of its command.
+func processStat(pid int) ([]string, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read process statistics")
+	}
+
+	// The name of the command, which may contain spaces, is enclosed in
+	// parentheses.
+	stat := string(data)
+	i := strings.LastIndex(stat, ")")
+	if i == -1 {
+		return nil, errors.Errorf("Invalid statistics for process %d", pid)
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 20 {
+		return nil, errors.Errorf("Invalid statistics for process %d", pid)
+	}
+	return fields, nil
+}
+
+// processCPUSeconds returns the CPU time consumed by the process pid.
+func processCPUSeconds(pid int) (float64, error) {
+	fields, err := processStat(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	// utime and stime are the 12th and 13th fields after the command.
+	var ticks uint64
+	for _, f := range fields[11:13] {
+		t, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return 0, errors.Errorf("Invalid statistics for process %d", pid)
+		}
+		ticks += t
+	}
+
+	return float64(ticks) / clockTicks, nil
+}
+
+// processRunSeconds returns the time elapsed since the process pid was
+// started.
+func processRunSeconds(pid int) (float64, error) {
+	fields, err := processStat(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	// starttime, in clock ticks since the host was booted, is the 20th
+	// field after the command.
+	start, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("Invalid statistics for process %d", pid)
+	}
+
+	data, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to read host uptime")
+	}
+	fields = strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, errors.New("Invalid host uptime")
+	}
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, errors.New("Invalid host uptime")
+	}
+
+	return uptime - float64(start)/clockTicks, nil
+}
+
+// processRSSBytes returns the resident memory of the process pid.
+func processRSSBytes(pid int) (int64, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/statm", pid))
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to read process memory statistics")
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0, errors.Errorf("Invalid memory statistics for process %d", pid)
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("Invalid memory statistics for process %d", pid)
+	}
+
+	return pages * int64(os.Getpagesize()), nil
+}
+
+// processOfInstance returns false if the process pid is known not to have
+// been started for the instance whose directory is instanceDir.  The
+// processes started for an instance refer to files of its directory on their
+// command lines.
+func processOfInstance(pid int, instanceDir string) bool {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if os.IsPermission(err) {
+		return true
+	} else if err != nil {
+		return false
+	}
+
+	return strings.Contains(string(data), filepath.Clean(instanceDir)+"/")
+}
+
+// instanceProcesses returns the hypervisor processes, found in procDir,
+// whose command lines refer to a directory of instancesDir.
+func instanceProcesses(procDir, instancesDir string) ([]hypervisorProcess, error) {
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read %s", procDir)
+	}
+
+	prefix := filepath.Clean(instancesDir) + "/"
+	var procs []hypervisorProcess
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(procDir, e.Name(), "cmdline"))
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		args := strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+		hypervisor := hypervisorOfBinary(args[0])
+		if hypervisor == "" {
+			continue
+		}
+		for _, arg := range args[1:] {
+			i := strings.Index(arg, prefix)
+			if i == -1 {
+				continue
+			}
+			name := arg[i+len(prefix):]
+			if j := strings.IndexAny(name, "/,"); j != -1 {
+				name = name[:j]
+			}
+			if name != "" {
+				procs = append(procs, hypervisorProcess{
+					pid:        pid,
+					instance:   name,
+					hypervisor: hypervisor,
+				})
+				break
+			}
+		}
+	}
+
+	return procs, nil
+}
+
+// hostBusySeconds returns the CPU time spent by the host doing work since
+// it was booted, summed over all CPUs.
+func hostBusySeconds() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to read host statistics")
+	}
+
+	lines := strings.SplitN(string(data), "\n", 2)
+	fields := strings.Fields(lines[0])
+	if len(fields) < 9 || fields[0] != "cpu" {
+		return 0, errors.New("Invalid host statistics")
+	}
+
+	// user, nice, system, irq, softirq and steal
+	var ticks uint64
+	for _, i := range []int{1, 2, 3, 6, 7, 8} {
+		t, err := strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			return 0, errors.New("Invalid host statistics")
+		}
+		ticks += t
+	}
+
+	return float64(ticks) / clockTicks, nil
+}
+
+func readCounter(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readEnergy returns the energy counters of the host's top level RAPL power
+// zones, i.e., of its CPU packages.  The counters are usually only readable
+// by root.
+func readEnergy() (map[string]energyCounter, error) {
+	zones, _ := filepath.Glob(filepath.Join(raplDir, "intel-rapl:*"))
+	counters := make(map[string]energyCounter)
+	for _, zone := range zones {
+		name := filepath.Base(zone)
+		if strings.Count(name, ":") != 1 {
+			continue
+		}
+		uj, err := readCounter(filepath.Join(zone, "energy_uj"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to read energy counter of %s", name)
+		}
+		max, err := readCounter(filepath.Join(zone, "max_energy_range_uj"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to read energy range of %s", name)
+		}
+		counters[name] = energyCounter{MicroJoules: uj, Max: max}
+	}
+
+	if len(counters) == 0 {
+		return nil, errors.New("RAPL is not available")
+	}
+
+	return counters, nil
+}
+
+// peerUID returns the uid of the process connected to the other end of conn,
+// a unix domain socket connection.
+func peerUID(conn net.Conn) (int, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return -1, errors.New("Not a unix domain socket connection")
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return -1, errors.Wrap(err, "Unable to access connection")
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return -1, errors.Wrap(err, "Unable to retrieve peer credentials")
+	}
+
+	return int(cred.Uid), nil
+}
@@ -0,0 +1,94 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// qemuAccelArgs are the qemu arguments that select the host's hardware
+// virtualization accelerator.  On macOS this is Hypervisor.framework.
+var qemuAccelArgs = []string{"-accel", "hvf"}
+
+// hostAccelAvailable returns true if Hypervisor.framework is supported by
+// the host.
+func hostAccelAvailable() bool {
+	out, err := exec.Command("sysctl", "-n", "kern.hv_support").Output()
+	return err == nil && strings.TrimSpace(string(out)) == "1"
+}
+
+// accelChecks verifies that Hypervisor.framework is supported by the host.
+func accelChecks() []types.PreflightCheck {
+	hvf := types.PreflightCheck{
+		Name:   "Hypervisor.framework",
+		Status: types.PreflightOK,
+		Detail: "Supported",
+	}
+	if !hostAccelAvailable() {
+		hvf.Status = types.PreflightFailed
+		hvf.Detail = "Hypervisor.framework is not supported by this Mac"
+		hvf.Fix = "Use a Mac whose CPU supports hardware virtualization"
+	}
+	return []types.PreflightCheck{hvf}
+}
+
+// The daemon is started by launchd on macOS, which does not support systemd
+// style socket activation, so the daemon creates its own socket.
+const defaultSystemd = false
+
+// CPU and energy accounting relies on procfs and RAPL, neither of which is
+// available on macOS.
+
+func processCPUSeconds(pid int) (float64, error) {
+	return 0, errors.New("CPU accounting is not supported on macOS")
+}
+
+func processRunSeconds(pid int) (float64, error) {
+	return 0, errors.New("CPU accounting is not supported on macOS")
+}
+
+func processRSSBytes(pid int) (int64, error) {
+	return 0, errors.New("Memory accounting is not supported on macOS")
+}
+
+func hostBusySeconds() (float64, error) {
+	return 0, errors.New("CPU accounting is not supported on macOS")
+}
+
+func readEnergy() (map[string]energyCounter, error) {
+	return nil, errors.New("RAPL is not available on macOS")
+}
+
+// The processes started for instances cannot be told apart from processes
+// that have reused their pids on macOS.
+
+func processOfInstance(pid int, instanceDir string) bool {
+	return true
+}
+
+func instanceProcesses(procDir, instancesDir string) ([]hypervisorProcess, error) {
+	return nil, errors.New("Process discovery is not supported on macOS")
+}
+
+func peerUID(conn net.Conn) (int, error) {
+	return -1, errors.New("System mode is not supported on macOS")
+}
@@ -0,0 +1,190 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The daemon writes a hosts file and an ssh_config file, in the ccloudvm
+// directory, naming each instance in hostsDomain.  Both files are rewritten
+// whenever the set of instances, or their addresses, change.
+
+const (
+	hostsFile     = "hosts"
+	sshConfigFile = "ssh_config"
+	hostsDomain   = "ccloudvm"
+)
+
+type hostEntry struct {
+	name         string
+	hostIP       string
+	sshPort      int
+	user         string
+	keyPath      string
+	forwardAgent bool
+}
+
+func (e *hostEntry) hostname() string {
+	return e.name + "." + hostsDomain
+}
+
+// instanceHostEntries returns the entries of the instances that have an SSH
+// port mapping, sorted by name.
+func instanceHostEntries(ctx context.Context, ccvmDir string) []hostEntry {
+	files, err := ioutil.ReadDir(path.Join(ccvmDir, "instances"))
+	if err != nil {
+		return nil
+	}
+
+	var entries []hostEntry
+	for _, fi := range files {
+		if !fi.IsDir() {
+			continue
+		}
+		ws, err := prepareEnv(ctx, fi.Name())
+		if err != nil {
+			continue
+		}
+		wkld, err := restoreWorkload(ws)
+		if err != nil {
+			continue
+		}
+		sshHost, sshPort, err := sshEndpoint(&wkld.spec.VM)
+		if err != nil || len(sshHost) == 0 {
+			continue
+		}
+		entries = append(entries, hostEntry{
+			name:         fi.Name(),
+			hostIP:       sshHost.String(),
+			sshPort:      sshPort,
+			user:         ws.User,
+			keyPath:      ws.keyPath,
+			forwardAgent: wkld.spec.SSHAgent,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	return entries
+}
+
+func hostsData(entries []hostEntry) []byte {
+	var b bytes.Buffer
+	b.WriteString("# Generated by ccloudvm, do not edit\n")
+	for i := range entries {
+		fmt.Fprintf(&b, "%s\t%s\n", entries[i].hostIP, entries[i].hostname())
+	}
+	return b.Bytes()
+}
+
+// sshConfigArg quotes arg, if needed, to be used as the argument of an
+// ssh_config keyword.
+func sshConfigArg(arg string) string {
+	if strings.ContainsAny(arg, " \t") {
+		return `"` + arg + `"`
+	}
+	return arg
+}
+
+func sshConfigData(entries []hostEntry) []byte {
+	var b bytes.Buffer
+	b.WriteString("# Generated by ccloudvm, do not edit\n")
+	for i := range entries {
+		e := &entries[i]
+		fmt.Fprintf(&b, "\nHost %s %s\n", e.name, e.hostname())
+		fmt.Fprintf(&b, "\tHostName %s\n", e.hostIP)
+		fmt.Fprintf(&b, "\tPort %d\n", e.sshPort)
+		fmt.Fprintf(&b, "\tUser %s\n", e.user)
+		fmt.Fprintf(&b, "\tIdentityFile %s\n", sshConfigArg(e.keyPath))
+		b.WriteString("\tIdentitiesOnly yes\n")
+		b.WriteString("\tStrictHostKeyChecking no\n")
+		b.WriteString("\tUserKnownHostsFile /dev/null\n")
+		b.WriteString("\tLogLevel ERROR\n")
+		if e.forwardAgent {
+			b.WriteString("\tForwardAgent yes\n")
+		}
+	}
+	return b.Bytes()
+}
+
+// writeFileAtomic replaces the contents of the file at p with data, so that
+// readers never see a partially written file.
+func writeFileAtomic(p string, data []byte) error {
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrapf(err, "Unable to write %s", p)
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrapf(err, "Unable to write %s", p)
+	}
+	return nil
+}
+
+type hostsPublisher struct {
+	ccvmDir string
+}
+
+func newHostsPublisher(ccvmDir string) *hostsPublisher {
+	return &hostsPublisher{ccvmDir: ccvmDir}
+}
+
+func (h *hostsPublisher) publish(ctx context.Context) error {
+	entries := instanceHostEntries(ctx, h.ccvmDir)
+	if err := writeFileAtomic(path.Join(h.ccvmDir, hostsFile), hostsData(entries)); err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(h.ccvmDir, sshConfigFile), sshConfigData(entries))
+}
+
+// run publishes the names of the instances and publishes them again each
+// time an instance is created, started, stopped, renamed or deleted, until ctx is cancelled
+// or eventCh is closed.
+func (h *hostsPublisher) run(ctx context.Context, eventCh <-chan interface{}) {
+	if err := h.publish(ctx); err != nil {
+		logWarningf("%v", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v, ok := <-eventCh:
+			if !ok {
+				return
+			}
+			e, _ := v.(types.InstanceEvent)
+			switch e.Type {
+			case types.EventCreated, types.EventStarted, types.EventStopped,
+				types.EventDeleted, types.EventRenamed:
+				if err := h.publish(ctx); err != nil {
+					logWarningf("%v", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestHostsFiles(t *testing.T) {
+	entries := []hostEntry{
+		{
+			name:    "dev1",
+			hostIP:  "127.0.0.2",
+			sshPort: 10022,
+			user:    "user",
+			keyPath: "/home/user/.ccloudvm/id_rsa",
+		},
+		{
+			name:         "dev2",
+			hostIP:       "127.0.0.3",
+			sshPort:      10022,
+			user:         "user",
+			keyPath:      "/home/user/.ccloudvm/instances/dev2/id_rsa",
+			forwardAgent: true,
+		},
+	}
+
+	hosts := string(hostsData(entries))
+	for _, line := range []string{"127.0.0.2\tdev1.ccloudvm\n", "127.0.0.3\tdev2.ccloudvm\n"} {
+		if !strings.Contains(hosts, line) {
+			t.Errorf("%q not found in hosts file\n%s", line, hosts)
+		}
+	}
+
+	sshConfig := string(sshConfigData(entries))
+	for _, line := range []string{
+		"Host dev1 dev1.ccloudvm\n\tHostName 127.0.0.2\n\tPort 10022\n\tUser user\n",
+		"IdentityFile /home/user/.ccloudvm/instances/dev2/id_rsa\n",
+	} {
+		if !strings.Contains(sshConfig, line) {
+			t.Errorf("%q not found in ssh config\n%s", line, sshConfig)
+		}
+	}
+	if strings.Count(sshConfig, "ForwardAgent yes") != 1 {
+		t.Errorf("Expected agent forwarding for dev2 only\n%s", sshConfig)
+	}
+
+	entries[0].keyPath = "/Users/user/Library/Application Support/ccloudvm/id_rsa"
+	line := "IdentityFile \"/Users/user/Library/Application Support/ccloudvm/id_rsa\"\n"
+	if data := string(sshConfigData(entries)); !strings.Contains(data, line) {
+		t.Errorf("%q not found in ssh config\n%s", line, data)
+	}
+
+	dir, err := ioutil.TempDir("", "ccloudvm-hosts")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	p := path.Join(dir, hostsFile)
+	for _, data := range [][]byte{[]byte("old"), hostsData(entries)} {
+		if err := writeFileAtomic(p, data); err != nil {
+			t.Fatalf("Unable to write hosts file: %v", err)
+		}
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil || string(data) != hosts {
+		t.Errorf("Unexpected hosts file contents %q: %v", string(data), err)
+	}
+	if _, err := os.Stat(p + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Temporary file not removed")
+	}
+}
@@ -0,0 +1,155 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Names of the supported hypervisors.  An empty VMSpec.Hypervisor field
+// selects the default hypervisor, qemu.
+const (
+	hypervisorQemu            = "qemu"
+	hypervisorFirecracker     = "firecracker"
+	hypervisorCloudHypervisor = "cloud-hypervisor"
+)
+
+// vmWatcher is returned by hypervisor.watch.  disconnectedCh is closed when
+// the VM exits.  quit can be used to forcefully terminate the VM while it is
+// being watched and close must be called to release the watcher's resources.
+type vmWatcher struct {
+	disconnectedCh <-chan struct{}
+	quit           func(context.Context) error
+	close          func()
+}
+
+// hypervisor abstracts the launching and management of the process that
+// executes an instance.
+type hypervisor interface {
+	// createRootfs creates the instance's root disk from the downloaded
+	// base image.
+	createRootfs(ctx context.Context, backingImage, instanceDir string, disk int) error
+
+	// listenAddress returns the host address, reachable from the guest
+	// of an instance connected to the network n, on which ccloudvm
+	// should serve files during the instance's creation.
+	listenAddress(n *vmNetwork) string
+
+	// growRootfs grows the root disk of a stopped instance to disk GiB.
+	growRootfs(ctx context.Context, instanceDir string, disk int) error
+
+	boot(ctx context.Context, ws *workspace, name string, in *types.VMSpec) error
+	stop(ctx context.Context, instanceDir string) error
+	quit(ctx context.Context, instanceDir string) error
+	watch(ctx context.Context, instanceDir string) (*vmWatcher, error)
+
+	// monitor blocks until the VM of a running instance exits, or ctx
+	// is cancelled, and describes how the VM exited.  It fails if the VM
+	// is not running.  Unlike watch, it may be used while other
+	// commands are being issued to the VM.
+	monitor(ctx context.Context, instanceDir string) (*vmExit, error)
+
+	// running returns true if the instance's VM is running.
+	running(ctx context.Context, instanceDir string) bool
+
+	// resize applies the resources described by next to a running
+	// instance whose current resources are described by cur.  It returns
+	// false if some of the changes can only be applied when the instance
+	// is next booted.
+	resize(ctx context.Context, instanceDir string, cur, next *types.VMSpec) (bool, error)
+
+	// forward adds, if add is true, or removes a port mapping from a
+	// running instance, described by in, connected to the network n.
+	forward(ctx context.Context, instanceDir string, in *types.VMSpec, n *vmNetwork, p types.PortMapping,
+		add bool) error
+
+	// addMount and removeMount share, and stop sharing, a host directory
+	// with a running instance.
+	addMount(ctx context.Context, instanceDir string, m *types.Mount) error
+	removeMount(ctx context.Context, instanceDir string, m *types.Mount) error
+
+	// attachDisk and detachDisk hot-plug, and unplug, a data disk of a
+	// running instance.
+	attachDisk(ctx context.Context, instanceDir string, d *types.Disk) error
+	detachDisk(ctx context.Context, instanceDir string, d *types.Disk) error
+}
+
+// getHypervisor returns the hypervisor selected by spec, using the daemon
+// settings in cfg, which may be nil, where spec does not override them.
+// The users of a system mode daemon cannot choose the qemu binary.
+func getHypervisor(ws *workspace, cfg *daemonConfig, spec *workloadSpec) (hypervisor, error) {
+	in := &spec.VM
+	switch in.Hypervisor {
+	case "", hypervisorQemu:
+		qemuCfg := spec.Qemu
+		if ws != nil && ws.account != nil {
+			qemuCfg = qemuConfig{}
+		}
+		if cfg != nil {
+			qemuCfg.merge(&cfg.Qemu)
+		}
+		return qemuHypervisor{cfg: qemuCfg}, nil
+	case hypervisorFirecracker:
+		return firecrackerHypervisor{}, nil
+	case hypervisorCloudHypervisor:
+		return cloudHypervisor{}, nil
+	}
+
+	return nil, errors.Errorf("Unsupported hypervisor %s", in.Hypervisor)
+}
+
+// checkQemuOnlyFeatures returns an error if the VM described by in, which is
+// to be booted by the hypervisor name, e.g., firecracker, uses a feature that
+// is only supported by qemu.
+func checkQemuOnlyFeatures(ws *workspace, in *types.VMSpec, name string) error {
+	switch {
+	case len(in.ReversePorts) > 0:
+		return errors.Errorf("Reverse port forwards are not supported by %s", name)
+	case clockSet(in):
+		return errors.Errorf("Clock offsets and frozen times are not supported by %s", name)
+	case !ws.network.isDefault():
+		return errors.Errorf("Only the default network is supported by %s", name)
+	case len(in.VGPUs) > 0:
+		return errors.Errorf("vGPUs are not supported by %s", name)
+	case len(in.GPUs) > 0:
+		return errors.Errorf("GPU passthrough is not supported by %s", name)
+	case in.TPM:
+		return errors.Errorf("TPMs are not supported by %s", name)
+	case in.DisplayPort() != 0:
+		return errors.Errorf("Graphical consoles are not supported by %s", name)
+	case hasMedia(in):
+		return errors.Errorf("Audio and USB devices are not supported by %s", name)
+	case in.Vsock:
+		return errors.Errorf("vsock devices are not supported by %s", name)
+	case len(in.Caches) > 0:
+		return errors.Errorf("Shared caches are not supported by %s", name)
+	case in.Encrypt:
+		return errors.Errorf("Encrypted disks are not supported by %s", name)
+	case guestArch(in) != types.ArchX86_64:
+		return errors.Errorf("%s guests are not supported by %s", in.Arch, name)
+	case firmwareType(in) != types.FirmwareBIOS:
+		return errors.Errorf("UEFI firmware is not supported by %s", name)
+	case in.Kernel != "":
+		return errors.Errorf("Direct kernel boot is not supported by %s.  Use the kernel field of the workload", name)
+	case in.CPUModel != "" || in.HasTopology() || in.NestedVirt:
+		return errors.Errorf("CPU models, topologies and nested virtualization are not supported by %s", name)
+	}
+	return nil
+}
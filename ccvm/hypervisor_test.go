@@ -0,0 +1,240 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestGetHypervisor(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected hypervisor
+		fail     bool
+	}{
+		{"", qemuHypervisor{}, false},
+		{hypervisorQemu, qemuHypervisor{}, false},
+		{hypervisorFirecracker, firecrackerHypervisor{}, false},
+		{hypervisorCloudHypervisor, cloudHypervisor{}, false},
+		{"bochs", nil, true},
+	}
+
+	for _, test := range tests {
+		spec := &workloadSpec{VM: types.VMSpec{Hypervisor: test.name}}
+		hv, err := getHypervisor(nil, nil, spec)
+		if test.fail {
+			if err == nil {
+				t.Errorf("Expected getHypervisor(%s) to fail", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("getHypervisor(%s) failed: %v", test.name, err)
+			continue
+		}
+		if reflect.TypeOf(hv) != reflect.TypeOf(test.expected) {
+			t.Errorf("Unexpected hypervisor %T for %s", hv, test.name)
+		}
+	}
+}
+
+func TestFirecrackerTapName(t *testing.T) {
+	name := firecrackerTapName("worried-guinevere")
+	if len(name) > 15 {
+		t.Errorf("Tap device name %s is too long", name)
+	}
+	if name != firecrackerTapName("worried-guinevere") {
+		t.Errorf("Tap device names are not stable")
+	}
+}
+
+func TestFirecrackerConfig(t *testing.T) {
+	in := &types.VMSpec{
+		Hypervisor: hypervisorFirecracker,
+		MemMiB:     1024,
+		CPUs:       2,
+		MACAddress: "52:54:00:12:34:56",
+	}
+	cfg := newFirecrackerConfig("/tmp/fc", "worried-guinevere", in, defaultNetwork(), "ds=nocloud-net")
+
+	bootArgs := cfg.BootSource.BootArgs
+	for _, want := range []string{"root=/dev/vda1",
+		"ip=10.0.2.15::10.0.2.2:255.255.255.0::eth0:off"} {
+		if !strings.Contains(bootArgs, want) {
+			t.Errorf("%s not found in %s", want, bootArgs)
+		}
+	}
+	if !strings.HasSuffix(bootArgs, " ds=nocloud-net") {
+		t.Errorf("Seed not appended to %s", bootArgs)
+	}
+	if cfg.BootSource.KernelImagePath != "/tmp/fc/kernel" {
+		t.Errorf("Unexpected kernel %s", cfg.BootSource.KernelImagePath)
+	}
+	if len(cfg.NetworkInterfaces) != 1 ||
+		cfg.NetworkInterfaces[0].HostDevName != firecrackerTapName("worried-guinevere") {
+		t.Errorf("Unexpected network interfaces %+v", cfg.NetworkInterfaces)
+	}
+
+	host, port, err := sshEndpoint(in)
+	if err != nil {
+		t.Fatalf("Unable to determine SSH endpoint: %v", err)
+	}
+	if host.String() != "10.0.2.15" || port != 22 {
+		t.Errorf("Unexpected SSH endpoint %s:%d", host, port)
+	}
+
+	// Instances are reached on the guest address of their tap subnet.
+	in.TapSubnet = "10.200.0.32/27"
+	in.GuestIP = net.ParseIP("10.200.0.47")
+	n, err := defaultNetwork().withTap(in)
+	if err != nil {
+		t.Fatalf("Unable to apply tap subnet: %v", err)
+	}
+	cfg = newFirecrackerConfig("/tmp/fc", "worried-guinevere", in, n, "")
+	want := "ip=10.200.0.47::10.200.0.34:255.255.255.224::eth0:off"
+	if !strings.Contains(cfg.BootSource.BootArgs, want) {
+		t.Errorf("%s not found in %s", want, cfg.BootSource.BootArgs)
+	}
+	host, _, err = sshEndpoint(in)
+	if err != nil || !host.Equal(in.GuestIP) {
+		t.Errorf("Unexpected SSH endpoint %s: %v", host, err)
+	}
+}
+
+func TestSSHEndpoint(t *testing.T) {
+	in := &types.VMSpec{
+		HostIP:       net.IPv4(127, 0, 0, 3),
+		PortMappings: []types.PortMapping{{Host: 10022, Guest: 22}},
+	}
+	host, port, err := sshEndpoint(in)
+	if err != nil {
+		t.Fatalf("Unable to determine SSH endpoint: %v", err)
+	}
+	if !host.Equal(in.HostIP) || port != 10022 {
+		t.Errorf("Unexpected SSH endpoint %s:%d", host, port)
+	}
+
+	in.PortMappings = nil
+	if _, _, err := sshEndpoint(in); err == nil {
+		t.Errorf("Expected sshEndpoint to fail without an SSH port mapping")
+	}
+}
+
+func TestCloudHVArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudhv-tests")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	spec := &types.VMSpec{
+		CPUs:   2,
+		MemMiB: 1024,
+		Mounts: []types.Mount{{Tag: "hostgo", Path: "/home/user/go"}},
+		Disks:  []types.Disk{{Name: "data", SizeGiB: 50}},
+	}
+
+	_, err = cloudHVArgs(dir, "test", spec, defaultNetwork(), "")
+	if err == nil {
+		t.Errorf("Expected cloudHVArgs to fail without a kernel or bios")
+	}
+
+	err = ioutil.WriteFile(path.Join(dir, "kernel"), nil, 0600)
+	if err != nil {
+		t.Fatalf("Unable to create kernel: %v", err)
+	}
+
+	args, err := cloudHVArgs(dir, "test", spec, defaultNetwork(), "")
+	if err != nil {
+		t.Fatalf("cloudHVArgs failed: %v", err)
+	}
+
+	cmdline := strings.Join(args, " ")
+	for _, expected := range []string{
+		"--kernel " + path.Join(dir, "kernel"),
+		"--cpus boot=2",
+		"--memory size=1024M,shared=on",
+		"path=" + dataDiskPath(dir, "data") + ",id=disk-data,serial=data",
+		"ip=10.0.2.15::10.0.2.2:255.255.255.0::eth0:off",
+		"--net tap=" + firecrackerTapName("test") + ",ip=10.0.2.2,mask=255.255.255.0",
+	} {
+		if !strings.Contains(cmdline, expected) {
+			t.Errorf("%s not found in %s", expected, cmdline)
+		}
+	}
+
+	// The guest is given the address of its tap subnet, on which it is
+	// reached.
+	spec.Hypervisor = hypervisorCloudHypervisor
+	spec.TapSubnet = "10.200.0.32/27"
+	spec.GuestIP = net.ParseIP("10.200.0.47")
+	n, err := defaultNetwork().withTap(spec)
+	if err != nil {
+		t.Fatalf("Unable to apply tap subnet: %v", err)
+	}
+	args, err = cloudHVArgs(dir, "test", spec, n, "")
+	if err != nil {
+		t.Fatalf("cloudHVArgs failed: %v", err)
+	}
+	cmdline = strings.Join(args, " ")
+	for _, expected := range []string{
+		"ip=10.200.0.47::10.200.0.34:255.255.255.224::eth0:off",
+		",ip=10.200.0.34,mask=255.255.255.224",
+	} {
+		if !strings.Contains(cmdline, expected) {
+			t.Errorf("%s not found in %s", expected, cmdline)
+		}
+	}
+	host, port, err := sshEndpoint(spec)
+	if err != nil || !host.Equal(spec.GuestIP) || port != 22 {
+		t.Errorf("Unexpected SSH endpoint %s:%d: %v", host, port, err)
+	}
+}
+
+func TestCheckQemuOnlyFeatures(t *testing.T) {
+	ws := &workspace{network: defaultNetwork()}
+	if err := checkQemuOnlyFeatures(ws, &types.VMSpec{}, hypervisorCloudHypervisor); err != nil {
+		t.Errorf("VM without qemu features rejected: %v", err)
+	}
+
+	for _, in := range []types.VMSpec{
+		{TPM: true},
+		{Vsock: true},
+		{Encrypt: true},
+		{Kernel: "/boot/vmlinuz"},
+		{NestedVirt: true},
+		{Arch: types.ArchAarch64},
+	} {
+		err := checkQemuOnlyFeatures(ws, &in, hypervisorFirecracker)
+		if err == nil || !strings.Contains(err.Error(), hypervisorFirecracker) {
+			t.Errorf("Unexpected error for %+v: %v", in, err)
+		}
+	}
+
+	ws.network = &vmNetwork{spec: types.NetworkSpec{Name: "lab"}}
+	if err := checkQemuOnlyFeatures(ws, &types.VMSpec{}, hypervisorFirecracker); err == nil {
+		t.Errorf("Named network accepted")
+	}
+}
@@ -0,0 +1,188 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Local base images are copied to the images directory, under a name
+// derived from their path, size and modification time, so that rebuilding
+// an image does not corrupt the instances created from it.
+
+const localImagesDir = "images"
+
+// localImagePath returns the path of the local file identified by URI, and
+// true, if URI is a file URL or an absolute path.
+func localImagePath(URI string) (string, bool, error) {
+	u, err := url.Parse(URI)
+	if err != nil {
+		return "", false, errors.Wrapf(err, "Invalid URL %s", URI)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return u.Path, true, nil
+	case "":
+		if !path.IsAbs(URI) {
+			return "", false, errors.Errorf("Path of local image %s is not absolute", URI)
+		}
+		return URI, true, nil
+	}
+
+	return "", false, nil
+}
+
+// copyLocalImage copies the local image src to the images directory of
+// ccvmDir, unless it has already been copied, and returns the path of the
+// copy.  Images already stored in the images directory, such as imported
+// images, are not copied.
+func copyLocalImage(ccvmDir, src string) (string, error) {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return "", errors.Wrapf(err, "Unable to access image %s", src)
+	}
+
+	imagesDir := path.Join(ccvmDir, localImagesDir)
+	if path.Dir(path.Clean(src)) == imagesDir {
+		return src, nil
+	}
+
+	id := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d", src, fi.Size(), fi.ModTime().UnixNano())))
+	dest := path.Join(imagesDir, fmt.Sprintf("%x-%s", id[:8], path.Base(src)))
+	if _, err := os.Stat(dest); err == nil {
+		return dest, nil
+	}
+
+	err = os.MkdirAll(imagesDir, 0755)
+	if err != nil {
+		return "", errors.Wrapf(err, "Unable to create directory %s", imagesDir)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", errors.Wrapf(err, "Unable to open image %s", src)
+	}
+	defer func() { _ = in.Close() }()
+
+	// Concurrent copies of the same image are written to different
+	// temporary files, the last one renamed replacing the others.
+
+	out, err := ioutil.TempFile(imagesDir, path.Base(src)+".part")
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to create image copy")
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dest)
+	}
+	if err != nil {
+		_ = os.Remove(out.Name())
+		return "", errors.Wrapf(err, "Unable to copy image %s", src)
+	}
+
+	return dest, nil
+}
+
+// fileSHA256 returns the SHA-256 digest of the file at p, in hexadecimal.
+func fileSHA256(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", errors.Wrapf(err, "Unable to open %s", p)
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "Unable to read %s", p)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyChecksum checks that the SHA-256 digest of the file at p, in
+// hexadecimal, is expected.  No check is made if expected is empty.
+func verifyChecksum(p, expected string) error {
+	if expected == "" {
+		return nil
+	}
+
+	sum, err := fileSHA256(p)
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(sum, strings.TrimSpace(expected)) {
+		return errors.Errorf("SHA-256 checksum of %s is %s, expected %s", p, sum, expected)
+	}
+
+	return nil
+}
+
+// verifyImage checks the checksum of the image stored at p.  Images owned
+// by ccloudvm, i.e., downloaded images and copies of local images, that
+// do not match their checksums are deleted so that they are fetched again
+// when next needed.
+func verifyImage(p, expected string, owned bool) error {
+	err := verifyChecksum(p, expected)
+	if err != nil && owned {
+		_ = os.Remove(p)
+	}
+	return err
+}
+
+// flattenImage copies the data of the images backing the qcow2 image into
+// image itself, so that it no longer depends on them.  It returns false if
+// image has no backing image.
+func flattenImage(ctx context.Context, image string) (bool, error) {
+	chain, err := imageChain(ctx, image)
+	if err != nil {
+		return false, err
+	}
+	if len(chain) < 2 {
+		return false, nil
+	}
+
+	tmpImage := image + ".flat"
+	out, err := exec.CommandContext(ctx, "qemu-img", "convert", "-O", "qcow2",
+		image, tmpImage).CombinedOutput()
+	if err != nil {
+		_ = os.Remove(tmpImage)
+		return false, errors.Wrapf(err, "Unable to flatten %s: %s", image, string(out))
+	}
+
+	if err := os.Rename(tmpImage, image); err != nil {
+		_ = os.Remove(tmpImage)
+		return false, errors.Wrapf(err, "Unable to replace %s", image)
+	}
+
+	return true, nil
+}
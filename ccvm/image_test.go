@@ -0,0 +1,104 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// SHA-256 checksum of "image"
+const testImageSHA256 = "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d"
+
+func TestLocalImagePath(t *testing.T) {
+	tests := []struct {
+		URI   string
+		path  string
+		local bool
+	}{
+		{"/images/custom.qcow2", "/images/custom.qcow2", true},
+		{"file:///images/custom.qcow2", "/images/custom.qcow2", true},
+		{"https://example.com/custom.qcow2", "", false},
+	}
+
+	for _, test := range tests {
+		p, local, err := localImagePath(test.URI)
+		if err != nil || p != test.path || local != test.local {
+			t.Errorf("localImagePath(%s) returned %s %v %v", test.URI, p, local, err)
+		}
+	}
+
+	if _, _, err := localImagePath("images/custom.qcow2"); err == nil {
+		t.Errorf("Relative path accepted")
+	}
+}
+
+func TestCopyLocalImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-tests")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	src := path.Join(dir, "custom.qcow2")
+	if err := ioutil.WriteFile(src, []byte("image"), 0600); err != nil {
+		t.Fatalf("Unable to write image: %v", err)
+	}
+
+	copy1, err := copyLocalImage(dir, src)
+	if err != nil {
+		t.Fatalf("Unable to copy image: %v", err)
+	}
+	if err := verifyChecksum(copy1, testImageSHA256); err != nil {
+		t.Errorf("Checksum of copy not verified: %v", err)
+	}
+	if err := verifyChecksum(copy1, "0123"); err == nil {
+		t.Errorf("Checksum mismatch not detected")
+	}
+
+	copy2, err := copyLocalImage(dir, src)
+	if err != nil || copy2 != copy1 {
+		t.Errorf("Unmodified image copied again to %s: %v", copy2, err)
+	}
+
+	// Modifying the image must not affect the existing copy.
+
+	if err := ioutil.WriteFile(src, []byte("image 2"), 0600); err != nil {
+		t.Fatalf("Unable to write image: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(src, later, later); err != nil {
+		t.Fatalf("Unable to change image times: %v", err)
+	}
+	copy3, err := copyLocalImage(dir, src)
+	if err != nil || copy3 == copy1 {
+		t.Errorf("Modified image not copied: %v", err)
+	}
+	if err := verifyChecksum(copy1, testImageSHA256); err != nil {
+		t.Errorf("Existing copy modified: %v", err)
+	}
+
+	if err := verifyImage(copy3, testImageSHA256, true); err == nil {
+		t.Errorf("Checksum mismatch not detected")
+	}
+	if _, err := os.Stat(copy3); !os.IsNotExist(err) {
+		t.Errorf("Copy with bad checksum not deleted")
+	}
+}
@@ -38,13 +38,31 @@ const (
 )
 
 type workloadSpec struct {
-	BaseImageURL  string       `yaml:"base_image_url"`
-	BaseImageName string       `yaml:"base_image_name"`
-	WorkloadName  string       `yaml:"workload"`
-	NeedsNestedVM bool         `yaml:"needs_nested_vm"`
-	BIOS          string       `yaml:"bios"`
-	VM            types.VMSpec `yaml:"vm"`
-	Inherits      string       `yaml:"inherits"`
+	BaseImageURL    string            `yaml:"base_image_url"`
+	BaseImageName   string            `yaml:"base_image_name"`
+	BaseImageSHA256 string            `yaml:"base_image_sha256"`
+	Distro          string            `yaml:"distro"`
+	WorkloadName    string            `yaml:"workload"`
+	NeedsNestedVM   bool              `yaml:"needs_nested_vm"`
+	BIOS            string            `yaml:"bios"`
+	BIOSSHA256      string            `yaml:"bios_sha256"`
+	Kernel          string            `yaml:"kernel"`
+	KernelSHA256    string            `yaml:"kernel_sha256"`
+	VirtioDrivers   string            `yaml:"virtio_drivers,omitempty"`
+	VirtioSHA256    string            `yaml:"virtio_drivers_sha256,omitempty"`
+	VM              types.VMSpec      `yaml:"vm"`
+	Inherits        inheritance       `yaml:"inherits"`
+	SSHCA           bool              `yaml:"ssh_ca"`
+	SSHAgent        bool              `yaml:"ssh_agent"`
+	Qemu            qemuConfig        `yaml:"qemu"`
+	Group           string            `yaml:"group,omitempty"`
+	Role            string            `yaml:"role,omitempty"`
+	Params          []workloadParam   `yaml:"params,omitempty"`
+	Ready           []readyCheck      `yaml:"ready,omitempty"`
+	ReadyTimeout    string            `yaml:"ready_timeout,omitempty"`
+	Hooks           workloadHooks     `yaml:"hooks,omitempty"`
+	Labels          map[string]string `yaml:"labels,omitempty"`
+	Kubeconfig      kubeconfigSpec    `yaml:"kubeconfig,omitempty"`
 }
 
 func defaultVMSpec() types.VMSpec {
@@ -62,6 +80,7 @@ func defaultWorkload() *workload {
 		spec: workloadSpec{
 			BaseImageName: guestImageFriendlyName,
 			BaseImageURL:  guestDownloadURL,
+			Distro:        defaultDistro,
 			VM:            defaultVMSpec(),
 		},
 	}
@@ -74,7 +93,7 @@ func unmarshal(in *types.VMSpec, data []byte) error {
 	}
 
 	for i := range in.Mounts {
-		if err := types.CheckDirectory(in.Mounts[i].Path); err != nil {
+		if err := in.Mounts[i].Check(); err != nil {
 			return fmt.Errorf("Bad mount %s specified: %v",
 				in.Mounts[i].Path, err)
 		}
@@ -0,0 +1,94 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// defaultKernelCmdline is the command line of kernels booted directly when
+// none is specified.  It mounts the first partition of the instance's disk,
+// where the root filesystems of cloud images live, and sends the kernel's
+// output to the serial console, so that it appears in the console log.
+const defaultKernelCmdline = "root=/dev/vda1 rw console=ttyS0"
+
+// checkDirectKernel verifies that the kernel, initrd and append fields of in
+// are consistent.
+func checkDirectKernel(in *types.VMSpec) error {
+	if in.Kernel == "" {
+		if in.Initrd != "" || in.Append != "" {
+			return errors.New("An initrd or a kernel command line requires a kernel")
+		}
+		return nil
+	}
+
+	for _, p := range []string{in.Kernel, in.Initrd} {
+		if p != "" && !filepath.IsAbs(p) {
+			return errors.Errorf("The path of the kernel or initrd, %s, must be absolute", p)
+		}
+	}
+	return nil
+}
+
+// checkKernelFiles verifies that the kernel and the initrd of in, if any, may
+// be read on behalf of the user of ws.  The VMs of a system mode daemon are
+// run by root, which would otherwise boot any file of the host.
+func checkKernelFiles(ws *workspace, in *types.VMSpec) error {
+	for _, p := range []string{in.Kernel, in.Initrd} {
+		if p == "" {
+			continue
+		}
+		if err := ws.checkLocalFile(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// directKernelArgs returns the qemu arguments that boot the kernel of in, if
+// any.  The NoCloud seed, if any, is appended to its command line.  The
+// kernel and the initrd must be accessible when the VM is booted.
+func directKernelArgs(ws *workspace, in *types.VMSpec, seed string) ([]string, error) {
+	if in.Kernel == "" {
+		return nil, nil
+	}
+
+	if err := checkKernelFiles(ws, in); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(in.Kernel); err != nil {
+		return nil, errors.Wrap(err, "Unable to access kernel")
+	}
+	args := []string{"-kernel", in.Kernel}
+
+	if in.Initrd != "" {
+		if _, err := os.Stat(in.Initrd); err != nil {
+			return nil, errors.Wrap(err, "Unable to access initrd")
+		}
+		args = append(args, "-initrd", in.Initrd)
+	}
+
+	cmdline := in.Append
+	if cmdline == "" {
+		cmdline = defaultKernelCmdline
+	}
+	return append(args, "-append", appendSeed(cmdline, seed)), nil
+}
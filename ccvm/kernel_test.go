@@ -0,0 +1,182 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestDirectKernel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	kernel := path.Join(dir, "bzImage")
+	initrd := path.Join(dir, "initrd.img")
+
+	invalid := []types.VMSpec{
+		{Initrd: initrd},
+		{Append: "console=ttyS0"},
+		{Kernel: "arch/x86/boot/bzImage"},
+		{Kernel: kernel, Initrd: "initrd.img"},
+	}
+	for i := range invalid {
+		if err := checkDirectKernel(&invalid[i]); err == nil {
+			t.Errorf("Expected error for %+v", invalid[i])
+		}
+	}
+
+	in := types.VMSpec{Kernel: kernel, Initrd: initrd}
+	if err := checkDirectKernel(&in); err != nil {
+		t.Errorf("Valid kernel rejected: %v", err)
+	}
+	if args, err := directKernelArgs(&workspace{}, &types.VMSpec{}, ""); err != nil || len(args) != 0 {
+		t.Errorf("Unexpected arguments without kernel %v: %v", args, err)
+	}
+	if _, err := directKernelArgs(&workspace{}, &in, ""); err == nil {
+		t.Errorf("Expected error for missing kernel")
+	}
+
+	for _, f := range []string{kernel, initrd} {
+		if err := ioutil.WriteFile(f, nil, 0644); err != nil {
+			t.Fatalf("Unable to write %s: %v", f, err)
+		}
+	}
+	args, err := directKernelArgs(&workspace{}, &in, "")
+	if err != nil {
+		t.Fatalf("Unable to boot kernel: %v", err)
+	}
+	expected := []string{"-kernel", kernel, "-initrd", initrd, "-append", defaultKernelCmdline}
+	if strings.Join(args, " ") != strings.Join(expected, " ") {
+		t.Errorf("Unexpected arguments %v", args)
+	}
+
+	in = types.VMSpec{Kernel: kernel, Append: "root=/dev/vda1 nokaslr"}
+	args, err = directKernelArgs(&workspace{}, &in, "")
+	if err != nil || args[len(args)-1] != in.Append {
+		t.Errorf("Command line not used %v: %v", args, err)
+	}
+}
+
+type shutdownHypervisor struct {
+	hypervisor
+	polls      int
+	ignoreACPI bool
+	stopped    bool
+	poweredOff bool
+	quitted    bool
+}
+
+func (h *shutdownHypervisor) stop(ctx context.Context, instanceDir string) error {
+	h.stopped = true
+	return nil
+}
+
+func (h *shutdownHypervisor) quit(ctx context.Context, instanceDir string) error {
+	h.quitted = true
+	return nil
+}
+
+func (h *shutdownHypervisor) running(ctx context.Context, instanceDir string) bool {
+	if h.quitted || h.poweredOff {
+		return false
+	}
+	if h.ignoreACPI {
+		return true
+	}
+	h.polls++
+	return h.polls < 2
+}
+
+func TestShutdownVM(t *testing.T) {
+	ctx := context.Background()
+	h := &shutdownHypervisor{}
+	method, err := shutdownVM(ctx, h, "", time.Minute, false, nil)
+	if err != nil {
+		t.Fatalf("Unable to shut down VM: %v", err)
+	}
+	if !h.stopped || h.quitted || method != types.StopACPI {
+		t.Errorf("VM not shut down cleanly: %s %+v", method, h)
+	}
+
+	h = &shutdownHypervisor{}
+	method, err = shutdownVM(ctx, h, "", 0, true, nil)
+	if err != nil {
+		t.Fatalf("Unable to quit VM: %v", err)
+	}
+	if h.stopped || !h.quitted || method != types.StopQuit {
+		t.Errorf("VM not quit: %s %+v", method, h)
+	}
+
+	h = &shutdownHypervisor{ignoreACPI: true}
+	if _, err = shutdownVM(ctx, h, "", time.Second, false, nil); err == nil {
+		t.Errorf("shutdownVM succeeded for a VM that ignored ACPI")
+	}
+	if h.quitted {
+		t.Errorf("VM quit without force")
+	}
+
+	h = &shutdownHypervisor{ignoreACPI: true}
+	method, err = shutdownVM(ctx, h, "", time.Second, true, nil)
+	if err != nil || !h.quitted || method != types.StopQuit {
+		t.Errorf("VM that ignored ACPI not quit: %s %v", method, err)
+	}
+
+	h = &shutdownHypervisor{ignoreACPI: true}
+	method, err = shutdownVM(ctx, h, "", time.Second, false, func(context.Context) error {
+		h.poweredOff = true
+		return nil
+	})
+	if err != nil || h.quitted || method != types.StopGuest {
+		t.Errorf("VM not powered off by guest: %s %v", method, err)
+	}
+}
+
+func TestApplyCustomSpec(t *testing.T) {
+	cur := types.VMSpec{
+		MemMiB: 2048,
+		CPUs:   2,
+		HostIP: []byte{127, 0, 0, 2},
+	}
+
+	in := cur
+	if err := applyCustomSpec(&in, &types.VMSpec{CPUs: 4}); err != nil {
+		t.Errorf("Unable to apply CPU change: %v", err)
+	} else if in.CPUs != 4 || in.MemMiB != 2048 {
+		t.Errorf("Unexpected spec %+v", in)
+	}
+
+	in = cur
+	if err := applyCustomSpec(&in, &types.VMSpec{Network: "lab"}); err == nil {
+		t.Errorf("Change of network accepted")
+	}
+
+	in = cur
+	if err := applyCustomSpec(&in, &types.VMSpec{RestartPolicy: "sometimes"}); err == nil {
+		t.Errorf("Invalid restart policy accepted")
+	}
+}
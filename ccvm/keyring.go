@@ -0,0 +1,107 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build !darwin
+// +build !darwin
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// Keys are stored in the kernel keyring of the user running the daemon,
+// which is shared by all the processes of the user and cleared when the
+// host is rebooted.
+
+const (
+	keySpecUserKeyring = -4
+	keyctlUnlink       = 9
+	keyctlSearch       = 10
+	keyctlRead         = 11
+)
+
+func keyringPtrs(name string) (uintptr, uintptr, error) {
+	t, err := syscall.BytePtrFromString("user")
+	if err != nil {
+		return 0, 0, err
+	}
+	d, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uintptr(unsafe.Pointer(t)), uintptr(unsafe.Pointer(d)), nil
+}
+
+func searchKeyringKey(name string) (uintptr, error) {
+	t, d, err := keyringPtrs(name)
+	if err != nil {
+		return 0, err
+	}
+	ring := int32(keySpecUserKeyring)
+	id, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlSearch, uintptr(ring), t, d, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return id, nil
+}
+
+func addKeyringKey(name string, key []byte) error {
+	t, d, err := keyringPtrs(name)
+	if err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return errors.New("Empty key")
+	}
+	ring := int32(keySpecUserKeyring)
+	_, _, errno := syscall.Syscall6(syscall.SYS_ADD_KEY, t, d, uintptr(unsafe.Pointer(&key[0])),
+		uintptr(len(key)), uintptr(ring), 0)
+	if errno != 0 {
+		return errors.Wrap(errno, "add_key failed")
+	}
+	return nil
+}
+
+func readKeyringKey(name string) ([]byte, error) {
+	id, err := searchKeyringKey(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to find key %s", name)
+	}
+
+	buf := make([]byte, 4096)
+	n, _, errno := syscall.Syscall6(syscall.SYS_KEYCTL, keyctlRead, id,
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0, 0)
+	if errno != 0 {
+		return nil, errors.Wrapf(errno, "Unable to read key %s", name)
+	}
+	if int(n) > len(buf) {
+		return nil, errors.Errorf("Key %s is too large", name)
+	}
+	return buf[:n], nil
+}
+
+func removeKeyringKey(name string) {
+	id, err := searchKeyringKey(name)
+	if err != nil {
+		return
+	}
+	ring := int32(keySpecUserKeyring)
+	_, _, _ = syscall.Syscall(syscall.SYS_KEYCTL, keyctlUnlink, id, uintptr(ring))
+}
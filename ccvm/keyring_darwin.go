@@ -0,0 +1,35 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"github.com/pkg/errors"
+)
+
+// macOS has no kernel keyring, so the passphrases of encrypted disks must
+// be supplied each time their instances are started.
+
+func addKeyringKey(name string, key []byte) error {
+	return errors.New("The keyring is not supported on macOS")
+}
+
+func readKeyringKey(name string) ([]byte, error) {
+	return nil, errors.New("The keyring is not supported on macOS")
+}
+
+func removeKeyringKey(name string) {
+}
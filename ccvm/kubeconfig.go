@@ -0,0 +1,262 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The kubeconfig of the cluster installed by a workload is retrieved from
+// the guest and kept in kubeconfigFile.  Its server is rewritten to the
+// host port to which the API port is forwarded.
+
+const (
+	kubeconfigFile        = "kubeconfig"
+	defaultKubeconfigPort = 6443
+)
+
+// kubeconfigSpec describes the kubeconfig of the Kubernetes cluster run
+// by the instances of a workload.  Port is the API port in the guest and
+// defaults to defaultKubeconfigPort.
+type kubeconfigSpec struct {
+	Path string `yaml:"path,omitempty"`
+	Port int    `yaml:"port,omitempty"`
+}
+
+func (k *kubeconfigSpec) merge(parent *kubeconfigSpec) {
+	if k.Path == "" {
+		k.Path = parent.Path
+	}
+	if k.Port == 0 {
+		k.Port = parent.Port
+	}
+}
+
+func (k *kubeconfigSpec) apiPort() int {
+	if k.Port == 0 {
+		return defaultKubeconfigPort
+	}
+	return k.Port
+}
+
+// kubeconfigContext returns the name of the context, cluster and user of
+// the kubeconfig of the instance name.
+func kubeconfigContext(name string) string {
+	return "ccloudvm-" + name
+}
+
+// kubeconfigServer returns the URL of the API server of the instance
+// described by in whose API port is apiPort, as seen from the host.
+func kubeconfigServer(in *types.VMSpec, apiPort int) (string, error) {
+	for _, p := range in.PortMappings {
+		if p.Guest != apiPort {
+			continue
+		}
+		host := p.HostAddr
+		if host == "" {
+			host = in.HostIP.String()
+		}
+		return "https://" + net.JoinHostPort(host, strconv.Itoa(p.Host)), nil
+	}
+	return "", errors.Errorf("The API port %d is not forwarded to the host", apiPort)
+}
+
+// rewriteKubeconfig returns a kubeconfig with the current context of the
+// kubeconfig data, renamed contextName, whose server is server.  The
+// certificate of the server is verified against the original host name
+// of the server.
+func rewriteKubeconfig(data []byte, contextName, server string) ([]byte, error) {
+	k, err := types.ParseKubeconfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(k.Contexts) == 0 {
+		return nil, errors.New("The kubeconfig has no context")
+	}
+
+	ctx := k.Contexts[0]
+	for _, c := range k.Contexts {
+		if c.Name == k.CurrentContext {
+			ctx = c
+		}
+	}
+	clusterName, _ := ctx.Context["cluster"].(string)
+	userName, _ := ctx.Context["user"].(string)
+
+	var cluster *types.KubeconfigCluster
+	for i := range k.Clusters {
+		if k.Clusters[i].Name == clusterName {
+			cluster = &k.Clusters[i]
+		}
+	}
+	if cluster == nil {
+		return nil, errors.Errorf("Cluster %s not found in the kubeconfig", clusterName)
+	}
+	var user *types.KubeconfigUser
+	for i := range k.Users {
+		if k.Users[i].Name == userName {
+			user = &k.Users[i]
+		}
+	}
+	if user == nil {
+		return nil, errors.Errorf("User %s not found in the kubeconfig", userName)
+	}
+
+	clusterData := make(map[string]interface{}, len(cluster.Cluster)+1)
+	for key, v := range cluster.Cluster {
+		clusterData[key] = v
+	}
+	if orig, ok := cluster.Cluster["server"].(string); ok {
+		if u, err := url.Parse(orig); err == nil && u.Hostname() != "" {
+			if _, ok := clusterData["tls-server-name"]; !ok {
+				clusterData["tls-server-name"] = u.Hostname()
+			}
+		}
+	}
+	clusterData["server"] = server
+
+	contextData := map[string]interface{}{
+		"cluster": contextName,
+		"user":    contextName,
+	}
+	if ns, ok := ctx.Context["namespace"]; ok {
+		contextData["namespace"] = ns
+	}
+
+	out := &types.Kubeconfig{
+		Clusters:       []types.KubeconfigCluster{{Name: contextName, Cluster: clusterData}},
+		Contexts:       []types.KubeconfigContext{{Name: contextName, Context: contextData}},
+		Users:          []types.KubeconfigUser{{Name: contextName, User: user.User}},
+		CurrentContext: contextName,
+	}
+	return out.Marshal()
+}
+
+// fetchKubeconfig retrieves the kubeconfig of the instance name from its
+// guest and saves a copy of it in the instance directory.
+func (c ccvmBackend) fetchKubeconfig(ctx context.Context, ws *workspace, name string, wkld *workload) ([]byte, error) {
+	details, err := c.sshDetails(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !sshReachable(ctx, details.SSH.Host, details.SSH.Port) {
+		return nil, errors.Errorf("Unable to reach the SSH server of %s.  Is the instance running?", name)
+	}
+
+	var stdout, stderr bytes.Buffer
+	command := "sudo -n cat -- " + shellQuote(wkld.spec.Kubeconfig.Path)
+	cmd := exec.CommandContext(ctx, "ssh", sshArgs(details, command)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.Errorf("Unable to read %s: %s", wkld.spec.Kubeconfig.Path, msg)
+		}
+		return nil, errors.Wrapf(err, "Unable to read %s", wkld.spec.Kubeconfig.Path)
+	}
+
+	data := stdout.Bytes()
+	if _, err := types.ParseKubeconfig(data); err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(path.Join(ws.instanceDir, kubeconfigFile), data, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to save kubeconfig")
+	}
+	return data, nil
+}
+
+// kubeconfig returns the kubeconfig of the instance name.  It is retrieved
+// from the guest if the instance is running and from the copy kept in the
+// instance directory otherwise.
+func (c ccvmBackend) kubeconfig(ctx context.Context, name string) (*types.KubeconfigResult, error) {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to load instance state")
+	}
+	if wkld.spec.Kubeconfig.Path == "" {
+		return nil, errors.Errorf("The workload of %s does not declare a kubeconfig", name)
+	}
+	server, err := kubeconfigServer(&wkld.spec.VM, wkld.spec.Kubeconfig.apiPort())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.fetchKubeconfig(ctx, ws, name, wkld)
+	if err != nil {
+		var rerr error
+		data, rerr = ioutil.ReadFile(path.Join(ws.instanceDir, kubeconfigFile))
+		if rerr != nil {
+			if os.IsNotExist(rerr) {
+				return nil, err
+			}
+			return nil, errors.Wrap(rerr, "Unable to read kubeconfig")
+		}
+	}
+
+	contextName := kubeconfigContext(name)
+	data, err = rewriteKubeconfig(data, contextName, server)
+	if err != nil {
+		return nil, err
+	}
+	return &types.KubeconfigResult{
+		Context:    contextName,
+		Kubeconfig: data,
+	}, nil
+}
+
+func (s *ccvmService) kubeconfig(ctx context.Context, name string, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:  instanceCmdOther,
+		resultCh: resultCh,
+		ctx:      ctx,
+		fn: func() error {
+			res, err := s.b.kubeconfig(ctx, instanceName)
+			if err != nil {
+				resultCh <- err
+			} else {
+				resultCh <- res
+			}
+			return nil
+		},
+	}
+}
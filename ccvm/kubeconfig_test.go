@@ -0,0 +1,127 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: kubernetes
+  cluster:
+    certificate-authority-data: Q0E=
+    server: https://10.0.2.15:6443
+contexts:
+- name: kubernetes-admin@kubernetes
+  context:
+    cluster: kubernetes
+    user: kubernetes-admin
+current-context: kubernetes-admin@kubernetes
+preferences: {}
+users:
+- name: kubernetes-admin
+  user:
+    client-certificate-data: Q0VSVA==
+    client-key-data: S0VZ
+`
+
+func TestKubeconfigServer(t *testing.T) {
+	in := &types.VMSpec{
+		HostIP:       net.IPv4(127, 0, 0, 2),
+		PortMappings: []types.PortMapping{{Host: 10022, Guest: 22}, {Host: 16443, Guest: 6443}},
+	}
+
+	server, err := kubeconfigServer(in, defaultKubeconfigPort)
+	if err != nil {
+		t.Fatalf("Unable to determine server: %v", err)
+	}
+	if server != "https://127.0.0.2:16443" {
+		t.Errorf("Unexpected server %s", server)
+	}
+
+	if _, err := kubeconfigServer(in, 8443); err == nil {
+		t.Errorf("Expected an error for a port that is not forwarded")
+	}
+}
+
+func TestRewriteKubeconfig(t *testing.T) {
+	data, err := rewriteKubeconfig([]byte(testKubeconfig), "ccloudvm-kube", "https://127.0.0.2:16443")
+	if err != nil {
+		t.Fatalf("Unable to rewrite kubeconfig: %v", err)
+	}
+
+	k, err := types.ParseKubeconfig(data)
+	if err != nil {
+		t.Fatalf("Unable to parse rewritten kubeconfig: %v", err)
+	}
+	if k.CurrentContext != "ccloudvm-kube" || len(k.Contexts) != 1 ||
+		k.Contexts[0].Context["cluster"] != "ccloudvm-kube" ||
+		k.Contexts[0].Context["user"] != "ccloudvm-kube" {
+		t.Errorf("Unexpected contexts %+v", k.Contexts)
+	}
+	if len(k.Clusters) != 1 || k.Clusters[0].Name != "ccloudvm-kube" {
+		t.Fatalf("Unexpected clusters %+v", k.Clusters)
+	}
+	cluster := k.Clusters[0].Cluster
+	if cluster["server"] != "https://127.0.0.2:16443" ||
+		cluster["tls-server-name"] != "10.0.2.15" ||
+		cluster["certificate-authority-data"] != "Q0E=" {
+		t.Errorf("Unexpected cluster %+v", cluster)
+	}
+	if len(k.Users) != 1 || k.Users[0].Name != "ccloudvm-kube" ||
+		k.Users[0].User["client-key-data"] != "S0VZ" {
+		t.Errorf("Unexpected users %+v", k.Users)
+	}
+
+	if _, err := rewriteKubeconfig([]byte("apiVersion: v1\n"), "ccloudvm-kube", "https://127.0.0.2:16443"); err == nil {
+		t.Errorf("Expected an error for a kubeconfig without contexts")
+	}
+}
+
+func TestMergeKubeconfig(t *testing.T) {
+	dst, err := types.ParseKubeconfig([]byte(testKubeconfig))
+	if err != nil {
+		t.Fatalf("Unable to parse kubeconfig: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		data, err := rewriteKubeconfig([]byte(testKubeconfig), "ccloudvm-kube", "https://127.0.0.2:16443")
+		if err != nil {
+			t.Fatalf("Unable to rewrite kubeconfig: %v", err)
+		}
+		src, err := types.ParseKubeconfig(data)
+		if err != nil {
+			t.Fatalf("Unable to parse rewritten kubeconfig: %v", err)
+		}
+		dst.Merge(src)
+	}
+
+	if len(dst.Clusters) != 2 || len(dst.Contexts) != 2 || len(dst.Users) != 2 {
+		t.Errorf("Unexpected merged kubeconfig %+v", dst)
+	}
+	if dst.CurrentContext != "kubernetes-admin@kubernetes" {
+		t.Errorf("Current context changed to %s", dst.CurrentContext)
+	}
+	if _, ok := dst.Rest["preferences"]; !ok {
+		t.Errorf("preferences not preserved")
+	}
+}
@@ -0,0 +1,78 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Instances are labelled by their workloads, which inherit the labels of
+// their parents, and by the create command.  The labels are stored with
+// the rest of the workload in the state of the instance.
+
+// mergeLabels returns labels completed with the labels of parent that it
+// does not override.
+func mergeLabels(labels, parent map[string]string) map[string]string {
+	if len(parent) == 0 {
+		return labels
+	}
+	merged := make(map[string]string, len(labels)+len(parent))
+	for k, v := range parent {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
+}
+
+// instanceState returns the state, one of the types.State constants, of
+// the instance stored in instanceDir as recorded by the service.
+func instanceState(instanceDir string) string {
+	switch {
+	case loadSuspension(instanceDir) != nil:
+		return types.StateSuspended
+	case loadCrash(instanceDir).Active:
+		return types.StateCrashed
+	case loadStatus(instanceDir).Running:
+		return types.StateRunning
+	}
+	return types.StateStopped
+}
+
+func (c ccvmBackend) summary(ctx context.Context, name string) (*types.InstanceSummary, error) {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to load instance state")
+	}
+
+	return &types.InstanceSummary{
+		Name:     name,
+		Workload: wkld.spec.WorkloadName,
+		State:    instanceState(ws.instanceDir),
+		Group:    wkld.spec.Group,
+		Labels:   wkld.spec.Labels,
+	}, nil
+}
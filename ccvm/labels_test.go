@@ -0,0 +1,95 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"project": "kata", "tier": "db"}
+
+	tests := []struct {
+		selector string
+		match    bool
+	}{
+		{"", true},
+		{"project=kata", true},
+		{"project==kata", true},
+		{"project=clear", false},
+		{"project!=clear", true},
+		{"project!=kata", false},
+		{"owner!=me", true},
+		{"project", true},
+		{"owner", false},
+		{"!owner", true},
+		{"!project", false},
+		{"project=kata, tier=db", true},
+		{"project=kata,tier=web", false},
+	}
+	for _, tt := range tests {
+		sel, err := types.ParseSelector(tt.selector)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tt.selector, err)
+			continue
+		}
+		if sel.Matches(labels) != tt.match {
+			t.Errorf("Expected %q to match %v: %v", tt.selector, labels, tt.match)
+		}
+	}
+
+	for _, s := range []string{"project=", "=kata", "!", "project=a/b", "pro ject"} {
+		if _, err := types.ParseSelector(s); err == nil {
+			t.Errorf("Expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestCheckLabels(t *testing.T) {
+	if err := types.CheckLabels(map[string]string{"example.com/project": "kata", "empty": ""}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	bad := []map[string]string{
+		{"": "kata"},
+		{"-project": "kata"},
+		{"project": "a b"},
+		{"project": "a/b"},
+	}
+	for _, labels := range bad {
+		if err := types.CheckLabels(labels); err == nil {
+			t.Errorf("Expected %v to be rejected", labels)
+		}
+	}
+}
+
+func TestMergeLabels(t *testing.T) {
+	merged := mergeLabels(map[string]string{"project": "kata"},
+		map[string]string{"project": "clear", "tier": "db"})
+	expected := map[string]string{"project": "kata", "tier": "db"}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Expected %v, got %v", expected, merged)
+	}
+	if s := types.FormatLabels(merged); s != "project=kata,tier=db" {
+		t.Errorf("Unexpected formatted labels %s", s)
+	}
+	if merged := mergeLabels(nil, nil); merged != nil {
+		t.Errorf("Expected no labels, got %v", merged)
+	}
+}
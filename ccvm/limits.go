@@ -0,0 +1,158 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The limits section of the daemon configuration caps the resources of all
+// the instances of the daemon, running or not.  In system mode each user is
+// also limited by the quota of the system section.
+
+// resourceQuota limits the number of instances and the vCPUs, memory and
+// disk space assigned to them.  The disk space of an instance is that of
+// its root disk and of the data disks stored in its directory.  A limit of
+// 0 means that the resource is unlimited.
+type resourceQuota struct {
+	Instances int `yaml:"instances"`
+	CPUs      int `yaml:"cpus"`
+	MemMiB    int `yaml:"mem_mib"`
+	DiskGiB   int `yaml:"disk_gib"`
+}
+
+func (q *resourceQuota) validate() error {
+	if q.Instances < 0 || q.CPUs < 0 || q.MemMiB < 0 || q.DiskGiB < 0 {
+		return errors.New("Limits must be positive")
+	}
+	return nil
+}
+
+// allocation contains the resources assigned to a set of instances.
+type allocation struct {
+	instances int
+	cpus      int
+	memMiB    int
+	diskGiB   int
+}
+
+func (a *allocation) add(in *types.VMSpec) {
+	a.instances++
+	a.cpus += in.CPUs
+	a.memMiB += in.MemMiB
+	a.diskGiB += in.DiskGiB
+	for _, d := range in.Disks {
+		if d.Volume == "" {
+			a.diskGiB += d.SizeGiB
+		}
+	}
+}
+
+// allocated returns the resources assigned to the instances whose
+// directories match pattern, apart from the instance of ws.
+func (ws *workspace) allocated(pattern string) allocation {
+	var a allocation
+	instanceDirs, _ := filepath.Glob(pattern)
+	for _, instanceDir := range instanceDirs {
+		if instanceDir == ws.instanceDir {
+			continue
+		}
+		iws := *ws
+		iws.instanceDir = instanceDir
+		wkld, err := restoreWorkload(&iws)
+		if err != nil {
+			continue
+		}
+		a.add(&wkld.spec.VM)
+	}
+	return a
+}
+
+// check verifies that an instance with the resources of in can be added
+// to the instances that have been allocated used without exceeding q, the
+// limit described by what.
+func (q *resourceQuota) check(what string, used allocation, in *types.VMSpec) error {
+	var req allocation
+	req.add(in)
+
+	switch {
+	case q.Instances > 0 && used.instances+req.instances > q.Instances:
+		return errors.Errorf("The %s of %d instances has been reached", what, q.Instances)
+	case q.CPUs > 0 && used.cpus+req.cpus > q.CPUs:
+		return errors.Errorf("%d vCPUs requested but only %d of the %s of %d vCPUs are available",
+			req.cpus, available(q.CPUs, used.cpus), what, q.CPUs)
+	case q.MemMiB > 0 && used.memMiB+req.memMiB > q.MemMiB:
+		return errors.Errorf("%d MiB of memory requested but only %d MiB of the %s of %d MiB are available",
+			req.memMiB, available(q.MemMiB, used.memMiB), what, q.MemMiB)
+	case q.DiskGiB > 0 && used.diskGiB+req.diskGiB > q.DiskGiB:
+		return errors.Errorf("%d GiB of disk requested but only %d GiB of the %s of %d GiB are available",
+			req.diskGiB, available(q.DiskGiB, used.diskGiB), what, q.DiskGiB)
+	}
+	return nil
+}
+
+// grows returns true if next assigns more of any resource to an instance
+// than cur.  Changes that do not are allowed even if the instances exceed
+// their limits, e.g., because the limits have been lowered.
+func grows(cur, next *types.VMSpec) bool {
+	var a, b allocation
+	a.add(cur)
+	b.add(next)
+	return b.cpus > a.cpus || b.memMiB > a.memMiB || b.diskGiB > a.diskGiB
+}
+
+func available(limit, used int) int {
+	if used > limit {
+		return 0
+	}
+	return limit - used
+}
+
+// hostInstancesPattern returns the pattern matched by the directories of
+// all the instances of the daemon serving ws.
+func (ws *workspace) hostInstancesPattern() string {
+	if ws.account != nil {
+		// The data directories of all the users of a system mode
+		// daemon share a parent.
+		return filepath.Join(filepath.Dir(ws.ccvmDir), "*", "instances", "*")
+	}
+	return filepath.Join(ws.ccvmDir, "instances", "*")
+}
+
+// checkQuota verifies that the instance of ws can be assigned the resources
+// of in, whether it is being created or its resources are being changed,
+// without exceeding limits or, in system mode, the quota of the user.
+func (ws *workspace) checkQuota(limits *resourceQuota, in *types.VMSpec) error {
+	if ws.account != nil {
+		q := &ws.account.quota
+		if *q != (resourceQuota{}) {
+			pattern := filepath.Join(ws.ccvmDir, "instances", "*")
+			err := q.check("quota of "+ws.User, ws.allocated(pattern), in)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if *limits == (resourceQuota{}) {
+		return nil
+	}
+	return limits.check("host limit", ws.allocated(ws.hostInstancesPattern()), in)
+}
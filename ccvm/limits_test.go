@@ -0,0 +1,90 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestHostLimits(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	wkld := defaultWorkload()
+	wkld.spec.VM.CPUs = 4
+	wkld.spec.VM.MemMiB = 4096
+	wkld.spec.VM.DiskGiB = 60
+	wkld.spec.VM.Disks = []types.Disk{
+		{Name: "data", SizeGiB: 20},
+		{Name: "shared", Volume: "cache"},
+	}
+	for _, name := range []string{"web", "db"} {
+		instanceDir := filepath.Join(ccvmDir, "instances", name)
+		if err := os.MkdirAll(instanceDir, 0755); err != nil {
+			t.Fatalf("Unable to create %s: %v", instanceDir, err)
+		}
+		if err := wkld.save(instanceDir); err != nil {
+			t.Fatalf("Unable to save workload: %v", err)
+		}
+	}
+
+	ws := &workspace{
+		ccvmDir:     ccvmDir,
+		instanceDir: filepath.Join(ccvmDir, "instances", "dev"),
+	}
+	limits := resourceQuota{CPUs: 12, MemMiB: 12288, DiskGiB: 200}
+	spec := types.VMSpec{CPUs: 4, MemMiB: 4096, DiskGiB: 40}
+
+	if err := ws.checkQuota(&limits, &spec); err != nil {
+		t.Errorf("Instance within limits rejected: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		spec types.VMSpec
+	}{
+		{"cpus", types.VMSpec{CPUs: 5, MemMiB: 4096, DiskGiB: 40}},
+		{"memory", types.VMSpec{CPUs: 4, MemMiB: 8192, DiskGiB: 40}},
+		{"disk", types.VMSpec{CPUs: 4, MemMiB: 4096, DiskGiB: 41}},
+	}
+	for _, tt := range tests {
+		if err := ws.checkQuota(&limits, &tt.spec); err == nil {
+			t.Errorf("Limit on %s not enforced", tt.name)
+		}
+	}
+
+	// Resizing an instance only counts its new resources.
+	ws.instanceDir = filepath.Join(ccvmDir, "instances", "db")
+	resized := types.VMSpec{CPUs: 8, MemMiB: 8192, DiskGiB: 100}
+	if err := ws.checkQuota(&limits, &resized); err != nil {
+		t.Errorf("Resize within limits rejected: %v", err)
+	}
+	if !grows(&wkld.spec.VM, &resized) {
+		t.Errorf("Growth not detected")
+	}
+	if grows(&wkld.spec.VM, &types.VMSpec{CPUs: 2, MemMiB: 4096, DiskGiB: 80}) {
+		t.Errorf("Shrunk instance reported as growing")
+	}
+}
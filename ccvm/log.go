@@ -0,0 +1,332 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Log entries concerning an instance are also appended to
+// ~/.ccloudvm/logs/<instance>.log.
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	logDirName = "logs"
+
+	// instanceLogSize is the size above which an instance log file is
+	// moved to <instance>.log.1.
+	instanceLogSize = 1 << 20
+)
+
+type logLevel int32
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarning
+	levelError
+)
+
+var logLevelNames = []string{"debug", "info", "warning", "error"}
+
+func (l logLevel) String() string {
+	if l < levelDebug || l > levelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return logLevelNames[l]
+}
+
+func parseLogLevel(level string) (logLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(level, name) {
+			return logLevel(i), nil
+		}
+	}
+	return levelInfo, errors.Errorf("Unknown log level %q.  Valid levels are %s",
+		level, strings.Join(logLevelNames, ", "))
+}
+
+// logConfig contains the logging settings of the daemon configuration.
+// Level is one of debug, info, warning and error and defaults to info.
+// Format is text or json and defaults to text.  Both can be overridden by
+// the -log-level and -log-format command line options.
+type logConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+var logLevelFlag string
+var logFormatFlag string
+
+func init() {
+	flag.StringVar(&logLevelFlag, "log-level", "", "Minimum level of logged messages: debug, info, warning or error")
+	flag.StringVar(&logFormatFlag, "log-format", "", "Format of logged messages: text or json")
+}
+
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// logSink writes log entries to out and to the per instance log files in
+// dir, if dir is not empty.
+type logSink struct {
+	sync.Mutex
+	level  int32
+	out    io.Writer
+	format string
+	dir    string
+	now    func() time.Time
+}
+
+var daemonSink = &logSink{
+	level:  int32(levelInfo),
+	out:    os.Stdout,
+	format: logFormatText,
+	now:    time.Now,
+}
+
+func (s *logSink) setLevel(level logLevel) {
+	atomic.StoreInt32(&s.level, int32(level))
+}
+
+func (s *logSink) getLevel() logLevel {
+	return logLevel(atomic.LoadInt32(&s.level))
+}
+
+func (s *logSink) encode(t time.Time, level logLevel, msg string, fields []logField) []byte {
+	var buf bytes.Buffer
+	if s.format == logFormatJSON {
+		// The fields are encoded in order, rather than from a map,
+		// so that entries are easy to read.
+		buf.WriteString(`{"time":`)
+		writeJSON(&buf, t.Format(time.RFC3339Nano))
+		buf.WriteString(`,"level":`)
+		writeJSON(&buf, level.String())
+		buf.WriteString(`,"msg":`)
+		writeJSON(&buf, msg)
+		for _, f := range fields {
+			buf.WriteByte(',')
+			writeJSON(&buf, f.key)
+			buf.WriteByte(':')
+			writeJSON(&buf, f.value)
+		}
+		buf.WriteString("}\n")
+		return buf.Bytes()
+	}
+
+	fmt.Fprintf(&buf, "%s %-7s %s", t.Format(time.RFC3339), strings.ToUpper(level.String()), msg)
+	for _, f := range fields {
+		value := fmt.Sprint(f.value)
+		if strings.ContainsAny(value, " \t\n\"=") || value == "" {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&buf, " %s=%s", f.key, value)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(data)
+}
+
+func (s *logSink) write(level logLevel, msg string, fields []logField) {
+	if level < s.getLevel() {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	entry := s.encode(s.now(), level, msg, fields)
+	_, _ = s.out.Write(entry)
+
+	if s.dir == "" {
+		return
+	}
+	for _, f := range fields {
+		if f.key != "instance" {
+			continue
+		}
+		if name, ok := f.value.(string); ok && name != "" && !strings.ContainsAny(name, "/\\") {
+			s.appendInstanceLog(name, entry)
+		}
+	}
+}
+
+func (s *logSink) appendInstanceLog(name string, entry []byte) {
+	logPath := filepath.Join(s.dir, name+".log")
+	if fi, err := os.Stat(logPath); err == nil && fi.Size()+int64(len(entry)) > instanceLogSize {
+		_ = os.Rename(logPath, logPath+".1")
+	}
+
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	_, _ = f.Write(entry)
+	_ = f.Close()
+}
+
+// renameInstanceLog renames the log of the instance name, and its previous
+// generation, after newName.
+func (s *logSink) renameInstanceLog(name, newName string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.dir == "" {
+		return
+	}
+	for _, suffix := range []string{".log", ".log.1"} {
+		_ = os.Rename(filepath.Join(s.dir, name+suffix), filepath.Join(s.dir, newName+suffix))
+	}
+}
+
+// logger logs messages, along with a set of fields, to a sink.
+type logger struct {
+	sink   *logSink
+	fields []logField
+}
+
+var daemonLog = logger{sink: daemonSink}
+
+// with returns a logger that adds the field key to the entries it logs.
+func (l logger) with(key string, value interface{}) logger {
+	fields := make([]logField, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+	return logger{
+		sink:   l.sink,
+		fields: append(fields, logField{key: key, value: value}),
+	}
+}
+
+func (l logger) logf(level logLevel, format string, args ...interface{}) {
+	if level < l.sink.getLevel() {
+		return
+	}
+	msg := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	l.sink.write(level, msg, l.fields)
+}
+
+func (l logger) Debugf(format string, args ...interface{}) {
+	l.logf(levelDebug, format, args...)
+}
+
+func (l logger) Infof(format string, args ...interface{}) {
+	l.logf(levelInfo, format, args...)
+}
+
+func (l logger) Warningf(format string, args ...interface{}) {
+	l.logf(levelWarning, format, args...)
+}
+
+func (l logger) Errorf(format string, args ...interface{}) {
+	l.logf(levelError, format, args...)
+}
+
+// instanceLog returns a logger for messages that concern the instance name.
+func instanceLog(name string) logger {
+	return daemonLog.with("instance", name)
+}
+
+// instanceDirLog returns a logger for messages that concern the instance
+// whose directory is instanceDir.
+func instanceDirLog(instanceDir string) logger {
+	return instanceLog(filepath.Base(instanceDir))
+}
+
+func logDebugf(format string, args ...interface{}) {
+	daemonLog.logf(levelDebug, format, args...)
+}
+
+func logInfof(format string, args ...interface{}) {
+	daemonLog.logf(levelInfo, format, args...)
+}
+
+func logWarningf(format string, args ...interface{}) {
+	daemonLog.logf(levelWarning, format, args...)
+}
+
+func logErrorf(format string, args ...interface{}) {
+	daemonLog.logf(levelError, format, args...)
+}
+
+// configureLogging applies the logging settings of the daemon configuration
+// and of the command line and creates the directory of the per instance log
+// files in ccvmDir.
+func configureLogging(ccvmDir string, cfg *logConfig) error {
+	levelName := cfg.Level
+	if logLevelFlag != "" {
+		levelName = logLevelFlag
+	}
+	level := levelInfo
+	if levelName != "" {
+		var err error
+		level, err = parseLogLevel(levelName)
+		if err != nil {
+			return err
+		}
+	}
+
+	format := cfg.Format
+	if logFormatFlag != "" {
+		format = logFormatFlag
+	}
+	switch format {
+	case "":
+		format = logFormatText
+	case logFormatText, logFormatJSON:
+	default:
+		return errors.Errorf("Unknown log format %q.  Valid formats are %s and %s",
+			format, logFormatText, logFormatJSON)
+	}
+
+	logDir := filepath.Join(ccvmDir, logDirName)
+	if err := os.MkdirAll(logDir, 0700); err != nil {
+		return errors.Wrapf(err, "Unable to create %s", logDir)
+	}
+
+	daemonSink.Lock()
+	daemonSink.format = format
+	daemonSink.dir = logDir
+	daemonSink.Unlock()
+	daemonSink.setLevel(level)
+
+	return nil
+}
@@ -0,0 +1,131 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func testSink(format, dir string) (*logSink, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return &logSink{
+		level:  int32(levelInfo),
+		out:    &buf,
+		format: format,
+		dir:    dir,
+		now: func() time.Time {
+			return time.Date(2026, 10, 13, 10, 15, 4, 0, time.UTC)
+		},
+	}, &buf
+}
+
+func TestLogText(t *testing.T) {
+	sink, buf := testSink(logFormatText, "")
+	l := logger{sink: sink}
+
+	l.Debugf("Not logged")
+	l.with("instance", "tense-peles").with("reason", "VM exited unexpectedly").Errorf("Instance crashed\n")
+	expected := `2026-10-13T10:15:04Z ERROR   Instance crashed instance=tense-peles reason="VM exited unexpectedly"` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected log entry %q", buf.String())
+	}
+
+	buf.Reset()
+	sink.setLevel(levelDebug)
+	l.Debugf("Transaction ID %d", 4)
+	if !strings.Contains(buf.String(), "DEBUG   Transaction ID 4") {
+		t.Errorf("Debug message not logged %q", buf.String())
+	}
+}
+
+func TestLogJSON(t *testing.T) {
+	sink, buf := testSink(logFormatJSON, "")
+	logger{sink: sink}.with("id", 3).Warningf("Drain timed out")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Invalid JSON log entry %q: %v", buf.String(), err)
+	}
+	if entry["level"] != "warning" || entry["msg"] != "Drain timed out" || entry["id"] != 3.0 {
+		t.Errorf("Unexpected log entry %v", entry)
+	}
+}
+
+func TestInstanceLogFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	sink, buf := testSink(logFormatText, dir)
+	l := logger{sink: sink}
+	l.Infof("Running server")
+	l.with("instance", "tense-peles").Infof("VM Started")
+	l.with("instance", "../escape").Infof("Not written")
+
+	if strings.Count(buf.String(), "\n") != 3 {
+		t.Errorf("Entries missing from daemon log %q", buf.String())
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "tense-peles.log"))
+	if err != nil {
+		t.Fatalf("Instance log not written: %v", err)
+	}
+	if !strings.Contains(string(data), "VM Started") || strings.Contains(string(data), "Running server") {
+		t.Errorf("Unexpected instance log %q", data)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("Unexpected log files %v", files)
+	}
+
+	sink.renameInstanceLog("tense-peles", "calm-peles")
+	if _, err := os.Stat(filepath.Join(dir, "calm-peles.log")); err != nil {
+		t.Errorf("Instance log not renamed: %v", err)
+	}
+}
+
+func TestSetLogLevel(t *testing.T) {
+	saved := daemonSink.getLevel()
+	defer daemonSink.setLevel(saved)
+
+	api := &ServerAPI{}
+	var id int
+	if err := api.SetLogLevel(&types.SetLogLevelArgs{Level: "verbose"}, &id); err == nil {
+		t.Errorf("Expected error for unknown log level")
+	}
+	if err := api.SetLogLevel(&types.SetLogLevelArgs{Level: "Debug"}, &id); err != nil {
+		t.Fatalf("Unable to set log level: %v", err)
+	}
+	if id != noTransaction {
+		t.Errorf("Transaction started")
+	}
+
+	var level string
+	if err := api.SetLogLevelResult(id, &level); err != nil || level != "debug" {
+		t.Errorf("Unexpected log level %s: %v", level, err)
+	}
+}
@@ -0,0 +1,137 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ciao-project/ciao/uuid"
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The mediated devices backing the vGPUs of an instance are created when its
+// VM boots and removed when the VM exits.  Their UUIDs are recorded in
+// mdevFile so that they can be removed after a restart of the daemon.
+
+const mdevFile = "mdevs"
+
+var (
+	mdevBusDir     = "/sys/class/mdev_bus"
+	mdevDevicesDir = "/sys/bus/mdev/devices"
+)
+
+func mdevPath(id string) string {
+	return path.Join(mdevDevicesDir, id)
+}
+
+func loadMdevs(instanceDir string) []string {
+	data, err := ioutil.ReadFile(path.Join(instanceDir, mdevFile))
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(data))
+}
+
+func saveMdevs(instanceDir string, ids []string) error {
+	data := strings.Join(ids, "\n") + "\n"
+	err := ioutil.WriteFile(path.Join(instanceDir, mdevFile), []byte(data), 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to record vGPUs")
+	}
+	return nil
+}
+
+// createMdevs creates a mediated device for each of vgpus and returns their
+// UUIDs.  Any devices left over from a previous boot are removed first.
+func createMdevs(instanceDir string, vgpus []types.VGPU) ([]string, error) {
+	removeMdevs(instanceDir)
+
+	var ids []string
+	for _, g := range vgpus {
+		typeDir := path.Join(mdevBusDir, g.Parent, "mdev_supported_types", g.Type)
+		data, err := ioutil.ReadFile(path.Join(typeDir, "available_instances"))
+		if err != nil {
+			removeMdevs(instanceDir)
+			return nil, errors.Errorf("vGPU type %s is not supported by %s", g.Type, g.Parent)
+		}
+		if n, _ := strconv.Atoi(strings.TrimSpace(string(data))); n < 1 {
+			removeMdevs(instanceDir)
+			return nil, errors.Errorf("No %s vGPUs are available on %s", g.Type, g.Parent)
+		}
+
+		id := uuid.Generate().String()
+		err = ioutil.WriteFile(path.Join(typeDir, "create"), []byte(id), 0200)
+		if err != nil {
+			removeMdevs(instanceDir)
+			return nil, errors.Wrapf(err, "Unable to create %s vGPU on %s", g.Type, g.Parent)
+		}
+
+		ids = append(ids, id)
+		if err := saveMdevs(instanceDir, ids); err != nil {
+			_ = removeMdev(id)
+			removeMdevs(instanceDir)
+			return nil, err
+		}
+	}
+
+	return ids, nil
+}
+
+// removeMdev removes the mediated device id.  The device cannot be removed
+// until the hypervisor using it has exited, so removal is retried for a few
+// seconds.
+func removeMdev(id string) error {
+	var err error
+	for i := 0; i < 50; i++ {
+		if _, serr := os.Stat(mdevPath(id)); os.IsNotExist(serr) {
+			return nil
+		}
+		err = ioutil.WriteFile(path.Join(mdevPath(id), "remove"), []byte("1"), 0200)
+		if err == nil {
+			return nil
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	return errors.Wrapf(err, "Unable to remove vGPU %s", id)
+}
+
+// removeMdevs removes the mediated devices created for an instance.
+func removeMdevs(instanceDir string) {
+	for _, id := range loadMdevs(instanceDir) {
+		if err := removeMdev(id); err != nil {
+			instanceDirLog(instanceDir).Warningf("%v", err)
+		}
+	}
+	_ = os.Remove(path.Join(instanceDir, mdevFile))
+}
+
+// mdevArgs returns the qemu arguments that assign the mediated devices ids
+// to a VM.
+func mdevArgs(ids []string) []string {
+	var args []string
+	for _, id := range ids {
+		args = append(args, "-device", fmt.Sprintf("vfio-pci,sysfsdev=%s,display=off", mdevPath(id)))
+	}
+	return args
+}
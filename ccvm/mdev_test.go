@@ -0,0 +1,118 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestVGPUCheck(t *testing.T) {
+	valid := types.VGPU{Parent: "0000:00:02.0", Type: "i915-GVTg_V5_4"}
+	if err := valid.Check(); err != nil {
+		t.Errorf("Valid vGPU rejected: %v", err)
+	}
+
+	invalid := []types.VGPU{
+		{Parent: "00:02.0", Type: "i915-GVTg_V5_4"},
+		{Parent: "0000:00:02.0"},
+		{Parent: "0000:00:02.0", Type: "../../../devices"},
+	}
+	for _, g := range invalid {
+		if err := g.Check(); err == nil {
+			t.Errorf("Expected error for %v", g)
+		}
+	}
+}
+
+func TestMdevs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	savedBusDir, savedDevicesDir := mdevBusDir, mdevDevicesDir
+	mdevBusDir = path.Join(dir, "mdev_bus")
+	mdevDevicesDir = path.Join(dir, "devices")
+	defer func() {
+		mdevBusDir, mdevDevicesDir = savedBusDir, savedDevicesDir
+	}()
+
+	instanceDir := path.Join(dir, "instance")
+	g := types.VGPU{Parent: "0000:00:02.0", Type: "i915-GVTg_V5_4"}
+	typeDir := path.Join(mdevBusDir, g.Parent, "mdev_supported_types", g.Type)
+	for _, d := range []string{instanceDir, typeDir} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatalf("Unable to create %s: %v", d, err)
+		}
+	}
+
+	if _, err := createMdevs(instanceDir, []types.VGPU{{Parent: g.Parent, Type: "none"}}); err == nil {
+		t.Errorf("Expected error for unsupported type")
+	}
+
+	err = ioutil.WriteFile(path.Join(typeDir, "available_instances"), []byte("0\n"), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write available instances: %v", err)
+	}
+	if _, err := createMdevs(instanceDir, []types.VGPU{g}); err == nil {
+		t.Errorf("Expected error when no vGPUs are available")
+	}
+
+	err = ioutil.WriteFile(path.Join(typeDir, "available_instances"), []byte("2\n"), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write available instances: %v", err)
+	}
+	ids, err := createMdevs(instanceDir, []types.VGPU{g})
+	if err != nil {
+		t.Fatalf("Unable to create vGPUs: %v", err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("Expected 1 vGPU, found %d", len(ids))
+	}
+	data, _ := ioutil.ReadFile(path.Join(typeDir, "create"))
+	if string(data) != ids[0] {
+		t.Errorf("vGPU %s not created, found %q", ids[0], string(data))
+	}
+	if recorded := loadMdevs(instanceDir); len(recorded) != 1 || recorded[0] != ids[0] {
+		t.Errorf("vGPU not recorded %v", recorded)
+	}
+
+	args := mdevArgs(ids)
+	expected := "vfio-pci,sysfsdev=" + path.Join(mdevDevicesDir, ids[0]) + ",display=off"
+	if len(args) != 2 || args[0] != "-device" || args[1] != expected {
+		t.Errorf("Unexpected qemu arguments %v", args)
+	}
+
+	// The kernel creates the device's directory.
+	if err := os.MkdirAll(mdevPath(ids[0]), 0700); err != nil {
+		t.Fatalf("Unable to create device directory: %v", err)
+	}
+	removeMdevs(instanceDir)
+	data, _ = ioutil.ReadFile(path.Join(mdevPath(ids[0]), "remove"))
+	if string(data) != "1" {
+		t.Errorf("vGPU not removed")
+	}
+	if recorded := loadMdevs(instanceDir); len(recorded) != 0 {
+		t.Errorf("vGPUs still recorded %v", recorded)
+	}
+}
@@ -0,0 +1,178 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// VMs can be given an Intel HD Audio card, connected to the audio backend
+// selected by the audio field, and host USB devices, attached to their own
+// xHCI controller.
+
+// audioMinVersion is the first version of qemu that supports -audiodev.
+var audioMinVersion = qemuVersion{4, 0, 0}
+
+// sysUSBDevices is the sysfs directory that lists the host's USB devices.
+var sysUSBDevices = "/sys/bus/usb/devices"
+
+// audioDrivers maps the audio of a VM to the qemu audio driver that
+// implements it.
+var audioDrivers = map[string]string{
+	types.AudioDummy:      "none",
+	types.AudioPulseAudio: "pa",
+	types.AudioSPICE:      "spice",
+}
+
+// checkMedia verifies that the audio of a VM is known and that its USB
+// devices are well formed.
+func checkMedia(in *types.VMSpec) error {
+	switch in.Audio {
+	case "", types.AudioNone, types.AudioDummy, types.AudioPulseAudio:
+	case types.AudioSPICE:
+		if in.Graphics != types.GraphicsSPICE {
+			return errors.New("spice audio requires a spice graphical console")
+		}
+	default:
+		return errors.Errorf("Unknown audio %s, expected %s, %s, %s or %s", in.Audio,
+			types.AudioNone, types.AudioDummy, types.AudioPulseAudio, types.AudioSPICE)
+	}
+
+	for _, u := range in.USBDevices {
+		if err := u.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasMedia returns true if the VM has a sound card or USB devices.
+func hasMedia(in *types.VMSpec) bool {
+	return (in.Audio != "" && in.Audio != types.AudioNone) || len(in.USBDevices) > 0
+}
+
+// audioArgs returns the qemu arguments that give a VM its sound card.
+func audioArgs(in *types.VMSpec, caps *qemuCaps) ([]string, error) {
+	driver, ok := audioDrivers[in.Audio]
+	if !ok {
+		return nil, nil
+	}
+
+	if caps.version.less(audioMinVersion) {
+		return nil, errors.Errorf("qemu %s or later is required for audio", audioMinVersion)
+	}
+	if !caps.hasDevice("intel-hda") || !caps.hasDevice("hda-duplex") {
+		return nil, errors.New("qemu does not support Intel HD Audio")
+	}
+
+	return []string{
+		"-audiodev", driver + ",id=snd0",
+		"-device", "intel-hda",
+		"-device", "hda-duplex,audiodev=snd0",
+	}, nil
+}
+
+// findUSBDevice returns the sysfs directory of the host USB device u.
+func findUSBDevice(u types.USBDevice) (string, error) {
+	vendor, product := u.VendorProduct()
+	entries, err := ioutil.ReadDir(sysUSBDevices)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to list USB devices")
+	}
+	for _, e := range entries {
+		dir := path.Join(sysUSBDevices, e.Name())
+		v, err := ioutil.ReadFile(path.Join(dir, "idVendor"))
+		if err != nil || strings.TrimSpace(string(v)) != vendor {
+			continue
+		}
+		p, err := ioutil.ReadFile(path.Join(dir, "idProduct"))
+		if err != nil || strings.TrimSpace(string(p)) != product {
+			continue
+		}
+		return dir, nil
+	}
+	return "", errors.Errorf("USB device %s not found", u.ID)
+}
+
+// checkUSBAccess verifies that the USB device whose sysfs directory is dir
+// can be opened by qemu.
+func checkUSBAccess(u types.USBDevice, dir string) error {
+	var bus, dev int
+	for _, f := range []struct {
+		name string
+		v    *int
+	}{{"busnum", &bus}, {"devnum", &dev}} {
+		data, err := ioutil.ReadFile(path.Join(dir, f.name))
+		if err != nil {
+			return errors.Wrapf(err, "Unable to read %s of USB device %s", f.name, u.ID)
+		}
+		*f.v, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return errors.Wrapf(err, "Invalid %s for USB device %s", f.name, u.ID)
+		}
+	}
+
+	node := fmt.Sprintf("/dev/bus/usb/%03d/%03d", bus, dev)
+	file, err := os.OpenFile(node, os.O_RDWR, 0)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to open USB device %s.  Grant access to %s with a udev rule",
+			u.ID, node)
+	}
+	return file.Close()
+}
+
+// usbArgs returns the qemu arguments that pass the USB devices of a VM
+// through to it.  The devices are looked up on hosts that have sysfs, so
+// that missing or inaccessible devices are reported before qemu is
+// launched.
+func usbArgs(in *types.VMSpec, caps *qemuCaps) ([]string, error) {
+	if len(in.USBDevices) == 0 {
+		return nil, nil
+	}
+
+	if !caps.hasDevice("qemu-xhci") || !caps.hasDevice("usb-host") {
+		return nil, errors.New("qemu does not support USB passthrough")
+	}
+
+	_, err := os.Stat(sysUSBDevices)
+	checkHost := err == nil
+
+	args := []string{"-device", "qemu-xhci,id=xhci-host"}
+	for _, u := range in.USBDevices {
+		if checkHost {
+			dir, err := findUSBDevice(u)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkUSBAccess(u, dir); err != nil {
+				return nil, err
+			}
+		}
+		vendor, product := u.VendorProduct()
+		args = append(args, "-device",
+			fmt.Sprintf("usb-host,bus=xhci-host.0,vendorid=0x%s,productid=0x%s", vendor, product))
+	}
+	return args, nil
+}
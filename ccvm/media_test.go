@@ -0,0 +1,149 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestCheckMedia(t *testing.T) {
+	valid := []types.VMSpec{
+		{},
+		{Audio: types.AudioDummy},
+		{Audio: types.AudioPulseAudio},
+		{Audio: types.AudioSPICE, Graphics: types.GraphicsSPICE},
+		{USBDevices: []types.USBDevice{{ID: "046d:0825"}}},
+	}
+	for i := range valid {
+		if err := checkMedia(&valid[i]); err != nil {
+			t.Errorf("Unexpected error for %+v: %v", valid[i], err)
+		}
+	}
+
+	invalid := []types.VMSpec{
+		{Audio: "alsa"},
+		{Audio: types.AudioSPICE, Graphics: types.GraphicsVNC},
+		{USBDevices: []types.USBDevice{{ID: "046d"}}},
+		{USBDevices: []types.USBDevice{{ID: "046d:082g"}}},
+	}
+	for i := range invalid {
+		if err := checkMedia(&invalid[i]); err == nil {
+			t.Errorf("Expected %+v to be rejected", invalid[i])
+		}
+	}
+}
+
+func TestAudioArgs(t *testing.T) {
+	caps := &qemuCaps{
+		version: qemuVersion{5, 2, 0},
+		devices: map[string]struct{}{
+			"intel-hda":  {},
+			"hda-duplex": {},
+		},
+	}
+
+	args, err := audioArgs(&types.VMSpec{Audio: types.AudioNone}, caps)
+	if err != nil || len(args) != 0 {
+		t.Errorf("Unexpected audio arguments %v: %v", args, err)
+	}
+
+	args, err = audioArgs(&types.VMSpec{Audio: types.AudioPulseAudio}, caps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, "-audiodev pa,id=snd0") ||
+		!strings.Contains(joined, "hda-duplex,audiodev=snd0") {
+		t.Errorf("Unexpected audio arguments %s", joined)
+	}
+
+	caps.version = qemuVersion{3, 1, 0}
+	if _, err := audioArgs(&types.VMSpec{Audio: types.AudioDummy}, caps); err == nil {
+		t.Errorf("Expected audio to require qemu 4.0")
+	}
+}
+
+func TestFindUSBDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "usb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	devDir := path.Join(dir, "1-2")
+	if err := os.Mkdir(devDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{"idVendor": "046d\n", "idProduct": "0825\n"} {
+		if err := ioutil.WriteFile(path.Join(devDir, name), []byte(value), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saved := sysUSBDevices
+	sysUSBDevices = dir
+	defer func() { sysUSBDevices = saved }()
+
+	found, err := findUSBDevice(types.USBDevice{ID: "046d:0825"})
+	if err != nil || found != devDir {
+		t.Errorf("Unexpected device %s: %v", found, err)
+	}
+	if _, err := findUSBDevice(types.USBDevice{ID: "046d:0826"}); err == nil {
+		t.Errorf("Expected missing device not to be found")
+	}
+}
+
+func TestUSBArgs(t *testing.T) {
+	caps := &qemuCaps{
+		devices: map[string]struct{}{
+			"qemu-xhci": {},
+			"usb-host":  {},
+		},
+	}
+
+	saved := sysUSBDevices
+	sysUSBDevices = "/nonexistent"
+	defer func() { sysUSBDevices = saved }()
+
+	in := &types.VMSpec{USBDevices: []types.USBDevice{{ID: "046d:0825"}}}
+	args, err := usbArgs(in, caps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, "usb-host,bus=xhci-host.0,vendorid=0x046d,productid=0x0825") {
+		t.Errorf("Unexpected USB arguments %s", joined)
+	}
+
+	if _, err := usbArgs(in, &qemuCaps{}); err == nil {
+		t.Errorf("Expected USB passthrough to require usb-host")
+	}
+}
+
+func TestMergeUSBDevices(t *testing.T) {
+	in := types.VMSpec{USBDevices: []types.USBDevice{{ID: "046d:0825"}}}
+	in.MergeUSBDevices([]types.USBDevice{{ID: "046d:0825"}, {ID: "0c45:6366"}})
+	if len(in.USBDevices) != 2 || in.USBDevices[1].ID != "0c45:6366" {
+		t.Errorf("Unexpected USB devices %v", in.USBDevices)
+	}
+}
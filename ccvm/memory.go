@@ -0,0 +1,160 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+const defaultHugepageSize = "2M"
+
+// hugepagesDir contains a directory per hugepage size supported by the host,
+// with the number of pages of that size that are reserved and free.  It is
+// a variable so that it can be replaced by the tests.
+var hugepagesDir = "/sys/kernel/mm/hugepages"
+
+// parseHugepageSize returns the size in KiB of a hugepage size such as 2M,
+// 1G or 2048K.
+func parseHugepageSize(size string) (int, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1024
+	case strings.HasSuffix(s, "K"):
+	default:
+		return 0, errors.Errorf("Invalid hugepage size %q.  Sizes need a unit, e.g., 2M or 1G", size)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 || n&(n-1) != 0 {
+		return 0, errors.Errorf("Invalid hugepage size %q", size)
+	}
+	return n * multiplier, nil
+}
+
+// hugepageSizeKiB returns the size in KiB of the hugepages backing the memory
+// of in.
+func hugepageSizeKiB(in *types.VMSpec) (int, error) {
+	size := in.HugepageSize
+	if size == "" {
+		size = defaultHugepageSize
+	}
+	return parseHugepageSize(size)
+}
+
+// checkMemoryBackend verifies the memory backend of in.  Memory backed by
+// hugepages must be a whole number of pages on each NUMA node and virtio-fs
+// mounts need memory that can be shared with virtiofsd.
+func checkMemoryBackend(in *types.VMSpec) error {
+	switch in.MemoryBackend {
+	case "", types.MemoryBackendMemfd:
+	case types.MemoryBackendRAM:
+		for i := range in.Mounts {
+			if in.Mounts[i].FSType() == types.MountTypeVirtiofs {
+				return errors.Errorf("virtio-fs mount %s requires a shared memory backend, %s or %s",
+					in.Mounts[i].Tag, types.MemoryBackendMemfd, types.MemoryBackendHugepages)
+			}
+		}
+	case types.MemoryBackendHugepages:
+		pageKiB, err := hugepageSizeKiB(in)
+		if err != nil {
+			return err
+		}
+		sockets, _, _ := in.TopologySize()
+		if in.MemMiB*1024%(pageKiB*sockets) != 0 {
+			return errors.Errorf("The memory of the VM, %d MiB, is not a multiple of %d hugepages of %d KiB",
+				in.MemMiB, sockets, pageKiB)
+		}
+	default:
+		return errors.Errorf("Unknown memory backend %q.  Valid backends are %s, %s and %s",
+			in.MemoryBackend, types.MemoryBackendRAM, types.MemoryBackendMemfd,
+			types.MemoryBackendHugepages)
+	}
+
+	if in.HugepageSize != "" && in.MemoryBackend != types.MemoryBackendHugepages {
+		return errors.Errorf("A hugepage size requires the %s memory backend", types.MemoryBackendHugepages)
+	}
+	return nil
+}
+
+func readHugepages(dir, name string) (int, error) {
+	data, err := ioutil.ReadFile(path.Join(dir, name))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// checkHugepagesAvailable verifies that the host has enough free hugepages
+// reserved to back the memory of in.
+func checkHugepagesAvailable(in *types.VMSpec) error {
+	pageKiB, err := hugepageSizeKiB(in)
+	if err != nil {
+		return err
+	}
+
+	dir := path.Join(hugepagesDir, fmt.Sprintf("hugepages-%dkB", pageKiB))
+	total, err := readHugepages(dir, "nr_hugepages")
+	if err != nil {
+		return errors.Errorf("The host does not support hugepages of %d KiB", pageKiB)
+	}
+	free, err := readHugepages(dir, "free_hugepages")
+	if err != nil {
+		return errors.Wrap(err, "Unable to read the number of free hugepages")
+	}
+
+	needed := in.MemMiB * 1024 / pageKiB
+	if needed > free {
+		return errors.Errorf("%d hugepages of %d KiB are needed but only %d of the %d reserved are free.  Reserve more with\n\techo %d | sudo tee %s",
+			needed, pageKiB, free, total, total+needed-free, path.Join(dir, "nr_hugepages"))
+	}
+	return nil
+}
+
+// qemuMemoryBackend returns the qemu memory backend object, and its options,
+// that back the memory of in.  The memory is shared, so that it can be
+// accessed by virtiofsd, if shared is true and no backend is specified.  No
+// object is returned if qemu's default memory can be used.
+func qemuMemoryBackend(in *types.VMSpec, shared bool) (string, string, error) {
+	switch in.MemoryBackend {
+	case types.MemoryBackendRAM:
+		return "memory-backend-ram", "", nil
+	case types.MemoryBackendMemfd:
+		return "memory-backend-memfd", ",share=on", nil
+	case types.MemoryBackendHugepages:
+		pageKiB, err := hugepageSizeKiB(in)
+		if err != nil {
+			return "", "", err
+		}
+		return "memory-backend-memfd", fmt.Sprintf(",share=on,hugetlb=on,hugetlbsize=%dK", pageKiB), nil
+	}
+
+	if shared {
+		return "memory-backend-memfd", ",share=on", nil
+	}
+	return "", "", nil
+}
@@ -0,0 +1,133 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestCheckMemoryBackend(t *testing.T) {
+	virtiofs := types.Mount{Tag: "share", Path: "/tmp", Type: types.MountTypeVirtiofs}
+
+	valid := []types.VMSpec{
+		{MemMiB: 1024},
+		{MemMiB: 1024, MemoryBackend: types.MemoryBackendRAM},
+		{MemMiB: 1024, MemoryBackend: types.MemoryBackendMemfd, Mounts: []types.Mount{virtiofs}},
+		{MemMiB: 1024, MemoryBackend: types.MemoryBackendHugepages},
+		{MemMiB: 2048, MemoryBackend: types.MemoryBackendHugepages, HugepageSize: "1G", Sockets: 2},
+	}
+	for i := range valid {
+		if err := checkMemoryBackend(&valid[i]); err != nil {
+			t.Errorf("Valid memory backend %+v rejected: %v", valid[i], err)
+		}
+	}
+
+	invalid := []types.VMSpec{
+		{MemMiB: 1024, MemoryBackend: "file"},
+		{MemMiB: 1024, HugepageSize: "2M"},
+		{MemMiB: 1024, MemoryBackend: types.MemoryBackendHugepages, HugepageSize: "3M"},
+		{MemMiB: 1024, MemoryBackend: types.MemoryBackendHugepages, HugepageSize: "2048"},
+		{MemMiB: 1025, MemoryBackend: types.MemoryBackendHugepages},
+		{MemMiB: 1024, MemoryBackend: types.MemoryBackendHugepages, HugepageSize: "1G", Sockets: 2},
+		{MemMiB: 1024, MemoryBackend: types.MemoryBackendRAM, Mounts: []types.Mount{virtiofs}},
+	}
+	for i := range invalid {
+		if err := checkMemoryBackend(&invalid[i]); err == nil {
+			t.Errorf("Expected error for %+v", invalid[i])
+		}
+	}
+}
+
+func TestHugepagesAvailable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	savedDir := hugepagesDir
+	hugepagesDir = dir
+	defer func() { hugepagesDir = savedDir }()
+
+	in := types.VMSpec{MemMiB: 1024, MemoryBackend: types.MemoryBackendHugepages}
+	if err := checkHugepagesAvailable(&in); err == nil {
+		t.Errorf("Expected error when hugepages are not supported")
+	}
+
+	pagesDir := path.Join(dir, "hugepages-2048kB")
+	if err := os.MkdirAll(pagesDir, 0755); err != nil {
+		t.Fatalf("Unable to create %s: %v", pagesDir, err)
+	}
+	writePages := func(total, free string) {
+		for f, v := range map[string]string{"nr_hugepages": total, "free_hugepages": free} {
+			if err := ioutil.WriteFile(path.Join(pagesDir, f), []byte(v+"\n"), 0644); err != nil {
+				t.Fatalf("Unable to write %s: %v", f, err)
+			}
+		}
+	}
+
+	writePages("600", "500")
+	err = checkHugepagesAvailable(&in)
+	if err == nil {
+		t.Fatalf("Expected error when too few hugepages are free")
+	}
+	if !strings.Contains(err.Error(), "echo 612 |") {
+		t.Errorf("Error does not suggest reserving enough hugepages: %v", err)
+	}
+
+	writePages("512", "512")
+	if err := checkHugepagesAvailable(&in); err != nil {
+		t.Errorf("Enough hugepages rejected: %v", err)
+	}
+}
+
+func TestQemuMemoryBackend(t *testing.T) {
+	tests := []struct {
+		in      types.VMSpec
+		shared  bool
+		backend string
+		options string
+	}{
+		{types.VMSpec{}, false, "", ""},
+		{types.VMSpec{}, true, "memory-backend-memfd", ",share=on"},
+		{types.VMSpec{MemoryBackend: types.MemoryBackendRAM}, true, "memory-backend-ram", ""},
+		{types.VMSpec{MemoryBackend: types.MemoryBackendMemfd}, false, "memory-backend-memfd", ",share=on"},
+		{types.VMSpec{MemoryBackend: types.MemoryBackendHugepages, HugepageSize: "1G"}, false,
+			"memory-backend-memfd", ",share=on,hugetlb=on,hugetlbsize=1048576K"},
+	}
+	for _, test := range tests {
+		backend, options, err := qemuMemoryBackend(&test.in, test.shared)
+		if err != nil || backend != test.backend || options != test.options {
+			t.Errorf("Unexpected backend for %+v: %s%s: %v", test.in, backend, options, err)
+		}
+	}
+
+	in := types.VMSpec{MemMiB: 2048, MemoryBackend: types.MemoryBackendHugepages}
+	backend, options, _ := qemuMemoryBackend(&in, false)
+	args := strings.Join(qemuNUMAArgs(&in, backend, options), " ")
+	expected := "-object memory-backend-memfd,id=mem,size=2048M,share=on,hugetlb=on,hugetlbsize=2048K " +
+		"-numa node,memdev=mem"
+	if args != expected {
+		t.Errorf("Unexpected memory arguments %s", args)
+	}
+}
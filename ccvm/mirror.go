@@ -0,0 +1,125 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// mirrorConfig redirects the downloads whose URLs start with Prefix to URL.
+// Proxy is either the URL of the proxy to use for the mirror or direct.
+type mirrorConfig struct {
+	Prefix string `yaml:"prefix"`
+	URL    string `yaml:"url"`
+	Proxy  string `yaml:"proxy"`
+}
+
+const directMirror = "direct"
+
+type mirrorList []mirrorConfig
+
+func checkMirrorURL(what, URL string) error {
+	u, err := url.Parse(URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("Invalid %s %s, expected an http or https URL", what, URL)
+	}
+	return nil
+}
+
+func (m *mirrorConfig) validate() error {
+	if err := checkMirrorURL("mirror prefix", m.Prefix); err != nil {
+		return err
+	}
+	if err := checkMirrorURL("mirror", m.URL); err != nil {
+		return err
+	}
+	if m.Proxy != "" && m.Proxy != directMirror {
+		if _, err := url.Parse(m.Proxy); err != nil {
+			return errors.Errorf("Invalid proxy %s of mirror %s", m.Proxy, m.URL)
+		}
+	}
+	return nil
+}
+
+func (l mirrorList) validate() error {
+	for i := range l {
+		if err := l[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withOverrides returns the mirrors of l preceded by the mirrors
+// specified when creating an instance, which map prefixes to mirror URLs.
+func (l mirrorList) withOverrides(overrides map[string]string) (mirrorList, error) {
+	if len(overrides) == 0 {
+		return l, nil
+	}
+
+	prefixes := make([]string, 0, len(overrides))
+	for p := range overrides {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	mirrors := make(mirrorList, 0, len(overrides)+len(l))
+	for _, p := range prefixes {
+		m := mirrorConfig{Prefix: p, URL: overrides[p]}
+		if err := m.validate(); err != nil {
+			return nil, err
+		}
+		mirrors = append(mirrors, m)
+	}
+	return append(mirrors, l...), nil
+}
+
+// resolve returns the URL from which URL is to be downloaded and the
+// transport used to download it.  The mirror with the longest matching
+// prefix is used.  Of mirrors with the same prefix, the first one wins.
+func (l mirrorList) resolve(URL string, transport *http.Transport) (string, *http.Transport) {
+	var best *mirrorConfig
+	for i := range l {
+		m := &l[i]
+		if !strings.HasPrefix(URL, m.Prefix) {
+			continue
+		}
+		if best == nil || len(m.Prefix) > len(best.Prefix) {
+			best = m
+		}
+	}
+	if best == nil {
+		return URL, transport
+	}
+
+	mirrored := strings.TrimSuffix(best.URL, "/") + "/" +
+		strings.TrimPrefix(URL[len(best.Prefix):], "/")
+	switch best.Proxy {
+	case "":
+	case directMirror:
+		transport = getHTTPTransport("", "", "")
+	default:
+		transport = getHTTPTransport(best.Proxy, best.Proxy, "")
+	}
+	logInfof("Downloading %s from mirror %s", URL, mirrored)
+	return mirrored, transport
+}
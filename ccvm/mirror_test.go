@@ -0,0 +1,69 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMirrorResolve(t *testing.T) {
+	mirrors := mirrorList{
+		{Prefix: "https://cloud-images.ubuntu.com/", URL: "https://mirror.example.com/ubuntu"},
+		{Prefix: "https://cloud-images.ubuntu.com/releases/", URL: "https://releases.example.com/", Proxy: "direct"},
+	}
+	if err := mirrors.validate(); err != nil {
+		t.Fatalf("Valid mirrors rejected: %v", err)
+	}
+
+	transport := getHTTPTransport("http://proxy.example.com:3128", "", "")
+	tests := []struct {
+		URL      string
+		expected string
+		direct   bool
+	}{
+		{"https://cloud-images.ubuntu.com/xenial/current/img", "https://mirror.example.com/ubuntu/xenial/current/img", false},
+		{"https://cloud-images.ubuntu.com/releases/18.04/img", "https://releases.example.com/18.04/img", true},
+		{"https://download.fedoraproject.org/img", "https://download.fedoraproject.org/img", false},
+	}
+	for _, test := range tests {
+		URL, tr := mirrors.resolve(test.URL, transport)
+		if URL != test.expected {
+			t.Errorf("%s resolved to %s, expected %s", test.URL, URL, test.expected)
+		}
+		req, _ := http.NewRequest("GET", "http://"+test.expected[len("https://"):], nil)
+		proxy, _ := tr.Proxy(req)
+		if (proxy == nil) != test.direct {
+			t.Errorf("Unexpected proxy %v for %s", proxy, URL)
+		}
+	}
+
+	overridden, err := mirrors.withOverrides(map[string]string{
+		"https://cloud-images.ubuntu.com/": "http://local.example.com/",
+	})
+	if err != nil {
+		t.Fatalf("Valid override rejected: %v", err)
+	}
+	URL, _ := overridden.resolve("https://cloud-images.ubuntu.com/bionic/img", transport)
+	if URL != "http://local.example.com/bionic/img" {
+		t.Errorf("Override not applied: %s", URL)
+	}
+
+	if _, err := mirrors.withOverrides(map[string]string{"ubuntu": "ftp://x"}); err == nil {
+		t.Errorf("Invalid override accepted")
+	}
+}
@@ -61,6 +61,7 @@ func createMockWorkSpaceWithWorkload(workload, workloadName, ccvmDir string) (*w
 
 	ws := &workspace{
 		ccvmDir: ccvmDir,
+		network: defaultNetwork(),
 	}
 
 	workloadFile := path.Join(workloadDir, workloadName+".yaml")
@@ -73,7 +74,11 @@ func createMockWorkSpaceWithWorkload(workload, workloadName, ccvmDir string) (*w
 }
 
 func createMockWorkSpaceWithInstance(workload, ccvmDir string) (*workspace, error) {
-	instanceDir, err := ioutil.TempDir(ccvmDir, "wkl-")
+	instancesDir := path.Join(ccvmDir, "instances")
+	if err := os.MkdirAll(instancesDir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "Failed to create directory %s", instancesDir)
+	}
+	instanceDir, err := ioutil.TempDir(instancesDir, "wkl-")
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to create directory %s", instanceDir)
 	}
@@ -81,12 +86,22 @@ func createMockWorkSpaceWithInstance(workload, ccvmDir string) (*workspace, erro
 	ws := &workspace{
 		ccvmDir:     ccvmDir,
 		instanceDir: instanceDir,
+		network:     defaultNetwork(),
 	}
 
-	workloadFile := path.Join(ws.instanceDir, "state.yaml")
-	err = ioutil.WriteFile(workloadFile, []byte(workload), 0640)
+	docs := splitYaml([]byte(workload))
+	if len(docs) != 2 {
+		return nil, errors.New("Invalid workload; must have two documents")
+	}
+	st, err := instanceStore(instanceDir)
+	if err == nil {
+		rec := workloadRecord{Spec: string(docs[0]), UserData: string(docs[1])}
+		err = st.update(func(tx *storeTx) error {
+			return tx.put(bucketSpecs, path.Base(instanceDir), &rec)
+		})
+	}
 	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to write workload file %s", workloadFile)
+		return nil, errors.Wrap(err, "Failed to store workload")
 	}
 
 	return ws, nil
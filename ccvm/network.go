@@ -0,0 +1,561 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Named networks are stored in the networks directory, one file per
+// network.  The default network is not stored.  The host, DNS server and
+// guest are given the same offsets within the subnet of every network.
+
+const (
+	networksDir          = "networks"
+	defaultSubnet        = "10.0.2.0/24"
+	networkHostOffset    = 2
+	networkDNSOffset     = 3
+	networkGuestOffset   = 15
+	networkReverseOffset = 100
+	networkMaxPrefix     = 25
+	defaultIPv6Subnet    = "fec0::/64"
+	guestMAC             = "52:54:00:12:34:56"
+)
+
+// vmNetwork is a validated network.
+type vmNetwork struct {
+	spec       types.NetworkSpec
+	subnet     *net.IPNet
+	ipv6Subnet *net.IPNet
+}
+
+var defaultNetworkSpec = types.NetworkSpec{
+	Name:       types.DefaultNetwork,
+	Subnet:     defaultSubnet,
+	IPv6Subnet: defaultIPv6Subnet,
+	Mode:       types.NetworkModeNAT,
+}
+
+// defaultNetwork returns the default network.
+func defaultNetwork() *vmNetwork {
+	_, subnet, _ := net.ParseCIDR(defaultSubnet)
+	_, ipv6Subnet, _ := net.ParseCIDR(defaultIPv6Subnet)
+	return &vmNetwork{spec: defaultNetworkSpec, subnet: subnet, ipv6Subnet: ipv6Subnet}
+}
+
+// networkName returns the name of the network selected by name, which may
+// be empty.
+func networkName(name string) string {
+	if name == "" {
+		return types.DefaultNetwork
+	}
+	return name
+}
+
+// checkNetwork validates spec and fills in its defaults.
+func checkNetwork(spec *types.NetworkSpec) (*vmNetwork, error) {
+	if !hostnameRegexp.MatchString(spec.Name) {
+		return nil, errors.Errorf("Invalid network name %s", spec.Name)
+	}
+
+	switch spec.Mode {
+	case "":
+		spec.Mode = types.NetworkModeNAT
+	case types.NetworkModeNAT, types.NetworkModeRestricted:
+	default:
+		return nil, errors.Errorf("Invalid network mode %s", spec.Mode)
+	}
+
+	if spec.Subnet == "" {
+		spec.Subnet = defaultSubnet
+	}
+	_, subnet, err := net.ParseCIDR(spec.Subnet)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid subnet %s", spec.Subnet)
+	}
+	ones, bits := subnet.Mask.Size()
+	if bits != 32 || subnet.IP.To4() == nil {
+		return nil, errors.Errorf("Subnet %s is not an IPv4 subnet", spec.Subnet)
+	}
+	if ones > networkMaxPrefix {
+		return nil, errors.Errorf("Subnet %s is too small, the prefix must not exceed /%d",
+			spec.Subnet, networkMaxPrefix)
+	}
+	spec.Subnet = subnet.String()
+
+	if spec.IPv6Subnet == "" {
+		spec.IPv6Subnet = defaultIPv6Subnet
+	}
+	_, ipv6Subnet, err := net.ParseCIDR(spec.IPv6Subnet)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid IPv6 subnet %s", spec.IPv6Subnet)
+	}
+	ones, bits = ipv6Subnet.Mask.Size()
+	if bits != 128 || ipv6Subnet.IP.To4() != nil {
+		return nil, errors.Errorf("Subnet %s is not an IPv6 subnet", spec.IPv6Subnet)
+	}
+	if ones != 64 {
+		return nil, errors.Errorf("IPv6 subnet %s must be a /64 prefix", spec.IPv6Subnet)
+	}
+	spec.IPv6Subnet = ipv6Subnet.String()
+
+	for _, s := range spec.DNSSearch {
+		if s == "" || strings.ContainsAny(s, ", ") {
+			return nil, errors.Errorf("Invalid DNS search domain %q", s)
+		}
+	}
+
+	return &vmNetwork{spec: *spec, subnet: subnet, ipv6Subnet: ipv6Subnet}, nil
+}
+
+func (n *vmNetwork) addr(offset uint32) string {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(n.subnet.IP.To4())+offset)
+	return ip.String()
+}
+
+// hostIP returns the address of the host as seen from the guest.
+func (n *vmNetwork) hostIP() string {
+	return n.addr(networkHostOffset)
+}
+
+// dnsIP returns the address of the DNS server as seen from the guest.
+func (n *vmNetwork) dnsIP() string {
+	return n.addr(networkDNSOffset)
+}
+
+// guestIP returns the address assigned to the guest.
+func (n *vmNetwork) guestIP() string {
+	return n.addr(networkGuestOffset)
+}
+
+// reverseForwardIP returns the address at which the guest reaches the
+// services exposed by its reverse port forwards.
+func (n *vmNetwork) reverseForwardIP() string {
+	return n.addr(networkReverseOffset)
+}
+
+// ipv6Addr returns the address of the network's IPv6 subnet whose interface
+// identifier is id.
+func (n *vmNetwork) ipv6Addr(id []byte) string {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, n.ipv6Subnet.IP)
+	copy(ip[net.IPv6len-len(id):], id)
+	return ip.String()
+}
+
+// hostIPv6 returns the IPv6 address of the host as seen from the guest.
+func (n *vmNetwork) hostIPv6() string {
+	return n.ipv6Addr([]byte{networkHostOffset})
+}
+
+// dnsIPv6 returns the IPv6 address of the DNS server as seen from the guest.
+func (n *vmNetwork) dnsIPv6() string {
+	return n.ipv6Addr([]byte{networkDNSOffset})
+}
+
+// guestMACAddress returns the MAC address of the NIC of the VM described by
+// in.
+func guestMACAddress(in *types.VMSpec) string {
+	if in.MACAddress != "" {
+		return in.MACAddress
+	}
+	return guestMAC
+}
+
+// checkGuestIdentity validates the MAC address and the hostname of the VM
+// described by in.  The MAC address is normalised.
+func checkGuestIdentity(in *types.VMSpec) error {
+	if in.MACAddress != "" {
+		mac, err := net.ParseMAC(in.MACAddress)
+		if err != nil || len(mac) != 6 {
+			return errors.Errorf("Invalid MAC address %s", in.MACAddress)
+		}
+		if mac[0]&1 != 0 {
+			return errors.Errorf("MAC address %s is a multicast address", in.MACAddress)
+		}
+		in.MACAddress = mac.String()
+	}
+
+	if in.Hostname != "" && !hostnameRegexp.MatchString(in.Hostname) {
+		return errors.Errorf("Invalid hostname %s", in.Hostname)
+	}
+
+	return nil
+}
+
+// guestInterfaceID returns the modified EUI-64 interface identifier derived
+// from mac, the MAC address of the guest's NIC.
+func guestInterfaceID(mac string) []byte {
+	hw, _ := net.ParseMAC(mac)
+	return []byte{hw[0] ^ 0x02, hw[1], hw[2], 0xff, 0xfe, hw[3], hw[4], hw[5]}
+}
+
+// guestIPv6 returns the global IPv6 address configured by a guest whose NIC
+// has the MAC address mac.
+func (n *vmNetwork) guestIPv6(mac string) string {
+	return n.ipv6Addr(guestInterfaceID(mac))
+}
+
+// guestLinkLocalIPv6 returns the link-local IPv6 address of a guest whose
+// NIC has the MAC address mac.
+func guestLinkLocalIPv6(mac string) string {
+	ip := make(net.IP, net.IPv6len)
+	ip[0], ip[1] = 0xfe, 0x80
+	copy(ip[8:], guestInterfaceID(mac))
+	return ip.String()
+}
+
+// isDefault returns true if n is the default network.
+func (n *vmNetwork) isDefault() bool {
+	return n.spec.Name == types.DefaultNetwork
+}
+
+// checkHostIP returns an error if the port mappings of an instance of the
+// network cannot be exposed on hostIP.
+func (n *vmNetwork) checkHostIP(hostIP net.IP) error {
+	if n.spec.Isolated && len(hostIP) != 0 && !hostIP.IsLoopback() {
+		return errors.Errorf("Network %s is isolated, %s is not a loopback address",
+			n.spec.Name, hostIP)
+	}
+	return nil
+}
+
+// checkPorts returns an error if the host addresses of the port mappings
+// of an instance of the network are invalid.
+func (n *vmNetwork) checkPorts(ports []types.PortMapping) error {
+	for _, p := range ports {
+		if p.HostAddr == "" {
+			continue
+		}
+		ip := net.ParseIP(p.HostAddr)
+		if ip == nil {
+			return errors.Errorf("Invalid host address %s in port mapping %s", p.HostAddr, p)
+		}
+		if err := n.checkHostIP(ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hypervisors without user mode networking connect their VMs to a tap
+// device.  Each instance is given its own subnet of tapPool, unique to the
+// host.  tapSubnets records the subnets of instances being created.
+
+const (
+	tapPool       = "10.200.0.0/16"
+	tapSubnetBits = 27
+)
+
+var tapSubnets = struct {
+	sync.Mutex
+	m map[string]struct{}
+}{m: make(map[string]struct{})}
+
+// usesTap returns true if the VM described by in is connected to a tap
+// device.
+func usesTap(in *types.VMSpec) bool {
+	return in.Hypervisor == hypervisorFirecracker || in.Hypervisor == hypervisorCloudHypervisor
+}
+
+// allocateTap reserves a free tap subnet, and the guest address within it,
+// for the instance being created.  The subnet is released by releaseTap.
+func (ws *workspace) allocateTap(in *types.VMSpec) error {
+	in.TapSubnet = ""
+	in.GuestIP = nil
+	if !usesTap(in) {
+		return nil
+	}
+
+	tapSubnets.Lock()
+	defer tapSubnets.Unlock()
+
+	used := make(map[string]struct{})
+	for subnet := range tapSubnets.m {
+		used[subnet] = struct{}{}
+	}
+	instanceDirs, _ := filepath.Glob(ws.hostInstancesPattern())
+	for _, instanceDir := range instanceDirs {
+		iws := *ws
+		iws.instanceDir = instanceDir
+		wkld, err := restoreWorkload(&iws)
+		if err == nil && wkld.spec.VM.TapSubnet != "" {
+			used[wkld.spec.VM.TapSubnet] = struct{}{}
+		}
+	}
+
+	_, pool, _ := net.ParseCIDR(tapPool)
+	ones, _ := pool.Mask.Size()
+	base := binary.BigEndian.Uint32(pool.IP.To4())
+	for i := uint32(0); i < 1<<uint(tapSubnetBits-ones); i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, base+i<<uint(32-tapSubnetBits))
+		subnet := &net.IPNet{IP: ip, Mask: net.CIDRMask(tapSubnetBits, 32)}
+		if _, ok := used[subnet.String()]; ok {
+			continue
+		}
+
+		tapSubnets.m[subnet.String()] = struct{}{}
+		n := vmNetwork{subnet: subnet}
+		in.TapSubnet = subnet.String()
+		in.GuestIP = net.ParseIP(n.guestIP())
+		return nil
+	}
+
+	return errors.New("No tap subnets left")
+}
+
+// releaseTap releases a subnet reserved by allocateTap.
+func releaseTap(subnet string) {
+	tapSubnets.Lock()
+	delete(tapSubnets.m, subnet)
+	tapSubnets.Unlock()
+}
+
+// withTap returns the network of the instance whose VM, described by in,
+// is connected to n.  The subnet of n is replaced by the tap subnet of the
+// VM, if it has one.
+func (n *vmNetwork) withTap(in *types.VMSpec) (*vmNetwork, error) {
+	if in.TapSubnet == "" {
+		return n, nil
+	}
+
+	_, subnet, err := net.ParseCIDR(in.TapSubnet)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid tap subnet %s", in.TapSubnet)
+	}
+	tn := *n
+	tn.spec.Subnet = subnet.String()
+	tn.subnet = subnet
+	return &tn, nil
+}
+
+// instanceNetwork returns the network of the instance whose VM is described
+// by in.
+func instanceNetwork(ccvmDir string, in *types.VMSpec) (*vmNetwork, error) {
+	n, err := loadNetwork(ccvmDir, in.Network)
+	if err != nil {
+		return nil, err
+	}
+	return n.withTap(in)
+}
+
+// tapGuestIP returns the address of the guest of the VM in, which is
+// connected to a tap device.  Instances created before tap subnets were
+// allocated use the subnet of the default network.
+func tapGuestIP(in *types.VMSpec) net.IP {
+	if in.GuestIP != nil {
+		return in.GuestIP
+	}
+	return net.ParseIP(defaultNetwork().guestIP())
+}
+
+func networkPath(ccvmDir, name string) string {
+	return path.Join(ccvmDir, networksDir, name+".yaml")
+}
+
+// loadNetwork returns the network called name.  An empty name selects the
+// default network.
+func loadNetwork(ccvmDir, name string) (*vmNetwork, error) {
+	name = networkName(name)
+	if name == types.DefaultNetwork {
+		return defaultNetwork(), nil
+	}
+
+	data, err := ioutil.ReadFile(networkPath(ccvmDir, name))
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("Network %s does not exist", name)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read network %s", name)
+	}
+
+	var spec types.NetworkSpec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse network %s", name)
+	}
+
+	return checkNetwork(&spec)
+}
+
+func (c ccvmBackend) createNetwork(ctx context.Context, spec *types.NetworkSpec) error {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	if spec.Name == types.DefaultNetwork {
+		return errors.Errorf("Network %s already exists", spec.Name)
+	}
+
+	if _, err := checkNetwork(spec); err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(path.Join(ws.ccvmDir, networksDir), 0700)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create networks directory")
+	}
+
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal network")
+	}
+
+	f, err := os.OpenFile(networkPath(ws.ccvmDir, spec.Name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return errors.Errorf("Network %s already exists", spec.Name)
+	} else if err != nil {
+		return errors.Wrapf(err, "Unable to create network %s", spec.Name)
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(networkPath(ws.ccvmDir, spec.Name))
+		return errors.Wrapf(err, "Unable to write network %s", spec.Name)
+	}
+
+	return nil
+}
+
+func (c ccvmBackend) deleteNetwork(ctx context.Context, name string) error {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	if !hostnameRegexp.MatchString(name) {
+		return errors.Errorf("Invalid network name %s", name)
+	}
+
+	err = os.Remove(networkPath(ws.ccvmDir, name))
+	if os.IsNotExist(err) {
+		return errors.Errorf("Network %s does not exist", name)
+	} else if err != nil {
+		return errors.Wrapf(err, "Unable to delete network %s", name)
+	}
+
+	return nil
+}
+
+// listNetworks returns the default network followed by the networks created
+// by the user, sorted by name.
+func (c ccvmBackend) listNetworks(ctx context.Context) ([]types.NetworkSpec, error) {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	specs := []types.NetworkSpec{defaultNetworkSpec}
+
+	files, err := ioutil.ReadDir(path.Join(ws.ccvmDir, networksDir))
+	if os.IsNotExist(err) {
+		return specs, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "Unable to read networks directory")
+	}
+
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".yaml") {
+			continue
+		}
+		n, err := loadNetwork(ws.ccvmDir, strings.TrimSuffix(fi.Name(), ".yaml"))
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, n.spec)
+	}
+
+	return specs, nil
+}
+
+// networkMembers returns the sorted names of the instances connected to each
+// network.
+func (s *ccvmService) networkMembers() map[string][]string {
+	members := make(map[string][]string)
+	for name, network := range s.networks {
+		members[network] = append(members[network], name)
+	}
+	for _, names := range members {
+		sort.Strings(names)
+	}
+	return members
+}
+
+func (s *ccvmService) createNetwork(ctx context.Context, spec *types.NetworkSpec, resultCh chan interface{}) {
+	go func() {
+		resultCh <- s.b.createNetwork(ctx, spec)
+		close(resultCh)
+	}()
+}
+
+// deleteNetwork deletes the network called name.  Networks cannot be deleted
+// while instances are connected to them.  The network is deleted in the
+// service's goroutine, where instances are connected to their networks, so
+// that no instance can be connected to a network being deleted.
+func (s *ccvmService) deleteNetwork(ctx context.Context, name string, resultCh chan interface{}) {
+	if name == types.DefaultNetwork {
+		resultCh <- errors.Errorf("Network %s cannot be deleted", name)
+		close(resultCh)
+		return
+	}
+
+	if names := s.networkMembers()[name]; len(names) > 0 {
+		resultCh <- errors.Errorf("Network %s is used by %s", name, strings.Join(names, ", "))
+		close(resultCh)
+		return
+	}
+
+	resultCh <- s.b.deleteNetwork(ctx, name)
+	close(resultCh)
+}
+
+func (s *ccvmService) listNetworks(ctx context.Context, resultCh chan interface{}) {
+	members := s.networkMembers()
+
+	go func() {
+		specs, err := s.b.listNetworks(ctx)
+		if err != nil {
+			resultCh <- err
+			close(resultCh)
+			return
+		}
+
+		networks := make([]types.NetworkInfo, len(specs))
+		for i := range specs {
+			networks[i].NetworkSpec = specs[i]
+			networks[i].Instances = members[specs[i].Name]
+		}
+		resultCh <- networks
+		close(resultCh)
+	}()
+}
@@ -0,0 +1,255 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestCheckNetwork(t *testing.T) {
+	spec := types.NetworkSpec{Name: "lab", Subnet: "192.168.50.7/24"}
+	n, err := checkNetwork(&spec)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if spec.Subnet != "192.168.50.0/24" || spec.Mode != types.NetworkModeNAT {
+		t.Errorf("Defaults not applied correctly %+v", spec)
+	}
+	if n.hostIP() != "192.168.50.2" || n.dnsIP() != "192.168.50.3" ||
+		n.guestIP() != "192.168.50.15" || n.reverseForwardIP() != "192.168.50.100" {
+		t.Errorf("Unexpected addresses %s %s %s %s", n.hostIP(), n.dnsIP(),
+			n.guestIP(), n.reverseForwardIP())
+	}
+
+	invalid := []types.NetworkSpec{
+		{Name: "my lab"},
+		{Name: "lab", Mode: "bridge"},
+		{Name: "lab", Subnet: "192.168.50.0"},
+		{Name: "lab", Subnet: "192.168.50.0/26"},
+		{Name: "lab", Subnet: "fd00::/64"},
+		{Name: "lab", DNSSearch: []string{"a.com,b.com"}},
+	}
+	for i := range invalid {
+		if _, err := checkNetwork(&invalid[i]); err == nil {
+			t.Errorf("Expected error for %+v", invalid[i])
+		}
+	}
+}
+
+func TestDefaultNetwork(t *testing.T) {
+	n := defaultNetwork()
+	if !n.isDefault() || n.hostIP() != "10.0.2.2" || n.guestIP() != "10.0.2.15" ||
+		n.reverseForwardIP() != types.ReverseForwardIP {
+		t.Errorf("Unexpected default network %+v", n.spec)
+	}
+}
+
+func TestLoadNetwork(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	for _, name := range []string{"", types.DefaultNetwork} {
+		n, err := loadNetwork(dir, name)
+		if err != nil || !n.isDefault() {
+			t.Errorf("Unable to load default network %q: %v", name, err)
+		}
+	}
+
+	if _, err := loadNetwork(dir, "lab"); err == nil {
+		t.Errorf("Expected error loading missing network")
+	}
+
+	err = os.MkdirAll(path.Join(dir, networksDir), 0700)
+	if err != nil {
+		t.Fatalf("Unable to create networks directory: %v", err)
+	}
+	data := "name: lab\nsubnet: 172.30.0.0/16\nmode: restricted\nisolated: true\n"
+	err = ioutil.WriteFile(networkPath(dir, "lab"), []byte(data), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write network: %v", err)
+	}
+
+	n, err := loadNetwork(dir, "lab")
+	if err != nil {
+		t.Fatalf("Unable to load network: %v", err)
+	}
+	if n.isDefault() || n.spec.Mode != types.NetworkModeRestricted || n.hostIP() != "172.30.0.2" {
+		t.Errorf("Unexpected network %+v", n.spec)
+	}
+
+	if err := n.checkHostIP(net.IPv4(127, 0, 0, 2)); err != nil {
+		t.Errorf("Loopback address rejected: %v", err)
+	}
+	if err := n.checkHostIP(net.IPv4(192, 168, 1, 10)); err == nil {
+		t.Errorf("Non loopback address accepted on isolated network")
+	}
+}
+
+func TestUserNetParam(t *testing.T) {
+	spec := types.NetworkSpec{
+		Name:       "lab",
+		Subnet:     "192.168.50.0/24",
+		IPv6Subnet: "fd00:50::/64",
+		Mode:       types.NetworkModeRestricted,
+		DNSSearch:  []string{"lab.example.com"},
+	}
+	n, err := checkNetwork(&spec)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ws := &workspace{network: n, dnsSearch: []string{"example.com"}}
+	in := &types.VMSpec{
+		HostIP: net.IPv4(127, 0, 0, 2),
+		PortMappings: []types.PortMapping{
+			{Host: 10022, Guest: 22},
+			{Host: 10022, Guest: 22, HostAddr: "::1"},
+		},
+		ReversePorts: []types.ReverseForward{{Guest: 3142, Host: "127.0.0.1:3142"}},
+	}
+
+	expected := "user,net=192.168.50.0/24,host=192.168.50.2,dns=192.168.50.3," +
+		"dhcpstart=192.168.50.15,restrict=on," +
+		"ipv6=on,ipv6-net=fd00:50::/64,ipv6-host=fd00:50::2,ipv6-dns=fd00:50::3," +
+		"hostfwd=tcp:127.0.0.2:10022-:22," +
+		"hostfwd=tcp:[::1]:10022-[fd00:50::5054:ff:fe12:3456]:22," +
+		"guestfwd=tcp:192.168.50.100:3142-tcp:127.0.0.1:3142," +
+		"dnssearch=lab.example.com,hostname=vm"
+	if p := userNetParam(ws, "vm", in); p != expected {
+		t.Errorf("Unexpected -net parameter %s", p)
+	}
+}
+
+func TestNetworkIPv6(t *testing.T) {
+	n := defaultNetwork()
+	if ip := n.guestIPv6(guestMAC); ip != "fec0::5054:ff:fe12:3456" {
+		t.Errorf("Unexpected guest IPv6 address %s", ip)
+	}
+	if ip := guestLinkLocalIPv6("02:00:5e:10:00:01"); ip != "fe80::5eff:fe10:1" {
+		t.Errorf("Unexpected guest link-local address %s", ip)
+	}
+
+	for _, subnet := range []string{"10.0.3.0/24", "fd00::/48", "fd00::1::/64"} {
+		spec := types.NetworkSpec{Name: "lab", IPv6Subnet: subnet}
+		if _, err := checkNetwork(&spec); err == nil {
+			t.Errorf("IPv6 subnet %s accepted", subnet)
+		}
+	}
+
+	spec := types.NetworkSpec{Name: "lab", Isolated: true}
+	n, err := checkNetwork(&spec)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n.spec.IPv6Subnet != defaultIPv6Subnet {
+		t.Errorf("Unexpected IPv6 subnet %s", n.spec.IPv6Subnet)
+	}
+	if err := n.checkPorts([]types.PortMapping{{Host: 8080, Guest: 80, HostAddr: "::1"}}); err != nil {
+		t.Errorf("IPv6 loopback address rejected: %v", err)
+	}
+	for _, addr := range []string{"2001:db8::1", "localhost"} {
+		if err := n.checkPorts([]types.PortMapping{{Host: 8080, Guest: 80, HostAddr: addr}}); err == nil {
+			t.Errorf("Host address %s accepted on isolated network", addr)
+		}
+	}
+}
+
+func TestGuestIdentity(t *testing.T) {
+	in := &types.VMSpec{MACAddress: "52-54-00-AB-CD-EF", Hostname: "builder"}
+	if err := checkGuestIdentity(in); err != nil {
+		t.Fatalf("Valid identity rejected: %v", err)
+	}
+	if in.MACAddress != "52:54:00:ab:cd:ef" || guestMACAddress(in) != in.MACAddress {
+		t.Errorf("MAC address not normalised %s", in.MACAddress)
+	}
+	if guestMACAddress(&types.VMSpec{}) != guestMAC {
+		t.Errorf("Unexpected default MAC address")
+	}
+
+	for _, in := range []types.VMSpec{
+		{MACAddress: "52:54:00:ab:cd"},
+		{MACAddress: "01:00:5e:00:00:01"},
+		{Hostname: "bad_host"},
+	} {
+		if err := checkGuestIdentity(&in); err == nil {
+			t.Errorf("Invalid identity %+v accepted", in)
+		}
+	}
+}
+
+func TestAllocateTap(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	ws := &workspace{ccvmDir: ccvmDir}
+
+	in := types.VMSpec{TapSubnet: "10.0.0.0/8"}
+	if err := ws.allocateTap(&in); err != nil || in.TapSubnet != "" || in.GuestIP != nil {
+		t.Errorf("Tap subnet assigned to a qemu instance %+v: %v", in, err)
+	}
+
+	// Subnets reserved by instances being created and used by existing
+	// instances are not allocated again.
+	in = types.VMSpec{Hypervisor: hypervisorFirecracker}
+	if err := ws.allocateTap(&in); err != nil {
+		t.Fatalf("Unable to allocate tap subnet: %v", err)
+	}
+	defer releaseTap(in.TapSubnet)
+	if in.TapSubnet != "10.200.0.0/27" || in.GuestIP.String() != "10.200.0.15" {
+		t.Errorf("Unexpected tap subnet %s and guest address %s", in.TapSubnet, in.GuestIP)
+	}
+
+	wkld := defaultWorkload()
+	wkld.spec.VM.Hypervisor = hypervisorFirecracker
+	wkld.spec.VM.TapSubnet = "10.200.0.32/27"
+	instanceDir := path.Join(ccvmDir, "instances", "fc")
+	if err := os.MkdirAll(instanceDir, 0755); err != nil {
+		t.Fatalf("Unable to create %s: %v", instanceDir, err)
+	}
+	if err := wkld.save(instanceDir); err != nil {
+		t.Fatalf("Unable to save workload: %v", err)
+	}
+
+	next := types.VMSpec{Hypervisor: hypervisorFirecracker}
+	if err := ws.allocateTap(&next); err != nil {
+		t.Fatalf("Unable to allocate tap subnet: %v", err)
+	}
+	releaseTap(next.TapSubnet)
+	if next.TapSubnet != "10.200.0.64/27" {
+		t.Errorf("Tap subnet %s allocated twice", next.TapSubnet)
+	}
+
+	n, err := instanceNetwork(ccvmDir, &next)
+	if err != nil {
+		t.Fatalf("Unable to load network: %v", err)
+	}
+	if n.hostIP() != "10.200.0.66" || n.guestIP() != next.GuestIP.String() || !n.isDefault() {
+		t.Errorf("Tap subnet not applied to network %+v", n.spec)
+	}
+}
@@ -0,0 +1,287 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Instance events can be sent to the notification sinks listed in the
+// daemon configuration file.  Failures to notify a sink are logged.
+
+const (
+	notifyWebhook = "webhook"
+	notifySlack   = "slack"
+	notifyEmail   = "email"
+)
+
+const notificationTimeout = 10 * time.Second
+
+const defaultNotificationMessage = "ccloudvm on {{.Host}}: instance {{.Name}} {{.Type}}"
+
+// notificationConfig describes a notification sink.  URL is used by webhook
+// and slack sinks, SMTPServer, Username, Password, From and To by email
+// sinks.  Notifications are sent for all events if Events is empty.
+type notificationConfig struct {
+	Type        string   `yaml:"type"`
+	Events      []string `yaml:"events"`
+	Template    string   `yaml:"template"`
+	URL         string   `yaml:"url"`
+	ContentType string   `yaml:"content_type"`
+	SMTPServer  string   `yaml:"smtp_server"`
+	Username    string   `yaml:"username"`
+	Password    string   `yaml:"password"`
+	From        string   `yaml:"from"`
+	To          []string `yaml:"to"`
+}
+
+// notificationData is the data passed to the templates of the notification
+// sinks.  Host is the name of the host on which the daemon runs.
+type notificationData struct {
+	Name string
+	Type string
+	Time time.Time
+	Host string
+}
+
+type notificationSink struct {
+	cfg    notificationConfig
+	events map[string]struct{}
+	tmpl   *template.Template
+}
+
+type notifier struct {
+	sinks []notificationSink
+	host  string
+}
+
+var notificationFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func newNotificationSink(i int, cfg notificationConfig) (notificationSink, error) {
+	sink := notificationSink{cfg: cfg}
+
+	tmpl := cfg.Template
+	switch cfg.Type {
+	case notifyWebhook:
+		if tmpl == "" {
+			tmpl = "{{json .}}"
+		}
+		fallthrough
+	case notifySlack:
+		if cfg.URL == "" {
+			return sink, errors.Errorf("Notification %d: no url specified", i+1)
+		}
+	case notifyEmail:
+		if cfg.SMTPServer == "" || cfg.From == "" || len(cfg.To) == 0 {
+			return sink, errors.Errorf("Notification %d: smtp_server, from and to must be specified", i+1)
+		}
+		if _, _, err := net.SplitHostPort(cfg.SMTPServer); err != nil {
+			return sink, errors.Wrapf(err, "Notification %d: invalid smtp_server", i+1)
+		}
+	default:
+		return sink, errors.Errorf("Notification %d: unknown type %q", i+1, cfg.Type)
+	}
+
+	if tmpl == "" {
+		tmpl = defaultNotificationMessage
+	}
+	t, err := template.New("notification").Funcs(notificationFuncs).Parse(tmpl)
+	if err != nil {
+		return sink, errors.Wrapf(err, "Notification %d: invalid template", i+1)
+	}
+	sink.tmpl = t
+
+	if len(cfg.Events) > 0 {
+		sink.events = make(map[string]struct{})
+		for _, e := range cfg.Events {
+			switch e {
+			case types.EventCreated, types.EventStarted, types.EventStopped,
+				types.EventDeleted, types.EventCrashed:
+			default:
+				return sink, errors.Errorf("Notification %d: unknown event %q", i+1, e)
+			}
+			sink.events[e] = struct{}{}
+		}
+	}
+
+	return sink, nil
+}
+
+// newNotifier validates the notification sinks in cfgs.  It returns nil if
+// there are none.
+func newNotifier(cfgs []notificationConfig) (*notifier, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+
+	n := &notifier{}
+	for i := range cfgs {
+		sink, err := newNotificationSink(i, cfgs[i])
+		if err != nil {
+			return nil, err
+		}
+		n.sinks = append(n.sinks, sink)
+	}
+
+	n.host, _ = os.Hostname()
+
+	return n, nil
+}
+
+func postNotification(url, contentType string, payload []byte) error {
+	client := &http.Client{Timeout: notificationTimeout}
+	resp, err := client.Post(url, contentType, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrapf(err, "Unable to post notification to %s", url)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("Unable to post notification to %s: %s", url, resp.Status)
+	}
+
+	return nil
+}
+
+func mailNotification(cfg *notificationConfig, subject, body string) error {
+	host, _, _ := net.SplitHostPort(cfg.SMTPServer)
+
+	conn, err := net.DialTimeout("tcp", cfg.SMTPServer, notificationTimeout)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to connect to %s", cfg.SMTPServer)
+	}
+	_ = conn.SetDeadline(time.Now().Add(notificationTimeout))
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return errors.Wrapf(err, "Unable to connect to %s", cfg.SMTPServer)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return errors.Wrap(err, "Unable to start TLS")
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, host)); err != nil {
+			return errors.Wrap(err, "Unable to authenticate")
+		}
+	}
+
+	if err := c.Mail(cfg.From); err != nil {
+		return errors.Wrap(err, "Unable to send notification")
+	}
+	for _, to := range cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return errors.Wrapf(err, "Unable to send notification to %s", to)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrap(err, "Unable to send notification")
+	}
+	fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		cfg.From, strings.Join(cfg.To, ", "), subject, body)
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "Unable to send notification")
+	}
+
+	return c.Quit()
+}
+
+func (s *notificationSink) send(data *notificationData) error {
+	var buf bytes.Buffer
+	if err := s.tmpl.Execute(&buf, data); err != nil {
+		return errors.Wrap(err, "Unable to execute notification template")
+	}
+
+	switch s.cfg.Type {
+	case notifyWebhook:
+		contentType := s.cfg.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		return postNotification(s.cfg.URL, contentType, buf.Bytes())
+	case notifySlack:
+		payload, err := json.Marshal(&struct {
+			Text string `json:"text"`
+		}{buf.String()})
+		if err != nil {
+			return errors.Wrap(err, "Unable to marshal notification")
+		}
+		return postNotification(s.cfg.URL, "application/json", payload)
+	case notifyEmail:
+		subject := fmt.Sprintf("ccloudvm: instance %s %s", data.Name, data.Type)
+		return mailNotification(&s.cfg, subject, buf.String())
+	}
+
+	return nil
+}
+
+func (n *notifier) notify(event types.InstanceEvent) {
+	data := notificationData{
+		Name: event.Name,
+		Type: event.Type,
+		Time: event.Time,
+		Host: n.host,
+	}
+
+	for i := range n.sinks {
+		sink := &n.sinks[i]
+		if sink.events != nil {
+			if _, ok := sink.events[event.Type]; !ok {
+				continue
+			}
+		}
+		if err := sink.send(&data); err != nil {
+			instanceLog(event.Name).Warningf("%v", err)
+		}
+	}
+}
+
+// run sends notifications for the events received on eventCh until it is
+// closed.
+func (n *notifier) run(eventCh <-chan interface{}) {
+	for e := range eventCh {
+		if event, ok := e.(types.InstanceEvent); ok {
+			n.notify(event)
+		}
+	}
+}
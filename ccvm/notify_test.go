@@ -0,0 +1,97 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestBadNotificationConfig(t *testing.T) {
+	tests := []notificationConfig{
+		{Type: "pager", URL: "http://localhost"},
+		{Type: notifyWebhook},
+		{Type: notifySlack},
+		{Type: notifyEmail, SMTPServer: "localhost:25", From: "ccvm@localhost"},
+		{Type: notifyEmail, SMTPServer: "localhost", From: "ccvm@localhost", To: []string{"ops@localhost"}},
+		{Type: notifyWebhook, URL: "http://localhost", Template: "{{.Name"},
+		{Type: notifyWebhook, URL: "http://localhost", Events: []string{"exploded"}},
+	}
+
+	for _, cfg := range tests {
+		if _, err := newNotifier([]notificationConfig{cfg}); err == nil {
+			t.Errorf("Expected %+v to be rejected", cfg)
+		}
+	}
+
+	n, err := newNotifier(nil)
+	if n != nil || err != nil {
+		t.Errorf("Expected no notifier, got %v: %v", n, err)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	payloads := make(chan []byte, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		payloads <- data
+	}))
+	defer ts.Close()
+
+	n, err := newNotifier([]notificationConfig{
+		{
+			Type:   notifyWebhook,
+			URL:    ts.URL,
+			Events: []string{types.EventCrashed},
+		},
+		{
+			Type:     notifySlack,
+			URL:      ts.URL,
+			Template: "{{.Name}} {{.Type}}",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+
+	n.notify(types.InstanceEvent{Name: "test-instance", Type: types.EventStarted})
+	if p := <-payloads; string(p) != `{"text":"test-instance started"}` {
+		t.Errorf("Unexpected slack payload %s", p)
+	}
+	if len(payloads) != 0 {
+		t.Errorf("Webhook notified of unselected event")
+	}
+
+	now := time.Now()
+	n.notify(types.InstanceEvent{Name: "test-instance", Type: types.EventCrashed, Time: now})
+	var data notificationData
+	if err := json.Unmarshal(<-payloads, &data); err != nil {
+		t.Errorf("Unable to unmarshal webhook payload: %v", err)
+	} else if data.Name != "test-instance" || data.Type != types.EventCrashed ||
+		!data.Time.Equal(now) || data.Host != n.host {
+		t.Errorf("Unexpected webhook payload %+v", data)
+	}
+	if p := <-payloads; string(p) != `{"text":"test-instance crashed"}` {
+		t.Errorf("Unexpected slack payload %s", p)
+	}
+}
@@ -0,0 +1,217 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Instances created offline may only use files already present in the
+// caches.  Their creation fails before the VM is booted if anything would
+// need to be downloaded.
+
+type offlineKey struct{}
+
+// withOffline marks the requests made with ctx as not being allowed to
+// download anything, if offline is true.
+func withOffline(ctx context.Context, offline bool) context.Context {
+	if !offline {
+		return ctx
+	}
+	return context.WithValue(ctx, offlineKey{}, true)
+}
+
+func offlineFromContext(ctx context.Context) bool {
+	offline, _ := ctx.Value(offlineKey{}).(bool)
+	return offline
+}
+
+// errNotCached is returned when a file that is not cached is requested
+// offline.
+func errNotCached(what string) error {
+	return errors.Errorf("%s is not cached and cannot be downloaded offline.  Run ccloudvm prefetch first", what)
+}
+
+func isDownloadURL(URL string) bool {
+	u, err := url.Parse(URL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// workloadDownloads returns the URLs of the files downloaded by the host
+// when an instance of wkld is created, once its cloud-init documents have
+// been executed.  Mirrors are taken into account.
+func workloadDownloads(wkld *workload, ws *workspace) []string {
+	var URLs []string
+	for _, URL := range []string{wkld.spec.BIOS, wkld.spec.Kernel, wkld.spec.VirtioDrivers} {
+		if URL == "" {
+			continue
+		}
+		URL, _ = ws.mirrors.resolve(URL, nil)
+		if isDownloadURL(URL) {
+			URLs = append(URLs, URL)
+		}
+	}
+	if _, isLocal, err := localImagePath(wkld.spec.BaseImageURL); err == nil && !isLocal {
+		URL, _ := ws.mirrors.resolve(wkld.spec.BaseImageURL, nil)
+		URLs = append(URLs, URL)
+	}
+	return append(URLs, ws.downloads...)
+}
+
+// checkOfflineDownloads checks that all the files downloaded by the host
+// during the creation of an instance of wkld are cached.  It is only
+// called for offline creations, so no download is started.
+func checkOfflineDownloads(ctx context.Context, wkld *workload, ws *workspace,
+	downloadCh chan<- downloadRequest) error {
+	for _, URL := range workloadDownloads(wkld, ws) {
+		if _, err := downloadFile(ctx, downloadCh, nil, URL, func(bool, progress) {}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefetch downloads the workload identified by args.WorkloadName, its
+// ancestors and all the files needed to create an instance of it, so that
+// instances of the workload can later be created offline.  The roles of
+// group workloads are prefetched in turn.
+func (c ccvmBackend) prefetch(ctx context.Context, resultCh chan interface{},
+	downloadCh chan<- downloadRequest, args *types.CreateArgs) error {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	transport := getHTTPTransport(args.HTTPProxy, args.HTTPSProxy, args.NoProxy)
+	data, err := loadWorkloadData(ctx, ws, args.WorkloadName, transport)
+	if err != nil {
+		return err
+	}
+
+	if len(splitYaml(data)) == 2 {
+		return c.prefetchWorkload(ctx, resultCh, downloadCh, args, nil)
+	}
+
+	spec, err := parseGroupSpec(args.WorkloadName, data)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, role := range spec.Roles {
+		if seen[role.Workload] {
+			continue
+		}
+		seen[role.Workload] = true
+
+		roleArgs := *args
+		roleArgs.WorkloadName = role.Workload
+		err = c.prefetchWorkload(ctx, resultCh, downloadCh, &roleArgs,
+			&types.GroupInfo{Name: args.WorkloadName, Role: role.Name, Index: 1})
+		if err != nil {
+			return errors.Wrapf(err, "Unable to prefetch role %s", role.Name)
+		}
+	}
+	return nil
+}
+
+func (c ccvmBackend) prefetchWorkload(ctx context.Context, resultCh chan interface{},
+	downloadCh chan<- downloadRequest, args *types.CreateArgs, group *types.GroupInfo) error {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	ws.HTTPProxy = args.HTTPProxy
+	ws.HTTPSProxy = args.HTTPSProxy
+	ws.NoProxy = args.NoProxy
+	ws.GoPath = args.GoPath
+	ws.Group = group
+	ws.PackageUpgrade = "false"
+	ws.retry = c.retryPolicy()
+	ws.mirrors, err = c.mirrors().withOverrides(args.Mirrors)
+	if err != nil {
+		return err
+	}
+
+	transport := getHTTPTransport(ws.HTTPProxy, ws.HTTPSProxy, ws.NoProxy)
+	resultCh <- types.CreateResult{
+		Line: fmt.Sprintf("Prefetching workload %s\n", args.WorkloadName),
+	}
+	wkld, err := createWorkload(ctx, ws, args.WorkloadName, transport)
+	if err != nil {
+		return err
+	}
+
+	// The cloud-init documents are executed to find out which files they
+	// ask the host to download.
+	ws.Params, err = resolveParams(wkld.spec.Params, args.Params, group != nil)
+	if err != nil {
+		return err
+	}
+	ws.Distro = wkld.spec.Distro
+	if _, err = wkld.parse(ws, map[string]bool{}); err != nil {
+		return errors.Wrap(err, "Error parsing workload")
+	}
+
+	if _, _, err = downloadImages(ctx, wkld, ws, transport, resultCh, downloadCh); err != nil {
+		return err
+	}
+
+	if wkld.spec.Kernel != "" {
+		kernelURL, kernelTransport := ws.mirrors.resolve(wkld.spec.Kernel, transport)
+		_, err = downloadURI(ctx, ws, kernelURL, wkld.spec.KernelSHA256, kernelTransport,
+			resultCh, downloadCh)
+		if err != nil {
+			return err
+		}
+	}
+
+	if wkld.spec.VirtioDrivers != "" {
+		virtioURL, virtioTransport := ws.mirrors.resolve(wkld.spec.VirtioDrivers, transport)
+		_, err = downloadURI(ctx, ws, virtioURL, wkld.spec.VirtioSHA256, virtioTransport,
+			resultCh, downloadCh)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, URL := range ws.downloads {
+		_, err = downloadURI(ctx, ws, URL, "", transport, resultCh, downloadCh)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *ccvmService) prefetch(ctx context.Context, resultCh chan interface{}, args *types.CreateArgs) {
+	go func() {
+		err := s.b.prefetch(ctx, resultCh, s.downloadCh, args)
+		if err != nil {
+			resultCh <- err
+		} else {
+			resultCh <- types.CreateResult{Finished: true}
+		}
+		close(resultCh)
+	}()
+}
@@ -0,0 +1,178 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Workloads can declare parameters, supplied when instances are created and
+// available to cloud-init templates as .Params.<name>.  Parameters without
+// a default value must be supplied.
+
+const (
+	paramString = "string"
+	paramInt    = "int"
+	paramBool   = "bool"
+)
+
+var paramNameRegexp = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+type workloadParam struct {
+	Name        string  `yaml:"name"`
+	Type        string  `yaml:"type,omitempty"`
+	Default     *string `yaml:"default,omitempty"`
+	Description string  `yaml:"description,omitempty"`
+}
+
+func (p *workloadParam) parse(value string) (interface{}, error) {
+	switch p.Type {
+	case "", paramString:
+		return value, nil
+	case paramInt:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.Errorf("Parameter %s must be an integer, got %q", p.Name, value)
+		}
+		return i, nil
+	case paramBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.Errorf("Parameter %s must be a boolean, got %q", p.Name, value)
+		}
+		return b, nil
+	}
+
+	return nil, errors.Errorf("Parameter %s has an invalid type %s", p.Name, p.Type)
+}
+
+// mergeParams returns the parameters declared by a workload, params, followed
+// by the parameters of its parent that it does not redeclare.
+func mergeParams(params, parent []workloadParam) []workloadParam {
+	declared := make(map[string]bool, len(params))
+	for _, p := range params {
+		declared[p.Name] = true
+	}
+	for _, p := range parent {
+		if !declared[p.Name] {
+			params = append(params, p)
+			declared[p.Name] = true
+		}
+	}
+	return params
+}
+
+// checkParams checks the names, types and default values of the parameters
+// declared by a workload.
+func checkParams(params []workloadParam) error {
+	seen := make(map[string]bool, len(params))
+	for i := range params {
+		p := &params[i]
+		if !paramNameRegexp.MatchString(p.Name) {
+			return errors.Errorf("Invalid parameter name %q", p.Name)
+		}
+		if seen[p.Name] {
+			return errors.Errorf("Parameter %s declared more than once", p.Name)
+		}
+		seen[p.Name] = true
+
+		switch p.Type {
+		case "", paramString, paramInt, paramBool:
+		default:
+			return errors.Errorf("Parameter %s has an invalid type %s, expected %s, %s or %s",
+				p.Name, p.Type, paramString, paramInt, paramBool)
+		}
+		if p.Default != nil {
+			if _, err := p.parse(*p.Default); err != nil {
+				return errors.Wrap(err, "Invalid default value")
+			}
+		}
+	}
+	return nil
+}
+
+// resolveParams returns the values of the parameters declared by a workload,
+// from the values supplied by the user and the default values of the
+// parameters.  Supplied values for parameters that are not declared are
+// rejected, unless ignoreUnknown is true.
+func resolveParams(params []workloadParam, values map[string]string, ignoreUnknown bool) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(params))
+	declared := make(map[string]bool, len(params))
+	var missing []string
+	for i := range params {
+		p := &params[i]
+		declared[p.Name] = true
+		value, ok := values[p.Name]
+		if !ok {
+			if p.Default == nil {
+				missing = append(missing, p.Name)
+				continue
+			}
+			value = *p.Default
+		}
+		v, err := p.parse(value)
+		if err != nil {
+			return nil, err
+		}
+		resolved[p.Name] = v
+	}
+
+	if !ignoreUnknown {
+		var unknown []string
+		for name := range values {
+			if !declared[name] {
+				unknown = append(unknown, name)
+			}
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			return nil, errors.Errorf("Unknown workload parameters: %s", strings.Join(unknown, ", "))
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, errors.Errorf("Missing values for workload parameters: %s", strings.Join(missing, ", "))
+	}
+
+	return resolved, nil
+}
+
+// templateParams returns values for the parameters of a workload suitable
+// for rendering it outside of the creation of an instance.  Parameters
+// without a default value are given the zero value of their type, or their
+// name in angle brackets for strings.
+func templateParams(params []workloadParam) map[string]interface{} {
+	resolved := make(map[string]interface{}, len(params))
+	for i := range params {
+		p := &params[i]
+		value := "<" + p.Name + ">"
+		if p.Default != nil {
+			value = *p.Default
+		} else if p.Type == paramInt {
+			value = "0"
+		} else if p.Type == paramBool {
+			value = "false"
+		}
+		resolved[p.Name], _ = p.parse(value)
+	}
+	return resolved
+}
@@ -0,0 +1,145 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveParams(t *testing.T) {
+	version, debug := "1.9.2", "false"
+	params := []workloadParam{
+		{Name: "go_version", Default: &version},
+		{Name: "debug", Type: paramBool, Default: &debug},
+		{Name: "jobs", Type: paramInt},
+	}
+	if err := checkParams(params); err != nil {
+		t.Fatalf("Valid parameters rejected: %v", err)
+	}
+
+	resolved, err := resolveParams(params, map[string]string{"jobs": "4", "debug": "true"}, false)
+	if err != nil {
+		t.Fatalf("Unable to resolve parameters: %v", err)
+	}
+	if resolved["go_version"] != "1.9.2" || resolved["debug"] != true || resolved["jobs"] != 4 {
+		t.Errorf("Unexpected parameters %v", resolved)
+	}
+
+	failures := []map[string]string{
+		{},
+		{"jobs": "many"},
+		{"jobs": "4", "branch": "master"},
+	}
+	for _, values := range failures {
+		if _, err := resolveParams(params, values, false); err == nil {
+			t.Errorf("Invalid parameters %v accepted", values)
+		}
+	}
+
+	if _, err := resolveParams(params, map[string]string{"jobs": "4", "branch": "master"}, true); err != nil {
+		t.Errorf("Unknown parameter not ignored: %v", err)
+	}
+
+	invalid := [][]workloadParam{
+		{{Name: "go-version"}},
+		{{Name: "jobs", Type: "float"}},
+		{{Name: "debug", Type: paramBool, Default: &version}},
+		{{Name: "jobs"}, {Name: "jobs"}},
+	}
+	for _, p := range invalid {
+		if err := checkParams(p); err == nil {
+			t.Errorf("Invalid parameters %+v accepted", p)
+		}
+	}
+}
+
+const paramsParentWorkload = `---
+params:
+  - name: branch
+    default: master
+  - name: jobs
+    type: int
+    default: 1
+...
+---
+#cloud-config
+runcmd:
+ - git clone -b {{.Params.branch}} https://example.com/repo.git
+...
+`
+
+const paramsChildWorkload = `---
+inherits: params-parent
+params:
+  - name: jobs
+    type: int
+    default: 2
+...
+---
+#cloud-config
+runcmd:
+ - make -j{{.Params.jobs}}
+...
+`
+
+func TestWorkloadParams(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+
+	defer func() {
+		if err := os.RemoveAll(ccvmDir); err != nil {
+			t.Errorf("Failed to remove %s : %v", ccvmDir, err)
+		}
+	}()
+
+	_, err = createMockWorkSpaceWithWorkload(paramsParentWorkload, "params-parent", ccvmDir)
+	if err != nil {
+		t.Fatalf("Failed to create mock workload: %v", err)
+	}
+	ws, err := createMockWorkSpaceWithWorkload(paramsChildWorkload, "params-child", ccvmDir)
+	if err != nil {
+		t.Fatalf("Failed to create mock workload: %v", err)
+	}
+
+	wkld, err := createWorkload(context.Background(), ws, "params-child", nil)
+	if err != nil {
+		t.Fatalf("Unable to create workload: %v", err)
+	}
+	if len(wkld.spec.Params) != 2 || wkld.spec.Params[0].Name != "jobs" ||
+		*wkld.spec.Params[0].Default != "2" {
+		t.Fatalf("Unexpected parameters %+v", wkld.spec.Params)
+	}
+
+	ws.Params, err = resolveParams(wkld.spec.Params, map[string]string{"branch": "dev"}, false)
+	if err != nil {
+		t.Fatalf("Unable to resolve parameters: %v", err)
+	}
+	if err = wkld.generateCloudConfig(ws); err != nil {
+		t.Fatalf("Unable to generate cloud config: %v", err)
+	}
+
+	cc := string(wkld.mergedUserData)
+	if !strings.Contains(cc, "git clone -b dev ") || !strings.Contains(cc, "make -j2") {
+		t.Errorf("Parameters not expanded in cloud config:\n%s", cc)
+	}
+}
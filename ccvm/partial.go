@@ -0,0 +1,236 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Images are downloaded to a .part file.  If the server supports range
+// requests, the progress is recorded in a .part-state file so that an
+// interrupted download can be resumed.
+
+const stateSuffix = "-state"
+
+var (
+	// parallelDownloadSize is the size from which files are downloaded
+	// in parallel chunks.
+	parallelDownloadSize int64 = 64 << 20
+
+	// downloadChunks is the number of chunks of a parallel download.
+	downloadChunks = 4
+
+	// partialFileLifetime is the time after which partially downloaded
+	// files are discarded rather than resumed.
+	partialFileLifetime = 7 * 24 * time.Hour
+)
+
+// errFileChanged is returned when the file being downloaded changed on
+// the server since the download started.
+var errFileChanged = errors.New("File changed on the server")
+
+// downloadChunk is the range [Start, End) of a file, of which the first
+// Done bytes have been downloaded.  End is -1 if the size of the file is
+// unknown.
+type downloadChunk struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  int64 `json:"done"`
+}
+
+// downloadState records the progress of a download.  Size is -1 if the
+// server does not report the size of the file.  ETag and LastModified
+// identify the version of the file being downloaded.
+type downloadState struct {
+	mu           sync.Mutex
+	URL          string          `json:"url"`
+	Size         int64           `json:"size"`
+	ETag         string          `json:"etag,omitempty"`
+	LastModified string          `json:"last_modified,omitempty"`
+	Ranges       bool            `json:"ranges"`
+	Chunks       []downloadChunk `json:"chunks"`
+}
+
+// split divides the file into the chunks to be downloaded.
+func (st *downloadState) split() {
+	if !st.Ranges || st.Size < parallelDownloadSize || downloadChunks < 2 {
+		st.Chunks = []downloadChunk{{Start: 0, End: st.Size}}
+		return
+	}
+
+	chunkSize := st.Size / int64(downloadChunks)
+	st.Chunks = make([]downloadChunk, downloadChunks)
+	for i := range st.Chunks {
+		st.Chunks[i].Start = int64(i) * chunkSize
+		st.Chunks[i].End = st.Chunks[i].Start + chunkSize
+	}
+	st.Chunks[len(st.Chunks)-1].End = st.Size
+}
+
+func (st *downloadState) chunk(i int) downloadChunk {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.Chunks[i]
+}
+
+// advance records that n more bytes of chunk i have been written.
+func (st *downloadState) advance(i, n int) {
+	st.mu.Lock()
+	st.Chunks[i].Done += int64(n)
+	st.mu.Unlock()
+}
+
+func (st *downloadState) downloaded() int64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var done int64
+	for _, c := range st.Chunks {
+		done += c.Done
+	}
+	return done
+}
+
+// validator returns the value of the If-Range header ensuring that the
+// chunks of the file come from the same version of the file.  Weak ETags
+// cannot be used in If-Range.
+func (st *downloadState) validator() string {
+	if st.ETag != "" && !strings.HasPrefix(st.ETag, "W/") {
+		return st.ETag
+	}
+	return st.LastModified
+}
+
+// resumes returns true if the download recorded in st can be resumed to
+// download the file described by cur.
+func (st *downloadState) resumes(cur *downloadState) bool {
+	if !cur.Ranges || st.URL != cur.URL || st.Size != cur.Size || len(st.Chunks) == 0 {
+		return false
+	}
+	if cur.validator() == "" {
+		return false
+	}
+	return st.ETag == cur.ETag && st.LastModified == cur.LastModified
+}
+
+// save records st in path once the data downloaded to f has reached the
+// disk, so that a crash cannot leave a state describing data that was
+// lost.  Failures are only logged as the download can still complete
+// without its state.
+func (st *downloadState) save(path string, f *os.File) {
+	if !st.Ranges {
+		return
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	err := f.Sync()
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(st)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		logWarningf("Unable to save download state %s: %v", path, err)
+	}
+}
+
+func loadDownloadState(path string) *downloadState {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var st downloadState
+	if err := json.Unmarshal(data, &st); err != nil {
+		logWarningf("Ignoring invalid download state %s: %v", path, err)
+		return nil
+	}
+	return &st
+}
+
+// progressCounter reports the progress of the chunks of a download, every
+// 10MB, and saves the state of the download at the same pace.
+type progressCounter struct {
+	mu         sync.Mutex
+	downloaded int64
+	totalMB    int
+	progressCh chan updateInfo
+	name       string
+	save       func()
+}
+
+func (pc *progressCounter) add(n int) {
+	pc.mu.Lock()
+	oldMB := pc.downloaded / 10000000
+	pc.downloaded += int64(n)
+	newMB := pc.downloaded / 10000000
+	pc.mu.Unlock()
+
+	if newMB > oldMB {
+		pc.save()
+		pc.progressCh <- updateInfo{
+			p: progress{
+				downloadedMB: int(newMB * 10),
+				totalMB:      pc.totalMB,
+			},
+			name: pc.name,
+		}
+	}
+}
+
+// lockCacheFile waits until no other process downloads the image imgPath
+// and locks it.  The lock is released by closing the returned file.
+func lockCacheFile(ctx context.Context, imgPath string) (*os.File, error) {
+	lockPath := imgPath + ".lock"
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to create %s", lockPath)
+	}
+
+	logged := false
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return f, nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			_ = f.Close()
+			return nil, errors.Wrapf(err, "Unable to lock %s", lockPath)
+		}
+
+		if !logged {
+			logInfof("Waiting for another process to download %s", imgPath)
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			_ = f.Close()
+			return nil, ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
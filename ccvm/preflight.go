@@ -0,0 +1,176 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+// The preflight checks verify that the daemon's host has everything needed
+// to create instances, so that problems are reported, along with their
+// fixes, before a long create fails halfway.  The checks that depend on the
+// host's platform, e.g., those of KVM, are implemented by accelChecks.
+
+const (
+	// minDiskGiB is the free disk space below which instances cannot
+	// be created reliably.
+	minDiskGiB = 5
+
+	proxyTimeout = 5 * time.Second
+)
+
+// hostTool is a tool, run by the daemon, that is not part of ccloudvm.
+type hostTool struct {
+	name    string
+	pkg     string
+	purpose string
+}
+
+var hostTools = []hostTool{
+	{"xorriso", "xorriso", "create the cloud-init images of instances"},
+	{"qemu-img", "qemu-utils or qemu-img", "create and convert disk images"},
+	{"ssh-keygen", "openssh-client or openssh", "generate the SSH keys of instances"},
+}
+
+func checkQemuPreflight(ctx context.Context, cfg *qemuConfig) types.PreflightCheck {
+	check := types.PreflightCheck{Name: "qemu", Status: types.PreflightOK}
+
+	binary, caps, err := checkQemu(ctx, cfg, types.ArchX86_64)
+	if err != nil {
+		check.Status = types.PreflightFailed
+		check.Detail = err.Error()
+		check.Fix = "Install qemu, e.g., from the qemu-system-x86 package, or set the path of a suitable binary in the qemu section of config.yaml"
+		if cfg.MinVersion != "" {
+			check.Fix = fmt.Sprintf("Install qemu %s or later, or set the path of a suitable binary in the qemu section of config.yaml",
+				cfg.MinVersion)
+		}
+		return check
+	}
+
+	check.Detail = fmt.Sprintf("%s version %s", binary, caps.version)
+	return check
+}
+
+func checkTool(t hostTool) types.PreflightCheck {
+	check := types.PreflightCheck{Name: t.name, Status: types.PreflightOK}
+
+	p, err := exec.LookPath(t.name)
+	if err != nil {
+		check.Status = types.PreflightFailed
+		check.Detail = fmt.Sprintf("%s, needed to %s, was not found", t.name, t.purpose)
+		check.Fix = fmt.Sprintf("Install %s, e.g., from the %s package", t.name, t.pkg)
+		return check
+	}
+
+	check.Detail = p
+	return check
+}
+
+// checkDiskSpace warns if the file system of dir does not have room for
+// the root disk of an instance of the default size.
+func checkDiskSpace(dir string) types.PreflightCheck {
+	check := types.PreflightCheck{Name: "Disk space", Status: types.PreflightOK}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		check.Status = types.PreflightWarning
+		check.Detail = fmt.Sprintf("Unable to determine the free space of %s: %v", dir, err)
+		return check
+	}
+
+	freeGiB := uint64(st.Bavail) * uint64(st.Bsize) >> 30
+	check.Detail = fmt.Sprintf("%d GiB free in %s", freeGiB, dir)
+	switch {
+	case freeGiB < minDiskGiB:
+		check.Status = types.PreflightFailed
+	case freeGiB < defaultRootFSSize:
+		check.Status = types.PreflightWarning
+	}
+	if check.Status != types.PreflightOK {
+		check.Fix = fmt.Sprintf("Free up space in %s, e.g., by deleting unused instances and volumes", dir)
+	}
+	return check
+}
+
+// checkProxy verifies that the proxy identified by the URL proxy, the value
+// of the environment variable envVar, accepts connections.
+func checkProxy(ctx context.Context, envVar, proxy string) types.PreflightCheck {
+	check := types.PreflightCheck{Name: envVar, Status: types.PreflightOK}
+
+	u, err := url.Parse(proxy)
+	if err != nil || u.Hostname() == "" {
+		check.Status = types.PreflightFailed
+		check.Detail = fmt.Sprintf("Invalid proxy %s", proxy)
+		check.Fix = fmt.Sprintf("Set %s to the URL of the proxy, e.g., http://proxy.example.com:3128", envVar)
+		return check
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	address := net.JoinHostPort(u.Hostname(), port)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, proxyTimeout)
+	defer cancel()
+	d := &net.Dialer{}
+	conn, err := d.DialContext(timeoutCtx, "tcp", address)
+	if err != nil {
+		check.Status = types.PreflightFailed
+		check.Detail = fmt.Sprintf("Unable to connect to %s: %v", address, err)
+		check.Fix = fmt.Sprintf("Check that %s identifies a proxy reachable from the host", envVar)
+		return check
+	}
+	_ = conn.Close()
+
+	check.Detail = fmt.Sprintf("%s accepts connections", address)
+	return check
+}
+
+func (c ccvmBackend) preflight(ctx context.Context, args *types.PreflightArgs) (*types.PreflightResult, error) {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var res types.PreflightResult
+	res.Checks = append(res.Checks, accelChecks()...)
+	res.Checks = append(res.Checks, checkQemuPreflight(ctx, &c.cfg.Qemu))
+	for _, t := range hostTools {
+		res.Checks = append(res.Checks, checkTool(t))
+	}
+	res.Checks = append(res.Checks, checkDiskSpace(ws.ccvmDir))
+	if args.HTTPProxy != "" {
+		res.Checks = append(res.Checks, checkProxy(ctx, "HTTP_PROXY", args.HTTPProxy))
+	}
+	if args.HTTPSProxy != "" {
+		res.Checks = append(res.Checks, checkProxy(ctx, "HTTPS_PROXY", args.HTTPSProxy))
+	}
+
+	return &res, nil
+}
@@ -0,0 +1,82 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestCheckTool(t *testing.T) {
+	check := checkTool(hostTool{name: "ccloudvm-missing-tool", pkg: "missing"})
+	if check.Status != types.PreflightFailed || check.Fix == "" {
+		t.Errorf("Missing tool not reported: %+v", check)
+	}
+
+	check = checkTool(hostTool{name: "sh", pkg: "sh"})
+	if check.Status != types.PreflightOK {
+		t.Errorf("sh not found: %+v", check)
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	check := checkDiskSpace(dir)
+	if check.Detail == "" || (check.Status != types.PreflightOK && check.Fix == "") {
+		t.Errorf("Unexpected disk space check %+v", check)
+	}
+
+	check = checkDiskSpace(dir + "/missing")
+	if check.Status != types.PreflightWarning {
+		t.Errorf("Free space of missing directory determined: %+v", check)
+	}
+}
+
+func TestCheckProxy(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	address := listener.Addr().String()
+
+	ctx := context.Background()
+	check := checkProxy(ctx, "HTTP_PROXY", "http://"+address)
+	if check.Status != types.PreflightOK {
+		t.Errorf("Proxy accepting connections reported as %+v", check)
+	}
+
+	_ = listener.Close()
+	check = checkProxy(ctx, "HTTP_PROXY", "http://"+address)
+	if check.Status != types.PreflightFailed || check.Fix == "" {
+		t.Errorf("Unreachable proxy not reported: %+v", check)
+	}
+
+	check = checkProxy(ctx, "HTTPS_PROXY", "proxy:3128")
+	if check.Status != types.PreflightFailed {
+		t.Errorf("Invalid proxy not reported: %+v", check)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/url"
@@ -27,6 +28,7 @@ import (
 	"os/exec"
 	"path"
 	"strings"
+	"sync"
 	"text/template"
 
 	"github.com/ciao-project/ciao/uuid"
@@ -38,11 +40,23 @@ import (
 const metaDataTemplate = `
 {
   "uuid": "{{.UUID}}",
-  "hostname": "{{.Hostname}}"
+  "hostname": "{{.Hostname}}",
+  "local-hostname": "{{.Hostname}}"
 }
 `
 const msgprefix = "MESSAGE:"
 
+// Certificates minted by the SSH CA are valid from five minutes in the
+// past, to allow for clock skew between the host and the guest, to one
+// hour in the future.
+const sshCertificateValidity = "-5m:+1h"
+
+// instanceKeyName is the name of the SSH key pair generated for each
+// instance and stored in its directory.  Instances created by older
+// versions of ccloudvm are accessed with the key pair they shared,
+// ~/.ccloudvm/id_rsa.
+const instanceKeyName = "id_ed25519"
+
 type workspace struct {
 	GoPath         string
 	Home           string
@@ -61,11 +75,42 @@ type workspace struct {
 	HostIP         string
 	UUID           string
 	PackageUpgrade string
+	Distro         string
+	Params         map[string]interface{}
+	Group          *types.GroupInfo
 	ccvmDir        string
 	instanceDir    string
 	keyPath        string
 	publicKeyPath  string
+	caKeyPath      string
 	dnsSearch      []string
+	network        *vmNetwork
+	agentKeys      []string
+	userData       *userData
+	profile        *userProfile
+	retry          retryPolicy
+	mirrors        mirrorList
+	diskKey        []byte
+	account        *account
+
+	// downloads lists the URLs of the files that the cloud-init
+	// documents ask the host to download.
+	downloads []string
+
+	// incoming is the path of the saved state from which the VM is
+	// booted when resuming a suspended instance.
+	incoming string
+}
+
+// ReverseForwardIP returns the address at which the guest can reach the
+// services exposed by the reverse_ports of the instance.
+func (w *workspace) ReverseForwardIP() string {
+	return w.network.reverseForwardIP()
+}
+
+// HostAlias returns a hostname that resolves to the host inside the guest.
+func (w *workspace) HostAlias() string {
+	return hostAlias
 }
 
 func (w *workspace) MountPath(tag string) string {
@@ -100,27 +145,108 @@ func hostSupportsNestedKVM() bool {
 	return hostSupportsNestedKVMIntel() || hostSupportsNestedKVMAMD()
 }
 
+// prepareSSHKeys generates the SSH key pair of a new instance.  Only the
+// public key of the instance's own key pair is injected into its guest.
 func prepareSSHKeys(ctx context.Context, ws *workspace) error {
-	_, privKeyErr := os.Stat(ws.keyPath)
-	_, pubKeyErr := os.Stat(ws.publicKeyPath)
+	ws.keyPath = path.Join(ws.instanceDir, instanceKeyName)
+	ws.publicKeyPath = ws.keyPath + ".pub"
+
+	// ssh-keygen prompts before overwriting a key pair left by an earlier
+	// attempt to create the instance.
+	for _, p := range []string{ws.keyPath, ws.publicKeyPath, sshCertificatePath(ws)} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "Unable to remove %s", p)
+		}
+	}
+
+	out, err := exec.CommandContext(ctx, "ssh-keygen", "-q",
+		"-f", ws.keyPath, "-t", "ed25519", "-N", "",
+		"-C", fmt.Sprintf("%s@%s", ws.User, ws.Hostname)).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to generate SSH key pair: %s", string(out))
+	}
+
+	err = os.Chmod(ws.keyPath, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to set permissions of private SSH key")
+	}
+
+	err = ws.giveToUser(ws.keyPath, ws.publicKeyPath)
+	if err != nil {
+		return err
+	}
+
+	publicKey, err := ioutil.ReadFile(ws.publicKeyPath)
+	if err != nil {
+		return errors.Wrap(err, "Unable to read public ssh key")
+	}
+
+	ws.PublicKey = string(publicKey)
+	return nil
+}
+
+// checkAgentKeys verifies that the public keys retrieved from the user's
+// SSH agent are single lines in the authorized_keys format.
+func checkAgentKeys(keys []string) error {
+	for _, k := range keys {
+		if strings.ContainsAny(k, "\r\n") || len(strings.Fields(k)) < 2 {
+			return errors.Errorf("Invalid SSH agent key %q", k)
+		}
+	}
+	return nil
+}
+
+// prepareSSHCA ensures that the daemon's SSH certificate authority key pair
+// exists and replaces the public key injected into the guest with a
+// cert-authority entry.  Guests created in this mode accept any user
+// certificate signed by the CA rather than a specific public key.
+func prepareSSHCA(ctx context.Context, ws *workspace) error {
+	caPublicKeyPath := ws.caKeyPath + ".pub"
+	_, privKeyErr := os.Stat(ws.caKeyPath)
+	_, pubKeyErr := os.Stat(caPublicKeyPath)
 
 	if pubKeyErr != nil || privKeyErr != nil {
 		out, err := exec.CommandContext(ctx, "ssh-keygen",
-			"-f", ws.keyPath, "-t", "rsa", "-N", "").CombinedOutput()
+			"-f", ws.caKeyPath, "-t", "rsa", "-N", "", "-C", "ccloudvm-ca").CombinedOutput()
 		if err != nil {
-			return errors.Wrapf(err, "Unable to generate SSH key pair: %s", string(out))
+			return errors.Wrapf(err, "Unable to generate SSH CA key pair: %s", string(out))
 		}
 	}
 
-	publicKey, err := ioutil.ReadFile(ws.publicKeyPath)
+	publicKey, err := ioutil.ReadFile(caPublicKeyPath)
 	if err != nil {
-		return errors.Wrap(err, "Unable to read public ssh key")
+		return errors.Wrap(err, "Unable to read public SSH CA key")
 	}
 
-	ws.PublicKey = string(publicKey)
+	ws.PublicKey = "cert-authority " + strings.TrimSpace(string(publicKey))
 	return nil
 }
 
+// certLock serialises the signing of SSH certificates, which overwrites
+// the certificate stored alongside the user's key.
+var certLock sync.Mutex
+
+func sshCertificatePath(ws *workspace) string {
+	return ws.keyPath + "-cert.pub"
+}
+
+// mintSSHCertificate signs the user's public key with the daemon's CA,
+// storing a certificate valid for a short period of time alongside the
+// user's key.
+func mintSSHCertificate(ctx context.Context, ws *workspace, name string) error {
+	certLock.Lock()
+	defer certLock.Unlock()
+
+	out, err := exec.CommandContext(ctx, "ssh-keygen", "-s", ws.caKeyPath,
+		"-I", fmt.Sprintf("%s@%s", ws.User, name), "-n", ws.User,
+		"-V", sshCertificateValidity, ws.publicKeyPath).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to sign SSH certificate: %s", string(out))
+	}
+
+	return ws.giveToUser(sshCertificatePath(ws))
+}
+
 func dnsSearch() []string {
 	searches := []string{}
 	data, err := ioutil.ReadFile("/etc/resolv.conf")
@@ -145,35 +271,58 @@ func prepareEnv(ctx context.Context, name string) (*workspace, error) {
 	var err error
 
 	ws := &workspace{}
-	ws.Home = os.Getenv("HOME")
-	if ws.Home == "" {
-		return nil, fmt.Errorf("HOME is not defined")
-	}
-	ws.User = os.Getenv("USER")
-	if ws.User == "" {
-		return nil, fmt.Errorf("USER is not defined")
-	}
+	if a := accountFromContext(ctx); a != nil {
+		ws.Home = a.home
+		ws.User = a.name
+		ws.UID = a.uid
+		ws.GID = a.gid
+		ws.ccvmDir = a.ccvmDir
+		ws.account = a
+	} else {
+		ws.Home = os.Getenv("HOME")
+		if ws.Home == "" {
+			return nil, fmt.Errorf("HOME is not defined")
+		}
+		ws.User = os.Getenv("USER")
+		if ws.User == "" {
+			return nil, fmt.Errorf("USER is not defined")
+		}
 
-	ws.UID = os.Getuid()
-	ws.GID = os.Getgid()
+		ws.UID = os.Getuid()
+		ws.GID = os.Getgid()
 
-	ws.ccvmDir = path.Join(ws.Home, ".ccloudvm")
+		ws.ccvmDir = types.DataDir(ws.Home)
+	}
 	ws.instanceDir = path.Join(ws.ccvmDir, "instances", name)
 	ws.keyPath = path.Join(ws.ccvmDir, "id_rsa")
+	if name != "" {
+		keyPath := path.Join(ws.instanceDir, instanceKeyName)
+		if _, err := os.Stat(keyPath); err == nil {
+			ws.keyPath = keyPath
+		}
+	}
 	ws.publicKeyPath = fmt.Sprintf("%s.pub", ws.keyPath)
+	ws.caKeyPath = path.Join(ws.ccvmDir, "ssh_ca")
+
+	gitConfig := func(key string) ([]byte, error) {
+		cmd := exec.Command("git", "config", "--global", key)
+		cmd.Env = append(os.Environ(), "HOME="+ws.Home)
+		return cmd.Output()
+	}
 
-	data, err := exec.Command("git", "config", "--global", "user.name").Output()
+	data, err := gitConfig("user.name")
 	if err == nil {
 		ws.GitUserName = strings.TrimSpace(string(data))
 	}
 
-	data, err = exec.Command("git", "config", "--global", "user.email").Output()
+	data, err = gitConfig("user.email")
 	if err == nil {
 		ws.GitEmail = strings.TrimSpace(string(data))
 	}
 
 	ws.UUID = uuid.Generate().String()
 	ws.dnsSearch = dnsSearch()
+	ws.network = defaultNetwork()
 
 	return ws, nil
 }
@@ -184,9 +333,10 @@ func createCloudInitISO(ctx context.Context, instanceDir string, userData, metaD
 }
 
 func downloadFN(ws *workspace, URL, location string) string {
+	ws.downloads = append(ws.downloads, URL)
 	url := url.URL{
 		Scheme: "http",
-		Host:   fmt.Sprintf("10.0.2.2:%d", ws.HTTPServerPort),
+		Host:   fmt.Sprintf("%s:%d", ws.network.hostIP(), ws.HTTPServerPort),
 		Path:   "download",
 	}
 	q := url.Query()
@@ -196,31 +346,31 @@ func downloadFN(ws *workspace, URL, location string) string {
 }
 
 func beginTaskFN(ws *workspace, message string) string {
-	const infoStr = `'curl -X PUT -d "%s" 10.0.2.2:%d'`
+	const infoStr = `'curl -X PUT -d "%s" %s:%d'`
 	message = strings.Replace(message, "'", "''", -1)
-	return fmt.Sprintf(infoStr, message, ws.HTTPServerPort)
+	return fmt.Sprintf(infoStr, message, ws.network.hostIP(), ws.HTTPServerPort)
 }
 
 func endTaskCheckFN(ws *workspace) string {
 	const checkStr = `if [ $? -eq 0 ] ; then ret="OK" ; else ret="FAIL" ; fi ; ` +
-		`curl -X PUT -d $ret 10.0.2.2:%d`
-	return fmt.Sprintf(checkStr, ws.HTTPServerPort)
+		`curl -X PUT -d $ret %s:%d`
+	return fmt.Sprintf(checkStr, ws.network.hostIP(), ws.HTTPServerPort)
 }
 
 func endTaskOkFN(ws *workspace) string {
-	const okStr = `curl -X PUT -d "OK" 10.0.2.2:%d`
-	return fmt.Sprintf(okStr, ws.HTTPServerPort)
+	const okStr = `curl -X PUT -d "OK" %s:%d`
+	return fmt.Sprintf(okStr, ws.network.hostIP(), ws.HTTPServerPort)
 }
 
 func endTaskFailFN(ws *workspace) string {
-	const failStr = `curl -X PUT -d "FAIL" 10.0.2.2:%d`
-	return fmt.Sprintf(failStr, ws.HTTPServerPort)
+	const failStr = `curl -X PUT -d "FAIL" %s:%d`
+	return fmt.Sprintf(failStr, ws.network.hostIP(), ws.HTTPServerPort)
 }
 
 func messageFN(ws *workspace, message string) string {
-	const msgStr = `'curl -X PUT -d "%s%s" 10.0.2.2:%d'`
+	const msgStr = `'curl -X PUT -d "%s%s" %s:%d'`
 	message = strings.Replace(message, "'", "''", -1)
-	return fmt.Sprintf(msgStr, msgprefix, message, ws.HTTPServerPort)
+	return fmt.Sprintf(msgStr, msgprefix, message, ws.network.hostIP(), ws.HTTPServerPort)
 }
 
 func proxyVarsFN(ws *workspace) string {
@@ -286,16 +436,28 @@ func proxyEnvFN(ws *workspace, indent int) string {
 	return buf.String()
 }
 
-func buildISOImage(ctx context.Context, resultCh chan interface{}, userData []byte, ws *workspace, debug bool) error {
-	mdt, err := template.New("meta-data").Parse(metaDataTemplate)
-	if err != nil {
-		return errors.Wrap(err, "Unable to parse meta data template")
-	}
-
+// buildCloudInitData creates the NoCloud ISO image of an instance whose
+// instance specification is spec, or stores its cloud-init documents in
+// its seed directory if it uses another datasource.
+func buildCloudInitData(ctx context.Context, resultCh chan interface{}, userData []byte, ws *workspace,
+	spec *workloadSpec, debug bool) error {
 	var mdBuf bytes.Buffer
-	err = mdt.Execute(&mdBuf, ws)
-	if err != nil {
-		return errors.Wrap(err, "Unable to execute meta data template")
+	if spec.windows() {
+		data, err := windowsMetaData(ws)
+		if err != nil {
+			return err
+		}
+		_, _ = mdBuf.Write(data)
+	} else {
+		mdt, err := template.New("meta-data").Parse(metaDataTemplate)
+		if err != nil {
+			return errors.Wrap(err, "Unable to parse meta data template")
+		}
+
+		err = mdt.Execute(&mdBuf, ws)
+		if err != nil {
+			return errors.Wrap(err, "Unable to execute meta data template")
+		}
 	}
 
 	if debug {
@@ -307,17 +469,96 @@ func buildISOImage(ctx context.Context, resultCh chan interface{}, userData []by
 		}
 	}
 
+	if datasourceType(&spec.VM) != types.DatasourceNoCloud {
+		return writeSeed(ws.instanceDir, userData, mdBuf.Bytes())
+	}
 	return createCloudInitISO(ctx, ws.instanceDir, userData, mdBuf.Bytes())
 }
 
+// createRootfs creates the root disk of an instance as a qcow2 overlay of
+// the cached base image, which is shared by all the instances created from
+// it.  The format of the base image is recorded in the overlay as recent
+// versions of qemu refuse to probe it.
 func createRootfs(ctx context.Context, backingImage, instanceDir string, disk int) error {
 	vmImage := path.Join(instanceDir, "image.qcow2")
 	if _, err := os.Stat(vmImage); err == nil {
 		_ = os.Remove(vmImage)
 	}
+
+	chain, err := imageChain(ctx, backingImage)
+	if err != nil {
+		return err
+	}
+	if len(chain) == 0 {
+		return errors.Errorf("Unable to determine the format of %s", backingImage)
+	}
+	backingFormat := chain[0].Format
+
 	diskParam := fmt.Sprintf("%dG", disk)
 	params := make([]string, 0, 32)
-	params = append(params, "create", "-f", "qcow2", "-o", "backing_file="+backingImage,
+	params = append(params, "create", "-f", "qcow2", "-o",
+		fmt.Sprintf("backing_file=%s,backing_fmt=%s", backingImage, backingFormat),
 		vmImage, diskParam)
 	return exec.CommandContext(ctx, "qemu-img", params...).Run()
 }
+
+// imageVirtualSize returns the size, in bytes, of the disk presented by the
+// image located at image.
+func imageVirtualSize(ctx context.Context, image, format string) (int64, error) {
+	out, err := exec.CommandContext(ctx, "qemu-img", "info", "--output=json", "-f", format,
+		image).Output()
+	if err != nil {
+		return 0, errors.Wrapf(err, "Unable to retrieve size of %s", image)
+	}
+
+	var info struct {
+		VirtualSize int64 `json:"virtual-size"`
+	}
+	err = json.Unmarshal(out, &info)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Unable to parse size of %s", image)
+	}
+
+	return info.VirtualSize, nil
+}
+
+// imageAllocatedSize returns the host storage, in bytes, allocated to the
+// image located at image, which may be in use by a running VM.
+func imageAllocatedSize(ctx context.Context, image string) (int64, error) {
+	out, err := exec.CommandContext(ctx, "qemu-img", "info", "--force-share", "--output=json",
+		image).Output()
+	if err != nil {
+		return 0, errors.Wrapf(err, "Unable to retrieve allocated size of %s", image)
+	}
+
+	var info struct {
+		ActualSize int64 `json:"actual-size"`
+	}
+	err = json.Unmarshal(out, &info)
+	if err != nil {
+		return 0, errors.Wrapf(err, "Unable to parse allocated size of %s", image)
+	}
+
+	return info.ActualSize, nil
+}
+
+// growImage grows the disk image located at image to disk GiB.  Images that
+// are already at least this size are left untouched.
+func growImage(ctx context.Context, image, format string, disk int) error {
+	size, err := imageVirtualSize(ctx, image, format)
+	if err != nil {
+		return err
+	}
+
+	if size >= int64(disk)<<30 {
+		return nil
+	}
+
+	out, err := exec.CommandContext(ctx, "qemu-img", "resize", "-f", format, image,
+		fmt.Sprintf("%dG", disk)).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to resize %s: %s", image, string(out))
+	}
+
+	return nil
+}
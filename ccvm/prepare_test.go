@@ -0,0 +1,75 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestPrepareSSHKeys(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	ws := &workspace{
+		User:          "user",
+		Hostname:      "vm",
+		instanceDir:   dir,
+		keyPath:       path.Join(dir, "id_rsa"),
+		publicKeyPath: path.Join(dir, "id_rsa.pub"),
+	}
+	if err := prepareSSHKeys(context.Background(), ws); err != nil {
+		t.Fatalf("Unable to prepare SSH keys: %v", err)
+	}
+
+	if ws.keyPath != path.Join(dir, instanceKeyName) {
+		t.Errorf("Unexpected key path %s", ws.keyPath)
+	}
+	fi, err := os.Stat(ws.keyPath)
+	if err != nil {
+		t.Fatalf("Private key not created: %v", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("Private key has permissions %v", fi.Mode().Perm())
+	}
+	if !strings.HasPrefix(ws.PublicKey, "ssh-ed25519 ") ||
+		!strings.HasSuffix(strings.TrimSpace(ws.PublicKey), " user@vm") {
+		t.Errorf("Unexpected public key %s", ws.PublicKey)
+	}
+
+	// The key pair of an earlier attempt to create the instance is
+	// replaced.
+	first := ws.PublicKey
+	if err := prepareSSHKeys(context.Background(), ws); err != nil {
+		t.Fatalf("Unable to replace SSH keys: %v", err)
+	}
+	if ws.PublicKey == first {
+		t.Errorf("SSH key pair not replaced")
+	}
+}
@@ -0,0 +1,352 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The daemon periodically checks whether running instances are short of
+// memory, from OOM kills in the guest or the host's memory cgroup and from
+// the balloon statistics of qemu VMs.  Instances under pressure are marked
+// as degraded until none has been seen for pressureRecoveryTime.
+
+const (
+	pressureFile         = "pressure.yaml"
+	pressureInterval     = 30 * time.Second
+	pressureRecoveryTime = 10 * time.Minute
+	balloonQOMPath       = "/machine/peripheral/balloon0"
+
+	// swapThreshold is the number of bytes that must be swapped in or
+	// out by a guest within pressureInterval for the guest to be
+	// considered to be swapping heavily.
+	swapThreshold = 64 << 20
+)
+
+var oomRegexp = regexp.MustCompile(`(?i)out of memory`)
+
+type pressureRecord struct {
+	Active   bool      `yaml:"active"`
+	Since    time.Time `yaml:"since"`
+	LastSeen time.Time `yaml:"last_seen"`
+	Reason   string    `yaml:"reason"`
+}
+
+func loadPressure(instanceDir string) pressureRecord {
+	var pr pressureRecord
+
+	data, err := ioutil.ReadFile(path.Join(instanceDir, pressureFile))
+	if err != nil {
+		return pr
+	}
+	_ = yaml.Unmarshal(data, &pr)
+
+	return pr
+}
+
+func savePressure(instanceDir string, pr *pressureRecord) error {
+	data, err := yaml.Marshal(pr)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal memory pressure record")
+	}
+
+	err = ioutil.WriteFile(path.Join(instanceDir, pressureFile), data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to write memory pressure record")
+	}
+
+	return nil
+}
+
+// resolvePressure clears the degraded mark of an instance whose VM is
+// being started.
+func resolvePressure(instanceDir string) {
+	_ = os.Remove(path.Join(instanceDir, pressureFile))
+}
+
+// balloonStats contains the guest statistics reported by a balloon device.
+// The swap counters are in bytes.
+type balloonStats struct {
+	SwapIn  uint64 `json:"stat-swap-in"`
+	SwapOut uint64 `json:"stat-swap-out"`
+}
+
+// qemuGuestStats returns the statistics reported by the balloon device of
+// the qemu VM running in instanceDir.  The guest is asked to report its
+// statistics every pressureInterval.  ok is false if the guest has not yet
+// reported any statistics.
+func qemuGuestStats(ctx context.Context, instanceDir string) (stats balloonStats, ok bool, err error) {
+	_, err = qmpExecute(ctx, instanceDir, "qom-set", map[string]interface{}{
+		"path":     balloonQOMPath,
+		"property": "guest-stats-polling-interval",
+		"value":    int(pressureInterval / time.Second),
+	})
+	if err != nil {
+		return stats, false, err
+	}
+
+	ret, err := qmpExecute(ctx, instanceDir, "qom-get", map[string]interface{}{
+		"path":     balloonQOMPath,
+		"property": "guest-stats",
+	})
+	if err != nil {
+		return stats, false, err
+	}
+
+	var res struct {
+		Stats      balloonStats `json:"stats"`
+		LastUpdate int64        `json:"last-update"`
+	}
+	if err := json.Unmarshal(ret, &res); err != nil {
+		return stats, false, errors.Wrap(err, "Unable to parse guest statistics")
+	}
+
+	return res.Stats, res.LastUpdate != 0, nil
+}
+
+// cgroupOOMKills returns the number of processes killed by the host's OOM
+// killer in the cgroup v2 memory cgroup of the process pid.
+func cgroupOOMKills(pid int) (uint64, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	var cgroup string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			cgroup = strings.TrimPrefix(line, "0::")
+			break
+		}
+	}
+	if cgroup == "" {
+		return 0, errors.New("Process is not in a cgroup v2 hierarchy")
+	}
+
+	f, err := os.Open(path.Join("/sys/fs/cgroup", cgroup, "memory.events"))
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+
+	return 0, errors.New("oom_kill count not found")
+}
+
+// countOOMKills returns the number of OOM kills reported in the output
+// written to the console log logPath since it was offset bytes long, and
+// the new length of the log.  The log is read from the start if it has
+// been rotated.
+func countOOMKills(logPath string, offset int64) (int, int64) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return 0, 0
+	}
+	defer func() { _ = f.Close() }()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, offset
+	}
+	if fi.Size() < offset {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+		return 0, offset
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return 0, offset
+	}
+
+	// Only complete lines are examined so that a message is not missed
+	// if it is read while it is being written.
+	i := strings.LastIndex(string(data), "\n")
+	if i < 0 {
+		return 0, offset
+	}
+
+	return len(oomRegexp.FindAllIndex(data[:i], -1)), offset + int64(i) + 1
+}
+
+// pressureSample contains the counters read when an instance was last
+// checked.  The counters are only compared if the VM's pid has not changed.
+type pressureSample struct {
+	pid          int
+	consoleSize  int64
+	swapped      uint64
+	statsOK      bool
+	hostOOMKills uint64
+	cgroupOK     bool
+}
+
+// pressureMonitor checks whether instances are under memory pressure.  The
+// host specific functions it relies on can be replaced for testing.
+type pressureMonitor struct {
+	ccvmDir     string
+	vmProcess   func(instanceDir string) (string, int, bool)
+	guestStats  func(ctx context.Context, instanceDir string) (balloonStats, bool, error)
+	cgroupKills func(pid int) (uint64, error)
+	samples     map[string]*pressureSample
+}
+
+func newPressureMonitor(ccvmDir string) *pressureMonitor {
+	return &pressureMonitor{
+		ccvmDir:     ccvmDir,
+		vmProcess:   vmProcess,
+		guestStats:  qemuGuestStats,
+		cgroupKills: cgroupOOMKills,
+		samples:     make(map[string]*pressureSample),
+	}
+}
+
+// pressureReasons returns descriptions of the signs of memory pressure
+// shown by the instance whose directory is instanceDir since it was last
+// checked.
+func (p *pressureMonitor) pressureReasons(ctx context.Context, instanceDir string) []string {
+	name := filepath.Base(instanceDir)
+	hypervisor, pid, ok := p.vmProcess(instanceDir)
+	if !ok {
+		delete(p.samples, name)
+		return nil
+	}
+
+	logPath := path.Join(instanceDir, hypervisor+".log")
+	if hypervisor == hypervisorQemu {
+		logPath = path.Join(instanceDir, consoleLog)
+	}
+
+	prev := p.samples[name]
+	cur := &pressureSample{pid: pid}
+	if prev == nil || prev.pid != pid {
+		// The output written before the VM was started or before
+		// the daemon started is not examined.
+		if fi, err := os.Stat(logPath); err == nil {
+			cur.consoleSize = fi.Size()
+		}
+		prev = nil
+	}
+
+	var reasons []string
+
+	var kills int
+	if prev != nil {
+		kills, cur.consoleSize = countOOMKills(logPath, prev.consoleSize)
+	}
+	if kills > 0 {
+		reasons = append(reasons, fmt.Sprintf("guest OOM killer invoked %d times", kills))
+	}
+
+	if hypervisor == hypervisorQemu {
+		statsCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		stats, ok, err := p.guestStats(statsCtx, instanceDir)
+		cancel()
+		if err == nil && ok {
+			cur.swapped = stats.SwapIn + stats.SwapOut
+			cur.statsOK = true
+			if prev != nil && prev.statsOK && cur.swapped >= prev.swapped &&
+				cur.swapped-prev.swapped >= swapThreshold {
+				reasons = append(reasons, fmt.Sprintf("guest swapped %d MiB in %v",
+					(cur.swapped-prev.swapped)>>20, pressureInterval))
+			}
+		}
+	}
+
+	if hostKills, err := p.cgroupKills(pid); err == nil {
+		cur.hostOOMKills = hostKills
+		cur.cgroupOK = true
+		if prev != nil && prev.cgroupOK && hostKills > prev.hostOOMKills {
+			reasons = append(reasons, fmt.Sprintf("host OOM killer invoked %d times in the VM's cgroup",
+				hostKills-prev.hostOOMKills))
+		}
+	}
+
+	p.samples[name] = cur
+	return reasons
+}
+
+// check checks each running instance for memory pressure, updating their
+// degraded marks and publishing the changes of their marks.
+func (p *pressureMonitor) check(ctx context.Context, now time.Time, publish func(name, eventType string)) {
+	instanceDirs, _ := filepath.Glob(filepath.Join(p.ccvmDir, "instances", "*"))
+	for _, instanceDir := range instanceDirs {
+		name := filepath.Base(instanceDir)
+		reasons := p.pressureReasons(ctx, instanceDir)
+
+		pr := loadPressure(instanceDir)
+		eventType := ""
+		if len(reasons) > 0 {
+			if !pr.Active {
+				pr.Active = true
+				pr.Since = now
+				eventType = types.EventDegraded
+			}
+			pr.LastSeen = now
+			pr.Reason = strings.Join(reasons, ", ")
+			instanceLog(name).Warningf("Instance is short of memory: %s", pr.Reason)
+		} else if pr.Active && now.Sub(pr.LastSeen) >= pressureRecoveryTime {
+			pr.Active = false
+			eventType = types.EventRecovered
+		} else {
+			continue
+		}
+
+		if err := savePressure(instanceDir, &pr); err != nil {
+			instanceLog(name).Warningf("%v", err)
+		}
+		if eventType != "" {
+			publish(name, eventType)
+		}
+	}
+}
+
+// run checks instances for memory pressure every pressureInterval until
+// ctx is cancelled.
+func (p *pressureMonitor) run(ctx context.Context, publish func(name, eventType string)) {
+	for {
+		p.check(ctx, time.Now(), publish)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pressureInterval):
+		}
+	}
+}
@@ -0,0 +1,146 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func appendFile(t *testing.T, p, data string) {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Unable to open %s: %v", p, err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatalf("Unable to write to %s: %v", p, err)
+	}
+}
+
+func TestCountOOMKills(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	logPath := path.Join(dir, consoleLog)
+	if n, offset := countOOMKills(logPath, 10); n != 0 || offset != 0 {
+		t.Errorf("Unexpected result for missing log %d %d", n, offset)
+	}
+
+	appendFile(t, logPath, "boot\n")
+	_, offset := countOOMKills(logPath, 0)
+
+	appendFile(t, logPath, "Out of memory: Killed process 42 (stress)\nlogin: ")
+	n, offset := countOOMKills(logPath, offset)
+	if n != 1 {
+		t.Errorf("Expected 1 OOM kill, found %d", n)
+	}
+
+	appendFile(t, logPath, "\nout of memory: Killed process 43\n")
+	if n, _ = countOOMKills(logPath, offset); n != 1 {
+		t.Errorf("Expected 1 OOM kill after partial line, found %d", n)
+	}
+
+	if err := ioutil.WriteFile(logPath, []byte("Out of memory\n"), 0600); err != nil {
+		t.Fatalf("Unable to rotate log: %v", err)
+	}
+	if n, _ = countOOMKills(logPath, offset); n != 1 {
+		t.Errorf("Expected 1 OOM kill after rotation, found %d", n)
+	}
+}
+
+func TestPressureMonitor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	instanceDir := path.Join(dir, "instances", "vm")
+	if err := os.MkdirAll(instanceDir, 0700); err != nil {
+		t.Fatalf("Unable to create instance directory: %v", err)
+	}
+	logPath := path.Join(instanceDir, consoleLog)
+	appendFile(t, logPath, "boot\n")
+
+	var swapped uint64
+	var hostKills uint64
+	p := newPressureMonitor(dir)
+	p.vmProcess = func(string) (string, int, bool) { return hypervisorQemu, 42, true }
+	p.guestStats = func(context.Context, string) (balloonStats, bool, error) {
+		return balloonStats{SwapOut: swapped}, true, nil
+	}
+	p.cgroupKills = func(int) (uint64, error) { return hostKills, nil }
+
+	var events []string
+	publish := func(name, eventType string) {
+		events = append(events, name+" "+eventType)
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	p.check(ctx, now, publish)
+	if pr := loadPressure(instanceDir); pr.Active || len(events) != 0 {
+		t.Fatalf("Instance degraded by first sample")
+	}
+
+	appendFile(t, logPath, "Out of memory: Killed process 7\n")
+	swapped = swapThreshold
+	now = now.Add(pressureInterval)
+	p.check(ctx, now, publish)
+	pr := loadPressure(instanceDir)
+	if !pr.Active || !pr.Since.Equal(now) || len(events) != 1 ||
+		events[0] != "vm "+types.EventDegraded {
+		t.Fatalf("Instance not degraded %+v %v", pr, events)
+	}
+
+	hostKills = 2
+	now = now.Add(pressureInterval)
+	p.check(ctx, now, publish)
+	pr = loadPressure(instanceDir)
+	if pr.Reason != "host OOM killer invoked 2 times in the VM's cgroup" || len(events) != 1 {
+		t.Errorf("Unexpected pressure record %+v %v", pr, events)
+	}
+	lastSeen := now
+
+	now = now.Add(pressureRecoveryTime - time.Second)
+	p.check(ctx, now, publish)
+	if pr := loadPressure(instanceDir); !pr.Active {
+		t.Errorf("Instance recovered too early")
+	}
+
+	now = lastSeen.Add(pressureRecoveryTime)
+	p.check(ctx, now, publish)
+	if pr := loadPressure(instanceDir); pr.Active || len(events) != 2 ||
+		events[1] != "vm "+types.EventRecovered {
+		t.Errorf("Instance not recovered %+v %v", pr, events)
+	}
+
+	resolvePressure(instanceDir)
+	if _, err := os.Stat(path.Join(instanceDir, pressureFile)); !os.IsNotExist(err) {
+		t.Errorf("Pressure record not removed")
+	}
+}
@@ -0,0 +1,203 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The functions in this file are used by hypervisors, such as firecracker
+// and cloud-hypervisor, that do not daemonize themselves and that are
+// controlled by a REST API exposed over a unix socket.  The pid of the
+// hypervisor process is stored in a file in the instance directory.
+
+// launchProcess starts a daemon process, whose output is written to
+// instanceDir/name.log and whose pid is written to instanceDir/name.pid.
+func launchProcess(instanceDir, name, binary string, args ...string) error {
+	logFile, err := os.Create(path.Join(instanceDir, name+".log"))
+	if err != nil {
+		return errors.Wrapf(err, "Unable to create %s log file", name)
+	}
+	defer func() { _ = logFile.Close() }()
+
+	// The process must outlive the context of the request that started
+	// it, so we don't use exec.CommandContext here.
+
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if err != nil {
+		return errors.Wrapf(err, "Failed to launch %s", binary)
+	}
+	go func() { _ = cmd.Wait() }()
+
+	err = ioutil.WriteFile(path.Join(instanceDir, name+".pid"),
+		[]byte(strconv.Itoa(cmd.Process.Pid)), 0600)
+	if err != nil {
+		_ = cmd.Process.Kill()
+		return errors.Wrap(err, "Unable to write pid file")
+	}
+
+	return nil
+}
+
+func processPid(instanceDir, name string) (int, error) {
+	data, err := ioutil.ReadFile(path.Join(instanceDir, name+".pid"))
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to read pid file")
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, errors.Wrap(err, "Invalid pid file")
+	}
+
+	return pid, nil
+}
+
+// processRunning returns true if the process whose pid is stored in
+// instanceDir/name.pid is running.  Unrelated processes that reused the pid
+// are not considered to be running.
+func processRunning(instanceDir, name string) bool {
+	pid, err := processPid(instanceDir, name)
+	if err != nil {
+		return false
+	}
+
+	return syscall.Kill(pid, 0) == nil && processOfInstance(pid, instanceDir)
+}
+
+func killProcess(instanceDir, name string) error {
+	pid, err := processPid(instanceDir, name)
+	if err != nil {
+		return errors.Wrap(err, "Failed to connect to VM")
+	}
+
+	if !processOfInstance(pid, instanceDir) {
+		return errors.Errorf("Process %d does not belong to the instance", pid)
+	}
+
+	err = syscall.Kill(pid, syscall.SIGKILL)
+	if err != nil {
+		return errors.Wrap(err, "Unable to execute vm command")
+	}
+
+	return nil
+}
+
+// watchProcess returns a vmWatcher whose disconnectedCh is closed when the
+// process exits.
+func watchProcess(instanceDir, name string) (*vmWatcher, error) {
+	if !processRunning(instanceDir, name) {
+		return nil, errors.New("Unable to connect to VM")
+	}
+
+	disconnectedCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-doneCh:
+				return
+			case <-time.After(time.Second / 2):
+			}
+			if !processRunning(instanceDir, name) {
+				close(disconnectedCh)
+				return
+			}
+		}
+	}()
+
+	return &vmWatcher{
+		disconnectedCh: disconnectedCh,
+		quit: func(ctx context.Context) error {
+			return killProcess(instanceDir, name)
+		},
+		close: func() { close(doneCh) },
+	}, nil
+}
+
+// monitorProcess waits for the process to exit.  The exit status of the
+// process is not available, as it is not a child of the daemon, so the
+// way in which the VM exited is unknown.  The process' output, which
+// includes the VM's serial output, is written to instanceDir/name.log.
+func monitorProcess(ctx context.Context, instanceDir, name string) (*vmExit, error) {
+	watcher, err := watchProcess(instanceDir, name)
+	if err != nil {
+		return nil, err
+	}
+	defer watcher.close()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-watcher.disconnectedCh:
+	}
+
+	return &vmExit{
+		reason:  name + " exited",
+		console: path.Join(instanceDir, name+".log"),
+	}, nil
+}
+
+// putAPIRequest issues a PUT request to the REST API exposed by a hypervisor
+// over the unix socket located at socket.
+func putAPIRequest(ctx context.Context, socket, URL, body string) error {
+	cli := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodPut, URL, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := cli.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "Unable to execute vm command")
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("Unable to execute vm command: %s", resp.Status)
+	}
+
+	return nil
+}
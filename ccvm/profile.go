@@ -0,0 +1,142 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// userProfile contains the personal customizations, read from
+// ~/.ccloudvm/profile.yaml, applied to every instance the user creates.
+type userProfile struct {
+	Packages          []string      `yaml:"packages"`
+	SSHAuthorizedKeys []string      `yaml:"ssh_authorized_keys"`
+	Git               profileGit    `yaml:"git"`
+	Proxy             profileProxy  `yaml:"proxy"`
+	Mounts            []types.Mount `yaml:"mounts"`
+}
+
+type profileGit struct {
+	Name  string `yaml:"name"`
+	Email string `yaml:"email"`
+}
+
+type profileProxy struct {
+	HTTP    string `yaml:"http"`
+	HTTPS   string `yaml:"https"`
+	NoProxy string `yaml:"no_proxy"`
+}
+
+// loadUserProfile reads the profile of the user whose home directory is
+// home from ccvmDir.  It returns nil if the user has no profile.
+func loadUserProfile(ccvmDir, home string) (*userProfile, error) {
+	profilePath := filepath.Join(ccvmDir, "profile.yaml")
+	data, err := ioutil.ReadFile(profilePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read %s", profilePath)
+	}
+
+	var p userProfile
+	err = yaml.Unmarshal(data, &p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse %s", profilePath)
+	}
+
+	if err := checkAgentKeys(p.SSHAuthorizedKeys); err != nil {
+		return nil, errors.Wrapf(err, "Invalid ssh_authorized_keys in %s", profilePath)
+	}
+	for i := range p.Mounts {
+		m := &p.Mounts[i]
+		if m.Path == "~" || strings.HasPrefix(m.Path, "~/") {
+			m.Path = filepath.Join(home, m.Path[1:])
+		}
+		if m.Tag == "" {
+			return nil, errors.Errorf("Mount %s in %s has no tag", m.Path, profilePath)
+		}
+		if err := m.Check(); err != nil {
+			return nil, errors.Wrapf(err, "Invalid mount %s in %s", m.Tag, profilePath)
+		}
+	}
+
+	return &p, nil
+}
+
+// applyEnv applies the git identity and proxy settings of the profile to
+// ws.
+func (p *userProfile) applyEnv(ws *workspace) {
+	if p.Git.Name != "" {
+		ws.GitUserName = p.Git.Name
+	}
+	if p.Git.Email != "" {
+		ws.GitEmail = p.Git.Email
+	}
+
+	if ws.HTTPProxy == "" && ws.HTTPSProxy == "" {
+		ws.HTTPProxy = p.Proxy.HTTP
+		ws.HTTPSProxy = p.Proxy.HTTPS
+		if ws.NoProxy == "" {
+			ws.NoProxy = p.Proxy.NoProxy
+		}
+	}
+}
+
+// applyMounts adds the mounts of the profile to those of the instance in.
+// It must be called before the mounts of the create command are merged
+// into in.
+func (p *userProfile) applyMounts(in *types.VMSpec) {
+	for _, m := range p.Mounts {
+		var found bool
+		for i := range in.Mounts {
+			if in.Mounts[i].Tag == m.Tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			in.Mounts = append(in.Mounts, m)
+		}
+	}
+}
+
+// applyCloudConfig adds the packages and the SSH keys of the profile to
+// the cloud-config document data of an instance created for user.
+func (p *userProfile) applyCloudConfig(data cloudConfig, user string) error {
+	if len(p.Packages) > 0 {
+		packages, _ := data["packages"].([]interface{})
+		for _, pkg := range p.Packages {
+			packages = append(packages, pkg)
+		}
+		data["packages"] = packages
+	}
+
+	if len(p.SSHAuthorizedKeys) > 0 {
+		err := addAuthorizedKeys(data, user, p.SSHAuthorizedKeys)
+		if err != nil {
+			return errors.Wrap(err, "Unable to apply user profile")
+		}
+	}
+	return nil
+}
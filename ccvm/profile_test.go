@@ -0,0 +1,147 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+const testProfile = `
+packages:
+- vim
+ssh_authorized_keys:
+- ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFake user@laptop
+git:
+  name: Jane Doe
+  email: jane@example.com
+proxy:
+  http: http://proxy.example.com:911
+  https: http://proxy.example.com:912
+mounts:
+- tag: dotfiles
+  security_model: passthrough
+  path: ~/dotfiles
+- tag: hostgo
+  security_model: passthrough
+  path: ~/dotfiles
+`
+
+func writeTestProfile(t *testing.T, dir, profile string) {
+	err := ioutil.WriteFile(filepath.Join(dir, "profile.yaml"), []byte(profile), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write profile: %v", err)
+	}
+}
+
+func TestLoadUserProfile(t *testing.T) {
+	home, err := ioutil.TempDir("", "profile-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(home) }()
+
+	p, err := loadUserProfile(home, home)
+	if err != nil || p != nil {
+		t.Fatalf("Expected no profile, got %v, %v", p, err)
+	}
+
+	writeTestProfile(t, home, testProfile)
+	if _, err = loadUserProfile(home, home); err == nil {
+		t.Errorf("Expected profile with missing mount directory to fail")
+	}
+
+	if err = os.Mkdir(filepath.Join(home, "dotfiles"), 0700); err != nil {
+		t.Fatalf("Unable to create mount directory: %v", err)
+	}
+	p, err = loadUserProfile(home, home)
+	if err != nil {
+		t.Fatalf("Unable to load profile: %v", err)
+	}
+	if p.Mounts[0].Path != filepath.Join(home, "dotfiles") {
+		t.Errorf("Unexpected mount path %s", p.Mounts[0].Path)
+	}
+
+	writeTestProfile(t, home, "ssh_authorized_keys:\n- not-a-key\n")
+	if _, err = loadUserProfile(home, home); err == nil {
+		t.Errorf("Expected profile with invalid key to fail")
+	}
+
+	writeTestProfile(t, home, "mounts:\n- path: /tmp\n")
+	if _, err = loadUserProfile(home, home); err == nil {
+		t.Errorf("Expected mount without tag to fail")
+	}
+}
+
+func TestApplyUserProfile(t *testing.T) {
+	p := &userProfile{
+		Packages:          []string{"vim", "tmux"},
+		SSHAuthorizedKeys: []string{"ssh-ed25519 AAAA user@laptop"},
+		Git:               profileGit{Name: "Jane Doe"},
+		Proxy:             profileProxy{HTTP: "http://proxy:911", NoProxy: "example.com"},
+		Mounts: []types.Mount{
+			{Tag: "dotfiles", Path: "/home/jane/dotfiles"},
+			{Tag: "hostgo", Path: "/home/jane/go"},
+		},
+	}
+
+	ws := &workspace{GitUserName: "jane", GitEmail: "jane@example.com"}
+	p.applyEnv(ws)
+	if ws.GitUserName != "Jane Doe" || ws.GitEmail != "jane@example.com" ||
+		ws.HTTPProxy != "http://proxy:911" || ws.NoProxy != "example.com" {
+		t.Errorf("Unexpected workspace after applying profile %+v", ws)
+	}
+
+	ws = &workspace{HTTPSProxy: "http://other:912"}
+	p.applyEnv(ws)
+	if ws.HTTPProxy != "" || ws.NoProxy != "" {
+		t.Errorf("Profile proxies should not override those of the client")
+	}
+
+	in := &types.VMSpec{Mounts: []types.Mount{{Tag: "hostgo", Path: "/opt/go"}}}
+	p.applyMounts(in)
+	expected := []types.Mount{{Tag: "hostgo", Path: "/opt/go"}, p.Mounts[0]}
+	if !reflect.DeepEqual(in.Mounts, expected) {
+		t.Errorf("Unexpected mounts %v", in.Mounts)
+	}
+
+	data := cloudConfig{
+		"packages": []interface{}{"git"},
+		"users": []interface{}{
+			map[interface{}]interface{}{"name": "jane"},
+		},
+	}
+	if err := p.applyCloudConfig(data, "jane"); err != nil {
+		t.Fatalf("Unable to apply profile: %v", err)
+	}
+	if !reflect.DeepEqual(data["packages"], []interface{}{"git", "vim", "tmux"}) {
+		t.Errorf("Unexpected packages %v", data["packages"])
+	}
+	user := data["users"].([]interface{})[0].(map[interface{}]interface{})
+	if !reflect.DeepEqual(user["ssh-authorized-keys"], []interface{}{p.SSHAuthorizedKeys[0]}) {
+		t.Errorf("Unexpected authorized keys %v", user)
+	}
+
+	if err := p.applyCloudConfig(data, "john"); err == nil {
+		t.Errorf("Expected keys of undefined user to fail")
+	}
+}
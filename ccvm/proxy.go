@@ -0,0 +1,433 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The daemon can tunnel the traffic of host applications through an instance
+// using an ssh dynamic forward and, optionally, an HTTP proxy relaying to it.
+
+const (
+	defaultProxyAddress = "127.0.0.1"
+	proxyStartTimeout   = 30 * time.Second
+	proxyDialTimeout    = 30 * time.Second
+)
+
+// hopHeaders are the headers that apply to a single HTTP connection and
+// that are not relayed by the HTTP proxy.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Keep-Alive",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func checkProxyPort(port int) error {
+	if port < 0 || port > 65535 {
+		return errors.Errorf("Invalid proxy port %d", port)
+	}
+	if systemMode && port != 0 && port < 1024 {
+		return errors.Errorf("Proxy port %d is a privileged port", port)
+	}
+	return nil
+}
+
+// freeLocalPort returns a port of the loopback interface that is not in
+// use.
+func freeLocalPort() (int, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort(defaultProxyAddress, "0"))
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to find a free port")
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+	return port, nil
+}
+
+// socksConnect asks the SOCKS5 proxy at the other end of rw to connect to
+// host:port.  The host name is resolved by the proxy.
+func socksConnect(rw io.ReadWriter, host string, port int) error {
+	if len(host) == 0 || len(host) > 255 {
+		return errors.Errorf("Invalid host name %q", host)
+	}
+
+	if _, err := rw.Write([]byte{5, 1, 0}); err != nil {
+		return errors.Wrap(err, "Unable to write to SOCKS proxy")
+	}
+	buf := make([]byte, 258)
+	if _, err := io.ReadFull(rw, buf[:2]); err != nil {
+		return errors.Wrap(err, "Unable to read from SOCKS proxy")
+	}
+	if buf[0] != 5 || buf[1] != 0 {
+		return errors.New("SOCKS proxy requires authentication")
+	}
+
+	req := append([]byte{5, 1, 0, 3, byte(len(host))}, host...)
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := rw.Write(req); err != nil {
+		return errors.Wrap(err, "Unable to write to SOCKS proxy")
+	}
+	if _, err := io.ReadFull(rw, buf[:4]); err != nil {
+		return errors.Wrap(err, "Unable to read from SOCKS proxy")
+	}
+	if buf[1] != 0 {
+		return errors.Errorf("Unable to connect to %s through SOCKS proxy: error %d",
+			net.JoinHostPort(host, strconv.Itoa(port)), buf[1])
+	}
+
+	var addrLen int
+	switch buf[3] {
+	case 1:
+		addrLen = net.IPv4len
+	case 4:
+		addrLen = net.IPv6len
+	case 3:
+		if _, err := io.ReadFull(rw, buf[:1]); err != nil {
+			return errors.Wrap(err, "Unable to read from SOCKS proxy")
+		}
+		addrLen = int(buf[0])
+	default:
+		return errors.Errorf("Unexpected SOCKS address type %d", buf[3])
+	}
+	if _, err := io.ReadFull(rw, buf[:addrLen+2]); err != nil {
+		return errors.Wrap(err, "Unable to read from SOCKS proxy")
+	}
+	return nil
+}
+
+// dialSOCKS connects to target, a host:port address, through the SOCKS
+// proxy listening on proxyAddr.
+func dialSOCKS(ctx context.Context, proxyAddr, target string) (net.Conn, error) {
+	host, p, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, errors.Errorf("Invalid port in %s", target)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, proxyDialTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+	if err := socksConnect(conn, host, port); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+// httpProxy is an HTTP proxy that relays requests through a SOCKS proxy.
+type httpProxy struct {
+	socksAddr string
+	transport *http.Transport
+}
+
+func newHTTPProxy(socksAddr string) *httpProxy {
+	return &httpProxy{
+		socksAddr: socksAddr,
+		transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialSOCKS(ctx, socksAddr, addr)
+			},
+		},
+	}
+}
+
+func (p *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.connect(w, r)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		http.Error(w, "Only proxy requests are supported", http.StatusBadRequest)
+		return
+	}
+
+	out := r.WithContext(r.Context())
+	out.RequestURI = ""
+	out.Close = false
+	if r.ContentLength == 0 {
+		out.Body = nil
+	}
+	out.Header = make(http.Header, len(r.Header))
+	for k, v := range r.Header {
+		out.Header[k] = v
+	}
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// connect serves a CONNECT request by relaying the client's connection to
+// the requested host through the SOCKS proxy.
+func (p *httpProxy) connect(w http.ResponseWriter, r *http.Request) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "CONNECT is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := dialSOCKS(r.Context(), p.socksAddr, r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	_, err = client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	if err != nil {
+		_ = client.Close()
+		_ = conn.Close()
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(conn, buf.Reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(client, conn)
+		done <- struct{}{}
+	}()
+	<-done
+	_ = client.Close()
+	_ = conn.Close()
+	<-done
+}
+
+// waitForSOCKS waits until the SOCKS proxy started by ssh accepts
+// connections on addr.  doneCh is closed when ssh exits.
+func waitForSOCKS(ctx context.Context, addr string, doneCh <-chan struct{}) error {
+	deadline := time.After(proxyStartTimeout)
+	for {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-doneCh:
+			return errors.New("ssh exited")
+		case <-deadline:
+			return errors.Errorf("Timed out waiting for SOCKS proxy on %s", addr)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func sshError(err error, stderr *bytes.Buffer) error {
+	if err == nil {
+		err = errors.New("ssh exited")
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return errors.Errorf("SSH connection failed: %v: %s", err, msg)
+	}
+	return errors.Wrap(err, "SSH connection failed")
+}
+
+// proxy runs the proxies requested by args through the instance
+// args.Name until ctx is cancelled.  ready is called once the proxies
+// accept connections.
+func (c ccvmBackend) proxy(ctx context.Context, args *types.ProxyArgs, ready func(*types.ProxyStatus)) error {
+	if args.SOCKSPort == 0 && args.HTTPPort == 0 {
+		return errors.New("No proxy port specified")
+	}
+	for _, port := range []int{args.SOCKSPort, args.HTTPPort} {
+		if err := checkProxyPort(port); err != nil {
+			return err
+		}
+	}
+	address := args.Address
+	if address == "" {
+		address = defaultProxyAddress
+	}
+	if net.ParseIP(address) == nil {
+		return errors.Errorf("Invalid proxy address %s", address)
+	}
+
+	details, err := c.sshDetails(ctx, args.Name)
+	if err != nil {
+		return err
+	}
+	if !sshReachable(ctx, details.SSH.Host, details.SSH.Port) {
+		return errors.Errorf("Unable to reach the SSH server of %s.  Is the instance running?", args.Name)
+	}
+
+	var status types.ProxyStatus
+	var socksAddr string
+	if args.SOCKSPort != 0 {
+		socksAddr = net.JoinHostPort(address, strconv.Itoa(args.SOCKSPort))
+		status.SOCKS = socksAddr
+	} else {
+		port, err := freeLocalPort()
+		if err != nil {
+			return err
+		}
+		socksAddr = net.JoinHostPort(defaultProxyAddress, strconv.Itoa(port))
+	}
+
+	sshCtx, cancel := context.WithCancel(ctx)
+	sshArgs := append(sshOptions(details), "-N",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "ServerAliveInterval=30",
+		"-D", socksAddr,
+		details.SSH.Host.String())
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(sshCtx, "ssh", sshArgs...)
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return errors.Wrap(err, "Unable to start ssh")
+	}
+
+	var sshErr error
+	doneCh := make(chan struct{})
+	go func() {
+		sshErr = cmd.Wait()
+		close(doneCh)
+	}()
+	defer func() {
+		cancel()
+		<-doneCh
+	}()
+
+	if err := waitForSOCKS(ctx, socksAddr, doneCh); err != nil {
+		select {
+		case <-doneCh:
+			return sshError(sshErr, &stderr)
+		default:
+			return err
+		}
+	}
+
+	if args.HTTPPort != 0 {
+		l, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(args.HTTPPort)))
+		if err != nil {
+			return errors.Wrap(err, "Unable to start HTTP proxy")
+		}
+		status.HTTP = l.Addr().String()
+		srv := &http.Server{Handler: newHTTPProxy(socksAddr)}
+		go func() { _ = srv.Serve(l) }()
+		defer func() { _ = srv.Close() }()
+	}
+
+	if status.SOCKS != "" {
+		instanceLog(args.Name).Infof("SOCKS proxy listening on %s", status.SOCKS)
+	}
+	if status.HTTP != "" {
+		instanceLog(args.Name).Infof("HTTP proxy listening on %s", status.HTTP)
+	}
+	ready(&status)
+
+	select {
+	case <-ctx.Done():
+		instanceLog(args.Name).Infof("Proxy stopped")
+		return nil
+	case <-doneCh:
+		return sshError(sshErr, &stderr)
+	}
+}
+
+// proxy runs proxies through an instance.  Like exec, it runs outside of
+// the instance's loop, so that the instance can be stopped while the
+// proxies run.
+func (s *ccvmService) proxy(ctx context.Context, args *types.ProxyArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+
+	go func() {
+		defer close(resultCh)
+
+		send := func(v interface{}) bool {
+			select {
+			case resultCh <- v:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		proxyArgs := *args
+		proxyArgs.Name = instanceName
+		err := s.b.proxy(ctx, &proxyArgs, func(status *types.ProxyStatus) {
+			send(*status)
+		})
+		if err != nil {
+			send(err)
+			return
+		}
+		send(types.ProxyStatus{Finished: true})
+	}()
+}
@@ -0,0 +1,190 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// startSOCKSServer starts a minimal SOCKS5 server that supports the
+// CONNECT command and returns its address.
+func startSOCKSServer(t *testing.T) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS(conn)
+		}
+	}()
+
+	return l.Addr().String(), func() { _ = l.Close() }
+}
+
+func serveSOCKS(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	buf := make([]byte, 258)
+	if _, err := io.ReadFull(conn, buf[:3]); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:5]); err != nil || buf[3] != 3 {
+		return
+	}
+	hostLen := int(buf[4])
+	if _, err := io.ReadFull(conn, buf[:hostLen+2]); err != nil {
+		return
+	}
+	host := string(buf[:hostLen])
+	port := int(buf[hostLen])<<8 | int(buf[hostLen+1])
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer func() { _ = target.Close() }()
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0}); err != nil {
+		return
+	}
+
+	go func() { _, _ = io.Copy(target, conn) }()
+	_, _ = io.Copy(conn, target)
+}
+
+func TestDialSOCKS(t *testing.T) {
+	socksAddr, stop := startSOCKSServer(t)
+	defer stop()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("hello\n"))
+		_ = conn.Close()
+	}()
+
+	conn, err := dialSOCKS(context.Background(), socksAddr, l.Addr().String())
+	if err != nil {
+		t.Fatalf("Unable to connect through SOCKS proxy: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Errorf("Unexpected data %q: %v", line, err)
+	}
+
+	_, err = dialSOCKS(context.Background(), socksAddr, "127.0.0.1:1")
+	if err == nil {
+		t.Errorf("Connection to closed port expected to fail")
+	}
+}
+
+func TestHTTPProxy(t *testing.T) {
+	socksAddr, stop := startSOCKSServer(t)
+	defer stop()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Method, r.URL.Path)
+	}))
+	defer backend.Close()
+
+	tlsBackend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "tls %s", r.URL.Path)
+	}))
+	defer tlsBackend.Close()
+
+	proxy := httptest.NewServer(newHTTPProxy(socksAddr))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+	}
+	resp, err := client.Get(backend.URL + "/plain")
+	if err != nil {
+		t.Fatalf("Proxied request failed: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "GET /plain" {
+		t.Errorf("Unexpected response %q", body)
+	}
+
+	tlsTransport := tlsBackend.Client().Transport.(*http.Transport)
+	tlsTransport.Proxy = http.ProxyURL(proxyURL)
+	resp, err = tlsBackend.Client().Get(tlsBackend.URL + "/connect")
+	if err != nil {
+		t.Fatalf("CONNECT request failed: %v", err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "tls /connect" {
+		t.Errorf("Unexpected response %q", body)
+	}
+
+	resp, err = http.Get(proxy.URL + "/direct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Direct request to proxy returned %d", resp.StatusCode)
+	}
+}
+
+func TestCheckProxyPort(t *testing.T) {
+	for _, port := range []int{0, 1080, 65535} {
+		if err := checkProxyPort(port); err != nil {
+			t.Errorf("Unexpected error for port %d: %v", port, err)
+		}
+	}
+	for _, port := range []int{-1, 65536} {
+		if err := checkProxyPort(port); err == nil {
+			t.Errorf("Port %d expected to be invalid", port)
+		}
+	}
+}
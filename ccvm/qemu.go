@@ -0,0 +1,182 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const defaultQemuBinary = "qemu-system-x86_64"
+
+var qemuVersionRegexp = regexp.MustCompile(`version (\d+)\.(\d+)(?:\.(\d+))?`)
+var qemuDeviceRegexp = regexp.MustCompile(`^name "([^"]+)"`)
+
+type qemuVersion [3]int
+
+func (v qemuVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+func (v qemuVersion) less(o qemuVersion) bool {
+	for i := range v {
+		if v[i] != o[i] {
+			return v[i] < o[i]
+		}
+	}
+	return false
+}
+
+// qemuCaps contains the capabilities of a qemu binary as determined by
+// probing it.
+type qemuCaps struct {
+	version qemuVersion
+	devices map[string]struct{}
+}
+
+func (c *qemuCaps) hasDevice(name string) bool {
+	_, ok := c.devices[name]
+	return ok
+}
+
+var qemuCapsCache = struct {
+	sync.Mutex
+	caps map[string]*qemuCaps
+}{
+	caps: make(map[string]*qemuCaps),
+}
+
+func parseQemuVersion(version string) (qemuVersion, error) {
+	var v qemuVersion
+
+	components := strings.Split(strings.TrimSpace(version), ".")
+	if len(components) == 0 || len(components) > 3 {
+		return v, errors.Errorf("Invalid qemu version %s", version)
+	}
+
+	for i, c := range components {
+		n, err := strconv.Atoi(c)
+		if err != nil {
+			return v, errors.Errorf("Invalid qemu version %s", version)
+		}
+		v[i] = n
+	}
+
+	return v, nil
+}
+
+func parseQemuVersionOutput(output []byte) (qemuVersion, error) {
+	var v qemuVersion
+
+	matches := qemuVersionRegexp.FindSubmatch(output)
+	if matches == nil {
+		return v, errors.New("Unable to determine qemu version")
+	}
+
+	for i := range v {
+		if len(matches[i+1]) == 0 {
+			continue
+		}
+		v[i], _ = strconv.Atoi(string(matches[i+1]))
+	}
+
+	return v, nil
+}
+
+func parseQemuDevices(output []byte) map[string]struct{} {
+	devices := make(map[string]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		matches := qemuDeviceRegexp.FindStringSubmatch(scanner.Text())
+		if matches != nil {
+			devices[matches[1]] = struct{}{}
+		}
+	}
+	return devices
+}
+
+// probeQemu determines the capabilities of the qemu binary located at
+// binary.  The results are cached for the lifetime of the daemon.
+func probeQemu(ctx context.Context, binary string) (*qemuCaps, error) {
+	qemuCapsCache.Lock()
+	caps, ok := qemuCapsCache.caps[binary]
+	qemuCapsCache.Unlock()
+	if ok {
+		return caps, nil
+	}
+
+	out, err := exec.CommandContext(ctx, binary, "-version").Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to execute %s", binary)
+	}
+
+	version, err := parseQemuVersionOutput(out)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to probe %s", binary)
+	}
+
+	out, err = exec.CommandContext(ctx, binary, "-device", "help").CombinedOutput()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to list devices supported by %s", binary)
+	}
+
+	caps = &qemuCaps{
+		version: version,
+		devices: parseQemuDevices(out),
+	}
+
+	qemuCapsCache.Lock()
+	qemuCapsCache.caps[binary] = caps
+	qemuCapsCache.Unlock()
+
+	return caps, nil
+}
+
+// checkQemu probes the qemu binary identified by cfg that runs guests of
+// architecture arch and verifies that it meets the minimum version
+// requirement, if any.  It returns the path of the binary and its
+// capabilities.
+func checkQemu(ctx context.Context, cfg *qemuConfig, arch string) (string, *qemuCaps, error) {
+	binary := qemuBinary(cfg, arch)
+
+	caps, err := probeQemu(ctx, binary)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if cfg.MinVersion != "" {
+		minVersion, err := parseQemuVersion(cfg.MinVersion)
+		if err != nil {
+			return "", nil, err
+		}
+		if caps.version.less(minVersion) {
+			return "", nil, errors.Errorf("%s is version %s, version %s or later is required",
+				binary, caps.version, minVersion)
+		}
+	}
+
+	return binary, caps, nil
+}
@@ -0,0 +1,78 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"testing"
+)
+
+const qemuVersionOutput = `QEMU emulator version 2.11.1(Debian 1:2.11+dfsg-1ubuntu7.4)
+Copyright (c) 2003-2017 Fabrice Bellard and the QEMU Project developers
+`
+
+const qemuDeviceOutput = `Controller/Bridge/Hub devices:
+name "i82801b11-bridge", bus PCI
+name "pci-bridge", bus PCI, desc "Standard PCI Bridge"
+
+Storage devices:
+name "virtio-9p-pci", bus PCI, alias "virtio-9p"
+name "virtio-blk-pci", bus PCI, alias "virtio-blk"
+`
+
+func TestParseQemuVersion(t *testing.T) {
+	v, err := parseQemuVersionOutput([]byte(qemuVersionOutput))
+	if err != nil {
+		t.Fatalf("Unable to parse qemu version: %v", err)
+	}
+
+	if v != (qemuVersion{2, 11, 1}) {
+		t.Errorf("Unexpected version %s", v)
+	}
+
+	min, err := parseQemuVersion("2.12")
+	if err != nil {
+		t.Fatalf("Unable to parse version: %v", err)
+	}
+
+	if !v.less(min) {
+		t.Errorf("Expected %s to be less than %s", v, min)
+	}
+
+	if min.less(v) {
+		t.Errorf("Expected %s to be greater than %s", min, v)
+	}
+
+	if _, err := parseQemuVersion("2.x"); err == nil {
+		t.Errorf("Expected invalid version to fail")
+	}
+}
+
+func TestParseQemuDevices(t *testing.T) {
+	caps := qemuCaps{
+		devices: parseQemuDevices([]byte(qemuDeviceOutput)),
+	}
+
+	for _, d := range []string{"pci-bridge", "virtio-9p-pci", "virtio-blk-pci"} {
+		if !caps.hasDevice(d) {
+			t.Errorf("Expected device %s to be found", d)
+		}
+	}
+
+	if caps.hasDevice("vhost-user-fs-pci") {
+		t.Errorf("Unexpected device vhost-user-fs-pci found")
+	}
+}
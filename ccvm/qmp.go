@@ -0,0 +1,113 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// qmpResponse is a message received from a QMP server.  Events, which have
+// neither a return value nor an error, are ignored.
+type qmpResponse struct {
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+}
+
+func qmpReadResponse(dec *json.Decoder) (json.RawMessage, error) {
+	for {
+		var resp qmpResponse
+		err := dec.Decode(&resp)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read QMP response")
+		}
+		if resp.Error != nil {
+			return nil, errors.New(resp.Error.Desc)
+		}
+		if resp.Return != nil {
+			return resp.Return, nil
+		}
+	}
+}
+
+// qmpExecute executes a single QMP command, that is not supported by govmm,
+// on the instance whose instance directory is instanceDir and returns its
+// raw result.
+func qmpExecute(ctx context.Context, instanceDir, command string,
+	args map[string]interface{}) (json.RawMessage, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path.Join(instanceDir, "socket"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to connect to VM")
+	}
+	defer func() { _ = conn.Close() }()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-doneCh:
+		}
+	}()
+
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+
+	var greeting map[string]interface{}
+	err = dec.Decode(&greeting)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read QMP greeting")
+	}
+
+	err = enc.Encode(map[string]interface{}{"execute": "qmp_capabilities"})
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to send QMP command")
+	}
+	_, err = qmpReadResponse(dec)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to query QEMU caps")
+	}
+
+	cmd := map[string]interface{}{"execute": command}
+	if args != nil {
+		cmd["arguments"] = args
+	}
+	err = enc.Encode(cmd)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to send QMP command")
+	}
+
+	ret, err := qmpReadResponse(dec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s failed", command)
+	}
+
+	return ret, nil
+}
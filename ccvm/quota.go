@@ -0,0 +1,158 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Mounts with a quota are copied into an ext4 image of the quota's size,
+// mounted on the host with fuse2fs and shared with the guest in place of
+// the host directory.
+
+func sharesDir(instanceDir string) string {
+	return path.Join(instanceDir, "shares")
+}
+
+func isMountPoint(dir string) (bool, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false, errors.Wrap(err, "Unable to read mount table")
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 && fields[4] == dir {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+func createQuotaImage(ctx context.Context, image string, m *types.Mount) error {
+	f, err := os.Create(image)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to create image for %s", m.Tag)
+	}
+	err = f.Truncate(int64(m.QuotaMiB) * 1024 * 1024)
+	_ = f.Close()
+	if err != nil {
+		_ = os.Remove(image)
+		return errors.Wrapf(err, "Unable to size image for %s", m.Tag)
+	}
+
+	out, err := exec.CommandContext(ctx, "mkfs.ext4", "-q", "-F", "-d", m.Path,
+		image).CombinedOutput()
+	if err != nil {
+		_ = os.Remove(image)
+		return errors.Wrapf(err, "Unable to populate image for %s: %s", m.Tag, string(out))
+	}
+
+	return nil
+}
+
+// prepareQuotaMounts ensures that the images backing the mounts of in that
+// have quotas exist and are mounted on the host.  It returns a copy of in
+// whose mounts refer to the directories that should be shared with the
+// guest.
+func prepareQuotaMounts(ctx context.Context, instanceDir string, in *types.VMSpec) (*types.VMSpec, error) {
+	spec := *in
+	spec.Mounts = make([]types.Mount, len(in.Mounts))
+	copy(spec.Mounts, in.Mounts)
+
+	for i := range spec.Mounts {
+		m := &spec.Mounts[i]
+		if m.QuotaMiB == 0 {
+			continue
+		}
+
+		shareDir := path.Join(sharesDir(instanceDir), m.Tag)
+		err := os.MkdirAll(shareDir, 0700)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to create %s", shareDir)
+		}
+
+		image := shareDir + ".img"
+		if _, err := os.Stat(image); err != nil {
+			if err := createQuotaImage(ctx, image, m); err != nil {
+				return nil, err
+			}
+		}
+
+		mounted, err := isMountPoint(shareDir)
+		if err != nil {
+			return nil, err
+		}
+		if !mounted {
+			out, err := exec.CommandContext(ctx, "fuse2fs", image, shareDir).CombinedOutput()
+			if err != nil {
+				return nil, errors.Wrapf(err, "Unable to mount image for %s: %s",
+					m.Tag, string(out))
+			}
+		}
+
+		m.Path = shareDir
+	}
+
+	return &spec, nil
+}
+
+// releaseQuotaMounts unmounts any images mounted by prepareQuotaMounts.
+func releaseQuotaMounts(instanceDir string) error {
+	dir := sharesDir(instanceDir)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "Unable to read %s", dir)
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		shareDir := path.Join(dir, e.Name())
+		mounted, err := isMountPoint(shareDir)
+		if err != nil {
+			return err
+		}
+		if !mounted {
+			continue
+		}
+
+		out, err := exec.Command("fusermount", "-u", shareDir).CombinedOutput()
+		if err != nil {
+			return errors.Wrapf(err, "Unable to unmount %s: %s", shareDir, string(out))
+		}
+	}
+
+	return nil
+}
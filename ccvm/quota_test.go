@@ -0,0 +1,59 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestPrepareQuotaMountsNoQuota(t *testing.T) {
+	instanceDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(instanceDir) }()
+
+	in := &types.VMSpec{
+		Mounts: []types.Mount{
+			{Tag: "hostgo", SecurityModel: "passthrough", Path: "/tmp"},
+		},
+	}
+
+	spec, err := prepareQuotaMounts(context.Background(), instanceDir, in)
+	if err != nil {
+		t.Fatalf("prepareQuotaMounts failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(spec, in) {
+		t.Errorf("Mounts without quotas should not be modified")
+	}
+
+	if _, err := os.Stat(sharesDir(instanceDir)); err == nil {
+		t.Errorf("shares directory should not be created")
+	}
+
+	err = releaseQuotaMounts(instanceDir)
+	if err != nil {
+		t.Errorf("releaseQuotaMounts failed: %v", err)
+	}
+}
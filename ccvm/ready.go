@@ -0,0 +1,270 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The readiness checks of a workload are run in the guest, over SSH, each
+// time its VM boots, until they all pass or ready_timeout expires.  The
+// outcome is recorded in readyFile.
+
+const (
+	readyFile           = "ready.yaml"
+	defaultReadyTimeout = 10 * time.Minute
+	readyInterval       = 5 * time.Second
+	readyProbeTimeout   = 5
+)
+
+// readyCheck is a readiness check declared by a workload.  Exactly one of
+// its fields is set.
+type readyCheck struct {
+	CloudInit bool   `yaml:"cloud_init,omitempty"`
+	TCP       int    `yaml:"tcp,omitempty"`
+	HTTP      string `yaml:"http,omitempty"`
+	Command   string `yaml:"command,omitempty"`
+}
+
+func (rc *readyCheck) String() string {
+	switch {
+	case rc.CloudInit:
+		return "cloud-init"
+	case rc.TCP != 0:
+		return fmt.Sprintf("tcp port %d", rc.TCP)
+	case rc.HTTP != "":
+		return "http " + rc.HTTP
+	default:
+		return "command " + rc.Command
+	}
+}
+
+// guestCommand returns the command run in the guest to perform the check.
+func (rc *readyCheck) guestCommand() string {
+	switch {
+	case rc.CloudInit:
+		return "cloud-init status --wait"
+	case rc.TCP != 0:
+		return fmt.Sprintf("timeout %d bash -c %s", readyProbeTimeout,
+			shellQuote(fmt.Sprintf("exec 3<>/dev/tcp/127.0.0.1/%d", rc.TCP)))
+	case rc.HTTP != "":
+		return fmt.Sprintf("curl -fsS -o /dev/null --max-time %d %s", readyProbeTimeout,
+			shellQuote(rc.HTTP))
+	default:
+		return rc.Command
+	}
+}
+
+func (rc *readyCheck) check() error {
+	set := 0
+	if rc.CloudInit {
+		set++
+	}
+	if rc.TCP != 0 {
+		if rc.TCP < 0 || rc.TCP > 65535 {
+			return errors.Errorf("Invalid tcp port %d", rc.TCP)
+		}
+		set++
+	}
+	if rc.HTTP != "" {
+		u, err := url.Parse(rc.HTTP)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Errorf("Invalid http URL %s", rc.HTTP)
+		}
+		set++
+	}
+	if rc.Command != "" {
+		set++
+	}
+	if set != 1 {
+		return errors.New("Readiness checks must have exactly one of cloud_init, tcp, http or command")
+	}
+	return nil
+}
+
+// checkReadyChecks checks the readiness checks and the timeout declared
+// by a workload.
+func checkReadyChecks(checks []readyCheck, timeout string) error {
+	for i := range checks {
+		if err := checks[i].check(); err != nil {
+			return err
+		}
+	}
+	_, err := parseReadyTimeout(timeout)
+	return err
+}
+
+func parseReadyTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return defaultReadyTimeout, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		return 0, errors.Errorf("Invalid ready_timeout %s", timeout)
+	}
+	return d, nil
+}
+
+type readyRecord struct {
+	State string    `yaml:"state"`
+	Time  time.Time `yaml:"time"`
+	Error string    `yaml:"error,omitempty"`
+}
+
+func loadReadiness(instanceDir string) readyRecord {
+	var rr readyRecord
+
+	data, err := ioutil.ReadFile(path.Join(instanceDir, readyFile))
+	if err != nil {
+		return rr
+	}
+	_ = yaml.Unmarshal(data, &rr)
+
+	return rr
+}
+
+func saveReadiness(instanceDir string, rr *readyRecord) error {
+	data, err := yaml.Marshal(rr)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal readiness record")
+	}
+
+	err = ioutil.WriteFile(path.Join(instanceDir, readyFile), data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to write readiness record")
+	}
+
+	return nil
+}
+
+// resetReadiness marks an instance whose VM has just been booted as not
+// yet ready.
+func resetReadiness(instanceDir string) {
+	err := saveReadiness(instanceDir, &readyRecord{
+		State: types.ReadyPending,
+		Time:  time.Now(),
+	})
+	if err != nil {
+		instanceDirLog(instanceDir).Warningf("%v", err)
+	}
+}
+
+// runReadyChecks runs the readiness checks of spec in the guest of the
+// instance name until they have all passed or the workload's ready_timeout
+// has expired.
+func (c ccvmBackend) runReadyChecks(ctx context.Context, name string, spec *workloadSpec) error {
+	timeout, err := parseReadyTimeout(spec.ReadyTimeout)
+	if err != nil {
+		return err
+	}
+	if spec.ReadyTimeout == "" && spec.windows() {
+		timeout = windowsReadyTimeout
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pending := spec.Ready
+	if len(pending) == 0 {
+		pending = []readyCheck{{Command: "true"}}
+		if spec.windows() {
+			pending = []readyCheck{{Command: windowsReadyCommand}}
+		}
+	}
+
+	var lastErr error
+	for {
+		var failed []readyCheck
+		for _, rc := range pending {
+			var stderr bytes.Buffer
+			status, err := c.execCommand(checkCtx, name, rc.guestCommand(), ioutil.Discard, &stderr)
+			if err == nil && status != 0 {
+				err = errors.Errorf("exit status %d", status)
+				if msg := strings.TrimSpace(stderr.String()); msg != "" {
+					err = errors.Errorf("%s: %s", err, msg)
+				}
+			}
+			if err != nil {
+				lastErr = errors.Wrapf(err, "%s", &rc)
+				failed = append(failed, rc)
+			}
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+		pending = failed
+
+		select {
+		case <-checkCtx.Done():
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errors.Wrapf(lastErr, "Instance not ready after %v", timeout)
+		case <-time.After(readyInterval):
+		}
+	}
+}
+
+// checkReady runs the readiness checks of the instance name if its VM has
+// been booted since they were last run, and records their outcome.
+func (c ccvmBackend) checkReady(ctx context.Context, name string) error {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if loadReadiness(ws.instanceDir).State != types.ReadyPending {
+		return nil
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load instance state")
+	}
+
+	err = c.runReadyChecks(ctx, name, &wkld.spec)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	rr := readyRecord{
+		State: types.ReadyOK,
+		Time:  time.Now(),
+	}
+	if err != nil {
+		rr.State = types.ReadyFailed
+		rr.Error = err.Error()
+		instanceLog(name).Warningf("%v", err)
+	} else {
+		instanceLog(name).Infof("Instance ready")
+	}
+	if err := saveReadiness(ws.instanceDir, &rr); err != nil {
+		return err
+	}
+
+	return nil
+}
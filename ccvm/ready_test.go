@@ -0,0 +1,102 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestReadyChecks(t *testing.T) {
+	var spec workloadSpec
+	err := yaml.Unmarshal([]byte(`
+ready:
+  - cloud_init: true
+  - tcp: 5432
+  - http: http://localhost:8080/healthz
+  - command: systemctl is-active docker
+ready_timeout: 5m
+`), &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkReadyChecks(spec.Ready, spec.ReadyTimeout); err != nil {
+		t.Fatalf("Valid readiness checks rejected: %v", err)
+	}
+
+	expected := []string{
+		"cloud-init status --wait",
+		"timeout 5 bash -c 'exec 3<>/dev/tcp/127.0.0.1/5432'",
+		"curl -fsS -o /dev/null --max-time 5 'http://localhost:8080/healthz'",
+		"systemctl is-active docker",
+	}
+	for i := range expected {
+		if cmd := spec.Ready[i].guestCommand(); cmd != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], cmd)
+		}
+	}
+
+	invalid := []struct {
+		checks  []readyCheck
+		timeout string
+	}{
+		{[]readyCheck{{}}, ""},
+		{[]readyCheck{{CloudInit: true, TCP: 22}}, ""},
+		{[]readyCheck{{TCP: 70000}}, ""},
+		{[]readyCheck{{HTTP: "localhost:8080"}}, ""},
+		{nil, "soon"},
+		{nil, "-1m"},
+	}
+	for _, test := range invalid {
+		if checkReadyChecks(test.checks, test.timeout) == nil {
+			t.Errorf("Invalid readiness checks %+v %s accepted", test.checks, test.timeout)
+		}
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	instanceDir, err := ioutil.TempDir("", "ccloudvm-ready-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(instanceDir) }()
+
+	if rr := loadReadiness(instanceDir); rr.State != "" {
+		t.Errorf("Unexpected state %s for an instance never booted", rr.State)
+	}
+
+	resetReadiness(instanceDir)
+	if rr := loadReadiness(instanceDir); rr.State != types.ReadyPending {
+		t.Errorf("Booted instance not pending: %s", rr.State)
+	}
+
+	err = saveReadiness(instanceDir, &readyRecord{
+		State: types.ReadyFailed,
+		Error: "tcp port 5432: exit status 1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := loadReadiness(instanceDir)
+	if rr.State != types.ReadyFailed || rr.Error != "tcp port 5432: exit status 1" {
+		t.Errorf("Unexpected readiness record %+v", rr)
+	}
+}
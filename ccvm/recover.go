@@ -0,0 +1,244 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+// When the daemon starts it reconciles the state store with the instance
+// directories and the hypervisor processes found on the host.
+
+// instanceRecord is the record of an instance in the state store.
+type instanceRecord struct {
+	HostIP  string `json:"host_ip"`
+	Group   string `json:"group,omitempty"`
+	Network string `json:"network,omitempty"`
+}
+
+func newInstanceRecord(details *types.InstanceDetails) instanceRecord {
+	return instanceRecord{
+		HostIP:  details.VMSpec.HostIP.String(),
+		Group:   details.Group,
+		Network: networkName(details.VMSpec.Network),
+	}
+}
+
+// hypervisorProcess is a hypervisor process running the VM of an instance.
+type hypervisorProcess struct {
+	pid        int
+	instance   string
+	hypervisor string
+}
+
+// hypervisorOfBinary returns the hypervisor implemented by binary, or an
+// empty string if binary is not a known hypervisor.
+func hypervisorOfBinary(binary string) string {
+	base := filepath.Base(binary)
+	switch {
+	case strings.HasPrefix(base, "qemu-system-"):
+		return hypervisorQemu
+	case base == hypervisorFirecracker:
+		return hypervisorFirecracker
+	case base == hypervisorCloudHypervisor:
+		return hypervisorCloudHypervisor
+	}
+	return ""
+}
+
+// recordCreatedInstance stores the record of the newly created instance
+// name.  It is called by the loop of the instance, so the status method of
+// the backend, which is also called by the loops of other instances, must
+// be safe for concurrent use.
+func (s *ccvmService) recordCreatedInstance(ctx context.Context, name string) {
+	details, err := s.b.status(ctx, name)
+	if err != nil {
+		instanceLog(name).Warningf("Unable to record instance: %v", err)
+		return
+	}
+
+	st, err := stateStore(s.ccvmDir)
+	if err == nil {
+		rec := newInstanceRecord(details)
+		err = st.update(func(tx *storeTx) error {
+			return tx.put(bucketInstances, name, &rec)
+		})
+	}
+	if err != nil {
+		instanceLog(name).Warningf("Unable to record instance: %v", err)
+	}
+}
+
+// forgetInstance removes the records of an instance that no longer exists.
+func (s *ccvmService) forgetInstance(name string) {
+	st, err := stateStore(s.ccvmDir)
+	if err == nil {
+		err = st.update(func(tx *storeTx) error {
+			_ = tx.delete(bucketStatus, name)
+			return tx.delete(bucketInstances, name)
+		})
+	}
+	if err != nil {
+		instanceLog(name).Warningf("Unable to forget instance: %v", err)
+	}
+}
+
+// reconcileRecords adopts the instances that have no record in the state
+// store and removes the records of instances whose directory has gone.
+func (s *ccvmService) reconcileRecords(found map[string]instanceRecord) {
+	st, err := stateStore(s.ccvmDir)
+	if err != nil {
+		logErrorf("Unable to open state store: %v", err)
+		return
+	}
+
+	// Instances whose state cannot be read are not found but keep their
+	// records until their directories are removed.
+	gone := func(name string) bool {
+		if _, ok := found[name]; ok {
+			return false
+		}
+		_, err := os.Stat(filepath.Join(s.ccvmDir, "instances", name))
+		return os.IsNotExist(err)
+	}
+
+	err = st.update(func(tx *storeTx) error {
+		for _, name := range tx.keys(bucketInstances) {
+			if gone(name) {
+				instanceLog(name).Warningf("Instance directory has disappeared, forgetting instance")
+				_ = tx.delete(bucketInstances, name)
+			}
+		}
+		for _, bucket := range []string{bucketSpecs, bucketStatus} {
+			for _, name := range tx.keys(bucket) {
+				if gone(name) {
+					_ = tx.delete(bucket, name)
+				}
+			}
+		}
+
+		for name, rec := range found {
+			var stored instanceRecord
+			ok, _ := tx.get(bucketInstances, name, &stored)
+			if !ok {
+				instanceLog(name).Infof("Adopting instance")
+			}
+			if !ok || stored != rec {
+				if err := tx.put(bucketInstances, name, &rec); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logErrorf("Unable to reconcile instance records: %v", err)
+	}
+}
+
+// removeStalePidFiles removes the pid files of the processes of an instance
+// that are no longer running, so that their pids are not mistaken for those
+// of the instance's processes once they are reused.
+func removeStalePidFiles(instanceDir string) {
+	pidFiles, _ := filepath.Glob(filepath.Join(instanceDir, "*.pid"))
+	for _, pidFile := range pidFiles {
+		name := strings.TrimSuffix(filepath.Base(pidFile), ".pid")
+		if !processRunning(instanceDir, name) {
+			instanceDirLog(instanceDir).Debugf("Removing stale pid file %s", pidFile)
+			_ = os.Remove(pidFile)
+		}
+	}
+}
+
+// adoptProcesses looks for hypervisor processes running the VMs of
+// instances and restores the pid files of those that have lost them, so
+// that the VMs can be controlled and accounted for again.  Hypervisor
+// processes of instances that no longer exist are reported.
+func (s *ccvmService) adoptProcesses() {
+	instancesDir := filepath.Join(s.ccvmDir, "instances")
+	for name := range s.instances {
+		removeStalePidFiles(filepath.Join(instancesDir, name))
+	}
+
+	procs, err := instanceProcesses("/proc", instancesDir)
+	if err != nil {
+		logDebugf("Unable to look for hypervisor processes: %v", err)
+		return
+	}
+
+	for _, p := range procs {
+		l := instanceLog(p.instance)
+		if _, ok := s.instances[p.instance]; !ok {
+			l.Warningf("Orphaned %s process %d is running the VM of an instance that no longer exists",
+				p.hypervisor, p.pid)
+			continue
+		}
+
+		instanceDir := filepath.Join(instancesDir, p.instance)
+		if pid, err := processPid(instanceDir, p.hypervisor); err == nil && pid == p.pid {
+			continue
+		}
+
+		l.Infof("Re-adopting %s process %d", p.hypervisor, p.pid)
+		pidPath := filepath.Join(instanceDir, p.hypervisor+".pid")
+		if err := ioutil.WriteFile(pidPath, []byte(strconv.Itoa(p.pid)), 0600); err != nil {
+			l.Warningf("Unable to write pid file: %v", err)
+		}
+	}
+}
+
+// reconcileStatus probes the status of each instance and reports the VMs
+// whose state changed while the daemon was not running.  VMs found running
+// are adopted.
+func (s *ccvmService) reconcileStatus(wasRunning map[string]bool) {
+	for name, running := range wasRunning {
+		name, running := name, running
+		instanceCh := s.instances[name]
+		kickCh := s.monitors[name]
+		instanceCh <- instanceCmd{
+			cmdType:  instanceCmdOther,
+			resultCh: make(chan interface{}, 1),
+			fn: func() error {
+				details, err := s.b.refreshStatus(s.guestCtx, name, 0)
+				if err != nil {
+					instanceLog(name).Warningf("Unable to probe status: %v", err)
+					return nil
+				}
+				switch {
+				case details.Status.Running && !running:
+					instanceLog(name).Infof("VM was started while the service was not running, re-adopting it")
+					s.events.publish(name, types.EventStarted)
+					kickMonitor(kickCh)
+				case details.Status.Running:
+					instanceLog(name).Infof("Adopted running VM")
+					kickMonitor(kickCh)
+				case running:
+					instanceLog(name).Warningf("VM exited while the service was not running")
+					s.events.publish(name, types.EventStopped)
+				}
+				return nil
+			},
+		}
+	}
+}
@@ -0,0 +1,183 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestInstanceProcesses(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("procfs is not available")
+	}
+
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	instancesDir := "/home/user/.ccloudvm/instances"
+	cmdlines := map[string][]string{
+		"10":   {"/usr/bin/qemu-system-x86_64", "-pidfile", instancesDir + "/dev/qemu.pid"},
+		"11":   {"firecracker", "--api-sock", instancesDir + "/test/firecracker.socket"},
+		"12":   {"/usr/bin/vim", instancesDir + "/dev/state.yaml"},
+		"13":   {"qemu-system-aarch64", "-drive", "file=/var/lib/images/disk.qcow2"},
+		"self": {"qemu-system-x86_64", instancesDir + "/dev/qemu.pid"},
+	}
+	for pid, args := range cmdlines {
+		if err := os.MkdirAll(filepath.Join(dir, pid), 0755); err != nil {
+			t.Fatalf("Unable to create process directory: %v", err)
+		}
+		data := []byte(strings.Join(args, "\x00") + "\x00")
+		if err := ioutil.WriteFile(filepath.Join(dir, pid, "cmdline"), data, 0644); err != nil {
+			t.Fatalf("Unable to write cmdline: %v", err)
+		}
+	}
+
+	procs, err := instanceProcesses(dir, instancesDir)
+	if err != nil {
+		t.Fatalf("Unable to find processes: %v", err)
+	}
+	expected := []hypervisorProcess{
+		{pid: 10, instance: "dev", hypervisor: hypervisorQemu},
+		{pid: 11, instance: "test", hypervisor: hypervisorFirecracker},
+	}
+	if !reflect.DeepEqual(procs, expected) {
+		t.Errorf("Unexpected processes %+v", procs)
+	}
+}
+
+func TestReconcileRecords(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	for _, name := range []string{"dev", "broken"} {
+		if err := os.MkdirAll(filepath.Join(ccvmDir, "instances", name), 0755); err != nil {
+			t.Fatalf("Unable to create instance directory: %v", err)
+		}
+	}
+
+	st, err := stateStore(ccvmDir)
+	if err != nil {
+		t.Fatalf("Unable to open store: %v", err)
+	}
+	stale := instanceRecord{HostIP: "127.0.0.3"}
+	err = st.update(func(tx *storeTx) error {
+		for _, name := range []string{"gone", "broken"} {
+			if err := tx.put(bucketInstances, name, &stale); err != nil {
+				return err
+			}
+			if err := tx.put(bucketStatus, name, &cachedStatus{Running: true}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to update store: %v", err)
+	}
+
+	s := &ccvmService{ccvmDir: ccvmDir}
+	rec := instanceRecord{HostIP: "127.0.0.2", Network: "default"}
+	s.reconcileRecords(map[string]instanceRecord{"dev": rec})
+
+	var instances, status []string
+	var loaded instanceRecord
+	_ = st.view(func(tx *storeTx) error {
+		instances = tx.keys(bucketInstances)
+		status = tx.keys(bucketStatus)
+		_, err = tx.get(bucketInstances, "dev", &loaded)
+		return nil
+	})
+	if !reflect.DeepEqual(instances, []string{"broken", "dev"}) || loaded != rec {
+		t.Errorf("Unexpected instance records %v %+v: %v", instances, loaded, err)
+	}
+	if !reflect.DeepEqual(status, []string{"broken"}) {
+		t.Errorf("Unexpected status records %v", status)
+	}
+
+	s.forgetInstance("broken")
+	_ = st.view(func(tx *storeTx) error {
+		instances = tx.keys(bucketInstances)
+		status = tx.keys(bucketStatus)
+		return nil
+	})
+	if !reflect.DeepEqual(instances, []string{"dev"}) || len(status) != 0 {
+		t.Errorf("Instance not forgotten %v %v", instances, status)
+	}
+}
+
+func TestStalePidFiles(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("procfs is not available")
+	}
+
+	instanceDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(instanceDir) }()
+
+	cmd := exec.Command("sh", "-c", "sleep 30", filepath.Join(instanceDir, "firecracker.socket"))
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Unable to start process: %v", err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	// The pid of the test process stands for a pid that has been reused
+	// by a process unrelated to the instance.
+	pids := map[string]int{
+		"firecracker": cmd.Process.Pid,
+		"swtpm":       os.Getpid(),
+	}
+	for name, pid := range pids {
+		pidPath := filepath.Join(instanceDir, name+".pid")
+		if err := ioutil.WriteFile(pidPath, []byte(strconv.Itoa(pid)), 0600); err != nil {
+			t.Fatalf("Unable to write pid file: %v", err)
+		}
+	}
+
+	if !processRunning(instanceDir, "firecracker") || processRunning(instanceDir, "swtpm") {
+		t.Errorf("Processes of the instance not identified")
+	}
+	if err := killProcess(instanceDir, "swtpm"); err == nil {
+		t.Errorf("Unrelated process killed")
+	}
+
+	removeStalePidFiles(instanceDir)
+	if _, err := processPid(instanceDir, "firecracker"); err != nil {
+		t.Errorf("Pid file of running process removed: %v", err)
+	}
+	if _, err := processPid(instanceDir, "swtpm"); err == nil {
+		t.Errorf("Stale pid file not removed")
+	}
+}
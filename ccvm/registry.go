@@ -0,0 +1,175 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Exported instances are pushed to and pulled from OCI registries as ORAS
+// artifacts, using the oras command.
+
+const (
+	ociArtifactType      = "application/vnd.ccloudvm.bundle.v1"
+	ociWorkloadMediaType = "application/vnd.ccloudvm.workload.v1+yaml"
+	ociImageMediaType    = "application/vnd.ccloudvm.image.v1.qcow2"
+)
+
+// registryWorkloadName returns the default name of the workload pulled from
+// reference, i.e., the last component of its repository.
+func registryWorkloadName(reference string) string {
+	if i := strings.Index(reference, "@"); i != -1 {
+		reference = reference[:i]
+	}
+	name := path.Base(reference)
+	if i := strings.Index(name, ":"); i != -1 {
+		name = name[:i]
+	}
+	return name
+}
+
+func oras(ctx context.Context, ws *workspace, dir string, plainHTTP bool, args ...string) error {
+	if _, err := exec.LookPath("oras"); err != nil {
+		return errors.New("oras is required to use OCI registries.  See https://oras.land to install it")
+	}
+
+	if plainHTTP {
+		args = append(args, "--plain-http")
+	}
+	cmd := exec.CommandContext(ctx, "oras", args...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	if vars := proxyVarsFN(ws); vars != "" {
+		cmd.Env = append(cmd.Env, strings.Fields(vars)...)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "oras %s failed: %s", args[0], strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// pushWorkload pushes the instance exported to archive to the registry as
+// reference.
+func (c ccvmBackend) pushWorkload(ctx context.Context, args *types.PushArgs) error {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	if err := ws.checkUserPath(args.Path); err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "ccloudvm-push-")
+	if err != nil {
+		return errors.Wrap(err, "Unable to create temporary directory")
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	image, data, err := readExportArchive(args.Path, dir)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(image, path.Join(dir, exportedImageFile)); err != nil {
+		return errors.Wrapf(err, "Unable to extract %s", args.Path)
+	}
+	err = ioutil.WriteFile(path.Join(dir, exportedWorkloadFile), data, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to extract %s", args.Path)
+	}
+
+	return oras(ctx, ws, dir, args.PlainHTTP, "push", args.Reference,
+		"--artifact-type", ociArtifactType,
+		exportedWorkloadFile+":"+ociWorkloadMediaType,
+		exportedImageFile+":"+ociImageMediaType)
+}
+
+// pullWorkload pulls the exported instance stored in the registry as
+// reference and imports it as a workload, whose name is returned.
+func (c ccvmBackend) pullWorkload(ctx context.Context, args *types.PullArgs) (string, error) {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return "", err
+	}
+
+	name := args.Name
+	if name == "" {
+		name = registryWorkloadName(args.Reference)
+	}
+
+	// The artifact is pulled to the images directory so that its image
+	// can be moved, rather than copied, to its final location.
+
+	var dir string
+	defer func() {
+		if dir != "" {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+	err = importBundle(ws.ccvmDir, name, args.Reference, func(imagesDir string) (string, []byte, error) {
+		var err error
+		dir, err = ioutil.TempDir(imagesDir, "pull-")
+		if err != nil {
+			return "", nil, errors.Wrap(err, "Unable to create temporary directory")
+		}
+		err = oras(ctx, ws, dir, args.PlainHTTP, "pull", args.Reference, "--output", dir)
+		if err != nil {
+			return "", nil, err
+		}
+		data, err := ioutil.ReadFile(path.Join(dir, exportedWorkloadFile))
+		if err != nil {
+			return "", nil, errors.Errorf("%s is not an exported instance", args.Reference)
+		}
+		image := path.Join(dir, exportedImageFile)
+		if _, err := os.Stat(image); err != nil {
+			return "", nil, errors.Errorf("%s is not an exported instance", args.Reference)
+		}
+		return image, data, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+func (s *ccvmService) pushWorkload(ctx context.Context, args *types.PushArgs, resultCh chan interface{}) {
+	go func() {
+		resultCh <- s.b.pushWorkload(ctx, args)
+		close(resultCh)
+	}()
+}
+
+func (s *ccvmService) pullWorkload(ctx context.Context, args *types.PullArgs, resultCh chan interface{}) {
+	go func() {
+		name, err := s.b.pullWorkload(ctx, args)
+		if err != nil {
+			resultCh <- err
+		} else {
+			resultCh <- name
+		}
+		close(resultCh)
+	}()
+}
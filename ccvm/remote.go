@@ -0,0 +1,269 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Workloads loaded from http, https and git URLs are cached in
+// remoteWorkloadsDir, in files named after a hash of their URL.
+const remoteWorkloadsDir = "remote-workloads"
+
+func remoteWorkloadPath(ccvmDir, URL string) string {
+	return path.Join(ccvmDir, remoteWorkloadsDir,
+		fmt.Sprintf("%x.yaml", sha256.Sum256([]byte(URL))))
+}
+
+func remoteWorkloadSourcePath(workloadPath string) string {
+	return strings.TrimSuffix(workloadPath, ".yaml") + ".source"
+}
+
+// isGitWorkload indicates whether u identifies a workload stored in a git
+// repository.  Such URLs use the git scheme, a scheme prefixed with git+,
+// e.g., git+ssh, or have a path ending with .git.
+func isGitWorkload(u *url.URL) bool {
+	return u.Scheme == "git" || strings.HasPrefix(u.Scheme, "git+") ||
+		strings.HasSuffix(u.Path, ".git")
+}
+
+// isRemoteWorkload indicates whether u identifies a workload that is
+// cached.
+func isRemoteWorkload(u *url.URL) bool {
+	return u.Scheme == "http" || u.Scheme == "https" || isGitWorkload(u)
+}
+
+// gitWorkloadSource splits the URL of a workload stored in a git repository
+// into the URL of the repository, the branch or tag to check out, if any,
+// and the path of the workload in the repository.  These are specified by
+// the fragment of the URL, which has the form [<ref>:]<path>.
+func gitWorkloadSource(u *url.URL) (repo, ref, file string, err error) {
+	fragment := u.Fragment
+	if fragment == "" {
+		return "", "", "", errors.Errorf("Missing workload path in %s, e.g., #workloads/dev.yaml", u)
+	}
+	if i := strings.Index(fragment, ":"); i != -1 {
+		ref, fragment = fragment[:i], fragment[i+1:]
+	}
+	file = path.Clean(fragment)
+	if path.IsAbs(file) || file == ".." || strings.HasPrefix(file, "../") {
+		return "", "", "", errors.Errorf("Invalid workload path %s in %s", fragment, u)
+	}
+
+	r := *u
+	r.Fragment = ""
+	r.Scheme = strings.TrimPrefix(r.Scheme, "git+")
+	return r.String(), ref, file, nil
+}
+
+func workloadFromGit(ctx context.Context, ws *workspace, u *url.URL) ([]byte, error) {
+	repo, ref, file, err := gitWorkloadSource(u)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "git+file" {
+		if err := ws.checkLocalFile(u.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "ccloudvm-workload-")
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create temporary directory")
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	args = append(args, "--", repo, dir)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if vars := proxyVarsFN(ws); vars != "" {
+		cmd.Env = append(cmd.Env, strings.Fields(vars)...)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, errors.Wrapf(err, "Unable to clone %s: %s", repo, strings.TrimSpace(string(out)))
+	}
+
+	// The workload may be a symbolic link, which must not lead out of
+	// the repository.
+
+	p, err := filepath.EvalSymlinks(filepath.Join(dir, filepath.FromSlash(file)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read %s from %s", file, repo)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read %s from %s", file, repo)
+	}
+	if !strings.HasPrefix(p, root+string(filepath.Separator)) {
+		return nil, errors.Errorf("Workload %s is outside %s", file, repo)
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to read %s from %s", file, repo)
+	}
+	return data, nil
+}
+
+// validateWorkloadData checks that data, downloaded from URL, contains
+// either a workload whose templates can be executed or a group workload.
+func validateWorkloadData(ws *workspace, URL string, data []byte) error {
+	docs := splitYaml(data)
+	if len(docs) != 2 {
+		_, err := parseGroupSpec(URL, data)
+		return err
+	}
+
+	var wkld workload
+	if err := unmarshalWorkload(ws, &wkld, string(docs[0]), string(docs[1])); err != nil {
+		return errors.Wrapf(err, "Invalid workload %s", URL)
+	}
+	_, err := template.New("user-data").Funcs(workloadFuncMap).Parse(wkld.userData)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid cloud-init document in %s", URL)
+	}
+	return nil
+}
+
+// fetchRemoteWorkload downloads the workload identified by u, validates it
+// and stores it in the cache.
+func fetchRemoteWorkload(ctx context.Context, ws *workspace, u *url.URL, transport *http.Transport) ([]byte, error) {
+	var data []byte
+	var err error
+	if isGitWorkload(u) {
+		data, err = workloadFromGit(ctx, ws, u)
+	} else {
+		data, err = workloadFromURL(ctx, *u, transport)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	URL := u.String()
+	if err = validateWorkloadData(ws, URL, data); err != nil {
+		return nil, err
+	}
+
+	p := remoteWorkloadPath(ws.ccvmDir, URL)
+	if err = os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return nil, errors.Wrap(err, "Unable to create remote workload cache")
+	}
+	if err = writeFileAtomic(remoteWorkloadSourcePath(p), []byte(URL+"\n")); err != nil {
+		return nil, err
+	}
+	if err = writeFileAtomic(p, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// remoteWorkload returns the cached copy of the workload identified by u,
+// downloading it if it is not yet cached.
+func remoteWorkload(ctx context.Context, ws *workspace, u *url.URL, transport *http.Transport) ([]byte, error) {
+	data, err := ioutil.ReadFile(remoteWorkloadPath(ws.ccvmDir, u.String()))
+	if err == nil {
+		return data, nil
+	}
+	if offlineFromContext(ctx) {
+		return nil, errNotCached("Workload " + u.String())
+	}
+
+	return fetchRemoteWorkload(ctx, ws, u, transport)
+}
+
+// cachedRemoteWorkloads returns the sorted URLs of the cached workloads.
+func cachedRemoteWorkloads(ccvmDir string) []string {
+	files, _ := filepath.Glob(path.Join(ccvmDir, remoteWorkloadsDir, "*.source"))
+	URLs := make([]string, 0, len(files))
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		URLs = append(URLs, strings.TrimSpace(string(data)))
+	}
+	sort.Strings(URLs)
+	return URLs
+}
+
+// updateWorkloads downloads again the remote workloads identified by URLs,
+// or all the cached remote workloads if URLs is empty.  A cached workload is
+// only replaced if its new version is valid.  The URLs of the updated
+// workloads are returned.
+func (c ccvmBackend) updateWorkloads(ctx context.Context, URLs []string) ([]string, error) {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(URLs) == 0 {
+		URLs = cachedRemoteWorkloads(ws.ccvmDir)
+	}
+
+	transport := getHTTPTransport(ws.HTTPProxy, ws.HTTPSProxy, ws.NoProxy)
+	updated := make([]string, 0, len(URLs))
+	var failed []string
+	for _, URL := range URLs {
+		u, err := url.Parse(URL)
+		if err == nil && !isRemoteWorkload(u) {
+			err = errors.Errorf("%s is not a remote workload", URL)
+		}
+		if err == nil {
+			_, err = fetchRemoteWorkload(ctx, ws, u, transport)
+		}
+		if err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		updated = append(updated, URL)
+	}
+
+	if len(failed) > 0 {
+		return updated, errors.Errorf("Unable to update workloads: %s", strings.Join(failed, "; "))
+	}
+	return updated, nil
+}
+
+func (s *ccvmService) updateWorkloads(ctx context.Context, URLs []string, resultCh chan interface{}) {
+	go func() {
+		updated, err := s.b.updateWorkloads(ctx, URLs)
+		if err != nil {
+			resultCh <- err
+		} else {
+			resultCh <- updated
+		}
+		close(resultCh)
+	}()
+}
@@ -0,0 +1,196 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitWorkloadSource(t *testing.T) {
+	tests := []struct {
+		URL  string
+		repo string
+		ref  string
+		file string
+	}{
+		{"https://example.com/workloads.git#dev.yaml", "https://example.com/workloads.git", "", "dev.yaml"},
+		{"git+ssh://git@example.com/workloads#v1.0:ci/dev.yaml", "ssh://git@example.com/workloads", "v1.0", "ci/dev.yaml"},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.URL)
+		if !isRemoteWorkload(u) || !isGitWorkload(u) {
+			t.Errorf("%s not identified as a git workload", tt.URL)
+			continue
+		}
+		repo, ref, file, err := gitWorkloadSource(u)
+		if err != nil || repo != tt.repo || ref != tt.ref || file != tt.file {
+			t.Errorf("Unexpected source for %s: %s %s %s %v", tt.URL, repo, ref, file, err)
+		}
+	}
+
+	for _, URL := range []string{"git://example.com/workloads", "git://example.com/w#../dev.yaml"} {
+		u, _ := url.Parse(URL)
+		if _, _, _, err := gitWorkloadSource(u); err == nil {
+			t.Errorf("Invalid git workload %s accepted", URL)
+		}
+	}
+}
+
+func TestGitWorkload(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+
+	defer func() {
+		if err := os.RemoveAll(ccvmDir); err != nil {
+			t.Errorf("Failed to remove %s : %v", ccvmDir, err)
+		}
+	}()
+
+	repo := filepath.Join(ccvmDir, "repo")
+	if err := os.MkdirAll(filepath.Join(repo, "ci"), 0755); err != nil {
+		t.Fatalf("Unable to create repository: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(repo, "ci", "dev.yaml"), []byte(sampleWorkload), 0644)
+	if err != nil {
+		t.Fatalf("Unable to write workload: %v", err)
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "workload"},
+		{"tag", "v1"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v %s", args, err, out)
+		}
+	}
+
+	ws := &workspace{ccvmDir: ccvmDir, network: defaultNetwork()}
+	data, err := loadWorkloadData(context.Background(), ws, "git+file://"+repo+"#v1:ci/dev.yaml", nil)
+	if err != nil || string(data) != sampleWorkload {
+		t.Errorf("Unable to load workload from git: %v", err)
+	}
+
+	// Repositories of the daemon's user cannot be cloned on behalf of
+	// other users of a system mode daemon.
+
+	otherDir := filepath.Join(ccvmDir, "other")
+	other := &workspace{
+		ccvmDir: otherDir,
+		network: defaultNetwork(),
+		account: &account{name: "alice", uid: os.Getuid() + 1, ccvmDir: otherDir},
+		UID:     os.Getuid() + 1,
+		User:    "alice",
+	}
+	_, err = loadWorkloadData(context.Background(), other, "git+file://"+repo+"#v1:ci/dev.yaml", nil)
+	if err == nil {
+		t.Errorf("Repository of another user cloned")
+	}
+
+	// Workloads cannot be symbolic links leading out of the repository.
+
+	if err := os.Symlink(filepath.Join(ccvmDir, "secret.yaml"), filepath.Join(repo, "link.yaml")); err != nil {
+		t.Fatalf("Unable to create link: %v", err)
+	}
+	for _, args := range [][]string{
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "link"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v %s", args, err, out)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(ccvmDir, "secret.yaml"), []byte(sampleWorkload), 0600); err != nil {
+		t.Fatalf("Unable to write secret: %v", err)
+	}
+	if _, err := workloadFromGit(context.Background(), ws, mustParseURL(t, "git+file://"+repo+"#link.yaml")); err == nil {
+		t.Errorf("Workload linked out of the repository read")
+	}
+}
+
+func mustParseURL(t *testing.T, URL string) *url.URL {
+	u, err := url.Parse(URL)
+	if err != nil {
+		t.Fatalf("Unable to parse %s: %v", URL, err)
+	}
+	return u
+}
+
+func TestRemoteWorkloadCache(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+
+	defer func() {
+		if err := os.RemoveAll(ccvmDir); err != nil {
+			t.Errorf("Failed to remove %s : %v", ccvmDir, err)
+		}
+	}()
+
+	content := sampleWorkload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	ws := &workspace{ccvmDir: ccvmDir, network: defaultNetwork()}
+	URL := ts.URL + "/dev.yaml"
+	data, err := loadWorkloadData(context.Background(), ws, URL, &http.Transport{})
+	if err != nil || string(data) != sampleWorkload {
+		t.Fatalf("Unable to load remote workload: %v", err)
+	}
+
+	content = "vm: {{.Broken\n...\n---\n#cloud-config\n"
+	data, err = loadWorkloadData(context.Background(), ws, URL, &http.Transport{})
+	if err != nil || string(data) != sampleWorkload {
+		t.Errorf("Cached workload not used: %v", err)
+	}
+
+	u, _ := url.Parse(URL)
+	if _, err = fetchRemoteWorkload(context.Background(), ws, u, &http.Transport{}); err == nil {
+		t.Errorf("Invalid workload accepted")
+	}
+	data, err = ioutil.ReadFile(remoteWorkloadPath(ccvmDir, URL))
+	if err != nil || string(data) != sampleWorkload {
+		t.Errorf("Cached workload replaced by invalid workload: %v", err)
+	}
+
+	URLs := cachedRemoteWorkloads(ccvmDir)
+	if len(URLs) != 1 || URLs[0] != URL {
+		t.Errorf("Unexpected cached workloads %v", URLs)
+	}
+}
@@ -0,0 +1,185 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Renaming a stopped instance moves its directory, and everything else named
+// after it, to the new name.  The new name is reserved by starting its
+// instance loop as soon as the rename is requested.
+
+func (c ccvmBackend) rename(ctx context.Context, name, newName string) error {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return err
+	}
+	newDir := path.Join(path.Dir(ws.instanceDir), newName)
+
+	if _, err := os.Lstat(newDir); err == nil {
+		return errors.Errorf("Instance %s already exists", newName)
+	}
+
+	hv, err := c.instanceHypervisor(ws)
+	if err != nil {
+		return err
+	}
+	if hv.running(ctx, ws.instanceDir) {
+		return errors.New("The instance must be stopped before it can be renamed")
+	}
+	if loadSuspension(ws.instanceDir) != nil {
+		return errors.New("Suspended instances cannot be renamed")
+	}
+
+	err = releaseQuotaMounts(ws.instanceDir)
+	if err != nil {
+		return err
+	}
+
+	key, keyErr := readDiskKey(diskKeyName(ws.instanceDir))
+	policy := loadBackupPolicy(ws.instanceDir)
+
+	err = os.Rename(ws.instanceDir, newDir)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to rename %s", name)
+	}
+
+	err = renameWorkload(ws.instanceDir, newDir)
+	if err != nil {
+		_ = os.Rename(newDir, ws.instanceDir)
+		return errors.Wrapf(err, "Unable to rename %s", name)
+	}
+
+	if keyErr == nil {
+		if err := storeDiskKey(diskKeyName(newDir), key); err != nil {
+			logWarningf("Unable to store the key of %s in the keyring: %v", newDir, err)
+		}
+		forgetDiskKey(diskKeyName(ws.instanceDir))
+	}
+
+	renameVolumeClaims(ws.ccvmDir, name, newName)
+
+	backupDir := ""
+	if policy != nil {
+		backupDir = policy.Dir
+	}
+	renameInstanceBackups(backupRoot(ws.ccvmDir, backupDir), name, newName)
+	daemonSink.renameInstanceLog(name, newName)
+
+	return nil
+}
+
+// renameInstanceBackups moves the backups of the instance name stored in
+// root to those of newName.
+func renameInstanceBackups(root, name, newName string) {
+	oldDir := path.Join(root, name)
+	if _, err := os.Stat(oldDir); err != nil {
+		return
+	}
+	if err := os.Rename(oldDir, path.Join(root, newName)); err != nil {
+		logWarningf("Unable to rename the backups of %s: %v", name, err)
+	}
+}
+
+// recordedHostIP returns the host IP address, as recorded in the state
+// store, of the instance name.
+func (s *ccvmService) recordedHostIP(name string) (uint32, error) {
+	st, err := stateStore(s.ccvmDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var rec instanceRecord
+	var ok bool
+	err = st.view(func(tx *storeTx) error {
+		ok, err = tx.get(bucketInstances, name, &rec)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errors.Errorf("No record of instance %s", name)
+	}
+
+	return flattenIP(net.ParseIP(rec.HostIP))
+}
+
+func (s *ccvmService) rename(ctx context.Context, args *types.RenameArgs, resultCh chan interface{}) {
+	fail := func(err error) {
+		resultCh <- err
+		close(resultCh)
+	}
+
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		fail(err)
+		return
+	}
+	newName := args.NewName
+	if !hostnameRegexp.MatchString(newName) {
+		fail(errors.Errorf("Invalid hostname %s", newName))
+		return
+	}
+	if _, ok := s.instances[newName]; ok {
+		fail(errors.Errorf("Instance %s already exists", newName))
+		return
+	}
+	flatIP, err := s.recordedHostIP(instanceName)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	newCh := s.startInstanceLoop(newName, flatIP)
+	s.networks[newName] = s.networks[instanceName]
+	if group, ok := s.groups[instanceName]; ok {
+		s.groups[newName] = group
+	}
+
+	renameCh := make(chan interface{}, 1)
+	s.instances[instanceName] <- instanceCmd{
+		cmdType:    instanceCmdDelete,
+		resultCh:   renameCh,
+		ctx:        ctx,
+		progressCh: resultCh,
+		fn: func() error {
+			return s.b.rename(ctx, instanceName, newName)
+		},
+	}
+
+	newCh <- instanceCmd{
+		cmdType:  instanceCmdCreate,
+		resultCh: resultCh,
+		fn: func() error {
+			err, _ := (<-renameCh).(error)
+			if err == nil {
+				instanceLog(newName).Infof("Renamed from %s", instanceName)
+				s.recordCreatedInstance(ctx, newName)
+				s.events.publish(newName, types.EventRenamed)
+			}
+			return err
+		},
+	}
+}
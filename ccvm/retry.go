@@ -0,0 +1,174 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Downloads made while an instance is created are retried when they fail
+// for transient reasons.
+
+const (
+	defaultRetryAttempts     = 3
+	defaultRetryInitialDelay = 5 * time.Second
+	defaultRetryMaxDelay     = time.Minute
+)
+
+// retryConfig contains the daemon wide retry settings.  Attempts is the
+// maximum number of attempts made by each phase.  The delay between two
+// attempts starts at InitialDelay and doubles after each failure, up to
+// MaxDelay.
+type retryConfig struct {
+	Attempts     int    `yaml:"attempts"`
+	InitialDelay string `yaml:"initial_delay"`
+	MaxDelay     string `yaml:"max_delay"`
+}
+
+type retryPolicy struct {
+	attempts     int
+	initialDelay time.Duration
+	maxDelay     time.Duration
+}
+
+// policy returns the retry policy described by r, applying defaults for
+// the settings it does not specify.
+func (r *retryConfig) policy() (retryPolicy, error) {
+	p := retryPolicy{
+		attempts:     defaultRetryAttempts,
+		initialDelay: defaultRetryInitialDelay,
+		maxDelay:     defaultRetryMaxDelay,
+	}
+
+	if r.Attempts < 0 {
+		return p, errors.Errorf("Invalid number of retry attempts %d", r.Attempts)
+	} else if r.Attempts > 0 {
+		p.attempts = r.Attempts
+	}
+
+	for _, d := range []struct {
+		value string
+		dest  *time.Duration
+	}{
+		{r.InitialDelay, &p.initialDelay},
+		{r.MaxDelay, &p.maxDelay},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v < 0 {
+			return p, errors.Errorf("Invalid retry delay %s", d.value)
+		}
+		*d.dest = v
+	}
+
+	if p.maxDelay < p.initialDelay {
+		p.maxDelay = p.initialDelay
+	}
+
+	return p, nil
+}
+
+// aptLockTimeout returns the number of seconds for which apt waits for the
+// package database lock in the guest.
+func (p retryPolicy) aptLockTimeout() int {
+	return int(p.maxDelay/time.Second) * p.attempts
+}
+
+// do calls fn until it succeeds, fails with an error that is not
+// transient or has been called p.attempts times.  Each failure that is
+// followed by another attempt is described by a line passed to report.
+func (p retryPolicy) do(ctx context.Context, what string, report func(line string),
+	fn func() error) error {
+	delay := p.initialDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.attempts || ctx.Err() != nil || !isTransient(err) {
+			return err
+		}
+
+		report(fmt.Sprintf("%s failed: %v\nRetrying in %v (attempt %d of %d)\n",
+			what, err, delay, attempt+1, p.attempts))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > p.maxDelay {
+			delay = p.maxDelay
+		}
+	}
+}
+
+// httpStatusError is returned when a server responds to a download request
+// with an unexpected status.
+type httpStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("Failed to download %s : %s", e.URL, e.Status)
+}
+
+// isTransient returns true if err is likely to be caused by a temporary
+// condition, such as a timeout, a dropped connection or a server error.
+func isTransient(err error) bool {
+	err = errors.Cause(err)
+	for {
+		switch e := err.(type) {
+		case *httpStatusError:
+			return e.StatusCode >= 500 || e.StatusCode == http.StatusRequestTimeout ||
+				e.StatusCode == http.StatusTooManyRequests
+		case *url.Error:
+			if e.Timeout() {
+				return true
+			}
+			err = e.Err
+		case *net.OpError:
+			if e.Timeout() || e.Temporary() {
+				return true
+			}
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		case syscall.Errno:
+			return e == syscall.ECONNRESET || e == syscall.ECONNREFUSED ||
+				e == syscall.ECONNABORTED || e == syscall.ETIMEDOUT ||
+				e == syscall.EHOSTUNREACH || e == syscall.ENETUNREACH
+		case net.Error:
+			return e.Timeout() || e.Temporary()
+		default:
+			return err == io.ErrUnexpectedEOF
+		}
+	}
+}
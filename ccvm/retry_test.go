@@ -0,0 +1,114 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{&httpStatusError{StatusCode: 503, Status: "503 Service Unavailable"}, true},
+		{&httpStatusError{StatusCode: 429, Status: "429 Too Many Requests"}, true},
+		{&httpStatusError{StatusCode: 404, Status: "404 Not Found"}, false},
+		{errors.Wrap(&httpStatusError{StatusCode: 502}, "Unable to download"), true},
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: &net.OpError{
+			Op: "read", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}}}, true},
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: &net.DNSError{
+			Err: "no such host", Name: "example.com"}}, false},
+		{io.ErrUnexpectedEOF, true},
+		{context.Canceled, false},
+		{errors.New("Invalid URL"), false},
+	}
+
+	for _, test := range tests {
+		if isTransient(test.err) != test.transient {
+			t.Errorf("Expected isTransient(%v) to be %v", test.err, test.transient)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p, err := (&retryConfig{}).policy()
+	if err != nil || p.attempts != defaultRetryAttempts ||
+		p.initialDelay != defaultRetryInitialDelay || p.maxDelay != defaultRetryMaxDelay {
+		t.Errorf("Unexpected default policy %+v: %v", p, err)
+	}
+
+	for _, cfg := range []retryConfig{
+		{Attempts: -1},
+		{InitialDelay: "soon"},
+		{MaxDelay: "-1s"},
+	} {
+		if _, err := cfg.policy(); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+
+	ctx := context.Background()
+	p = retryPolicy{attempts: 3, initialDelay: time.Millisecond, maxDelay: time.Millisecond}
+	transient := &httpStatusError{StatusCode: 500, Status: "500 Internal Server Error"}
+
+	var lines []string
+	report := func(line string) { lines = append(lines, line) }
+	calls := 0
+	err = p.do(ctx, "Download", report, func() error {
+		calls++
+		if calls < 3 {
+			return transient
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected success after 3 calls, got %d calls: %v", calls, err)
+	}
+	if len(lines) != 2 || !strings.Contains(lines[1], "attempt 3 of 3") {
+		t.Errorf("Unexpected retry reports %v", lines)
+	}
+
+	calls = 0
+	err = p.do(ctx, "Download", report, func() error {
+		calls++
+		return transient
+	})
+	if err != transient || calls != 3 {
+		t.Errorf("Expected failure after 3 calls, got %d calls: %v", calls, err)
+	}
+
+	calls = 0
+	permanent := errors.New("Invalid URL")
+	err = p.do(ctx, "Download", report, func() error {
+		calls++
+		return permanent
+	})
+	if err != permanent || calls != 1 {
+		t.Errorf("Permanent error retried, %d calls: %v", calls, err)
+	}
+}
@@ -22,13 +22,15 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-	"net/rpc"
 	"os"
 	"os/signal"
+	"os/user"
 	"path/filepath"
 	"reflect"
 	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -43,43 +45,124 @@ const (
 	DoneChIndex = iota
 	ActionChIndex
 	TimeChIndex
+	ExpiryChIndex
 )
 
 var systemd bool
+var systemMode bool
 var hostnameRegexp *regexp.Regexp
 
 func init() {
-	flag.BoolVar(&systemd, "systemd", true, "Use systemd socket activation if true")
+	flag.BoolVar(&systemd, "systemd", defaultSystemd, "Use systemd socket activation if true")
+	flag.BoolVar(&systemMode, "system", false, "Serve all the users of the host if true")
 	hostnameRegexp = regexp.MustCompile("^[A-Za-z0-9\\-]+$")
 }
 
 type service interface {
 	create(context.Context, chan interface{}, *types.CreateArgs)
-	stop(context.Context, string, chan interface{})
-	start(context.Context, string, *types.VMSpec, chan interface{})
+	prefetch(context.Context, chan interface{}, *types.CreateArgs)
+	stop(context.Context, *types.StopArgs, chan interface{})
+	start(context.Context, string, *types.VMSpec, bool, chan interface{})
+	restart(context.Context, *types.RestartArgs, chan interface{})
 	quit(context.Context, string, chan interface{})
+	batch(context.Context, *types.BatchArgs, func(string) bool, chan interface{})
+	resize(context.Context, *types.ResizeArgs, chan interface{})
+	forward(context.Context, *types.ForwardArgs, bool, chan interface{})
+	mount(context.Context, *types.MountArgs, bool, chan interface{})
+	disk(context.Context, *types.DiskArgs, bool, chan interface{})
+	fsck(context.Context, *types.FsckArgs, chan interface{})
+	flatten(context.Context, string, chan interface{})
+	rename(context.Context, *types.RenameArgs, chan interface{})
+	backup(context.Context, *types.BackupArgs, chan interface{})
+	listBackups(context.Context, *types.BackupListArgs, chan interface{})
+	restoreBackup(context.Context, *types.RestoreArgs, chan interface{})
+	setBackupPolicy(context.Context, *types.BackupPolicy, chan interface{})
+	suspend(context.Context, *types.SuspendArgs, chan interface{})
+	resumeFromDisk(context.Context, *types.ResumeArgs, chan interface{})
+	set(context.Context, *types.SetArgs, chan interface{})
+	syncStatus(context.Context, string, chan interface{})
+	kubeconfig(context.Context, string, chan interface{})
+	pauseSync(context.Context, string, bool, chan interface{})
+	sshKey(context.Context, string, chan interface{})
+	auditLog(context.Context, *types.AuditLogArgs, chan interface{})
+	preflight(context.Context, *types.PreflightArgs, chan interface{})
 	delete(context.Context, string, chan interface{})
 	status(context.Context, string, chan interface{})
-	getInstances(context.Context, chan interface{})
+	refreshStatus(context.Context, *types.RefreshStatusArgs, chan interface{})
+	getInstances(context.Context, *types.InstancesArgs, chan interface{})
+	createGroup(context.Context, chan interface{}, *types.CreateArgs)
+	groupAction(context.Context, string, int, func(string) bool, chan interface{})
+	watchEvents(context.Context, *types.WatchEventsArgs, chan interface{})
+	report(context.Context, *types.ReportArgs, chan interface{})
+	consoleLog(context.Context, *types.ConsoleLogArgs, chan interface{})
+	consoleInput(context.Context, *types.ConsoleInputArgs, chan interface{})
+	createNetwork(context.Context, *types.NetworkSpec, chan interface{})
+	deleteNetwork(context.Context, string, chan interface{})
+	listNetworks(context.Context, chan interface{})
+	createVolume(context.Context, *types.VolumeSpec, chan interface{})
+	deleteVolume(context.Context, string, chan interface{})
+	listVolumes(context.Context, chan interface{})
+	listCaches(context.Context, chan interface{})
+	clearCache(context.Context, string, chan interface{})
+	updateWorkloads(context.Context, []string, chan interface{})
+	getWorkloads(context.Context, chan interface{})
+	showWorkload(context.Context, string, chan interface{})
+	validateWorkload(context.Context, *types.ValidateWorkloadArgs, chan interface{})
+	exportInstance(context.Context, *types.ExportArgs, chan interface{})
+	buildTemplate(context.Context, *types.BuildTemplateArgs, chan interface{})
+	importWorkload(context.Context, *types.ImportArgs, chan interface{})
+	importVagrant(context.Context, *types.ImportVagrantArgs, chan interface{})
+	pushWorkload(context.Context, *types.PushArgs, chan interface{})
+	pullWorkload(context.Context, *types.PullArgs, chan interface{})
+	exec(context.Context, *types.ExecArgs, chan interface{})
+	proxy(context.Context, *types.ProxyArgs, chan interface{})
 }
 
+// startAction starts a new transaction.  Interruptible transactions are
+// cancelled as soon as the service starts draining.
 type startAction struct {
-	action  func(ctx context.Context, s service, resultCh chan interface{})
-	transCh chan int
+	action        func(ctx context.Context, s service, resultCh chan interface{})
+	transCh       chan int
+	interruptible bool
+	owner         string
+}
+
+const noTransaction = -1
+
+// drainAction asks the service to stop accepting new transactions and to
+// exit once the transactions in progress have completed.  Those still in
+// progress when timeout expires are cancelled.
+type drainAction struct {
+	timeout time.Duration
 }
 
 type getResult struct {
-	ID  int
-	res chan interface{}
+	ID    int
+	owner string
+	res   chan interface{}
+}
+
+type cancelAction struct {
+	ID    int
+	owner string
 }
 
-type cancelAction int
 type completeAction int
 
+// transaction is a command in progress.  claimed is set once a client has
+// asked for its result, most recently at lastClaim.  stalled is set when
+// results of a claimed transaction are found waiting to be retrieved long
+// after they were last asked for.
 type transaction struct {
-	ctx      context.Context
-	cancel   func()
-	resultCh chan interface{}
+	ctx           context.Context
+	cancel        func()
+	resultCh      chan interface{}
+	interruptible bool
+	owner         string
+	started       time.Time
+	claimed       bool
+	lastClaim     time.Time
+	stalled       bool
 }
 
 const (
@@ -88,10 +171,13 @@ const (
 	instanceCmdDelete
 )
 
+// instanceCmd is a command executed by the loop of an instance.
 type instanceCmd struct {
-	cmdType  int
-	resultCh chan interface{}
-	fn       func() error
+	cmdType    int
+	resultCh   chan interface{}
+	fn         func() error
+	ctx        context.Context
+	progressCh chan<- interface{}
 }
 
 type ccvmService struct {
@@ -100,13 +186,58 @@ type ccvmService struct {
 	counter       int
 	shutdownTimer *time.Timer
 	transactions  map[int]transaction
+	expired       map[int]time.Time
+	txPolicy      transactionPolicy
+	idleTimeout   time.Duration
 	cases         []reflect.SelectCase
 	hostIPs       map[uint32]struct{}
 	instances     map[string]chan instanceCmd
 	hostIPMask    uint32
 	instanceChMap map[chan struct{}]string
+	groups        map[string]string
 	instanceWg    sync.WaitGroup
 	b             backend
+	events        eventBroker
+	notifier      *notifier
+	hosts         *hostsPublisher
+	accountant    *accountant
+	pressure      *pressureMonitor
+	backups       *backupScheduler
+	autostart     *autostarter
+	audit         *auditLog
+
+	// The contexts of all the transactions and background tasks of the
+	// service derive from ctx, which is cancelled when the service exits.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// draining is set once the service has been asked to drain.  New
+	// transactions are rejected and the service exits as soon as there
+	// are no transactions left.
+	draining bool
+
+	// Guest channels are served while the service runs.  guestChannels
+	// contains the names of the instances whose guest channel is
+	// connected.
+	actionCh      chan interface{}
+	guestCtx      context.Context
+	guestCancel   context.CancelFunc
+	guestChannels map[string]struct{}
+	guestWg       sync.WaitGroup
+
+	// The VM of each instance is monitored while the service runs.
+	// monitors contains the channels used to ask the monitors to start
+	// monitoring a VM that has just been booted.
+	monitors  map[string]chan struct{}
+	monitorWg sync.WaitGroup
+
+	// consoles contains the hubs through which clients access the serial
+	// consoles of the instances.
+	consoles map[string]*consoleHub
+
+	// networks contains the name of the network to which each instance
+	// is connected.
+	networks map[string]string
 }
 
 func returnCreateResult(createCmd instanceCmd, name string, err error) {
@@ -115,18 +246,81 @@ func returnCreateResult(createCmd instanceCmd, name string, err error) {
 	} else {
 		createCmd.resultCh <- types.CreateResult{
 			Name:     name,
+			Names:    []string{name},
 			Finished: true,
 		}
 	}
 	close(createCmd.resultCh)
 }
 
+func rejectInstanceCmd(cmd instanceCmd, err error) {
+	cmd.resultCh <- err
+	close(cmd.resultCh)
+}
+
+// reportQueuePosition tells the client of cmd how many commands will be
+// executed before it.  Positions are dropped rather than allowed to block
+// the instance loop if the client is not retrieving them.
+func reportQueuePosition(cmd instanceCmd, position int) {
+	if cmd.progressCh == nil {
+		return
+	}
+	select {
+	case cmd.progressCh <- types.CommandResult{Position: position}:
+	default:
+	}
+}
+
+// instanceLoop executes the commands sent to the instance name, one at a
+// time and in the order in which they are received.
 func instanceLoop(name string, instanceCh <-chan instanceCmd, closeCh chan struct{}, wg *sync.WaitGroup) {
 	var createCh chan error
 	var createCmd instanceCmd
+	var runCh chan error
+	var running instanceCmd
+	var queue []instanceCmd
 
 	deleted := false
 
+	runNext := func() {
+		for runCh == nil && len(queue) > 0 {
+			cmd := queue[0]
+			queue = queue[1:]
+			if cmd.ctx != nil && cmd.ctx.Err() != nil {
+				rejectInstanceCmd(cmd, cmd.ctx.Err())
+				continue
+			}
+			ch := make(chan error, 1)
+			go func() {
+				ch <- cmd.fn()
+			}()
+			runCh = ch
+			running = cmd
+		}
+		for i := range queue {
+			reportQueuePosition(queue[i], i+1)
+		}
+	}
+
+	finishRunning := func(err error) {
+		runCh = nil
+		if running.cmdType != instanceCmdDelete {
+			/* Instance loop is only interested in errors from create and delete */
+			close(running.resultCh)
+			return
+		}
+		running.resultCh <- err
+		close(running.resultCh)
+		if err == nil {
+			deleted = true
+			close(closeCh)
+			for _, cmd := range queue {
+				rejectInstanceCmd(cmd, errors.New("Instance does not exist"))
+			}
+			queue = nil
+		}
+	}
+
 DONE:
 	for {
 		select {
@@ -137,8 +331,7 @@ DONE:
 			switch cmd.cmdType {
 			case instanceCmdCreate:
 				if deleted {
-					cmd.resultCh <- errors.New("Instance already exists (but is being deleted)")
-					close(cmd.resultCh)
+					rejectInstanceCmd(cmd, errors.New("Instance already exists (but is being deleted)"))
 					continue
 				}
 				createCh = make(chan error)
@@ -148,27 +341,18 @@ DONE:
 				}()
 			default:
 				if deleted {
-					cmd.resultCh <- errors.New("Instance does not exist")
-					close(cmd.resultCh)
+					rejectInstanceCmd(cmd, errors.New("Instance does not exist"))
 					continue
 				}
 				if createCh != nil {
-					cmd.resultCh <- errors.New("Command not allowed while instance is being created")
-					close(cmd.resultCh)
+					rejectInstanceCmd(cmd, errors.New("Command not allowed while instance is being created"))
 					continue
 				}
-				if cmd.cmdType == instanceCmdDelete {
-					err := cmd.fn()
-					cmd.resultCh <- err
-					close(cmd.resultCh)
-					if err == nil {
-						deleted = true
-						close(closeCh)
-					}
+				queue = append(queue, cmd)
+				if runCh == nil {
+					runNext()
 				} else {
-					/* Instance loop is only interested in errors from create and delete */
-					_ = cmd.fn()
-					close(cmd.resultCh)
+					reportQueuePosition(cmd, len(queue))
 				}
 			}
 		case err := <-createCh:
@@ -178,6 +362,9 @@ DONE:
 				deleted = true
 				close(closeCh)
 			}
+		case err := <-runCh:
+			finishRunning(err)
+			runNext()
 		}
 	}
 
@@ -186,7 +373,14 @@ DONE:
 		returnCreateResult(createCmd, name, err)
 	}
 
-	fmt.Println("Instance loop quiting")
+	if runCh != nil {
+		finishRunning(<-runCh)
+	}
+	for _, cmd := range queue {
+		rejectInstanceCmd(cmd, errors.New("Service is shutting down"))
+	}
+
+	instanceLog(name).Debugf("Instance loop quitting")
 
 	wg.Done()
 }
@@ -208,6 +402,27 @@ func (s *ccvmService) startInstanceLoop(name string, flatIP uint32) chan instanc
 	s.hostIPs[flatIP] = struct{}{}
 	s.instanceWg.Add(1)
 	go instanceLoop(name, instanceCh, closeCh, &s.instanceWg)
+	s.guestWg.Add(1)
+	go func() {
+		socket := filepath.Join(s.ccvmDir, "instances", name, guestSocket)
+		watchGuest(s.guestCtx, name, socket, closeCh, s.actionCh)
+		s.guestWg.Done()
+	}()
+	hub := &consoleHub{}
+	s.consoles[name] = hub
+	s.guestWg.Add(1)
+	go func() {
+		instanceDir := filepath.Join(s.ccvmDir, "instances", name)
+		watchConsole(s.guestCtx, hub, instanceDir, closeCh)
+		s.guestWg.Done()
+	}()
+	kickCh := make(chan struct{}, 1)
+	s.monitors[name] = kickCh
+	s.monitorWg.Add(1)
+	go func() {
+		monitorVM(s.guestCtx, s.b, name, kickCh, closeCh, s.actionCh)
+		s.monitorWg.Done()
+	}()
 	s.cases = append(s.cases, reflect.SelectCase{
 		Dir:  reflect.SelectRecv,
 		Chan: reflect.ValueOf(closeCh),
@@ -236,8 +451,34 @@ func (s *ccvmService) findFreeIP() (net.IP, uint32, error) {
 	return net.IPv4(a, b, c, d), uint32(i), nil
 }
 
-func (s *ccvmService) findExistingInstances() {
+// findFreeIPs returns n distinct host IP addresses that are not in use.
+func (s *ccvmService) findFreeIPs(n int) ([]net.IP, []uint32, error) {
+	var err error
+
+	hostIPs := make([]net.IP, n)
+	flatIPs := make([]uint32, n)
+
+	// The addresses are reserved as they are found so that each
+	// instance is assigned a different one.
+	for i := 0; i < n; i++ {
+		hostIPs[i], flatIPs[i], err = s.findFreeIP()
+		if err != nil {
+			break
+		}
+		s.hostIPs[flatIPs[i]] = struct{}{}
+	}
+	for i := 0; i < n; i++ {
+		delete(s.hostIPs, flatIPs[i])
+	}
+
+	return hostIPs, flatIPs, err
+}
+
+func (s *ccvmService) findExistingInstances() []autostartEntry {
 	instancesDir := filepath.Join(s.ccvmDir, "instances")
+	found := make(map[string]instanceRecord)
+	wasRunning := make(map[string]bool)
+	var autostart []autostartEntry
 
 	_ = filepath.Walk(instancesDir, func(path string, info os.FileInfo, err error) error {
 		if path == instancesDir {
@@ -248,29 +489,44 @@ func (s *ccvmService) findExistingInstances() {
 			return nil
 		}
 
-		details, err := s.b.status(context.Background(), info.Name())
+		details, err := s.b.status(s.ctx, info.Name())
 		if err != nil {
-			fmt.Printf("Unable to read state information for %s\n", info.Name())
+			instanceLog(info.Name()).Errorf("Unable to read state information: %v", err)
 			return filepath.SkipDir
 		}
 
 		flatIP, err := flattenIP(details.VMSpec.HostIP)
 		if err != nil {
-			fmt.Printf("Unable to parse IP address %s\n", details.VMSpec.HostIP)
+			instanceLog(info.Name()).Errorf("Unable to parse IP address %s", details.VMSpec.HostIP)
 			return filepath.SkipDir
 		}
 
 		if _, ok := s.hostIPs[flatIP]; ok {
-			fmt.Printf("Host IP address already in use %s\n", details.VMSpec.HostIP)
+			instanceLog(info.Name()).Errorf("Host IP address already in use %s", details.VMSpec.HostIP)
 			return filepath.SkipDir
 		}
 
-		fmt.Printf("Starting instance %s on %s\n", info.Name(), details.VMSpec.HostIP)
+		instanceLog(info.Name()).Infof("Starting instance on %s", details.VMSpec.HostIP)
 
 		_ = s.startInstanceLoop(info.Name(), flatIP)
+		s.networks[info.Name()] = networkName(details.VMSpec.Network)
+		if details.Group != "" {
+			s.groups[info.Name()] = details.Group
+		}
+		found[info.Name()] = newInstanceRecord(details)
+		wasRunning[info.Name()] = details.Status.Running
+		if details.VMSpec.Autostart {
+			autostart = append(autostart, newAutostartEntry(info.Name(), &details.VMSpec))
+		}
 
 		return filepath.SkipDir
 	})
+
+	s.reconcileRecords(found)
+	s.adoptProcesses()
+	s.reconcileStatus(wasRunning)
+
+	return autostart
 }
 
 func (s *ccvmService) getInstance(instanceName string) (string, error) {
@@ -293,14 +549,18 @@ func (s *ccvmService) getInstance(instanceName string) (string, error) {
 	return instanceName, nil
 }
 
-func (s *ccvmService) newInstanceName() (string, error) {
+// newInstanceName returns a random name that is not used by an existing
+// instance or contained in reserved.
+func (s *ccvmService) newInstanceName(reserved map[string]struct{}) (string, error) {
 	var name string
 	var i int
 
 	for i := 0; i < maxNames(); i++ {
 		name = makeRandomName()
 		if _, ok := s.instances[name]; !ok {
-			break
+			if _, ok := reserved[name]; !ok {
+				break
+			}
 		}
 	}
 
@@ -312,8 +572,13 @@ func (s *ccvmService) newInstanceName() (string, error) {
 }
 
 func (s *ccvmService) create(ctx context.Context, resultCh chan interface{}, args *types.CreateArgs) {
+	if args.Count > 1 || args.NameTemplate != "" {
+		s.createMany(ctx, resultCh, args)
+		return
+	}
+
 	if args.Name == "" {
-		instanceName, err := s.newInstanceName()
+		instanceName, err := s.newInstanceName(nil)
 		if err != nil {
 			resultCh <- err
 			return
@@ -359,16 +624,302 @@ func (s *ccvmService) create(ctx context.Context, resultCh chan interface{}, arg
 	}
 
 	instanceCh := s.startInstanceLoop(args.Name, flatIP)
+	s.networks[args.Name] = networkName(args.CustomSpec.Network)
+	kickCh := s.monitors[args.Name]
 	instanceCh <- instanceCmd{
 		cmdType:  instanceCmdCreate,
 		resultCh: resultCh,
 		fn: func() error {
-			return s.b.createInstance(ctx, resultCh, s.downloadCh, args)
+			err := s.b.createInstance(ctx, resultCh, s.downloadCh, args)
+			if err == nil {
+				s.recordCreatedInstance(ctx, args.Name)
+				s.events.publish(args.Name, types.EventCreated)
+				kickMonitor(kickCh)
+			}
+			return err
+		},
+	}
+}
+
+// batchNames generates the names of the instances to be created by a
+// request to create multiple instances.
+func (s *ccvmService) batchNames(args *types.CreateArgs) ([]string, error) {
+	count := args.Count
+	if count < 1 {
+		count = 1
+	}
+
+	tmpl := args.NameTemplate
+	if tmpl == "" && args.Name != "" {
+		tmpl = args.Name + "-%d"
+	}
+	if tmpl != "" && (strings.Count(tmpl, "%") != 1 || !strings.Contains(tmpl, "%d")) {
+		return nil, errors.Errorf("Name template %s must contain a single %%d", tmpl)
+	}
+
+	names := make([]string, 0, count)
+	reserved := make(map[string]struct{})
+	for i := 1; i <= count; i++ {
+		var name string
+		if tmpl == "" {
+			var err error
+			name, err = s.newInstanceName(reserved)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			name = fmt.Sprintf(tmpl, i)
+			if !hostnameRegexp.MatchString(name) {
+				return nil, errors.Errorf("Invalid hostname %s", name)
+			}
+			if _, ok := s.instances[name]; ok {
+				return nil, errors.Errorf("Instance %s already exists", name)
+			}
+		}
+		reserved[name] = struct{}{}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+type createOutcome struct {
+	name string
+	err  error
+}
+
+// forwardCreateOutput forwards the output of the creation of a single
+// instance, in a request to create multiple instances, to outputCh.
+// Complete lines of output are prefixed with the name of the instance.
+// The outcome of the creation is sent to outputCh as a createOutcome.
+func forwardCreateOutput(name string, instanceResultCh <-chan interface{}, outputCh chan<- interface{}) {
+	var pending string
+	for r := range instanceResultCh {
+		switch v := r.(type) {
+		case types.CreateResult:
+			if v.Finished {
+				outputCh <- createOutcome{name: name}
+				continue
+			}
+			pending += v.Line
+			for {
+				i := strings.IndexByte(pending, '\n')
+				if i == -1 {
+					break
+				}
+				outputCh <- types.CreateResult{Line: name + ": " + pending[:i+1]}
+				pending = pending[i+1:]
+			}
+		case error:
+			outputCh <- createOutcome{name: name, err: v}
+		}
+	}
+}
+
+// createMany creates multiple instances in parallel, each in its own
+// instance loop.
+func (s *ccvmService) createMany(ctx context.Context, resultCh chan interface{}, args *types.CreateArgs) {
+	if len(args.CustomSpec.HostIP) != 0 && args.Count > 1 {
+		resultCh <- errors.New("A host IP address cannot be specified when creating multiple instances")
+		close(resultCh)
+		return
+	}
+	if (args.CustomSpec.MACAddress != "" || args.CustomSpec.Hostname != "") && args.Count > 1 {
+		resultCh <- errors.New("A MAC address or hostname cannot be specified when creating multiple instances")
+		close(resultCh)
+		return
+	}
+
+	names, err := s.batchNames(args)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+
+	hostIPs := make([]net.IP, len(names))
+	flatIPs := make([]uint32, len(names))
+	if len(args.CustomSpec.HostIP) != 0 {
+		flatIPs[0], err = flattenIP(args.CustomSpec.HostIP)
+		if err == nil {
+			if _, ok := s.hostIPs[flatIPs[0]]; ok {
+				err = errors.Errorf("IP address %s is already in use", args.CustomSpec.HostIP)
+			}
+		}
+		hostIPs[0] = args.CustomSpec.HostIP
+	} else {
+		hostIPs, flatIPs, err = s.findFreeIPs(len(names))
+	}
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+
+	creates := make([]pendingCreate, len(names))
+	for i := range names {
+		instanceArgs := cloneCreateArgs(args)
+		instanceArgs.Name = names[i]
+		instanceArgs.Count = 0
+		instanceArgs.NameTemplate = ""
+		instanceArgs.CustomSpec.HostIP = hostIPs[i]
+		creates[i] = pendingCreate{
+			args:   &instanceArgs,
+			flatIP: flatIPs[i],
+		}
+	}
+
+	s.launchCreates(ctx, resultCh, creates)
+}
+
+// cloneCreateArgs returns a copy of args that shares none of its slices and
+// maps with args, as the instances created in parallel from args modify
+// their arguments.
+func cloneCreateArgs(args *types.CreateArgs) types.CreateArgs {
+	c := *args
+	c.CustomSpec = args.CustomSpec.Clone()
+	c.AgentKeys = append([]string(nil), args.AgentKeys...)
+	c.UserData = append([]byte(nil), args.UserData...)
+	c.Params = mergeLabels(nil, args.Params)
+	c.Mirrors = mergeLabels(nil, args.Mirrors)
+	c.Labels = mergeLabels(nil, args.Labels)
+	if args.Group != nil {
+		g := *args.Group
+		g.Members = append([]types.GroupMember(nil), args.Group.Members...)
+		c.Group = &g
+	}
+	return c
+}
+
+// pendingCreate describes an instance to be created by launchCreates.
+// before, if not nil, is called in the instance's loop before the
+// instance is created.  The instance is not created if it returns an
+// error.  after, if not nil, is called with the outcome of the creation.
+type pendingCreate struct {
+	args   *types.CreateArgs
+	flatIP uint32
+	before func() error
+	after  func(error)
+}
+
+// launchCreates starts the creations described by creates and sends a
+// final CreateResult once they have all completed.
+func (s *ccvmService) launchCreates(ctx context.Context, resultCh chan interface{}, creates []pendingCreate) {
+	outputCh := make(chan interface{})
+	var wg sync.WaitGroup
+	for i := range creates {
+		pc := creates[i]
+		name := pc.args.Name
+
+		instanceResultCh := make(chan interface{})
+		wg.Add(1)
+		go func() {
+			forwardCreateOutput(name, instanceResultCh, outputCh)
+			wg.Done()
+		}()
+
+		instanceCh := s.startInstanceLoop(name, pc.flatIP)
+		s.networks[name] = networkName(pc.args.CustomSpec.Network)
+		kickCh := s.monitors[name]
+		instanceCh <- instanceCmd{
+			cmdType:  instanceCmdCreate,
+			resultCh: instanceResultCh,
+			fn: func() error {
+				var err error
+				if pc.before != nil {
+					err = pc.before()
+				}
+				if err == nil {
+					err = s.b.createInstance(ctx, instanceResultCh, s.downloadCh, pc.args)
+				}
+				if err == nil {
+					s.recordCreatedInstance(ctx, name)
+					s.events.publish(name, types.EventCreated)
+					kickMonitor(kickCh)
+				}
+				if pc.after != nil {
+					pc.after(err)
+				}
+				return err
+			},
+		}
+	}
+
+	go func() {
+		wg.Wait()
+		close(outputCh)
+	}()
+
+	go func() {
+		var failures []string
+		succeeded := make(map[string]struct{})
+		for r := range outputCh {
+			switch v := r.(type) {
+			case types.CreateResult:
+				resultCh <- v
+			case createOutcome:
+				if v.err != nil {
+					failures = append(failures, fmt.Sprintf("%s: %v", v.name, v.err))
+				} else {
+					succeeded[v.name] = struct{}{}
+				}
+			}
+		}
+
+		created := make([]string, 0, len(succeeded))
+		for _, pc := range creates {
+			if _, ok := succeeded[pc.args.Name]; ok {
+				created = append(created, pc.args.Name)
+			}
+		}
+
+		if len(failures) > 0 {
+			msg := fmt.Sprintf("Failed to create instances %s", strings.Join(failures, ", "))
+			if len(created) > 0 {
+				msg += fmt.Sprintf(".  Instances %s were created", strings.Join(created, ", "))
+			}
+			resultCh <- errors.New(msg)
+		} else {
+			resultCh <- types.CreateResult{
+				Name:     created[0],
+				Names:    created,
+				Finished: true,
+			}
+		}
+		close(resultCh)
+	}()
+}
+
+func (s *ccvmService) stop(ctx context.Context, args *types.StopArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:    instanceCmdOther,
+		resultCh:   resultCh,
+		ctx:        ctx,
+		progressCh: resultCh,
+		fn: func() error {
+			stopArgs := *args
+			stopArgs.Name = instanceName
+			res, err := s.b.stop(ctx, &stopArgs)
+			if err != nil {
+				resultCh <- err
+				return nil
+			}
+			s.events.publish(instanceName, types.EventStopped)
+			resultCh <- *res
+			return nil
 		},
 	}
 }
 
-func (s *ccvmService) stop(ctx context.Context, instanceName string, resultCh chan interface{}) {
+func (s *ccvmService) start(ctx context.Context, instanceName string, vmSpec *types.VMSpec, force bool, resultCh chan interface{}) {
 	instanceName, err := s.getInstance(instanceName)
 	if err != nil {
 		resultCh <- err
@@ -376,18 +927,55 @@ func (s *ccvmService) stop(ctx context.Context, instanceName string, resultCh ch
 		return
 	}
 	instanceCh := s.instances[instanceName]
+	kickCh := s.monitors[instanceName]
 
 	instanceCh <- instanceCmd{
-		cmdType:  instanceCmdOther,
-		resultCh: resultCh,
+		cmdType:    instanceCmdOther,
+		resultCh:   resultCh,
+		ctx:        ctx,
+		progressCh: resultCh,
+		fn: func() error {
+			err := s.b.start(ctx, instanceName, vmSpec, force)
+			if err == nil {
+				s.events.publish(instanceName, types.EventStarted)
+				kickMonitor(kickCh)
+			}
+			resultCh <- err
+			return nil
+		},
+	}
+}
+
+func (s *ccvmService) restart(ctx context.Context, args *types.RestartArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+	kickCh := s.monitors[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:    instanceCmdOther,
+		resultCh:   resultCh,
+		ctx:        ctx,
+		progressCh: resultCh,
 		fn: func() error {
-			resultCh <- s.b.stop(ctx, instanceName)
+			restartArgs := *args
+			restartArgs.Name = instanceName
+			err := s.b.restart(ctx, &restartArgs)
+			if err == nil {
+				s.events.publish(instanceName, types.EventStarted)
+				kickMonitor(kickCh)
+			}
+			resultCh <- err
 			return nil
 		},
 	}
 }
 
-func (s *ccvmService) start(ctx context.Context, instanceName string, vmSpec *types.VMSpec, resultCh chan interface{}) {
+func (s *ccvmService) quit(ctx context.Context, instanceName string, resultCh chan interface{}) {
 	instanceName, err := s.getInstance(instanceName)
 	if err != nil {
 		resultCh <- err
@@ -396,18 +984,112 @@ func (s *ccvmService) start(ctx context.Context, instanceName string, vmSpec *ty
 	}
 	instanceCh := s.instances[instanceName]
 
+	instanceCh <- instanceCmd{
+		cmdType:    instanceCmdOther,
+		resultCh:   resultCh,
+		ctx:        ctx,
+		progressCh: resultCh,
+		fn: func() error {
+			err := s.b.quit(ctx, instanceName)
+			if err == nil {
+				s.events.publish(instanceName, types.EventStopped)
+			}
+			resultCh <- err
+			return nil
+		},
+	}
+}
+
+func (s *ccvmService) resize(ctx context.Context, args *types.ResizeArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
 	instanceCh <- instanceCmd{
 		cmdType:  instanceCmdOther,
 		resultCh: resultCh,
+		ctx:      ctx,
 		fn: func() error {
-			resultCh <- s.b.start(ctx, instanceName, vmSpec)
+			res, err := s.b.resize(ctx, instanceName, args)
+			if err != nil {
+				resultCh <- err
+			} else {
+				resultCh <- *res
+			}
 			return nil
 		},
 	}
 }
 
-func (s *ccvmService) quit(ctx context.Context, instanceName string, resultCh chan interface{}) {
-	instanceName, err := s.getInstance(instanceName)
+func (s *ccvmService) forward(ctx context.Context, args *types.ForwardArgs, add bool, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:    instanceCmdOther,
+		resultCh:   resultCh,
+		ctx:        ctx,
+		progressCh: resultCh,
+		fn: func() error {
+			resultCh <- s.b.forward(ctx, instanceName, args.Mapping, add)
+			return nil
+		},
+	}
+}
+
+func (s *ccvmService) mount(ctx context.Context, args *types.MountArgs, add bool, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:    instanceCmdOther,
+		resultCh:   resultCh,
+		ctx:        ctx,
+		progressCh: resultCh,
+		fn: func() error {
+			resultCh <- s.b.mount(ctx, instanceName, args.Mount, add)
+			return nil
+		},
+	}
+}
+
+func (s *ccvmService) disk(ctx context.Context, args *types.DiskArgs, attach bool, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:    instanceCmdOther,
+		resultCh:   resultCh,
+		ctx:        ctx,
+		progressCh: resultCh,
+		fn: func() error {
+			resultCh <- s.b.disk(ctx, instanceName, args, attach)
+			return nil
+		},
+	}
+}
+
+func (s *ccvmService) fsck(ctx context.Context, args *types.FsckArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
 	if err != nil {
 		resultCh <- err
 		close(resultCh)
@@ -418,8 +1100,35 @@ func (s *ccvmService) quit(ctx context.Context, instanceName string, resultCh ch
 	instanceCh <- instanceCmd{
 		cmdType:  instanceCmdOther,
 		resultCh: resultCh,
+		ctx:      ctx,
+		fn: func() error {
+			res, err := s.b.fsck(ctx, instanceName, args.Repair)
+			if err != nil {
+				resultCh <- err
+			} else {
+				resultCh <- *res
+			}
+			return nil
+		},
+	}
+}
+
+func (s *ccvmService) flatten(ctx context.Context, instanceName string, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(instanceName)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:    instanceCmdOther,
+		resultCh:   resultCh,
+		ctx:        ctx,
+		progressCh: resultCh,
 		fn: func() error {
-			resultCh <- s.b.quit(ctx, instanceName)
+			resultCh <- s.b.flatten(ctx, instanceName)
 			return nil
 		},
 	}
@@ -435,10 +1144,16 @@ func (s *ccvmService) delete(ctx context.Context, instanceName string, resultCh
 	instanceCh := s.instances[instanceName]
 
 	instanceCh <- instanceCmd{
-		cmdType:  instanceCmdDelete,
-		resultCh: resultCh,
+		cmdType:    instanceCmdDelete,
+		resultCh:   resultCh,
+		ctx:        ctx,
+		progressCh: resultCh,
 		fn: func() error {
-			return s.b.deleteInstance(ctx, instanceName)
+			err := s.b.deleteInstance(ctx, instanceName)
+			if err == nil {
+				s.events.publish(instanceName, types.EventDeleted)
+			}
+			return err
 		},
 	}
 }
@@ -455,6 +1170,7 @@ func (s *ccvmService) status(ctx context.Context, instanceName string, resultCh
 	instanceCh <- instanceCmd{
 		cmdType:  instanceCmdOther,
 		resultCh: resultCh,
+		ctx:      ctx,
 		fn: func() error {
 			details, err := s.b.status(ctx, instanceName)
 			if err != nil {
@@ -467,16 +1183,158 @@ func (s *ccvmService) status(ctx context.Context, instanceName string, resultCh
 	}
 }
 
-func (s *ccvmService) getInstances(ctx context.Context, resultCh chan interface{}) {
-	names := make([]string, len(s.instances))
-	i := 0
+func (s *ccvmService) sshKey(ctx context.Context, instanceName string, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(instanceName)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:  instanceCmdOther,
+		resultCh: resultCh,
+		ctx:      ctx,
+		fn: func() error {
+			key, err := s.b.sshKey(ctx, instanceName)
+			if err != nil {
+				resultCh <- err
+			} else {
+				resultCh <- *key
+			}
+			return nil
+		},
+	}
+}
+
+// refreshStatus probes the status of each of the instances named in args, or
+// of all instances if no names are given.  The instances are probed in
+// parallel, each in its own instance loop, and a single RefreshStatusResult
+// is returned once all the probes have completed.
+func (s *ccvmService) refreshStatus(ctx context.Context, args *types.RefreshStatusArgs, resultCh chan interface{}) {
+	names := args.Names
+	if len(names) == 0 {
+		names = make([]string, 0, len(s.instances))
+		for k := range s.instances {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		if _, ok := s.instances[name]; !ok {
+			resultCh <- errors.Errorf("Instance %s does not exist", name)
+			close(resultCh)
+			return
+		}
+	}
+
+	instanceResults := make([]chan interface{}, len(names))
+	for i := range names {
+		instanceName := names[i]
+		instanceResult := make(chan interface{}, 1)
+		instanceResults[i] = instanceResult
+		s.instances[instanceName] <- instanceCmd{
+			cmdType:  instanceCmdOther,
+			resultCh: instanceResult,
+			fn: func() error {
+				details, err := s.b.refreshStatus(ctx, instanceName, args.MaxStaleness)
+				if err != nil {
+					instanceResult <- err
+				} else {
+					instanceResult <- *details
+				}
+				return nil
+			},
+		}
+	}
+
+	go func() {
+		result := types.RefreshStatusResult{
+			Errors: make(map[string]string),
+		}
+		for i, instanceResult := range instanceResults {
+			switch r := (<-instanceResult).(type) {
+			case error:
+				result.Errors[names[i]] = r.Error()
+			case types.InstanceDetails:
+				result.Instances = append(result.Instances, r)
+			}
+		}
+		resultCh <- result
+		close(resultCh)
+	}()
+}
+
+// getInstances lists the instances whose labels match args.Selector,
+// sorted by name.  Instances whose state cannot be read are listed in the
+// unknown state, unless the selector requires labels.
+func (s *ccvmService) getInstances(ctx context.Context, args *types.InstancesArgs, resultCh chan interface{}) {
+	sel, err := types.ParseSelector(args.Selector)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+
+	names := make([]string, 0, len(s.instances))
 	for k := range s.instances {
-		names[i] = k
-		i++
+		names = append(names, k)
 	}
 	sort.Strings(names)
-	resultCh <- names
-	close(resultCh)
+
+	go func() {
+		summaries := make([]types.InstanceSummary, 0, len(names))
+		for _, name := range names {
+			sum, err := s.b.summary(ctx, name)
+			if err != nil {
+				logWarningf("Unable to summarize %s: %v", name, err)
+				sum = &types.InstanceSummary{Name: name, State: types.StateUnknown}
+			}
+			if sel.Matches(sum.Labels) {
+				summaries = append(summaries, *sum)
+			}
+		}
+		resultCh <- summaries
+		close(resultCh)
+	}()
+}
+
+func (s *ccvmService) report(ctx context.Context, args *types.ReportArgs, resultCh chan interface{}) {
+	go func() {
+		res, err := s.b.report(ctx, args.Since)
+		if err != nil {
+			resultCh <- err
+		} else {
+			resultCh <- *res
+		}
+		close(resultCh)
+	}()
+}
+
+func (s *ccvmService) preflight(ctx context.Context, args *types.PreflightArgs, resultCh chan interface{}) {
+	go func() {
+		res, err := s.b.preflight(ctx, args)
+		if err != nil {
+			resultCh <- err
+		} else {
+			resultCh <- *res
+		}
+		close(resultCh)
+	}()
+}
+
+func (s *ccvmService) auditLog(ctx context.Context, args *types.AuditLogArgs, resultCh chan interface{}) {
+	go func() {
+		records, err := s.audit.query(args)
+		if err != nil {
+			resultCh <- err
+		} else {
+			resultCh <- records
+		}
+		close(resultCh)
+	}()
 }
 
 func (s *ccvmService) processAction(action interface{}) {
@@ -486,13 +1344,26 @@ func (s *ccvmService) processAction(action interface{}) {
 			TODO: Assuming systemd will not send us any new commands
 			after it has asked us to shut down.
 		*/
+		if s.draining {
+			a.transCh <- noTransaction
+			return
+		}
 		resultCh := make(chan interface{}, 256)
-		ctx, cancel := context.WithCancel(context.Background())
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if s.txPolicy.timeout > 0 && !a.interruptible {
+			ctx, cancel = context.WithTimeout(s.ctx, s.txPolicy.timeout)
+		} else {
+			ctx, cancel = context.WithCancel(s.ctx)
+		}
 
 		s.transactions[s.counter] = transaction{
-			ctx:      ctx,
-			cancel:   cancel,
-			resultCh: resultCh,
+			ctx:           ctx,
+			cancel:        cancel,
+			resultCh:      resultCh,
+			interruptible: a.interruptible,
+			owner:         a.owner,
+			started:       time.Now(),
 		}
 		if s.shutdownTimer != nil {
 			if !s.shutdownTimer.Stop() {
@@ -504,40 +1375,217 @@ func (s *ccvmService) processAction(action interface{}) {
 		a.transCh <- s.counter
 		s.counter++
 		a.action(ctx, s, resultCh)
+	case drainAction:
+		s.drain(a.timeout)
 	case cancelAction:
-		fmt.Fprintf(os.Stderr, "Cancelling %d\n", int(a))
-		t, ok := s.transactions[int(a)]
-		if ok {
+		logDebugf("Cancelling %d", a.ID)
+		t, ok := s.transactions[a.ID]
+		if ok && t.owner == a.owner {
 			t.cancel()
 		}
 	case getResult:
-		t, ok := s.transactions[int(a.ID)]
-		if !ok {
+		// The transactions of other callers are reported as unknown so
+		// as not to reveal which exist.
+
+		t, ok := s.transactions[a.ID]
+		if ok && t.owner != a.owner {
 			a.res <- errors.Errorf("Unknown transaction %d", a.ID)
+		} else if !ok {
+			if _, expired := s.expired[a.ID]; expired {
+				a.res <- errors.Errorf("Transaction %d has expired.  Its result was not retrieved in time", a.ID)
+			} else {
+				a.res <- errors.Errorf("Unknown transaction %d", a.ID)
+			}
 		} else {
+			t.claimed = true
+			t.lastClaim = time.Now()
+			t.stalled = false
+			s.transactions[a.ID] = t
 			a.res <- t.resultCh
 		}
-	case completeAction:
-		fmt.Printf("Completing %d\n", int(a))
-		_, ok := s.transactions[int(a)]
+	case guestAction:
+		instanceCh, ok := s.instances[a.name]
+		if !ok {
+			a.resultCh <- errors.New("Instance does not exist")
+			return
+		}
+		instanceCh <- instanceCmd{
+			cmdType:  instanceCmdOther,
+			resultCh: a.resultCh,
+			fn: func() error {
+				a.resultCh <- s.b.guestRequest(s.guestCtx, a.name, &a.req)
+				return nil
+			},
+		}
+	case backupAction:
+		instanceCh, ok := s.instances[a.name]
+		if !ok {
+			a.resultCh <- errors.New("Instance does not exist")
+			close(a.resultCh)
+			return
+		}
+		instanceCh <- instanceCmd{
+			cmdType:  instanceCmdOther,
+			resultCh: a.resultCh,
+			ctx:      s.ctx,
+			fn: func() error {
+				_, err := s.b.backup(s.ctx, a.name, "", true)
+				a.resultCh <- err
+				return nil
+			},
+		}
+	case autostartAction:
+		instanceCh, ok := s.instances[a.name]
 		if !ok {
-			panic("Action %d does not exist")
+			a.resultCh <- errors.New("Instance does not exist")
+			close(a.resultCh)
+			return
+		}
+		kickCh := s.monitors[a.name]
+		instanceCh <- instanceCmd{
+			cmdType:  instanceCmdOther,
+			resultCh: a.resultCh,
+			ctx:      s.ctx,
+			fn: func() error {
+				instanceDir := filepath.Join(s.ccvmDir, "instances", a.name)
+				if loadStatus(instanceDir).Running {
+					a.resultCh <- nil
+					return nil
+				}
+				instanceLog(a.name).Infof("Starting VM automatically")
+				err := s.b.start(s.ctx, a.name, &types.VMSpec{}, false)
+				if err == nil {
+					s.events.publish(a.name, types.EventStarted)
+					kickMonitor(kickCh)
+				}
+				a.resultCh <- err
+				return nil
+			},
+		}
+	case vmExitAction:
+		instanceCh, ok := s.instances[a.name]
+		if !ok {
+			return
+		}
+		kickCh := s.monitors[a.name]
+		instanceCh <- instanceCmd{
+			cmdType:  instanceCmdOther,
+			resultCh: make(chan interface{}, 1),
+			fn: func() error {
+				outcome, err := s.b.vmExited(s.guestCtx, a.name, a.exit)
+				if err != nil {
+					instanceLog(a.name).Warningf("%v", err)
+				}
+				if outcome == nil || outcome.expected {
+					return nil
+				}
+				if outcome.crashed {
+					s.events.publish(a.name, types.EventCrashed)
+				} else {
+					s.events.publish(a.name, types.EventStopped)
+				}
+				if outcome.restarted {
+					s.events.publish(a.name, types.EventStarted)
+					kickMonitor(kickCh)
+				}
+				return nil
+			},
+		}
+	case guestConnection:
+		if !a.connected {
+			delete(s.guestChannels, a.name)
+		} else if _, ok := s.instances[a.name]; ok {
+			s.guestChannels[a.name] = struct{}{}
+		}
+	case completeAction:
+		logDebugf("Completing %d", int(a))
+		// The transaction may have expired while its result was
+		// being retrieved.
+		if _, ok := s.transactions[int(a)]; !ok {
+			return
 		}
 		delete(s.transactions, int(a))
 		if len(s.transactions) == 0 {
-			if s.shutdownTimer == nil {
-				shutdownIn := time.Minute
-				s.shutdownTimer = time.NewTimer(shutdownIn)
-				s.cases[TimeChIndex].Chan = reflect.ValueOf(s.shutdownTimer.C)
-			}
+			s.startShutdownTimer()
+		}
+	}
+}
+
+// startShutdownTimer arranges for the service to exit once it has been idle
+// for the idle timeout, unless new transactions are started in the
+// meantime.  The service never exits if the idle timeout is 0.
+func (s *ccvmService) startShutdownTimer() {
+	if s.shutdownTimer == nil && s.idleTimeout > 0 {
+		s.shutdownTimer = time.NewTimer(s.idleTimeout)
+		s.cases[TimeChIndex].Chan = reflect.ValueOf(s.shutdownTimer.C)
+	}
+}
+
+// idle returns true if the service can exit without leaving instances
+// unattended, i.e., if no guest channels are connected, no VMs are running
+// and no instances have backup policies.  Running VMs are monitored and
+// accounted for by the service, which also takes scheduled backups.
+func (s *ccvmService) idle() bool {
+	if len(s.guestChannels) > 0 {
+		return false
+	}
+
+	for name := range s.instances {
+		instanceDir := filepath.Join(s.ccvmDir, "instances", name)
+		if loadStatus(instanceDir).Running {
+			return false
+		}
+		if s.backups != nil && loadBackupPolicy(instanceDir) != nil {
+			return false
+		}
+	}
+	if s.autostart != nil && s.autostart.starting() {
+		return false
+	}
+	return true
+}
+
+func (s *ccvmService) drain(timeout time.Duration) {
+	if s.draining {
+		return
+	}
+
+	logInfof("Draining, active transactions = %d", len(s.transactions))
+	s.draining = true
+	for _, t := range s.transactions {
+		if t.interruptible {
+			t.cancel()
+		}
+	}
+
+	if s.shutdownTimer != nil {
+		if !s.shutdownTimer.Stop() {
+			_ = <-s.shutdownTimer.C
+		}
+		s.shutdownTimer = nil
+		s.cases[TimeChIndex].Chan = reflect.ValueOf(nil)
+	}
+	if timeout > 0 {
+		s.shutdownTimer = time.NewTimer(timeout)
+		s.cases[TimeChIndex].Chan = reflect.ValueOf(s.shutdownTimer.C)
+	}
+}
+
+// cancelTransactions cancels the transactions in progress and waits for
+// them to complete.
+func (s *ccvmService) cancelTransactions() {
+	s.cancel()
+	for _, t := range s.transactions {
+		for range t.resultCh {
 		}
 	}
 }
 
-func (s *ccvmService) run(doneCh chan struct{}, actionCh chan interface{}) {
-	fmt.Println("Starting Service")
+func (s *ccvmService) run(ctx context.Context, doneCh chan struct{}, actionCh chan interface{}) {
+	logInfof("Starting Service")
 
 	s.transactions = make(map[int]transaction)
+	s.expired = make(map[int]time.Time)
 	s.cases = []reflect.SelectCase{
 		{
 			Dir:  reflect.SelectRecv,
@@ -551,50 +1599,161 @@ func (s *ccvmService) run(doneCh chan struct{}, actionCh chan interface{}) {
 			Dir:  reflect.SelectRecv,
 			Chan: reflect.ValueOf(nil),
 		},
+		{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(nil),
+		},
 	}
 
-	s.findExistingInstances()
+	if s.txPolicy.claimTimeout > 0 {
+		expiryTicker := time.NewTicker(s.txPolicy.claimTimeout / 2)
+		defer expiryTicker.Stop()
+		s.cases[ExpiryChIndex].Chan = reflect.ValueOf(expiryTicker.C)
+	}
+
+	s.actionCh = actionCh
+	s.guestChannels = make(map[string]struct{})
+	s.monitors = make(map[string]chan struct{})
+	s.consoles = make(map[string]*consoleHub)
+	s.networks = make(map[string]string)
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.guestCtx, s.guestCancel = context.WithCancel(s.ctx)
+
+	var notifyCh chan interface{}
+	var notifyWg sync.WaitGroup
+	if s.notifier != nil {
+		notifyCh = make(chan interface{}, 256)
+		s.events.subscribe(notifyCh, nil)
+		notifyWg.Add(1)
+		go func() {
+			s.notifier.run(notifyCh)
+			notifyWg.Done()
+		}()
+	}
+
+	var hostsCh chan interface{}
+	var hostsWg sync.WaitGroup
+	if s.hosts != nil {
+		hostsCh = make(chan interface{}, 256)
+		s.events.subscribe(hostsCh, nil)
+		hostsWg.Add(1)
+		go func() {
+			s.hosts.run(s.ctx, hostsCh)
+			hostsWg.Done()
+		}()
+	}
+
+	accountCtx, accountCancel := context.WithCancel(s.ctx)
+	var accountWg sync.WaitGroup
+	if s.accountant != nil {
+		accountWg.Add(1)
+		go func() {
+			s.accountant.run(accountCtx)
+			accountWg.Done()
+		}()
+	}
+	if s.pressure != nil {
+		accountWg.Add(1)
+		go func() {
+			s.pressure.run(accountCtx, s.events.publish)
+			accountWg.Done()
+		}()
+	}
+	if s.backups != nil {
+		accountWg.Add(1)
+		go func() {
+			s.backups.run(accountCtx, actionCh)
+			accountWg.Done()
+		}()
+	}
+
+	autostart := s.findExistingInstances()
+	if s.autostart != nil {
+		s.autostart.start(accountCtx, actionCh, autostart, &accountWg)
+	}
 
 DONE:
 	for {
 		index, value, _ := reflect.Select(s.cases)
 		switch index {
 		case DoneChIndex:
-			fmt.Printf("Signal received active transactions = %d\n", len(s.transactions))
+			logInfof("Signal received active transactions = %d", len(s.transactions))
 			if s.shutdownTimer != nil {
 				if !s.shutdownTimer.Stop() {
 					_ = <-s.shutdownTimer.C
 				}
 			}
-			for _, t := range s.transactions {
-				t.cancel()
-				for range t.resultCh {
-				}
-			}
+			s.cancelTransactions()
 			break DONE
 		case ActionChIndex:
 			s.processAction(value.Interface())
+			if s.draining && len(s.transactions) == 0 {
+				logInfof("Drain complete")
+				break DONE
+			}
 		case TimeChIndex:
+			if s.draining {
+				logWarningf("Drain timed out, cancelling %d transactions",
+					len(s.transactions))
+				s.cancelTransactions()
+				break DONE
+			}
+			if !s.idle() {
+				s.shutdownTimer.Reset(s.idleTimeout)
+				break
+			}
+			logInfof("Idle for %v, exiting", s.idleTimeout)
 			break DONE
+		case ExpiryChIndex:
+			s.expireTransactions(time.Now())
+			if s.draining && len(s.transactions) == 0 {
+				logInfof("Drain complete")
+				break DONE
+			}
 		default:
 			/* One of the instanceLoops has quit */
 
-			fmt.Println("Instance loop has died")
+			logDebugf("Instance loop has died")
 			closeCh := s.cases[index].Chan.Interface().(chan struct{})
 			name := s.instanceChMap[closeCh]
+			s.forgetInstance(name)
 			close(s.instances[name])
 			delete(s.instances, name)
+			delete(s.groups, name)
+			delete(s.guestChannels, name)
+			delete(s.monitors, name)
+			s.consoles[name].close()
+			delete(s.consoles, name)
+			delete(s.networks, name)
 			delete(s.instanceChMap, closeCh)
 			s.cases = append(s.cases[:index], s.cases[index+1:]...)
 		}
 	}
 
+	s.guestCancel()
+	accountCancel()
+	s.cancel()
 	for _, instanceCh := range s.instances {
 		close(instanceCh)
 	}
 	s.instanceWg.Wait()
+	s.guestWg.Wait()
+	s.monitorWg.Wait()
+	accountWg.Wait()
+
+	if notifyCh != nil {
+		s.events.unsubscribe(notifyCh)
+		close(notifyCh)
+		notifyWg.Wait()
+	}
+
+	if hostsCh != nil {
+		s.events.unsubscribe(hostsCh)
+		close(hostsCh)
+		hostsWg.Wait()
+	}
 
-	fmt.Println("Shutting down Service")
+	logInfof("Shutting down Service")
 }
 
 func makeDir() (string, error) {
@@ -602,7 +1761,7 @@ func makeDir() (string, error) {
 	if home == "" {
 		return "", errors.New("HOME is not defined")
 	}
-	ccvmDir := filepath.Join(home, ".ccloudvm")
+	ccvmDir := types.DataDir(home)
 	err := os.MkdirAll(ccvmDir, 0700)
 	if err != nil {
 		return "", errors.Wrapf(err, "Unable to create %s", ccvmDir)
@@ -611,7 +1770,7 @@ func makeDir() (string, error) {
 	return ccvmDir, nil
 }
 
-func getListener(domainParent string) (net.Listener, error) {
+func getListener(socketPath string) (net.Listener, error) {
 	if systemd {
 		listeners, err := activation.Listeners(true)
 		if err != nil {
@@ -625,19 +1784,122 @@ func getListener(domainParent string) (net.Listener, error) {
 		return listeners[0], nil
 	}
 
-	listener, err := net.Listen("unix", filepath.Join(domainParent, "socket"))
+	// Remove any socket left behind by a previous instance of the daemon.
+	_ = os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
 	if err != nil {
 		return nil, errors.Wrap(err, "Unable to create listener")
 	}
 	return listener, nil
 }
 
+// daemon contains the settings shared by the services run by the daemon.  A
+// daemon runs a single service, on behalf of the user running it, unless
+// it runs in system mode.
+type daemon struct {
+	cfg      *daemonConfig
+	notifier *notifier
+	txPolicy transactionPolicy
+	signalCh chan os.Signal
+	doneCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// startService starts a service that manages the instances of the user
+// whose uid is given, which are stored in ccvmDir.  The returned API issues
+// commands to the service.  Its finishedCh is closed when the service
+// exits.
+func (d *daemon) startService(ctx context.Context, ccvmDir string, uid int,
+	idleTimeout time.Duration) (*ServerAPI, error) {
+	api := &ServerAPI{
+		signalCh:   d.signalCh,
+		actionCh:   make(chan interface{}),
+		finishedCh: make(chan struct{}),
+		audit:      newAuditLog(ccvmDir),
+	}
+
+	downloadCh := make(chan downloadRequest)
+	dl := downloader{}
+	err := dl.setup(ccvmDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to start download manager")
+	}
+
+	d.wg.Add(1)
+	go func() {
+		dl.start(api.finishedCh, downloadCh)
+		d.wg.Done()
+	}()
+
+	d.wg.Add(1)
+	go func() {
+		svc := &ccvmService{
+			ccvmDir:       ccvmDir,
+			downloadCh:    downloadCh,
+			instances:     make(map[string]chan instanceCmd),
+			instanceChMap: make(map[chan struct{}]string),
+			groups:        make(map[string]string),
+			hostIPs:       make(map[uint32]struct{}),
+			hostIPMask:    0x7f000000 | uint32((uid&0xffff)<<8),
+			b:             ccvmBackend{cfg: d.cfg},
+			notifier:      d.notifier,
+			hosts:         newHostsPublisher(ccvmDir),
+			accountant:    newAccountant(ccvmDir, d.cfg.Accounting),
+			pressure:      newPressureMonitor(ccvmDir),
+			backups:       newBackupScheduler(ccvmDir),
+			autostart:     newAutostarter(ccvmDir),
+			txPolicy:      d.txPolicy,
+			idleTimeout:   idleTimeout,
+			audit:         api.audit,
+		}
+		svc.run(ctx, d.doneCh, api.actionCh)
+		close(api.finishedCh)
+		d.wg.Done()
+	}()
+
+	return api, nil
+}
+
 func startServer(signalCh chan os.Signal) error {
-	ccvmDir, err := makeDir()
+	ccvmDir := systemDataDir
+	var err error
+	if !systemMode {
+		ccvmDir, err = makeDir()
+		if err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(ccvmDir, 0755); err != nil {
+		return errors.Wrapf(err, "Unable to create %s", ccvmDir)
+	}
+	cfg, err := loadDaemonConfig(ccvmDir)
+	if err != nil {
+		return err
+	}
+	if err := configureLogging(ccvmDir, &cfg.Logging); err != nil {
+		return err
+	}
+	n, err := newNotifier(cfg.Notifications)
 	if err != nil {
 		return err
 	}
-	listener, err := getListener(ccvmDir)
+	txPolicy, err := cfg.Transactions.policy()
+	if err != nil {
+		return err
+	}
+	idleTimeout, err := cfg.Service.idleTimeout()
+	if err != nil {
+		return err
+	}
+
+	socketPath := filepath.Join(ccvmDir, "socket")
+	if systemMode {
+		socketPath = types.SystemSocket
+		if err := os.MkdirAll(filepath.Dir(socketPath), 0755); err != nil {
+			return errors.Wrapf(err, "Unable to create %s", filepath.Dir(socketPath))
+		}
+	}
+	listener, err := getListener(socketPath)
 	if err != nil {
 		return err
 	}
@@ -646,69 +1908,78 @@ func startServer(signalCh chan os.Signal) error {
 		_ = listener.Close()
 	}()
 
-	api := &ServerAPI{
+	ccvmServer := &http.Server{}
+	d := &daemon{
+		cfg:      cfg,
+		notifier: n,
+		txPolicy: txPolicy,
 		signalCh: signalCh,
-		actionCh: make(chan interface{}),
-	}
-	err = rpc.Register(api)
-	if err != nil {
-		return errors.New("Unable to register RPC API")
+		doneCh:   make(chan struct{}),
 	}
-	rpc.HandleHTTP()
-
-	ccvmServer := &http.Server{}
-	finishedCh := make(chan struct{})
-	doneCh := make(chan struct{})
 
-	fmt.Println("Running server")
+	logInfof("Running server")
 
-	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	downloadCh := make(chan downloadRequest)
-	d := downloader{}
-	err = d.setup(ccvmDir)
-	if err != nil {
-		return errors.Wrap(err, "Unable to start download manager")
+	// The service of a single user daemon exits when it is idle, taking
+	// the daemon with it.  A system mode daemon runs until it is
+	// signalled.
+	var finishedCh chan struct{}
+	if systemMode {
+		if !systemd {
+			if err := restrictSocket(socketPath, cfg.System.Group); err != nil {
+				return err
+			}
+		}
+		listener = peerListener{listener}
+		ccvmServer.Handler = newUserServices(ctx, d)
+	} else {
+		api, err := d.startService(ctx, ccvmDir, os.Getuid(), idleTimeout)
+		if err != nil {
+			return err
+		}
+		userName := strconv.Itoa(os.Getuid())
+		if u, err := user.Current(); err == nil {
+			userName = u.Username
+		}
+		ccvmServer.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveAPI(w, r, api, &cfg.Auth, userName)
+		})
+		finishedCh = api.finishedCh
 	}
 
-	wg.Add(1)
-	go func() {
-		d.start(doneCh, downloadCh)
-		wg.Done()
-	}()
-
-	wg.Add(1)
+	d.wg.Add(1)
 	go func() {
 		_ = ccvmServer.Serve(listener)
-		wg.Done()
+		d.wg.Done()
 	}()
 
-	wg.Add(1)
-	go func() {
-		svc := &ccvmService{
-			ccvmDir:       ccvmDir,
-			downloadCh:    downloadCh,
-			instances:     make(map[string]chan instanceCmd),
-			instanceChMap: make(map[chan struct{}]string),
-			hostIPs:       make(map[uint32]struct{}),
-			hostIPMask:    0x7f000000 | uint32((os.Getuid()&0xffff)<<8),
-			b:             ccvmBackend{},
+	if cfg.Remote.Listen != "" {
+		remoteListener, err := listenRemote(ccvmDir, &cfg.Remote)
+		if err != nil {
+			logErrorf("Remote access disabled: %v", err)
+		} else {
+			logInfof("Accepting remote clients on %s", cfg.Remote.Listen)
+			d.wg.Add(1)
+			go func() {
+				_ = ccvmServer.Serve(remoteListener)
+				d.wg.Done()
+			}()
 		}
-		svc.run(doneCh, api.actionCh)
-		close(finishedCh)
-		wg.Done()
-	}()
+	}
+
 	select {
 	case <-signalCh:
-		fmt.Println("Signal channel closed")
-		close(doneCh)
+		logInfof("Signal channel closed")
+		close(d.doneCh)
 	case <-finishedCh:
-		close(doneCh)
+		close(d.doneCh)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	err = ccvmServer.Shutdown(ctx)
-	cancel()
-	wg.Wait()
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, time.Second*10)
+	err = ccvmServer.Shutdown(shutdownCtx)
+	shutdownCancel()
+	d.wg.Wait()
 	if err != nil {
 		return errors.Wrap(err, "ccloudvm server did not shut down correctly")
 	}
@@ -717,7 +1988,7 @@ func startServer(signalCh chan os.Signal) error {
 }
 
 func main() {
-	fmt.Println("Starting")
+	logInfof("Starting")
 
 	flag.Parse()
 
@@ -725,9 +1996,9 @@ func main() {
 	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	err := startServer(signalCh)
-	fmt.Println("Quiting")
+	logInfof("Quitting")
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		logErrorf("%v", err)
 		os.Exit(1)
 	}
 }
@@ -20,19 +20,27 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/intel/ccloudvm/types"
 )
 
 type goodBackend struct {
-	ipIndex  int
-	reuseIps bool
+	// status is called concurrently by the instance loops.
+	ipMu       sync.Mutex
+	ipIndex    int
+	reuseIps   bool
+	labels     map[string]string
+	failRename bool
 }
 type badBackend struct {
 	failCreate bool
@@ -43,27 +51,97 @@ func (gb *goodBackend) createInstance(ctx context.Context, resultCh chan interfa
 	return nil
 }
 
-func (gb *goodBackend) start(ctx context.Context, name string, args *types.VMSpec) error {
+func (gb *goodBackend) prefetch(ctx context.Context, resultCh chan interface{},
+	downloadCh chan<- downloadRequest, args *types.CreateArgs) error {
+	return nil
+}
+
+func (gb *goodBackend) start(ctx context.Context, name string, args *types.VMSpec, force bool) error {
 	return nil
 }
 
-func (gb *goodBackend) stop(ctx context.Context, name string) error {
+func (gb *goodBackend) restart(ctx context.Context, args *types.RestartArgs) error {
 	return nil
 }
 
+func (gb *goodBackend) stop(ctx context.Context, args *types.StopArgs) (*types.StopResult, error) {
+	return &types.StopResult{Method: types.StopACPI}, nil
+}
+
 func (gb *goodBackend) quit(ctx context.Context, name string) error {
 	return nil
 }
 
+func (gb *goodBackend) resize(ctx context.Context, name string, args *types.ResizeArgs) (*types.ResizeResult, error) {
+	return &types.ResizeResult{}, nil
+}
+
+func (gb *goodBackend) forward(ctx context.Context, name string, p types.PortMapping, add bool) error {
+	return nil
+}
+
+func (gb *goodBackend) mount(ctx context.Context, name string, m types.Mount, add bool) error {
+	return nil
+}
+
+func (gb *goodBackend) disk(ctx context.Context, name string, args *types.DiskArgs, attach bool) error {
+	return nil
+}
+
+func (gb *goodBackend) fsck(ctx context.Context, name string, repair bool) (*types.FsckResult, error) {
+	return &types.FsckResult{}, nil
+}
+
+func (gb *goodBackend) flatten(ctx context.Context, name string) error {
+	return nil
+}
+
+func (gb *goodBackend) rename(ctx context.Context, name, newName string) error {
+	if gb.failRename {
+		return errors.New("Failure")
+	}
+	return nil
+}
+
+func (gb *goodBackend) backup(ctx context.Context, name, dir string, scheduled bool) (*types.BackupInfo, error) {
+	return &types.BackupInfo{Instance: name, ID: "20181010T101010Z"}, nil
+}
+
+func (gb *goodBackend) listBackups(ctx context.Context, name, dir string) (*types.BackupList, error) {
+	return &types.BackupList{}, nil
+}
+
+func (gb *goodBackend) restoreBackup(ctx context.Context, args *types.RestoreArgs) error {
+	return nil
+}
+
+func (gb *goodBackend) setBackupPolicy(ctx context.Context, p *types.BackupPolicy) error {
+	return nil
+}
+
+func (gb *goodBackend) suspend(ctx context.Context, name string) error {
+	return nil
+}
+
+func (gb *goodBackend) resumeFromDisk(ctx context.Context, name string, discard bool) error {
+	return nil
+}
+
+func (gb *goodBackend) set(ctx context.Context, name string, settings map[string]string) error {
+	return nil
+}
+
 func (gb *goodBackend) status(ctx context.Context, name string) (*types.InstanceDetails, error) {
+	gb.ipMu.Lock()
 	ip := 0x7f700000 + gb.ipIndex + 1
+	if !gb.reuseIps {
+		gb.ipIndex++
+	}
+	gb.ipMu.Unlock()
 	a := byte((0xff000000 & ip) >> 24)
 	b := byte((0xff0000 & ip) >> 16)
 	c := byte((0xff00 & ip) >> 8)
 	d := byte(0xff & ip)
-	if !gb.reuseIps {
-		gb.ipIndex++
-	}
 
 	return &types.InstanceDetails{
 		Name: name,
@@ -73,10 +151,167 @@ func (gb *goodBackend) status(ctx context.Context, name string) (*types.Instance
 	}, nil
 }
 
+func (gb *goodBackend) summary(ctx context.Context, name string) (*types.InstanceSummary, error) {
+	return &types.InstanceSummary{
+		Name:   name,
+		State:  types.StateRunning,
+		Labels: gb.labels,
+	}, nil
+}
+
+func (gb *goodBackend) refreshStatus(ctx context.Context, name string, maxStaleness time.Duration) (*types.InstanceDetails, error) {
+	return &types.InstanceDetails{Name: name}, nil
+}
+
 func (gb *goodBackend) deleteInstance(context.Context, string) error {
 	return nil
 }
 
+func (gb *goodBackend) guestRequest(ctx context.Context, name string, req *guestRequest) error {
+	return nil
+}
+
+func (gb *goodBackend) monitor(ctx context.Context, name string) (*vmExit, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (gb *goodBackend) checkReady(ctx context.Context, name string) error {
+	return nil
+}
+
+func (gb *goodBackend) syncFiles(ctx context.Context, name string) error {
+	return nil
+}
+
+func (gb *goodBackend) forwardDocker(ctx context.Context, name string) error {
+	return nil
+}
+
+func (gb *goodBackend) syncStatus(ctx context.Context, name string) (*types.SyncStatusResult, error) {
+	return &types.SyncStatusResult{}, nil
+}
+
+func (gb *goodBackend) kubeconfig(ctx context.Context, name string) (*types.KubeconfigResult, error) {
+	return &types.KubeconfigResult{Context: kubeconfigContext(name)}, nil
+}
+
+func (gb *goodBackend) pauseSync(ctx context.Context, name string, paused bool) error {
+	return nil
+}
+
+func (gb *goodBackend) vmExited(ctx context.Context, name string, exit *vmExit) (*exitOutcome, error) {
+	return &exitOutcome{crashed: exit.crashed}, nil
+}
+
+func (gb *goodBackend) report(ctx context.Context, since time.Time) (*types.ReportResult, error) {
+	return &types.ReportResult{Since: since}, nil
+}
+
+func (gb *goodBackend) preflight(ctx context.Context, args *types.PreflightArgs) (*types.PreflightResult, error) {
+	return &types.PreflightResult{}, nil
+}
+
+func (gb *goodBackend) sshKey(ctx context.Context, name string) (*types.SSHKeyResult, error) {
+	return &types.SSHKeyResult{}, nil
+}
+
+func (gb *goodBackend) consoleLogPath(ctx context.Context, name string) (string, error) {
+	return filepath.Join(os.TempDir(), "ccloudvm-tests-missing", consoleLog), nil
+}
+
+func (gb *goodBackend) createNetwork(ctx context.Context, spec *types.NetworkSpec) error {
+	return nil
+}
+
+func (gb *goodBackend) deleteNetwork(ctx context.Context, name string) error {
+	return nil
+}
+
+func (gb *goodBackend) listNetworks(ctx context.Context) ([]types.NetworkSpec, error) {
+	return []types.NetworkSpec{defaultNetworkSpec, {Name: "lab", Subnet: "192.168.50.0/24"}}, nil
+}
+
+func (gb *goodBackend) createVolume(ctx context.Context, spec *types.VolumeSpec) error {
+	return nil
+}
+
+func (gb *goodBackend) deleteVolume(ctx context.Context, name string) error {
+	return nil
+}
+
+func (gb *goodBackend) listVolumes(ctx context.Context) ([]types.VolumeInfo, error) {
+	return []types.VolumeInfo{{VolumeSpec: types.VolumeSpec{Name: "cache", SizeGiB: 50}}}, nil
+}
+
+func (gb *goodBackend) listCaches(ctx context.Context) ([]types.CacheInfo, error) {
+	return []types.CacheInfo{{Name: "apt", Instances: []string{"testInstance"}}}, nil
+}
+
+func (gb *goodBackend) clearCache(ctx context.Context, name string) error {
+	return nil
+}
+
+func (gb *goodBackend) updateWorkloads(ctx context.Context, URLs []string) ([]string, error) {
+	return URLs, nil
+}
+
+func (gb *goodBackend) getWorkloads(ctx context.Context) ([]types.WorkloadInfo, error) {
+	return []types.WorkloadInfo{{Name: "xenial", Source: "bundled"}}, nil
+}
+
+func (gb *goodBackend) showWorkload(ctx context.Context, name string) (*types.WorkloadDetails, error) {
+	return &types.WorkloadDetails{Name: name}, nil
+}
+
+func (gb *goodBackend) validateWorkload(ctx context.Context, args *types.ValidateWorkloadArgs) ([]types.WorkloadProblem, error) {
+	return nil, nil
+}
+
+func (gb *goodBackend) exportInstance(ctx context.Context, name, archive string) error {
+	return nil
+}
+
+func (gb *goodBackend) buildTemplate(ctx context.Context, name string, args *types.BuildTemplateArgs) (*types.TemplateInfo, error) {
+	return &types.TemplateInfo{Name: args.Template}, nil
+}
+
+func (gb *goodBackend) importWorkload(ctx context.Context, archive, name string) (string, error) {
+	return name, nil
+}
+
+func (gb *goodBackend) importVagrant(ctx context.Context, args *types.ImportVagrantArgs) (*types.ImportVagrantResult, error) {
+	return &types.ImportVagrantResult{Name: args.Name}, nil
+}
+
+func (gb *goodBackend) pushWorkload(ctx context.Context, args *types.PushArgs) error {
+	return nil
+}
+
+func (gb *goodBackend) pullWorkload(ctx context.Context, args *types.PullArgs) (string, error) {
+	return args.Name, nil
+}
+
+func (gb *goodBackend) execCommand(ctx context.Context, name, command string, stdout, stderr io.Writer) (int, error) {
+	_, _ = stdout.Write([]byte(command + "\n"))
+	_, _ = stderr.Write([]byte("warning\n"))
+	return 3, nil
+}
+
+func (gb *goodBackend) proxy(ctx context.Context, args *types.ProxyArgs, ready func(*types.ProxyStatus)) error {
+	ready(&types.ProxyStatus{SOCKS: "127.0.0.1:1080"})
+	return nil
+}
+
+func (gb *goodBackend) loadGroup(ctx context.Context, args *types.CreateArgs) (*types.GroupSpec, error) {
+	return &types.GroupSpec{
+		Roles: []types.GroupRole{
+			{Name: "master", Workload: "xenial", Count: 1},
+			{Name: "worker", Workload: "xenial", Count: 2},
+		},
+	}, nil
+}
+
 func (bb *badBackend) createInstance(ctx context.Context, resultCh chan interface{},
 	downloadCh chan<- downloadRequest, args *types.CreateArgs) error {
 	if bb.failCreate {
@@ -85,127 +320,993 @@ func (bb *badBackend) createInstance(ctx context.Context, resultCh chan interfac
 	return nil
 }
 
-func (bb *badBackend) start(ctx context.Context, name string, args *types.VMSpec) error {
+func (bb *badBackend) prefetch(ctx context.Context, resultCh chan interface{},
+	downloadCh chan<- downloadRequest, args *types.CreateArgs) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) start(ctx context.Context, name string, args *types.VMSpec, force bool) error {
 	return errors.New("Failure")
 }
 
-func (bb *badBackend) stop(ctx context.Context, name string) error {
+func (bb *badBackend) restart(ctx context.Context, args *types.RestartArgs) error {
 	return errors.New("Failure")
 }
 
+func (bb *badBackend) stop(ctx context.Context, args *types.StopArgs) (*types.StopResult, error) {
+	return nil, errors.New("Failure")
+}
+
 func (bb *badBackend) quit(ctx context.Context, name string) error {
 	return errors.New("Failure")
 }
 
-func (bb *badBackend) status(ctx context.Context, name string) (*types.InstanceDetails, error) {
+func (bb *badBackend) resize(ctx context.Context, name string, args *types.ResizeArgs) (*types.ResizeResult, error) {
 	return nil, errors.New("Failure")
 }
 
-func (bb *badBackend) deleteInstance(context.Context, string) error {
+func (bb *badBackend) forward(ctx context.Context, name string, p types.PortMapping, add bool) error {
 	return errors.New("Failure")
 }
 
-func setupServer(t *testing.T, b backend, wg *sync.WaitGroup) (string, chan interface{}, chan struct{}) {
-	downloadCh := make(chan downloadRequest)
-	actionCh := make(chan interface{})
-	doneCh := make(chan struct{})
-	ccvmDir, err := ioutil.TempDir("", "ccvm-test")
-	if err != nil {
-		t.Fatalf("Unable to create directory %v", err)
-	}
+func (bb *badBackend) mount(ctx context.Context, name string, m types.Mount, add bool) error {
+	return errors.New("Failure")
+}
 
-	wg.Add(1)
-	go func() {
-		svc := &ccvmService{
-			ccvmDir:       ccvmDir,
-			downloadCh:    downloadCh,
-			instances:     make(map[string]chan instanceCmd),
-			instanceChMap: make(map[chan struct{}]string),
-			hostIPs:       make(map[uint32]struct{}),
-			hostIPMask:    0x7f000000 | uint32((os.Getuid()&0xffff)<<8),
-			b:             b,
-		}
-		svc.run(doneCh, actionCh)
-		wg.Done()
-	}()
+func (bb *badBackend) disk(ctx context.Context, name string, args *types.DiskArgs, attach bool) error {
+	return errors.New("Failure")
+}
 
-	return ccvmDir, actionCh, doneCh
+func (bb *badBackend) fsck(ctx context.Context, name string, repair bool) (*types.FsckResult, error) {
+	return nil, errors.New("Failure")
 }
 
-func setupServerWithInstances(t *testing.T, b backend, wg *sync.WaitGroup, instances int) (string, chan interface{}, chan struct{}) {
-	downloadCh := make(chan downloadRequest)
-	actionCh := make(chan interface{})
-	doneCh := make(chan struct{})
-	ccvmDir, err := ioutil.TempDir("", "ccvm-test")
-	if err != nil {
-		t.Fatalf("Unable to create directory %v", err)
-	}
+func (bb *badBackend) flatten(ctx context.Context, name string) error {
+	return errors.New("Failure")
+}
 
-	for i := 0; i < instances; i++ {
-		name := makeRandomName()
-		instanceDir := filepath.Join(ccvmDir, "instances", name)
-		if err := os.MkdirAll(instanceDir, 0755); err != nil {
-			_ = os.RemoveAll(ccvmDir)
-			t.Fatal("Unable to create instance directory")
-		}
-	}
+func (bb *badBackend) rename(ctx context.Context, name, newName string) error {
+	return errors.New("Failure")
+}
 
-	wg.Add(1)
-	go func() {
-		svc := &ccvmService{
-			ccvmDir:       ccvmDir,
-			downloadCh:    downloadCh,
-			instances:     make(map[string]chan instanceCmd),
-			instanceChMap: make(map[chan struct{}]string),
-			hostIPs:       make(map[uint32]struct{}),
-			hostIPMask:    0x7f000000 | uint32((os.Getuid()&0xffff)<<8),
-			b:             b,
-		}
-		svc.run(doneCh, actionCh)
-		wg.Done()
-	}()
+func (bb *badBackend) backup(ctx context.Context, name, dir string, scheduled bool) (*types.BackupInfo, error) {
+	return nil, errors.New("Failure")
+}
 
-	return ccvmDir, actionCh, doneCh
+func (bb *badBackend) listBackups(ctx context.Context, name, dir string) (*types.BackupList, error) {
+	return nil, errors.New("Failure")
 }
 
-func TestServerShutdown(t *testing.T) {
-	var wg sync.WaitGroup
+func (bb *badBackend) restoreBackup(ctx context.Context, args *types.RestoreArgs) error {
+	return errors.New("Failure")
+}
 
-	gb := &goodBackend{}
-	dir, _, doneCh := setupServer(t, gb, &wg)
-	close(doneCh)
-	wg.Wait()
-	_ = os.RemoveAll(dir)
+func (bb *badBackend) setBackupPolicy(ctx context.Context, p *types.BackupPolicy) error {
+	return errors.New("Failure")
 }
 
-func checkResult(actionCh chan interface{}, id int, fail bool) error {
-	res := make(chan interface{})
-	actionCh <- getResult{
-		ID:  id,
-		res: res,
-	}
+func (bb *badBackend) suspend(ctx context.Context, name string) error {
+	return errors.New("Failure")
+}
 
-	r := <-res
-	if v, ok := r.(error); ok {
-		return v
-	}
+func (bb *badBackend) resumeFromDisk(ctx context.Context, name string, discard bool) error {
+	return errors.New("Failure")
+}
 
-	resultCh := r.(chan interface{})
-	err, _ := (<-resultCh).(error)
+func (bb *badBackend) set(ctx context.Context, name string, settings map[string]string) error {
+	return errors.New("Failure")
+}
 
-	actionCh <- completeAction(id)
+func (bb *badBackend) status(ctx context.Context, name string) (*types.InstanceDetails, error) {
+	return nil, errors.New("Failure")
+}
 
-	if fail {
-		if err == nil {
-			return errors.New("Command expected to fail")
-		}
-		return nil
-	}
+func (bb *badBackend) summary(ctx context.Context, name string) (*types.InstanceSummary, error) {
+	return nil, errors.New("Failure")
+}
 
-	return err
+func (bb *badBackend) refreshStatus(ctx context.Context, name string, maxStaleness time.Duration) (*types.InstanceDetails, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) deleteInstance(context.Context, string) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) guestRequest(ctx context.Context, name string, req *guestRequest) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) monitor(ctx context.Context, name string) (*vmExit, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) checkReady(ctx context.Context, name string) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) syncFiles(ctx context.Context, name string) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) forwardDocker(ctx context.Context, name string) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) syncStatus(ctx context.Context, name string) (*types.SyncStatusResult, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) kubeconfig(ctx context.Context, name string) (*types.KubeconfigResult, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) pauseSync(ctx context.Context, name string, paused bool) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) vmExited(ctx context.Context, name string, exit *vmExit) (*exitOutcome, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) report(ctx context.Context, since time.Time) (*types.ReportResult, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) preflight(ctx context.Context, args *types.PreflightArgs) (*types.PreflightResult, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) sshKey(ctx context.Context, name string) (*types.SSHKeyResult, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) consoleLogPath(ctx context.Context, name string) (string, error) {
+	return "", errors.New("Failure")
+}
+
+func (bb *badBackend) createNetwork(ctx context.Context, spec *types.NetworkSpec) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) deleteNetwork(ctx context.Context, name string) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) listNetworks(ctx context.Context) ([]types.NetworkSpec, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) createVolume(ctx context.Context, spec *types.VolumeSpec) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) deleteVolume(ctx context.Context, name string) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) listVolumes(ctx context.Context) ([]types.VolumeInfo, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) listCaches(ctx context.Context) ([]types.CacheInfo, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) clearCache(ctx context.Context, name string) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) updateWorkloads(ctx context.Context, URLs []string) ([]string, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) getWorkloads(ctx context.Context) ([]types.WorkloadInfo, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) showWorkload(ctx context.Context, name string) (*types.WorkloadDetails, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) validateWorkload(ctx context.Context, args *types.ValidateWorkloadArgs) ([]types.WorkloadProblem, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) exportInstance(ctx context.Context, name, archive string) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) buildTemplate(ctx context.Context, name string, args *types.BuildTemplateArgs) (*types.TemplateInfo, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) importWorkload(ctx context.Context, archive, name string) (string, error) {
+	return "", errors.New("Failure")
+}
+
+func (bb *badBackend) importVagrant(ctx context.Context, args *types.ImportVagrantArgs) (*types.ImportVagrantResult, error) {
+	return nil, errors.New("Failure")
+}
+
+func (bb *badBackend) pushWorkload(ctx context.Context, args *types.PushArgs) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) pullWorkload(ctx context.Context, args *types.PullArgs) (string, error) {
+	return "", errors.New("Failure")
+}
+
+func (bb *badBackend) execCommand(ctx context.Context, name, command string, stdout, stderr io.Writer) (int, error) {
+	return 0, errors.New("Failure")
+}
+
+func (bb *badBackend) proxy(ctx context.Context, args *types.ProxyArgs, ready func(*types.ProxyStatus)) error {
+	return errors.New("Failure")
+}
+
+func (bb *badBackend) loadGroup(ctx context.Context, args *types.CreateArgs) (*types.GroupSpec, error) {
+	return nil, errors.New("Failure")
+}
+
+func setupServer(t *testing.T, b backend, wg *sync.WaitGroup) (string, chan interface{}, chan struct{}) {
+	downloadCh := make(chan downloadRequest)
+	actionCh := make(chan interface{})
+	doneCh := make(chan struct{})
+	ccvmDir, err := ioutil.TempDir("", "ccvm-test")
+	if err != nil {
+		t.Fatalf("Unable to create directory %v", err)
+	}
+
+	wg.Add(1)
+	go func() {
+		svc := &ccvmService{
+			ccvmDir:       ccvmDir,
+			downloadCh:    downloadCh,
+			instances:     make(map[string]chan instanceCmd),
+			instanceChMap: make(map[chan struct{}]string),
+			groups:        make(map[string]string),
+			hostIPs:       make(map[uint32]struct{}),
+			hostIPMask:    0x7f000000 | uint32((os.Getuid()&0xffff)<<8),
+			b:             b,
+		}
+		svc.run(context.Background(), doneCh, actionCh)
+		wg.Done()
+	}()
+
+	return ccvmDir, actionCh, doneCh
+}
+
+func setupServerWithInstances(t *testing.T, b backend, wg *sync.WaitGroup, instances int) (string, chan interface{}, chan struct{}) {
+	downloadCh := make(chan downloadRequest)
+	actionCh := make(chan interface{})
+	doneCh := make(chan struct{})
+	ccvmDir, err := ioutil.TempDir("", "ccvm-test")
+	if err != nil {
+		t.Fatalf("Unable to create directory %v", err)
+	}
+
+	for i := 0; i < instances; i++ {
+		name := makeRandomName()
+		instanceDir := filepath.Join(ccvmDir, "instances", name)
+		if err := os.MkdirAll(instanceDir, 0755); err != nil {
+			_ = os.RemoveAll(ccvmDir)
+			t.Fatal("Unable to create instance directory")
+		}
+	}
+
+	wg.Add(1)
+	go func() {
+		svc := &ccvmService{
+			ccvmDir:       ccvmDir,
+			downloadCh:    downloadCh,
+			instances:     make(map[string]chan instanceCmd),
+			instanceChMap: make(map[chan struct{}]string),
+			groups:        make(map[string]string),
+			hostIPs:       make(map[uint32]struct{}),
+			hostIPMask:    0x7f000000 | uint32((os.Getuid()&0xffff)<<8),
+			b:             b,
+		}
+		svc.run(context.Background(), doneCh, actionCh)
+		wg.Done()
+	}()
+
+	return ccvmDir, actionCh, doneCh
+}
+
+func TestServerShutdown(t *testing.T) {
+	var wg sync.WaitGroup
+
+	gb := &goodBackend{}
+	dir, _, doneCh := setupServer(t, gb, &wg)
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
+}
+
+func checkResult(actionCh chan interface{}, id int, fail bool) error {
+	res := make(chan interface{})
+	actionCh <- getResult{
+		ID:  id,
+		res: res,
+	}
+
+	r := <-res
+	if v, ok := r.(error); ok {
+		return v
+	}
+
+	resultCh := r.(chan interface{})
+	err, _ := (<-resultCh).(error)
+
+	actionCh <- completeAction(id)
+
+	if fail {
+		if err == nil {
+			return errors.New("Command expected to fail")
+		}
+		return nil
+	}
+
+	return err
+}
+
+// checkRefreshResult retrieves the result of a RefreshStatus transaction and
+// checks that it contains either details or errors for each of the instances.
+func checkRefreshResult(actionCh chan interface{}, id int, names []string, fail bool) error {
+	res := make(chan interface{})
+	actionCh <- getResult{
+		ID:  id,
+		res: res,
+	}
+
+	r := <-res
+	if v, ok := r.(error); ok {
+		return v
+	}
+
+	resultCh := r.(chan interface{})
+	result := <-resultCh
+
+	actionCh <- completeAction(id)
+
+	switch v := result.(type) {
+	case error:
+		return v
+	case types.RefreshStatusResult:
+		if fail && len(v.Errors) != len(names) {
+			return fmt.Errorf("Expected %d errors, got %v", len(names), v.Errors)
+		} else if !fail && len(v.Instances) != len(names) {
+			return fmt.Errorf("Expected %d instances, got %d : %v",
+				len(names), len(v.Instances), v.Errors)
+		}
+	default:
+		return fmt.Errorf("Unexpected result %v", result)
+	}
+
+	return nil
+}
+
+func TestServerTransactionOwner(t *testing.T) {
+	var wg sync.WaitGroup
+
+	gb := &goodBackend{}
+	dir, actionCh, doneCh := setupServer(t, gb, &wg)
+	defer func() {
+		close(doneCh)
+		wg.Wait()
+		_ = os.RemoveAll(dir)
+	}()
+
+	transCh := make(chan int)
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			go func() {
+				select {
+				case <-ctx.Done():
+					resultCh <- ctx.Err()
+				case <-time.After(200 * time.Millisecond):
+					resultCh <- nil
+				}
+			}()
+		},
+		transCh: transCh,
+		owner:   "alice/ci",
+	}
+	id := <-transCh
+
+	// Other callers can neither cancel the transaction nor retrieve its
+	// result.
+
+	actionCh <- cancelAction{ID: id, owner: "bob"}
+	res := make(chan interface{})
+	actionCh <- getResult{ID: id, owner: "bob", res: res}
+	if r := <-res; !strings.Contains(fmt.Sprint(r), "Unknown transaction") {
+		t.Errorf("Result of another caller's transaction retrieved: %v", r)
+	}
+
+	actionCh <- getResult{ID: id, owner: "alice/ci", res: res}
+	r := <-res
+	resultCh, ok := r.(chan interface{})
+	if !ok {
+		t.Fatalf("Unable to retrieve result: %v", r)
+	}
+	if err, _ := (<-resultCh).(error); err != nil {
+		t.Errorf("Transaction cancelled by another caller: %v", err)
+	}
+	actionCh <- completeAction(id)
+}
+
+func TestServerCommands(t *testing.T) {
+	var wg sync.WaitGroup
+
+	gb := &goodBackend{}
+	dir, actionCh, doneCh := setupServer(t, gb, &wg)
+	transCh := make(chan int)
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.create(ctx, resultCh, &types.CreateArgs{
+				Name: "test-instance",
+			})
+		},
+		transCh: transCh,
+	}
+
+	id := <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.stop(ctx, &types.StopArgs{Name: "test-instance"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.start(ctx, "test-instance", &types.VMSpec{}, false, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.restart(ctx, &types.RestartArgs{Name: "test-instance"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.resize(ctx, &types.ResizeArgs{Name: "test-instance", CPUs: 2}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.fsck(ctx, &types.FsckArgs{Name: "test-instance"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.flatten(ctx, "test-instance", resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.backup(ctx, &types.BackupArgs{Name: "test-instance"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.restoreBackup(ctx, &types.RestoreArgs{Name: "test-instance", ID: "20181010T101010Z"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.suspend(ctx, &types.SuspendArgs{Name: "test-instance"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.resumeFromDisk(ctx, &types.ResumeArgs{Name: "test-instance"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.set(ctx, &types.SetArgs{Name: "test-instance", Settings: map[string]string{"autostart": "on"}}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.syncStatus(ctx, "test-instance", resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.kubeconfig(ctx, "test-instance", resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.pauseSync(ctx, "test-instance", true, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, svc service, resultCh chan interface{}) {
+			svc.status(ctx, "", resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, svc service, resultCh chan interface{}) {
+			svc.refreshStatus(ctx, &types.RefreshStatusArgs{}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkRefreshResult(actionCh, id, []string{"test-instance"}, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.quit(ctx, "test-instance", resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.delete(ctx, "test-instance", resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
+}
+
+func TestServerFailedCommands(t *testing.T) {
+	var wg sync.WaitGroup
+
+	bb := &badBackend{}
+	dir, actionCh, doneCh := setupServer(t, bb, &wg)
+	transCh := make(chan int)
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.create(ctx, resultCh, &types.CreateArgs{
+				Name: "test-instance",
+			})
+		},
+		transCh: transCh,
+	}
+
+	id := <-transCh
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.stop(ctx, &types.StopArgs{Name: "test-instance"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.start(ctx, "test-instance", &types.VMSpec{}, false, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.restart(ctx, &types.RestartArgs{Name: "test-instance"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.resize(ctx, &types.ResizeArgs{Name: "test-instance", CPUs: 2}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.fsck(ctx, &types.FsckArgs{Name: "test-instance"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.flatten(ctx, "test-instance", resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.backup(ctx, &types.BackupArgs{Name: "test-instance"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.restoreBackup(ctx, &types.RestoreArgs{Name: "test-instance", ID: "20181010T101010Z"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.suspend(ctx, &types.SuspendArgs{Name: "test-instance"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.resumeFromDisk(ctx, &types.ResumeArgs{Name: "test-instance"}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.set(ctx, &types.SetArgs{Name: "test-instance", Settings: map[string]string{"autostart": "on"}}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.syncStatus(ctx, "test-instance", resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.kubeconfig(ctx, "test-instance", resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.pauseSync(ctx, "test-instance", false, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, svc service, resultCh chan interface{}) {
+			svc.status(ctx, "", resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, svc service, resultCh chan interface{}) {
+			svc.refreshStatus(ctx, &types.RefreshStatusArgs{}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkRefreshResult(actionCh, id, []string{"test-instance"}, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.quit(ctx, "test-instance", resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.delete(ctx, "test-instance", resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.groupAction(ctx, "test-group", groupStop, func(string) bool { return true }, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
+}
+
+func TestServerFailedCreate(t *testing.T) {
+	var wg sync.WaitGroup
+
+	bb := &badBackend{true}
+	dir, actionCh, doneCh := setupServer(t, bb, &wg)
+	transCh := make(chan int)
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.create(ctx, resultCh, &types.CreateArgs{})
+		},
+		transCh: transCh,
+	}
+
+	id := <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
+}
+
+func getInstances(actionCh chan interface{}, transCh chan int) ([]string, error) {
+	summaries, err := getSummaries(actionCh, transCh, "")
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]string, len(summaries))
+	for i := range summaries {
+		instances[i] = summaries[i].Name
+	}
+	return instances, nil
+}
+
+func getSummaries(actionCh chan interface{}, transCh chan int, selector string) ([]types.InstanceSummary, error) {
+	var instances []types.InstanceSummary
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.getInstances(ctx, &types.InstancesArgs{Selector: selector}, resultCh)
+		},
+		transCh: transCh,
+	}
+	id := <-transCh
+
+	resultChCh := make(chan interface{})
+	actionCh <- getResult{
+		ID:  id,
+		res: resultChCh,
+	}
+
+	defer func() {
+		actionCh <- completeAction(id)
+	}()
+
+	r := <-resultChCh
+	if v, ok := r.(error); ok {
+		return nil, fmt.Errorf("Failed to retrieve result channel: %v", v)
+	}
+
+	resultCh := r.(chan interface{})
+	switch res := (<-resultCh).(type) {
+	case error:
+		return nil, fmt.Errorf("Failed to retrieve list of instances: %v", res)
+	case []types.InstanceSummary:
+		instances = res
+	}
+
+	return instances, nil
+}
+
+func TestServerCreateExisting(t *testing.T) {
+	var wg sync.WaitGroup
+
+	gb := &goodBackend{}
+	dir, actionCh, doneCh := setupServerWithInstances(t, gb, &wg, 5)
+
+	transCh := make(chan int)
+	res, err := getInstances(actionCh, transCh)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(res) != 5 {
+		t.Errorf("Expected 5 instances found %d", len(res))
+	}
+
+	if len(res) > 0 {
+		actionCh <- startAction{
+			action: func(ctx context.Context, s service, resultCh chan interface{}) {
+				s.create(ctx, resultCh, &types.CreateArgs{
+					Name: res[0],
+				})
+			},
+			transCh: transCh,
+		}
+
+		id := <-transCh
+		if err := checkResult(actionCh, id, true); err != nil {
+			t.Error(err)
+		}
+	}
+
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
 }
 
-func TestServerCommands(t *testing.T) {
+func TestServerCreateCount(t *testing.T) {
 	var wg sync.WaitGroup
 
 	gb := &goodBackend{}
@@ -215,70 +1316,146 @@ func TestServerCommands(t *testing.T) {
 	actionCh <- startAction{
 		action: func(ctx context.Context, s service, resultCh chan interface{}) {
 			s.create(ctx, resultCh, &types.CreateArgs{
-				Name: "test-instance",
+				Count:        3,
+				NameTemplate: "node-%d",
 			})
 		},
 		transCh: transCh,
 	}
 
 	id := <-transCh
-	if err := checkResult(actionCh, id, false); err != nil {
-		t.Errorf(err.Error())
+	res := make(chan interface{})
+	actionCh <- getResult{
+		ID:  id,
+		res: res,
 	}
+	resultCh := (<-res).(chan interface{})
+	result := <-resultCh
+	actionCh <- completeAction(id)
 
-	actionCh <- startAction{
-		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.stop(ctx, "test-instance", resultCh)
-		},
-		transCh: transCh,
+	expected := []string{"node-1", "node-2", "node-3"}
+	if cr, ok := result.(types.CreateResult); !ok {
+		t.Errorf("Unexpected create result %v", result)
+	} else if !cr.Finished || !reflect.DeepEqual(cr.Names, expected) {
+		t.Errorf("Expected finished result with names %v, got %+v", expected, cr)
 	}
-	id = <-transCh
-	if err := checkResult(actionCh, id, false); err != nil {
-		t.Errorf(err.Error())
+
+	instances, err := getInstances(actionCh, transCh)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("Expected instances %v, found %v", expected, instances)
 	}
 
 	actionCh <- startAction{
 		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.start(ctx, "test-instance", &types.VMSpec{}, resultCh)
+			s.create(ctx, resultCh, &types.CreateArgs{
+				Count:        2,
+				NameTemplate: "node",
+			})
 		},
 		transCh: transCh,
 	}
+
 	id = <-transCh
-	if err := checkResult(actionCh, id, false); err != nil {
-		t.Errorf(err.Error())
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
 	}
 
-	actionCh <- startAction{
-		action: func(ctx context.Context, svc service, resultCh chan interface{}) {
-			svc.status(ctx, "", resultCh)
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
+}
+
+func TestCloneCreateArgs(t *testing.T) {
+	args := &types.CreateArgs{
+		CustomSpec: types.VMSpec{
+			Mounts:       []types.Mount{{Tag: "tmp", Path: "/tmp"}},
+			PortMappings: []types.PortMapping{{Host: 10022, Guest: 22}},
 		},
-		transCh: transCh,
+		Labels: map[string]string{"app": "web"},
 	}
-	id = <-transCh
-	if err := checkResult(actionCh, id, false); err != nil {
-		t.Errorf(err.Error())
+	clone := cloneCreateArgs(args)
+	clone.CustomSpec.Mounts[0].Path = "/var/tmp"
+	clone.CustomSpec.PortMappings[0].Host = 10023
+	clone.Labels["app"] = "db"
+
+	if args.CustomSpec.Mounts[0].Path != "/tmp" ||
+		args.CustomSpec.PortMappings[0].Host != 10022 ||
+		args.Labels["app"] != "web" {
+		t.Errorf("Clone shares its arguments with the original %+v", args)
 	}
+}
+
+func TestServerCreateGroup(t *testing.T) {
+	var wg sync.WaitGroup
+
+	gb := &goodBackend{}
+	dir, actionCh, doneCh := setupServer(t, gb, &wg)
+	transCh := make(chan int)
 
 	actionCh <- startAction{
 		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.quit(ctx, "test-instance", resultCh)
+			s.createGroup(ctx, resultCh, &types.CreateArgs{
+				Name:         "k8s",
+				WorkloadName: "k8s-cluster",
+			})
 		},
 		transCh: transCh,
 	}
-	id = <-transCh
-	if err := checkResult(actionCh, id, false); err != nil {
-		t.Errorf(err.Error())
+
+	id := <-transCh
+	res := make(chan interface{})
+	actionCh <- getResult{
+		ID:  id,
+		res: res,
+	}
+	resultCh := (<-res).(chan interface{})
+	result := <-resultCh
+	actionCh <- completeAction(id)
+
+	expected := []string{"k8s-master-1", "k8s-worker-1", "k8s-worker-2"}
+	if cr, ok := result.(types.CreateResult); !ok {
+		t.Errorf("Unexpected create result %v", result)
+	} else if !cr.Finished || !reflect.DeepEqual(cr.Names, expected) {
+		t.Errorf("Expected finished result with names %v, got %+v", expected, cr)
+	}
+
+	instances, err := getInstances(actionCh, transCh)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Errorf("Expected instances %v, found %v", expected, instances)
 	}
 
 	actionCh <- startAction{
 		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.delete(ctx, "test-instance", resultCh)
+			s.createGroup(ctx, resultCh, &types.CreateArgs{
+				Name:         "k8s",
+				WorkloadName: "k8s-cluster",
+			})
 		},
 		transCh: transCh,
 	}
 	id = <-transCh
-	if err := checkResult(actionCh, id, false); err != nil {
-		t.Errorf(err.Error())
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
+	}
+
+	for _, action := range []int{groupStop, groupStart, groupQuit, groupDelete} {
+		action := action
+		actionCh <- startAction{
+			action: func(ctx context.Context, s service, resultCh chan interface{}) {
+				s.groupAction(ctx, "k8s", action, func(string) bool { return true }, resultCh)
+			},
+			transCh: transCh,
+		}
+		id = <-transCh
+		if err := checkResult(actionCh, id, false); err != nil {
+			t.Error(err)
+		}
 	}
 
 	close(doneCh)
@@ -286,7 +1463,7 @@ func TestServerCommands(t *testing.T) {
 	_ = os.RemoveAll(dir)
 }
 
-func TestServerFailedCommands(t *testing.T) {
+func TestServerCreateGroupFailed(t *testing.T) {
 	var wg sync.WaitGroup
 
 	bb := &badBackend{}
@@ -295,71 +1472,112 @@ func TestServerFailedCommands(t *testing.T) {
 
 	actionCh <- startAction{
 		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.create(ctx, resultCh, &types.CreateArgs{
-				Name: "test-instance",
+			s.createGroup(ctx, resultCh, &types.CreateArgs{
+				Name:         "k8s",
+				WorkloadName: "k8s-cluster",
 			})
 		},
 		transCh: transCh,
 	}
-
 	id := <-transCh
-	if err := checkResult(actionCh, id, false); err != nil {
-		t.Errorf(err.Error())
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
 	}
 
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
+}
+
+func batchResult(actionCh chan<- interface{}, transCh chan int, args *types.BatchArgs) (interface{}, error) {
 	actionCh <- startAction{
 		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.stop(ctx, "test-instance", resultCh)
+			s.batch(ctx, args, func(string) bool { return true }, resultCh)
 		},
 		transCh: transCh,
 	}
-	id = <-transCh
-	if err := checkResult(actionCh, id, true); err != nil {
-		t.Errorf(err.Error())
+	id := <-transCh
+	if id == noTransaction {
+		return nil, errors.New("Unable to start batch transaction")
+	}
+	res := make(chan interface{})
+	actionCh <- getResult{
+		ID:  id,
+		res: res,
 	}
+	resultCh := (<-res).(chan interface{})
+	result := <-resultCh
+	actionCh <- completeAction(id)
+	return result, nil
+}
 
-	actionCh <- startAction{
-		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.start(ctx, "test-instance", &types.VMSpec{}, resultCh)
-		},
-		transCh: transCh,
+func TestServerBatch(t *testing.T) {
+	var wg sync.WaitGroup
+
+	gb := &goodBackend{}
+	dir, actionCh, doneCh := setupServerWithInstances(t, gb, &wg, 3)
+	transCh := make(chan int)
+
+	instances, err := getInstances(actionCh, transCh)
+	if err != nil {
+		t.Fatal(err)
 	}
-	id = <-transCh
-	if err := checkResult(actionCh, id, true); err != nil {
-		t.Errorf(err.Error())
+
+	for _, action := range []string{types.BatchStop, types.BatchStart, types.BatchQuit} {
+		result, err := batchResult(actionCh, transCh, &types.BatchArgs{Action: action, All: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		br, ok := result.(types.BatchResult)
+		if !ok {
+			t.Fatalf("Unexpected %s result %v", action, result)
+		}
+		if len(br.Instances) != len(instances) {
+			t.Errorf("Expected %s of %d instances, got %+v", action, len(instances), br)
+		}
+		for _, r := range br.Instances {
+			if r.Error != "" {
+				t.Errorf("%s of %s failed: %s", action, r.Name, r.Error)
+			}
+		}
 	}
 
-	actionCh <- startAction{
-		action: func(ctx context.Context, svc service, resultCh chan interface{}) {
-			svc.status(ctx, "", resultCh)
-		},
-		transCh: transCh,
+	result, err := batchResult(actionCh, transCh, &types.BatchArgs{
+		Action: types.BatchDelete,
+		Names:  []string{instances[0], "no-such-instance"},
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
-	id = <-transCh
-	if err := checkResult(actionCh, id, true); err != nil {
-		t.Errorf(err.Error())
+	if _, ok := result.(error); !ok {
+		t.Errorf("Expected batch with unknown instance to fail, got %v", result)
 	}
 
-	actionCh <- startAction{
-		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.quit(ctx, "test-instance", resultCh)
-		},
-		transCh: transCh,
+	result, err = batchResult(actionCh, transCh, &types.BatchArgs{
+		Action: types.BatchDelete,
+		Names:  []string{instances[0], "*"},
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
-	id = <-transCh
-	if err := checkResult(actionCh, id, true); err != nil {
-		t.Errorf(err.Error())
+	if br, ok := result.(types.BatchResult); !ok || len(br.Instances) != len(instances) {
+		t.Errorf("Unexpected delete result %v", result)
 	}
 
-	actionCh <- startAction{
-		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.delete(ctx, "test-instance", resultCh)
-		},
-		transCh: transCh,
+	// The instances' loops quit asynchronously once they are deleted.
+	var remaining []string
+	for start := time.Now(); time.Since(start) < 5*time.Second; {
+		remaining, err = getInstances(actionCh, transCh)
+		if err != nil || len(remaining) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
-	id = <-transCh
-	if err := checkResult(actionCh, id, true); err != nil {
-		t.Errorf(err.Error())
+	if err != nil {
+		t.Error(err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("Instances %v not deleted", remaining)
 	}
 
 	close(doneCh)
@@ -367,23 +1585,31 @@ func TestServerFailedCommands(t *testing.T) {
 	_ = os.RemoveAll(dir)
 }
 
-func TestServerFailedCreate(t *testing.T) {
+func TestServerGuestAction(t *testing.T) {
 	var wg sync.WaitGroup
 
-	bb := &badBackend{true}
-	dir, actionCh, doneCh := setupServer(t, bb, &wg)
+	gb := &goodBackend{}
+	dir, actionCh, doneCh := setupServerWithInstances(t, gb, &wg, 1)
 	transCh := make(chan int)
 
-	actionCh <- startAction{
-		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.create(ctx, resultCh, &types.CreateArgs{})
-		},
-		transCh: transCh,
+	instances, err := getInstances(actionCh, transCh)
+	if err != nil || len(instances) != 1 {
+		t.Fatalf("Expected 1 instance, found %v: %v", instances, err)
 	}
 
-	id := <-transCh
-	if err := checkResult(actionCh, id, true); err != nil {
-		t.Errorf(err.Error())
+	for _, name := range []string{instances[0], "missing"} {
+		resultCh := make(chan interface{}, 1)
+		actionCh <- guestAction{
+			name:     name,
+			req:      guestRequest{Command: "notify", Args: []string{"done"}},
+			resultCh: resultCh,
+		}
+		err, _ := (<-resultCh).(error)
+		if name == "missing" && err == nil {
+			t.Errorf("Guest request for missing instance expected to fail")
+		} else if name != "missing" && err != nil {
+			t.Errorf("Guest request failed: %v", err)
+		}
 	}
 
 	close(doneCh)
@@ -391,120 +1617,220 @@ func TestServerFailedCreate(t *testing.T) {
 	_ = os.RemoveAll(dir)
 }
 
-func getInstances(actionCh chan interface{}, transCh chan int) ([]string, error) {
-	var instances []string
+func TestServerWatchEvents(t *testing.T) {
+	var wg sync.WaitGroup
+
+	gb := &goodBackend{}
+	dir, actionCh, doneCh := setupServer(t, gb, &wg)
+	transCh := make(chan int)
+
+	watch := func(names []string) (int, chan interface{}) {
+		actionCh <- startAction{
+			action: func(ctx context.Context, s service, resultCh chan interface{}) {
+				s.watchEvents(ctx, &types.WatchEventsArgs{Names: names}, resultCh)
+			},
+			transCh: transCh,
+		}
+		id := <-transCh
+		res := make(chan interface{})
+		actionCh <- getResult{
+			ID:  id,
+			res: res,
+		}
+		return id, (<-res).(chan interface{})
+	}
+
+	allID, allCh := watch(nil)
+	otherID, otherCh := watch([]string{"other-instance"})
+
+	name := "test-instance"
+	commands := []func(ctx context.Context, s service, resultCh chan interface{}){
+		func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.create(ctx, resultCh, &types.CreateArgs{Name: name})
+		},
+		func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.stop(ctx, &types.StopArgs{Name: name}, resultCh)
+		},
+		func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.start(ctx, name, &types.VMSpec{}, false, resultCh)
+		},
+		func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.quit(ctx, name, resultCh)
+		},
+	}
+	for _, command := range commands {
+		actionCh <- startAction{
+			action:  command,
+			transCh: transCh,
+		}
+		if err := checkResult(actionCh, <-transCh, false); err != nil {
+			t.Error(err)
+		}
+	}
+
+	actionCh <- vmExitAction{name: name, exit: &vmExit{crashed: true}}
 
 	actionCh <- startAction{
 		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.getInstances(ctx, resultCh)
+			s.delete(ctx, name, resultCh)
 		},
 		transCh: transCh,
 	}
-	id := <-transCh
-
-	resultChCh := make(chan interface{})
-	actionCh <- getResult{
-		ID:  id,
-		res: resultChCh,
+	if err := checkResult(actionCh, <-transCh, false); err != nil {
+		t.Error(err)
 	}
 
-	defer func() {
-		actionCh <- completeAction(id)
-	}()
-
-	r := <-resultChCh
-	if v, ok := r.(error); ok {
-		return nil, fmt.Errorf("Failed to retrieve result channel: %v", v)
+	expected := []string{types.EventCreated, types.EventStopped, types.EventStarted,
+		types.EventStopped, types.EventCrashed, types.EventDeleted}
+	for _, eventType := range expected {
+		select {
+		case r := <-allCh:
+			event, ok := r.(types.InstanceEvent)
+			if !ok || event.Name != name || event.Type != eventType {
+				t.Errorf("Expected %s event for %s, got %v", eventType, name, r)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s event", eventType)
+		}
 	}
 
-	resultCh := r.(chan interface{})
-	switch res := (<-resultCh).(type) {
-	case error:
-		return nil, fmt.Errorf("Failed to retrieve list of instances: %v", res)
-	case []string:
-		instances = res
+	for _, id := range []int{allID, otherID} {
+		actionCh <- cancelAction{ID: id}
+	}
+	for r := range allCh {
+		t.Errorf("Unexpected event %v", r)
+	}
+	for r := range otherCh {
+		t.Errorf("Unexpected event %v", r)
+	}
+	for _, id := range []int{allID, otherID} {
+		actionCh <- completeAction(id)
 	}
 
-	return instances, nil
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
 }
 
-func TestServerCreateExisting(t *testing.T) {
+func TestServerCorruptInstances(t *testing.T) {
 	var wg sync.WaitGroup
 
-	gb := &goodBackend{}
+	gb := &goodBackend{
+		reuseIps: true,
+	}
 	dir, actionCh, doneCh := setupServerWithInstances(t, gb, &wg, 5)
 
 	transCh := make(chan int)
 	res, err := getInstances(actionCh, transCh)
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
 	}
-	if len(res) != 5 {
-		t.Errorf("Expected 5 instances found %d", len(res))
+	if len(res) != 1 {
+		t.Errorf("Expected 1 instance found %d", len(res))
 	}
 
-	if len(res) > 0 {
-		actionCh <- startAction{
-			action: func(ctx context.Context, s service, resultCh chan interface{}) {
-				s.create(ctx, resultCh, &types.CreateArgs{
-					Name: res[0],
-				})
-			},
-			transCh: transCh,
-		}
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
+}
 
-		id := <-transCh
-		if err := checkResult(actionCh, id, true); err != nil {
-			t.Errorf(err.Error())
-		}
+func TestServerShutdownPending(t *testing.T) {
+	var wg sync.WaitGroup
+
+	gb := &goodBackend{}
+	dir, actionCh, doneCh := setupServer(t, gb, &wg)
+	transCh := make(chan int)
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.create(ctx, resultCh, &types.CreateArgs{})
+		},
+		transCh: transCh,
+	}
+
+	_ = <-transCh
+
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
+}
+
+// blockingAction returns an action that completes when releaseCh is closed
+// or its context is cancelled.
+func blockingAction(releaseCh chan struct{}) func(context.Context, service, chan interface{}) {
+	return func(ctx context.Context, s service, resultCh chan interface{}) {
+		go func() {
+			select {
+			case <-releaseCh:
+				resultCh <- struct{}{}
+			case <-ctx.Done():
+				resultCh <- ctx.Err()
+			}
+			close(resultCh)
+		}()
+	}
+}
+
+func TestServerDrain(t *testing.T) {
+	var wg sync.WaitGroup
+
+	gb := &goodBackend{}
+	dir, actionCh, _ := setupServer(t, gb, &wg)
+	defer func() { _ = os.RemoveAll(dir) }()
+	transCh := make(chan int)
+
+	releaseCh := make(chan struct{})
+	actionCh <- startAction{
+		action:  blockingAction(releaseCh),
+		transCh: transCh,
 	}
+	id := <-transCh
 
-	close(doneCh)
-	wg.Wait()
-	_ = os.RemoveAll(dir)
-}
+	actionCh <- startAction{
+		action:        blockingAction(make(chan struct{})),
+		transCh:       transCh,
+		interruptible: true,
+	}
+	watchID := <-transCh
 
-func TestServerCorruptInstances(t *testing.T) {
-	var wg sync.WaitGroup
+	actionCh <- drainAction{}
 
-	gb := &goodBackend{
-		reuseIps: true,
+	actionCh <- startAction{
+		action:  blockingAction(releaseCh),
+		transCh: transCh,
+	}
+	if <-transCh != noTransaction {
+		t.Errorf("Transaction started while draining")
 	}
-	dir, actionCh, doneCh := setupServerWithInstances(t, gb, &wg, 5)
 
-	transCh := make(chan int)
-	res, err := getInstances(actionCh, transCh)
-	if err != nil {
-		t.Errorf(err.Error())
+	if err := checkResult(actionCh, watchID, true); err != nil {
+		t.Errorf("Interruptible transaction not cancelled: %v", err)
 	}
-	if len(res) != 1 {
-		t.Errorf("Expected 1 instance found %d", len(res))
+
+	close(releaseCh)
+	if err := checkResult(actionCh, id, false); err != nil {
+		t.Errorf("Transaction did not complete: %v", err)
 	}
 
-	close(doneCh)
 	wg.Wait()
-	_ = os.RemoveAll(dir)
 }
 
-func TestServerShutdownPending(t *testing.T) {
+func TestServerDrainTimeout(t *testing.T) {
 	var wg sync.WaitGroup
 
 	gb := &goodBackend{}
-	dir, actionCh, doneCh := setupServer(t, gb, &wg)
+	dir, actionCh, _ := setupServer(t, gb, &wg)
+	defer func() { _ = os.RemoveAll(dir) }()
 	transCh := make(chan int)
 
 	actionCh <- startAction{
-		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.create(ctx, resultCh, &types.CreateArgs{})
-		},
+		action:  blockingAction(make(chan struct{})),
 		transCh: transCh,
 	}
-
 	_ = <-transCh
 
-	close(doneCh)
+	actionCh <- drainAction{timeout: time.Millisecond * 10}
 	wg.Wait()
-	_ = os.RemoveAll(dir)
 }
 
 func TestServerCancelCreate(t *testing.T) {
@@ -523,7 +1849,7 @@ func TestServerCancelCreate(t *testing.T) {
 		transCh: transCh,
 	}
 
-	actionCh <- cancelAction(<-transCh)
+	actionCh <- cancelAction{ID: <-transCh}
 
 	close(doneCh)
 	wg.Wait()
@@ -548,24 +1874,24 @@ func TestServerCancelCommands(t *testing.T) {
 
 	id := <-transCh
 	if err := checkResult(actionCh, id, false); err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
 	}
 
 	actionCh <- startAction{
 		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.stop(ctx, "test-instance", resultCh)
+			s.stop(ctx, &types.StopArgs{Name: "test-instance"}, resultCh)
 		},
 		transCh: transCh,
 	}
-	actionCh <- cancelAction(<-transCh)
+	actionCh <- cancelAction{ID: <-transCh}
 
 	actionCh <- startAction{
 		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.start(ctx, "test-instance", &types.VMSpec{}, resultCh)
+			s.start(ctx, "test-instance", &types.VMSpec{}, false, resultCh)
 		},
 		transCh: transCh,
 	}
-	actionCh <- cancelAction(<-transCh)
+	actionCh <- cancelAction{ID: <-transCh}
 
 	actionCh <- startAction{
 		action: func(ctx context.Context, s service, resultCh chan interface{}) {
@@ -573,7 +1899,7 @@ func TestServerCancelCommands(t *testing.T) {
 		},
 		transCh: transCh,
 	}
-	actionCh <- cancelAction(<-transCh)
+	actionCh <- cancelAction{ID: <-transCh}
 
 	actionCh <- startAction{
 		action: func(ctx context.Context, s service, resultCh chan interface{}) {
@@ -581,7 +1907,7 @@ func TestServerCancelCommands(t *testing.T) {
 		},
 		transCh: transCh,
 	}
-	actionCh <- cancelAction(<-transCh)
+	actionCh <- cancelAction{ID: <-transCh}
 
 	close(doneCh)
 	wg.Wait()
@@ -597,24 +1923,35 @@ func TestServerNoInstance(t *testing.T) {
 
 	actionCh <- startAction{
 		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.stop(ctx, "test-instance", resultCh)
+			s.stop(ctx, &types.StopArgs{Name: "test-instance"}, resultCh)
 		},
 		transCh: transCh,
 	}
 	id := <-transCh
 	if err := checkResult(actionCh, id, true); err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.start(ctx, "test-instance", &types.VMSpec{}, false, resultCh)
+		},
+		transCh: transCh,
+	}
+	id = <-transCh
+	if err := checkResult(actionCh, id, true); err != nil {
+		t.Error(err)
 	}
 
 	actionCh <- startAction{
 		action: func(ctx context.Context, s service, resultCh chan interface{}) {
-			s.start(ctx, "test-instance", &types.VMSpec{}, resultCh)
+			s.restart(ctx, &types.RestartArgs{Name: "test-instance"}, resultCh)
 		},
 		transCh: transCh,
 	}
 	id = <-transCh
 	if err := checkResult(actionCh, id, true); err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
 	}
 
 	actionCh <- startAction{
@@ -625,7 +1962,7 @@ func TestServerNoInstance(t *testing.T) {
 	}
 	id = <-transCh
 	if err := checkResult(actionCh, id, true); err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
 	}
 
 	actionCh <- startAction{
@@ -636,7 +1973,7 @@ func TestServerNoInstance(t *testing.T) {
 	}
 	id = <-transCh
 	if err := checkResult(actionCh, id, true); err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
 	}
 
 	close(doneCh)
@@ -666,13 +2003,13 @@ func TestServerGetInstances(t *testing.T) {
 
 	for i := range ids {
 		if err := checkResult(actionCh, ids[i], false); err != nil {
-			t.Errorf(err.Error())
+			t.Error(err)
 		}
 	}
 
 	res, err := getInstances(actionCh, transCh)
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
 	} else {
 		if len(res) != 2 {
 			t.Errorf("Expected two instances")
@@ -685,3 +2022,502 @@ func TestServerGetInstances(t *testing.T) {
 	wg.Wait()
 	_ = os.RemoveAll(dir)
 }
+
+func TestServerGetInstancesSelector(t *testing.T) {
+	var wg sync.WaitGroup
+
+	gb := &goodBackend{labels: map[string]string{"project": "kata"}}
+	dir, actionCh, doneCh := setupServer(t, gb, &wg)
+	transCh := make(chan int)
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.create(ctx, resultCh, &types.CreateArgs{})
+		},
+		transCh: transCh,
+	}
+	if err := checkResult(actionCh, <-transCh, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		selector string
+		count    int
+	}{
+		{"", 1},
+		{"project=kata", 1},
+		{"project!=kata", 0},
+		{"project=clear", 0},
+		{"!project", 0},
+	}
+	for _, tt := range tests {
+		res, err := getSummaries(actionCh, transCh, tt.selector)
+		if err != nil {
+			t.Errorf("%s: %v", tt.selector, err)
+			continue
+		}
+		if len(res) != tt.count {
+			t.Errorf("%s: expected %d instances, got %d", tt.selector, tt.count, len(res))
+		}
+		for _, s := range res {
+			if s.State != types.StateRunning {
+				t.Errorf("%s: unexpected state %s", tt.selector, s.State)
+			}
+		}
+	}
+
+	if _, err := getSummaries(actionCh, transCh, "project=a=b"); err == nil {
+		t.Errorf("Expected invalid selector to be rejected")
+	}
+
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
+}
+
+// waitForInstances waits for the instances of the service to be expected,
+// as instance loops quit asynchronously.
+func waitForInstances(actionCh chan interface{}, transCh chan int, expected []string) error {
+	var instances []string
+	var err error
+	for start := time.Now(); time.Since(start) < 5*time.Second; {
+		instances, err = getInstances(actionCh, transCh)
+		if err != nil {
+			return err
+		}
+		if reflect.DeepEqual(instances, expected) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("Expected instances %v, found %v", expected, instances)
+}
+
+func TestServerRename(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		var wg sync.WaitGroup
+
+		gb := &goodBackend{failRename: fail}
+		dir, actionCh, doneCh := setupServer(t, gb, &wg)
+		transCh := make(chan int)
+
+		run := func(action func(ctx context.Context, s service, resultCh chan interface{}), fail bool) error {
+			actionCh <- startAction{
+				action:  action,
+				transCh: transCh,
+			}
+			return checkResult(actionCh, <-transCh, fail)
+		}
+
+		for _, name := range []string{"alpha", "beta"} {
+			name := name
+			err := run(func(ctx context.Context, s service, resultCh chan interface{}) {
+				s.create(ctx, resultCh, &types.CreateArgs{Name: name})
+			}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := run(func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.rename(ctx, &types.RenameArgs{Name: "alpha", NewName: "gamma"}, resultCh)
+		}, fail)
+		if err != nil {
+			t.Errorf("Unexpected rename result: %v", err)
+		}
+
+		expected := []string{"beta", "gamma"}
+		if fail {
+			expected = []string{"alpha", "beta"}
+		} else {
+			err = run(func(ctx context.Context, s service, resultCh chan interface{}) {
+				s.status(ctx, "gamma", resultCh)
+			}, false)
+			if err != nil {
+				t.Errorf("Renamed instance not found: %v", err)
+			}
+		}
+		if err := waitForInstances(actionCh, transCh, expected); err != nil {
+			t.Error(err)
+		}
+
+		for _, args := range []types.RenameArgs{
+			{Name: "beta", NewName: expected[0]},
+			{Name: "beta", NewName: "not a hostname"},
+			{Name: "delta", NewName: "epsilon"},
+		} {
+			args := args
+			err = run(func(ctx context.Context, s service, resultCh chan interface{}) {
+				s.rename(ctx, &args, resultCh)
+			}, true)
+			if err != nil {
+				t.Errorf("Rename %+v: %v", args, err)
+			}
+		}
+
+		close(doneCh)
+		wg.Wait()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestServerConsoleLog(t *testing.T) {
+	var wg sync.WaitGroup
+
+	gb := &goodBackend{}
+	dir, actionCh, doneCh := setupServer(t, gb, &wg)
+	transCh := make(chan int)
+
+	name := "test-instance"
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.create(ctx, resultCh, &types.CreateArgs{Name: name})
+		},
+		transCh: transCh,
+	}
+	if err := checkResult(actionCh, <-transCh, false); err != nil {
+		t.Fatalf("Unable to create instance: %v", err)
+	}
+
+	consoleLog := func(follow bool) (int, chan interface{}) {
+		actionCh <- startAction{
+			action: func(ctx context.Context, s service, resultCh chan interface{}) {
+				s.consoleLog(ctx, &types.ConsoleLogArgs{Name: name, Follow: follow}, resultCh)
+			},
+			transCh: transCh,
+		}
+		id := <-transCh
+		res := make(chan interface{})
+		actionCh <- getResult{
+			ID:  id,
+			res: res,
+		}
+		return id, (<-res).(chan interface{})
+	}
+
+	id, resultCh := consoleLog(false)
+	if out, ok := (<-resultCh).(types.ConsoleOutput); !ok || out.Finished {
+		t.Errorf("Expected console output, got %v", out)
+	}
+	if out, ok := (<-resultCh).(types.ConsoleOutput); !ok || !out.Finished {
+		t.Errorf("Expected console output to be finished, got %v", out)
+	}
+	actionCh <- completeAction(id)
+
+	// Following the console lasts until the instance is deleted.
+	id, resultCh = consoleLog(true)
+	if out, ok := (<-resultCh).(types.ConsoleOutput); !ok || out.Finished {
+		t.Errorf("Expected console output, got %v", out)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.delete(ctx, name, resultCh)
+		},
+		transCh: transCh,
+	}
+	if err := checkResult(actionCh, <-transCh, false); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case r := <-resultCh:
+		if out, ok := r.(types.ConsoleOutput); !ok || !out.Finished {
+			t.Errorf("Expected console output to be finished, got %v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for console to be closed")
+	}
+	actionCh <- completeAction(id)
+
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
+}
+
+func TestServerNetworks(t *testing.T) {
+	var wg sync.WaitGroup
+
+	gb := &goodBackend{}
+	dir, actionCh, doneCh := setupServer(t, gb, &wg)
+	transCh := make(chan int)
+
+	run := func(action func(ctx context.Context, s service, resultCh chan interface{}), fail bool) error {
+		actionCh <- startAction{
+			action:  action,
+			transCh: transCh,
+		}
+		return checkResult(actionCh, <-transCh, fail)
+	}
+
+	name := "test-instance"
+	err := run(func(ctx context.Context, s service, resultCh chan interface{}) {
+		s.create(ctx, resultCh, &types.CreateArgs{
+			Name:       name,
+			CustomSpec: types.VMSpec{Network: "lab"},
+		})
+	}, false)
+	if err != nil {
+		t.Fatalf("Unable to create instance: %v", err)
+	}
+
+	actionCh <- startAction{
+		action: func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.listNetworks(ctx, resultCh)
+		},
+		transCh: transCh,
+	}
+	id := <-transCh
+	res := make(chan interface{})
+	actionCh <- getResult{
+		ID:  id,
+		res: res,
+	}
+	networks, _ := (<-(<-res).(chan interface{})).([]types.NetworkInfo)
+	actionCh <- completeAction(id)
+	if len(networks) != 2 || len(networks[0].Instances) != 0 ||
+		len(networks[1].Instances) != 1 || networks[1].Instances[0] != name {
+		t.Errorf("Unexpected networks %+v", networks)
+	}
+
+	for _, network := range []string{types.DefaultNetwork, "lab"} {
+		err = run(func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.deleteNetwork(ctx, network, resultCh)
+		}, true)
+		if err != nil {
+			t.Errorf("Deletion of network %s: %v", network, err)
+		}
+	}
+
+	err = run(func(ctx context.Context, s service, resultCh chan interface{}) {
+		s.delete(ctx, name, resultCh)
+	}, false)
+	if err != nil {
+		t.Errorf("Unable to delete instance: %v", err)
+	}
+
+	// The instance's loop quits asynchronously once it is deleted.
+	for start := time.Now(); time.Since(start) < 5*time.Second; {
+		err = run(func(ctx context.Context, s service, resultCh chan interface{}) {
+			s.deleteNetwork(ctx, "lab", resultCh)
+		}, false)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Errorf("Unable to delete unused network: %v", err)
+	}
+
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
+}
+
+func TestServerExec(t *testing.T) {
+	for _, b := range []backend{&goodBackend{}, &badBackend{}} {
+		var wg sync.WaitGroup
+
+		dir, actionCh, doneCh := setupServer(t, b, &wg)
+		transCh := make(chan int)
+
+		name := "test-instance"
+		_, fail := b.(*badBackend)
+		actionCh <- startAction{
+			action: func(ctx context.Context, s service, resultCh chan interface{}) {
+				s.create(ctx, resultCh, &types.CreateArgs{Name: name})
+			},
+			transCh: transCh,
+		}
+		if err := checkResult(actionCh, <-transCh, false); err != nil {
+			t.Fatalf("Unable to create instance: %v", err)
+		}
+
+		actionCh <- startAction{
+			action: func(ctx context.Context, s service, resultCh chan interface{}) {
+				s.exec(ctx, &types.ExecArgs{Name: name, Command: "uname"}, resultCh)
+			},
+			transCh: transCh,
+		}
+		id := <-transCh
+		res := make(chan interface{})
+		actionCh <- getResult{
+			ID:  id,
+			res: res,
+		}
+		r := <-res
+		if resultCh, ok := r.(chan interface{}); ok {
+			var stdout, stderr string
+			var last interface{}
+			for v := range resultCh {
+				last = v
+				if out, ok := v.(types.ExecOutput); ok {
+					stdout += out.Stdout
+					stderr += out.Stderr
+				}
+			}
+			out, ok := last.(types.ExecOutput)
+			if fail && ok {
+				t.Errorf("Exec expected to fail")
+			} else if !fail && (!ok || !out.Finished || out.ExitCode != 3 ||
+				stdout != "uname\n" || stderr != "warning\n") {
+				t.Errorf("Unexpected exec output %q %q %v", stdout, stderr, last)
+			}
+		} else if !fail {
+			t.Errorf("Exec failed: %v", r)
+		}
+		actionCh <- completeAction(id)
+
+		close(doneCh)
+		wg.Wait()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestServerReport(t *testing.T) {
+	for _, b := range []backend{&goodBackend{}, &badBackend{}} {
+		var wg sync.WaitGroup
+
+		dir, actionCh, doneCh := setupServer(t, b, &wg)
+		transCh := make(chan int)
+
+		actionCh <- startAction{
+			action: func(ctx context.Context, s service, resultCh chan interface{}) {
+				s.report(ctx, &types.ReportArgs{}, resultCh)
+			},
+			transCh: transCh,
+		}
+
+		_, fail := b.(*badBackend)
+		if err := checkResult(actionCh, <-transCh, fail); err != nil {
+			t.Error(err)
+		}
+
+		close(doneCh)
+		wg.Wait()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestInstanceLoopQueue(t *testing.T) {
+	var wg sync.WaitGroup
+
+	instanceCh := make(chan instanceCmd)
+	closeCh := make(chan struct{})
+	wg.Add(1)
+	go instanceLoop("queued", instanceCh, closeCh, &wg)
+
+	release := make(chan struct{})
+	order := make(chan int, 4)
+	queueCmd := func(i int, ctx context.Context, fn func() error) chan interface{} {
+		resultCh := make(chan interface{}, 16)
+		instanceCh <- instanceCmd{
+			cmdType:    instanceCmdOther,
+			resultCh:   resultCh,
+			ctx:        ctx,
+			progressCh: resultCh,
+			fn: func() error {
+				order <- i
+				if fn != nil {
+					return fn()
+				}
+				return nil
+			},
+		}
+		return resultCh
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Commands are queued, rather than blocking the sender, while the
+	// first command is executing.
+	first := queueCmd(1, nil, func() error {
+		<-release
+		return nil
+	})
+	second := queueCmd(2, nil, nil)
+	third := queueCmd(3, cancelled, nil)
+	fourth := queueCmd(4, nil, nil)
+	close(release)
+
+	var positions []int
+	for r := range fourth {
+		if cr, ok := r.(types.CommandResult); ok {
+			positions = append(positions, cr.Position)
+		}
+	}
+	if !reflect.DeepEqual(positions, []int{3, 2}) {
+		t.Errorf("Unexpected queue positions %v", positions)
+	}
+	if r := <-second; !reflect.DeepEqual(r, types.CommandResult{Position: 1}) {
+		t.Errorf("Unexpected queue position %v", r)
+	}
+	var last interface{}
+	for r := range third {
+		last = r
+	}
+	if last != context.Canceled {
+		t.Errorf("Cancelled command not rejected: %v", last)
+	}
+	for range first {
+	}
+
+	close(order)
+	var executed []int
+	for i := range order {
+		executed = append(executed, i)
+	}
+	if !reflect.DeepEqual(executed, []int{1, 2, 4}) {
+		t.Errorf("Commands executed out of order %v", executed)
+	}
+
+	close(instanceCh)
+	wg.Wait()
+}
+
+func TestServiceIdle(t *testing.T) {
+	timeout, err := (&serviceConfig{}).idleTimeout()
+	if err != nil || timeout != defaultIdleTimeout {
+		t.Errorf("Unexpected default idle timeout %v: %v", timeout, err)
+	}
+	timeout, err = (&serviceConfig{IdleTimeout: "0"}).idleTimeout()
+	if err != nil || timeout != 0 {
+		t.Errorf("Unexpected idle timeout %v: %v", timeout, err)
+	}
+	if _, err := (&serviceConfig{IdleTimeout: "-1m"}).idleTimeout(); err == nil {
+		t.Errorf("Negative idle timeout accepted")
+	}
+
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	instanceDir := filepath.Join(ccvmDir, "instances", "dev")
+	if err := os.MkdirAll(instanceDir, 0755); err != nil {
+		t.Fatalf("Unable to create %s: %v", instanceDir, err)
+	}
+
+	s := &ccvmService{
+		ccvmDir:       ccvmDir,
+		instances:     map[string]chan instanceCmd{"dev": nil},
+		guestChannels: make(map[string]struct{}),
+	}
+	if !s.idle() {
+		t.Errorf("Service with stopped instances not idle")
+	}
+
+	recordStatus(instanceDir, true)
+	if s.idle() {
+		t.Errorf("Service with running instances idle")
+	}
+
+	recordStatus(instanceDir, false)
+	s.guestChannels["dev"] = struct{}{}
+	if s.idle() {
+		t.Errorf("Service with connected guest channels idle")
+	}
+}
@@ -0,0 +1,188 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The status of each instance is cached in the status bucket of the state
+// store.  The cache is updated when the instance is started or quit, or when
+// a refresh is requested.
+
+const statusFile = "status.yaml"
+
+type cachedStatus struct {
+	Running      bool      `yaml:"running" json:"running"`
+	SSHReachable bool      `yaml:"ssh_reachable" json:"ssh_reachable"`
+	Checked      time.Time `yaml:"checked" json:"checked"`
+}
+
+// loadStatus returns the cached status of the instance.  A zero status is
+// returned if the instance has never been probed.
+func loadStatus(instanceDir string) types.InstanceStatus {
+	st, err := instanceStore(instanceDir)
+	if err != nil {
+		return types.InstanceStatus{}
+	}
+
+	var cs cachedStatus
+	err = st.view(func(tx *storeTx) error {
+		_, err := tx.get(bucketStatus, filepath.Base(instanceDir), &cs)
+		return err
+	})
+	if err != nil {
+		return types.InstanceStatus{}
+	}
+
+	return types.InstanceStatus{
+		Running:      cs.Running,
+		SSHReachable: cs.SSHReachable,
+		Checked:      cs.Checked,
+	}
+}
+
+func saveStatus(instanceDir string, status types.InstanceStatus) error {
+	st, err := instanceStore(instanceDir)
+	if err != nil {
+		return err
+	}
+
+	err = st.update(func(tx *storeTx) error {
+		return tx.put(bucketStatus, filepath.Base(instanceDir), &cachedStatus{
+			Running:      status.Running,
+			SSHReachable: status.SSHReachable,
+			Checked:      status.Checked,
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "Unable to write instance status")
+	}
+
+	return nil
+}
+
+// recordStatus caches the running state of an instance that has just been
+// booted or quit.  Errors are not fatal as the cache can always be refreshed.
+func recordStatus(instanceDir string, running bool) {
+	err := saveStatus(instanceDir, types.InstanceStatus{
+		Running: running,
+		Checked: time.Now(),
+	})
+	if err != nil {
+		instanceDirLog(instanceDir).Warningf("%v", err)
+	}
+}
+
+// clearStatus discards the cached status of an instance whose state is
+// no longer known, e.g., one that has been asked to shut down.
+func clearStatus(instanceDir string) {
+	st, err := instanceStore(instanceDir)
+	if err != nil {
+		return
+	}
+
+	_ = st.update(func(tx *storeTx) error {
+		return tx.delete(bucketStatus, filepath.Base(instanceDir))
+	})
+}
+
+// migrateStatusFiles moves the status cached in the instance directories
+// by older versions of ccloudvm to the state store.
+func migrateStatusFiles(tx *storeTx, ccvmDir string) error {
+	dirs, err := ioutil.ReadDir(filepath.Join(ccvmDir, "instances"))
+	if err != nil {
+		return nil
+	}
+
+	for _, d := range dirs {
+		statusPath := filepath.Join(ccvmDir, "instances", d.Name(), statusFile)
+		data, err := ioutil.ReadFile(statusPath)
+		if err != nil {
+			continue
+		}
+
+		var cs cachedStatus
+		if err := yaml.Unmarshal(data, &cs); err == nil {
+			if err := tx.put(bucketStatus, d.Name(), &cs); err != nil {
+				return err
+			}
+		}
+		_ = os.Remove(statusPath)
+	}
+
+	return nil
+}
+
+// sshReachable returns true if an SSH server responds on port of hostIP.
+func sshReachable(ctx context.Context, hostIP net.IP, port int) bool {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", hostIP, port))
+	if err != nil {
+		return false
+	}
+	defer func() { _ = conn.Close() }()
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+	return bufio.NewScanner(conn).Scan()
+}
+
+// sshEndpoint returns the address and the port on which the SSH server of
+// the instance in can be reached.  Guests connected to a tap device have no
+// port mappings and are reached on their own address.
+func sshEndpoint(in *types.VMSpec) (net.IP, int, error) {
+	if usesTap(in) {
+		return tapGuestIP(in), 22, nil
+	}
+
+	port, err := in.SSHPort()
+	if err != nil {
+		return nil, 0, err
+	}
+	return in.HostIP, port, nil
+}
+
+// probeStatus determines the current status of an instance by querying its
+// hypervisor and by checking whether its SSH server can be reached.
+func probeStatus(ctx context.Context, hv hypervisor, instanceDir string, in *types.VMSpec) types.InstanceStatus {
+	status := types.InstanceStatus{
+		Running: hv.running(ctx, instanceDir),
+	}
+
+	if status.Running {
+		if host, port, err := sshEndpoint(in); err == nil {
+			status.SSHReachable = sshReachable(ctx, host, port)
+		}
+	}
+
+	status.Checked = time.Now()
+	return status
+}
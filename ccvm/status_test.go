@@ -0,0 +1,60 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestStatusCache(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+	instanceDir := filepath.Join(ccvmDir, "instances", "dev")
+
+	if status := loadStatus(instanceDir); !status.Checked.IsZero() {
+		t.Errorf("Expected zero status for unprobed instance, got %+v", status)
+	}
+
+	saved := types.InstanceStatus{
+		Running:      true,
+		SSHReachable: true,
+		Checked:      time.Now().Truncate(time.Second),
+	}
+	if err := saveStatus(instanceDir, saved); err != nil {
+		t.Fatalf("Failed to save status: %v", err)
+	}
+
+	loaded := loadStatus(instanceDir)
+	if loaded.Running != saved.Running || loaded.SSHReachable != saved.SSHReachable ||
+		!loaded.Checked.Equal(saved.Checked) {
+		t.Errorf("Loaded status %+v does not match saved status %+v", loaded, saved)
+	}
+
+	clearStatus(instanceDir)
+	if status := loadStatus(instanceDir); !status.Checked.IsZero() {
+		t.Errorf("Expected zero status after clear, got %+v", status)
+	}
+}
@@ -0,0 +1,244 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The state of the daemon is kept in state.db, a bbolt database in the
+// ccloudvm directory, whose schema version is stored in the meta bucket.
+
+const (
+	stateStoreFile = "state.db"
+
+	bucketMeta      = "meta"
+	bucketInstances = "instances"
+	bucketSpecs     = "specs"
+	bucketStatus    = "status"
+	bucketDaemon    = "daemon"
+
+	schemaVersionKey = "schema_version"
+)
+
+// storeOpenTimeout is the time spent waiting for another process that has
+// opened the store to close it.
+const storeOpenTimeout = 5 * time.Second
+
+// storeMigrations contains the functions that upgrade the store from one
+// schema version to the next.  storeMigrations[i] upgrades the store from
+// version i to version i+1.  The version of a store is the number of
+// migrations that have been applied to it.
+var storeMigrations = []func(tx *storeTx, ccvmDir string) error{
+	migrateStatusFiles,
+	migrateWorkloadFiles,
+}
+
+type store struct {
+	db      *bolt.DB
+	ccvmDir string
+}
+
+// storeTx is a transaction on a store.
+type storeTx struct {
+	tx *bolt.Tx
+}
+
+var stores = struct {
+	sync.Mutex
+	m map[string]*store
+}{m: make(map[string]*store)}
+
+// stateStore returns the store of the ccloudvm directory ccvmDir, opening
+// it the first time it is needed.
+func stateStore(ccvmDir string) (*store, error) {
+	stores.Lock()
+	defer stores.Unlock()
+
+	if st, ok := stores.m[ccvmDir]; ok {
+		return st, nil
+	}
+
+	st, err := openStore(ccvmDir)
+	if err != nil {
+		return nil, err
+	}
+	stores.m[ccvmDir] = st
+	return st, nil
+}
+
+// instanceStore returns the store of the ccloudvm directory that contains
+// the instance directory instanceDir.
+func instanceStore(instanceDir string) (*store, error) {
+	return stateStore(filepath.Dir(filepath.Dir(instanceDir)))
+}
+
+func openStore(ccvmDir string) (*store, error) {
+	p := filepath.Join(ccvmDir, stateStoreFile)
+	db, err := bolt.Open(p, 0600, &bolt.Options{Timeout: storeOpenTimeout})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to open %s", p)
+	}
+	st := &store{db: db, ccvmDir: ccvmDir}
+
+	version, err := st.version()
+	if err == nil && version > len(storeMigrations) {
+		err = errors.Errorf("%s has version %d, this version of ccloudvm supports up to version %d",
+			p, version, len(storeMigrations))
+	}
+	if err == nil && version < len(storeMigrations) {
+		err = st.migrate(version)
+	}
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return st, nil
+}
+
+func (st *store) close() error {
+	return st.db.Close()
+}
+
+// version returns the schema version of the store.
+func (st *store) version() (int, error) {
+	var version int
+	err := st.view(func(tx *storeTx) error {
+		var err error
+		version, err = tx.version()
+		return err
+	})
+	return version, err
+}
+
+func (tx *storeTx) version() (int, error) {
+	b := tx.tx.Bucket([]byte(bucketMeta))
+	if b == nil {
+		return 0, nil
+	}
+	data := b.Get([]byte(schemaVersionKey))
+	if data == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, errors.Wrap(err, "Invalid schema version")
+	}
+	return version, nil
+}
+
+func (tx *storeTx) setVersion(version int) error {
+	b, err := tx.tx.CreateBucketIfNotExists([]byte(bucketMeta))
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(schemaVersionKey), []byte(strconv.Itoa(version)))
+}
+
+// migrate upgrades the store from version from to the current schema
+// version, in a single transaction.
+func (st *store) migrate(from int) error {
+	return st.update(func(tx *storeTx) error {
+		for v := from; v < len(storeMigrations); v++ {
+			if err := storeMigrations[v](tx, st.ccvmDir); err != nil {
+				return errors.Wrapf(err, "Unable to migrate %s to version %d",
+					st.db.Path(), v+1)
+			}
+		}
+		return tx.setVersion(len(storeMigrations))
+	})
+}
+
+// view runs fn in a read only transaction.
+func (st *store) view(fn func(tx *storeTx) error) error {
+	return st.db.View(func(tx *bolt.Tx) error {
+		return fn(&storeTx{tx: tx})
+	})
+}
+
+// update runs fn in a read write transaction.  The changes made by fn are
+// committed if it succeeds and discarded otherwise.
+func (st *store) update(fn func(tx *storeTx) error) error {
+	return st.db.Update(func(tx *bolt.Tx) error {
+		return fn(&storeTx{tx: tx})
+	})
+}
+
+// get decodes the value of key in bucket into v.  It returns false if there
+// is no such value.
+func (tx *storeTx) get(bucket, key string, v interface{}) (bool, error) {
+	b := tx.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return false, nil
+	}
+	data := b.Get([]byte(key))
+	if data == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, errors.Wrapf(err, "Invalid %s record %s", bucket, key)
+	}
+	return true, nil
+}
+
+func (tx *storeTx) put(bucket, key string, v interface{}) error {
+	if !tx.tx.Writable() {
+		return errors.New("Store transaction is read only")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to marshal %s record %s", bucket, key)
+	}
+	b, err := tx.tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return errors.Wrapf(err, "Unable to create bucket %s", bucket)
+	}
+	return b.Put([]byte(key), data)
+}
+
+func (tx *storeTx) delete(bucket, key string) error {
+	if !tx.tx.Writable() {
+		return errors.New("Store transaction is read only")
+	}
+	b := tx.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	return b.Delete([]byte(key))
+}
+
+// keys returns the keys of bucket in order.
+func (tx *storeTx) keys(bucket string) []string {
+	var keys []string
+	b := tx.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return keys
+	}
+	_ = b.ForEach(func(k, v []byte) error {
+		keys = append(keys, string(k))
+		return nil
+	})
+	return keys
+}
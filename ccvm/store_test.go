@@ -0,0 +1,150 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStoreTransactions(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	st, err := openStore(ccvmDir)
+	if err != nil {
+		t.Fatalf("Unable to open store: %v", err)
+	}
+
+	rec := instanceRecord{HostIP: "127.0.0.2", Network: "default"}
+	err = st.update(func(tx *storeTx) error {
+		return tx.put(bucketInstances, "dev", &rec)
+	})
+	if err != nil {
+		t.Fatalf("Unable to update store: %v", err)
+	}
+
+	// A failed transaction leaves the store untouched.
+	err = st.update(func(tx *storeTx) error {
+		_ = tx.delete(bucketInstances, "dev")
+		_ = tx.put(bucketInstances, "test", &rec)
+		return errors.New("Failed")
+	})
+	if err == nil {
+		t.Errorf("Failed transaction committed")
+	}
+
+	err = st.view(func(tx *storeTx) error {
+		return tx.put(bucketInstances, "test", &rec)
+	})
+	if err == nil {
+		t.Errorf("Read only transaction modified store")
+	}
+
+	if err := st.close(); err != nil {
+		t.Fatalf("Unable to close store: %v", err)
+	}
+	st, err = openStore(ccvmDir)
+	if err != nil {
+		t.Fatalf("Unable to reopen store: %v", err)
+	}
+	var keys []string
+	var loaded instanceRecord
+	_ = st.view(func(tx *storeTx) error {
+		keys = tx.keys(bucketInstances)
+		_, err = tx.get(bucketInstances, "dev", &loaded)
+		return nil
+	})
+	if !reflect.DeepEqual(keys, []string{"dev"}) || err != nil || loaded != rec {
+		t.Errorf("Unexpected store contents %v %+v: %v", keys, loaded, err)
+	}
+	if version, err := st.version(); err != nil || version != len(storeMigrations) {
+		t.Errorf("Unexpected store version %d: %v", version, err)
+	}
+
+	err = st.update(func(tx *storeTx) error {
+		return tx.setVersion(1000)
+	})
+	if err != nil {
+		t.Fatalf("Unable to set store version: %v", err)
+	}
+	_ = st.close()
+	if st, err := openStore(ccvmDir); err == nil {
+		_ = st.close()
+		t.Errorf("Store from a newer version opened")
+	}
+}
+
+func TestStoreMigrateStatus(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	instanceDir := filepath.Join(ccvmDir, "instances", "dev")
+	if err := os.MkdirAll(instanceDir, 0755); err != nil {
+		t.Fatalf("Unable to create %s: %v", instanceDir, err)
+	}
+	statusPath := filepath.Join(instanceDir, statusFile)
+	err = ioutil.WriteFile(statusPath, []byte("running: true\nssh_reachable: true\n"), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write status: %v", err)
+	}
+
+	if status := loadStatus(instanceDir); !status.Running || !status.SSHReachable {
+		t.Errorf("Status not migrated %+v", status)
+	}
+	if _, err := os.Stat(statusPath); !os.IsNotExist(err) {
+		t.Errorf("Status file not removed")
+	}
+}
+
+func TestStoreMigrateWorkload(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	instanceDir := filepath.Join(ccvmDir, "instances", "dev")
+	if err := os.MkdirAll(instanceDir, 0755); err != nil {
+		t.Fatalf("Unable to create %s: %v", instanceDir, err)
+	}
+	statePath := filepath.Join(instanceDir, workloadStateFile)
+	if err := ioutil.WriteFile(statePath, []byte(sampleWorkload), 0600); err != nil {
+		t.Fatalf("Unable to write state: %v", err)
+	}
+
+	wkld, err := restoreWorkload(&workspace{ccvmDir: ccvmDir, instanceDir: instanceDir})
+	if err != nil {
+		t.Fatalf("Workload not migrated: %v", err)
+	}
+	if !reflect.DeepEqual(mockVMSpec, wkld.spec.VM) {
+		t.Errorf("Expected %+v got %+v", mockVMSpec, wkld.spec.VM)
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("State file not removed")
+	}
+}
@@ -0,0 +1,474 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Suspending an instance saves the state of its qemu VM to a file in the
+// instance directory and quits the VM.  Starting the instance boots the VM
+// from the saved state.  Commands that modify the disks are refused while
+// an instance is suspended.
+
+const (
+	suspendFile      = "suspend.yaml"
+	suspendStateFile = "vmstate"
+	bootedSpecFile   = "booted.yaml"
+
+	// maxMigrationBandwidth lifts the limit on the bandwidth of the
+	// migrations saving the state of VMs, which are throttled by
+	// default as they are meant to run alongside the VM.
+	maxMigrationBandwidth = 1 << 40
+)
+
+// suspendPollInterval is the interval at which the progress of the saving,
+// or loading, of the state of a VM is checked.
+var suspendPollInterval = 500 * time.Millisecond
+
+var (
+	errNotRunning   = errors.New("The instance is not running")
+	errNotSuspended = errors.New("The instance is not suspended")
+)
+
+type suspendRecord struct {
+	Time time.Time    `yaml:"time"`
+	VM   types.VMSpec `yaml:"vm"`
+}
+
+func loadVMSpecFile(p string, v interface{}) bool {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return false
+	}
+	if err := yaml.Unmarshal(data, v); err != nil {
+		logWarningf("Ignoring invalid %s: %v", p, err)
+		return false
+	}
+	return true
+}
+
+func saveVMSpecFile(p string, v interface{}) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to marshal %s", path.Base(p))
+	}
+	return writeFileAtomic(p, data)
+}
+
+// loadSuspension returns the record of the suspension of the instance whose
+// directory is instanceDir, or nil if the instance is not suspended.
+func loadSuspension(instanceDir string) *suspendRecord {
+	var rec suspendRecord
+	if !loadVMSpecFile(path.Join(instanceDir, suspendFile), &rec) {
+		return nil
+	}
+	if _, err := os.Stat(path.Join(instanceDir, suspendStateFile)); err != nil {
+		return nil
+	}
+	return &rec
+}
+
+// discardSuspension removes the saved state of a suspended instance.
+func discardSuspension(instanceDir string) {
+	_ = os.Remove(path.Join(instanceDir, suspendFile))
+	_ = os.Remove(path.Join(instanceDir, suspendStateFile))
+}
+
+// checkNotSuspended fails if the instance whose directory is instanceDir is
+// suspended.
+func checkNotSuspended(instanceDir string) error {
+	if loadSuspension(instanceDir) == nil {
+		return nil
+	}
+	return errors.New("The instance is suspended.  Resume it, or discard its saved state with ccloudvm resume --discard, first")
+}
+
+// recordBootedSpec records the specification in with which the VM of an
+// instance has been booted.
+func recordBootedSpec(instanceDir string, in *types.VMSpec) {
+	if err := saveVMSpecFile(path.Join(instanceDir, bootedSpecFile), in); err != nil {
+		instanceDirLog(instanceDir).Warningf("%v", err)
+	}
+}
+
+// devicesChanged returns true if the devices of a VM booted with booted
+// differ from those described by cur.
+func devicesChanged(booted, cur *types.VMSpec) bool {
+	return booted.CPUs != cur.CPUs || booted.MemMiB != cur.MemMiB ||
+		!reflect.DeepEqual(booted.Mounts, cur.Mounts) ||
+		!reflect.DeepEqual(booted.Disks, cur.Disks)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// incomingParam returns the parameter of qemu's -incoming option that loads
+// the state of a VM from stateFile.
+func incomingParam(stateFile string) string {
+	return "exec:cat " + shellQuote(stateFile)
+}
+
+// qmpBackground executes a QMP command on behalf of an operation that has
+// been cancelled.
+func qmpBackground(instanceDir, command string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, _ = qmpExecute(ctx, instanceDir, command, nil)
+}
+
+// qmpSaveState pauses the VM of the instance whose directory is instanceDir
+// and saves its state to target.  The VM is left paused if the state is
+// saved and resumed otherwise.
+func qmpSaveState(ctx context.Context, instanceDir, target string) error {
+	_, _ = qmpExecute(ctx, instanceDir, "migrate-set-parameters", map[string]interface{}{
+		"max-bandwidth": int64(maxMigrationBandwidth),
+	})
+
+	if _, err := qmpExecute(ctx, instanceDir, "stop", nil); err != nil {
+		return err
+	}
+
+	_, err := qmpExecute(ctx, instanceDir, "migrate", map[string]interface{}{
+		"uri": "exec:cat > " + shellQuote(target),
+	})
+	if err != nil {
+		qmpBackground(instanceDir, "cont")
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			qmpBackground(instanceDir, "migrate_cancel")
+			qmpBackground(instanceDir, "cont")
+			return ctx.Err()
+		case <-time.After(suspendPollInterval):
+		}
+
+		ret, err := qmpExecute(ctx, instanceDir, "query-migrate", nil)
+		if err != nil {
+			qmpBackground(instanceDir, "cont")
+			return err
+		}
+		var st struct {
+			Status    string `json:"status"`
+			ErrorDesc string `json:"error-desc"`
+		}
+		if err := json.Unmarshal(ret, &st); err != nil {
+			qmpBackground(instanceDir, "cont")
+			return errors.Wrap(err, "Unable to parse migration status")
+		}
+
+		switch st.Status {
+		case "completed":
+			return nil
+		case "failed", "cancelled":
+			qmpBackground(instanceDir, "cont")
+			return errors.Errorf("Unable to save VM state: %s", st.ErrorDesc)
+		}
+	}
+}
+
+// waitForIncoming waits until the VM of the instance whose directory is
+// instanceDir has loaded its saved state and is running.
+func waitForIncoming(ctx context.Context, instanceDir string) error {
+	for {
+		ret, err := qmpExecute(ctx, instanceDir, "query-status", nil)
+		if err != nil {
+			return errors.Wrap(err, "The VM exited while loading its saved state")
+		}
+		var st struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(ret, &st); err != nil {
+			return errors.Wrap(err, "Unable to parse VM status")
+		}
+
+		switch st.Status {
+		case "running":
+			return nil
+		case "inmigrate", "prelaunch":
+		default:
+			return errors.Errorf("Unexpected VM status %s while loading its saved state", st.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(suspendPollInterval):
+		}
+	}
+}
+
+func (c ccvmBackend) suspend(ctx context.Context, name string) error {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load instance state")
+	}
+	in := &wkld.spec.VM
+
+	hv, err := getHypervisor(ws, c.cfg, &wkld.spec)
+	if err != nil {
+		return err
+	}
+	if !hv.running(ctx, ws.instanceDir) {
+		return errNotRunning
+	}
+
+	if in.Hypervisor != "" && in.Hypervisor != hypervisorQemu {
+		return errors.Errorf("%s instances cannot be suspended", in.Hypervisor)
+	}
+	if len(in.GPUs) > 0 || len(in.VGPUs) > 0 {
+		return errors.New("Instances with assigned GPUs cannot be suspended")
+	}
+	var booted types.VMSpec
+	if loadVMSpecFile(path.Join(ws.instanceDir, bootedSpecFile), &booted) &&
+		devicesChanged(&booted, in) {
+		return errors.New("The resources of the instance have changed since it was booted.  Restart it before suspending it")
+	}
+
+	stateFile := path.Join(ws.instanceDir, suspendStateFile)
+	tmp := stateFile + ".part"
+	_ = os.Remove(tmp)
+
+	instanceLog(name).Infof("Saving VM state")
+	if err := qmpSaveState(ctx, ws.instanceDir, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrapf(err, "Unable to suspend %s", name)
+	}
+
+	err = os.Rename(tmp, stateFile)
+	if err == nil {
+		err = saveVMSpecFile(path.Join(ws.instanceDir, suspendFile), &suspendRecord{
+			Time: time.Now(),
+			VM:   *in,
+		})
+	}
+	if err != nil {
+		discardSuspension(ws.instanceDir)
+		_ = os.Remove(tmp)
+		qmpBackground(ws.instanceDir, "cont")
+		return errors.Wrapf(err, "Unable to suspend %s", name)
+	}
+
+	// The VM exits as expected once it is no longer recorded as running.
+	recordStatus(ws.instanceDir, false)
+	if err := hv.quit(ctx, ws.instanceDir); err != nil {
+		instanceLog(name).Warningf("Unable to quit suspended VM: %v", err)
+	}
+
+	instanceLog(name).Infof("VM Suspended")
+	return nil
+}
+
+// resumeVM boots the VM of the suspended instance name from its saved
+// state.
+func (c ccvmBackend) resumeVM(ctx context.Context, ws *workspace, name string, wkld *workload,
+	rec *suspendRecord) error {
+	in := &rec.VM
+
+	var err error
+	ws.network, err = instanceNetwork(ws.ccvmDir, in)
+	if err != nil {
+		return err
+	}
+
+	hv, err := getHypervisor(ws, c.cfg, &wkld.spec)
+	if err != nil {
+		return err
+	}
+
+	if err := unlockDisk(ctx, ws, in); err != nil {
+		return err
+	}
+	if err := checkDataDisks(ws.instanceDir, in.Disks); err != nil {
+		return err
+	}
+
+	instanceLog(name).Infof("Resuming VM suspended at %v", rec.Time)
+
+	bootSpec, err := prepareQuotaMounts(ctx, ws.instanceDir, in)
+	if err != nil {
+		return err
+	}
+
+	ws.incoming = path.Join(ws.instanceDir, suspendStateFile)
+	if err := hv.boot(ctx, ws, name, bootSpec); err != nil {
+		return err
+	}
+	if err := waitForIncoming(ctx, ws.instanceDir); err != nil {
+		_ = hv.quit(ctx, ws.instanceDir)
+		return errors.Wrapf(err, "Unable to resume %s", name)
+	}
+
+	discardSuspension(ws.instanceDir)
+	recordStatus(ws.instanceDir, true)
+	recordBootedSpec(ws.instanceDir, in)
+	rememberDiskKey(ws)
+	resolveCrash(ws.instanceDir)
+	resolvePressure(ws.instanceDir)
+
+	instanceLog(name).Infof("VM Resumed")
+
+	return nil
+}
+
+func (c ccvmBackend) resumeFromDisk(ctx context.Context, name string, discard bool) error {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if loadSuspension(ws.instanceDir) == nil {
+		return errNotSuspended
+	}
+
+	if discard {
+		discardSuspension(ws.instanceDir)
+		instanceLog(name).Infof("Saved VM state discarded")
+		return nil
+	}
+
+	return c.start(ctx, name, &types.VMSpec{}, false)
+}
+
+// suspendTargets returns the names of the instances targeted by a suspend
+// or resume request, sorted.
+func (s *ccvmService) suspendTargets(name string, all bool) ([]string, error) {
+	if !all {
+		name, err := s.getInstance(name)
+		if err != nil {
+			return nil, err
+		}
+		return []string{name}, nil
+	}
+
+	names := make([]string, 0, len(s.instances))
+	for name := range s.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// runOnInstances runs op in the loops of the instances names and sends the
+// names of the instances on which it succeeded to resultCh.  When all the
+// instances are targeted, those on which op fails with skip are ignored.
+func (s *ccvmService) runOnInstances(ctx context.Context, names []string, all bool, skip error,
+	op func(name string) error, done func(name string, kickCh chan struct{}), resultCh chan interface{}) {
+	instanceResults := make([]chan interface{}, len(names))
+	for i := range names {
+		instanceName := names[i]
+		instanceResult := make(chan interface{}, 1)
+		instanceResults[i] = instanceResult
+		kickCh := s.monitors[instanceName]
+
+		s.instances[instanceName] <- instanceCmd{
+			cmdType:  instanceCmdOther,
+			resultCh: instanceResult,
+			ctx:      ctx,
+			fn: func() error {
+				err := op(instanceName)
+				if err == nil {
+					done(instanceName, kickCh)
+				}
+				instanceResult <- err
+				return nil
+			},
+		}
+	}
+
+	go func() {
+		var failures []string
+		succeeded := []string{}
+		for i, instanceResult := range instanceResults {
+			err, _ := (<-instanceResult).(error)
+			switch {
+			case err == nil:
+				succeeded = append(succeeded, names[i])
+			case all && err == skip:
+			case all:
+				failures = append(failures, fmt.Sprintf("%s: %v", names[i], err))
+			default:
+				failures = append(failures, err.Error())
+			}
+		}
+		if len(failures) > 0 {
+			resultCh <- errors.New(strings.Join(failures, ", "))
+		} else {
+			resultCh <- succeeded
+		}
+		close(resultCh)
+	}()
+}
+
+func (s *ccvmService) suspend(ctx context.Context, args *types.SuspendArgs, resultCh chan interface{}) {
+	names, err := s.suspendTargets(args.Name, args.All)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+
+	s.runOnInstances(ctx, names, args.All, errNotRunning,
+		func(name string) error {
+			return s.b.suspend(ctx, name)
+		},
+		func(name string, kickCh chan struct{}) {
+			s.events.publish(name, types.EventStopped)
+		}, resultCh)
+}
+
+func (s *ccvmService) resumeFromDisk(ctx context.Context, args *types.ResumeArgs, resultCh chan interface{}) {
+	names, err := s.suspendTargets(args.Name, args.All)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+
+	s.runOnInstances(ctx, names, args.All, errNotSuspended,
+		func(name string) error {
+			return s.b.resumeFromDisk(ctx, name, args.Discard)
+		},
+		func(name string, kickCh chan struct{}) {
+			if !args.Discard {
+				s.events.publish(name, types.EventStarted)
+				kickMonitor(kickCh)
+			}
+		}, resultCh)
+}
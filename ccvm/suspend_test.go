@@ -0,0 +1,115 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestIncomingParam(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/home/user/.ccloudvm/instances/dev/vmstate", "exec:cat '/home/user/.ccloudvm/instances/dev/vmstate'"},
+		{"/home/o'neil/vmstate", `exec:cat '/home/o'\''neil/vmstate'`},
+	}
+	for _, test := range tests {
+		if p := incomingParam(test.path); p != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, p)
+		}
+	}
+}
+
+func TestSuspension(t *testing.T) {
+	instanceDir, err := ioutil.TempDir("", "ccloudvm-suspend-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(instanceDir) }()
+
+	if loadSuspension(instanceDir) != nil {
+		t.Errorf("Instance without saved state reported as suspended")
+	}
+
+	vm := types.VMSpec{MemMiB: 2048, CPUs: 2}
+	err = saveVMSpecFile(path.Join(instanceDir, suspendFile), &suspendRecord{
+		Time: time.Now(),
+		VM:   vm,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loadSuspension(instanceDir) != nil {
+		t.Errorf("Instance whose state was not saved reported as suspended")
+	}
+
+	stateFile := path.Join(instanceDir, suspendStateFile)
+	if err := ioutil.WriteFile(stateFile, []byte("QEVM"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rec := loadSuspension(instanceDir)
+	if rec == nil {
+		t.Fatalf("Suspended instance not reported as suspended")
+	}
+	if rec.VM.MemMiB != vm.MemMiB || rec.VM.CPUs != vm.CPUs {
+		t.Errorf("Unexpected VM spec %+v", rec.VM)
+	}
+	if checkNotSuspended(instanceDir) == nil {
+		t.Errorf("checkNotSuspended succeeded for a suspended instance")
+	}
+
+	discardSuspension(instanceDir)
+	if _, err := os.Stat(stateFile); err == nil {
+		t.Errorf("Saved state not discarded")
+	}
+	if err := checkNotSuspended(instanceDir); err != nil {
+		t.Errorf("checkNotSuspended failed once the state was discarded: %v", err)
+	}
+}
+
+func TestDevicesChanged(t *testing.T) {
+	booted := types.VMSpec{
+		MemMiB: 2048,
+		CPUs:   2,
+		Disks:  []types.Disk{{Name: "data", SizeGiB: 10}},
+	}
+
+	cur := booted
+	cur.PortMappings = []types.PortMapping{{Host: 8080, Guest: 80}}
+	if devicesChanged(&booted, &cur) {
+		t.Errorf("Port mappings reported as a change of devices")
+	}
+
+	cur = booted
+	cur.CPUs = 4
+	if !devicesChanged(&booted, &cur) {
+		t.Errorf("Hot-plugged CPUs not detected")
+	}
+
+	cur = booted
+	cur.Disks = nil
+	if !devicesChanged(&booted, &cur) {
+		t.Errorf("Detached disk not detected")
+	}
+}
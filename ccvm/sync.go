@@ -0,0 +1,456 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The syncs of an instance are mirrored into its guest with rsync over SSH
+// when its VM boots, whenever a change is detected and every syncInterval.
+
+const (
+	syncFile          = "sync.yaml"
+	syncInterval      = time.Minute
+	syncRetryInterval = 5 * time.Second
+	syncSettleTime    = 300 * time.Millisecond
+	syncPollInterval  = 2 * time.Second
+)
+
+type syncRecord struct {
+	Paused bool `yaml:"paused"`
+}
+
+func loadSyncRecord(instanceDir string) syncRecord {
+	var sr syncRecord
+
+	data, err := ioutil.ReadFile(path.Join(instanceDir, syncFile))
+	if err != nil {
+		return sr
+	}
+	_ = yaml.Unmarshal(data, &sr)
+
+	return sr
+}
+
+func saveSyncRecord(instanceDir string, sr *syncRecord) error {
+	data, err := yaml.Marshal(sr)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal sync record")
+	}
+
+	err = ioutil.WriteFile(path.Join(instanceDir, syncFile), data, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to write sync record")
+	}
+
+	return nil
+}
+
+// syncState is the state of the syncs of an instance whose VM is running.
+// wakeCh is used to ask them to mirror the directories straight away,
+// e.g., after they have been resumed.
+type syncState struct {
+	sync.Mutex
+	status []types.SyncStatus
+	wakeCh chan struct{}
+}
+
+var syncStates = struct {
+	sync.Mutex
+	m map[string]*syncState
+}{m: make(map[string]*syncState)}
+
+func registerSyncs(instanceDir string, syncs []types.Sync) *syncState {
+	st := &syncState{
+		status: make([]types.SyncStatus, len(syncs)),
+		wakeCh: make(chan struct{}, 1),
+	}
+	for i, s := range syncs {
+		st.status[i] = types.SyncStatus{
+			Source:  s.Source,
+			Target:  s.Target,
+			Pending: true,
+		}
+	}
+
+	syncStates.Lock()
+	syncStates.m[instanceDir] = st
+	syncStates.Unlock()
+	return st
+}
+
+func unregisterSyncs(instanceDir string, st *syncState) {
+	syncStates.Lock()
+	if syncStates.m[instanceDir] == st {
+		delete(syncStates.m, instanceDir)
+	}
+	syncStates.Unlock()
+}
+
+func lookupSyncs(instanceDir string) *syncState {
+	syncStates.Lock()
+	defer syncStates.Unlock()
+	return syncStates.m[instanceDir]
+}
+
+func (st *syncState) changed() {
+	st.Lock()
+	for i := range st.status {
+		st.status[i].Pending = true
+	}
+	st.Unlock()
+}
+
+// synced records the outcome of an attempt to mirror the ith sync and
+// returns true if it failed for a new reason, so that failures are only
+// logged once.
+func (st *syncState) synced(i int, err error) bool {
+	st.Lock()
+	defer st.Unlock()
+	if err != nil {
+		failed := st.status[i].Error != err.Error()
+		st.status[i].Error = err.Error()
+		return failed
+	}
+	st.status[i].Error = ""
+	st.status[i].Pending = false
+	st.status[i].LastSync = time.Now()
+	return false
+}
+
+func (st *syncState) wake() {
+	select {
+	case st.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// rsyncArgs returns the arguments of the rsync command that mirrors the
+// source of s into its target in the instance described by details.
+func rsyncArgs(details *types.InstanceDetails, s *types.Sync) []string {
+	opts := sshOptions(details)
+	quoted := make([]string, len(opts))
+	for i := range opts {
+		quoted[i] = shellQuote(opts[i])
+	}
+
+	target := path.Clean(s.Target)
+	args := []string{
+		"-az", "--delete",
+		"-e", "ssh " + strings.Join(quoted, " "),
+		"--rsync-path", "mkdir -p " + shellQuote(target) + " && rsync",
+	}
+	for _, e := range s.Exclude {
+		args = append(args, "--exclude", e)
+	}
+	return append(args, filepath.Clean(s.Source)+"/",
+		details.SSH.Host.String()+":"+target+"/")
+}
+
+func runRsync(ctx context.Context, details *types.InstanceDetails, s *types.Sync) error {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "rsync", rsyncArgs(details, s)...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		if out := strings.TrimSpace(output.String()); out != "" {
+			return errors.Errorf("rsync failed: %v: %s", err, out)
+		}
+		return errors.Wrap(err, "rsync failed")
+	}
+	return nil
+}
+
+// treeWatcher reports changes to a set of directory trees.  A value is
+// sent on the events channel when a change has been detected.  Successive
+// changes may be reported by a single value.
+type treeWatcher interface {
+	events() <-chan struct{}
+	close()
+}
+
+// snapshotTrees returns the size and modification time of the files in
+// the trees rooted at roots, indexed by path.
+func snapshotTrees(roots []string) map[string]os.FileInfo {
+	snapshot := make(map[string]os.FileInfo)
+	for _, root := range roots {
+		_ = filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+			if err == nil {
+				snapshot[p] = fi
+			}
+			return nil
+		})
+	}
+	return snapshot
+}
+
+func snapshotsDiffer(a, b map[string]os.FileInfo) bool {
+	if len(a) != len(b) {
+		return true
+	}
+	for p, fa := range a {
+		fb, ok := b[p]
+		if !ok || fa.Size() != fb.Size() || !fa.ModTime().Equal(fb.ModTime()) ||
+			fa.Mode() != fb.Mode() {
+			return true
+		}
+	}
+	return false
+}
+
+// pollWatcher detects changes to directory trees by scanning them every
+// syncPollInterval.
+type pollWatcher struct {
+	eventCh chan struct{}
+	doneCh  chan struct{}
+}
+
+func newPollWatcher(roots []string) *pollWatcher {
+	w := &pollWatcher{
+		eventCh: make(chan struct{}, 1),
+		doneCh:  make(chan struct{}),
+	}
+	go func() {
+		last := snapshotTrees(roots)
+		for {
+			select {
+			case <-w.doneCh:
+				return
+			case <-time.After(syncPollInterval):
+			}
+			cur := snapshotTrees(roots)
+			if snapshotsDiffer(last, cur) {
+				select {
+				case w.eventCh <- struct{}{}:
+				default:
+				}
+			}
+			last = cur
+		}
+	}()
+	return w
+}
+
+func (w *pollWatcher) events() <-chan struct{} {
+	return w.eventCh
+}
+
+func (w *pollWatcher) close() {
+	close(w.doneCh)
+}
+
+// syncFiles mirrors the syncs of the instance name into its guest until
+// ctx is cancelled.
+func (c ccvmBackend) syncFiles(ctx context.Context, name string) error {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load instance state")
+	}
+	syncs := wkld.spec.VM.Syncs
+	if len(syncs) == 0 {
+		return nil
+	}
+
+	roots := make([]string, len(syncs))
+	for i := range syncs {
+		roots[i] = syncs[i].Source
+	}
+	w := newTreeWatcher(roots)
+	defer w.close()
+
+	st := registerSyncs(ws.instanceDir, syncs)
+	defer unregisterSyncs(ws.instanceDir, st)
+
+	for {
+		wait := syncInterval
+		if !loadSyncRecord(ws.instanceDir).Paused {
+			if !c.syncAll(ctx, ws, name, syncs, st) {
+				wait = syncRetryInterval
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-st.wakeCh:
+		case <-time.After(wait):
+		case <-w.events():
+			st.changed()
+			// Let bursts of changes, e.g., a git checkout, settle.
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(syncSettleTime):
+			}
+		}
+	}
+}
+
+// syncAll mirrors all the syncs of an instance and returns true if they
+// were all mirrored successfully.  The sources are checked before each
+// sync, as they may have been replaced since the instance was created, so
+// that a system mode daemon only copies the user's own directories.
+func (c ccvmBackend) syncAll(ctx context.Context, ws *workspace, name string, syncs []types.Sync, st *syncState) bool {
+	details, err := c.sshDetails(ctx, name)
+	if err == nil && !sshReachable(ctx, details.SSH.Host, details.SSH.Port) {
+		err = errors.New("Unable to reach the SSH server of the instance")
+	}
+
+	ok := true
+	for i := range syncs {
+		serr := err
+		if serr == nil {
+			serr = ws.checkUserPath(syncs[i].Source)
+		}
+		if serr == nil {
+			serr = runRsync(ctx, details, &syncs[i])
+		}
+		if st.synced(i, serr) && ctx.Err() == nil {
+			instanceLog(name).Warningf("Unable to sync %s: %v", syncs[i].Source, serr)
+		}
+		ok = ok && serr == nil
+	}
+	return ok
+}
+
+func (c ccvmBackend) syncStatus(ctx context.Context, name string) (*types.SyncStatusResult, error) {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to load instance state")
+	}
+
+	result := &types.SyncStatusResult{
+		Paused: loadSyncRecord(ws.instanceDir).Paused,
+	}
+	if st := lookupSyncs(ws.instanceDir); st != nil {
+		result.Active = !result.Paused
+		st.Lock()
+		result.Syncs = append(result.Syncs, st.status...)
+		st.Unlock()
+		return result, nil
+	}
+
+	for _, s := range wkld.spec.VM.Syncs {
+		result.Syncs = append(result.Syncs, types.SyncStatus{
+			Source:  s.Source,
+			Target:  s.Target,
+			Pending: true,
+		})
+	}
+	return result, nil
+}
+
+// pauseSync pauses, or resumes, the syncs of an instance.  Resumed syncs
+// mirror the directories straight away.
+func (c ccvmBackend) pauseSync(ctx context.Context, name string, paused bool) error {
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load instance state")
+	}
+	if len(wkld.spec.VM.Syncs) == 0 {
+		return errors.Errorf("Instance %s has no syncs", name)
+	}
+
+	if err := saveSyncRecord(ws.instanceDir, &syncRecord{Paused: paused}); err != nil {
+		return err
+	}
+
+	if paused {
+		instanceLog(name).Infof("Syncs paused")
+		return nil
+	}
+
+	instanceLog(name).Infof("Syncs resumed")
+	if st := lookupSyncs(ws.instanceDir); st != nil {
+		st.wake()
+	}
+	return nil
+}
+
+func (s *ccvmService) syncStatus(ctx context.Context, name string, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:  instanceCmdOther,
+		resultCh: resultCh,
+		ctx:      ctx,
+		fn: func() error {
+			res, err := s.b.syncStatus(ctx, instanceName)
+			if err != nil {
+				resultCh <- err
+			} else {
+				resultCh <- res
+			}
+			return nil
+		},
+	}
+}
+
+func (s *ccvmService) pauseSync(ctx context.Context, name string, paused bool, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:  instanceCmdOther,
+		resultCh: resultCh,
+		ctx:      ctx,
+		fn: func() error {
+			resultCh <- s.b.pauseSync(ctx, instanceName, paused)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,183 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestRsyncArgs(t *testing.T) {
+	details := &types.InstanceDetails{
+		VMSpec: types.VMSpec{HostIP: net.IPv4(127, 0, 0, 1)},
+		SSH: types.SSHDetails{
+			KeyPath: "/home/user/.ccloudvm/instances/test/id_rsa",
+			Host:    net.IPv4(127, 0, 0, 1),
+			Port:    10022,
+		},
+	}
+	s := &types.Sync{
+		Source:  "/home/user/src/",
+		Target:  "/home/user/src",
+		Exclude: []string{".git"},
+	}
+
+	args := rsyncArgs(details, s)
+	if len(args) < 2 {
+		t.Fatalf("Unexpected rsync arguments %v", args)
+	}
+	if src := args[len(args)-2]; src != "/home/user/src/" {
+		t.Errorf("Unexpected source %s", src)
+	}
+	if dst := args[len(args)-1]; dst != "127.0.0.1:/home/user/src/" {
+		t.Errorf("Unexpected destination %s", dst)
+	}
+
+	cmdLine := strings.Join(args, " ")
+	for _, want := range []string{"--delete", "--exclude .git", "'-p' '10022'",
+		"mkdir -p '/home/user/src' && rsync"} {
+		if !strings.Contains(cmdLine, want) {
+			t.Errorf("%s not found in %s", want, cmdLine)
+		}
+	}
+}
+
+func TestMergeSyncs(t *testing.T) {
+	in := types.VMSpec{
+		Syncs: []types.Sync{
+			{Source: "/a", Target: "/guest/a"},
+			{Source: "/b", Target: "/guest/b"},
+		},
+	}
+	in.MergeSyncs([]types.Sync{
+		{Source: "/c", Target: "/guest/b"},
+		{Source: "/d", Target: "/guest/d"},
+	})
+
+	if len(in.Syncs) != 3 {
+		t.Fatalf("Expected 3 syncs, found %d", len(in.Syncs))
+	}
+	if in.Syncs[1].Source != "/c" || in.Syncs[2].Source != "/d" {
+		t.Errorf("Unexpected syncs %v", in.Syncs)
+	}
+}
+
+func TestSyncCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sync-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	syncs := []struct {
+		sync types.Sync
+		ok   bool
+	}{
+		{types.Sync{Source: dir, Target: "/home/user/src"}, true},
+		{types.Sync{Source: dir, Target: "src"}, false},
+		{types.Sync{Source: dir, Target: "/"}, false},
+		{types.Sync{Source: filepath.Join(dir, "missing"), Target: "/src"}, false},
+		{types.Sync{Target: "/src"}, false},
+	}
+	for _, s := range syncs {
+		err := s.sync.Check()
+		if s.ok && err != nil {
+			t.Errorf("Unexpected error for %s: %v", s.sync, err)
+		} else if !s.ok && err == nil {
+			t.Errorf("Expected %s to be invalid", s.sync)
+		}
+	}
+}
+
+func TestSnapshotsDiffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sync-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("one"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	before := snapshotTrees([]string{dir})
+	if snapshotsDiffer(before, snapshotTrees([]string{dir})) {
+		t.Errorf("Unchanged tree reported as changed")
+	}
+
+	if err := ioutil.WriteFile(file, []byte("three"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !snapshotsDiffer(before, snapshotTrees([]string{dir})) {
+		t.Errorf("Modified file not detected")
+	}
+
+	before = snapshotTrees([]string{dir})
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if !snapshotsDiffer(before, snapshotTrees([]string{dir})) {
+		t.Errorf("New directory not detected")
+	}
+}
+
+func TestTreeWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sync-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	w := newTreeWatcher([]string{dir})
+	defer w.close()
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-w.events():
+	case <-time.After(2*syncPollInterval + time.Second):
+		t.Errorf("Change not reported")
+	}
+}
+
+func TestSyncRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sync-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if loadSyncRecord(dir).Paused {
+		t.Errorf("Syncs paused without a record")
+	}
+	if err := saveSyncRecord(dir, &syncRecord{Paused: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !loadSyncRecord(dir).Paused {
+		t.Errorf("Paused record not loaded")
+	}
+}
@@ -0,0 +1,141 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build !darwin
+// +build !darwin
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_ATTRIB | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_ONLYDIR
+
+// inotifyWatcher detects changes to directory trees with inotify.
+type inotifyWatcher struct {
+	fd      int
+	paths   map[int32]string
+	eventCh chan struct{}
+	doneCh  chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newTreeWatcher(roots []string) treeWatcher {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return newPollWatcher(roots)
+	}
+
+	w := &inotifyWatcher{
+		fd:      fd,
+		paths:   make(map[int32]string),
+		eventCh: make(chan struct{}, 1),
+		doneCh:  make(chan struct{}),
+	}
+	for _, root := range roots {
+		if err := w.addTree(root); err != nil {
+			// Most likely the limit on the number of watches.
+			_ = syscall.Close(fd)
+			return newPollWatcher(roots)
+		}
+	}
+
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+// addTree watches the directory root and all the directories below it.
+func (w *inotifyWatcher) addTree(root string) error {
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, p, inotifyMask)
+		if err == syscall.ENOENT {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		w.paths[int32(wd)] = p
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) run() {
+	defer w.wg.Done()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err == syscall.EAGAIN || n <= 0 {
+			select {
+			case <-w.doneCh:
+				return
+			case <-time.After(syncSettleTime):
+			}
+			continue
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			// New directories must be watched too.
+			if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				if dir, ok := w.paths[ev.Wd]; ok {
+					_ = w.addTree(filepath.Join(dir, cString(nameBytes)))
+				}
+			}
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.paths, ev.Wd)
+			}
+		}
+
+		select {
+		case w.eventCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+func (w *inotifyWatcher) events() <-chan struct{} {
+	return w.eventCh
+}
+
+func (w *inotifyWatcher) close() {
+	close(w.doneCh)
+	w.wg.Wait()
+	_ = syscall.Close(w.fd)
+}
@@ -0,0 +1,249 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// A template is a sealed instance whose disk has been flattened into an
+// image, described by a workload of the templates directory that boots from
+// this image.
+
+const (
+	templatesDir = "templates"
+
+	// templateShutdownTimeout is the time given to a sealed instance to
+	// shut down before its VM is quit.
+	templateShutdownTimeout = 2 * time.Minute
+)
+
+// templatePath returns the path of the workload of the template name.
+func templatePath(ccvmDir, name string) (string, error) {
+	if !hostnameRegexp.MatchString(name) {
+		return "", errors.Errorf("Invalid template name %s", name)
+	}
+
+	p := path.Join(ccvmDir, templatesDir, name+".yaml")
+	if _, err := os.Stat(p); err != nil {
+		return "", errors.Errorf("Template %s does not exist", name)
+	}
+	return p, nil
+}
+
+// sealCommand returns the command that removes the identity of the instance
+// hostname from its guest.
+func sealCommand(hostname string, mounts []types.Mount) string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("(sudo cloud-init status --wait >/dev/null || true)")
+	_, _ = buf.WriteString(" && sudo cloud-init clean --logs")
+	_, _ = buf.WriteString(" && sudo rm -f /etc/ssh/ssh_host_*")
+	_, _ = buf.WriteString(" && sudo truncate -s 0 /etc/machine-id")
+	_, _ = fmt.Fprintf(&buf, " && sudo sed -i %s /etc/hosts",
+		shellQuote(fmt.Sprintf("/^127\\.0\\.0\\.1 %s$/d", hostname)))
+	for _, m := range mounts {
+		_, _ = fmt.Fprintf(&buf, " && sudo sed -i %s /etc/fstab",
+			shellQuote(fmt.Sprintf("/^%s /d", m.Tag)))
+	}
+	_, _ = buf.WriteString(" && rm -f ~/.ssh/authorized_keys && sync")
+	return buf.String()
+}
+
+// saveTemplate stores the image image as the image of the template name,
+// built from the instance whose workload specification is spec, and writes
+// the workload of the template.
+func saveTemplate(ccvmDir, name, instanceName string, spec *workloadSpec, image string) (*types.TemplateInfo, error) {
+	checksum, err := fileSHA256(image)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []string{path.Join(ccvmDir, templatesDir), path.Join(ccvmDir, localImagesDir)}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, errors.Wrapf(err, "Unable to create directory %s", dir)
+		}
+	}
+
+	dest := path.Join(dirs[1], fmt.Sprintf("template-%s-%s.qcow2", name, checksum[:16]))
+	if err := os.Rename(image, dest); err != nil {
+		return nil, errors.Wrapf(err, "Unable to store image of template %s", name)
+	}
+
+	tspec := exportedSpec(instanceName, spec, checksum)
+	tspec.BaseImageURL = "file://" + dest
+	tspec.BaseImageName = fmt.Sprintf("%s template %s", spec.BaseImageName, name)
+	tspec.WorkloadName = spec.WorkloadName
+	data, err := marshalWorkload(&tspec, exportedUserData)
+	if err != nil {
+		return nil, err
+	}
+	workload := path.Join(dirs[0], name+".yaml")
+	if err := writeFileAtomic(workload, data); err != nil {
+		return nil, err
+	}
+
+	return &types.TemplateInfo{
+		Name:        name,
+		Image:       dest,
+		ImageSHA256: checksum,
+		Workload:    workload,
+	}, nil
+}
+
+func (c ccvmBackend) buildTemplate(ctx context.Context, name string, args *types.BuildTemplateArgs) (*types.TemplateInfo, error) {
+	if !hostnameRegexp.MatchString(args.Template) {
+		return nil, errors.Errorf("Invalid template name %s", args.Template)
+	}
+
+	ws, err := prepareEnv(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if args.Output != "" {
+		if err := ws.checkUserPath(args.Output); err != nil {
+			return nil, err
+		}
+	}
+
+	hv, err := c.instanceHypervisor(ws)
+	if err != nil {
+		return nil, err
+	}
+
+	wkld, err := restoreWorkload(ws)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to load instance state")
+	}
+	if wkld.spec.VM.Encrypt {
+		return nil, errors.New("Templates cannot be built from instances with encrypted disks")
+	}
+	if wkld.spec.windows() {
+		return nil, errors.New("Templates cannot be built from Windows instances")
+	}
+
+	vmImage := path.Join(ws.instanceDir, "image.qcow2")
+	if _, err := os.Stat(vmImage); err != nil {
+		return nil, errors.New("Templates can only be built from instances with qcow2 disks")
+	}
+
+	if !hv.running(ctx, ws.instanceDir) {
+		return nil, errors.New("The instance must be running to be sealed")
+	}
+
+	var stderr bytes.Buffer
+	status, err := c.execCommand(ctx, name, sealCommand(name, wkld.spec.VM.Mounts),
+		ioutil.Discard, &stderr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to seal %s", name)
+	}
+	if status != 0 {
+		return nil, errors.Errorf("Unable to seal %s: %s", name, strings.TrimSpace(stderr.String()))
+	}
+
+	recordStatus(ws.instanceDir, false)
+	method, err := shutdownVM(ctx, hv, ws.instanceDir, templateShutdownTimeout, true,
+		func(ctx context.Context) error {
+			_, err := c.execCommand(ctx, name, "sudo poweroff", ioutil.Discard, ioutil.Discard)
+			return err
+		})
+	if err != nil {
+		clearStatus(ws.instanceDir)
+		return nil, err
+	}
+	instanceLog(name).Infof("VM Stopped (%s)", method)
+
+	// The image is converted in the images directory so that it can be
+	// renamed into place.
+
+	imagesDir := path.Join(ws.ccvmDir, localImagesDir)
+	if err := os.MkdirAll(imagesDir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "Unable to create directory %s", imagesDir)
+	}
+	dir, err := ioutil.TempDir(imagesDir, "template-")
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create temporary directory")
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	image := path.Join(dir, exportedImageFile)
+	out, err := exec.CommandContext(ctx, "qemu-img", "convert", "-c", "-O", "qcow2",
+		vmImage, image).CombinedOutput()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to convert image of %s: %s", name,
+			strings.TrimSpace(string(out)))
+	}
+
+	info, err := saveTemplate(ws.ccvmDir, args.Template, name, &wkld.spec, image)
+	if err != nil {
+		return nil, err
+	}
+
+	// The copy is the artifact of image pipelines, which may move or
+	// delete it, so the image of the template is not shared with it.
+
+	if args.Output != "" {
+		err := copyImage(info.Image, path.Dir(args.Output), path.Base(args.Output))
+		if err != nil {
+			return nil, err
+		}
+		if err := ws.giveToUser(args.Output); err != nil {
+			return nil, err
+		}
+		info.Image = args.Output
+	}
+
+	instanceLog(name).Infof("Template %s built", args.Template)
+	return info, nil
+}
+
+func (s *ccvmService) buildTemplate(ctx context.Context, args *types.BuildTemplateArgs, resultCh chan interface{}) {
+	instanceName, err := s.getInstance(args.Name)
+	if err != nil {
+		resultCh <- err
+		close(resultCh)
+		return
+	}
+	instanceCh := s.instances[instanceName]
+
+	instanceCh <- instanceCmd{
+		cmdType:  instanceCmdOther,
+		resultCh: resultCh,
+		ctx:      ctx,
+		fn: func() error {
+			info, err := s.b.buildTemplate(ctx, instanceName, args)
+			if err != nil {
+				resultCh <- err
+			} else {
+				resultCh <- info
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,116 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestSealCommand(t *testing.T) {
+	cmd := sealCommand("dev", []types.Mount{{Tag: "hostgo", Path: "/home/user/go"}})
+	for _, s := range []string{
+		"sudo cloud-init clean --logs",
+		"sudo rm -f /etc/ssh/ssh_host_*",
+		"sudo truncate -s 0 /etc/machine-id",
+		`sudo sed -i '/^127\.0\.0\.1 dev$/d' /etc/hosts`,
+		"sudo sed -i '/^hostgo /d' /etc/fstab",
+		"rm -f ~/.ssh/authorized_keys",
+	} {
+		if !strings.Contains(cmd, s) {
+			t.Errorf("%s missing from seal command %s", s, cmd)
+		}
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+
+	defer func() {
+		if err := os.RemoveAll(ccvmDir); err != nil {
+			t.Errorf("Failed to remove %s : %v", ccvmDir, err)
+		}
+	}()
+
+	if _, err := templatePath(ccvmDir, "dev"); err == nil {
+		t.Errorf("Expected missing template to be rejected")
+	}
+	if _, err := templatePath(ccvmDir, "../dev"); err == nil {
+		t.Errorf("Expected invalid template name to be rejected")
+	}
+
+	image := path.Join(ccvmDir, "sealed.qcow2")
+	if err := ioutil.WriteFile(image, []byte("qcow2 image"), 0644); err != nil {
+		t.Fatalf("Unable to write image: %v", err)
+	}
+	checksum, err := fileSHA256(image)
+	if err != nil {
+		t.Fatalf("Unable to compute checksum: %v", err)
+	}
+
+	original := workloadSpec{
+		WorkloadName:  "xenial",
+		BaseImageName: "Ubuntu 16.04",
+		Distro:        "ubuntu",
+		VM:            types.VMSpec{MemMiB: 2048, CPUs: 2},
+	}
+	info, err := saveTemplate(ccvmDir, "dev", "build-dev", &original, image)
+	if err != nil {
+		t.Fatalf("Unable to save template: %v", err)
+	}
+	if _, err := os.Stat(image); err == nil {
+		t.Errorf("Image of template not moved")
+	}
+
+	p, err := templatePath(ccvmDir, "dev")
+	if err != nil {
+		t.Fatalf("Unable to find template: %v", err)
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatalf("Unable to read template workload: %v", err)
+	}
+	docs := splitYaml(data)
+	if len(docs) != 2 {
+		t.Fatalf("Expected two documents in template workload, found %d", len(docs))
+	}
+	var spec workloadSpec
+	if err := yaml.Unmarshal(docs[0], &spec); err != nil {
+		t.Fatalf("Unable to parse template workload: %v", err)
+	}
+
+	dest := path.Join(ccvmDir, localImagesDir, "template-dev-"+checksum[:16]+".qcow2")
+	if spec.BaseImageURL != "file://"+dest || spec.BaseImageSHA256 != checksum ||
+		spec.WorkloadName != "xenial" || spec.VM.MemMiB != 2048 {
+		t.Errorf("Unexpected template specification %+v", spec)
+	}
+	if _, err := os.Stat(dest); err != nil {
+		t.Errorf("Image of template not stored: %v", err)
+	}
+	if info.Name != "dev" || info.Image != dest || info.ImageSHA256 != checksum || info.Workload != p {
+		t.Errorf("Unexpected template description %+v", info)
+	}
+}
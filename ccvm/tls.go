@@ -0,0 +1,115 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// The daemon can be accessed from other machines over TCP, secured by mutual
+// TLS.  A system mode daemon serves each client on behalf of the user named
+// by the common name of its certificate.
+
+const (
+	defaultServerCert = "tls/server.pem"
+	defaultServerKey  = "tls/server-key.pem"
+	defaultClientCA   = "tls/ca.pem"
+)
+
+// remoteAccessConfig enables access to the daemon from other machines.
+// Relative paths are relative to the ccloudvm directory.
+type remoteAccessConfig struct {
+	Listen string `yaml:"listen"`
+	Cert   string `yaml:"cert"`
+	Key    string `yaml:"key"`
+	CA     string `yaml:"ca"`
+}
+
+func configPath(ccvmDir, p, def string) string {
+	if p == "" {
+		p = def
+	}
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(ccvmDir, p)
+}
+
+func (c *remoteAccessConfig) tlsConfig(ccvmDir string) (*tls.Config, error) {
+	certPath := configPath(ccvmDir, c.Cert, defaultServerCert)
+	keyPath := configPath(ccvmDir, c.Key, defaultServerKey)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to load server certificate")
+	}
+
+	caPath := configPath(ccvmDir, c.CA, defaultClientCA)
+	caData, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read CA certificate")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, errors.Errorf("No certificates found in %s", caPath)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// listenRemote returns a listener accepting the TLS connections of remote
+// clients.
+func listenRemote(ccvmDir string, c *remoteAccessConfig) (net.Listener, error) {
+	cfg, err := c.tlsConfig(ccvmDir)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to listen on %s", c.Listen)
+	}
+
+	return tls.NewListener(listener, cfg), nil
+}
+
+// certUID returns the uid of the user named by the common name of the
+// certificate presented by a remote client.
+func certUID(state *tls.ConnectionState) (int, error) {
+	if len(state.PeerCertificates) == 0 {
+		return -1, errors.New("No client certificate")
+	}
+
+	name := state.PeerCertificates[0].Subject.CommonName
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, errors.Wrapf(err, "Unable to look up user %s", name)
+	}
+	return strconv.Atoi(u.Uid)
+}
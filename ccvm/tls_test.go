@@ -0,0 +1,60 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestConfigPath(t *testing.T) {
+	tests := []struct {
+		p    string
+		path string
+	}{
+		{"", "/ccvm/tls/server.pem"},
+		{"certs/server.pem", "/ccvm/certs/server.pem"},
+		{"/etc/ccloudvm/server.pem", "/etc/ccloudvm/server.pem"},
+	}
+
+	for _, tt := range tests {
+		if path := configPath("/ccvm", tt.p, defaultServerCert); path != tt.path {
+			t.Errorf("Expected %s for %q, got %s", tt.path, tt.p, path)
+		}
+	}
+}
+
+func TestCertUID(t *testing.T) {
+	if _, err := certUID(&tls.ConnectionState{}); err == nil {
+		t.Errorf("Connection without certificate accepted")
+	}
+
+	state := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{
+			{Subject: pkix.Name{CommonName: "root"}},
+		},
+	}
+	uid, err := certUID(state)
+	if err != nil {
+		t.Fatalf("Unable to identify root: %v", err)
+	}
+	if uid != 0 {
+		t.Errorf("Unexpected uid %d for root", uid)
+	}
+}
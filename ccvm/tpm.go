@@ -0,0 +1,91 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Instances created with a TPM are given a TPM 2.0 device backed by swtpm,
+// whose state is kept in tpmStateDir.
+
+const (
+	swtpmName   = "swtpm"
+	tpmStateDir = "tpm"
+)
+
+func tpmSocket(instanceDir string) string {
+	return path.Join(instanceDir, swtpmName+".socket")
+}
+
+// startSwtpm starts the swtpm process of an instance and waits for it to
+// create the socket to which qemu connects.
+func startSwtpm(instanceDir string) error {
+	if _, err := exec.LookPath("swtpm"); err != nil {
+		return errors.New("swtpm is required to emulate a TPM.  Install the swtpm package")
+	}
+
+	stateDir := path.Join(instanceDir, tpmStateDir)
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return errors.Wrap(err, "Unable to create TPM state directory")
+	}
+
+	killSwtpm(instanceDir)
+	socket := tpmSocket(instanceDir)
+	_ = os.Remove(socket)
+
+	err := launchProcess(instanceDir, swtpmName, "swtpm", "socket", "--tpm2",
+		"--tpmstate", "dir="+stateDir,
+		"--ctrl", "type=unixio,path="+socket,
+		"--terminate")
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			return nil
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	killSwtpm(instanceDir)
+	return errors.New("Timed out waiting for swtpm to start")
+}
+
+// killSwtpm terminates the swtpm process of an instance, if it is running.
+func killSwtpm(instanceDir string) {
+	if processRunning(instanceDir, swtpmName) {
+		_ = killProcess(instanceDir, swtpmName)
+	}
+}
+
+// tpmArgs returns the qemu arguments that connect a tpm-tis device to the
+// swtpm process of an instance.
+func tpmArgs(instanceDir string) []string {
+	return []string{
+		"-chardev", fmt.Sprintf("socket,id=chrtpm,path=%s", tpmSocket(instanceDir)),
+		"-tpmdev", "emulator,id=tpm0,chardev=chrtpm",
+		"-device", "tpm-tis,tpmdev=tpm0",
+	}
+}
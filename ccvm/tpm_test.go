@@ -0,0 +1,54 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestTPM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	args := strings.Join(tpmArgs(dir), " ")
+	expected := "-chardev socket,id=chrtpm,path=" + tpmSocket(dir) +
+		" -tpmdev emulator,id=tpm0,chardev=chrtpm -device tpm-tis,tpmdev=tpm0"
+	if args != expected {
+		t.Errorf("Unexpected arguments %s", args)
+	}
+
+	savedPath := os.Getenv("PATH")
+	defer func() { _ = os.Setenv("PATH", savedPath) }()
+	_ = os.Setenv("PATH", dir)
+	if err := startSwtpm(dir); err == nil || !strings.Contains(err.Error(), "swtpm") {
+		t.Errorf("Expected error when swtpm is not installed: %v", err)
+	}
+
+	in := types.VMSpec{}
+	in.Merge(&types.VMSpec{TPM: true})
+	if !in.TPM {
+		t.Errorf("TPM not inherited from parent")
+	}
+}
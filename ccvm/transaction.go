@@ -0,0 +1,125 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Transactions that have not been claimed by a Result call within
+// claimTimeout are cancelled and forgotten.  Retrieving their result then
+// fails with an expired error for expiredRetention.
+
+const (
+	defaultTransactionTimeout = 6 * time.Hour
+	defaultClaimTimeout       = time.Minute
+	expiredRetention          = time.Hour
+)
+
+// transactionConfig contains the transaction settings of the daemon
+// configuration.  Both are durations, e.g., 2h.  A Timeout of 0 lets
+// transactions run for as long as they need to.
+type transactionConfig struct {
+	Timeout      string `yaml:"timeout"`
+	ClaimTimeout string `yaml:"claim_timeout"`
+}
+
+type transactionPolicy struct {
+	timeout      time.Duration
+	claimTimeout time.Duration
+}
+
+// policy returns the transaction policy described by c, applying defaults
+// for the settings it does not specify.
+func (c *transactionConfig) policy() (transactionPolicy, error) {
+	p := transactionPolicy{
+		timeout:      defaultTransactionTimeout,
+		claimTimeout: defaultClaimTimeout,
+	}
+
+	if c.Timeout != "" {
+		v, err := time.ParseDuration(c.Timeout)
+		if err != nil || v < 0 {
+			return p, errors.Errorf("Invalid transaction timeout %s", c.Timeout)
+		}
+		p.timeout = v
+	}
+
+	if c.ClaimTimeout != "" {
+		v, err := time.ParseDuration(c.ClaimTimeout)
+		if err != nil || v <= 0 {
+			return p, errors.Errorf("Invalid transaction claim timeout %s", c.ClaimTimeout)
+		}
+		p.claimTimeout = v
+	}
+
+	return p, nil
+}
+
+// abandoned returns true if the client of t has given up on its result.  A
+// streamed transaction is only considered abandoned once its pending results
+// have been found unretrieved twice in a row, so that a Result call that
+// is about to receive them is not mistaken for an absent client.
+func (t *transaction) abandoned(now time.Time, claimTimeout time.Duration) bool {
+	if !t.claimed {
+		return now.Sub(t.started) > claimTimeout
+	}
+
+	if len(t.resultCh) == 0 || now.Sub(t.lastClaim) <= claimTimeout {
+		t.stalled = false
+		return false
+	}
+
+	if !t.stalled {
+		t.stalled = true
+		return false
+	}
+	return true
+}
+
+// expireTransactions cancels and forgets the transactions that have been
+// abandoned by their clients.  Their results are discarded as they are
+// produced.
+func (s *ccvmService) expireTransactions(now time.Time) {
+	for id, t := range s.transactions {
+		if !t.abandoned(now, s.txPolicy.claimTimeout) {
+			s.transactions[id] = t
+			continue
+		}
+
+		logWarningf("Transaction %d abandoned by its client, cancelling it", id)
+		t.cancel()
+		go func(resultCh chan interface{}) {
+			for range resultCh {
+			}
+		}(t.resultCh)
+		delete(s.transactions, id)
+		s.expired[id] = now
+	}
+
+	for id, expired := range s.expired {
+		if now.Sub(expired) > expiredRetention {
+			delete(s.expired, id)
+		}
+	}
+
+	if len(s.transactions) == 0 {
+		s.startShutdownTimer()
+	}
+}
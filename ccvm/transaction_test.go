@@ -0,0 +1,143 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransactionPolicy(t *testing.T) {
+	p, err := (&transactionConfig{}).policy()
+	if err != nil || p.timeout != defaultTransactionTimeout || p.claimTimeout != defaultClaimTimeout {
+		t.Errorf("Unexpected default policy %+v: %v", p, err)
+	}
+
+	p, err = (&transactionConfig{Timeout: "0", ClaimTimeout: "30s"}).policy()
+	if err != nil || p.timeout != 0 || p.claimTimeout != 30*time.Second {
+		t.Errorf("Unexpected policy %+v: %v", p, err)
+	}
+
+	for _, c := range []transactionConfig{{Timeout: "-1h"}, {ClaimTimeout: "0"}, {Timeout: "1 hour"}} {
+		if _, err := c.policy(); err == nil {
+			t.Errorf("Expected error for %+v", c)
+		}
+	}
+}
+
+func TestTransactionAbandoned(t *testing.T) {
+	now := time.Now()
+	claimTimeout := time.Minute
+
+	tr := transaction{started: now.Add(-2 * time.Minute), resultCh: make(chan interface{}, 1)}
+	if !tr.abandoned(now, claimTimeout) {
+		t.Errorf("Unclaimed transaction not abandoned")
+	}
+
+	tr.claimed = true
+	tr.lastClaim = now.Add(-2 * time.Minute)
+	if tr.abandoned(now, claimTimeout) {
+		t.Errorf("Transaction waiting for its result abandoned")
+	}
+
+	// A streamed result has not been retrieved.
+	tr.resultCh <- struct{}{}
+	if tr.abandoned(now, claimTimeout) || !tr.stalled {
+		t.Errorf("Transaction abandoned before it stalled")
+	}
+	if !tr.abandoned(now.Add(time.Second), claimTimeout) {
+		t.Errorf("Stalled transaction not abandoned")
+	}
+}
+
+func setupExpiringServer(t *testing.T, wg *sync.WaitGroup) (string, chan interface{}, chan struct{}) {
+	actionCh := make(chan interface{})
+	doneCh := make(chan struct{})
+	ccvmDir, err := ioutil.TempDir("", "ccvm-test")
+	if err != nil {
+		t.Fatalf("Unable to create directory %v", err)
+	}
+
+	wg.Add(1)
+	go func() {
+		svc := &ccvmService{
+			ccvmDir:       ccvmDir,
+			instances:     make(map[string]chan instanceCmd),
+			instanceChMap: make(map[chan struct{}]string),
+			groups:        make(map[string]string),
+			hostIPs:       make(map[uint32]struct{}),
+			b:             &goodBackend{},
+			txPolicy: transactionPolicy{
+				timeout:      time.Second,
+				claimTimeout: 50 * time.Millisecond,
+			},
+		}
+		svc.run(context.Background(), doneCh, actionCh)
+		wg.Done()
+	}()
+
+	return ccvmDir, actionCh, doneCh
+}
+
+func TestServerExpireTransactions(t *testing.T) {
+	var wg sync.WaitGroup
+
+	dir, actionCh, doneCh := setupExpiringServer(t, &wg)
+	transCh := make(chan int)
+
+	actionCh <- startAction{
+		action:  blockingAction(make(chan struct{})),
+		transCh: transCh,
+	}
+	abandonedID := <-transCh
+
+	// The result of this transaction is claimed straight away, so it
+	// must be allowed to run until its deadline.
+	actionCh <- startAction{
+		action:  blockingAction(make(chan struct{})),
+		transCh: transCh,
+	}
+	id := <-transCh
+	res := make(chan interface{})
+	actionCh <- getResult{ID: id, res: res}
+	resultCh, ok := (<-res).(chan interface{})
+	if !ok {
+		t.Fatalf("Unable to claim transaction %d", id)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	err := checkResult(actionCh, abandonedID, false)
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Expected abandoned transaction to expire, got %v", err)
+	}
+
+	err, _ = (<-resultCh).(error)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected transaction to reach its deadline, got %v", err)
+	}
+	actionCh <- completeAction(id)
+
+	close(doneCh)
+	wg.Wait()
+	_ = os.RemoveAll(dir)
+}
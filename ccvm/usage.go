@@ -0,0 +1,430 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The daemon periodically samples the CPU time, and estimates the energy,
+// consumed by each running instance and records them, per day, in
+// ~/.ccloudvm/usage.yaml.
+
+const (
+	usageFile          = "usage.yaml"
+	accountingInterval = time.Minute
+	usageDayFormat     = "2006-01-02"
+)
+
+// accountingConfig contains the daemon wide accounting settings.  CPUWatts
+// is the power drawn by a busy host CPU.  It is used to estimate energy
+// usage on hosts that do not expose RAPL counters.
+type accountingConfig struct {
+	CPUWatts float64 `yaml:"cpu_watts"`
+}
+
+type energyCounter struct {
+	MicroJoules uint64 `yaml:"energy_uj"`
+	Max         uint64 `yaml:"max_energy_range_uj"`
+}
+
+type dailyUsage struct {
+	CPUSeconds   float64 `yaml:"cpu_seconds"`
+	EnergyJoules float64 `yaml:"energy_joules"`
+}
+
+type processSample struct {
+	PID        int     `yaml:"pid,omitempty"`
+	CPUSeconds float64 `yaml:"cpu_seconds,omitempty"`
+}
+
+type instanceUsage struct {
+	Days map[string]dailyUsage `yaml:"days"`
+	Last processSample         `yaml:"last"`
+}
+
+type hostSample struct {
+	Energy      map[string]energyCounter `yaml:"energy,omitempty"`
+	BusySeconds float64                  `yaml:"busy_seconds,omitempty"`
+}
+
+type usageRecord struct {
+	Instances map[string]*instanceUsage `yaml:"instances"`
+	Host      hostSample                `yaml:"host"`
+}
+
+func loadUsage(ccvmDir string) (*usageRecord, error) {
+	rec := &usageRecord{}
+
+	data, err := ioutil.ReadFile(filepath.Join(ccvmDir, usageFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "Unable to read usage")
+	} else if err == nil {
+		if err := yaml.Unmarshal(data, rec); err != nil {
+			return nil, errors.Wrap(err, "Unable to parse usage")
+		}
+	}
+
+	if rec.Instances == nil {
+		rec.Instances = make(map[string]*instanceUsage)
+	}
+
+	return rec, nil
+}
+
+// saveUsage replaces the usage file atomically, as it may be read by
+// status and report requests while it is being written.
+func saveUsage(ccvmDir string, rec *usageRecord) error {
+	data, err := yaml.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal usage")
+	}
+
+	usagePath := filepath.Join(ccvmDir, usageFile)
+	tmpPath := usagePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return errors.Wrap(err, "Unable to write usage")
+	}
+	if err := os.Rename(tmpPath, usagePath); err != nil {
+		_ = os.Remove(tmpPath)
+		return errors.Wrap(err, "Unable to write usage")
+	}
+
+	return nil
+}
+
+// total returns the resources consumed by an instance on or after the day
+// since.  All resources are returned if since is empty.
+func (iu *instanceUsage) total(since string) types.ResourceUsage {
+	var usage types.ResourceUsage
+	for day, du := range iu.Days {
+		if day >= since {
+			usage.CPUSeconds += du.CPUSeconds
+			usage.EnergyJoules += du.EnergyJoules
+		}
+	}
+	return usage
+}
+
+// vmProcess returns the name of the hypervisor running the instance whose
+// directory is instanceDir and the pid of its process.
+func vmProcess(instanceDir string) (string, int, bool) {
+	for _, name := range []string{hypervisorQemu, hypervisorFirecracker, hypervisorCloudHypervisor} {
+		if processRunning(instanceDir, name) {
+			pid, err := processPid(instanceDir, name)
+			return name, pid, err == nil
+		}
+	}
+	return "", 0, false
+}
+
+// vmPid returns the pid of the hypervisor process running the instance
+// whose directory is instanceDir.
+func vmPid(instanceDir string) (int, bool) {
+	_, pid, ok := vmProcess(instanceDir)
+	return pid, ok
+}
+
+// accountant samples the resources consumed by instances.  The host
+// specific functions it relies on can be replaced for testing.
+type accountant struct {
+	ccvmDir     string
+	cfg         accountingConfig
+	vmPid       func(instanceDir string) (int, bool)
+	cpuSeconds  func(pid int) (float64, error)
+	busySeconds func() (float64, error)
+	readEnergy  func() (map[string]energyCounter, error)
+}
+
+func newAccountant(ccvmDir string, cfg accountingConfig) *accountant {
+	return &accountant{
+		ccvmDir:     ccvmDir,
+		cfg:         cfg,
+		vmPid:       vmPid,
+		cpuSeconds:  processCPUSeconds,
+		busySeconds: hostBusySeconds,
+		readEnergy:  readEnergy,
+	}
+}
+
+// hostEnergy returns the energy, in joules, used by the host's CPUs, and the
+// CPU time spent by the host doing work, since the last sample.  ok is false
+// if the energy used cannot be measured.
+func (a *accountant) hostEnergy(rec *usageRecord) (joules, busy float64, ok bool) {
+	energy, err := a.readEnergy()
+	if err != nil {
+		rec.Host = hostSample{}
+		return 0, 0, false
+	}
+	busySeconds, err := a.busySeconds()
+	if err != nil {
+		rec.Host = hostSample{}
+		return 0, 0, false
+	}
+
+	prev := rec.Host
+	rec.Host = hostSample{Energy: energy, BusySeconds: busySeconds}
+	if len(prev.Energy) != len(energy) || busySeconds <= prev.BusySeconds {
+		return 0, 0, false
+	}
+
+	var microJoules uint64
+	for zone, c := range energy {
+		p, found := prev.Energy[zone]
+		if !found {
+			return 0, 0, false
+		}
+		if c.MicroJoules >= p.MicroJoules {
+			microJoules += c.MicroJoules - p.MicroJoules
+		} else {
+			microJoules += c.Max - p.MicroJoules + c.MicroJoules
+		}
+	}
+
+	return float64(microJoules) / 1e6, busySeconds - prev.BusySeconds, true
+}
+
+func (a *accountant) sample(now time.Time) error {
+	rec, err := loadUsage(a.ccvmDir)
+	if err != nil {
+		return err
+	}
+
+	instanceDirs, _ := filepath.Glob(filepath.Join(a.ccvmDir, "instances", "*"))
+	cpu := make(map[string]float64)
+	for _, instanceDir := range instanceDirs {
+		name := filepath.Base(instanceDir)
+		iu := rec.Instances[name]
+		pid, ok := a.vmPid(instanceDir)
+		if !ok {
+			if iu != nil {
+				iu.Last = processSample{}
+			}
+			continue
+		}
+		seconds, err := a.cpuSeconds(pid)
+		if err != nil {
+			continue
+		}
+		if iu == nil {
+			iu = &instanceUsage{}
+			rec.Instances[name] = iu
+		}
+
+		// The VM has been restarted if its pid has changed, in which
+		// case all the CPU time consumed by the new process is
+		// accounted for.
+		delta := seconds
+		if iu.Last.PID == pid && seconds >= iu.Last.CPUSeconds {
+			delta = seconds - iu.Last.CPUSeconds
+		}
+		iu.Last = processSample{PID: pid, CPUSeconds: seconds}
+		cpu[name] = delta
+	}
+
+	joules, busy, rapl := a.hostEnergy(rec)
+
+	day := now.Format(usageDayFormat)
+	for name, seconds := range cpu {
+		iu := rec.Instances[name]
+		if iu.Days == nil {
+			iu.Days = make(map[string]dailyUsage)
+		}
+		du := iu.Days[day]
+		du.CPUSeconds += seconds
+		if rapl {
+			share := seconds / busy
+			if share > 1 {
+				share = 1
+			}
+			du.EnergyJoules += joules * share
+		} else {
+			du.EnergyJoules += seconds * a.cfg.CPUWatts
+		}
+		iu.Days[day] = du
+	}
+
+	return saveUsage(a.ccvmDir, rec)
+}
+
+// run samples the resources consumed by instances every accountingInterval
+// until ctx is cancelled.
+func (a *accountant) run(ctx context.Context) {
+	for {
+		if err := a.sample(time.Now()); err != nil {
+			logWarningf("%v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(accountingInterval):
+		}
+	}
+}
+
+// cpuSample is the CPU time consumed by the VM process pid at time.
+type cpuSample struct {
+	pid        int
+	cpuSeconds float64
+	time       time.Time
+}
+
+// cpuSampler computes the CPU usage of VMs from successive samples of the
+// CPU time they consume, so that the usage reported by consecutive requests,
+// e.g., by ccloudvm top, reflects the period between them.
+type cpuSampler struct {
+	sync.Mutex
+	samples map[string]cpuSample
+}
+
+var vmCPUSampler = &cpuSampler{samples: make(map[string]cpuSample)}
+
+// percent records a sample of the CPU time consumed by the VM of the
+// instance name and returns the CPU time it has consumed since the previous
+// sample as a percentage of one host CPU.  The average since the VM was
+// started, runSeconds ago, is returned for the first sample of a VM.
+func (s *cpuSampler) percent(name string, cur cpuSample, runSeconds float64) float64 {
+	s.Lock()
+	prev, ok := s.samples[name]
+	s.samples[name] = cur
+	s.Unlock()
+
+	if ok && prev.pid == cur.pid && cur.time.After(prev.time) && cur.cpuSeconds >= prev.cpuSeconds {
+		return 100 * (cur.cpuSeconds - prev.cpuSeconds) / cur.time.Sub(prev.time).Seconds()
+	}
+	if runSeconds <= 0 {
+		return 0
+	}
+	return 100 * cur.cpuSeconds / runSeconds
+}
+
+func (s *cpuSampler) forget(name string) {
+	s.Lock()
+	delete(s.samples, name)
+	s.Unlock()
+}
+
+// instanceLiveUsage returns the resources currently used by the instance
+// whose directory is instanceDir.  Resources that cannot be measured are
+// reported as zero.
+func instanceLiveUsage(ctx context.Context, instanceDir string) types.LiveUsage {
+	var usage types.LiveUsage
+
+	for _, image := range []string{"image.qcow2", "image.raw"} {
+		vmImage := filepath.Join(instanceDir, image)
+		if _, err := os.Stat(vmImage); err != nil {
+			continue
+		}
+		if size, err := imageAllocatedSize(ctx, vmImage); err == nil {
+			usage.DiskBytes = size
+		}
+		break
+	}
+
+	name := filepath.Base(instanceDir)
+	pid, ok := vmPid(instanceDir)
+	if !ok {
+		vmCPUSampler.forget(name)
+		return usage
+	}
+
+	if rss, err := processRSSBytes(pid); err == nil {
+		usage.RSSBytes = rss
+	}
+	seconds, err := processCPUSeconds(pid)
+	if err != nil {
+		return usage
+	}
+	runSeconds, _ := processRunSeconds(pid)
+	usage.CPUPercent = vmCPUSampler.percent(name, cpuSample{
+		pid:        pid,
+		cpuSeconds: seconds,
+		time:       time.Now(),
+	}, runSeconds)
+
+	return usage
+}
+
+// instanceUsageTotal returns the resources consumed by the instance name
+// since it was created.
+func instanceUsageTotal(ccvmDir, name string) types.ResourceUsage {
+	rec, err := loadUsage(ccvmDir)
+	if err != nil {
+		return types.ResourceUsage{}
+	}
+	iu, ok := rec.Instances[name]
+	if !ok {
+		return types.ResourceUsage{}
+	}
+	return iu.total("")
+}
+
+func usageReport(ccvmDir string, since time.Time) (*types.ReportResult, error) {
+	rec, err := loadUsage(ccvmDir)
+	if err != nil {
+		return nil, err
+	}
+
+	sinceDay := ""
+	if !since.IsZero() {
+		sinceDay = since.Local().Format(usageDayFormat)
+	}
+
+	names := make([]string, 0, len(rec.Instances))
+	for name := range rec.Instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := &types.ReportResult{Since: since}
+	for _, name := range names {
+		usage := rec.Instances[name].total(sinceDay)
+		if usage.CPUSeconds == 0 && usage.EnergyJoules == 0 {
+			continue
+		}
+		_, err := os.Stat(filepath.Join(ccvmDir, "instances", name))
+		res.Instances = append(res.Instances, types.InstanceReport{
+			Name:    name,
+			Deleted: os.IsNotExist(err),
+			Usage:   usage,
+		})
+		res.Total.CPUSeconds += usage.CPUSeconds
+		res.Total.EnergyJoules += usage.EnergyJoules
+	}
+
+	return res, nil
+}
+
+func (c ccvmBackend) report(ctx context.Context, since time.Time) (*types.ReportResult, error) {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return usageReport(ws.ccvmDir, since)
+}
@@ -0,0 +1,203 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+type fakeHost struct {
+	pids   map[string]int
+	cpu    map[int]float64
+	busy   float64
+	energy map[string]energyCounter
+}
+
+func (h *fakeHost) accountant(ccvmDir string, cfg accountingConfig) *accountant {
+	return &accountant{
+		ccvmDir: ccvmDir,
+		cfg:     cfg,
+		vmPid: func(instanceDir string) (int, bool) {
+			pid, ok := h.pids[filepath.Base(instanceDir)]
+			return pid, ok
+		},
+		cpuSeconds: func(pid int) (float64, error) {
+			return h.cpu[pid], nil
+		},
+		busySeconds: func() (float64, error) {
+			return h.busy, nil
+		},
+		readEnergy: func() (map[string]energyCounter, error) {
+			if h.energy == nil {
+				return nil, os.ErrNotExist
+			}
+			return h.energy, nil
+		},
+	}
+}
+
+func makeUsageDir(t *testing.T, instances ...string) string {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	for _, name := range instances {
+		if err := os.MkdirAll(filepath.Join(ccvmDir, "instances", name), 0755); err != nil {
+			t.Fatalf("Failed to create instance directory: %v", err)
+		}
+	}
+	return ccvmDir
+}
+
+func TestAccountingCPUWatts(t *testing.T) {
+	ccvmDir := makeUsageDir(t, "running", "stopped")
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	h := &fakeHost{
+		pids: map[string]int{"running": 100},
+		cpu:  map[int]float64{100: 10},
+	}
+	a := h.accountant(ccvmDir, accountingConfig{CPUWatts: 2})
+
+	day1 := time.Date(2026, 10, 1, 12, 0, 0, 0, time.Local)
+	day2 := day1.Add(24 * time.Hour)
+
+	if err := a.sample(day1); err != nil {
+		t.Fatalf("Sample failed: %v", err)
+	}
+	h.cpu[100] = 25
+	if err := a.sample(day2); err != nil {
+		t.Fatalf("Sample failed: %v", err)
+	}
+
+	// The VM is restarted with a new pid.
+	h.pids["running"] = 200
+	h.cpu[200] = 5
+	if err := a.sample(day2); err != nil {
+		t.Fatalf("Sample failed: %v", err)
+	}
+
+	usage := instanceUsageTotal(ccvmDir, "running")
+	if usage.CPUSeconds != 30 || usage.EnergyJoules != 60 {
+		t.Errorf("Unexpected total usage %+v", usage)
+	}
+
+	res, err := usageReport(ccvmDir, day2)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if len(res.Instances) != 1 || res.Instances[0].Name != "running" ||
+		res.Instances[0].Deleted || res.Total.CPUSeconds != 20 {
+		t.Errorf("Unexpected report %+v", res)
+	}
+
+	if err := os.RemoveAll(filepath.Join(ccvmDir, "instances", "running")); err != nil {
+		t.Fatalf("Failed to delete instance: %v", err)
+	}
+	res, err = usageReport(ccvmDir, time.Time{})
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if len(res.Instances) != 1 || !res.Instances[0].Deleted || res.Total.CPUSeconds != 30 {
+		t.Errorf("Unexpected report %+v", res)
+	}
+}
+
+func TestAccountingRAPL(t *testing.T) {
+	ccvmDir := makeUsageDir(t, "a", "b")
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	h := &fakeHost{
+		pids:   map[string]int{"a": 100, "b": 200},
+		cpu:    map[int]float64{100: 0, 200: 0},
+		busy:   1000,
+		energy: map[string]energyCounter{"intel-rapl:0": {MicroJoules: 900e6, Max: 1000e6}},
+	}
+	a := h.accountant(ccvmDir, accountingConfig{})
+
+	now := time.Now()
+	if err := a.sample(now); err != nil {
+		t.Fatalf("Sample failed: %v", err)
+	}
+
+	// The counter wraps.  The host consumed 200 J while busy for 40
+	// seconds, 30 of which were spent running the instances.
+	h.cpu[100] = 10
+	h.cpu[200] = 20
+	h.busy = 1040
+	h.energy["intel-rapl:0"] = energyCounter{MicroJoules: 100e6, Max: 1000e6}
+	if err := a.sample(now); err != nil {
+		t.Fatalf("Sample failed: %v", err)
+	}
+
+	for name, joules := range map[string]float64{"a": 50, "b": 100} {
+		if usage := instanceUsageTotal(ccvmDir, name); usage.EnergyJoules != joules {
+			t.Errorf("Expected %s to use %f J, got %+v", name, joules, usage)
+		}
+	}
+}
+
+func TestProcessCPUSeconds(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("procfs is not available")
+	}
+
+	if _, err := processCPUSeconds(os.Getpid()); err != nil {
+		t.Errorf("Unable to read CPU time: %v", err)
+	}
+	if _, err := hostBusySeconds(); err != nil {
+		t.Errorf("Unable to read host CPU time: %v", err)
+	}
+	if seconds, err := processRunSeconds(os.Getpid()); err != nil || seconds < 0 {
+		t.Errorf("Unable to read process run time %f: %v", seconds, err)
+	}
+	if rss, err := processRSSBytes(os.Getpid()); err != nil || rss <= 0 {
+		t.Errorf("Unable to read resident memory %d: %v", rss, err)
+	}
+}
+
+func TestCPUSampler(t *testing.T) {
+	s := &cpuSampler{samples: make(map[string]cpuSample)}
+	now := time.Now()
+
+	// The first sample of a VM reports its average usage since it was
+	// started.
+	if p := s.percent("a", cpuSample{pid: 100, cpuSeconds: 30, time: now}, 60); p != 50 {
+		t.Errorf("Expected 50%% CPU since start, got %f", p)
+	}
+
+	now = now.Add(2 * time.Second)
+	if p := s.percent("a", cpuSample{pid: 100, cpuSeconds: 33, time: now}, 62); p != 150 {
+		t.Errorf("Expected 150%% CPU, got %f", p)
+	}
+
+	// The VM has been restarted.
+	now = now.Add(2 * time.Second)
+	if p := s.percent("a", cpuSample{pid: 200, cpuSeconds: 1, time: now}, 4); p != 25 {
+		t.Errorf("Expected 25%% CPU after restart, got %f", p)
+	}
+
+	s.forget("a")
+	if p := s.percent("a", cpuSample{pid: 200, cpuSeconds: 1, time: now}, 0); p != 0 {
+		t.Errorf("Expected no CPU usage without run time, got %f", p)
+	}
+}
@@ -0,0 +1,266 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The user data passed to create is applied to the cloud-init document of
+// the new instance.  Its cloud-config documents are merged into the
+// document and its other parts are added to it.
+
+// userDataTypes maps the first line of user data documents to the MIME
+// type of the parts in which they are passed to cloud-init.
+var userDataTypes = []struct {
+	prefix    string
+	mediaType string
+}{
+	{"#!", "text/x-shellscript"},
+	{"#cloud-boothook", "text/cloud-boothook"},
+	{"#include", "text/x-include-url"},
+	{"#part-handler", "text/part-handler"},
+}
+
+type userDataPart struct {
+	mediaType string
+	filename  string
+	body      []byte
+}
+
+// userData is the user data passed to create.
+type userData struct {
+	replace bool
+	configs []cloudConfig
+	parts   []userDataPart
+}
+
+// parseUserData parses the user data data, applied as mode, one of the
+// types.UserData constants.  It returns nil if there is no user data.
+func parseUserData(data []byte, mode string) (*userData, error) {
+	var ud userData
+	switch mode {
+	case "", types.UserDataMerge:
+	case types.UserDataReplace:
+		ud.replace = true
+	default:
+		return nil, errors.Errorf("Invalid user data mode %s", mode)
+	}
+
+	if len(data) == 0 {
+		if ud.replace {
+			return nil, errors.New("No user data to replace the cloud-init document of the workload")
+		}
+		return nil, nil
+	}
+
+	if err := ud.addDocument(data); err != nil {
+		return nil, err
+	}
+	return &ud, nil
+}
+
+// addDocument adds a user data document whose type is given by its first
+// line.
+func (ud *userData) addDocument(data []byte) error {
+	firstLine := string(data)
+	if i := strings.IndexByte(firstLine, '\n'); i >= 0 {
+		firstLine = firstLine[:i]
+	}
+	firstLine = strings.TrimSpace(firstLine)
+	lower := strings.ToLower(firstLine)
+
+	switch {
+	case strings.HasPrefix(firstLine, "#cloud-config"):
+		return ud.addCloudConfig(data)
+	case strings.HasPrefix(lower, "content-type:") || strings.HasPrefix(lower, "mime-version:"):
+		return ud.addMIME(data)
+	}
+	for _, t := range userDataTypes {
+		if strings.HasPrefix(firstLine, t.prefix) {
+			ud.addPart(t.mediaType, "", data)
+			return nil
+		}
+	}
+	return errors.Errorf("Unsupported user data starting with %q.  Expected a cloud-config document, a script or a multi-part MIME document", firstLine)
+}
+
+func (ud *userData) addCloudConfig(data []byte) error {
+	var cc cloudConfig
+	if err := yaml.Unmarshal(data, &cc); err != nil {
+		return errors.Wrap(err, "Invalid cloud-config user data")
+	}
+	if cc != nil {
+		ud.configs = append(ud.configs, cc)
+	}
+	return nil
+}
+
+func (ud *userData) addPart(mediaType, filename string, body []byte) {
+	if filename == "" {
+		filename = fmt.Sprintf("user-data-%d", len(ud.parts)+1)
+	}
+	ud.parts = append(ud.parts, userDataPart{
+		mediaType: mediaType,
+		filename:  filename,
+		body:      body,
+	})
+}
+
+// addMIME adds the parts of a MIME document.  The parts of multi-part
+// documents nested in it are added as well.
+func (ud *userData) addMIME(data []byte) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "Invalid MIME user data")
+	}
+	body, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		return errors.Wrap(err, "Invalid MIME user data")
+	}
+	return ud.addMIMEPart(textproto.MIMEHeader(msg.Header), body)
+}
+
+func (ud *userData) addMIMEPart(header textproto.MIMEHeader, body []byte) error {
+	if strings.EqualFold(header.Get("Content-Transfer-Encoding"), "base64") {
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+		if err != nil {
+			return errors.Wrap(err, "Invalid base64 part in user data")
+		}
+		body = decoded
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		return ud.addDocument(body)
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid content type %s in user data", contentType)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			p, err := r.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return errors.Wrap(err, "Invalid multi-part user data")
+			}
+			data, err := ioutil.ReadAll(p)
+			if err != nil {
+				return errors.Wrap(err, "Invalid multi-part user data")
+			}
+			if err := ud.addMIMEPart(p.Header, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	if mediaType == "text/cloud-config" {
+		return ud.addCloudConfig(body)
+	}
+	var filename string
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		filename = params["filename"]
+	}
+	ud.addPart(mediaType, filename, body)
+	return nil
+}
+
+// apply merges the cloud-config documents of the user data into data.  The
+// users of the workload are kept if the user data defines none.
+func (ud *userData) apply(data cloudConfig) (cloudConfig, error) {
+	result := data
+	if ud.replace {
+		result = cloudConfig{}
+	}
+
+	for _, cc := range ud.configs {
+		merged := make(cloudConfig, len(cc))
+		for k, v := range cc {
+			merged[k] = v
+		}
+		if err := merged.merge(result); err != nil {
+			return nil, errors.Wrap(err, "Unable to merge user data")
+		}
+		result = merged
+	}
+
+	if _, ok := result["users"]; !ok {
+		if users, ok := data["users"]; ok {
+			result["users"] = users
+		}
+	}
+	return result, nil
+}
+
+// multipart returns a multi-part MIME document made of the cloud-config
+// document cloudConfig followed by the other parts of the user data.
+func (ud *userData) multipart(cloudConfig []byte) ([]byte, error) {
+	var parts bytes.Buffer
+	w := multipart.NewWriter(&parts)
+
+	add := func(mediaType, filename, encoding string, body []byte) error {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", mime.FormatMediaType(mediaType, map[string]string{"charset": "utf-8"}))
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		if encoding != "" {
+			header.Set("Content-Transfer-Encoding", encoding)
+		}
+		pw, err := w.CreatePart(header)
+		if err == nil {
+			_, err = pw.Write(body)
+		}
+		return err
+	}
+
+	err := add("text/cloud-config", "ccloudvm.cfg", "", cloudConfig)
+	for i := 0; err == nil && i < len(ud.parts); i++ {
+		p := &ud.parts[i]
+		err = add(p.mediaType, p.filename, "base64",
+			[]byte(base64.StdEncoding.EncodeToString(p.body)+"\n"))
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create multi-part user data")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Content-Type: %s\n",
+		mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": w.Boundary()}))
+	_, _ = buf.WriteString("MIME-Version: 1.0\n\n")
+	_, _ = buf.Write(parts.Bytes())
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,159 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const userDataMultiPart = `Content-Type: multipart/mixed; boundary="BOUNDARY"
+MIME-Version: 1.0
+
+--BOUNDARY
+Content-Type: text/cloud-config; charset="us-ascii"
+
+#cloud-config
+runcmd:
+- user command
+--BOUNDARY
+Content-Type: text/x-shellscript; charset="us-ascii"
+Content-Disposition: attachment; filename="script.sh"
+Content-Transfer-Encoding: base64
+
+IyEvYmluL3NoCmVjaG8gaGVsbG8K
+--BOUNDARY--
+`
+
+func TestParseUserData(t *testing.T) {
+	ud, err := parseUserData(nil, types.UserDataMerge)
+	if err != nil || ud != nil {
+		t.Errorf("Expected no user data, got %v, %v", ud, err)
+	}
+
+	if _, err = parseUserData(nil, types.UserDataReplace); err == nil {
+		t.Errorf("Expected replace without user data to fail")
+	}
+
+	if _, err = parseUserData([]byte("#cloud-config\n"), "append"); err == nil {
+		t.Errorf("Expected invalid mode to fail")
+	}
+
+	if _, err = parseUserData([]byte("runcmd:\n- command\n"), ""); err == nil {
+		t.Errorf("Expected user data without header to fail")
+	}
+
+	ud, err = parseUserData([]byte("#!/bin/sh\necho hello\n"), "")
+	if err != nil {
+		t.Fatalf("Failed to parse script: %v", err)
+	}
+	if len(ud.configs) != 0 || len(ud.parts) != 1 || ud.parts[0].mediaType != "text/x-shellscript" {
+		t.Errorf("Unexpected user data for script %+v", ud)
+	}
+
+	ud, err = parseUserData([]byte(userDataMultiPart), "")
+	if err != nil {
+		t.Fatalf("Failed to parse multi-part user data: %v", err)
+	}
+	if len(ud.configs) != 1 || len(ud.parts) != 1 {
+		t.Fatalf("Unexpected multi-part user data %+v", ud)
+	}
+	p := ud.parts[0]
+	if p.mediaType != "text/x-shellscript" || p.filename != "script.sh" ||
+		string(p.body) != "#!/bin/sh\necho hello\n" {
+		t.Errorf("Unexpected script part %+v", p)
+	}
+}
+
+func TestApplyUserData(t *testing.T) {
+	workloadData := "runcmd:\n- workload command\npackages:\n- git\nusers:\n- name: user\n"
+	userData := "#cloud-config\nruncmd:\n- user command\npackages:\n- vim\ntimezone: UTC\n"
+
+	var data cloudConfig
+	if err := yaml.Unmarshal([]byte(workloadData), &data); err != nil {
+		t.Fatalf("Failed to unmarshal workload data: %v", err)
+	}
+
+	ud, err := parseUserData([]byte(userData), types.UserDataMerge)
+	if err != nil {
+		t.Fatalf("Failed to parse user data: %v", err)
+	}
+	merged, err := ud.apply(data)
+	if err != nil {
+		t.Fatalf("Failed to merge user data: %v", err)
+	}
+	if !reflect.DeepEqual(merged["runcmd"], []interface{}{"workload command", "user command"}) ||
+		!reflect.DeepEqual(merged["packages"], []interface{}{"git", "vim"}) ||
+		merged["timezone"] != "UTC" {
+		t.Errorf("Unexpected merged user data %v", merged)
+	}
+
+	ud, err = parseUserData([]byte(userData), types.UserDataReplace)
+	if err != nil {
+		t.Fatalf("Failed to parse user data: %v", err)
+	}
+	replaced, err := ud.apply(data)
+	if err != nil {
+		t.Fatalf("Failed to replace user data: %v", err)
+	}
+	if !reflect.DeepEqual(replaced["runcmd"], []interface{}{"user command"}) ||
+		!reflect.DeepEqual(replaced["users"], data["users"]) {
+		t.Errorf("Unexpected replaced user data %v", replaced)
+	}
+}
+
+func TestUserDataCloudConfig(t *testing.T) {
+	ud, err := parseUserData([]byte(userDataMultiPart), "")
+	if err != nil {
+		t.Fatalf("Failed to parse multi-part user data: %v", err)
+	}
+
+	ws := &workspace{HTTPServerPort: 1234, network: defaultNetwork(), userData: ud}
+	wkld := &workload{userData: "runcmd:\n- workload command\n"}
+	err = wkld.generateCloudConfig(ws)
+	if err != nil {
+		t.Fatalf("Failed to generate cloud config: %v", err)
+	}
+
+	for _, line := range strings.Split(string(wkld.mergedUserData), "\n") {
+		if line == "---" || line == "..." {
+			t.Fatalf("Document separator found in user data")
+		}
+	}
+
+	parsed, err := parseUserData(wkld.mergedUserData, "")
+	if err != nil {
+		t.Fatalf("Failed to parse generated user data: %v", err)
+	}
+	if len(parsed.configs) != 1 || len(parsed.parts) != 1 ||
+		!bytes.Equal(parsed.parts[0].body, ud.parts[0].body) {
+		t.Fatalf("Unexpected generated user data %+v", parsed)
+	}
+
+	var runcmd []string
+	for _, cmd := range parsed.configs[0]["runcmd"].([]interface{}) {
+		runcmd = append(runcmd, cmd.(string))
+	}
+	if len(runcmd) < 2 || runcmd[0] != "workload command" || runcmd[1] != "user command" {
+		t.Errorf("Unexpected runcmd %v", runcmd)
+	}
+}
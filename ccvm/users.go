@@ -0,0 +1,382 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// In system mode a single daemon, run by root, serves all the users of the
+// host.  Each connection is served by a service of the user that made it,
+// whose data lives in /var/lib/ccloudvm/users/<user>.
+
+const systemDataDir = "/var/lib/ccloudvm"
+
+// systemConfig contains the settings of a system mode daemon.
+type systemConfig struct {
+	Group   string              `yaml:"group"`
+	Quota   resourceQuota       `yaml:"quota"`
+	Devices map[string][]string `yaml:"devices"`
+}
+
+func (c *systemConfig) checkDevices() error {
+	for user, addresses := range c.Devices {
+		for _, a := range addresses {
+			if err := (types.GPU{Address: a}).Check(); err != nil {
+				return errors.Wrapf(err, "Invalid device of %s", user)
+			}
+		}
+	}
+	return nil
+}
+
+// account identifies the user on whose behalf a system mode service manages
+// instances.
+type account struct {
+	name    string
+	home    string
+	uid     int
+	gid     int
+	ccvmDir string
+	quota   resourceQuota
+	devices []string
+}
+
+type accountKey struct{}
+
+func withAccount(ctx context.Context, a *account) context.Context {
+	return context.WithValue(ctx, accountKey{}, a)
+}
+
+// accountFromContext returns the account of the user served by a system
+// mode service, or nil if the daemon only serves the user running it.
+func accountFromContext(ctx context.Context) *account {
+	a, _ := ctx.Value(accountKey{}).(*account)
+	return a
+}
+
+func lookupAccount(uid int, cfg *systemConfig) (*account, error) {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to look up user %d", uid)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid group of user %s", u.Username)
+	}
+
+	return &account{
+		name:    u.Username,
+		home:    u.HomeDir,
+		uid:     uid,
+		gid:     gid,
+		ccvmDir: types.SystemUserDataDir(u.Username),
+		quota:   cfg.Quota,
+		devices: cfg.Devices[u.Username],
+	}, nil
+}
+
+// makeDir creates the data directory of a, which belongs to root and can
+// only be traversed by the user's group.  The directories of earlier
+// versions of ccloudvm, which belonged to the user, are given back to root.
+func (a *account) makeDir() error {
+	err := os.MkdirAll(a.ccvmDir, 0710)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to create %s", a.ccvmDir)
+	}
+	err = os.Chown(a.ccvmDir, 0, a.gid)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to change owner of %s", a.ccvmDir)
+	}
+	err = os.Chmod(a.ccvmDir, 0710)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to change permissions of %s", a.ccvmDir)
+	}
+	return nil
+}
+
+// giveToUser makes the user served by a system mode service the owner of
+// files the client needs to access, e.g., SSH keys.
+func (ws *workspace) giveToUser(paths ...string) error {
+	if ws.account == nil {
+		return nil
+	}
+
+	for _, p := range paths {
+		if err := os.Chown(p, ws.UID, ws.GID); err != nil {
+			return errors.Wrapf(err, "Unable to change owner of %s", p)
+		}
+	}
+	return nil
+}
+
+// checkUserPath verifies that a host path supplied by the user of a system
+// mode service belongs to that user.
+func (ws *workspace) checkUserPath(p string) error {
+	if ws.account == nil {
+		return nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(p)
+	if os.IsNotExist(err) {
+		resolved, err = filepath.EvalSymlinks(filepath.Dir(p))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Unable to access %s", p)
+	}
+
+	fi, err := os.Stat(resolved)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to access %s", p)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || int(st.Uid) != ws.UID {
+		return errors.Errorf("%s does not belong to %s", p, ws.User)
+	}
+	return nil
+}
+
+// checkLocalFile verifies that a local file named by the user of a system
+// mode service may be read on behalf of the user.
+func (ws *workspace) checkLocalFile(p string) error {
+	if ws.account == nil {
+		return nil
+	}
+
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		for _, dir := range []string{localImagesDir, templatesDir} {
+			d, err := filepath.EvalSymlinks(filepath.Join(ws.ccvmDir, dir))
+			if err == nil && strings.HasPrefix(resolved, d+string(filepath.Separator)) {
+				return nil
+			}
+		}
+	}
+	return ws.checkUserPath(p)
+}
+
+// checkQemuConfig verifies that the workload of an instance of the user
+// served by a system mode service does not choose the qemu binary.  The
+// binary is run by root, so only the daemon configuration may name it.
+func (ws *workspace) checkQemuConfig(q *qemuConfig) error {
+	if ws.account == nil {
+		return nil
+	}
+
+	if q.Path != "" || q.MinVersion != "" {
+		return errors.New("The qemu binary can only be configured by the daemon in system mode")
+	}
+	return nil
+}
+
+// checkDevices verifies that the user of a system mode service may use the
+// host devices given to the VM described by in.
+func (ws *workspace) checkDevices(in *types.VMSpec) error {
+	if ws.account == nil {
+		return nil
+	}
+
+	allowed := make(map[string]struct{})
+	for _, a := range ws.account.devices {
+		allowed[a] = struct{}{}
+	}
+	for _, g := range in.GPUs {
+		if _, ok := allowed[g.Address]; !ok {
+			return errors.Errorf("GPU %s is not assigned to %s by the daemon configuration",
+				g.Address, ws.account.name)
+		}
+	}
+	for _, g := range in.VGPUs {
+		if _, ok := allowed[g.Parent]; !ok {
+			return errors.Errorf("vGPU parent %s is not assigned to %s by the daemon configuration",
+				g.Parent, ws.account.name)
+		}
+	}
+	return nil
+}
+
+// restrictSocket limits access to the socket created by a system mode
+// daemon to the members of group, or opens it to all users if group is
+// empty.
+func restrictSocket(socketPath, group string) error {
+	mode := os.FileMode(0666)
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to look up group %s", group)
+		}
+		gid, err := strconv.Atoi(g.Gid)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid group %s", group)
+		}
+		if err := os.Chown(socketPath, -1, gid); err != nil {
+			return errors.Wrap(err, "Unable to change group of socket")
+		}
+		mode = 0660
+	}
+
+	if err := os.Chmod(socketPath, mode); err != nil {
+		return errors.Wrap(err, "Unable to set permissions of socket")
+	}
+	return nil
+}
+
+// peerAddr is the address of a connection made by the user with the uid
+// peerAddr.
+type peerAddr int
+
+func (a peerAddr) Network() string {
+	return "unix"
+}
+
+func (a peerAddr) String() string {
+	return strconv.Itoa(int(a))
+}
+
+type peerConn struct {
+	net.Conn
+	uid int
+}
+
+func (c peerConn) RemoteAddr() net.Addr {
+	return peerAddr(c.uid)
+}
+
+// peerListener identifies the users connecting to a system mode daemon.  The
+// uid of the user making a connection is its remote address.
+type peerListener struct {
+	net.Listener
+}
+
+func (l peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		uid, err := peerUID(conn)
+		if err != nil {
+			logWarningf("Rejecting connection: %v", err)
+			_ = conn.Close()
+			continue
+		}
+
+		return peerConn{Conn: conn, uid: uid}, nil
+	}
+}
+
+// userService is the service of a user of a system mode daemon.
+type userService struct {
+	api  *ServerAPI
+	user string
+}
+
+// userServices dispatches the RPC connections made to a system mode daemon
+// to the services of their users, starting a service the first time a user
+// connects.  A user's service is replaced if it exits, e.g., after having
+// been drained.
+type userServices struct {
+	sync.Mutex
+	d        *daemon
+	ctx      context.Context
+	services map[int]*userService
+}
+
+func newUserServices(ctx context.Context, d *daemon) *userServices {
+	return &userServices{
+		d:        d,
+		ctx:      ctx,
+		services: make(map[int]*userService),
+	}
+}
+
+func (u *userServices) service(uid int) (*userService, error) {
+	u.Lock()
+	defer u.Unlock()
+
+	if us, ok := u.services[uid]; ok {
+		return us, nil
+	}
+
+	a, err := lookupAccount(uid, &u.d.cfg.System)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.makeDir(); err != nil {
+		return nil, err
+	}
+
+	// Services exit when the daemon does rather than when they are idle
+	// as the daemon serves many users.
+	api, err := u.d.startService(withAccount(u.ctx, a), a.ccvmDir, uid, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	us := &userService{api: api, user: a.name}
+	u.services[uid] = us
+	logInfof("Started service for user %s", a.name)
+
+	go func() {
+		<-api.finishedCh
+		u.Lock()
+		if u.services[uid] == us {
+			delete(u.services, uid)
+		}
+		u.Unlock()
+	}()
+
+	return us, nil
+}
+
+// ServeHTTP serves the connections of local users, identified by their
+// peerListener, and of remote clients, identified by their certificates.
+func (u *userServices) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var uid int
+	var err error
+	if r.TLS != nil {
+		uid, err = certUID(r.TLS)
+	} else {
+		uid, err = strconv.Atoi(r.RemoteAddr)
+	}
+	if err != nil {
+		logWarningf("Rejecting connection: %v", err)
+		http.Error(w, "Unknown user", http.StatusForbidden)
+		return
+	}
+
+	us, err := u.service(uid)
+	if err != nil {
+		logWarningf("Unable to serve user %d: %v", uid, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	serveAPI(w, r, us.api, &u.d.cfg.Auth, us.user)
+}
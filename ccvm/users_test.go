@@ -0,0 +1,317 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestPeerListener(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("Peer credentials are not supported")
+	}
+
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	socketPath := filepath.Join(dir, "socket")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	go func() {
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			_ = conn.Close()
+		}
+	}()
+
+	conn, err := peerListener{listener}.Accept()
+	if err != nil {
+		t.Fatalf("Unable to accept connection: %v", err)
+	}
+	_ = conn.Close()
+
+	if uid := conn.RemoteAddr().String(); uid != strconv.Itoa(os.Getuid()) {
+		t.Errorf("Unexpected peer %s", uid)
+	}
+}
+
+func TestAccountDir(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Data directories of system mode can only be created by root")
+	}
+
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// Directories that belong to the user, as created by earlier
+	// versions, are given back to root.
+
+	a := &account{
+		name:    "alice",
+		uid:     1000,
+		gid:     1000,
+		ccvmDir: filepath.Join(dir, "alice"),
+	}
+	if err := os.Mkdir(a.ccvmDir, 0700); err != nil {
+		t.Fatalf("Unable to create %s: %v", a.ccvmDir, err)
+	}
+	if err := os.Chown(a.ccvmDir, a.uid, a.gid); err != nil {
+		t.Fatalf("Unable to change owner of %s: %v", a.ccvmDir, err)
+	}
+
+	if err := a.makeDir(); err != nil {
+		t.Fatalf("Unable to create data directory: %v", err)
+	}
+	fi, err := os.Stat(a.ccvmDir)
+	if err != nil {
+		t.Fatalf("Unable to stat %s: %v", a.ccvmDir, err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	if st.Uid != 0 || int(st.Gid) != a.gid || fi.Mode().Perm() != 0710 {
+		t.Errorf("Unexpected owner %d:%d or mode %v", st.Uid, st.Gid, fi.Mode().Perm())
+	}
+}
+
+func TestUserWorkspace(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	a := &account{
+		name:    "alice",
+		home:    "/home/alice",
+		uid:     os.Getuid() + 1,
+		gid:     os.Getgid(),
+		ccvmDir: ccvmDir,
+		quota:   resourceQuota{Instances: 2, MemMiB: 4096},
+	}
+	ws, err := prepareEnv(withAccount(context.Background(), a), "dev")
+	if err != nil {
+		t.Fatalf("Unable to prepare workspace: %v", err)
+	}
+	if ws.User != "alice" || ws.UID != a.uid || ws.instanceDir != filepath.Join(ccvmDir, "instances", "dev") {
+		t.Errorf("Workspace not prepared for user %+v", ws)
+	}
+
+	// The files of the daemon's user do not belong to alice.
+	if err := ws.checkUserPath(ccvmDir); err == nil {
+		t.Errorf("Path of another user accepted")
+	}
+	if err := ws.checkUserPath(filepath.Join(ccvmDir, "archive.tar")); err == nil {
+		t.Errorf("Path in a directory of another user accepted")
+	}
+
+	wkld := defaultWorkload()
+	wkld.spec.VM.MemMiB = 2048
+	instanceDir := filepath.Join(ccvmDir, "instances", "test")
+	if err := os.MkdirAll(instanceDir, 0755); err != nil {
+		t.Fatalf("Unable to create %s: %v", instanceDir, err)
+	}
+	if err := wkld.save(instanceDir); err != nil {
+		t.Fatalf("Unable to save workload: %v", err)
+	}
+
+	var limits resourceQuota
+	if err := ws.checkQuota(&limits, &types.VMSpec{MemMiB: 2048, CPUs: 2}); err != nil {
+		t.Errorf("Instance within quota rejected: %v", err)
+	}
+	if err := ws.checkQuota(&limits, &types.VMSpec{MemMiB: 4096, CPUs: 2}); err == nil {
+		t.Errorf("Memory quota not enforced")
+	}
+
+	// The instance being created does not count towards the quota.
+	if err := os.MkdirAll(filepath.Join(ccvmDir, "instances", "dev"), 0755); err != nil {
+		t.Fatalf("Unable to create instance directory: %v", err)
+	}
+	if err := wkld.save(filepath.Join(ccvmDir, "instances", "dev")); err != nil {
+		t.Fatalf("Unable to save workload: %v", err)
+	}
+	if err := ws.checkQuota(&limits, &types.VMSpec{MemMiB: 0, CPUs: 1}); err != nil {
+		t.Errorf("Instance within quota rejected: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(ccvmDir, "instances", "other"), 0755); err != nil {
+		t.Fatalf("Unable to create instance directory: %v", err)
+	}
+	if err := wkld.save(filepath.Join(ccvmDir, "instances", "other")); err != nil {
+		t.Fatalf("Unable to save workload: %v", err)
+	}
+	if err := ws.checkQuota(&limits, &types.VMSpec{MemMiB: 0, CPUs: 1}); err == nil {
+		t.Errorf("Instance quota not enforced")
+	}
+}
+
+func TestUserLocalFiles(t *testing.T) {
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(ccvmDir) }()
+
+	a := &account{
+		name:    "alice",
+		home:    "/home/alice",
+		uid:     os.Getuid() + 1,
+		gid:     os.Getgid(),
+		ccvmDir: ccvmDir,
+	}
+	ws, err := prepareEnv(withAccount(context.Background(), a), "dev")
+	if err != nil {
+		t.Fatalf("Unable to prepare workspace: %v", err)
+	}
+
+	// The files of the daemon's user cannot be read on behalf of alice,
+	// except for the images and templates stored by the daemon.
+
+	secret := filepath.Join(ccvmDir, "secret.yaml")
+	if err := ioutil.WriteFile(secret, []byte(sampleWorkload), 0600); err != nil {
+		t.Fatalf("Unable to write %s: %v", secret, err)
+	}
+	if err := ws.checkLocalFile(secret); err == nil {
+		t.Errorf("File of another user accepted")
+	}
+
+	image := filepath.Join(ccvmDir, localImagesDir, "template-dev.qcow2")
+	if err := os.MkdirAll(filepath.Dir(image), 0755); err != nil {
+		t.Fatalf("Unable to create images directory: %v", err)
+	}
+	if err := ioutil.WriteFile(image, []byte("qcow2 image"), 0644); err != nil {
+		t.Fatalf("Unable to write %s: %v", image, err)
+	}
+	if err := ws.checkLocalFile(image); err != nil {
+		t.Errorf("Image of the daemon rejected: %v", err)
+	}
+	link := filepath.Join(ccvmDir, localImagesDir, "secret.qcow2")
+	if err := os.Symlink(secret, link); err != nil {
+		t.Fatalf("Unable to create %s: %v", link, err)
+	}
+	if err := ws.checkLocalFile(link); err == nil {
+		t.Errorf("Link out of the images directory accepted")
+	}
+
+	ctx := context.Background()
+	if _, err := downloadURI(ctx, ws, "file://"+secret, "", nil, nil, nil); err == nil {
+		t.Errorf("downloadURI read a file of another user")
+	}
+
+	wkld := defaultWorkload()
+	wkld.spec.BaseImageURL = secret
+	resultCh := make(chan interface{}, 1)
+	if _, _, err := downloadImages(ctx, wkld, ws, nil, resultCh, nil); err == nil {
+		t.Errorf("downloadImages read a base image of another user")
+	}
+
+	for _, name := range []string{secret, "file://" + secret} {
+		if _, err := loadWorkloadData(ctx, ws, name, nil); err == nil {
+			t.Errorf("loadWorkloadData read %s, a file of another user", name)
+		}
+	}
+
+	for _, in := range []types.VMSpec{{Kernel: secret}, {Kernel: image, Initrd: secret}} {
+		if err := checkKernelFiles(ws, &in); err == nil {
+			t.Errorf("Kernel or initrd of another user accepted: %+v", in)
+		}
+		if _, err := directKernelArgs(ws, &in, ""); err == nil {
+			t.Errorf("Kernel or initrd of another user booted: %+v", in)
+		}
+	}
+}
+
+func TestUserQemuConfig(t *testing.T) {
+	cfg := &daemonConfig{Qemu: qemuConfig{Path: "/usr/bin/qemu-system-x86_64"}}
+	spec := &workloadSpec{Qemu: qemuConfig{Path: "/home/alice/qemu", MinVersion: "2.9.0"}}
+
+	ws := &workspace{}
+	if err := ws.checkQemuConfig(&spec.Qemu); err != nil {
+		t.Errorf("qemu settings of the daemon's user rejected: %v", err)
+	}
+	hv, err := getHypervisor(ws, cfg, spec)
+	if err != nil {
+		t.Fatalf("getHypervisor failed: %v", err)
+	}
+	if q := hv.(qemuHypervisor).cfg; q != spec.Qemu {
+		t.Errorf("Workload qemu settings not used: %+v", q)
+	}
+
+	// The users of a system mode daemon cannot choose the binary run by
+	// root.
+	ws.account = &account{name: "alice"}
+	if err := ws.checkQemuConfig(&spec.Qemu); err == nil {
+		t.Errorf("qemu settings of a system mode user accepted")
+	}
+	hv, err = getHypervisor(ws, cfg, spec)
+	if err != nil {
+		t.Fatalf("getHypervisor failed: %v", err)
+	}
+	if q := hv.(qemuHypervisor).cfg; q != cfg.Qemu {
+		t.Errorf("Workload qemu settings used in system mode: %+v", q)
+	}
+}
+
+func TestUserDevices(t *testing.T) {
+	in := &types.VMSpec{
+		GPUs:  []types.GPU{{Address: "0000:01:00.0"}},
+		VGPUs: []types.VGPU{{Parent: "0000:00:02.0", Type: "i915-GVTg_V5_4"}},
+	}
+
+	ws := &workspace{}
+	if err := ws.checkDevices(in); err != nil {
+		t.Errorf("Devices of the daemon's user rejected: %v", err)
+	}
+
+	// The users of a system mode daemon may only use the devices assigned
+	// to them.
+	ws.account = &account{name: "alice"}
+	if err := ws.checkDevices(in); err == nil {
+		t.Errorf("Unassigned devices accepted in system mode")
+	}
+	ws.account.devices = []string{"0000:01:00.0"}
+	if err := ws.checkDevices(in); err == nil {
+		t.Errorf("Unassigned vGPU parent accepted in system mode")
+	}
+	ws.account.devices = append(ws.account.devices, "0000:00:02.0")
+	if err := ws.checkDevices(in); err != nil {
+		t.Errorf("Assigned devices rejected: %v", err)
+	}
+
+	cfg := systemConfig{Devices: map[string][]string{"alice": {"01:00.0"}}}
+	if err := cfg.checkDevices(); err == nil {
+		t.Errorf("Invalid device address accepted")
+	}
+}
@@ -0,0 +1,677 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// importVagrant only understands the common settings of the Vagrantfiles of
+// development VMs, one per statement.  Other settings are reported as
+// warnings.
+
+const vagrantScriptDir = "/var/lib/ccloudvm/vagrant"
+
+// vagrantBoxes maps the prefixes of the names of common Vagrant boxes to
+// the built-in workloads of the same distributions.
+var vagrantBoxes = []struct {
+	prefix   string
+	workload string
+}{
+	{"ubuntu/xenial64", "xenial"},
+	{"bento/ubuntu-16.04", "xenial"},
+	{"generic/ubuntu1604", "xenial"},
+	{"ubuntu/artful64", "artful"},
+	{"debian/bookworm64", "debian12"},
+	{"bento/debian-12", "debian12"},
+	{"generic/debian12", "debian12"},
+	{"fedora/40-cloud-base", "fedora40"},
+	{"bento/fedora-40", "fedora40"},
+	{"fedora/27-cloud-base", "fedora27"},
+	{"fedora/25-cloud-base", "fedora25"},
+	{"centos/stream9", "centos-stream9"},
+	{"bento/centos-stream-9", "centos-stream9"},
+	{"generic/centos9s", "centos-stream9"},
+	{"opensuse/Leap-15", "opensuse-leap15"},
+	{"bento/opensuse-leap-15", "opensuse-leap15"},
+	{"generic/opensuse15", "opensuse-leap15"},
+}
+
+func vagrantBoxWorkload(box string) string {
+	for _, b := range vagrantBoxes {
+		if strings.HasPrefix(box, b.prefix) {
+			return b.workload
+		}
+	}
+	return ""
+}
+
+type vagrantProvisioner struct {
+	script     string
+	args       []string
+	privileged bool
+}
+
+type vagrantConfig struct {
+	box          string
+	hostname     string
+	memory       int
+	cpus         int
+	ports        []types.PortMapping
+	syncs        []types.Sync
+	provisioners []vagrantProvisioner
+	warnings     []string
+}
+
+func (vc *vagrantConfig) warnf(format string, args ...interface{}) {
+	vc.warnings = append(vc.warnings, fmt.Sprintf(format, args...))
+}
+
+var (
+	vagrantHeredocRegexp   = regexp.MustCompile(`<<([-~]?)([A-Z_a-z]\w*)`)
+	vagrantVMRegexp        = regexp.MustCompile(`^\w+\.vm\.(\w+)\s*(=)?\s*(.*)$`)
+	vagrantProviderRegexp  = regexp.MustCompile(`^\w+\.(memory|cpus)\s*=\s*(.*)$`)
+	vagrantCustomizeRegexp = regexp.MustCompile(`"--(memory|cpus)"\s*,\s*"?(\d+)"?`)
+	vagrantBlockRegexp     = regexp.MustCompile(`\bdo\s*\|\s*(\w+)\s*\|\s*$`)
+	vagrantSettingRegexp   = regexp.MustCompile(`^(\w+)\.(\w+)\s*=\s*(.*)$`)
+	rubyKeywordRegexp      = regexp.MustCompile(`^(\w+):\s`)
+	rubySymbolKeyRegexp    = regexp.MustCompile(`^:(\w+)\s*=>`)
+	rubyStringKeyRegexp    = regexp.MustCompile(`^("(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*')\s*=>`)
+	rubySymbolRegexp       = regexp.MustCompile(`^:(\w+)`)
+	rubyWordRegexp         = regexp.MustCompile(`^[^\s,\]]+`)
+)
+
+// vagrantStatements returns the statements of a Vagrantfile, one per
+// line, without their comments.  The here documents of a statement are
+// replaced by string literals.
+func vagrantStatements(data []byte) []string {
+	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	var stmts []string
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(stripRubyComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		m := vagrantHeredocRegexp.FindStringSubmatchIndex(line)
+		if m == nil {
+			stmts = append(stmts, line)
+			continue
+		}
+		id := line[m[4]:m[5]]
+		var body []string
+		for i++; i < len(lines); i++ {
+			if strings.TrimSpace(lines[i]) == id {
+				break
+			}
+			body = append(body, lines[i])
+		}
+		doc := strconv.Quote(dedent(body))
+		stmts = append(stmts, line[:m[0]]+doc+line[m[1]:])
+	}
+	return stmts
+}
+
+// stripRubyComment removes the comment, if any, from a line of Ruby.
+func stripRubyComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// dedent joins lines after removing their common indentation.
+func dedent(lines []string) string {
+	indent := -1
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		n := len(l) - len(strings.TrimLeft(l, " \t"))
+		if indent == -1 || n < indent {
+			indent = n
+		}
+	}
+	var buf bytes.Buffer
+	for _, l := range lines {
+		if len(l) >= indent && indent > 0 {
+			l = l[indent:]
+		}
+		_, _ = buf.WriteString(strings.TrimRight(l, " \t"))
+		_ = buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// rubyArgs parses the arguments of a method call, e.g.,
+// "forwarded_port", guest: 80, host: 8080.  Strings, symbols, integers,
+// booleans and arrays of them are understood.  Keyword arguments are
+// returned in kw.  Parsing stops at a do block.
+func rubyArgs(s string) (pos []interface{}, kw map[string]interface{}, err error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "(") {
+		if end := strings.LastIndex(s, ")"); end > 0 {
+			s = s[1:end] + s[end+1:]
+		}
+	}
+	kw = make(map[string]interface{})
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" || s == "do" || strings.HasPrefix(s, "do ") || strings.HasPrefix(s, "do|") {
+			return pos, kw, nil
+		}
+
+		var key string
+		if m := rubyKeywordRegexp.FindStringSubmatch(s + " "); m != nil {
+			key = m[1]
+			s = s[len(m[1])+1:]
+		} else if m := rubySymbolKeyRegexp.FindStringSubmatch(s); m != nil {
+			key = m[1]
+			s = s[len(m[0]):]
+		} else if m := rubyStringKeyRegexp.FindStringSubmatch(s); m != nil {
+			v, _, err := rubyValue(m[1])
+			if err != nil {
+				return nil, nil, err
+			}
+			key = v.(string)
+			s = s[len(m[0]):]
+		}
+
+		v, rest, err := rubyValue(strings.TrimSpace(s))
+		if err != nil {
+			return nil, nil, err
+		}
+		s = rest
+		if key != "" {
+			kw[key] = v
+		} else {
+			pos = append(pos, v)
+		}
+	}
+}
+
+// rubyValue parses the value at the start of s and returns it with the
+// rest of s.
+func rubyValue(s string) (interface{}, string, error) {
+	switch {
+	case s == "":
+		return nil, "", errors.New("Missing value")
+	case s[0] == '"' || s[0] == '\'':
+		return rubyString(s)
+	case s[0] == ':':
+		m := rubySymbolRegexp.FindStringSubmatch(s)
+		if m == nil {
+			return nil, "", errors.Errorf("Unsupported value %s", s)
+		}
+		return m[1], s[len(m[0]):], nil
+	case s[0] == '[':
+		var list []interface{}
+		s = s[1:]
+		for {
+			s = strings.TrimLeft(s, " \t,")
+			if strings.HasPrefix(s, "]") {
+				return list, s[1:], nil
+			}
+			v, rest, err := rubyValue(s)
+			if err != nil {
+				return nil, "", err
+			}
+			list = append(list, v)
+			s = rest
+		}
+	}
+
+	m := rubyWordRegexp.FindString(s)
+	rest := s[len(m):]
+	if n, err := strconv.Atoi(m); err == nil {
+		return n, rest, nil
+	}
+	switch m {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	case "nil":
+		return nil, rest, nil
+	}
+	return nil, "", errors.Errorf("Unsupported value %s", m)
+}
+
+// rubyString parses the string literal at the start of s.  The
+// interpolations of double quoted strings are not evaluated.
+func rubyString(s string) (interface{}, string, error) {
+	quote := s[0]
+	var buf bytes.Buffer
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote:
+			return buf.String(), s[i+1:], nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			c = s[i]
+			if quote == '"' {
+				switch c {
+				case 'n':
+					c = '\n'
+				case 't':
+					c = '\t'
+				}
+			} else if c != '\'' && c != '\\' {
+				_ = buf.WriteByte('\\')
+			}
+		}
+		_ = buf.WriteByte(c)
+	}
+	return nil, "", errors.Errorf("Unterminated string %s", s)
+}
+
+func rubyInt(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case string:
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func rubyStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, e := range v {
+			list = append(list, fmt.Sprint(e))
+		}
+		return list
+	}
+	return nil
+}
+
+// parseVagrantfile parses the Vagrantfile data stored in dir.  The
+// statements that cannot be parsed are reported as warnings.
+func parseVagrantfile(data []byte, dir string) *vagrantConfig {
+	vc := &vagrantConfig{}
+	defaultSync := true
+
+	// block is the variable of the do block of the shell provisioner
+	// being parsed, if any, and prov its settings.
+	var block string
+	var prov map[string]interface{}
+
+	for _, stmt := range vagrantStatements(data) {
+		if block != "" {
+			if stmt == "end" {
+				vc.addProvisioner(prov, dir)
+				block = ""
+				continue
+			}
+			m := vagrantSettingRegexp.FindStringSubmatch(stmt)
+			if m == nil || m[1] != block {
+				vc.warnf("Ignoring %s", stmt)
+				continue
+			}
+			v, _, err := rubyValue(strings.TrimSpace(m[3]))
+			if err != nil {
+				vc.warnf("Ignoring %s: %v", stmt, err)
+				continue
+			}
+			prov[m[2]] = v
+			continue
+		}
+
+		if m := vagrantProviderRegexp.FindStringSubmatch(stmt); m != nil {
+			v, _, err := rubyValue(strings.TrimSpace(m[2]))
+			n, ok := rubyInt(v)
+			if err != nil || !ok {
+				vc.warnf("Ignoring %s: unsupported value", stmt)
+				continue
+			}
+			if m[1] == "memory" {
+				vc.memory = n
+			} else {
+				vc.cpus = n
+			}
+			continue
+		}
+		if strings.Contains(stmt, ".customize") {
+			for _, m := range vagrantCustomizeRegexp.FindAllStringSubmatch(stmt, -1) {
+				n, _ := strconv.Atoi(m[2])
+				if m[1] == "memory" {
+					vc.memory = n
+				} else {
+					vc.cpus = n
+				}
+			}
+			continue
+		}
+
+		m := vagrantVMRegexp.FindStringSubmatch(stmt)
+		if m == nil {
+			continue
+		}
+		setting, assign, rest := m[1], m[2] != "", m[3]
+		pos, kw, err := rubyArgs(rest)
+		if err != nil {
+			vc.warnf("Ignoring %s: %v", stmt, err)
+			continue
+		}
+		first := ""
+		if len(pos) > 0 {
+			first = fmt.Sprint(pos[0])
+		}
+
+		switch {
+		case setting == "box" && assign:
+			vc.box = first
+		case setting == "hostname" && assign:
+			vc.hostname = first
+		case setting == "provider":
+		case setting == "network" && first == "forwarded_port":
+			guest, gok := rubyInt(kw["guest"])
+			host, hok := rubyInt(kw["host"])
+			if !gok || !hok {
+				vc.warnf("Ignoring %s: guest or host port missing", stmt)
+				continue
+			}
+			if p, ok := kw["protocol"]; ok && p != "tcp" {
+				vc.warnf("Ignoring %s: only TCP ports can be forwarded", stmt)
+				continue
+			}
+			pm := types.PortMapping{Host: host, Guest: guest}
+			if addr, ok := kw["host_ip"].(string); ok {
+				pm.HostAddr = addr
+			}
+			vc.ports = append(vc.ports, pm)
+		case setting == "synced_folder" && len(pos) == 2:
+			source, _ := pos[0].(string)
+			target, _ := pos[1].(string)
+			if source == "" || target == "" {
+				vc.warnf("Ignoring %s: source or target missing", stmt)
+				continue
+			}
+			if !filepath.IsAbs(source) {
+				source = filepath.Join(dir, source)
+			}
+			source = filepath.Clean(source)
+			if path.Clean(target) == "/vagrant" && source == filepath.Clean(dir) {
+				defaultSync = false
+			}
+			if disabled, _ := kw["disabled"].(bool); disabled {
+				continue
+			}
+			if fi, err := os.Stat(source); err != nil || !fi.IsDir() {
+				vc.warnf("Ignoring %s: %s is not a directory", stmt, source)
+				continue
+			}
+			vc.syncs = append(vc.syncs, types.Sync{
+				Source:  source,
+				Target:  path.Clean(target),
+				Exclude: rubyStrings(kw["rsync__exclude"]),
+			})
+		case setting == "provision" && first == "shell":
+			if bm := vagrantBlockRegexp.FindStringSubmatch(stmt); bm != nil {
+				block = bm[1]
+				prov = kw
+				continue
+			}
+			vc.addProvisioner(kw, dir)
+		case setting == "provision":
+			vc.warnf("Ignoring %s: only shell provisioners are supported", stmt)
+		case setting == "define":
+			vc.warnf("Multi-machine Vagrantfiles are not supported: the settings of all the machines are merged")
+		default:
+			vc.warnf("Ignoring %s", stmt)
+		}
+	}
+
+	if defaultSync {
+		vc.syncs = append([]types.Sync{{
+			Source:  filepath.Clean(dir),
+			Target:  "/vagrant",
+			Exclude: []string{".vagrant/"},
+		}}, vc.syncs...)
+	}
+	return vc
+}
+
+// addProvisioner adds the shell provisioner whose settings are prov.
+func (vc *vagrantConfig) addProvisioner(prov map[string]interface{}, dir string) {
+	p := vagrantProvisioner{privileged: true}
+	if privileged, ok := prov["privileged"].(bool); ok {
+		p.privileged = privileged
+	}
+	p.args = rubyStrings(prov["args"])
+
+	if inline, ok := prov["inline"].(string); ok {
+		p.script = inline
+	} else if scriptPath, ok := prov["path"].(string); ok {
+		if !filepath.IsAbs(scriptPath) {
+			scriptPath = filepath.Join(dir, scriptPath)
+		}
+		data, err := ioutil.ReadFile(scriptPath)
+		if err != nil {
+			vc.warnf("Ignoring shell provisioner: %v", err)
+			return
+		}
+		p.script = string(data)
+	} else {
+		vc.warnf("Ignoring shell provisioner with neither inline nor path")
+		return
+	}
+
+	for _, k := range []string{"env", "upload_path", "binary", "reboot", "run"} {
+		if _, ok := prov[k]; ok {
+			vc.warnf("Ignoring the %s setting of a shell provisioner", k)
+		}
+	}
+	vc.provisioners = append(vc.provisioners, p)
+}
+
+// vagrantWorkloadVM is the part of the VM spec of the workload created
+// from a Vagrantfile.
+type vagrantWorkloadVM struct {
+	MemMiB       int                 `yaml:"mem_mib,omitempty"`
+	CPUs         int                 `yaml:"cpus,omitempty"`
+	Hostname     string              `yaml:"hostname,omitempty"`
+	PortMappings []types.PortMapping `yaml:"ports,omitempty"`
+	Syncs        []types.Sync        `yaml:"syncs,omitempty"`
+}
+
+// workload returns the workload, inheriting from parent, that corresponds
+// to the Vagrantfile.
+func (vc *vagrantConfig) workload(parent string) ([]byte, error) {
+	spec := struct {
+		Inherits string            `yaml:"inherits"`
+		VM       vagrantWorkloadVM `yaml:"vm"`
+	}{
+		Inherits: parent,
+		VM: vagrantWorkloadVM{
+			MemMiB:       vc.memory,
+			CPUs:         vc.cpus,
+			Hostname:     vc.hostname,
+			PortMappings: vc.ports,
+			Syncs:        vc.syncs,
+		},
+	}
+	data, err := yaml.Marshal(&spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to marshal instance specification")
+	}
+
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("---\n")
+	_, _ = buf.Write(data)
+	_, _ = buf.WriteString("...\n---\n#cloud-config\n")
+
+	if len(vc.provisioners) > 0 {
+		_, _ = buf.WriteString("write_files:\n")
+		for i, p := range vc.provisioners {
+			fmt.Fprintf(&buf, " - path: %s/provision-%d.sh\n", vagrantScriptDir, i+1)
+			fmt.Fprintf(&buf, "   permissions: '0755'\n")
+			fmt.Fprintf(&buf, "   encoding: b64\n")
+			fmt.Fprintf(&buf, "   content: %s\n", base64.StdEncoding.EncodeToString([]byte(p.script)))
+		}
+	}
+
+	var dirs []string
+	for _, s := range vc.syncs {
+		if !strings.HasPrefix(s.Target, "/home/") {
+			dirs = append(dirs, shellQuote(s.Target))
+		}
+	}
+	if len(dirs) == 0 && len(vc.provisioners) == 0 {
+		_, _ = buf.WriteString("...\n")
+		return buf.Bytes(), nil
+	}
+
+	_, _ = buf.WriteString("runcmd:\n")
+	if len(dirs) > 0 {
+		// The syncs run as the user, who cannot create their targets
+		// outside of their home directory.
+		cmd := fmt.Sprintf("mkdir -p %s && chown {{.User}} %s",
+			strings.Join(dirs, " "), strings.Join(dirs, " "))
+		fmt.Fprintf(&buf, " - %s\n", strconv.Quote(cmd))
+	}
+	for i, p := range vc.provisioners {
+		script := fmt.Sprintf("%s/provision-%d.sh", vagrantScriptDir, i+1)
+		if !strings.HasPrefix(p.script, "#!") {
+			script = "bash " + script
+		}
+		for _, a := range p.args {
+			script += " " + shellQuote(a)
+		}
+		if !p.privileged {
+			script = "sudo -u {{.User}} -i " + script
+		}
+		fmt.Fprintf(&buf, " - {{beginTask . %q}}\n", fmt.Sprintf("Running shell provisioner %d", i+1))
+		fmt.Fprintf(&buf, " - %s\n", strconv.Quote(script))
+		fmt.Fprintf(&buf, " - {{endTaskCheck .}}\n")
+	}
+	_, _ = buf.WriteString("...\n")
+	return buf.Bytes(), nil
+}
+
+// importVagrant creates a workload from the Vagrantfile args.Path, or
+// from the Vagrantfile of the directory args.Path.
+func (c ccvmBackend) importVagrant(ctx context.Context, args *types.ImportVagrantArgs) (*types.ImportVagrantResult, error) {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	vagrantfile := args.Path
+	if fi, err := os.Stat(vagrantfile); err == nil && fi.IsDir() {
+		vagrantfile = filepath.Join(vagrantfile, "Vagrantfile")
+	}
+	if err := ws.checkUserPath(vagrantfile); err != nil {
+		return nil, err
+	}
+
+	return importVagrantfile(ws.ccvmDir, vagrantfile, args.Name, args.Inherits)
+}
+
+// importVagrantfile creates the workload name, which defaults to the name
+// of the directory of the Vagrantfile, from vagrantfile.  The workload
+// inherits from parent, which defaults to the built-in workload of the
+// box of the Vagrantfile.
+func importVagrantfile(ccvmDir, vagrantfile, name, parent string) (*types.ImportVagrantResult, error) {
+	data, err := ioutil.ReadFile(vagrantfile)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read Vagrantfile")
+	}
+	dir := filepath.Dir(vagrantfile)
+	if name == "" {
+		name = filepath.Base(dir)
+	}
+	if !hostnameRegexp.MatchString(name) {
+		return nil, errors.Errorf("Invalid workload name %s", name)
+	}
+
+	vc := parseVagrantfile(data, dir)
+	if parent == "" {
+		if vc.box == "" {
+			return nil, errors.New("The Vagrantfile does not specify a box.  Specify the workload to inherit from with --inherits")
+		}
+		parent = vagrantBoxWorkload(vc.box)
+		if parent == "" {
+			return nil, errors.Errorf("No workload corresponds to the box %s.  Specify the workload to inherit from with --inherits", vc.box)
+		}
+	}
+
+	workload, err := vc.workload(parent)
+	if err != nil {
+		return nil, err
+	}
+
+	workloadsDir := path.Join(ccvmDir, "workloads")
+	workloadPath := path.Join(workloadsDir, name+".yaml")
+	if _, err := os.Stat(workloadPath); err == nil {
+		return nil, errors.Errorf("Workload %s already exists", name)
+	}
+	if err := os.MkdirAll(workloadsDir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "Unable to create directory %s", workloadsDir)
+	}
+	if err := writeFileAtomic(workloadPath, workload); err != nil {
+		return nil, err
+	}
+
+	return &types.ImportVagrantResult{
+		Name:     name,
+		Inherits: parent,
+		Warnings: vc.warnings,
+	}, nil
+}
+
+func (s *ccvmService) importVagrant(ctx context.Context, args *types.ImportVagrantArgs, resultCh chan interface{}) {
+	go func() {
+		res, err := s.b.importVagrant(ctx, args)
+		if err != nil {
+			resultCh <- err
+		} else {
+			resultCh <- res
+		}
+		close(resultCh)
+	}()
+}
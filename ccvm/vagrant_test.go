@@ -0,0 +1,187 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const testVagrantfile = `# -*- mode: ruby -*-
+Vagrant.configure("2") do |config|
+  config.vm.box = "ubuntu/xenial64"
+  config.vm.hostname = "devbox"
+  config.vm.network "forwarded_port", guest: 80, host: 8080
+  config.vm.network :forwarded_port, :guest => 5432, :host => 15432, host_ip: "127.0.0.1"
+  config.vm.network "private_network", ip: "192.168.33.10"
+  config.vm.synced_folder "src", "/home/vagrant/src", rsync__exclude: [".git/", "node_modules/"]
+
+  config.vm.provider "virtualbox" do |vb|
+    vb.memory = "2048" # MiB
+    vb.cpus = 2
+  end
+
+  config.vm.provision "shell", inline: <<-SHELL
+    apt-get update
+    apt-get install -y "postgresql"
+  SHELL
+  config.vm.provision "shell", path: "bootstrap.sh", privileged: false
+  config.vm.provision "shell" do |s|
+    s.inline = "echo $1"
+    s.args = ["hello # world"]
+  end
+  config.vm.provision "ansible", playbook: "site.yml"
+end
+`
+
+func TestImportVagrantfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-vagrant-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	project := filepath.Join(dir, "myproject")
+	ccvmDir := filepath.Join(dir, ".ccloudvm")
+	if err := os.MkdirAll(filepath.Join(project, "src"), 0755); err != nil {
+		t.Fatalf("Unable to create project: %v", err)
+	}
+	vagrantfile := filepath.Join(project, "Vagrantfile")
+	if err := ioutil.WriteFile(vagrantfile, []byte(testVagrantfile), 0644); err != nil {
+		t.Fatalf("Unable to write Vagrantfile: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(project, "bootstrap.sh"), []byte("#!/bin/sh\nmake\n"), 0755)
+	if err != nil {
+		t.Fatalf("Unable to write script: %v", err)
+	}
+
+	res, err := importVagrantfile(ccvmDir, vagrantfile, "", "")
+	if err != nil {
+		t.Fatalf("Unable to import Vagrantfile: %v", err)
+	}
+	if res.Name != "myproject" || res.Inherits != "xenial" {
+		t.Errorf("Unexpected result %+v", res)
+	}
+	if len(res.Warnings) != 2 {
+		t.Errorf("Expected warnings for the private network and ansible, got %v", res.Warnings)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(ccvmDir, "workloads", "myproject.yaml"))
+	if err != nil {
+		t.Fatalf("Unable to read workload: %v", err)
+	}
+	docs := splitYaml(data)
+	if len(docs) != 2 {
+		t.Fatalf("Expected two documents in %s", data)
+	}
+
+	var spec workloadSpec
+	if err := yaml.Unmarshal(docs[0], &spec); err != nil {
+		t.Fatalf("Unable to parse instance specification: %v", err)
+	}
+	vm := &spec.VM
+	if len(spec.Inherits) != 1 || spec.Inherits[0] != "xenial" || vm.MemMiB != 2048 ||
+		vm.CPUs != 2 || vm.Hostname != "devbox" {
+		t.Errorf("Unexpected instance specification %+v", spec)
+	}
+	if len(vm.PortMappings) != 2 || vm.PortMappings[0].Host != 8080 || vm.PortMappings[0].Guest != 80 ||
+		vm.PortMappings[1].HostAddr != "127.0.0.1" || vm.PortMappings[1].Guest != 5432 {
+		t.Errorf("Unexpected ports %+v", vm.PortMappings)
+	}
+	if len(vm.Syncs) != 2 || vm.Syncs[0].Target != "/vagrant" || vm.Syncs[0].Source != project ||
+		vm.Syncs[1].Source != filepath.Join(project, "src") || len(vm.Syncs[1].Exclude) != 2 {
+		t.Errorf("Unexpected syncs %+v", vm.Syncs)
+	}
+
+	tmpl, err := template.New("user-data").Funcs(template.FuncMap{
+		"beginTask":    func(interface{}, string) string { return "'begin'" },
+		"endTaskCheck": func(interface{}) string { return "end" },
+	}).Parse(string(docs[1]))
+	if err != nil {
+		t.Fatalf("Unable to parse cloud-init template: %v", err)
+	}
+	var userData bytes.Buffer
+	if err := tmpl.Execute(&userData, struct{ User string }{"user"}); err != nil {
+		t.Fatalf("Unable to execute cloud-init template: %v", err)
+	}
+	var cc struct {
+		WriteFiles []struct {
+			Path    string `yaml:"path"`
+			Content string `yaml:"content"`
+		} `yaml:"write_files"`
+		Runcmd []string `yaml:"runcmd"`
+	}
+	if err := yaml.Unmarshal(userData.Bytes(), &cc); err != nil {
+		t.Fatalf("Unable to parse cloud-init document: %v", err)
+	}
+	if len(cc.WriteFiles) != 3 {
+		t.Fatalf("Expected 3 scripts, got %+v", cc.WriteFiles)
+	}
+	script, _ := base64.StdEncoding.DecodeString(cc.WriteFiles[0].Content)
+	if string(script) != "apt-get update\napt-get install -y \"postgresql\"\n" {
+		t.Errorf("Unexpected inline script %q", script)
+	}
+
+	runcmd := strings.Join(cc.Runcmd, "\n")
+	for _, want := range []string{
+		"mkdir -p '/vagrant' && chown user '/vagrant'",
+		"bash /var/lib/ccloudvm/vagrant/provision-1.sh",
+		"sudo -u user -i /var/lib/ccloudvm/vagrant/provision-2.sh",
+		"bash /var/lib/ccloudvm/vagrant/provision-3.sh 'hello # world'",
+	} {
+		if !strings.Contains(runcmd, want) {
+			t.Errorf("%s not found in %s", want, runcmd)
+		}
+	}
+
+	if _, err := importVagrantfile(ccvmDir, vagrantfile, "", ""); err == nil {
+		t.Errorf("Expected an error when importing an existing workload")
+	}
+}
+
+func TestImportVagrantfileUnknownBox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-vagrant-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	vagrantfile := filepath.Join(dir, "Vagrantfile")
+	data := "Vagrant.configure(\"2\") do |config|\n  config.vm.box = \"hashicorp/precise64\"\nend\n"
+	if err := ioutil.WriteFile(vagrantfile, []byte(data), 0644); err != nil {
+		t.Fatalf("Unable to write Vagrantfile: %v", err)
+	}
+
+	if _, err := importVagrantfile(dir, vagrantfile, "precise", ""); err == nil {
+		t.Errorf("Expected an error for an unknown box")
+	}
+	res, err := importVagrantfile(dir, vagrantfile, "precise", "xenial")
+	if err != nil {
+		t.Fatalf("Unable to import Vagrantfile: %v", err)
+	}
+	if res.Inherits != "xenial" || len(res.Warnings) != 0 {
+		t.Errorf("Unexpected result %+v", res)
+	}
+}
@@ -0,0 +1,258 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The GPUs of an instance are bound to vfio-pci when its VM boots and given
+// back to their drivers when it exits.  They are recorded in vfioFile so
+// that they can be restored after a restart of the daemon.
+
+const vfioFile = "vfio"
+
+const vfioDriver = "vfio-pci"
+
+var (
+	pciBusDir      = "/sys/bus/pci"
+	iommuGroupsDir = "/sys/kernel/iommu_groups"
+	vfioDevDir     = "/dev/vfio"
+)
+
+func pciDevicePath(address string) string {
+	return path.Join(pciBusDir, "devices", address)
+}
+
+// pciDriver returns the name of the driver bound to the PCI device at
+// address, or an empty string if the device is not bound to any driver.
+func pciDriver(address string) string {
+	link, err := os.Readlink(path.Join(pciDevicePath(address), "driver"))
+	if err != nil {
+		return ""
+	}
+	return path.Base(link)
+}
+
+// pciBridge returns true if the PCI device at address is a PCI bridge.
+// Bridges do not prevent the other devices of their IOMMU group from
+// being assigned to a VM.
+func pciBridge(address string) bool {
+	data, err := ioutil.ReadFile(path.Join(pciDevicePath(address), "class"))
+	return err == nil && strings.HasPrefix(strings.TrimSpace(string(data)), "0x0604")
+}
+
+func iommuGroup(address string) (string, error) {
+	if _, err := os.Stat(pciDevicePath(address)); err != nil {
+		return "", errors.Errorf("There is no PCI device at %s", address)
+	}
+
+	link, err := os.Readlink(path.Join(pciDevicePath(address), "iommu_group"))
+	if err != nil {
+		return "", errors.Errorf("%s does not belong to an IOMMU group.  "+
+			"Enable VT-d or AMD-Vi in the firmware and boot the host with intel_iommu=on or amd_iommu=on",
+			address)
+	}
+	return path.Base(link), nil
+}
+
+// checkIOMMUGroups verifies that each of gpus can be assigned to a VM.  The
+// kernel only allows a device to be assigned to a VM if every other
+// device of its IOMMU group is a bridge, is not bound to a driver or is
+// bound to vfio-pci or pci-stub.
+func checkIOMMUGroups(gpus []types.GPU) error {
+	assigned := make(map[string]bool)
+	for _, g := range gpus {
+		assigned[g.Address] = true
+	}
+
+	for _, g := range gpus {
+		group, err := iommuGroup(g.Address)
+		if err != nil {
+			return err
+		}
+
+		devices, err := ioutil.ReadDir(path.Join(iommuGroupsDir, group, "devices"))
+		if err != nil {
+			return errors.Wrapf(err, "Unable to read IOMMU group %s", group)
+		}
+		for _, d := range devices {
+			address := d.Name()
+			if assigned[address] || pciBridge(address) {
+				continue
+			}
+			driver := pciDriver(address)
+			if driver == "" || driver == vfioDriver || driver == "pci-stub" {
+				continue
+			}
+			return errors.Errorf("%s shares IOMMU group %s with %s, which is bound to %s.  "+
+				"Devices in the same IOMMU group must be assigned to the same VM, so %s must also be listed in gpus",
+				g.Address, group, address, driver, address)
+		}
+	}
+
+	return nil
+}
+
+func loadVFIO(instanceDir string) map[string]string {
+	data, err := ioutil.ReadFile(path.Join(instanceDir, vfioFile))
+	if err != nil {
+		return nil
+	}
+
+	devices := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		driver := ""
+		if len(fields) > 1 {
+			driver = fields[1]
+		}
+		devices[fields[0]] = driver
+	}
+	return devices
+}
+
+func saveVFIO(instanceDir string, devices map[string]string) error {
+	var buf bytes.Buffer
+	for address, driver := range devices {
+		fmt.Fprintf(&buf, "%s %s\n", address, driver)
+	}
+	err := ioutil.WriteFile(path.Join(instanceDir, vfioFile), []byte(buf.String()), 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to record GPUs")
+	}
+	return nil
+}
+
+func writeSysfs(file, value string) error {
+	return ioutil.WriteFile(file, []byte(value), 0200)
+}
+
+// bindVFIO binds each of gpus to the vfio-pci driver.  Any devices left
+// bound by a previous boot are restored first.
+func bindVFIO(instanceDir string, gpus []types.GPU) error {
+	releaseVFIO(instanceDir)
+
+	if len(gpus) == 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(path.Join(pciBusDir, "drivers", vfioDriver)); err != nil {
+		return errors.New("The vfio-pci driver is not loaded.  Load it with modprobe vfio-pci")
+	}
+
+	if err := checkIOMMUGroups(gpus); err != nil {
+		return err
+	}
+
+	devices := make(map[string]string)
+	for _, g := range gpus {
+		driver := pciDriver(g.Address)
+		if driver == vfioDriver {
+			continue
+		}
+
+		devices[g.Address] = driver
+		if err := saveVFIO(instanceDir, devices); err != nil {
+			releaseVFIO(instanceDir)
+			return err
+		}
+
+		if err := bindDriver(g.Address, driver); err != nil {
+			releaseVFIO(instanceDir)
+			return err
+		}
+	}
+
+	for _, g := range gpus {
+		group, err := iommuGroup(g.Address)
+		if err != nil {
+			releaseVFIO(instanceDir)
+			return err
+		}
+		f, err := os.OpenFile(path.Join(vfioDevDir, group), os.O_RDWR, 0)
+		if err != nil {
+			releaseVFIO(instanceDir)
+			return errors.Wrapf(err, "Unable to access IOMMU group %s of %s.  "+
+				"The user running the daemon must be allowed to read and write %s",
+				group, g.Address, path.Join(vfioDevDir, group))
+		}
+		_ = f.Close()
+	}
+
+	return nil
+}
+
+// bindDriver moves the PCI device at address from driver to vfio-pci.
+func bindDriver(address, driver string) error {
+	devicePath := pciDevicePath(address)
+	err := writeSysfs(path.Join(devicePath, "driver_override"), vfioDriver)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to bind %s to vfio-pci", address)
+	}
+	if driver != "" {
+		err = writeSysfs(path.Join(devicePath, "driver", "unbind"), address)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to unbind %s from %s", address, driver)
+		}
+	}
+	err = writeSysfs(path.Join(pciBusDir, "drivers_probe"), address)
+	if err != nil || pciDriver(address) != vfioDriver {
+		return errors.Errorf("Unable to bind %s to vfio-pci", address)
+	}
+	return nil
+}
+
+// releaseVFIO unbinds the GPUs of an instance from vfio-pci and lets the
+// kernel probe them again, which binds them back to their original
+// drivers.
+func releaseVFIO(instanceDir string) {
+	for address, driver := range loadVFIO(instanceDir) {
+		devicePath := pciDevicePath(address)
+		err := writeSysfs(path.Join(devicePath, "driver_override"), "\n")
+		if err == nil && pciDriver(address) == vfioDriver {
+			err = writeSysfs(path.Join(devicePath, "driver", "unbind"), address)
+		}
+		if err == nil && driver != "" {
+			err = writeSysfs(path.Join(pciBusDir, "drivers_probe"), address)
+		}
+		if err != nil {
+			instanceDirLog(instanceDir).Warningf("Unable to restore driver of %s: %v", address, err)
+		}
+	}
+	_ = os.Remove(path.Join(instanceDir, vfioFile))
+}
+
+// vfioArgs returns the qemu arguments that pass gpus through to a VM.
+func vfioArgs(gpus []types.GPU) []string {
+	var args []string
+	for _, g := range gpus {
+		args = append(args, "-device", fmt.Sprintf("vfio-pci,host=%s", g.Address))
+	}
+	return args
+}
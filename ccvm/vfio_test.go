@@ -0,0 +1,171 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestGPUCheck(t *testing.T) {
+	if err := (types.GPU{Address: "0000:01:00.0"}).Check(); err != nil {
+		t.Errorf("Valid GPU rejected: %v", err)
+	}
+
+	for _, address := range []string{"", "01:00.0", "0000:01:00.8", "../0000:01:00.0"} {
+		if err := (types.GPU{Address: address}).Check(); err == nil {
+			t.Errorf("Expected error for %q", address)
+		}
+	}
+}
+
+func bindFakeDriver(t *testing.T, address, driver string) {
+	link := path.Join(pciDevicePath(address), "driver")
+	_ = os.Remove(link)
+	if err := os.Symlink(path.Join(pciBusDir, "drivers", driver), link); err != nil {
+		t.Fatalf("Unable to bind %s to %s: %v", address, driver, err)
+	}
+}
+
+func TestVFIO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	savedBusDir, savedGroupsDir, savedDevDir := pciBusDir, iommuGroupsDir, vfioDevDir
+	pciBusDir = path.Join(dir, "pci")
+	iommuGroupsDir = path.Join(dir, "iommu_groups")
+	vfioDevDir = path.Join(dir, "vfio")
+	defer func() {
+		pciBusDir, iommuGroupsDir, vfioDevDir = savedBusDir, savedGroupsDir, savedDevDir
+	}()
+
+	// The GPU and its audio function share IOMMU group 1 with a bridge.
+
+	instanceDir := path.Join(dir, "instance")
+	groupDir := path.Join(iommuGroupsDir, "1", "devices")
+	devices := map[string]string{
+		"0000:00:01.0": "0x060400",
+		"0000:01:00.0": "0x030000",
+		"0000:01:00.1": "0x040300",
+	}
+	dirs := []string{instanceDir, groupDir, vfioDevDir}
+	for _, driver := range []string{"nvidia", "snd_hda_intel", "pcieport"} {
+		dirs = append(dirs, path.Join(pciBusDir, "drivers", driver))
+	}
+	for address := range devices {
+		dirs = append(dirs, pciDevicePath(address))
+	}
+	for _, d := range dirs {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatalf("Unable to create %s: %v", d, err)
+		}
+	}
+	for address, class := range devices {
+		err := ioutil.WriteFile(path.Join(pciDevicePath(address), "class"), []byte(class+"\n"), 0600)
+		if err != nil {
+			t.Fatalf("Unable to write class: %v", err)
+		}
+		if err := os.Symlink(pciDevicePath(address), path.Join(groupDir, address)); err != nil {
+			t.Fatalf("Unable to add %s to group: %v", address, err)
+		}
+		err = os.Symlink(path.Join(iommuGroupsDir, "1"), path.Join(pciDevicePath(address), "iommu_group"))
+		if err != nil {
+			t.Fatalf("Unable to set group of %s: %v", address, err)
+		}
+	}
+	bindFakeDriver(t, "0000:00:01.0", "pcieport")
+	bindFakeDriver(t, "0000:01:00.0", "nvidia")
+	bindFakeDriver(t, "0000:01:00.1", "snd_hda_intel")
+
+	gpu := []types.GPU{{Address: "0000:01:00.0"}}
+	gpus := []types.GPU{{Address: "0000:01:00.0"}, {Address: "0000:01:00.1"}}
+
+	if err := bindVFIO(instanceDir, gpus); err == nil || !strings.Contains(err.Error(), "modprobe") {
+		t.Errorf("Expected error when vfio-pci is not loaded: %v", err)
+	}
+	if err := os.MkdirAll(path.Join(pciBusDir, "drivers", vfioDriver), 0700); err != nil {
+		t.Fatalf("Unable to create vfio-pci driver: %v", err)
+	}
+
+	if err := bindVFIO(instanceDir, []types.GPU{{Address: "0000:02:00.0"}}); err == nil {
+		t.Errorf("Expected error for missing device")
+	}
+	if err := bindVFIO(instanceDir, gpu); err == nil || !strings.Contains(err.Error(), "0000:01:00.1") {
+		t.Errorf("Expected IOMMU group error: %v", err)
+	}
+
+	// The fake drivers_probe does not bind the devices to vfio-pci, so
+	// binding fails and the original drivers are restored.
+
+	err = bindVFIO(instanceDir, gpus)
+	if err == nil {
+		t.Errorf("Expected error when device is not bound to vfio-pci")
+	}
+	data, _ := ioutil.ReadFile(path.Join(pciDevicePath("0000:01:00.0"), "driver_override"))
+	if string(data) != "\n" {
+		t.Errorf("driver_override not cleared: %q", data)
+	}
+	data, _ = ioutil.ReadFile(path.Join(pciBusDir, "drivers", "nvidia", "unbind"))
+	if string(data) != "0000:01:00.0" {
+		t.Errorf("Device not unbound from its driver: %q", data)
+	}
+	if recorded := loadVFIO(instanceDir); len(recorded) != 0 {
+		t.Errorf("GPUs still recorded after failure: %v", recorded)
+	}
+
+	bindFakeDriver(t, "0000:01:00.0", vfioDriver)
+	bindFakeDriver(t, "0000:01:00.1", vfioDriver)
+	if err := bindVFIO(instanceDir, gpus); err == nil || !strings.Contains(err.Error(), "IOMMU group 1") {
+		t.Errorf("Expected error when IOMMU group is not accessible: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(vfioDevDir, "1"), nil, 0600); err != nil {
+		t.Fatalf("Unable to create IOMMU group device: %v", err)
+	}
+	if err := bindVFIO(instanceDir, gpus); err != nil {
+		t.Fatalf("Unable to bind GPUs: %v", err)
+	}
+	if recorded := loadVFIO(instanceDir); len(recorded) != 0 {
+		t.Errorf("GPUs already bound to vfio-pci recorded: %v", recorded)
+	}
+
+	args := vfioArgs(gpus)
+	expected := "-device vfio-pci,host=0000:01:00.0 -device vfio-pci,host=0000:01:00.1"
+	if strings.Join(args, " ") != expected {
+		t.Errorf("Unexpected arguments %v", args)
+	}
+
+	if err := saveVFIO(instanceDir, map[string]string{"0000:01:00.0": "nvidia"}); err != nil {
+		t.Fatalf("Unable to record GPUs: %v", err)
+	}
+	releaseVFIO(instanceDir)
+	data, _ = ioutil.ReadFile(path.Join(pciBusDir, "drivers_probe"))
+	if string(data) != "0000:01:00.0" {
+		t.Errorf("Device not probed again: %q", data)
+	}
+	if _, err := os.Stat(path.Join(instanceDir, vfioFile)); !os.IsNotExist(err) {
+		t.Errorf("GPU record not removed")
+	}
+}
@@ -0,0 +1,75 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// A virtiofsd process is started for each mount shared with a guest using
+// virtio-fs.  The processes are named after the tags of their mounts.
+
+func virtiofsdName(tag string) string {
+	return "virtiofsd-" + tag
+}
+
+func virtiofsDeviceID(tag string) string {
+	return "fs-" + tag
+}
+
+func startVirtiofsd(instanceDir string, m *types.Mount) (string, error) {
+	name := virtiofsdName(m.Tag)
+	socket := path.Join(instanceDir, name+".socket")
+	_ = os.Remove(socket)
+
+	err := launchProcess(instanceDir, name, "virtiofsd",
+		"--socket-path="+socket, "-o", "source="+m.Path, "-o", "cache=auto")
+	if err != nil {
+		return "", err
+	}
+
+	// Wait for virtiofsd to create its socket before handing it to the
+	// hypervisor.
+
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			return socket, nil
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	_ = killProcess(instanceDir, name)
+	return "", errors.Errorf("Timed out waiting for virtiofsd to start for %s", m.Tag)
+}
+
+// killVirtiofsd terminates any virtiofsd processes started for the instance.
+func killVirtiofsd(instanceDir string) {
+	pidFiles, _ := filepath.Glob(path.Join(instanceDir, virtiofsdName("*")+".pid"))
+	for _, f := range pidFiles {
+		name := strings.TrimSuffix(path.Base(f), ".pid")
+		if processRunning(instanceDir, name) {
+			_ = killProcess(instanceDir, name)
+		}
+	}
+}
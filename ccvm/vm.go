@@ -19,6 +19,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -41,7 +42,106 @@ const (
 	urlParam          = "url"
 )
 
-func bootVM(ctx context.Context, ws *workspace, name string, in *types.VMSpec) error {
+// monitorSocket is the QMP socket used by the daemon to monitor a VM.  It
+// is separate from the socket used to control the VM, as qemu only serves
+// one client per socket at a time.
+const monitorSocket = "monitor.sock"
+
+type qemuHypervisor struct {
+	cfg qemuConfig
+}
+
+func (qemuHypervisor) createRootfs(ctx context.Context, backingImage, instanceDir string, disk int) error {
+	return createRootfs(ctx, backingImage, instanceDir, disk)
+}
+
+func (qemuHypervisor) growRootfs(ctx context.Context, instanceDir string, disk int) error {
+	return growImage(ctx, path.Join(instanceDir, "image.qcow2"), "qcow2", disk)
+}
+
+// rootfsDriveParam returns the parameter of qemu's -drive option for the
+// root disk vmImage.
+func rootfsDriveParam(vmImage string, encrypted bool) string {
+	param := fmt.Sprintf("file=%s,if=virtio,aio=threads,format=qcow2", vmImage)
+	if encrypted {
+		param += ",encrypt.key-secret=" + rootfsSecretID
+	}
+	return param
+}
+
+func (qemuHypervisor) listenAddress(n *vmNetwork) string {
+	return "127.0.0.1"
+}
+
+// hostfwdAddrs returns the host address of the port mapping p of the VM
+// described by in, and the guest address to which it is forwarded, in the
+// format expected by qemu.  IPv4 mappings are forwarded to the default guest
+// address.
+func hostfwdAddrs(in *types.VMSpec, n *vmNetwork, p types.PortMapping) (string, string) {
+	if p.HostAddr == "" {
+		return in.HostIP.String(), ""
+	}
+	if p.IPv6() {
+		return "[" + p.HostAddr + "]", "[" + n.guestIPv6(guestMACAddress(in)) + "]"
+	}
+	return p.HostAddr, ""
+}
+
+// hostfwdRule returns the qemu hostfwd rule that implements the port mapping
+// p of the VM described by in.
+func hostfwdRule(in *types.VMSpec, n *vmNetwork, p types.PortMapping) string {
+	host, guest := hostfwdAddrs(in, n, p)
+	return fmt.Sprintf("tcp:%s:%d-%s:%d", host, p.Host, guest, p.Guest)
+}
+
+// userNetParam returns the parameter of qemu's -net option that connects
+// the VM to its network.
+func userNetParam(ws *workspace, name string, in *types.VMSpec) string {
+	n := ws.network
+
+	var b bytes.Buffer
+	b.WriteString("user")
+	b.WriteString(fmt.Sprintf(",net=%s,host=%s,dns=%s,dhcpstart=%s", n.spec.Subnet,
+		n.hostIP(), n.dnsIP(), n.guestIP()))
+	if n.spec.Mode == types.NetworkModeRestricted {
+		b.WriteString(",restrict=on")
+	}
+
+	b.WriteString(fmt.Sprintf(",ipv6=on,ipv6-net=%s,ipv6-host=%s,ipv6-dns=%s", n.spec.IPv6Subnet,
+		n.hostIPv6(), n.dnsIPv6()))
+
+	for _, p := range in.PortMappings {
+		b.WriteString(",hostfwd=" + hostfwdRule(in, n, p))
+	}
+
+	for _, r := range in.ReversePorts {
+		b.WriteString(fmt.Sprintf(",guestfwd=tcp:%s:%d-tcp:%s", n.reverseForwardIP(),
+			r.Guest, r.Host))
+	}
+
+	dnsSearch := ws.dnsSearch
+	if len(n.spec.DNSSearch) > 0 {
+		dnsSearch = n.spec.DNSSearch
+	}
+	for _, s := range dnsSearch {
+		b.WriteString(fmt.Sprintf(",dnssearch=%s", s))
+	}
+	hostname := name
+	if in.Hostname != "" {
+		hostname = in.Hostname
+	}
+	b.WriteString(fmt.Sprintf(",hostname=%s", hostname))
+
+	return b.String()
+}
+
+func (h qemuHypervisor) boot(ctx context.Context, ws *workspace, name string, in *types.VMSpec) error {
+	arch := guestArch(in)
+	binary, caps, err := checkQemu(ctx, &h.cfg, arch)
+	if err != nil {
+		return err
+	}
+
 	disconnectedCh := make(chan struct{})
 	socket := path.Join(ws.instanceDir, "socket")
 	qmp, _, err := qemu.QMPStart(ctx, socket, qemu.QMPConfig{}, disconnectedCh)
@@ -55,24 +155,65 @@ func bootVM(ctx context.Context, ws *workspace, name string, in *types.VMSpec) e
 		BIOSPath = ""
 	}
 	vmImage := path.Join(ws.instanceDir, "image.qcow2")
-	isoPath := path.Join(ws.instanceDir, "config.iso")
 	memParam := fmt.Sprintf("%dM", in.MemMiB)
-	CPUsParam := fmt.Sprintf("cpus=%d", in.CPUs)
 	args := []string{
 		"-qmp", fmt.Sprintf("unix:%s,server,nowait", socket),
-		"-m", memParam, "-smp", CPUsParam,
-		"-drive", fmt.Sprintf("file=%s,if=virtio,aio=threads,format=qcow2", vmImage),
-		"-drive", fmt.Sprintf("file=%s,if=virtio,media=cdrom", isoPath),
-		"-daemonize", "-enable-kvm", "-cpu", "host",
-		"-net", "nic,model=virtio",
+		"-qmp", fmt.Sprintf("unix:%s,server,nowait", path.Join(ws.instanceDir, monitorSocket)),
+		"-m", memParam, "-smp", qemuSMPParam(in),
+		"-drive", rootfsDriveParam(vmImage, in.Encrypt),
+		"-daemonize", "-pidfile", path.Join(ws.instanceDir, hypervisorQemu+".pid"),
+		"-net", "nic,model=virtio,macaddr=" + guestMACAddress(in),
 		"-device", "virtio-rng-pci",
+		"-device", "virtio-balloon-pci,id=balloon0",
 	}
+	args = append(args, archArgs(in, qemuAccelerated(arch))...)
+
+	// Guest memory must be shared with virtiofsd for virtio-fs mounts to
+	// be added to the running instance.
+	backend, options, err := qemuMemoryBackend(in, caps.hasDevice("vhost-user-fs-pci"))
+	if err != nil {
+		return err
+	}
+	args = append(args, qemuNUMAArgs(in, backend, options)...)
 
 	if BIOSPath != "" {
 		args = append(args, "-bios", BIOSPath)
 	}
 
-	for i, m := range in.Mounts {
+	firmware, err := archFirmwareArgs(ws.instanceDir, in)
+	if err != nil {
+		return err
+	}
+	args = append(args, firmware...)
+
+	args = append(args, qemuDatasourceArgs(ws, in)...)
+	args = append(args, virtioDriversArgs(ws.instanceDir)...)
+
+	kernel, err := directKernelArgs(ws, in, kernelSeed(ws, in))
+	if err != nil {
+		return err
+	}
+	args = append(args, kernel...)
+
+	for i := range in.Mounts {
+		m := &in.Mounts[i]
+		if m.FSType() == types.MountTypeVirtiofs {
+			if !caps.hasDevice("vhost-user-fs-pci") {
+				killVirtiofsd(ws.instanceDir)
+				return errors.New("qemu does not support virtio-fs")
+			}
+			socket, err := startVirtiofsd(ws.instanceDir, m)
+			if err != nil {
+				killVirtiofsd(ws.instanceDir)
+				return err
+			}
+			args = append(args,
+				"-chardev", fmt.Sprintf("socket,id=char-%s,path=%s", m.Tag, socket),
+				"-device", fmt.Sprintf("vhost-user-fs-pci,id=%s,chardev=char-%s,tag=%s",
+					virtiofsDeviceID(m.Tag), m.Tag, m.Tag))
+			continue
+		}
+
 		fsdevParam := fmt.Sprintf("local,security_model=%s,id=fsdev%d,path=%s",
 			m.SecurityModel, i, m.Path)
 		devParam := fmt.Sprintf("virtio-9p-pci,id=fs%[1]d,fsdev=fsdev%[1]d,mount_tag=%s",
@@ -80,6 +221,13 @@ func bootVM(ctx context.Context, ws *workspace, name string, in *types.VMSpec) e
 		args = append(args, "-fsdev", fsdevParam, "-device", devParam)
 	}
 
+	caches, err := cacheArgs(ws.ccvmDir, in)
+	if err != nil {
+		killVirtiofsd(ws.instanceDir)
+		return err
+	}
+	args = append(args, caches...)
+
 	for _, d := range in.Drives {
 		options := strings.TrimSpace(d.Options)
 		if options != "" {
@@ -90,30 +238,128 @@ func bootVM(ctx context.Context, ws *workspace, name string, in *types.VMSpec) e
 		args = append(args, "-drive", driveParam)
 	}
 
-	var b bytes.Buffer
-	b.WriteString("user")
-	for _, p := range in.PortMappings {
-		b.WriteString(fmt.Sprintf(",hostfwd=tcp:%s:%d-:%d", in.HostIP, p.Host, p.Guest))
+	for i := range in.Disks {
+		d := &in.Disks[i]
+		args = append(args,
+			"-drive", qemuDataDriveParam(ws.instanceDir, d),
+			"-device", fmt.Sprintf("virtio-blk-pci,id=%[1]s,drive=drive-%[1]s,serial=%[2]s",
+				dataDiskID(d.Name), d.Name))
 	}
 
-	for _, s := range ws.dnsSearch {
-		b.WriteString(fmt.Sprintf(",dnssearch=%s", s))
-	}
-	b.WriteString(fmt.Sprintf(",hostname=%s", name))
-
-	netParam := b.String()
+	netParam := userNetParam(ws, name, in)
 	args = append(args, "-net", netParam)
 
+	// The serial output is always logged so that it can be reported if
+	// the VM crashes.  It is logged by qemu when the console is exposed
+	// on a qemu port and by the daemon otherwise.
 	if in.Qemuport != 0 {
 		args = append(args, "-chardev",
-			fmt.Sprintf("socket,host=localhost,port=%d,id=ccld0,server,nowait", in.Qemuport),
-			"-device", "isa-serial,chardev=ccld0")
+			fmt.Sprintf("socket,host=localhost,port=%d,id=ccld0,server,nowait,logfile=%s",
+				in.Qemuport, path.Join(ws.instanceDir, consoleLog)))
+	} else {
+		args = append(args, "-chardev", fmt.Sprintf("socket,path=%s,id=ccld0,server,nowait",
+			path.Join(ws.instanceDir, consoleSocket)))
+	}
+	args = append(args, archSerialArgs(in)...)
+
+	if caps.hasDevice("pvpanic") {
+		args = append(args, "-device", "pvpanic")
+	}
+
+	clock, err := clockArgs(in, time.Now())
+	if err != nil {
+		return err
+	}
+	args = append(args, clock...)
+
+	args = append(args,
+		"-chardev", fmt.Sprintf("socket,id=ccvmguest,path=%s,server,nowait",
+			path.Join(ws.instanceDir, guestSocket)),
+		"-device", "virtio-serial-pci",
+		"-device", fmt.Sprintf("virtserialport,chardev=ccvmguest,name=%s", guestPortName))
+
+	args = append(args, graphicsArgs(in, caps)...)
+
+	audio, err := audioArgs(in, caps)
+	if err != nil {
+		killVirtiofsd(ws.instanceDir)
+		return err
+	}
+	args = append(args, audio...)
+
+	usb, err := usbArgs(in, caps)
+	if err != nil {
+		killVirtiofsd(ws.instanceDir)
+		return err
+	}
+	args = append(args, usb...)
+
+	vsock, err := vsockArgs(in, caps)
+	if err != nil {
+		killVirtiofsd(ws.instanceDir)
+		return err
+	}
+	args = append(args, vsock...)
+
+	if in.TPM {
+		if !caps.hasDevice("tpm-tis") {
+			killVirtiofsd(ws.instanceDir)
+			return errors.New("qemu does not support TPM emulation")
+		}
+		if err := startSwtpm(ws.instanceDir); err != nil {
+			killVirtiofsd(ws.instanceDir)
+			return err
+		}
+		args = append(args, tpmArgs(ws.instanceDir)...)
+	}
+
+	if err := ws.checkDevices(in); err != nil {
+		killVirtiofsd(ws.instanceDir)
+		killSwtpm(ws.instanceDir)
+		return err
+	}
+
+	mdevs, err := createMdevs(ws.instanceDir, in.VGPUs)
+	if err != nil {
+		killVirtiofsd(ws.instanceDir)
+		killSwtpm(ws.instanceDir)
+		return err
+	}
+	args = append(args, mdevArgs(mdevs)...)
+
+	if err := bindVFIO(ws.instanceDir, in.GPUs); err != nil {
+		killVirtiofsd(ws.instanceDir)
+		killSwtpm(ws.instanceDir)
+		removeMdevs(ws.instanceDir)
+		return err
 	}
+	args = append(args, vfioArgs(in.GPUs)...)
 
-	args = append(args, "-display", "none", "-vga", "none")
+	var fds []*os.File
+	if in.Encrypt {
+		secret, err := secretPipe(ws.diskKey)
+		if err != nil {
+			killVirtiofsd(ws.instanceDir)
+			killSwtpm(ws.instanceDir)
+			removeMdevs(ws.instanceDir)
+			releaseVFIO(ws.instanceDir)
+			return err
+		}
+		defer func() { _ = secret.Close() }()
+		fds = append(fds, secret)
+		args = append(args, "-object", secretObjectParam())
+	}
 
-	output, err := qemu.LaunchCustomQemu(ctx, "", args, nil, nil, nil)
+	if ws.incoming != "" {
+		args = append(args, "-incoming", incomingParam(ws.incoming))
+	}
+
+	output, err := qemu.LaunchCustomQemu(ctx, binary, args, fds, nil, nil)
 	if err != nil {
+		killVirtiofsd(ws.instanceDir)
+		killSwtpm(ws.instanceDir)
+		removeMdevs(ws.instanceDir)
+		releaseVFIO(ws.instanceDir)
 		return fmt.Errorf("Failed to launch qemu : %v, %s", err, output)
 	}
 	return nil
@@ -142,25 +388,329 @@ func executeQMPCommand(ctx context.Context, instanceDir string,
 	return nil
 }
 
-func stopVM(ctx context.Context, instanceDir string) error {
+func (qemuHypervisor) stop(ctx context.Context, instanceDir string) error {
 	return executeQMPCommand(ctx, instanceDir, func(ctx context.Context, q *qemu.QMP) error {
 		return q.ExecuteSystemPowerdown(ctx)
 	})
 }
 
-func quitVM(ctx context.Context, instanceDir string) error {
+func (qemuHypervisor) quit(ctx context.Context, instanceDir string) error {
+	defer killVirtiofsd(instanceDir)
 	return executeQMPCommand(ctx, instanceDir, func(ctx context.Context, q *qemu.QMP) error {
 		return q.ExecuteQuit(ctx)
 	})
 }
 
-func serveLocalFile(ctx context.Context, downloadCh chan<- downloadRequest, transport *http.Transport,
-	w http.ResponseWriter, r *http.Request) {
+func (qemuHypervisor) running(ctx context.Context, instanceDir string) bool {
+	_, err := qmpExecute(ctx, instanceDir, "query-status", nil)
+	return err == nil
+}
+
+func qemuHotplugCPUs(ctx context.Context, instanceDir string, count int) (bool, error) {
+	added := 0
+	err := executeQMPCommand(ctx, instanceDir, func(ctx context.Context, q *qemu.QMP) error {
+		cpus, err := q.ExecuteQueryHotpluggableCPUs(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range cpus {
+			if added == count {
+				break
+			}
+			if c.QOMPath != "" {
+				continue
+			}
+			p := c.Properties
+			err = q.ExecuteCPUDeviceAdd(ctx, c.Type,
+				fmt.Sprintf("cpu-%d-%d-%d", p.Socket, p.Core, p.Thread),
+				strconv.Itoa(p.Socket), strconv.Itoa(p.Core), strconv.Itoa(p.Thread))
+			if err != nil {
+				return err
+			}
+			added++
+		}
+
+		return nil
+	})
+
+	return added == count, err
+}
+
+func qemuBalloon(ctx context.Context, instanceDir string, memMiB int) (bool, error) {
+	ret, err := qmpExecute(ctx, instanceDir, "query-memory-size-summary", nil)
+	if err != nil {
+		return false, nil
+	}
+
+	var summary struct {
+		BaseMemory int64 `json:"base-memory"`
+	}
+	err = json.Unmarshal(ret, &summary)
+	if err != nil {
+		return false, errors.Wrap(err, "Unable to parse memory summary")
+	}
+
+	// The balloon can only be used to reduce the memory available to the
+	// guest below the amount with which it was booted.
+
+	size := int64(memMiB) << 20
+	if size > summary.BaseMemory {
+		return false, nil
+	}
+
+	_, err = qmpExecute(ctx, instanceDir, "balloon", map[string]interface{}{
+		"value": size,
+	})
+	if err != nil {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (qemuHypervisor) resize(ctx context.Context, instanceDir string, cur, next *types.VMSpec) (bool, error) {
+	live := true
+
+	if next.DiskGiB != cur.DiskGiB {
+		_, err := qmpExecute(ctx, instanceDir, "block_resize", map[string]interface{}{
+			"device": "virtio0",
+			"size":   int64(next.DiskGiB) << 30,
+		})
+		if err != nil {
+			return false, errors.Wrap(err, "Unable to resize disk")
+		}
+	}
+
+	if next.CPUs > cur.CPUs {
+		added, err := qemuHotplugCPUs(ctx, instanceDir, next.CPUs-cur.CPUs)
+		if err != nil {
+			return false, err
+		}
+		live = live && added
+	} else if next.CPUs < cur.CPUs {
+		live = false
+	}
+
+	if next.MemMiB != cur.MemMiB {
+		ballooned, err := qemuBalloon(ctx, instanceDir, next.MemMiB)
+		if err != nil {
+			return false, err
+		}
+		live = live && ballooned
+	}
+
+	return live, nil
+}
+
+func (qemuHypervisor) forward(ctx context.Context, instanceDir string, in *types.VMSpec, n *vmNetwork, p types.PortMapping,
+	add bool) error {
+	var cmd string
+	if add {
+		cmd = "hostfwd_add " + hostfwdRule(in, n, p)
+	} else {
+		host, _ := hostfwdAddrs(in, n, p)
+		cmd = fmt.Sprintf("hostfwd_remove tcp:%s:%d", host, p.Host)
+	}
+
+	ret, err := qmpExecute(ctx, instanceDir, "human-monitor-command", map[string]interface{}{
+		"command-line": cmd,
+	})
+	if err != nil {
+		return err
+	}
+
+	// HMP commands report errors in their output.  hostfwd_remove also
+	// reports success, so we need to look for specific error messages.
+
+	var output string
+	_ = json.Unmarshal(ret, &output)
+	output = strings.TrimSpace(output)
+	if strings.Contains(output, "could not") || strings.Contains(output, "invalid") {
+		return errors.Errorf("%s failed: %s", cmd, output)
+	}
+
+	return nil
+}
+
+func (h qemuHypervisor) addMount(ctx context.Context, instanceDir string, m *types.Mount) error {
+	_, caps, err := checkQemu(ctx, &h.cfg, types.ArchX86_64)
+	if err != nil {
+		return err
+	}
+	if !caps.hasDevice("vhost-user-fs-pci") {
+		return errors.New("qemu does not support virtio-fs")
+	}
+
+	socket, err := startVirtiofsd(instanceDir, m)
+	if err != nil {
+		return err
+	}
+
+	chardevID := "char-" + m.Tag
+	_, err = qmpExecute(ctx, instanceDir, "chardev-add", map[string]interface{}{
+		"id": chardevID,
+		"backend": map[string]interface{}{
+			"type": "socket",
+			"data": map[string]interface{}{
+				"addr": map[string]interface{}{
+					"type": "unix",
+					"data": map[string]interface{}{"path": socket},
+				},
+				"server": false,
+			},
+		},
+	})
+	if err != nil {
+		_ = killProcess(instanceDir, virtiofsdName(m.Tag))
+		return err
+	}
+
+	_, err = qmpExecute(ctx, instanceDir, "device_add", map[string]interface{}{
+		"driver":  "vhost-user-fs-pci",
+		"id":      virtiofsDeviceID(m.Tag),
+		"chardev": chardevID,
+		"tag":     m.Tag,
+	})
+	if err != nil {
+		_, _ = qmpExecute(ctx, instanceDir, "chardev-remove", map[string]interface{}{
+			"id": chardevID,
+		})
+		_ = killProcess(instanceDir, virtiofsdName(m.Tag))
+		return err
+	}
+
+	return nil
+}
+
+func qemuDataDriveParam(instanceDir string, d *types.Disk) string {
+	return fmt.Sprintf("file=%s,if=none,id=drive-%s,format=qcow2,aio=threads",
+		dataDiskPath(instanceDir, d.Name), dataDiskID(d.Name))
+}
+
+func (qemuHypervisor) attachDisk(ctx context.Context, instanceDir string, d *types.Disk) error {
+	// There is no QMP equivalent of drive_add in the versions of qemu
+	// we support.  Drives added with drive_add are deleted automatically
+	// when the device using them is removed.
+
+	cmd := "drive_add 0 " + qemuDataDriveParam(instanceDir, d)
+	ret, err := qmpExecute(ctx, instanceDir, "human-monitor-command", map[string]interface{}{
+		"command-line": cmd,
+	})
+	if err != nil {
+		return err
+	}
+
+	var output string
+	_ = json.Unmarshal(ret, &output)
+	output = strings.TrimSpace(output)
+	if output != "OK" {
+		return errors.Errorf("%s failed: %s", cmd, output)
+	}
+
+	_, err = qmpExecute(ctx, instanceDir, "device_add", map[string]interface{}{
+		"driver": "virtio-blk-pci",
+		"id":     dataDiskID(d.Name),
+		"drive":  "drive-" + dataDiskID(d.Name),
+		"serial": d.Name,
+	})
+	if err != nil {
+		_, _ = qmpExecute(ctx, instanceDir, "human-monitor-command", map[string]interface{}{
+			"command-line": "drive_del drive-" + dataDiskID(d.Name),
+		})
+		return err
+	}
+
+	return nil
+}
+
+func (qemuHypervisor) detachDisk(ctx context.Context, instanceDir string, d *types.Disk) error {
+	_, err := qmpExecute(ctx, instanceDir, "device_del", map[string]interface{}{
+		"id": dataDiskID(d.Name),
+	})
+	if err != nil {
+		return err
+	}
+
+	// device_del returns before the guest has released the device so we
+	// wait for its drive to be deleted, at which point the volume is no
+	// longer in use.
+
+	driveID := "drive-" + dataDiskID(d.Name)
+	for i := 0; i < 20; i++ {
+		ret, err := qmpExecute(ctx, instanceDir, "query-block", nil)
+		if err != nil {
+			return err
+		}
+		var drives []struct {
+			Device string `json:"device"`
+		}
+		_ = json.Unmarshal(ret, &drives)
+		found := false
+		for _, drive := range drives {
+			if drive.Device == driveID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond * 500):
+		}
+	}
+
+	return errors.Errorf("Disk %s was not released by the guest", d.Name)
+}
+
+func (qemuHypervisor) removeMount(ctx context.Context, instanceDir string, m *types.Mount) error {
+	_, err := qmpExecute(ctx, instanceDir, "device_del", map[string]interface{}{
+		"id": virtiofsDeviceID(m.Tag),
+	})
+	if err != nil {
+		return err
+	}
+
+	// device_del returns before the guest has released the device so we
+	// retry the removal of the chardev until it is no longer in use.
+
+	chardevID := "char-" + m.Tag
+	for i := 0; i < 20; i++ {
+		_, err = qmpExecute(ctx, instanceDir, "chardev-remove", map[string]interface{}{
+			"id": chardevID,
+		})
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond * 500):
+		}
+	}
+
+	if processRunning(instanceDir, virtiofsdName(m.Tag)) {
+		_ = killProcess(instanceDir, virtiofsdName(m.Tag))
+	}
+
+	return err
+}
+
+func serveLocalFile(ctx context.Context, resultCh chan interface{}, downloadCh chan<- downloadRequest,
+	transport *http.Transport, retry retryPolicy, w http.ResponseWriter, r *http.Request) {
 	params := r.URL.Query()
 	URL := params.Get(urlParam)
 
-	path, err := downloadFile(ctx, downloadCh, transport, URL,
-		func(bool, progress) {})
+	var path string
+	err := retry.do(ctx, "Download of "+URL, reportRetry(resultCh), func() error {
+		var err error
+		path, err = downloadFile(ctx, downloadCh, transport, URL,
+			func(bool, progress) {})
+		return err
+	})
 	if err != nil {
 		// May not be the correct error code but the error message is only going
 		// to end up in cloud-init's logs.
@@ -183,7 +733,8 @@ func serveLocalFile(ctx context.Context, downloadCh chan<- downloadRequest, tran
 }
 
 func startHTTPServer(ctx context.Context, resultCh chan interface{}, downloadCh chan<- downloadRequest,
-	transport *http.Transport, listener net.Listener, errCh chan error) {
+	transport *http.Transport, retry retryPolicy, listener net.Listener, instanceDir string,
+	errCh chan error) {
 	finished := false
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -215,9 +766,11 @@ func startHTTPServer(ctx context.Context, resultCh chan interface{}, downloadCh
 	})
 
 	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
-		serveLocalFile(ctx, downloadCh, transport, w, r)
+		serveLocalFile(ctx, resultCh, downloadCh, transport, retry, w, r)
 	})
 
+	mux.HandleFunc(seedPath, serveSeed(instanceDir))
+
 	server := &http.Server{
 		Handler: mux,
 	}
@@ -231,8 +784,100 @@ func startHTTPServer(ctx context.Context, resultCh chan interface{}, downloadCh
 	}()
 }
 
-func createLocalListener() (net.Listener, int, error) {
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+func (qemuHypervisor) watch(ctx context.Context, instanceDir string) (*vmWatcher, error) {
+	socket := path.Join(instanceDir, "socket")
+	disconnectedCh := make(chan struct{})
+
+	qmp, _, err := qemu.QMPStart(ctx, socket, qemu.QMPConfig{}, disconnectedCh)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to connect to VM")
+	}
+
+	err = qmp.ExecuteQMPCapabilities(ctx)
+	if err != nil {
+		qmp.Shutdown()
+		return nil, fmt.Errorf("Unable to query QEMU caps")
+	}
+
+	return &vmWatcher{
+		disconnectedCh: disconnectedCh,
+		quit:           qmp.ExecuteQuit,
+		close:          qmp.Shutdown,
+	}, nil
+}
+
+// qmpExitEvent updates exit according to a QMP event received while
+// monitoring a VM.
+func qmpExitEvent(ctx context.Context, q *qemu.QMP, e *qemu.QMPEvent, exit *vmExit) {
+	switch e.Name {
+	case "GUEST_PANICKED":
+		// The VM is paused when the guest panics.
+		exit.reason = "guest-panic"
+		exit.crashed = true
+		exit.clean = false
+		_ = q.ExecuteQuit(ctx)
+	case "SHUTDOWN":
+		if exit.reason == "guest-panic" {
+			return
+		}
+		reason, _ := e.Data["reason"].(string)
+		if reason == "" {
+			// Versions of qemu older than 4.0 do not report a
+			// reason.
+			reason = "shutdown"
+		}
+		exit.reason = reason
+		switch reason {
+		case "guest-shutdown", "host-qmp-quit", "shutdown":
+			exit.crashed = false
+			exit.clean = true
+		default:
+			exit.crashed = true
+			exit.clean = false
+		}
+	}
+}
+
+func (qemuHypervisor) monitor(ctx context.Context, instanceDir string) (*vmExit, error) {
+	socket := path.Join(instanceDir, monitorSocket)
+	eventCh := make(chan qemu.QMPEvent, 16)
+	disconnectedCh := make(chan struct{})
+
+	q, _, err := qemu.QMPStart(ctx, socket, qemu.QMPConfig{EventCh: eventCh}, disconnectedCh)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to connect to VM")
+	}
+	defer q.Shutdown()
+
+	err = q.ExecuteQMPCapabilities(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to query QEMU caps")
+	}
+
+	// qemu reports why it is about to exit with a SHUTDOWN event.  If
+	// the connection is lost without one, qemu was killed or crashed.
+	exit := &vmExit{
+		reason:  "qemu exited unexpectedly",
+		crashed: true,
+		console: path.Join(instanceDir, consoleLog),
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case e, ok := <-eventCh:
+			// eventCh is closed, once all the events have been
+			// delivered, when the connection is lost.
+			if !ok {
+				return exit, nil
+			}
+			qmpExitEvent(ctx, q, &e, exit)
+		}
+	}
+}
+
+func createLocalListener(address string) (net.Listener, int, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
 	if err != nil {
 		return nil, 0, errors.Wrap(err, "Unable to create listener")
 	}
@@ -253,36 +898,27 @@ func createLocalListener() (net.Listener, int, error) {
 }
 
 func manageInstallation(ctx context.Context, resultCh chan interface{},
-	downloadCh chan<- downloadRequest, transport *http.Transport, listener net.Listener,
-	instanceDir string) error {
-	socket := path.Join(instanceDir, "socket")
-	disconnectedCh := make(chan struct{})
-
-	qmp, _, err := qemu.QMPStart(ctx, socket, qemu.QMPConfig{}, disconnectedCh)
+	downloadCh chan<- downloadRequest, transport *http.Transport, retry retryPolicy,
+	listener net.Listener, instanceDir string, hv hypervisor) error {
+	watcher, err := hv.watch(ctx, instanceDir)
 	if err != nil {
 		_ = listener.Close()
-		return errors.Wrap(err, "Unable to connect to VM")
+		return err
 	}
 
-	qemuShutdown := true
+	vmShutdown := true
 	defer func() {
-		if qemuShutdown {
+		if vmShutdown {
 			ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
-			_ = qmp.ExecuteQuit(ctx)
-			<-disconnectedCh
+			_ = watcher.quit(ctx)
+			<-watcher.disconnectedCh
 			cancelFn()
 		}
-		qmp.Shutdown()
+		watcher.close()
 	}()
 
-	err = qmp.ExecuteQMPCapabilities(ctx)
-	if err != nil {
-		_ = listener.Close()
-		return fmt.Errorf("Unable to query QEMU caps")
-	}
-
 	errCh := make(chan error)
-	startHTTPServer(ctx, resultCh, downloadCh, transport, listener, errCh)
+	startHTTPServer(ctx, resultCh, downloadCh, transport, retry, listener, instanceDir, errCh)
 	select {
 	case <-ctx.Done():
 		_ = listener.Close()
@@ -290,13 +926,13 @@ func manageInstallation(ctx context.Context, resultCh chan interface{},
 		return ctx.Err()
 	case err := <-errCh:
 		if err == nil {
-			qemuShutdown = false
+			vmShutdown = false
 		}
 		return err
-	case <-disconnectedCh:
-		qemuShutdown = false
+	case <-watcher.disconnectedCh:
+		vmShutdown = false
 		_ = listener.Close()
 		<-errCh
-		return fmt.Errorf("Lost connection to QEMU instance")
+		return fmt.Errorf("Lost connection to VM instance")
 	}
 }
@@ -0,0 +1,326 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Named volumes are qcow2 images stored in the volumes directory.  A volume
+// is attached to one instance at a time, recorded in its owner file, and
+// survives the deletion of the instance.
+
+const volumesDir = "volumes"
+
+func volumePath(ccvmDir, name string) string {
+	return path.Join(ccvmDir, volumesDir, name+".qcow2")
+}
+
+func volumeOwnerPath(ccvmDir, name string) string {
+	return path.Join(ccvmDir, volumesDir, name+".owner")
+}
+
+// volumeOwner returns the name of the instance using the volume called
+// name, and the name of the disk it backs, if it is claimed.
+func volumeOwner(ccvmDir, name string) (instance, disk string, claimed bool) {
+	data, err := ioutil.ReadFile(volumeOwnerPath(ccvmDir, name))
+	if err != nil {
+		return "", "", !os.IsNotExist(err)
+	}
+	fields := strings.SplitN(strings.TrimSpace(string(data)), "\n", 2)
+	instance = fields[0]
+	if len(fields) == 2 {
+		disk = fields[1]
+	}
+	return instance, disk, true
+}
+
+// claimVolume records that the volume called name backs the disk of
+// instance.  It fails if the volume is already claimed.
+func claimVolume(ccvmDir, name, instance, disk string) error {
+	err := os.MkdirAll(path.Join(ccvmDir, volumesDir), 0755)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create volumes directory")
+	}
+
+	p := volumeOwnerPath(ccvmDir, name)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		if owner, _, _ := volumeOwner(ccvmDir, name); owner != "" {
+			return errors.Errorf("Volume %s is attached to %s", name, owner)
+		}
+		return errors.Errorf("Volume %s is being modified", name)
+	} else if err != nil {
+		return errors.Wrapf(err, "Unable to claim volume %s", name)
+	}
+
+	_, err = fmt.Fprintf(f, "%s\n%s\n", instance, disk)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(p)
+		return errors.Wrapf(err, "Unable to claim volume %s", name)
+	}
+
+	return nil
+}
+
+func releaseVolume(ccvmDir, name string) {
+	_ = os.Remove(volumeOwnerPath(ccvmDir, name))
+}
+
+// releaseVolumes releases all the volumes claimed by instance.
+func releaseVolumes(ccvmDir, instance string) {
+	files, err := ioutil.ReadDir(path.Join(ccvmDir, volumesDir))
+	if err != nil {
+		return
+	}
+
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".owner") {
+			continue
+		}
+		name := strings.TrimSuffix(fi.Name(), ".owner")
+		if owner, _, _ := volumeOwner(ccvmDir, name); owner == instance {
+			releaseVolume(ccvmDir, name)
+		}
+	}
+}
+
+// renameVolumeClaims transfers the volumes claimed by instance to newName.
+func renameVolumeClaims(ccvmDir, instance, newName string) {
+	files, err := ioutil.ReadDir(path.Join(ccvmDir, volumesDir))
+	if err != nil {
+		return
+	}
+
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".owner") {
+			continue
+		}
+		name := strings.TrimSuffix(fi.Name(), ".owner")
+		owner, disk, _ := volumeOwner(ccvmDir, name)
+		if owner != instance {
+			continue
+		}
+		data := []byte(fmt.Sprintf("%s\n%s\n", newName, disk))
+		err := writeFileAtomic(volumeOwnerPath(ccvmDir, name), data)
+		if err != nil {
+			logWarningf("Unable to transfer volume %s to %s: %v", name, newName, err)
+		}
+	}
+}
+
+func createVolumeImage(ctx context.Context, ccvmDir, name string, sizeGiB int) error {
+	out, err := exec.CommandContext(ctx, "qemu-img", "create", "-f", "qcow2",
+		volumePath(ccvmDir, name), fmt.Sprintf("%dG", sizeGiB)).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to create volume %s: %s", name, string(out))
+	}
+	return nil
+}
+
+func volumeSizeGiB(ctx context.Context, ccvmDir, name string) (int, error) {
+	size, err := imageVirtualSize(ctx, volumePath(ccvmDir, name), "qcow2")
+	if err != nil {
+		return 0, err
+	}
+	return int((size + (1 << 30) - 1) >> 30), nil
+}
+
+// linkVolume claims the named volume backing d for the instance of ws and
+// links it into the instance's disks directory.  The volume is created if
+// it does not exist and d specifies a size.  The size of the volume is
+// recorded in d.
+func linkVolume(ctx context.Context, ws *workspace, d *types.Disk) (err error) {
+	instance := path.Base(ws.instanceDir)
+	err = claimVolume(ws.ccvmDir, d.Volume, instance, d.Name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			releaseVolume(ws.ccvmDir, d.Volume)
+		}
+	}()
+
+	link := dataDiskPath(ws.instanceDir, d.Name)
+	if _, err = os.Lstat(link); err == nil {
+		return errors.Errorf("A volume for disk %s already exists in instance %s", d.Name, instance)
+	}
+
+	volume := volumePath(ws.ccvmDir, d.Volume)
+	if _, err = os.Stat(volume); os.IsNotExist(err) {
+		if d.SizeGiB == 0 {
+			return errors.Errorf("Volume %s does not exist", d.Volume)
+		}
+		err = createVolumeImage(ctx, ws.ccvmDir, d.Volume, d.SizeGiB)
+	} else if err == nil && d.SizeGiB > 0 {
+		err = growImage(ctx, volume, "qcow2", d.SizeGiB)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.SizeGiB, err = volumeSizeGiB(ctx, ws.ccvmDir, d.Volume)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(path.Join(ws.instanceDir, dataDiskDir), 0755)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create disks directory")
+	}
+
+	err = os.Symlink(volume, link)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to link volume %s", d.Volume)
+	}
+
+	return nil
+}
+
+// unlinkVolume removes the named volume backing d from the instance of ws
+// and releases it.
+func unlinkVolume(ws *workspace, d *types.Disk) error {
+	err := os.Remove(dataDiskPath(ws.instanceDir, d.Name))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "Unable to unlink volume %s", d.Volume)
+	}
+	releaseVolume(ws.ccvmDir, d.Volume)
+	return nil
+}
+
+func (c ccvmBackend) createVolume(ctx context.Context, spec *types.VolumeSpec) error {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	if err := types.CheckVolumeName(spec.Name); err != nil {
+		return err
+	}
+	if spec.SizeGiB <= 0 {
+		return errors.Errorf("Invalid size %d for volume %s", spec.SizeGiB, spec.Name)
+	}
+
+	err = claimVolume(ws.ccvmDir, spec.Name, "", "")
+	if err != nil {
+		return err
+	}
+	defer releaseVolume(ws.ccvmDir, spec.Name)
+
+	if _, err := os.Stat(volumePath(ws.ccvmDir, spec.Name)); err == nil {
+		return errors.Errorf("Volume %s already exists", spec.Name)
+	}
+
+	return createVolumeImage(ctx, ws.ccvmDir, spec.Name, spec.SizeGiB)
+}
+
+func (c ccvmBackend) deleteVolume(ctx context.Context, name string) error {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	if err := types.CheckVolumeName(name); err != nil {
+		return err
+	}
+
+	err = claimVolume(ws.ccvmDir, name, "", "")
+	if err != nil {
+		return err
+	}
+	defer releaseVolume(ws.ccvmDir, name)
+
+	err = os.Remove(volumePath(ws.ccvmDir, name))
+	if os.IsNotExist(err) {
+		return errors.Errorf("Volume %s does not exist", name)
+	} else if err != nil {
+		return errors.Wrapf(err, "Unable to delete volume %s", name)
+	}
+
+	return nil
+}
+
+// listVolumes returns the named volumes, sorted by name.
+func (c ccvmBackend) listVolumes(ctx context.Context) ([]types.VolumeInfo, error) {
+	ws, err := prepareEnv(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(path.Join(ws.ccvmDir, volumesDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "Unable to read volumes directory")
+	}
+
+	var volumes []types.VolumeInfo
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".qcow2") {
+			continue
+		}
+		v := types.VolumeInfo{}
+		v.Name = strings.TrimSuffix(fi.Name(), ".qcow2")
+		v.SizeGiB, err = volumeSizeGiB(ctx, ws.ccvmDir, v.Name)
+		if err != nil {
+			return nil, err
+		}
+		v.Instance, v.Disk, _ = volumeOwner(ws.ccvmDir, v.Name)
+		volumes = append(volumes, v)
+	}
+
+	return volumes, nil
+}
+
+func (s *ccvmService) createVolume(ctx context.Context, spec *types.VolumeSpec, resultCh chan interface{}) {
+	go func() {
+		resultCh <- s.b.createVolume(ctx, spec)
+		close(resultCh)
+	}()
+}
+
+func (s *ccvmService) deleteVolume(ctx context.Context, name string, resultCh chan interface{}) {
+	go func() {
+		resultCh <- s.b.deleteVolume(ctx, name)
+		close(resultCh)
+	}()
+}
+
+func (s *ccvmService) listVolumes(ctx context.Context, resultCh chan interface{}) {
+	go func() {
+		volumes, err := s.b.listVolumes(ctx)
+		if err != nil {
+			resultCh <- err
+		} else {
+			resultCh <- volumes
+		}
+		close(resultCh)
+	}()
+}
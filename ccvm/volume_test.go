@@ -0,0 +1,86 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestVolumeClaims(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume-tests")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if err := claimVolume(dir, "cache", "dev1", "data"); err != nil {
+		t.Fatalf("Unable to claim volume: %v", err)
+	}
+	if instance, disk, claimed := volumeOwner(dir, "cache"); !claimed ||
+		instance != "dev1" || disk != "data" {
+		t.Errorf("Unexpected owner %s %s %v", instance, disk, claimed)
+	}
+
+	err = claimVolume(dir, "cache", "dev2", "data")
+	if err == nil || !strings.Contains(err.Error(), "dev1") {
+		t.Errorf("Volume claimed twice: %v", err)
+	}
+
+	if err := claimVolume(dir, "dataset", "dev2", "data"); err != nil {
+		t.Fatalf("Unable to claim volume: %v", err)
+	}
+
+	releaseVolumes(dir, "dev1")
+	if _, _, claimed := volumeOwner(dir, "cache"); claimed {
+		t.Errorf("Volume cache not released")
+	}
+	if _, _, claimed := volumeOwner(dir, "dataset"); !claimed {
+		t.Errorf("Volume dataset released")
+	}
+
+	renameVolumeClaims(dir, "dev2", "dev3")
+	if instance, disk, _ := volumeOwner(dir, "dataset"); instance != "dev3" || disk != "data" {
+		t.Errorf("Volume dataset not transferred: %s %s", instance, disk)
+	}
+}
+
+func TestLinkMissingVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "volume-tests")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	ws := &workspace{
+		ccvmDir:     dir,
+		instanceDir: path.Join(dir, "instances", "dev1"),
+	}
+	d := &types.Disk{Name: "data", Volume: "cache"}
+	if err := linkVolume(context.Background(), ws, d); err == nil {
+		t.Errorf("Missing volume linked")
+	}
+	if _, _, claimed := volumeOwner(dir, "cache"); claimed {
+		t.Errorf("Volume not released after failure")
+	}
+}
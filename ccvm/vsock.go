@@ -0,0 +1,88 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The context ID of the vsock device of a VM is derived from the host IP
+// address of the instance, which is unique on the host.
+
+// vhostVsockDev is the device through which qemu creates vsock devices.
+var vhostVsockDev = "/dev/vhost-vsock"
+
+// Context IDs 0 to 2 are reserved for the hypervisor and the host, and
+// the largest one means any context ID.
+const (
+	minVsockCID = 3
+	maxVsockCID = ^uint32(0) - 1
+)
+
+// vsockCID returns the context ID of the vsock device of a VM, or 0 if it
+// has none.
+func vsockCID(in *types.VMSpec) uint32 {
+	if !in.Vsock {
+		return 0
+	}
+	ip := in.HostIP.To4()
+	if ip == nil {
+		return 0
+	}
+	cid := uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+	if cid < minVsockCID || cid > maxVsockCID {
+		return 0
+	}
+	return cid
+}
+
+// checkVsock verifies that a context ID can be derived from the host IP
+// address of a VM that has a vsock device.
+func checkVsock(in *types.VMSpec) error {
+	if in.Vsock && vsockCID(in) == 0 {
+		return errors.Errorf("No vsock context ID can be derived from the host IP address %s", in.HostIP)
+	}
+	return nil
+}
+
+// vsockArgs returns the qemu arguments that give a VM its vsock device.
+func vsockArgs(in *types.VMSpec, caps *qemuCaps) ([]string, error) {
+	if !in.Vsock {
+		return nil, nil
+	}
+	if err := checkVsock(in); err != nil {
+		return nil, err
+	}
+	if !caps.hasDevice("vhost-vsock-pci") {
+		return nil, errors.New("qemu does not support vhost-vsock devices")
+	}
+
+	f, err := os.OpenFile(vhostVsockDev, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("%s does not exist.  Load the vhost_vsock module", vhostVsockDev)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "Unable to open %s", vhostVsockDev)
+	}
+	_ = f.Close()
+
+	return []string{"-device",
+		fmt.Sprintf("vhost-vsock-pci,id=vsock0,guest-cid=%d", vsockCID(in))}, nil
+}
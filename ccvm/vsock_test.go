@@ -0,0 +1,71 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"net"
+	"path"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+func TestVsockCID(t *testing.T) {
+	in := types.VMSpec{HostIP: net.IPv4(127, 0, 0, 2)}
+	if cid := vsockCID(&in); cid != 0 {
+		t.Errorf("Unexpected CID %d for a VM without vsock", cid)
+	}
+
+	in.Vsock = true
+	if cid := vsockCID(&in); cid != 0x7f000002 {
+		t.Errorf("Unexpected CID %d", cid)
+	}
+	if err := checkVsock(&in); err != nil {
+		t.Errorf("Valid vsock rejected: %v", err)
+	}
+
+	in.HostIP = net.IPv4(0, 0, 0, 2)
+	if err := checkVsock(&in); err == nil {
+		t.Errorf("Expected error for reserved CID")
+	}
+	in.HostIP = net.ParseIP("::1")
+	if err := checkVsock(&in); err == nil {
+		t.Errorf("Expected error for IPv6 host IP")
+	}
+}
+
+func TestVsockArgs(t *testing.T) {
+	in := types.VMSpec{HostIP: net.IPv4(127, 0, 0, 2)}
+	caps := &qemuCaps{devices: map[string]struct{}{}}
+	if args, err := vsockArgs(&in, caps); err != nil || len(args) != 0 {
+		t.Errorf("Unexpected arguments %v for a VM without vsock: %v", args, err)
+	}
+
+	in.Vsock = true
+	if _, err := vsockArgs(&in, caps); err == nil {
+		t.Errorf("Expected error when qemu does not support vhost-vsock")
+	}
+
+	saved := vhostVsockDev
+	vhostVsockDev = path.Join(t.Name(), "missing")
+	defer func() { vhostVsockDev = saved }()
+
+	caps.devices["vhost-vsock-pci"] = struct{}{}
+	if _, err := vsockArgs(&in, caps); err == nil {
+		t.Errorf("Expected error when %s does not exist", vhostVsockDev)
+	}
+}
@@ -0,0 +1,116 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Windows guests are configured by Cloudbase-Init, which reads the same
+// NoCloud ISO image as cloud-init.
+const (
+	distroWindows       = "windows"
+	rdpPort             = 3389
+	windowsReadyTimeout = 30 * time.Minute
+	windowsReadyCommand = "exit 0"
+	windowsPoweroffCmd  = "shutdown /s /t 0"
+	virtioDriversImage  = "virtio-win.iso"
+)
+
+func (spec *workloadSpec) windows() bool {
+	return spec.Distro == distroWindows
+}
+
+// ensureRDPPortMapping forwards the RDP port of Windows guests, unless the
+// workload already forwards it.
+func (spec *workloadSpec) ensureRDPPortMapping() {
+	for _, p := range spec.VM.PortMappings {
+		if p.Guest == rdpPort {
+			return
+		}
+	}
+
+	spec.VM.PortMappings = append(spec.VM.PortMappings,
+		types.PortMapping{
+			Host:  rdpPort,
+			Guest: rdpPort,
+		})
+}
+
+// checkWindows verifies that the Windows specific settings of spec can be
+// used with its hypervisor.
+func checkWindows(spec *workloadSpec) error {
+	if !spec.windows() && spec.VirtioDrivers == "" {
+		return nil
+	}
+	if spec.VM.Hypervisor != "" && spec.VM.Hypervisor != hypervisorQemu {
+		if spec.windows() {
+			return errors.Errorf("Windows guests are not supported by %s", spec.VM.Hypervisor)
+		}
+		return errors.Errorf("virtio_drivers is not supported by %s", spec.VM.Hypervisor)
+	}
+	return nil
+}
+
+// windowsMetaData returns the meta data of a Windows guest.
+func windowsMetaData(ws *workspace) ([]byte, error) {
+	keys := []string{}
+	if key := strings.TrimSpace(ws.PublicKey); key != "" {
+		keys = append(keys, key)
+	}
+	keys = append(keys, ws.agentKeys...)
+
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"instance-id":    ws.UUID,
+		"uuid":           ws.UUID,
+		"hostname":       ws.Hostname,
+		"local-hostname": ws.Hostname,
+		"admin-username": ws.User,
+		"public-keys":    keys,
+	}, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to marshal meta data")
+	}
+	return append(data, '\n'), nil
+}
+
+// linkImage links the file src, which is usually found in the download
+// cache, into instanceDir as name, or copies it if it cannot be linked.
+func linkImage(src, instanceDir, name string) error {
+	if err := os.Link(src, path.Join(instanceDir, name)); err == nil {
+		return nil
+	}
+	return copyImage(src, instanceDir, name)
+}
+
+// virtioDriversArgs returns the qemu arguments that attach the virtio
+// drivers of the instance stored in instanceDir, if any.
+func virtioDriversArgs(instanceDir string) []string {
+	isoPath := path.Join(instanceDir, virtioDriversImage)
+	if _, err := os.Stat(isoPath); err != nil {
+		return nil
+	}
+	return []string{"-drive", fmt.Sprintf("file=%s,media=cdrom,readonly=on", isoPath)}
+}
@@ -0,0 +1,150 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/intel/ccloudvm/types"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestWindowsSpec(t *testing.T) {
+	spec := workloadSpec{Distro: distroWindows}
+	if err := checkWindows(&spec); err != nil {
+		t.Errorf("Windows guest rejected: %v", err)
+	}
+	spec.VM.Hypervisor = hypervisorFirecracker
+	if err := checkWindows(&spec); err == nil {
+		t.Errorf("Windows guest accepted by firecracker")
+	}
+	spec = workloadSpec{VirtioDrivers: "https://example.com/virtio-win.iso"}
+	spec.VM.Hypervisor = hypervisorCloudHypervisor
+	if err := checkWindows(&spec); err == nil {
+		t.Errorf("virtio_drivers accepted by cloud-hypervisor")
+	}
+
+	spec = workloadSpec{Distro: distroWindows}
+	spec.ensureRDPPortMapping()
+	spec.ensureRDPPortMapping()
+	expected := []types.PortMapping{{Host: rdpPort, Guest: rdpPort}}
+	if !reflect.DeepEqual(spec.VM.PortMappings, expected) {
+		t.Errorf("Unexpected port mappings %v", spec.VM.PortMappings)
+	}
+
+	spec.VM.PortMappings = []types.PortMapping{{Host: 13389, Guest: rdpPort}}
+	spec.ensureRDPPortMapping()
+	if len(spec.VM.PortMappings) != 1 {
+		t.Errorf("RDP port forwarded twice %v", spec.VM.PortMappings)
+	}
+
+	if _, err := lookupDistro(distroWindows); err != nil {
+		t.Errorf("Windows distro not found: %v", err)
+	}
+}
+
+func TestWindowsMetaData(t *testing.T) {
+	ws := &workspace{
+		UUID:      "d9b2b11e-7d15-4a0b-b1c4-0d8f2b0b7c11",
+		Hostname:  "win",
+		User:      "jane",
+		PublicKey: "ssh-ed25519 AAAA jane@win\n",
+		agentKeys: []string{"ssh-rsa BBBB jane@laptop"},
+	}
+
+	data, err := windowsMetaData(ws)
+	if err != nil {
+		t.Fatalf("Unable to create meta data: %v", err)
+	}
+
+	var md struct {
+		InstanceID    string   `json:"instance-id"`
+		LocalHostname string   `json:"local-hostname"`
+		AdminUsername string   `json:"admin-username"`
+		PublicKeys    []string `json:"public-keys"`
+	}
+	if err := json.Unmarshal(data, &md); err != nil {
+		t.Fatalf("Invalid meta data: %v", err)
+	}
+	if md.InstanceID != ws.UUID || md.LocalHostname != "win" || md.AdminUsername != "jane" ||
+		!reflect.DeepEqual(md.PublicKeys, []string{"ssh-ed25519 AAAA jane@win", "ssh-rsa BBBB jane@laptop"}) {
+		t.Errorf("Unexpected meta data %+v", md)
+	}
+}
+
+func TestWindowsCloudConfig(t *testing.T) {
+	ws := &workspace{HTTPServerPort: 1234, network: defaultNetwork(),
+		agentKeys: []string{"ssh-rsa BBBB jane@laptop"}}
+	wkld := &workload{userData: "runcmd:\n- command 1\n"}
+	wkld.spec.Distro = distroWindows
+
+	err := wkld.generateCloudConfig(ws)
+	if err != nil {
+		t.Fatalf("Failed to generate cloud config: %v", err)
+	}
+
+	var cc map[string]interface{}
+	err = yaml.Unmarshal(wkld.mergedUserData, &cc)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal cloud config: %v", err)
+	}
+
+	expected := []interface{}{"command 1", `curl.exe -X PUT -d "FINISHED" 10.0.2.2:1234`}
+	if !reflect.DeepEqual(cc["runcmd"], expected) {
+		t.Errorf("Unexpected runcmd %v", cc["runcmd"])
+	}
+	for _, k := range []string{"bootcmd", "write_files"} {
+		if _, ok := cc[k]; ok {
+			t.Errorf("Linux specific %s added to Windows cloud config", k)
+		}
+	}
+}
+
+func TestVirtioDriversArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "windows-tests-")
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	if args := virtioDriversArgs(dir); len(args) != 0 {
+		t.Errorf("Unexpected arguments without drivers %v", args)
+	}
+
+	src := path.Join(dir, "cached.iso")
+	if err := ioutil.WriteFile(src, []byte("iso"), 0600); err != nil {
+		t.Fatalf("Unable to write image: %v", err)
+	}
+	instanceDir := path.Join(dir, "instance")
+	if err := os.Mkdir(instanceDir, 0700); err != nil {
+		t.Fatalf("Unable to create instance directory: %v", err)
+	}
+	if err := linkImage(src, instanceDir, virtioDriversImage); err != nil {
+		t.Fatalf("Unable to link image: %v", err)
+	}
+
+	args := virtioDriversArgs(instanceDir)
+	if len(args) != 2 || !strings.HasPrefix(args[1], "file="+path.Join(instanceDir, virtioDriversImage)) {
+		t.Errorf("Unexpected arguments %v", args)
+	}
+}
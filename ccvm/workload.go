@@ -20,8 +20,8 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
-	"go/build"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -50,27 +50,69 @@ func init() {
 type workload struct {
 	spec           workloadSpec
 	userData       string
-	parent         *workload
+	parents        []*workload
 	mergedUserData []byte
 }
 
-func (wkld *workload) save(instanceDir string) error {
-	var buf bytes.Buffer
+// inheritance lists the workloads from which a workload inherits.  It is
+// specified either as a single workload name or as a list of names.
+type inheritance []string
+
+func (in *inheritance) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*in = nil
+		if name != "" {
+			*in = inheritance{name}
+		}
+		return nil
+	}
+
+	var names []string
+	if err := unmarshal(&names); err != nil {
+		return errors.New("inherits must be a workload name or a list of workload names")
+	}
+	*in = names
+	return nil
+}
+
+func (in inheritance) MarshalYAML() (interface{}, error) {
+	switch len(in) {
+	case 0:
+		return "", nil
+	case 1:
+		return in[0], nil
+	}
+	return []string(in), nil
+}
+
+// workloadRecord is the specification and the user data of an instance,
+// as stored in the specs bucket of the state store.  Older versions of
+// ccloudvm stored them as two YAML documents in the state.yaml file of the
+// instance directory.
+type workloadRecord struct {
+	Spec     string `json:"spec"`
+	UserData string `json:"user_data"`
+}
+
+const workloadStateFile = "state.yaml"
 
-	_, _ = buf.WriteString("---\n")
+func (wkld *workload) save(instanceDir string) error {
 	data, err := yaml.Marshal(wkld.spec)
 	if err != nil {
 		return errors.Wrap(err, "Unable to marshal instance specification")
 	}
-	_, _ = buf.Write(data)
-	_, _ = buf.WriteString("...\n")
-
-	_, _ = buf.WriteString("---\n")
-	_, _ = buf.Write(wkld.mergedUserData)
-	_, _ = buf.WriteString("...\n")
 
-	err = ioutil.WriteFile(path.Join(instanceDir, "state.yaml"),
-		buf.Bytes(), 0600)
+	st, err := instanceStore(instanceDir)
+	if err == nil {
+		rec := workloadRecord{
+			Spec:     string(data),
+			UserData: string(wkld.mergedUserData),
+		}
+		err = st.update(func(tx *storeTx) error {
+			return tx.put(bucketSpecs, path.Base(instanceDir), &rec)
+		})
+	}
 	if err != nil {
 		return errors.Wrap(err, "Unable to write instance state")
 	}
@@ -78,10 +120,75 @@ func (wkld *workload) save(instanceDir string) error {
 	return nil
 }
 
+// forgetWorkload removes the specification of the instance whose directory,
+// instanceDir, has been removed.
+func forgetWorkload(instanceDir string) {
+	st, err := instanceStore(instanceDir)
+	if err == nil {
+		err = st.update(func(tx *storeTx) error {
+			return tx.delete(bucketSpecs, path.Base(instanceDir))
+		})
+	}
+	if err != nil {
+		instanceDirLog(instanceDir).Warningf("Unable to remove instance state: %v", err)
+	}
+}
+
+// renameWorkload moves the specification of the instance whose directory
+// was instanceDir to that of newDir.
+func renameWorkload(instanceDir, newDir string) error {
+	st, err := instanceStore(instanceDir)
+	if err != nil {
+		return err
+	}
+
+	return st.update(func(tx *storeTx) error {
+		var rec workloadRecord
+		ok, err := tx.get(bucketSpecs, path.Base(instanceDir), &rec)
+		if err != nil || !ok {
+			return err
+		}
+		if err := tx.put(bucketSpecs, path.Base(newDir), &rec); err != nil {
+			return err
+		}
+		return tx.delete(bucketSpecs, path.Base(instanceDir))
+	})
+}
+
+// migrateWorkloadFiles moves the state.yaml files written in the instance
+// directories by older versions of ccloudvm to the state store.
+func migrateWorkloadFiles(tx *storeTx, ccvmDir string) error {
+	dirs, err := ioutil.ReadDir(path.Join(ccvmDir, "instances"))
+	if err != nil {
+		return nil
+	}
+
+	for _, d := range dirs {
+		statePath := path.Join(ccvmDir, "instances", d.Name(), workloadStateFile)
+		data, err := ioutil.ReadFile(statePath)
+		if err != nil {
+			continue
+		}
+
+		docs := splitYaml(data)
+		if len(docs) != 2 {
+			logWarningf("Not migrating invalid instance state %s", statePath)
+			continue
+		}
+		rec := workloadRecord{Spec: string(docs[0]), UserData: string(docs[1])}
+		if err := tx.put(bucketSpecs, d.Name(), &rec); err != nil {
+			return err
+		}
+		_ = os.Remove(statePath)
+	}
+
+	return nil
+}
+
 func (spec *workloadSpec) ensureSSHPortMapping() {
 	var i int
 	for i = 0; i < len(spec.VM.PortMappings); i++ {
-		if spec.VM.PortMappings[i].Guest == 22 {
+		if spec.VM.PortMappings[i].Guest == 22 && spec.VM.PortMappings[i].HostAddr == "" {
 			break
 		}
 	}
@@ -165,9 +272,22 @@ func loadWorkloadData(ctx context.Context, ws *workspace, workloadName string, t
 
 	// Absolute means that it has a non-empty scheme
 	if u.IsAbs() {
+		if isRemoteWorkload(u) {
+			return remoteWorkload(ctx, ws, u, transport)
+		}
+		if u.Scheme == "file" {
+			if err := ws.checkLocalFile(u.Path); err != nil {
+				return nil, err
+			}
+		}
 		return workloadFromURL(ctx, *u, transport)
 	}
 
+	if fi, err := os.Stat(workloadName); err == nil && !fi.IsDir() {
+		if err := ws.checkLocalFile(workloadName); err != nil {
+			return nil, err
+		}
+	}
 	wkld, err := ioutil.ReadFile(workloadName)
 	if err == nil {
 		return wkld, nil
@@ -179,11 +299,11 @@ func loadWorkloadData(ctx context.Context, ws *workspace, workloadName string, t
 		return wkld, nil
 	}
 
-	p, err := build.Default.Import(ccloudvmPkg, "", build.FindOnly)
+	dir, err := bundledWorkloadsDir()
 	if err != nil {
-		return nil, errors.Wrap(err, "Unable to locate ccloudvm workload directory")
+		return nil, err
 	}
-	workloadPath := filepath.Join(p.Dir, "workloads", fmt.Sprintf("%s.yaml", workloadName))
+	workloadPath := filepath.Join(dir, fmt.Sprintf("%s.yaml", workloadName))
 	wkld, err = ioutil.ReadFile(workloadPath)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to load workload %s", workloadPath)
@@ -207,18 +327,56 @@ func unmarshalWorkload(ws *workspace, wkld *workload, spec,
 func (wkld *workload) merge(parent *workload) {
 	if wkld.spec.BaseImageURL == "" {
 		wkld.spec.BaseImageURL = parent.spec.BaseImageURL
+		wkld.spec.BaseImageSHA256 = parent.spec.BaseImageSHA256
 	}
 
 	if wkld.spec.BaseImageName == "" {
 		wkld.spec.BaseImageName = parent.spec.BaseImageName
 	}
 
+	if wkld.spec.Distro == "" {
+		wkld.spec.Distro = parent.spec.Distro
+	}
+
+	if wkld.spec.Kernel == "" {
+		wkld.spec.Kernel = parent.spec.Kernel
+		wkld.spec.KernelSHA256 = parent.spec.KernelSHA256
+	}
+
+	if wkld.spec.VirtioDrivers == "" {
+		wkld.spec.VirtioDrivers = parent.spec.VirtioDrivers
+		wkld.spec.VirtioSHA256 = parent.spec.VirtioSHA256
+	}
+
+	if !wkld.spec.SSHCA {
+		wkld.spec.SSHCA = parent.spec.SSHCA
+	}
+	if !wkld.spec.SSHAgent {
+		wkld.spec.SSHAgent = parent.spec.SSHAgent
+	}
+
+	wkld.spec.Qemu.merge(&parent.spec.Qemu)
+
 	// Always better to require nested VM that not.
 	if !wkld.spec.NeedsNestedVM {
 		wkld.spec.NeedsNestedVM = parent.spec.NeedsNestedVM
 	}
 
 	wkld.spec.VM.Merge(&parent.spec.VM)
+
+	wkld.spec.Params = mergeParams(wkld.spec.Params, parent.spec.Params)
+
+	if len(wkld.spec.Ready) == 0 {
+		wkld.spec.Ready = parent.spec.Ready
+	}
+	if wkld.spec.ReadyTimeout == "" {
+		wkld.spec.ReadyTimeout = parent.spec.ReadyTimeout
+	}
+
+	wkld.spec.Hooks.merge(&parent.spec.Hooks)
+	wkld.spec.Kubeconfig.merge(&parent.spec.Kubeconfig)
+
+	wkld.spec.Labels = mergeLabels(wkld.spec.Labels, parent.spec.Labels)
 }
 
 type cloudConfig map[string]interface{}
@@ -265,28 +423,45 @@ func (cc cloudConfig) merge(p cloudConfig) error {
 	return nil
 }
 
-func (wkld *workload) parse(ws *workspace) (cloudConfig, error) {
+// workloadFuncMap contains the functions available to the cloud-init
+// documents of the workloads.
+var workloadFuncMap = template.FuncMap{
+	"proxyVars":       proxyVarsFN,
+	"proxyEnv":        proxyEnvFN,
+	"download":        downloadFN,
+	"beginTask":       beginTaskFN,
+	"endTaskCheck":    endTaskCheckFN,
+	"endTaskOk":       endTaskOkFN,
+	"endTaskFail":     endTaskFailFN,
+	"message":         messageFN,
+	"refreshPackages": refreshPackagesFN,
+	"installPackages": installPackagesFN,
+}
+
+// parse executes the cloud-init templates of the workload and of its
+// ancestors and merges the resulting documents.  Workloads in seen are
+// skipped.
+func (wkld *workload) parse(ws *workspace, seen map[string]bool) (cloudConfig, error) {
 	var p cloudConfig
-	var err error
-	if wkld.parent != nil {
-		p, err = wkld.parent.parse(ws)
+	for _, parent := range wkld.parents {
+		if seen[parent.spec.WorkloadName] {
+			continue
+		}
+		seen[parent.spec.WorkloadName] = true
+
+		pc, err := parent.parse(ws, seen)
 		if err != nil {
 			return nil, err
 		}
+		if p != nil {
+			if err = pc.merge(p); err != nil {
+				return nil, errors.Wrap(err, "Error merging cloud-config data")
+			}
+		}
+		p = pc
 	}
 
-	funcMap := template.FuncMap{
-		"proxyVars":    proxyVarsFN,
-		"proxyEnv":     proxyEnvFN,
-		"download":     downloadFN,
-		"beginTask":    beginTaskFN,
-		"endTaskCheck": endTaskCheckFN,
-		"endTaskOk":    endTaskOkFN,
-		"endTaskFail":  endTaskFailFN,
-		"message":      messageFN,
-	}
-
-	udt, err := template.New("user-data").Funcs(funcMap).Parse(wkld.userData)
+	udt, err := template.New("user-data").Funcs(workloadFuncMap).Parse(wkld.userData)
 	if err != nil {
 		return nil, errors.Wrap(err, "Unable to parse user data template")
 	}
@@ -320,38 +495,223 @@ func (wkld *workload) parse(ws *workspace) (cloudConfig, error) {
 	return cc, err
 }
 
+// hostAlias is a hostname that resolves, inside every guest, to the address
+// at which the host can be reached from the guest.
+const hostAlias = "host.ccloudvm.internal"
+
+// hostAliasCmd returns a command that adds hostAlias to the guest's
+// /etc/hosts, hostIP being the address of the host as seen from the guest.
+// It is executed as a bootcmd so that the alias is restored if /etc/hosts
+// is regenerated.
+func hostAliasCmd(hostIP string) string {
+	return fmt.Sprintf(`grep -q " %[2]s$" /etc/hosts || echo "%[1]s %[2]s" >> /etc/hosts`,
+		hostIP, hostAlias)
+}
+
+// clockCmd applies the instance's clock setting at boot.  The helper is not
+// yet installed when the bootcmds are run during the instance's creation, so
+// the host's clock is used until the instance is restarted.
+var clockCmd = fmt.Sprintf(`[ ! -x %[1]s ] || %[1]s clock`, guestHelperPath)
+
+// profilingSetupCmd installs perf and bpftrace in the guest and relaxes the
+// kernel's restrictions on their use.  It is appended to the runcmds of
+// instances created with profiling enabled.
+const profilingSetupCmd = `if command -v apt-get > /dev/null; then ` +
+	`apt-get install -y linux-tools-common linux-tools-generic linux-tools-$(uname -r) bpftrace; ` +
+	`elif command -v dnf > /dev/null; then dnf install -y perf bpftrace; ` +
+	`elif command -v yum > /dev/null; then yum install -y perf bpftrace; ` +
+	`elif command -v zypper > /dev/null; then zypper --non-interactive install perf bpftrace; fi; ` +
+	`echo kernel.perf_event_paranoid=-1 > /etc/sysctl.d/60-profiling.conf; ` +
+	`echo kernel.kptr_restrict=0 >> /etc/sysctl.d/60-profiling.conf; ` +
+	`sysctl -p /etc/sysctl.d/60-profiling.conf`
+
+// aptRetriesPath configures apt, in the guest, to retry failed downloads and
+// to wait for the package database lock held by other package managers, such
+// as unattended-upgrades, rather than failing.
+const aptRetriesPath = "/etc/apt/apt.conf.d/80ccloudvm-retries"
+
+func aptRetriesConf(retry retryPolicy) string {
+	return fmt.Sprintf("Acquire::Retries \"%d\";\nDPkg::Lock::Timeout \"%d\";\n",
+		retry.attempts-1, retry.aptLockTimeout())
+}
+
+// addAuthorizedKeys authorizes keys to access the account of user, which
+// must be defined in the users section of the cloud-config document data.
+func addAuthorizedKeys(data cloudConfig, user string, keys []string) error {
+	users, _ := data["users"].([]interface{})
+	for _, u := range users {
+		entry, ok := u.(map[interface{}]interface{})
+		if !ok || entry["name"] != user {
+			continue
+		}
+
+		field := "ssh-authorized-keys"
+		if _, ok := entry["ssh_authorized_keys"]; ok {
+			field = "ssh_authorized_keys"
+		}
+		authorized, _ := entry[field].([]interface{})
+		for _, k := range keys {
+			authorized = append(authorized, k)
+		}
+		entry[field] = authorized
+		return nil
+	}
+
+	return errors.Errorf("Unable to authorize SSH agent keys, user %s is not defined by the workload", user)
+}
+
 func (wkld *workload) generateCloudConfig(ws *workspace) error {
-	data, err := wkld.parse(ws)
+	ws.Distro = wkld.spec.Distro
+	data, err := wkld.parse(ws, map[string]bool{})
 	if err != nil {
 		return errors.Wrap(err, "Error parsing workload")
 	}
+	if ws.userData != nil {
+		data, err = ws.userData.apply(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The documents of Windows guests are run by Cloudbase-Init and
+	// are only given the command that reports the end of the
+	// installation.
+	windows := wkld.spec.windows()
 
-	finishedStr := fmt.Sprintf(`curl -X PUT -d "FINISHED" 10.0.2.2:%d`,
-		ws.HTTPServerPort)
+	if !windows {
+		var bootcmds []interface{}
+		if v, ok := data["bootcmd"]; ok {
+			bootcmds = v.([]interface{})
+		}
+		data["bootcmd"] = append([]interface{}{hostAliasCmd(ws.network.hostIP()), clockCmd}, bootcmds...)
+	}
+
+	// The documents served to the other datasources are only available
+	// while the instance is being created.
+	if datasourceType(&wkld.spec.VM) != types.DatasourceNoCloud {
+		data["manual_cache_clean"] = true
+	}
+
+	// The agent keys of Windows guests are passed in their meta data.
+	if len(ws.agentKeys) > 0 && !windows {
+		if err := addAuthorizedKeys(data, ws.User, ws.agentKeys); err != nil {
+			return err
+		}
+	}
+	if ws.profile != nil {
+		if err := ws.profile.applyCloudConfig(data, ws.User); err != nil {
+			return err
+		}
+	}
+
+	if windows {
+		var cmds []interface{}
+		if v, ok := data["runcmd"]; ok {
+			cmds = v.([]interface{})
+		}
+		data["runcmd"] = append(cmds, fmt.Sprintf(`curl.exe -X PUT -d "FINISHED" %s:%d`,
+			ws.network.hostIP(), ws.HTTPServerPort))
+		return wkld.marshalCloudConfig(ws, data)
+	}
+
+	var files []interface{}
+	if v, ok := data["write_files"]; ok {
+		files = v.([]interface{})
+	}
+	data["write_files"] = append(files, map[interface{}]interface{}{
+		"path":        guestHelperPath,
+		"permissions": "0755",
+		"encoding":    "b64",
+		"content":     base64.StdEncoding.EncodeToString([]byte(guestHelperScript)),
+	})
+	if ws.retry.attempts > 1 {
+		data["write_files"] = append(data["write_files"].([]interface{}),
+			ws.distro().retriesFile(ws.retry))
+	}
+
+	caches := len(wkld.spec.VM.Caches) > 0
+	if caches {
+		data["write_files"] = append(data["write_files"].([]interface{}),
+			map[interface{}]interface{}{
+				"path":        cacheScriptPath,
+				"permissions": "0755",
+				"encoding":    "b64",
+				"content":     base64.StdEncoding.EncodeToString([]byte(cacheScript)),
+			},
+			map[interface{}]interface{}{
+				"path":    cacheUnitPath,
+				"content": cacheUnit,
+			},
+			map[interface{}]interface{}{
+				"path":    cacheConfPath,
+				"content": cacheConfig(&wkld.spec.VM, ws.User),
+			})
+	}
+
+	var cmds []interface{}
 	if v, ok := data["runcmd"]; ok {
-		runcmds := v.([]interface{})
-		runcmds = append(runcmds, finishedStr)
-		data["runcmd"] = runcmds
-	} else {
-		data["runcmd"] = []string{finishedStr}
+		cmds = v.([]interface{})
+	}
+
+	if caches {
+		cmds = append([]interface{}{cacheSetupCmd}, cmds...)
+	}
+
+	if wkld.spec.VM.Profiling {
+		cmds = append(cmds, profilingSetupCmd)
 	}
 
+	if caches {
+		cmds = append(cmds, cachePublishCmd)
+	}
+
+	finishedStr := fmt.Sprintf(`curl -X PUT -d "FINISHED" %s:%d`,
+		ws.network.hostIP(), ws.HTTPServerPort)
+	data["runcmd"] = append(cmds, finishedStr)
+
+	return wkld.marshalCloudConfig(ws, data)
+}
+
+// marshalCloudConfig stores the cloud-config document data, combined with
+// the other parts of the user data passed to create, in wkld.
+func (wkld *workload) marshalCloudConfig(ws *workspace, data cloudConfig) error {
 	output, err := yaml.Marshal(data)
 	if err != nil {
 		return errors.Wrap(err, "Error marshalling cloud-config")
 	}
 
 	wkld.mergedUserData = append([]byte("#cloud-config\n"), output...)
+	if ws.userData != nil && len(ws.userData.parts) > 0 {
+		wkld.mergedUserData, err = ws.userData.multipart(wkld.mergedUserData)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
 func createWorkload(ctx context.Context, ws *workspace, workloadName string, transport *http.Transport) (*workload, error) {
+	return loadWorkload(ctx, ws, workloadName, transport, nil)
+}
+
+// loadWorkload loads the workload workloadName and its ancestors.
+// descendants lists the workloads that inherit, directly or not, from
+// workloadName and is used to detect inheritance loops.
+func loadWorkload(ctx context.Context, ws *workspace, workloadName string, transport *http.Transport,
+	descendants []string) (*workload, error) {
 	data, err := loadWorkloadData(ctx, ws, workloadName, transport)
 	if err != nil {
 		return nil, err
 	}
 
+	return newWorkload(ctx, ws, workloadName, data, transport, descendants)
+}
+
+// newWorkload creates the workload workloadName from its definition, data,
+// and loads its ancestors.
+func newWorkload(ctx context.Context, ws *workspace, workloadName string, data []byte,
+	transport *http.Transport, descendants []string) (*workload, error) {
 	var wkld workload
 	var spec, userData string
 	docs := splitYaml(data)
@@ -362,7 +722,7 @@ func createWorkload(ctx context.Context, ws *workspace, workloadName string, tra
 		return nil, errors.New("Invalid workload; two documents required")
 	}
 
-	err = unmarshalWorkload(ws, &wkld, spec, userData)
+	err := unmarshalWorkload(ws, &wkld, spec, userData)
 	if err != nil {
 		return nil, err
 	}
@@ -370,39 +730,78 @@ func createWorkload(ctx context.Context, ws *workspace, workloadName string, tra
 		wkld.spec.WorkloadName = workloadName
 	}
 
-	if wkld.spec.Inherits != "" {
-		wkld.parent, err = createWorkload(ctx, ws, wkld.spec.Inherits, transport)
+	descendants = append(descendants[:len(descendants):len(descendants)], workloadName)
+	for _, name := range wkld.spec.Inherits {
+		for _, n := range descendants {
+			if n == name {
+				return nil, errors.Errorf("Workload %s inherits from itself", name)
+			}
+		}
+		parent, err := loadWorkload(ctx, ws, name, transport, descendants)
 		if err != nil {
-			return nil, errors.Wrapf(err, "Error loading inherited workload: %s", wkld.spec.Inherits)
+			return nil, errors.Wrapf(err, "Error loading inherited workload: %s", name)
 		}
-		wkld.merge(wkld.parent)
-	} else {
+		wkld.parents = append(wkld.parents, parent)
+	}
+
+	// The parents are merged from the last to the first, as merge only
+	// sets the fields that are not already set.
+	for i := len(wkld.parents) - 1; i >= 0; i-- {
+		wkld.merge(wkld.parents[i])
+	}
+	if len(wkld.parents) == 0 {
 		wkld.merge(defaultWorkload())
 	}
 
+	if _, err := lookupDistro(wkld.spec.Distro); err != nil {
+		return nil, err
+	}
+
+	if err := checkParams(wkld.spec.Params); err != nil {
+		return nil, errors.Wrapf(err, "Invalid parameters in workload %s", workloadName)
+	}
+
+	if err := checkReadyChecks(wkld.spec.Ready, wkld.spec.ReadyTimeout); err != nil {
+		return nil, errors.Wrapf(err, "Invalid readiness checks in workload %s", workloadName)
+	}
+
 	wkld.spec.ensureSSHPortMapping()
+	if wkld.spec.windows() {
+		wkld.spec.ensureRDPPortMapping()
+	}
 
 	return &wkld, nil
 }
 
 func restoreWorkload(ws *workspace) (*workload, error) {
-	var wkld workload
-	data, err := ioutil.ReadFile(path.Join(ws.instanceDir, "state.yaml"))
+	st, err := instanceStore(ws.instanceDir)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error reading workload")
 	}
 
-	docs := splitYaml(data)
-	if len(docs) != 2 {
-		return nil, errors.New("Invalid workload; must have two documents")
-
+	var rec workloadRecord
+	var ok bool
+	err = st.view(func(tx *storeTx) error {
+		ok, err = tx.get(bucketSpecs, path.Base(ws.instanceDir), &rec)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading workload")
+	}
+	if !ok {
+		return nil, errors.Errorf("Error reading workload: no state recorded for %s",
+			path.Base(ws.instanceDir))
 	}
 
-	err = unmarshalWorkload(ws, &wkld, string(docs[0]), string(docs[1]))
+	var wkld workload
+	err = unmarshalWorkload(ws, &wkld, rec.Spec, rec.UserData)
 	return &wkld, err
 }
 
-func findDocument(lines [][]byte) ([]byte, int) {
+// findDocument returns the last document of lines, the index of the first
+// line that belongs to it, including its directives and start marker, and
+// the index of the first line of its content.
+func findDocument(lines [][]byte) ([]byte, int, int) {
 	var realStart int
 	var realEnd int
 	docStartFound := false
@@ -454,10 +853,17 @@ func findDocument(lines [][]byte) ([]byte, int) {
 		_ = buf.WriteByte('\n')
 	}
 
-	return buf.Bytes(), start
+	return buf.Bytes(), start, realStart
 }
 
 func splitYaml(data []byte) [][]byte {
+	docs, _ := splitYamlLines(data)
+	return docs
+}
+
+// splitYamlLines splits data into documents and also returns the number of
+// the line of data, counting from 1, at which each document starts.
+func splitYamlLines(data []byte) ([][]byte, []int) {
 	lines := make([][]byte, 0, 256)
 	docs := make([][]byte, 0, 3)
 
@@ -470,12 +876,15 @@ func splitYaml(data []byte) [][]byte {
 		lines = append(lines, lineC)
 	}
 
+	var starts []int
 	endOfNextDoc := len(lines)
 	for endOfNextDoc > 0 {
 		var doc []byte
-		doc, endOfNextDoc = findDocument(lines[:endOfNextDoc])
+		var start int
+		doc, endOfNextDoc, start = findDocument(lines[:endOfNextDoc])
 		docs = append([][]byte{doc}, docs...)
+		starts = append([]int{start + 1}, starts...)
 	}
 
-	return docs
+	return docs, starts
 }
@@ -18,6 +18,7 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"io/ioutil"
 	"os"
 	"reflect"
@@ -25,6 +26,7 @@ import (
 
 	"github.com/intel/ccloudvm/types"
 	"github.com/pmezard/go-difflib/difflib"
+	yaml "gopkg.in/yaml.v2"
 )
 
 const document1 = `# Just a simple document
@@ -236,6 +238,7 @@ func level0spec() workloadSpec {
 	spec := workloadSpec{
 		BaseImageURL:  "https://mirror.us-midwest-1.nexcess.net/fedora/releases/27/CloudImages/x86_64/images/Fedora-Cloud-Base-27-1.6.x86_64.qcow2",
 		BaseImageName: "Fedora 27",
+		Distro:        defaultDistro,
 		NeedsNestedVM: true,
 		VM:            defaultVMSpec(),
 		WorkloadName:  "level0",
@@ -257,7 +260,7 @@ func level1spec() workloadSpec {
 			Path:          "/tmp",
 		},
 	}
-	spec.Inherits = "level0"
+	spec.Inherits = inheritance{"level0"}
 	spec.WorkloadName = "level1"
 
 	return spec
@@ -266,7 +269,7 @@ func level1spec() workloadSpec {
 func level2spec() workloadSpec {
 	spec := level1spec()
 	spec.VM.MemMiB = 256
-	spec.Inherits = "level1"
+	spec.Inherits = inheritance{"level1"}
 	spec.WorkloadName = "level2"
 
 	return spec
@@ -274,7 +277,7 @@ func level2spec() workloadSpec {
 
 func invalid1spec() workloadSpec {
 	spec := level0spec()
-	spec.Inherits = "level0"
+	spec.Inherits = inheritance{"level0"}
 	spec.WorkloadName = "invalid1"
 
 	return spec
@@ -282,21 +285,34 @@ func invalid1spec() workloadSpec {
 
 func invalid2spec() workloadSpec {
 	spec := invalid1spec()
-	spec.Inherits = "invalid1"
+	spec.Inherits = inheritance{"invalid1"}
 	spec.WorkloadName = "invalid2"
 
 	return spec
 }
 
+const hostAliasCloudConfig = `bootcmd:
+- grep -q " host.ccloudvm.internal$" /etc/hosts || echo "10.0.2.2 host.ccloudvm.internal"
+  >> /etc/hosts
+- '[ ! -x /usr/local/bin/ccvm-guest ] || /usr/local/bin/ccvm-guest clock'
+`
+
+var guestHelperCloudConfig = `write_files:
+- content: ` + base64.StdEncoding.EncodeToString([]byte(guestHelperScript)) + `
+  encoding: b64
+  path: /usr/local/bin/ccvm-guest
+  permissions: "0755"
+`
+
 var level0cloudConfig = `#cloud-config
 base: value
-runcmd:
+` + hostAliasCloudConfig + `runcmd:
 - curl -X PUT -d "FINISHED" 10.0.2.2:0
-`
+` + guestHelperCloudConfig
 
 var level1cloudConfig = `#cloud-config
 base: value
-map:
+` + hostAliasCloudConfig + `map:
   key1: value1
 runcmd:
 - command 1
@@ -304,11 +320,11 @@ runcmd:
 seq:
 - command 1
 - command 2
-`
+` + guestHelperCloudConfig
 
 var level2cloudConfig = `#cloud-config
 base: value
-extra: value
+` + hostAliasCloudConfig + `extra: value
 map:
   key1: value1
   key2: value2
@@ -320,20 +336,20 @@ seq:
 - command 1
 - command 2
 - command 3
-`
+` + guestHelperCloudConfig
 
 var invalid1cloudConfig = `#cloud-config
 base: value
-runcmd:
+` + hostAliasCloudConfig + `runcmd:
 - curl -X PUT -d "FINISHED" 10.0.2.2:0
-`
+` + guestHelperCloudConfig
 
 var invalid2cloudConfig = `#cloud-config
 base: value
-runcmd:
+` + hostAliasCloudConfig + `runcmd:
 - test
 - curl -X PUT -d "FINISHED" 10.0.2.2:0
-`
+` + guestHelperCloudConfig
 
 func TestWorkloadInheritance(t *testing.T) {
 	workloads := []struct {
@@ -422,6 +438,71 @@ func TestWorkloadInheritance(t *testing.T) {
 	}
 }
 
+func TestMultipleInheritance(t *testing.T) {
+	workloads := []struct {
+		body string
+		name string
+	}{
+		{level0Document, "level0"},
+		{"vm:\n  mem_mib: 128\ninherits: level0\n...\n---\nruncmd:\n- left\n", "left"},
+		{"vm:\n  mem_mib: 256\n  cpus: 4\ninherits: level0\n...\n---\nruncmd:\n- right\n", "right"},
+		{"inherits: [left, right]\n...\n---\nruncmd:\n- combined\n", "combined"},
+		{"inherits: loop2\n...\n---\n", "loop1"},
+		{"inherits: [level0, loop1]\n...\n---\n", "loop2"},
+	}
+
+	ccvmDir, err := ioutil.TempDir("", "ccloudvm-tests-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+
+	defer func() {
+		if err := os.RemoveAll(ccvmDir); err != nil {
+			t.Errorf("Failed to remove %s : %v", ccvmDir, err)
+		}
+	}()
+
+	var ws *workspace
+	for i := range workloads {
+		ws, err = createMockWorkSpaceWithWorkload(workloads[i].body, workloads[i].name, ccvmDir)
+		if err != nil {
+			t.Fatalf("Failed to create mock workload: %v", err)
+		}
+	}
+
+	wkld, err := createWorkload(context.TODO(), ws, "combined", nil)
+	if err != nil {
+		t.Fatalf("Error creating workload: %v", err)
+	}
+
+	if wkld.spec.VM.MemMiB != 256 || wkld.spec.VM.CPUs != 4 || !wkld.spec.NeedsNestedVM {
+		t.Errorf("Parents not merged as expected: %+v", wkld.spec)
+	}
+
+	err = wkld.generateCloudConfig(ws)
+	if err != nil {
+		t.Fatalf("Error generating cloud config data: %v", err)
+	}
+
+	var cc struct {
+		Base   string   `yaml:"base"`
+		Runcmd []string `yaml:"runcmd"`
+	}
+	err = yaml.Unmarshal(wkld.mergedUserData, &cc)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal cloud config: %v", err)
+	}
+
+	if cc.Base != "value" || len(cc.Runcmd) != 4 ||
+		!reflect.DeepEqual(cc.Runcmd[:3], []string{"left", "right", "combined"}) {
+		t.Errorf("Unexpected cloud config %s", string(wkld.mergedUserData))
+	}
+
+	if _, err = createWorkload(context.TODO(), ws, "loop1", nil); err == nil {
+		t.Errorf("Inheritance loop not detected")
+	}
+}
+
 var emptyWorkloadDocument = `
 ...
 ---
@@ -456,3 +537,129 @@ func TestBaseWorkload(t *testing.T) {
 		t.Fatalf("Default workload expected")
 	}
 }
+
+func TestProfilingCloudConfig(t *testing.T) {
+	ws := &workspace{HTTPServerPort: 1234, network: defaultNetwork()}
+	wkld := &workload{userData: "runcmd:\n- command 1\n"}
+
+	wkld.spec.VM.Profiling = true
+	err := wkld.generateCloudConfig(ws)
+	if err != nil {
+		t.Fatalf("Failed to generate cloud config: %v", err)
+	}
+
+	var cc struct {
+		Runcmd []string `yaml:"runcmd"`
+	}
+	err = yaml.Unmarshal(wkld.mergedUserData, &cc)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal cloud config: %v", err)
+	}
+
+	if len(cc.Runcmd) != 3 || cc.Runcmd[1] != profilingSetupCmd {
+		t.Errorf("Profiling setup command not found in %v", cc.Runcmd)
+	}
+}
+
+func TestHostAliasCloudConfig(t *testing.T) {
+	ws := &workspace{HTTPServerPort: 1234, network: defaultNetwork()}
+	wkld := &workload{userData: "bootcmd:\n- command 1\n"}
+
+	err := wkld.generateCloudConfig(ws)
+	if err != nil {
+		t.Fatalf("Failed to generate cloud config: %v", err)
+	}
+
+	var cc struct {
+		Bootcmd []string `yaml:"bootcmd"`
+	}
+	err = yaml.Unmarshal(wkld.mergedUserData, &cc)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal cloud config: %v", err)
+	}
+
+	if len(cc.Bootcmd) != 3 || cc.Bootcmd[0] != hostAliasCmd("10.0.2.2") || cc.Bootcmd[1] != clockCmd ||
+		cc.Bootcmd[2] != "command 1" {
+		t.Errorf("Unexpected bootcmds %v", cc.Bootcmd)
+	}
+}
+
+func TestMountCloudConfig(t *testing.T) {
+	ws := &workspace{
+		HTTPServerPort: 1234,
+		network:        defaultNetwork(),
+		Mounts: []types.Mount{
+			{Tag: "tag1", Path: "/path1"},
+			{Tag: "tag2", Path: "/path2", Type: types.MountTypeVirtiofs},
+		},
+	}
+	wkld := &workload{userData: "runcmd:\n{{range .Mounts}}- {{.FstabEntry}}\n{{end}}"}
+
+	err := wkld.generateCloudConfig(ws)
+	if err != nil {
+		t.Fatalf("Failed to generate cloud config: %v", err)
+	}
+
+	var cc struct {
+		Runcmd []string `yaml:"runcmd"`
+	}
+	err = yaml.Unmarshal(wkld.mergedUserData, &cc)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal cloud config: %v", err)
+	}
+
+	expected := []string{
+		"tag1 /path1 9p x-systemd.automount,x-systemd.device-timeout=10,nofail,trans=virtio,version=9p2000.L 0 0",
+		"tag2 /path2 virtiofs x-systemd.automount,x-systemd.device-timeout=10,nofail 0 0",
+	}
+	if len(cc.Runcmd) < len(expected) || !reflect.DeepEqual(cc.Runcmd[:len(expected)], expected) {
+		t.Errorf("Unexpected mount entries %v", cc.Runcmd)
+	}
+}
+
+func TestBadMountType(t *testing.T) {
+	var in types.VMSpec
+	data := "mounts:\n- tag: tag1\n  path: " + os.TempDir() + "\n  type: nfs\n"
+	if err := unmarshal(&in, []byte(data)); err == nil {
+		t.Errorf("Expected unmarshal of unsupported mount type to fail")
+	}
+}
+
+func TestAgentKeysCloudConfig(t *testing.T) {
+	keys := []string{"ssh-ed25519 AAAAC3Nza user@laptop", "ssh-rsa AAAAB3Nza user@desktop"}
+	if err := checkAgentKeys(keys); err != nil {
+		t.Fatalf("Valid agent keys rejected: %v", err)
+	}
+	if err := checkAgentKeys([]string{"ssh-rsa AAAA\nssh-rsa BBBB"}); err == nil {
+		t.Errorf("Multi-line agent key accepted")
+	}
+
+	ws := &workspace{User: "user", HTTPServerPort: 1234, network: defaultNetwork(), agentKeys: keys}
+	wkld := &workload{userData: "users:\n- name: user\n  ssh-authorized-keys:\n  - ssh-ed25519 AAAAinstance\n"}
+
+	err := wkld.generateCloudConfig(ws)
+	if err != nil {
+		t.Fatalf("Failed to generate cloud config: %v", err)
+	}
+
+	var cc struct {
+		Users []struct {
+			Name string   `yaml:"name"`
+			Keys []string `yaml:"ssh-authorized-keys"`
+		} `yaml:"users"`
+	}
+	err = yaml.Unmarshal(wkld.mergedUserData, &cc)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal cloud config: %v", err)
+	}
+
+	if len(cc.Users) != 1 || len(cc.Users[0].Keys) != 3 || cc.Users[0].Keys[0] != "ssh-ed25519 AAAAinstance" ||
+		cc.Users[0].Keys[1] != keys[0] || cc.Users[0].Keys[2] != keys[1] {
+		t.Errorf("Agent keys not authorized %+v", cc.Users)
+	}
+
+	ws.User = "other"
+	if err := wkld.generateCloudConfig(ws); err == nil {
+		t.Errorf("Expected error for undefined user")
+	}
+}
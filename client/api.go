@@ -0,0 +1,350 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/rpc"
+	"reflect"
+	"sync"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcStreamResult struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+}
+
+// apiMethod describes a method of the daemon API.  arg and reply are values
+// of the types of the method's argument and of its result.  If stream is
+// true, the method's Result method is called until it returns a result
+// whose Finished field is true, or it fails.
+type apiMethod struct {
+	arg    interface{}
+	reply  interface{}
+	stream bool
+}
+
+var apiMethods = map[string]apiMethod{
+	"Create":             {types.CreateArgs{}, types.CreateResult{}, true},
+	"CreateGroup":        {types.CreateArgs{}, types.CreateResult{}, true},
+	"Stop":               {types.StopArgs{}, types.StopResult{}, true},
+	"Start":              {types.StartArgs{}, types.CommandResult{}, true},
+	"Restart":            {types.RestartArgs{}, types.CommandResult{}, true},
+	"Resize":             {types.ResizeArgs{}, types.ResizeResult{}, false},
+	"AddForward":         {types.ForwardArgs{}, types.CommandResult{}, true},
+	"RemoveForward":      {types.ForwardArgs{}, types.CommandResult{}, true},
+	"Mount":              {types.MountArgs{}, types.CommandResult{}, true},
+	"Unmount":            {types.MountArgs{}, types.CommandResult{}, true},
+	"AttachDisk":         {types.DiskArgs{}, types.CommandResult{}, true},
+	"DetachDisk":         {types.DiskArgs{}, types.CommandResult{}, true},
+	"Quit":               {"", types.CommandResult{}, true},
+	"Batch":              {types.BatchArgs{}, types.BatchResult{}, false},
+	"Delete":             {"", types.CommandResult{}, true},
+	"Fsck":               {types.FsckArgs{}, types.FsckResult{}, false},
+	"Flatten":            {"", types.CommandResult{}, true},
+	"Rename":             {types.RenameArgs{}, types.CommandResult{}, true},
+	"Backup":             {types.BackupArgs{}, types.BackupInfo{}, false},
+	"ListBackups":        {types.BackupListArgs{}, types.BackupList{}, false},
+	"RestoreBackup":      {types.RestoreArgs{}, struct{}{}, false},
+	"SetBackupPolicy":    {types.BackupPolicy{}, struct{}{}, false},
+	"Suspend":            {types.SuspendArgs{}, []string{}, false},
+	"ResumeFromDisk":     {types.ResumeArgs{}, []string{}, false},
+	"Set":                {types.SetArgs{}, struct{}{}, false},
+	"SyncStatus":         {"", types.SyncStatusResult{}, false},
+	"PauseSync":          {"", struct{}{}, false},
+	"ResumeSync":         {"", struct{}{}, false},
+	"GetInstanceDetails": {"", types.InstanceDetails{}, false},
+	"GetSSHKey":          {"", types.SSHKeyResult{}, false},
+	"GetKubeconfig":      {"", types.KubeconfigResult{}, false},
+	"ImportVagrant":      {types.ImportVagrantArgs{}, types.ImportVagrantResult{}, false},
+	"RefreshStatus":      {types.RefreshStatusArgs{}, types.RefreshStatusResult{}, false},
+	"GetInstances":       {types.InstancesArgs{}, []types.InstanceSummary{}, false},
+	"StartGroup":         {types.GroupArgs{}, struct{}{}, false},
+	"StopGroup":          {types.GroupArgs{}, struct{}{}, false},
+	"QuitGroup":          {types.GroupArgs{}, struct{}{}, false},
+	"DeleteGroup":        {types.GroupArgs{}, struct{}{}, false},
+	"WatchEvents":        {types.WatchEventsArgs{}, types.InstanceEvent{}, true},
+	"Report":             {types.ReportArgs{}, types.ReportResult{}, false},
+	"GetAuditLog":        {types.AuditLogArgs{}, []types.AuditRecord{}, false},
+	"Preflight":          {types.PreflightArgs{}, types.PreflightResult{}, false},
+	"GetConsoleLog":      {types.ConsoleLogArgs{}, types.ConsoleOutput{}, true},
+	"SendConsoleInput":   {types.ConsoleInputArgs{}, struct{}{}, false},
+	"CreateNetwork":      {types.NetworkSpec{}, struct{}{}, false},
+	"DeleteNetwork":      {"", struct{}{}, false},
+	"ListNetworks":       {struct{}{}, []types.NetworkInfo{}, false},
+	"CreateVolume":       {types.VolumeSpec{}, struct{}{}, false},
+	"DeleteVolume":       {"", struct{}{}, false},
+	"ListVolumes":        {struct{}{}, []types.VolumeInfo{}, false},
+	"ListCaches":         {struct{}{}, []types.CacheInfo{}, false},
+	"ClearCache":         {"", struct{}{}, false},
+	"Prefetch":           {types.CreateArgs{}, types.CreateResult{}, true},
+	"UpdateWorkloads":    {[]string{}, []string{}, false},
+	"GetWorkloads":       {struct{}{}, []types.WorkloadInfo{}, false},
+	"ShowWorkload":       {"", types.WorkloadDetails{}, false},
+	"ValidateWorkload":   {types.ValidateWorkloadArgs{}, []types.WorkloadProblem{}, false},
+	"Export":             {types.ExportArgs{}, struct{}{}, false},
+	"BuildTemplate":      {types.BuildTemplateArgs{}, types.TemplateInfo{}, false},
+	"Import":             {types.ImportArgs{}, "", false},
+	"Push":               {types.PushArgs{}, struct{}{}, false},
+	"Pull":               {types.PullArgs{}, "", false},
+	"Exec":               {types.ExecArgs{}, types.ExecOutput{}, true},
+	"Proxy":              {types.ProxyArgs{}, types.ProxyStatus{}, true},
+	"Drain":              {types.DrainArgs{}, struct{}{}, false},
+	"SetLogLevel":        {types.SetLogLevelArgs{}, "", false},
+}
+
+// finished returns true if reply, a pointer to a streamed result, is the
+// last result of its request.
+func finished(reply interface{}) bool {
+	v := reflect.ValueOf(reply).Elem()
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+	f := v.FieldByName("Finished")
+	return f.IsValid() && f.Kind() == reflect.Bool && f.Bool()
+}
+
+type stdioServer struct {
+	ctx     context.Context
+	enc     *json.Encoder
+	encLock sync.Mutex
+	lock    sync.Mutex
+	cancels map[string]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func (s *stdioServer) write(v interface{}) {
+	s.encLock.Lock()
+	_ = s.enc.Encode(v)
+	s.encLock.Unlock()
+}
+
+func (s *stdioServer) respond(id json.RawMessage, result interface{}, err *rpcError) {
+	// Notifications do not receive responses.
+	if len(id) == 0 {
+		return
+	}
+	if err == nil && result == nil {
+		result = struct{}{}
+	}
+	s.write(&rpcResponse{JSONRPC: "2.0", ID: id, Result: result, Error: err})
+}
+
+func (s *stdioServer) cancel(params json.RawMessage) *rpcError {
+	var p struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil || len(p.ID) == 0 {
+		return &rpcError{Code: rpcInvalidParams, Message: "Cancel expects the id of a request"}
+	}
+
+	s.lock.Lock()
+	cancel := s.cancels[string(p.ID)]
+	s.lock.Unlock()
+	if cancel == nil {
+		return &rpcError{Code: rpcServerError, Message: "Request " + string(p.ID) + " is not in progress"}
+	}
+	cancel()
+	return nil
+}
+
+func (s *stdioServer) call(ctx context.Context, req *rpcRequest, m apiMethod, arg interface{}) {
+	var reply interface{}
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI."+req.Method, arg, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			for {
+				reply = reflect.New(reflect.TypeOf(m.reply)).Interface()
+				err := client.Call("ServerAPI."+req.Method+"Result", id, reply)
+				if err != nil {
+					return err
+				}
+				if !m.stream || finished(reply) {
+					return nil
+				}
+				if len(req.ID) != 0 {
+					s.write(&rpcNotification{
+						JSONRPC: "2.0",
+						Method:  "result",
+						Params:  rpcStreamResult{ID: req.ID, Result: reply},
+					})
+				}
+			}
+		})
+	if err != nil {
+		s.respond(req.ID, nil, &rpcError{Code: rpcServerError, Message: err.Error()})
+		return
+	}
+	s.respond(req.ID, reply, nil)
+}
+
+func (s *stdioServer) handle(line []byte) {
+	var req rpcRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		s.write(&rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+		return
+	}
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		s.respond(req.ID, nil, &rpcError{Code: rpcInvalidRequest, Message: "Invalid request"})
+		return
+	}
+
+	if req.Method == "Cancel" {
+		s.respond(req.ID, nil, s.cancel(req.Params))
+		return
+	}
+
+	m, ok := apiMethods[req.Method]
+	if !ok {
+		s.respond(req.ID, nil, &rpcError{Code: rpcMethodNotFound,
+			Message: "Unknown method " + req.Method})
+		return
+	}
+
+	arg := reflect.New(reflect.TypeOf(m.arg)).Interface()
+	if len(req.Params) != 0 && string(req.Params) != "null" {
+		if err := json.Unmarshal(req.Params, arg); err != nil {
+			s.respond(req.ID, nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	key := string(req.ID)
+	if key != "" {
+		s.lock.Lock()
+		if _, ok := s.cancels[key]; ok {
+			s.lock.Unlock()
+			cancel()
+			s.respond(req.ID, nil, &rpcError{Code: rpcInvalidRequest,
+				Message: "Request " + key + " is already in progress"})
+			return
+		}
+		s.cancels[key] = cancel
+		s.lock.Unlock()
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.call(ctx, &req, m, arg)
+		cancel()
+		if key != "" {
+			s.lock.Lock()
+			delete(s.cancels, key)
+			s.lock.Unlock()
+		}
+	}()
+}
+
+// ServeStdio implements a JSON-RPC 2.0 interface to the daemon API, reading
+// one request per line from in and writing one response per line to out.
+// Streamed results are sent as "result" notifications.
+func ServeStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	s := &stdioServer{
+		ctx:     ctx,
+		enc:     json.NewEncoder(out),
+		cancels: make(map[string]context.CancelFunc),
+	}
+
+	lines := make(chan []byte)
+	errCh := make(chan error, 1)
+	go func() {
+		reader := bufio.NewReader(in)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				errCh <- err
+				return
+			}
+		}
+	}()
+
+	var err error
+DONE:
+	for {
+		select {
+		case line := <-lines:
+			if len(bytes.TrimSpace(line)) > 0 {
+				s.handle(line)
+			}
+		case err = <-errCh:
+			break DONE
+		case <-ctx.Done():
+			break DONE
+		}
+	}
+
+	s.wg.Wait()
+	return err
+}
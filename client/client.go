@@ -18,6 +18,7 @@ package client
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -29,13 +30,13 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 	"text/tabwriter"
 	"time"
 
-	"github.com/ciao-project/ciao/osprepare"
 	"github.com/intel/ccloudvm/types"
 	"github.com/pkg/errors"
 )
@@ -55,32 +56,13 @@ func (l logger) Infof(s string, args ...interface{}) {
 }
 
 func (l logger) Warningf(s string, args ...interface{}) {
-	l.Infof(s, args)
+	l.Infof(s, args...)
 }
 
 func (l logger) Errorf(s string, args ...interface{}) {
-	l.Infof(s, args)
+	l.Infof(s, args...)
 }
 
-const systemdService = `
-[Unit]
-Description=Configurable CloudVM Service
-
-[Service]
-Type=simple
-ExecStart=%s/bin/ccvm
-KillMode=process
-`
-
-const systemdSocket = `
-[Socket]
-ListenStream=%s/.ccloudvm/socket
-SocketMode=0600
-
-[Install]
-WantedBy=sockets.target
-`
-
 func getGoPath() (string, error) {
 	goPathBytes, err := exec.Command("go", "env", "GOPATH").Output()
 	if err != nil {
@@ -103,42 +85,52 @@ func Setup(ctx context.Context) error {
 	}
 
 	fmt.Println("Installing host dependencies")
-	osprepare.InstallDeps(ctx, ccloudvmDeps, logger{})
-
-	systemdRootPath := filepath.Join(home, ".local/share/systemd/user")
-	err = os.MkdirAll(systemdRootPath, 0700)
-	if err != nil {
-		return errors.Wrap(err, "Unable to create systemd directory")
-	}
-
-	servicePath := filepath.Join(systemdRootPath, "ccloudvm.service")
-	serviceData := fmt.Sprintf(systemdService, goPath)
-	err = ioutil.WriteFile(servicePath, []byte(serviceData), 0600)
-	if err != nil {
-		return errors.Wrap(err, "Unable to write service file")
-	}
+	installDeps(ctx)
 
-	socketPath := filepath.Join(systemdRootPath, "ccloudvm.socket")
-	socketData := fmt.Sprintf(systemdSocket, home)
-	err = ioutil.WriteFile(socketPath, []byte(socketData), 0600)
-	if err != nil {
-		return errors.Wrap(err, "Unable to write service file")
-	}
+	return installService(home, goPath)
+}
 
-	err = exec.Command("systemctl", "--user", "daemon-reload").Run()
-	if err != nil {
-		return errors.Wrap(err, "Unable to reload service files")
+// Drain asks the daemon to exit once the commands in progress have
+// completed, cancelling those still running after timeout, if timeout is not
+// 0.  Drain returns once the daemon has drained.  The daemon is restarted
+// when the next command is issued, e.g., after it has been upgraded.
+func Drain(ctx context.Context, timeout time.Duration) error {
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Drain", types.DrainArgs{Timeout: timeout}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result struct{}
+			return client.Call("ServerAPI.DrainResult", id, &result)
+		})
+	// The daemon may exit before its reply reaches us.
+	if err == rpc.ErrShutdown || err == io.ErrUnexpectedEOF {
+		err = nil
 	}
+	return err
+}
 
-	err = exec.Command("systemctl", "--user", "enable", "ccloudvm.socket").Run()
-	if err != nil {
-		return errors.Wrap(err, "Unable to enable ccloudvm.socket")
-	}
-	err = exec.Command("systemctl", "--user", "start", "ccloudvm.socket").Run()
+// SetLogLevel changes the minimum level of the messages logged by the daemon
+// to level and prints the resulting level.  The level is left unchanged if
+// level is empty.
+func SetLogLevel(ctx context.Context, level string) error {
+	var current string
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.SetLogLevel", types.SetLogLevelArgs{Level: level}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.SetLogLevelResult", id, &current)
+		})
 	if err != nil {
-		return errors.Wrap(err, "Unable to start ccloudvm.socket")
+		return err
 	}
 
+	fmt.Println(current)
 	return nil
 }
 
@@ -149,20 +141,11 @@ func Teardown(ctx context.Context) error {
 		return errors.New("HOME is not defined")
 	}
 
-	var instances []string
-	err := issueCommand(ctx,
-		func(client *rpc.Client) (int, error) {
-			var id int
-			err := client.Call("ServerAPI.GetInstances", struct{}{}, &id)
-			return id, err
-		},
-		func(client *rpc.Client, id int) error {
-			return client.Call("ServerAPI.GetInstancesResult", id, &instances)
-		})
+	instances, err := instanceSummaries(ctx, "")
 	if err == nil {
 		for _, i := range instances {
-			fmt.Printf("Deleting %s\n", i)
-			_ = Delete(ctx, i)
+			fmt.Printf("Deleting %s\n", i.Name)
+			_ = Delete(ctx, i.Name)
 		}
 	}
 
@@ -173,22 +156,11 @@ func Teardown(ctx context.Context) error {
 
 	fmt.Println("Removing ccloudvm service")
 
-	_ = exec.Command("systemctl", "--user", "disable", "ccloudvm.service").Run()
-	_ = exec.Command("systemctl", "--user", "disable", "ccloudvm.socket").Run()
-	_ = exec.Command("systemctl", "--user", "stop", "ccloudvm.service").Run()
-	_ = exec.Command("systemctl", "--user", "stop", "ccloudvm.socket").Run()
-
-	systemdRootPath := filepath.Join(home, ".local/share/systemd/user")
-
-	servicePath := filepath.Join(systemdRootPath, "ccloudvm.service")
-	_ = os.Remove(servicePath)
-
-	socketPath := filepath.Join(systemdRootPath, "ccloudvm.socket")
-	_ = os.Remove(socketPath)
+	removeService(home)
 	return nil
 }
 
-func dialHTTP(ctx context.Context, socketPath string) (client *rpc.Client, err error) {
+func dialHTTP(ctx context.Context, socketPath string) (*rpc.Client, error) {
 	timeoutCtx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 	d := &net.Dialer{}
@@ -196,13 +168,21 @@ func dialHTTP(ctx context.Context, socketPath string) (client *rpc.Client, err e
 	if err != nil {
 		return nil, err
 	}
+	return connectRPC(conn)
+}
+
+// connectRPC sets up an RPC client on a connection to the daemon.
+func connectRPC(conn net.Conn) (client *rpc.Client, err error) {
 	defer func() {
 		if client == nil {
 			_ = conn.Close()
 		}
 	}()
-	connectString := fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", rpc.DefaultRPCPath)
-	_, err = io.WriteString(conn, connectString)
+	connectString := fmt.Sprintf("CONNECT %s HTTP/1.0\n", rpc.DefaultRPCPath)
+	if token := os.Getenv("CCLOUDVM_TOKEN"); token != "" {
+		connectString += fmt.Sprintf("Authorization: Bearer %s\n", token)
+	}
+	_, err = io.WriteString(conn, connectString+"\n")
 	if err != nil {
 		return nil, err
 	}
@@ -214,6 +194,11 @@ func dialHTTP(ctx context.Context, socketPath string) (client *rpc.Client, err e
 		return nil, err
 	}
 
+	if r.StatusCode == http.StatusUnauthorized || r.StatusCode == http.StatusForbidden {
+		msg, _ := ioutil.ReadAll(io.LimitReader(r.Body, 4096))
+		return nil, errors.Errorf("Access denied: %s", strings.TrimSpace(string(msg)))
+	}
+
 	if r.Status != "200 Connected to Go RPC" {
 		return nil, errors.Errorf("Unexpected response (%s) from RPC server", r.Status)
 	}
@@ -221,24 +206,65 @@ func dialHTTP(ctx context.Context, socketPath string) (client *rpc.Client, err e
 	return rpc.NewClient(conn), nil
 }
 
-func issueCommand(ctx context.Context, call func(*rpc.Client) (int, error),
-	result func(*rpc.Client, int) error) error {
+// serviceSocket returns the path of the socket of the daemon serving the
+// user.  The user's own daemon is used if it has been set up.  Otherwise the
+// daemon serving all the users of the host is used, if there is one.  The
+// second return value is true if the socket is that of the system daemon.
+func serviceSocket(home string) (string, bool) {
+	socketPath := filepath.Join(types.DataDir(home), "socket")
+	if _, err := os.Stat(socketPath); err != nil {
+		if _, err := os.Stat(types.SystemSocket); err == nil {
+			return types.SystemSocket, true
+		}
+	}
+	return socketPath, false
+}
+
+// dialService connects to the daemon designated by CCLOUDVM_HOST or, if it
+// is not set, to the local daemon, restarting the user's daemon if needed.
+func dialService(ctx context.Context) (*rpc.Client, error) {
 	home := os.Getenv("HOME")
 	if home == "" {
-		return errors.New("HOME is not defined")
+		return nil, errors.New("HOME is not defined")
 	}
 
-	socketPath := filepath.Join(home, ".ccloudvm/socket")
-	client, err := dialHTTP(ctx, socketPath)
+	remote, err := getRemoteDaemon()
 	if err != nil {
-		err2 := exec.Command("systemctl", "--user", "restart", "ccloudvm.socket").Run()
-		if err2 != nil {
-			return errors.Wrap(err, "Unable to communicate with server. Try running 'ccloudvm setup'")
-		}
-		client, err = rpc.DialHTTP("unix", socketPath)
+		return nil, err
+	}
+	if remote != nil {
+		client, err := remote.dial(ctx, home)
 		if err != nil {
-			return errors.Wrap(err, "Unable to communicate with server")
+			return nil, errors.Wrapf(err, "Unable to communicate with server at %s", remote.address)
 		}
+		return client, nil
+	}
+
+	socketPath, system := serviceSocket(home)
+	client, err := dialHTTP(ctx, socketPath)
+	if err == nil {
+		return client, nil
+	}
+	if system {
+		return nil, errors.Wrap(err, "Unable to communicate with system server")
+	}
+
+	err2 := restartService()
+	if err2 != nil {
+		return nil, errors.Wrap(err, "Unable to communicate with server. Try running 'ccloudvm setup'")
+	}
+	client, err = dialHTTP(ctx, socketPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to communicate with server")
+	}
+	return client, nil
+}
+
+func issueCommand(ctx context.Context, call func(*rpc.Client) (int, error),
+	result func(*rpc.Client, int) error) error {
+	client, err := dialService(ctx)
+	if err != nil {
+		return err
 	}
 
 	defer func() {
@@ -287,8 +313,35 @@ func getProxy(upper, lower string) (string, error) {
 	return proxyURL.String(), nil
 }
 
-// Create sets up the VM
-func Create(ctx context.Context, instanceName, workloadName string, debug bool, update bool, customSpec *types.VMSpec) error {
+// sshAgentKeys returns the public keys held by the user's SSH agent.
+func sshAgentKeys() ([]string, error) {
+	if os.Getenv("SSH_AUTH_SOCK") == "" {
+		return nil, errors.New("No SSH agent is running, SSH_AUTH_SOCK is not set")
+	}
+
+	out, err := exec.Command("ssh-add", "-L").Output()
+	if err != nil {
+		return nil, errors.Errorf("Unable to retrieve the keys of the SSH agent: %s",
+			strings.TrimSpace(string(out)))
+	}
+
+	var keys []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			keys = append(keys, line)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("The SSH agent does not hold any keys")
+	}
+
+	return keys, nil
+}
+
+// setCreateEnv fills in the proxy settings and GoPath fields of args from
+// the user's environment, along with the keys of the user's SSH agent if
+// args.SSHAgent is set.
+func setCreateEnv(args *types.CreateArgs) error {
 	HTTPProxy, err := getProxy("HTTP_PROXY", "http_proxy")
 	if err != nil {
 		return err
@@ -315,22 +368,55 @@ func Create(ctx context.Context, instanceName, workloadName string, debug bool,
 		return err
 	}
 
-	return issueCommand(ctx,
+	args.HTTPProxy = HTTPProxy
+	args.HTTPSProxy = HTTPSProxy
+	args.NoProxy = noProxy
+	args.GoPath = goPath
+
+	if args.SSHAgent {
+		args.AgentKeys, err = sshAgentKeys()
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.Encrypt && args.Passphrase == "" {
+		args.Passphrase, err = readPassphrase("New disk passphrase: ", true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Create sets up the VM.  The proxy settings and GoPath fields of args are
+// filled in from the user's environment.
+func Create(ctx context.Context, args *types.CreateArgs, waitReady bool) error {
+	if err := setCreateEnv(args); err != nil {
+		return err
+	}
+
+	// Workloads may require their instances' disks to be encrypted.
+	var names []string
+	err := withPassphrase(ctx, &args.Passphrase, true, func() error {
+		var err error
+		names, err = create(ctx, args)
+		return err
+	})
+	if err != nil || !waitReady {
+		return err
+	}
+
+	return waitForReady(ctx, names)
+}
+
+func create(ctx context.Context, args *types.CreateArgs) ([]string, error) {
+	var names []string
+	err := issueCommand(ctx,
 		func(client *rpc.Client) (int, error) {
 			var id int
-			err := client.Call("ServerAPI.Create",
-				types.CreateArgs{
-					Name:         instanceName,
-					WorkloadName: workloadName,
-					Debug:        debug,
-					Update:       update,
-					CustomSpec:   *customSpec,
-					HTTPProxy:    HTTPProxy,
-					HTTPSProxy:   HTTPSProxy,
-					NoProxy:      noProxy,
-					GoPath:       goPath,
-				},
-				&id)
+			err := client.Call("ServerAPI.Create", *args, &id)
 			return id, err
 		},
 		func(client *rpc.Client, id int) error {
@@ -341,6 +427,22 @@ func Create(ctx context.Context, instanceName, workloadName string, debug bool,
 					return err
 				}
 				if result.Finished {
+					names = result.Names
+					if len(names) == 0 {
+						names = []string{result.Name}
+					}
+				}
+				if jsonOutput() {
+					if err := printJSON(&result); err != nil || result.Finished {
+						return err
+					}
+					continue
+				}
+				if result.Finished && len(result.Names) > 1 {
+					fmt.Printf("\nInstances %s created\n", strings.Join(result.Names, ", "))
+					fmt.Printf("Type 'ccloudvm connect <instance>' to start using them.\n")
+					return nil
+				} else if result.Finished {
 					fmt.Printf("\nInstance %s created\n", result.Name)
 					fmt.Printf("Type 'ccloudvm connect %s' to start using it.\n", result.Name)
 					return nil
@@ -348,37 +450,240 @@ func Create(ctx context.Context, instanceName, workloadName string, debug bool,
 				fmt.Print(result.Line)
 			}
 		})
+	return names, err
 }
 
-// Start launches the VM
-func Start(ctx context.Context, instanceName string, customSpec *types.VMSpec) error {
-	return issueCommand(ctx,
+// waitForReady waits for the readiness checks of the instances names to
+// complete and fails if any of the instances did not pass them.
+func waitForReady(ctx context.Context, names []string) error {
+	if !jsonOutput() {
+		fmt.Println("Waiting for provisioning to complete")
+	}
+
+	var failed []string
+	for _, name := range names {
+		for {
+			details, err := getInstanceDetails(ctx, name)
+			if err != nil {
+				return err
+			}
+			if details.Ready == types.ReadyOK {
+				break
+			}
+			if details.Ready == types.ReadyFailed {
+				failed = append(failed, name)
+				fmt.Fprintf(os.Stderr, "%s: %s\n", name, details.ReadyError)
+				break
+			}
+			if details.Ready == "" {
+				return errors.Errorf("Instance %s is not running", name)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(2 * time.Second):
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.Errorf("Instance(s) %s not ready", strings.Join(failed, ", "))
+	}
+	if !jsonOutput() {
+		fmt.Println("Provisioning complete")
+	}
+	return nil
+}
+
+// waitForCommand calls method, the Result method of a command that modifies
+// an instance, until the command has completed.  The user is told how many
+// commands are ahead of it while it is queued.
+func waitForCommand(client *rpc.Client, method string, id int) error {
+	position := 0
+	for {
+		var result types.CommandResult
+		if err := client.Call(method, id, &result); err != nil {
+			return err
+		}
+		if result.Finished {
+			return nil
+		}
+		if result.Position != position {
+			position = result.Position
+			fmt.Fprintf(os.Stderr, "Waiting for %d earlier command(s) on the instance to complete\n", position)
+		}
+	}
+}
+
+// Start launches the VM.  VMs whose disks are known to be corrupt are only
+// started if force is true.
+func Start(ctx context.Context, instanceName string, customSpec *types.VMSpec, force bool) error {
+	args := types.StartArgs{
+		Name:   instanceName,
+		VMSpec: *customSpec,
+		Force:  force,
+	}
+	return withPassphrase(ctx, &args.Passphrase, false, func() error {
+		return issueCommand(ctx,
+			func(client *rpc.Client) (int, error) {
+				var id int
+				err := client.Call("ServerAPI.Start", args, &id)
+				return id, err
+			},
+			func(client *rpc.Client, id int) error {
+				return waitForCommand(client, "ServerAPI.StartResult", id)
+			})
+	})
+}
+
+// Restart shuts down the VM and boots it again
+func Restart(ctx context.Context, args *types.RestartArgs) error {
+	return withPassphrase(ctx, &args.Passphrase, false, func() error {
+		return issueCommand(ctx,
+			func(client *rpc.Client) (int, error) {
+				var id int
+				err := client.Call("ServerAPI.Restart", args, &id)
+				return id, err
+			},
+			func(client *rpc.Client, id int) error {
+				return waitForCommand(client, "ServerAPI.RestartResult", id)
+			})
+	})
+}
+
+// Stop requests the VM shuts down cleanly, waiting for args.Timeout for it
+// to do so, and quits it if args.Force is true and it has not.
+func Stop(ctx context.Context, args *types.StopArgs) error {
+	var result types.StopResult
+	err := issueCommand(ctx,
 		func(client *rpc.Client) (int, error) {
 			var id int
-			err := client.Call("ServerAPI.Start", types.StartArgs{
-				Name:   instanceName,
-				VMSpec: *customSpec,
-			}, &id)
+			err := client.Call("ServerAPI.Stop", *args, &id)
 			return id, err
 		},
 		func(client *rpc.Client, id int) error {
-			var result struct{}
-			return client.Call("ServerAPI.StartResult", id, &result)
+			position := 0
+			for {
+				result = types.StopResult{}
+				if err := client.Call("ServerAPI.StopResult", id, &result); err != nil {
+					return err
+				}
+				if result.Finished {
+					return nil
+				}
+				if result.Position != position {
+					position = result.Position
+					fmt.Fprintf(os.Stderr, "Waiting for %d earlier command(s) on the instance to complete\n", position)
+				}
+			}
 		})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(&result)
+	}
+
+	if result.Method == types.StopQuit {
+		fmt.Println("The VM did not shut down in time and was quit")
+	}
+
+	return nil
 }
 
-// Stop requests the VM shuts down cleanly
-func Stop(ctx context.Context, instanceName string) error {
-	return issueCommand(ctx,
+func batch(ctx context.Context, args *types.BatchArgs) (*types.BatchResult, error) {
+	var result types.BatchResult
+	err := issueCommand(ctx,
 		func(client *rpc.Client) (int, error) {
 			var id int
-			err := client.Call("ServerAPI.Stop", instanceName, &id)
+			err := client.Call("ServerAPI.Batch", *args, &id)
 			return id, err
 		},
 		func(client *rpc.Client, id int) error {
-			var result struct{}
-			return client.Call("ServerAPI.StopResult", id, &result)
+			return client.Call("ServerAPI.BatchResult", id, &result)
 		})
+	return &result, err
+}
+
+// Batch applies args.Action to several instances in parallel and prints the
+// outcome for each of them.
+func Batch(ctx context.Context, args *types.BatchArgs) error {
+	result, err := batch(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	if args.Action == types.BatchStart && args.Passphrase == "" {
+		if err := retryLocked(ctx, args, result); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, r := range result.Instances {
+		if r.Error != "" {
+			failed++
+		}
+	}
+
+	if jsonOutput() {
+		if err := printJSON(result); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range result.Instances {
+			if r.Error != "" {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", r.Name, r.Error)
+			} else {
+				fmt.Printf("%s: OK\n", r.Name)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return errors.Errorf("Unable to %s %d of %d instances", args.Action, failed, len(result.Instances))
+	}
+	return nil
+}
+
+// retryLocked starts again, with the passphrase of their disks, the
+// instances that could not be started by a batch without it, and updates
+// result with the outcome.
+func retryLocked(ctx context.Context, args *types.BatchArgs, result *types.BatchResult) error {
+	var locked []string
+	for _, r := range result.Instances {
+		if strings.Contains(r.Error, types.ErrPassphraseRequired) {
+			locked = append(locked, r.Name)
+		}
+	}
+	if len(locked) == 0 {
+		return nil
+	}
+
+	retryArgs := *args
+	retryArgs.All = false
+	retryArgs.Names = locked
+	var err error
+	retryArgs.Passphrase, err = readPassphrase("Disk passphrase: ", false)
+	if err != nil {
+		return err
+	}
+	retried, err := batch(ctx, &retryArgs)
+	if err != nil {
+		return err
+	}
+
+	outcomes := make(map[string]string, len(retried.Instances))
+	for _, r := range retried.Instances {
+		outcomes[r.Name] = r.Error
+	}
+	for i := range result.Instances {
+		if e, ok := outcomes[result.Instances[i].Name]; ok {
+			result.Instances[i].Error = e
+		}
+	}
+	return nil
 }
 
 // Quit forceably kills VM
@@ -390,242 +695,2381 @@ func Quit(ctx context.Context, instanceName string) error {
 			return id, err
 		},
 		func(client *rpc.Client, id int) error {
-			var result struct{}
-			return client.Call("ServerAPI.QuitResult", id, &result)
+			return waitForCommand(client, "ServerAPI.QuitResult", id)
 		})
 }
 
-func sshReady(ctx context.Context, hostIP net.IP, sshPort int) bool {
-	dialer := net.Dialer{}
-	conn, err := dialer.DialContext(ctx, "tcp",
-		fmt.Sprintf("%s:%d", hostIP, sshPort))
+// Resize changes the resources assigned to an instance.  Changes that
+// cannot be applied to a running instance take effect when it is next
+// started.
+func Resize(ctx context.Context, args *types.ResizeArgs) error {
+	var result types.ResizeResult
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Resize", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.ResizeResult", id, &result)
+		})
 	if err != nil {
-		return false
+		return err
 	}
-	_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
-	scanner := bufio.NewScanner(conn)
-	retval := scanner.Scan()
-	_ = conn.Close()
-	return retval
-}
 
-func sshConnectionString(details *types.InstanceDetails) string {
-	return fmt.Sprintf("ssh -q -F /dev/null -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no -o IdentitiesOnly=yes -i %s %s -p %d",
-		details.SSH.KeyPath, details.VMSpec.HostIP, details.SSH.Port)
-}
+	if jsonOutput() {
+		return printJSON(&result)
+	}
 
-func statusVM(ctx context.Context, details *types.InstanceDetails) {
-	status := "VM down"
-	ssh := "N/A"
-	if sshReady(ctx, details.VMSpec.HostIP, details.SSH.Port) {
-		status = "VM up"
-		ssh = sshConnectionString(details)
+	if result.RestartRequired {
+		fmt.Println("Instance resized.  Restart the instance for all the changes to take effect.")
+	} else {
+		fmt.Println("Instance resized")
 	}
 
-	w := new(tabwriter.Writer)
-	w.Init(os.Stdout, 0, 8, 0, '\t', 0)
-	fmt.Fprintf(w, "Name\t:\t%s\n", details.Name)
-	fmt.Fprintf(w, "HostIP\t:\t%s\n", details.VMSpec.HostIP)
-	fmt.Fprintf(w, "Workload\t:\t%s\n", details.Workload)
-	fmt.Fprintf(w, "Status\t:\t%s\n", status)
-	fmt.Fprintf(w, "SSH\t:\t%s\n", ssh)
-	fmt.Fprintf(w, "VCPUs\t:\t%d\n", details.VMSpec.CPUs)
-	fmt.Fprintf(w, "Mem\t:\t%d MiB\n", details.VMSpec.MemMiB)
-	fmt.Fprintf(w, "Disk\t:\t%d GiB\n", details.VMSpec.DiskGiB)
-	if details.VMSpec.Qemuport != 0 {
-		fmt.Fprintf(w, "QEMU Debug Port\t:\t%d\n", details.VMSpec.Qemuport)
+	if args.DiskGiB != 0 {
+		fmt.Println("The root filesystem will be grown by cloud-init when the instance is next booted.")
 	}
-	_ = w.Flush()
+
+	return nil
 }
 
-func waitForSSH(ctx context.Context, in *types.InstanceDetails, silent bool) error {
-	if !sshReady(ctx, in.VMSpec.HostIP, in.SSH.Port) {
+// Forward adds, if add is true, or removes a port mapping from an instance.
+// Running instances are updated immediately.
+func Forward(ctx context.Context, args *types.ForwardArgs, add bool) error {
+	method := "ServerAPI.RemoveForward"
+	if add {
+		method = "ServerAPI.AddForward"
+	}
+
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call(method, *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return waitForCommand(client, method+"Result", id)
+		})
+}
+
+// Flatten copies the base image of a stopped instance into its disk, so
+// that the disk no longer depends on the image.
+func Flatten(ctx context.Context, instanceName string) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Flatten", instanceName, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return waitForCommand(client, "ServerAPI.FlattenResult", id)
+		})
+}
+
+// Rename renames the stopped instance args.Name to args.NewName.
+func Rename(ctx context.Context, args *types.RenameArgs) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Rename", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return waitForCommand(client, "ServerAPI.RenameResult", id)
+		})
+}
+
+// Backup backs up the disk of an instance.
+func Backup(ctx context.Context, args *types.BackupArgs) error {
+	var info types.BackupInfo
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Backup", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.BackupResult", id, &info)
+		})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(info)
+	}
+
+	fmt.Printf("Backup %s of %s created in %s\n", info.ID, info.Instance, info.Path)
+	return nil
+}
+
+// ListBackups lists the backups of an instance and its backup policy.
+func ListBackups(ctx context.Context, args *types.BackupListArgs) error {
+	var list types.BackupList
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.ListBackups", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.ListBackupsResult", id, &list)
+		})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(list)
+	}
+
+	if p := list.Policy; p != nil {
+		fmt.Printf("Backed up %s, keeping %d backups\n", p.Interval, p.Keep)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "ID\tCreated\tSize\tScheduled\t")
+	for i := range list.Backups {
+		b := &list.Backups[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\t\n", b.ID, b.Created.Local().Format(time.RFC1123),
+			formatBytes(b.SizeBytes), b.Scheduled)
+	}
+	_ = w.Flush()
+
+	return nil
+}
+
+// RestoreBackup replaces the disk of a stopped instance with one of its
+// backups.
+func RestoreBackup(ctx context.Context, args *types.RestoreArgs) error {
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.RestoreBackup", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result struct{}
+			return client.Call("ServerAPI.RestoreBackupResult", id, &result)
+		})
+	if err != nil {
+		return err
+	}
+
+	if !jsonOutput() {
+		fmt.Printf("Backup %s restored\n", args.ID)
+	}
+
+	return nil
+}
+
+// SetBackupPolicy sets, or removes, the policy scheduling the backups of an
+// instance.
+func SetBackupPolicy(ctx context.Context, policy *types.BackupPolicy) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.SetBackupPolicy", *policy, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result struct{}
+			return client.Call("ServerAPI.SetBackupPolicyResult", id, &result)
+		})
+}
+
+// Suspend saves the state of the VM of an instance, or of all the running
+// instances, to disk and quits the VM.
+func Suspend(ctx context.Context, args *types.SuspendArgs) error {
+	var suspended []string
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Suspend", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.SuspendResult", id, &suspended)
+		})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(suspended)
+	}
+
+	for _, name := range suspended {
+		fmt.Printf("Suspended %s\n", name)
+	}
+
+	return nil
+}
+
+// ResumeFromDisk boots the VM of a suspended instance, or of all the
+// suspended instances, from its saved state, or discards the saved state.
+func ResumeFromDisk(ctx context.Context, args *types.ResumeArgs) error {
+	var resumed []string
+	err := withPassphrase(ctx, &args.Passphrase, false, func() error {
+		return issueCommand(ctx,
+			func(client *rpc.Client) (int, error) {
+				var id int
+				err := client.Call("ServerAPI.ResumeFromDisk", *args, &id)
+				return id, err
+			},
+			func(client *rpc.Client, id int) error {
+				return client.Call("ServerAPI.ResumeFromDiskResult", id, &resumed)
+			})
+	})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(resumed)
+	}
+
+	verb := "Resumed"
+	if args.Discard {
+		verb = "Discarded the saved state of"
+	}
+	for _, name := range resumed {
+		fmt.Printf("%s %s\n", verb, name)
+	}
+
+	return nil
+}
+
+// Set changes the settings of an instance.
+func Set(ctx context.Context, args *types.SetArgs) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Set", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result struct{}
+			return client.Call("ServerAPI.SetResult", id, &result)
+		})
+}
+
+// SyncStatus prints the state of the directories synced into an instance.
+func SyncStatus(ctx context.Context, instanceName string) error {
+	var result types.SyncStatusResult
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.SyncStatus", instanceName, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.SyncStatusResult", id, &result)
+		})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(&result)
+	}
+
+	if len(result.Syncs) == 0 {
+		fmt.Println("No syncs")
+		return nil
+	}
+
+	switch {
+	case result.Paused:
+		fmt.Println("Syncs paused")
+	case !result.Active:
+		fmt.Println("Syncs inactive: the VM is not running")
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Source\tTarget\tLast Sync\tStatus\t")
+	for _, s := range result.Syncs {
+		lastSync := "never"
+		if !s.LastSync.IsZero() {
+			lastSync = s.LastSync.Format(time.RFC3339)
+		}
+		status := "up to date"
+		if s.Error != "" {
+			status = s.Error
+		} else if s.Pending {
+			status = "pending"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", s.Source, s.Target, lastSync, status)
+	}
+	return w.Flush()
+}
+
+// PauseSync pauses the syncing of directories into an instance.
+func PauseSync(ctx context.Context, instanceName string) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.PauseSync", instanceName, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result struct{}
+			return client.Call("ServerAPI.PauseSyncResult", id, &result)
+		})
+}
+
+// ResumeSync resumes the syncing of directories into an instance.
+func ResumeSync(ctx context.Context, instanceName string) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.ResumeSync", instanceName, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result struct{}
+			return client.Call("ServerAPI.ResumeSyncResult", id, &result)
+		})
+}
+
+// Fsck checks, and optionally repairs, the disk of a stopped instance.
+// An error is returned if corruptions remain after the check.
+func Fsck(ctx context.Context, args *types.FsckArgs) error {
+	var result types.FsckResult
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Fsck", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.FsckResult", id, &result)
+		})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		if err := printJSON(&result); err != nil {
+			return err
+		}
+	} else {
+		w := new(tabwriter.Writer)
+		w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+		fmt.Fprintln(w, "Image\tErrors\tCorruptions\tLeaks\tFixed\t")
+		for _, c := range result.Images {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t\n", c.Path, c.CheckErrors, c.Corruptions,
+				c.Leaks, c.CorruptionsFixed+c.LeaksFixed)
+		}
+		_ = w.Flush()
+	}
+
+	if result.Corrupt {
+		if args.Repair {
+			return errors.New("Disk is corrupt and could not be fully repaired")
+		}
+		return errors.New("Disk is corrupt.  Run fsck with --repair to repair it")
+	}
+
+	if !jsonOutput() {
+		fmt.Println("No corruptions found")
+	}
+	return nil
+}
+
+func sshReady(ctx context.Context, hostIP net.IP, sshPort int) bool {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp",
+		fmt.Sprintf("%s:%d", hostIP, sshPort))
+	if err != nil {
+		return false
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+	scanner := bufio.NewScanner(conn)
+	retval := scanner.Scan()
+	_ = conn.Close()
+	return retval
+}
+
+// guestReachable returns true if the guest of an instance accepts ssh
+// connections.  The guests of instances run by a remote daemon are probed
+// by the daemon.
+func guestReachable(ctx context.Context, details *types.InstanceDetails) bool {
+	if sshJumpHost() == "" {
+		return sshReady(ctx, details.SSH.Host, details.SSH.Port)
+	}
+
+	result, err := refreshStatus(ctx, []string{details.Name}, 0)
+	if err != nil || len(result.Instances) != 1 {
+		return false
+	}
+	return result.Instances[0].Status.SSHReachable
+}
+
+func sshConnectionString(details *types.InstanceDetails) string {
+	var options string
+	if details.SSH.CertPath != "" {
+		options = fmt.Sprintf(" -o CertificateFile=%s", details.SSH.CertPath)
+	}
+	if details.SSH.ForwardAgent {
+		options += " -A"
+	}
+	if jump := sshJumpHost(); jump != "" {
+		options += " -o ProxyJump=" + jump
+	}
+	return fmt.Sprintf("ssh -q -F /dev/null -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no -o IdentitiesOnly=yes -i %s%s %s -p %d",
+		details.SSH.KeyPath, options, details.SSH.Host, details.SSH.Port)
+}
+
+// sshOptions returns the options common to the ssh and scp commands used to
+// access an instance.
+func sshOptions(details *types.InstanceDetails) []string {
+	args := []string{
+		"-q", "-F", "/dev/null",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "IdentitiesOnly=yes",
+		"-i", details.SSH.KeyPath,
+	}
+
+	if details.SSH.CertPath != "" {
+		args = append(args, "-o", "CertificateFile="+details.SSH.CertPath)
+	}
+	if jump := sshJumpHost(); jump != "" {
+		args = append(args, "-o", "ProxyJump="+jump)
+	}
+
+	return args
+}
+
+// sshCommand returns a command that executes command in the guest over ssh.
+func sshCommand(ctx context.Context, details *types.InstanceDetails, command string) *exec.Cmd {
+	args := sshOptions(details)
+	args = append(args, details.SSH.Host.String(), "-p", strconv.Itoa(details.SSH.Port),
+		command)
+	return exec.CommandContext(ctx, "ssh", args...)
+}
+
+// instanceStatus returns a description of the cached status of an instance
+// and of the time at which it was last checked.
+func instanceStatus(details *types.InstanceDetails) (string, string) {
+	if details.Status.Checked.IsZero() {
+		return "Unknown", "Never"
+	}
+
+	status := "VM down"
+	if details.Suspended {
+		status = "VM suspended"
+	} else if details.Crashed {
+		status = "VM crashed"
+	} else if details.Status.SSHReachable && details.Degraded {
+		status = "VM degraded"
+	} else if details.Status.SSHReachable && details.Ready == types.ReadyPending {
+		status = "VM provisioning"
+	} else if details.Status.SSHReachable {
+		status = "VM up"
+	} else if details.Status.Running {
+		status = "VM booting"
+	}
+
+	return status, details.Status.Checked.Local().Format(time.RFC1123)
+}
+
+// formatUsage returns the CPU time and the energy in usage in a human
+// readable form.
+func formatUsage(usage *types.ResourceUsage) (string, string) {
+	cpuTime := time.Duration(usage.CPUSeconds * float64(time.Second)).Round(time.Second)
+	energy := "N/A"
+	if usage.EnergyJoules > 0 {
+		energy = fmt.Sprintf("%.1f Wh", usage.EnergyJoules/3600)
+	}
+	return cpuTime.String(), energy
+}
+
+func statusVM(ctx context.Context, details *types.InstanceDetails) {
+	status, checked := instanceStatus(details)
+	ssh := "N/A"
+	if details.Status.SSHReachable {
+		ssh = sshConnectionString(details)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 0, '\t', 0)
+	fmt.Fprintf(w, "Name\t:\t%s\n", details.Name)
+	fmt.Fprintf(w, "HostIP\t:\t%s\n", details.VMSpec.HostIP)
+	fmt.Fprintf(w, "Workload\t:\t%s\n", details.Workload)
+	if details.Group != "" {
+		fmt.Fprintf(w, "Group\t:\t%s (%s)\n", details.Group, details.Role)
+	}
+	if len(details.Labels) > 0 {
+		fmt.Fprintf(w, "Labels\t:\t%s\n", types.FormatLabels(details.Labels))
+	}
+	fmt.Fprintf(w, "Status\t:\t%s\n", status)
+	fmt.Fprintf(w, "Last Checked\t:\t%s\n", checked)
+	fmt.Fprintf(w, "SSH\t:\t%s\n", ssh)
+	if !details.Notification.Time.IsZero() {
+		fmt.Fprintf(w, "Notification\t:\t%s (%s)\n", details.Notification.Message,
+			details.Notification.Time.Local().Format(time.RFC1123))
+	}
+	if !details.LastCrash.Time.IsZero() {
+		fmt.Fprintf(w, "Last Crash\t:\t%s (%s)\n", details.LastCrash.Reason,
+			details.LastCrash.Time.Local().Format(time.RFC1123))
+	}
+	if details.Ready == types.ReadyFailed {
+		fmt.Fprintf(w, "Ready\t:\tNo (%s)\n", details.ReadyError)
+	} else if details.Ready == types.ReadyOK {
+		fmt.Fprintf(w, "Ready\t:\tYes\n")
+	} else if details.Ready == types.ReadyPending {
+		fmt.Fprintf(w, "Ready\t:\tPending\n")
+	}
+	if details.Degraded {
+		fmt.Fprintf(w, "Memory Pressure\t:\t%s (since %s)\n", details.Pressure.Reason,
+			details.Pressure.Since.Local().Format(time.RFC1123))
+	}
+	if details.VMSpec.ClockOffset != "" {
+		fmt.Fprintf(w, "Clock Offset\t:\t%s\n", details.VMSpec.ClockOffset)
+	} else if details.VMSpec.FrozenTime != "" {
+		fmt.Fprintf(w, "Frozen Time\t:\t%s\n", details.VMSpec.FrozenTime)
+	}
+	if details.VMSpec.Network != "" {
+		fmt.Fprintf(w, "Network\t:\t%s\n", details.VMSpec.Network)
+	}
+	if details.VMSpec.Hostname != "" {
+		fmt.Fprintf(w, "Hostname\t:\t%s\n", details.VMSpec.Hostname)
+	}
+	if details.VMSpec.MACAddress != "" {
+		fmt.Fprintf(w, "MAC Address\t:\t%s\n", details.VMSpec.MACAddress)
+	}
+	if len(details.GuestIPv6) > 0 {
+		fmt.Fprintf(w, "Guest IPv6\t:\t%s\n", strings.Join(details.GuestIPv6, ", "))
+	}
+	if details.VMSpec.RestartPolicy != "" {
+		fmt.Fprintf(w, "Restart Policy\t:\t%s\n", details.VMSpec.RestartPolicy)
+	}
+	if details.VMSpec.Autostart {
+		autostart := fmt.Sprintf("order %d", details.VMSpec.AutostartOrder)
+		if details.VMSpec.AutostartDelay != "" {
+			autostart += fmt.Sprintf(", delay %s", details.VMSpec.AutostartDelay)
+		}
+		fmt.Fprintf(w, "Autostart\t:\t%s\n", autostart)
+	}
+	cpuTime, energy := formatUsage(&details.Usage)
+	fmt.Fprintf(w, "CPU Time\t:\t%s\n", cpuTime)
+	fmt.Fprintf(w, "Energy\t:\t%s\n", energy)
+	if details.LiveUsage.RSSBytes > 0 {
+		fmt.Fprintf(w, "CPU Usage\t:\t%.1f%%\n", details.LiveUsage.CPUPercent)
+		fmt.Fprintf(w, "RSS\t:\t%s\n", formatBytes(details.LiveUsage.RSSBytes))
+	}
+	fmt.Fprintf(w, "VCPUs\t:\t%d\n", details.VMSpec.CPUs)
+	if details.VMSpec.CPUModel != "" {
+		fmt.Fprintf(w, "CPU Model\t:\t%s\n", details.VMSpec.CPUModel)
+	}
+	if details.VMSpec.HasTopology() {
+		sockets, cores, threads := details.VMSpec.TopologySize()
+		fmt.Fprintf(w, "CPU Topology\t:\t%d sockets, %d cores, %d threads\n", sockets, cores, threads)
+	}
+	if details.VMSpec.NestedVirt {
+		fmt.Fprintf(w, "Nested Virt\t:\tenabled\n")
+	}
+	fmt.Fprintf(w, "Mem\t:\t%d MiB\n", details.VMSpec.MemMiB)
+	switch details.VMSpec.MemoryBackend {
+	case "":
+	case types.MemoryBackendHugepages:
+		size := details.VMSpec.HugepageSize
+		if size == "" {
+			size = "2M"
+		}
+		fmt.Fprintf(w, "Mem Backend\t:\t%s (%s)\n", details.VMSpec.MemoryBackend, size)
+	default:
+		fmt.Fprintf(w, "Mem Backend\t:\t%s\n", details.VMSpec.MemoryBackend)
+	}
+	fmt.Fprintf(w, "Disk\t:\t%d GiB (%s used)\n", details.VMSpec.DiskGiB,
+		formatBytes(details.LiveUsage.DiskBytes))
+	if details.VMSpec.Qemuport != 0 {
+		fmt.Fprintf(w, "QEMU Debug Port\t:\t%d\n", details.VMSpec.Qemuport)
+	}
+	for _, m := range details.VMSpec.Mounts {
+		fmt.Fprintf(w, "Mount\t:\t%s %s\n", m.Tag, m.Path)
+	}
+	for _, d := range details.VMSpec.Disks {
+		if d.Volume != "" {
+			fmt.Fprintf(w, "Data Disk\t:\t%s %d GiB (volume %s)\n", d.Name, d.SizeGiB, d.Volume)
+		} else {
+			fmt.Fprintf(w, "Data Disk\t:\t%s %d GiB\n", d.Name, d.SizeGiB)
+		}
+	}
+	for _, g := range details.VMSpec.VGPUs {
+		fmt.Fprintf(w, "vGPU\t:\t%s on %s\n", g.Type, g.Parent)
+	}
+	for _, g := range details.VMSpec.GPUs {
+		fmt.Fprintf(w, "GPU\t:\t%s\n", g.Address)
+	}
+	if details.Arch != "" {
+		arch := details.Arch
+		if details.Emulated {
+			arch += " (emulated)"
+		}
+		fmt.Fprintf(w, "Architecture\t:\t%s\n", arch)
+	}
+	fmt.Fprintf(w, "Firmware\t:\t%s\n", details.Firmware)
+	if details.DisplayPort != 0 {
+		fmt.Fprintf(w, "Display\t:\t%s://%s\n", details.VMSpec.Graphics,
+			net.JoinHostPort(details.VMSpec.HostIP.String(), strconv.Itoa(details.DisplayPort)))
+	}
+	if details.VMSpec.Audio != "" && details.VMSpec.Audio != types.AudioNone {
+		fmt.Fprintf(w, "Audio\t:\tIntel HD Audio (%s)\n", details.VMSpec.Audio)
+	}
+	for _, u := range details.VMSpec.USBDevices {
+		fmt.Fprintf(w, "USB Device\t:\t%s\n", u.ID)
+	}
+	if details.VMSpec.TPM {
+		fmt.Fprintf(w, "TPM\t:\t2.0 (swtpm)\n")
+	}
+	if details.VsockCID != 0 {
+		fmt.Fprintf(w, "Vsock CID\t:\t%d\n", details.VsockCID)
+	}
+	if details.DockerSocket != "" {
+		fmt.Fprintf(w, "Docker Socket\t:\t%s\n", details.DockerSocket)
+	}
+	_ = w.Flush()
+
+	if details.Crashed && details.LastCrash.Output != "" {
+		fmt.Printf("\nLast console output:\n%s\n", details.LastCrash.Output)
+	}
+}
+
+func waitForSSH(ctx context.Context, in *types.InstanceDetails, silent bool) error {
+	if !guestReachable(ctx, in) {
 		if !silent {
 			fmt.Printf("Waiting for VM to boot ")
 		}
-	DONE:
-		for {
+	DONE:
+		for {
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return fmt.Errorf("Cancelled")
+			}
+
+			if guestReachable(ctx, in) {
+				break DONE
+			}
+
+			if !silent {
+				fmt.Print(".")
+			}
+		}
+		if !silent {
+			fmt.Println()
+		}
+	}
+
+	return nil
+}
+
+func getInstanceDetails(ctx context.Context, instanceName string) (types.InstanceDetails, error) {
+	var details types.InstanceDetails
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.GetInstanceDetails", instanceName, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			err := client.Call("ServerAPI.GetInstanceDetailsResult", id, &details)
+			if err != nil {
+				return err
+			}
+			return nil
+		})
+	if err != nil {
+		return details, err
+	}
+
+	remote, err := getRemoteDaemon()
+	if err == nil && remote != nil {
+		err = useRemoteSSHKey(ctx, remote, &details)
+	}
+	return details, err
+}
+
+// sshInstanceDetails returns the details of the instance called
+// instanceName to be used to connect to it over SSH.  The daemon signs a
+// new certificate for the instances accessed with the SSH CA when their
+// key is retrieved.
+func sshInstanceDetails(ctx context.Context, instanceName string) (types.InstanceDetails, error) {
+	details, err := getInstanceDetails(ctx, instanceName)
+	if err != nil || details.SSH.CertPath == "" || sshJumpHost() != "" {
+		return details, err
+	}
+
+	var key types.SSHKeyResult
+	err = issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.GetSSHKey", details.Name, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.GetSSHKeyResult", id, &key)
+		})
+	return details, err
+}
+
+func refreshStatus(ctx context.Context, names []string, maxStaleness time.Duration) (*types.RefreshStatusResult, error) {
+	var result types.RefreshStatusResult
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.RefreshStatus", types.RefreshStatusArgs{
+				Names:        names,
+				MaxStaleness: maxStaleness,
+			}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.RefreshStatusResult", id, &result)
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Status prints out VM information.  The status of the VM is the one cached
+// by ccloudvm unless refresh is true, in which case the VM is probed if its
+// cached status is older than maxStaleness.
+func Status(ctx context.Context, instanceName string, refresh bool, maxStaleness time.Duration) error {
+	result, err := getInstanceDetails(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+
+	if refresh {
+		res, err := refreshStatus(ctx, []string{result.Name}, maxStaleness)
+		if err != nil {
+			return err
+		}
+		if msg, ok := res.Errors[result.Name]; ok {
+			return errors.Errorf("Unable to refresh status of %s: %s", result.Name, msg)
+		}
+		if len(res.Instances) == 1 {
+			result = res.Instances[0]
+		}
+	}
+
+	if jsonOutput() {
+		return printJSON(&result)
+	}
+
+	statusVM(ctx, &result)
+	return nil
+}
+
+// RefreshStatus probes the status of the named instances, or of all
+// instances if no names are given, and prints the result.  Instances whose
+// cached status is no older than maxStaleness are not probed.
+func RefreshStatus(ctx context.Context, names []string, maxStaleness time.Duration) error {
+	res, err := refreshStatus(ctx, names, maxStaleness)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		if err := printJSON(res); err != nil {
+			return err
+		}
+	} else {
+		w := new(tabwriter.Writer)
+		w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+		fmt.Fprintln(w, "Name\tStatus\tLast Checked\t")
+		for i := range res.Instances {
+			status, checked := instanceStatus(&res.Instances[i])
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", res.Instances[i].Name, status, checked)
+		}
+		_ = w.Flush()
+
+		for name, msg := range res.Errors {
+			fmt.Fprintf(os.Stderr, "Unable to refresh status of %s: %s\n", name, msg)
+		}
+	}
+
+	if len(res.Errors) > 0 {
+		return errors.New("Failed to refresh the status of all instances")
+	}
+
+	return nil
+}
+
+// WatchEvents prints the lifecycle events of the named instances, or of all
+// instances if no names are given, as they occur.  It returns when ctx is
+// cancelled.
+func WatchEvents(ctx context.Context, names []string) error {
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.WatchEvents", types.WatchEventsArgs{
+				Names: names,
+			}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			for {
+				var event types.InstanceEvent
+				err := client.Call("ServerAPI.WatchEventsResult", id, &event)
+				if err != nil {
+					return err
+				}
+
+				if jsonOutput() {
+					if err := printJSON(&event); err != nil {
+						return err
+					}
+				} else {
+					fmt.Printf("%s\t%s\t%s\n", event.Time.Local().Format(time.RFC1123),
+						event.Name, event.Type)
+				}
+			}
+		})
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// parseSince returns the start of a period, such as 30d or 12h, that ends
+// now.
+func parseSince(since string) (time.Time, error) {
+	if strings.HasSuffix(since, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(since, "d"))
+		if err == nil && days >= 0 {
+			return time.Now().AddDate(0, 0, -days), nil
+		}
+	}
+
+	d, err := time.ParseDuration(since)
+	if err != nil || d < 0 {
+		return time.Time{}, errors.Errorf("Invalid period %s", since)
+	}
+
+	return time.Now().Add(-d), nil
+}
+
+// Report prints the resources consumed by each instance over the period
+// since, e.g., 30d.  Deleted instances are included.
+func Report(ctx context.Context, since string) error {
+	start, err := parseSince(since)
+	if err != nil {
+		return err
+	}
+
+	var result types.ReportResult
+	err = issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Report", types.ReportArgs{Since: start}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.ReportResult", id, &result)
+		})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(&result)
+	}
+
+	fmt.Printf("Usage since %s\n\n", start.Local().Format("Mon, 02 Jan 2006"))
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Name\tCPU Time\tEnergy\t")
+	for i := range result.Instances {
+		r := &result.Instances[i]
+		name := r.Name
+		if r.Deleted {
+			name += " (deleted)"
+		}
+		cpuTime, energy := formatUsage(&r.Usage)
+		fmt.Fprintf(w, "%s\t%s\t%s\t\n", name, cpuTime, energy)
+	}
+	cpuTime, energy := formatUsage(&result.Total)
+	fmt.Fprintf(w, "Total\t%s\t%s\t\n", cpuTime, energy)
+	_ = w.Flush()
+
+	return nil
+}
+
+// Doctor checks that the host of the daemon has everything needed to create
+// instances and prints the outcome of each check, along with the fixes of
+// any problems found.  It fails if any of the checks fails.
+func Doctor(ctx context.Context) error {
+	var args types.PreflightArgs
+	var err error
+	args.HTTPProxy, err = getProxy("HTTP_PROXY", "http_proxy")
+	if err != nil {
+		return err
+	}
+	args.HTTPSProxy, err = getProxy("HTTPS_PROXY", "https_proxy")
+	if err != nil {
+		return err
+	}
+
+	var result types.PreflightResult
+	err = issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Preflight", args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.PreflightResult", id, &result)
+		})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		if err := printJSON(&result); err != nil {
+			return err
+		}
+	} else {
+		w := new(tabwriter.Writer)
+		w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+		fmt.Fprintln(w, "Check\tStatus\tDetail\t")
+		for _, c := range result.Checks {
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", c.Name, c.Status, c.Detail)
+		}
+		_ = w.Flush()
+
+		for _, c := range result.Checks {
+			if c.Fix != "" {
+				fmt.Printf("\n%s: %s\n", c.Name, c.Fix)
+			}
+		}
+	}
+
+	failed := 0
+	for _, c := range result.Checks {
+		if c.Status == types.PreflightFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+// AuditLog prints the operations recorded in the daemon's audit log over the
+// period since, e.g., 30d.  Only the operations on instanceName are printed
+// if it is not empty.
+func AuditLog(ctx context.Context, instanceName, since string) error {
+	start, err := parseSince(since)
+	if err != nil {
+		return err
+	}
+
+	var records []types.AuditRecord
+	err = issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			args := types.AuditLogArgs{Since: start, Instance: instanceName}
+			err := client.Call("ServerAPI.GetAuditLog", args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.GetAuditLogResult", id, &records)
+		})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(records)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Time\tUser\tToken\tOperation\tInstance\tResult\t")
+	for _, r := range records {
+		user := r.User
+		if r.Remote != "" {
+			user += " from " + r.Remote
+		}
+		result := "allowed"
+		if r.Denied {
+			result = "denied"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			r.Time.Local().Format(time.Stamp), user, r.Token, r.Operation,
+			r.Instance, result)
+	}
+	_ = w.Flush()
+
+	return nil
+}
+
+// Run connects to the VM via SSH and runs the desired command
+func Run(ctx context.Context, instanceName, command string) error {
+	path, err := exec.LookPath("ssh")
+	if err != nil {
+		return fmt.Errorf("Unable to locate ssh binary")
+	}
+
+	result, err := sshInstanceDetails(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+
+	err = waitForSSH(ctx, &result, command != "")
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{path}, sshOptions(&result)...)
+	if result.SSH.ForwardAgent {
+		args = append(args, "-A")
+	}
+	args = append(args, result.SSH.Host.String(), "-p", strconv.Itoa(result.SSH.Port))
+
+	if command != "" {
+		args = append(args, command)
+	}
+
+	err = syscall.Exec(path, args, os.Environ())
+	return err
+}
+
+// Copy copies files between the host and the guest using scp.  If no
+// instance name is given and src or dest is of the form instance:path, the
+// files are copied with sftp instead.
+func Copy(ctx context.Context, instanceName string, recurse, host bool, src, dest string) error {
+	if instanceName == "" && !host {
+		_, _, srcRemote := splitInstancePath(src)
+		_, _, destRemote := splitInstancePath(dest)
+		if srcRemote || destRemote {
+			return copySFTP(ctx, src, dest)
+		}
+	}
+
+	path, err := exec.LookPath("scp")
+	if err != nil {
+		return fmt.Errorf("Unable to locate scp binary")
+	}
+
+	result, err := sshInstanceDetails(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{path}, sshOptions(&result)...)
+	args = append(args, "-P", strconv.Itoa(result.SSH.Port))
+
+	if recurse {
+		args = append(args, "-r")
+	}
+
+	if host {
+		args = append(args, fmt.Sprintf("%s:%s", result.SSH.Host.String(), src))
+		args = append(args, dest)
+	} else {
+		args = append(args, src)
+		args = append(args, fmt.Sprintf("%s:%s", result.SSH.Host.String(), dest))
+	}
+
+	return syscall.Exec(path, args, os.Environ())
+}
+
+// splitInstancePath splits an argument of the copy command of the form
+// instance:path into its components.  ok is false if arg is a path on the
+// host, i.e., if it does not contain a colon or if a slash precedes the
+// first colon.
+func splitInstancePath(arg string) (instance, path string, ok bool) {
+	i := strings.Index(arg, ":")
+	if i <= 0 || strings.Contains(arg[:i], "/") {
+		return "", arg, false
+	}
+
+	path = arg[i+1:]
+	if path == "" {
+		path = "."
+	}
+
+	return arg[:i], path, true
+}
+
+// sftpQuote quotes path so that it is passed verbatim to an sftp command
+// and is not expanded as a glob.
+func sftpQuote(path string) string {
+	var b bytes.Buffer
+	b.WriteByte('"')
+	for _, r := range path {
+		if strings.ContainsRune(`"\*?[]`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// sftpBatch returns the sftp batch file that runs command, put or get, to
+// copy src to dest recursively.  The progress meter is disabled in batch
+// mode and the progress command enables it again.
+func sftpBatch(command, src, dest string) string {
+	return fmt.Sprintf("progress\n%s -r %s %s\n", command, sftpQuote(src),
+		sftpQuote(dest))
+}
+
+// copySFTP copies files and directories between the host and an instance
+// using sftp.  Exactly one of src and dest must be of the form
+// instance:path.  Directories are copied recursively and the progress of
+// each transfer is reported when stdout is a terminal.
+func copySFTP(ctx context.Context, src, dest string) error {
+	path, err := exec.LookPath("sftp")
+	if err != nil {
+		return fmt.Errorf("Unable to locate sftp binary")
+	}
+
+	srcInstance, srcPath, srcRemote := splitInstancePath(src)
+	destInstance, destPath, destRemote := splitInstancePath(dest)
+	if srcRemote == destRemote {
+		return errors.New("Exactly one of the source and the destination must be of the form instance:path")
+	}
+
+	instanceName := destInstance
+	command := "put"
+	if srcRemote {
+		instanceName = srcInstance
+		command = "get"
+	}
+
+	result, err := sshInstanceDetails(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+
+	args := sshOptions(&result)
+	args = append(args, "-P", strconv.Itoa(result.SSH.Port), "-b", "-",
+		result.SSH.Host.String())
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdin = strings.NewReader(sftpBatch(command, srcPath, destPath))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "Unable to copy %s to %s", src, dest)
+	}
+
+	return nil
+}
+
+// Display launches a viewer that shows the graphical console of an
+// instance.  If printOnly is true, the URL of the console is printed
+// instead.
+func Display(ctx context.Context, instanceName string, printOnly bool) error {
+	details, err := getInstanceDetails(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+	if details.DisplayPort == 0 {
+		return errors.Errorf("%s has no graphical console.  Create it with --graphics vnc or spice",
+			details.Name)
+	}
+
+	host := details.VMSpec.HostIP.String()
+	url := fmt.Sprintf("%s://%s", details.VMSpec.Graphics,
+		net.JoinHostPort(host, strconv.Itoa(details.DisplayPort)))
+	if printOnly {
+		fmt.Println(url)
+		return nil
+	}
+
+	remote, err := getRemoteDaemon()
+	if err != nil {
+		return err
+	}
+	if remote != nil {
+		return errors.Errorf("The display of %s is only reachable from %s.  Forward it with ssh -L %d:%s %s",
+			details.Name, remote.host, details.DisplayPort,
+			net.JoinHostPort(host, strconv.Itoa(details.DisplayPort)), remote.jumpHost())
+	}
+
+	viewers := displayViewers(details.VMSpec.Graphics, host, details.DisplayPort)
+	for _, v := range viewers {
+		path, err := exec.LookPath(v[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.CommandContext(ctx, path, v[1:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil && ctx.Err() == nil {
+			return errors.Wrapf(err, "Unable to run %s", v[0])
+		}
+		return nil
+	}
+
+	return errors.Errorf("Unable to find a viewer for %s.  Install virt-viewer", url)
+}
+
+// Profile collects a perf profile in the guest for the specified duration
+// and copies the samples, or a flame graph of them, to output.
+func Profile(ctx context.Context, instanceName string, duration time.Duration,
+	output string, flamegraph bool) error {
+	result, err := sshInstanceDetails(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+
+	if !result.VMSpec.Profiling {
+		return errors.Errorf("%s was not created with profiling enabled", instanceName)
+	}
+
+	err = waitForSSH(ctx, &result, true)
+	if err != nil {
+		return err
+	}
+
+	seconds := int(duration / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	fmt.Printf("Profiling %s for %d seconds\n", instanceName, seconds)
+
+	samples, err := sshCommand(ctx, &result,
+		fmt.Sprintf("sudo perf record -q -F 99 -a -g -o /tmp/ccloudvm-perf.data -- sleep %d && "+
+			"sudo perf script -i /tmp/ccloudvm-perf.data; sudo rm -f /tmp/ccloudvm-perf.data",
+			seconds)).Output()
+	if err != nil {
+		return errors.Wrap(err, "Unable to collect profile")
+	}
+
+	if flamegraph {
+		samples, err = renderFlamegraph(ctx, samples)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ioutil.WriteFile(output, samples, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to write %s", output)
+	}
+
+	fmt.Printf("Profile written to %s\n", output)
+
+	return nil
+}
+
+func renderFlamegraph(ctx context.Context, samples []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "stackcollapse-perf.pl")
+	cmd.Stdin = bytes.NewReader(samples)
+	folded, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to execute stackcollapse-perf.pl")
+	}
+
+	cmd = exec.CommandContext(ctx, "flamegraph.pl")
+	cmd.Stdin = bytes.NewReader(folded)
+	svg, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to execute flamegraph.pl")
+	}
+
+	return svg, nil
+}
+
+// Mount shares the host directory m.Path with an instance.  If the instance
+// is running, the directory is also mounted in the guest, at the same path
+// as on the host, using virtio-fs.
+func Mount(ctx context.Context, instanceName string, m *types.Mount) error {
+	args := types.MountArgs{
+		Name:  instanceName,
+		Mount: *m,
+	}
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Mount", args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return waitForCommand(client, "ServerAPI.MountResult", id)
+		})
+	if err != nil {
+		return err
+	}
+
+	details, err := sshInstanceDetails(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+
+	if !guestReachable(ctx, &details) {
+		return nil
+	}
+
+	out, err := sshCommand(ctx, &details,
+		fmt.Sprintf("sudo mkdir -p '%[2]s' && sudo mount -t virtiofs %[1]s '%[2]s'",
+			m.Tag, m.Path)).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "Unable to mount %s in guest: %s", m.Tag, string(out))
+	}
+
+	return nil
+}
+
+// Unmount stops sharing the host directory identified by tag with an
+// instance, unmounting it from the guest first if the instance is running.
+func Unmount(ctx context.Context, instanceName, tag string) error {
+	details, err := sshInstanceDetails(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+
+	var m *types.Mount
+	for i := range details.VMSpec.Mounts {
+		if details.VMSpec.Mounts[i].Tag == tag {
+			m = &details.VMSpec.Mounts[i]
+			break
+		}
+	}
+	if m == nil {
+		return errors.Errorf("Mount %s not found", tag)
+	}
+
+	if guestReachable(ctx, &details) {
+		out, err := sshCommand(ctx, &details,
+			fmt.Sprintf("! mountpoint -q '%[1]s' || sudo umount '%[1]s'", m.Path)).CombinedOutput()
+		if err != nil {
+			return errors.Wrapf(err, "Unable to unmount %s in guest: %s", tag, string(out))
+		}
+	}
+
+	args := types.MountArgs{
+		Name:  instanceName,
+		Mount: types.Mount{Tag: tag},
+	}
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Unmount", args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return waitForCommand(client, "ServerAPI.UnmountResult", id)
+		})
+}
+
+// AttachDisk attaches the data disk d to an instance, creating its volume
+// if necessary.  The disk is hot-plugged if the instance is running.
+func AttachDisk(ctx context.Context, instanceName string, d *types.Disk) error {
+	args := types.DiskArgs{
+		Name: instanceName,
+		Disk: *d,
+	}
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.AttachDisk", args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return waitForCommand(client, "ServerAPI.AttachDiskResult", id)
+		})
+}
+
+// DetachDisk detaches the data disk called diskName from an instance.  The
+// disk's volume is kept, so that the disk can be attached again, unless
+// deleteVolume is true.
+func DetachDisk(ctx context.Context, instanceName, diskName string, deleteVolume bool) error {
+	args := types.DiskArgs{
+		Name:   instanceName,
+		Disk:   types.Disk{Name: diskName},
+		Delete: deleteVolume,
+	}
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.DetachDisk", args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return waitForCommand(client, "ServerAPI.DetachDiskResult", id)
+		})
+}
+
+// Connect opens a shell to the VM via
+func Connect(ctx context.Context, instanceName string) error {
+	return Run(ctx, instanceName, "")
+}
+
+// Delete the VM
+func Delete(ctx context.Context, instanceName string) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Delete", instanceName, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return waitForCommand(client, "ServerAPI.DeleteResult", id)
+		})
+}
+
+// instanceSummaries returns the summaries of the instances whose labels
+// match selector.
+func instanceSummaries(ctx context.Context, selector string) ([]types.InstanceSummary, error) {
+	var instances []types.InstanceSummary
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.GetInstances",
+				types.InstancesArgs{Selector: selector}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.GetInstancesResult", id, &instances)
+		})
+	return instances, err
+}
+
+func allInstanceDetails(ctx context.Context, selector string) ([]types.InstanceDetails, error) {
+	instances, err := instanceSummaries(ctx, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceDetails := make([]types.InstanceDetails, 0, len(instances))
+	for _, sum := range instances {
+		details, err := getInstanceDetails(ctx, sum.Name)
+		if err != nil {
+			continue
+		}
+		instanceDetails = append(instanceDetails, details)
+	}
+
+	return instanceDetails, nil
+}
+
+// Instances provides information about the current instances whose labels
+// match selector, all of them if selector is empty.
+func Instances(ctx context.Context, selector string) error {
+	instanceDetails, err := allInstanceDetails(ctx, selector)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(instanceDetails)
+	}
+
+	if len(instanceDetails) == 0 {
+		return nil
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Name\tHostIP\tWorkload\tVCPUs\tMem\tDisk\tStatus\tLabels\t")
+	for i := range instanceDetails {
+		id := &instanceDetails[i]
+		status, _ := instanceStatus(id)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d MiB\t%d Gib\t%s\t%s\n",
+			id.Name, id.VMSpec.HostIP, id.Workload,
+			id.VMSpec.CPUs, id.VMSpec.MemMiB, id.VMSpec.DiskGiB, status,
+			types.FormatLabels(id.Labels))
+	}
+	_ = w.Flush()
+
+	return nil
+
+}
+
+// formatBytes returns size in a human readable form, in binary units.
+func formatBytes(size int64) string {
+	const units = "KMGTPE"
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	i := -1
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, units[i])
+}
+
+func printTop(instanceDetails []types.InstanceDetails) {
+	sort.SliceStable(instanceDetails, func(i, j int) bool {
+		return instanceDetails[i].LiveUsage.CPUPercent > instanceDetails[j].LiveUsage.CPUPercent
+	})
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Name\tStatus\tVCPUs\tCPU%\tRSS\tMem\tDisk Used\tDisk\t")
+	for i := range instanceDetails {
+		id := &instanceDetails[i]
+		status, _ := instanceStatus(id)
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1f\t%s\t%d MiB\t%s\t%d GiB\t\n",
+			id.Name, status, id.VMSpec.CPUs, id.LiveUsage.CPUPercent,
+			formatBytes(id.LiveUsage.RSSBytes), id.VMSpec.MemMiB,
+			formatBytes(id.LiveUsage.DiskBytes), id.VMSpec.DiskGiB)
+	}
+	_ = w.Flush()
+}
+
+// Top displays the resources currently used by all of the instances,
+// busiest first, refreshing the display every interval until ctx is
+// cancelled or, if iterations is positive, iterations times.  The screen is
+// cleared before each refresh when stdout is a terminal.
+func Top(ctx context.Context, interval time.Duration, iterations int) error {
+	if interval <= 0 {
+		return errors.New("The refresh interval must be positive")
+	}
+
+	terminal := false
+	if fi, err := os.Stdout.Stat(); err == nil {
+		terminal = fi.Mode()&os.ModeCharDevice != 0
+	}
+
+	for i := 0; iterations <= 0 || i < iterations; i++ {
+		if i > 0 {
 			select {
-			case <-time.After(time.Second):
 			case <-ctx.Done():
-				return fmt.Errorf("Cancelled")
+				return nil
+			case <-time.After(interval):
+			}
+		}
+
+		instanceDetails, err := allInstanceDetails(ctx, "")
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if jsonOutput() {
+			if err := printJSON(instanceDetails); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if terminal {
+			fmt.Print("\033[H\033[2J")
+		} else if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s - %d instances\n\n", time.Now().Format("15:04:05"), len(instanceDetails))
+		printTop(instanceDetails)
+	}
+
+	return nil
+}
+
+// CreateGroup creates the instances of a group workload.  args.Name is the
+// name of the group.
+func CreateGroup(ctx context.Context, args *types.CreateArgs) error {
+	if err := setCreateEnv(args); err != nil {
+		return err
+	}
+
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.CreateGroup", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result types.CreateResult
+			for {
+				err := client.Call("ServerAPI.CreateGroupResult", id, &result)
+				if err != nil {
+					return err
+				}
+				if jsonOutput() {
+					if err := printJSON(&result); err != nil || result.Finished {
+						return err
+					}
+					continue
+				}
+				if result.Finished {
+					fmt.Printf("\nGroup %s created with instances %s\n", args.Name,
+						strings.Join(result.Names, ", "))
+					fmt.Printf("Type 'ccloudvm connect <instance>' to start using them.\n")
+					return nil
+				}
+				fmt.Print(result.Line)
+			}
+		})
+}
+
+func groupCommand(ctx context.Context, method, group string) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI."+method, types.GroupArgs{Name: group}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result struct{}
+			return client.Call("ServerAPI."+method+"Result", id, &result)
+		})
+}
+
+// StartGroup starts all the instances of a group
+func StartGroup(ctx context.Context, group string) error {
+	return groupCommand(ctx, "StartGroup", group)
+}
+
+// StopGroup cleanly shuts down all the instances of a group
+func StopGroup(ctx context.Context, group string) error {
+	return groupCommand(ctx, "StopGroup", group)
+}
+
+// QuitGroup forceably kills all the instances of a group
+func QuitGroup(ctx context.Context, group string) error {
+	return groupCommand(ctx, "QuitGroup", group)
+}
+
+// DeleteGroup deletes all the instances of a group
+func DeleteGroup(ctx context.Context, group string) error {
+	return groupCommand(ctx, "DeleteGroup", group)
+}
+
+// Groups lists the current groups and the instances that belong to them
+func Groups(ctx context.Context) error {
+	instanceDetails, err := allInstanceDetails(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		members := make([]types.InstanceDetails, 0, len(instanceDetails))
+		for _, id := range instanceDetails {
+			if id.Group != "" {
+				members = append(members, id)
+			}
+		}
+		return printJSON(members)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	header := false
+	for i := range instanceDetails {
+		id := &instanceDetails[i]
+		if id.Group == "" {
+			continue
+		}
+		if !header {
+			fmt.Fprintln(w, "Group\tRole\tName\tHostIP\tStatus\t")
+			header = true
+		}
+		status, _ := instanceStatus(id)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", id.Group, id.Role, id.Name,
+			id.VMSpec.HostIP, status)
+	}
+	_ = w.Flush()
+
+	return nil
+}
+
+func sendConsoleInput(ctx context.Context, instanceName, data string) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.SendConsoleInput", types.ConsoleInputArgs{
+				Name: instanceName,
+				Data: data,
+			}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			reply := struct{}{}
+			return client.Call("ServerAPI.SendConsoleInputResult", id, &reply)
+		})
+}
+
+// Console prints the last lines lines of the serial console log of an
+// instance, or the entire log if lines is 0.
+func Console(ctx context.Context, instanceName string, lines int, follow, attach bool) error {
+	if attach {
+		follow = true
+		fmt.Fprintln(os.Stderr, "Attached to console.  Press Ctrl-C to detach.")
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				err := sendConsoleInput(ctx, instanceName, scanner.Text()+"\r")
+				if err != nil {
+					if ctx.Err() == nil {
+						fmt.Fprintln(os.Stderr, err)
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.GetConsoleLog", types.ConsoleLogArgs{
+				Name:   instanceName,
+				Lines:  lines,
+				Follow: follow,
+			}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			for {
+				var out types.ConsoleOutput
+				err := client.Call("ServerAPI.GetConsoleLogResult", id, &out)
+				if err != nil {
+					return err
+				}
+				fmt.Print(out.Data)
+				if out.Finished {
+					return nil
+				}
+			}
+		})
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// CreateNetwork creates a named network to which instances can be connected
+// when they are created.
+func CreateNetwork(ctx context.Context, spec *types.NetworkSpec) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.CreateNetwork", spec, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result struct{}
+			return client.Call("ServerAPI.CreateNetworkResult", id, &result)
+		})
+}
+
+// DeleteNetwork deletes a named network.
+func DeleteNetwork(ctx context.Context, networkName string) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.DeleteNetwork", networkName, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result struct{}
+			return client.Call("ServerAPI.DeleteNetworkResult", id, &result)
+		})
+}
+
+// ListNetworks lists the networks and the instances connected to them.
+func ListNetworks(ctx context.Context) error {
+	var networks []types.NetworkInfo
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.ListNetworks", struct{}{}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.ListNetworksResult", id, &networks)
+		})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(networks)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Name\tSubnet\tIPv6 Subnet\tMode\tIsolated\tDNS Search\tInstances\t")
+	for i := range networks {
+		n := &networks[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\t%s\t%s\t\n", n.Name, n.Subnet, n.IPv6Subnet, n.Mode,
+			n.Isolated, strings.Join(n.DNSSearch, ","), strings.Join(n.Instances, ","))
+	}
+	_ = w.Flush()
+
+	return nil
+}
+
+// CreateVolume creates a named volume.
+func CreateVolume(ctx context.Context, spec *types.VolumeSpec) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.CreateVolume", spec, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result struct{}
+			return client.Call("ServerAPI.CreateVolumeResult", id, &result)
+		})
+}
+
+// DeleteVolume deletes a named volume.
+func DeleteVolume(ctx context.Context, volumeName string) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.DeleteVolume", volumeName, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result struct{}
+			return client.Call("ServerAPI.DeleteVolumeResult", id, &result)
+		})
+}
+
+// ListVolumes lists the named volumes and the instances to which they are
+// attached.
+func ListVolumes(ctx context.Context) error {
+	var volumes []types.VolumeInfo
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.ListVolumes", struct{}{}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.ListVolumesResult", id, &volumes)
+		})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(volumes)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Name\tSize\tInstance\tDisk\t")
+	for i := range volumes {
+		v := &volumes[i]
+		fmt.Fprintf(w, "%s\t%d GiB\t%s\t%s\t\n", v.Name, v.SizeGiB, v.Instance, v.Disk)
+	}
+	_ = w.Flush()
+
+	return nil
+}
+
+// ListCaches lists the shared caches and the instances that use them.
+func ListCaches(ctx context.Context) error {
+	var caches []types.CacheInfo
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.ListCaches", struct{}{}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.ListCachesResult", id, &caches)
+		})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(caches)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Name\tSize\tFiles\tInstances\t")
+	for i := range caches {
+		c := &caches[i]
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t\n", c.Name, formatBytes(c.SizeBytes), c.Files,
+			strings.Join(c.Instances, ","))
+	}
+	_ = w.Flush()
+
+	return nil
+}
+
+// ClearCache deletes the contents of a shared cache.
+func ClearCache(ctx context.Context, cacheName string) error {
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.ClearCache", cacheName, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result struct{}
+			return client.Call("ServerAPI.ClearCacheResult", id, &result)
+		})
+}
+
+// Prefetch downloads the workload identified by args.WorkloadName and all
+// the files needed to create instances of it, so that they can later be
+// created with the Offline option.
+func Prefetch(ctx context.Context, args *types.CreateArgs) error {
+	if err := setCreateEnv(args); err != nil {
+		return err
+	}
+
+	return issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Prefetch", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result types.CreateResult
+			for {
+				err := client.Call("ServerAPI.PrefetchResult", id, &result)
+				if err != nil {
+					return err
+				}
+				if jsonOutput() {
+					if err := printJSON(&result); err != nil || result.Finished {
+						return err
+					}
+					continue
+				}
+				if result.Finished {
+					fmt.Printf("\nWorkload %s prefetched\n", args.WorkloadName)
+					return nil
+				}
+				fmt.Print(result.Line)
 			}
+		})
+}
 
-			if sshReady(ctx, in.VMSpec.HostIP, in.SSH.Port) {
-				break DONE
-			}
+// UpdateWorkloads downloads again the cached remote workloads identified by
+// URLs, or all the cached remote workloads if URLs is empty.
+func UpdateWorkloads(ctx context.Context, URLs []string) error {
+	var updated []string
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.UpdateWorkloads", URLs, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.UpdateWorkloadsResult", id, &updated)
+		})
+	if err != nil {
+		return err
+	}
 
-			if !silent {
-				fmt.Print(".")
-			}
-		}
-		if !silent {
-			fmt.Println()
-		}
+	if jsonOutput() {
+		return printJSON(updated)
+	}
+
+	for _, URL := range updated {
+		fmt.Printf("Updated %s\n", URL)
 	}
 
 	return nil
 }
 
-func getInstanceDetails(ctx context.Context, instanceName string) (types.InstanceDetails, error) {
-	var details types.InstanceDetails
+// Workloads lists the workloads from which instances can be created.
+func Workloads(ctx context.Context) error {
+	var workloads []types.WorkloadInfo
 	err := issueCommand(ctx,
 		func(client *rpc.Client) (int, error) {
 			var id int
-			err := client.Call("ServerAPI.GetInstanceDetails", instanceName, &id)
+			err := client.Call("ServerAPI.GetWorkloads", struct{}{}, &id)
 			return id, err
 		},
 		func(client *rpc.Client, id int) error {
-			err := client.Call("ServerAPI.GetInstanceDetailsResult", id, &details)
-			if err != nil {
-				return err
-			}
-			return nil
+			return client.Call("ServerAPI.GetWorkloadsResult", id, &workloads)
 		})
-	return details, err
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(workloads)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(w, "Name\tSource\tBase Image\tInherits\t")
+	for i := range workloads {
+		wi := &workloads[i]
+		image := wi.BaseImageName
+		if wi.Group {
+			image = "(group)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", wi.Name, wi.Source, image, strings.Join(wi.Inherits, ","))
+	}
+	_ = w.Flush()
+
+	return nil
 }
 
-// Status prints out VM information
-func Status(ctx context.Context, instanceName string) error {
-	result, err := getInstanceDetails(ctx, instanceName)
+// ShowWorkload prints the instance specification and the cloud-init document
+// of a workload, rendered as they would be for a new instance.
+func ShowWorkload(ctx context.Context, workloadName string) error {
+	var details types.WorkloadDetails
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.ShowWorkload", workloadName, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.ShowWorkloadResult", id, &details)
+		})
 	if err != nil {
 		return err
 	}
-	statusVM(ctx, &result)
+
+	if jsonOutput() {
+		return printJSON(details)
+	}
+
+	fmt.Printf("---\n%s...\n", details.Spec)
+	if details.CloudConfig != "" {
+		fmt.Printf("---\n%s...\n", details.CloudConfig)
+	}
+
 	return nil
 }
 
-// Run connects to the VM via SSH and runs the desired command
-func Run(ctx context.Context, instanceName, command string) error {
-	path, err := exec.LookPath("ssh")
+// ValidateWorkload checks the workload stored in workloadPath for errors,
+// which are printed along with the lines at which they were found.
+func ValidateWorkload(ctx context.Context, workloadPath string) error {
+	data, err := ioutil.ReadFile(workloadPath)
 	if err != nil {
-		return fmt.Errorf("Unable to locate ssh binary")
+		return errors.Wrapf(err, "Unable to read %s", workloadPath)
 	}
 
-	result, err := getInstanceDetails(ctx, instanceName)
+	var problems []types.WorkloadProblem
+	err = issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.ValidateWorkload", types.ValidateWorkloadArgs{
+				Name: strings.TrimSuffix(filepath.Base(workloadPath), ".yaml"),
+				Data: data,
+			}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.ValidateWorkloadResult", id, &problems)
+		})
 	if err != nil {
 		return err
 	}
 
-	err = waitForSSH(ctx, &result, command != "")
+	if jsonOutput() {
+		if err := printJSON(problems); err != nil {
+			return err
+		}
+	} else {
+		for _, p := range problems {
+			if p.Line > 0 {
+				fmt.Printf("%s:%d: %s\n", workloadPath, p.Line, p.Message)
+			} else {
+				fmt.Printf("%s: %s\n", workloadPath, p.Message)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.Errorf("%s is not a valid workload", workloadPath)
+	}
+	if !jsonOutput() {
+		fmt.Printf("%s is a valid workload\n", workloadPath)
+	}
+
+	return nil
+}
+
+// Export exports a stopped instance to an archive from which workloads can
+// be created with Import.
+func Export(ctx context.Context, args *types.ExportArgs) error {
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Export", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			var result struct{}
+			return client.Call("ServerAPI.ExportResult", id, &result)
+		})
 	if err != nil {
 		return err
 	}
 
-	args := []string{
-		path,
-		"-q", "-F", "/dev/null",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "IdentitiesOnly=yes",
-		"-i", result.SSH.KeyPath,
-		result.VMSpec.HostIP.String(), "-p", strconv.Itoa(result.SSH.Port),
+	if !jsonOutput() {
+		fmt.Printf("Instance exported to %s\n", args.Path)
 	}
 
-	if command != "" {
-		args = append(args, command)
-	}
+	return nil
+}
 
-	err = syscall.Exec(path, args, os.Environ())
-	return err
+// BuildTemplate seals the running instance args.Name and turns it into the
+// template args.Template.
+func BuildTemplate(ctx context.Context, args *types.BuildTemplateArgs) (*types.TemplateInfo, error) {
+	var info types.TemplateInfo
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.BuildTemplate", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.BuildTemplateResult", id, &info)
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
 }
 
-// Copy copies files between the host and the guest using scp
-func Copy(ctx context.Context, instanceName string, recurse, host bool, src, dest string) error {
-	path, err := exec.LookPath("scp")
+// BuildImage creates an instance of args.WorkloadName, turns it into the
+// template template, copying its image to output, and deletes the instance.
+func BuildImage(ctx context.Context, args *types.CreateArgs, template, output string) (*types.TemplateInfo, error) {
+	if args.Name == "" {
+		args.Name = "build-" + template
+	}
+
+	if err := Create(ctx, args, true); err != nil {
+		return nil, err
+	}
+
+	info, err := BuildTemplate(ctx, &types.BuildTemplateArgs{
+		Name:     args.Name,
+		Template: template,
+		Output:   output,
+	})
+	if delErr := Delete(ctx, args.Name); err == nil && delErr != nil {
+		err = errors.Wrapf(delErr, "Unable to delete %s", args.Name)
+	}
 	if err != nil {
-		return fmt.Errorf("Unable to locate scp binary")
+		return nil, err
 	}
+	return info, nil
+}
 
-	result, err := getInstanceDetails(ctx, instanceName)
+// Build builds the template template from the workload args.WorkloadName,
+// as BuildImage does, and reports where it has been stored.
+func Build(ctx context.Context, args *types.CreateArgs, template, output string) error {
+	info, err := BuildImage(ctx, args, template, output)
 	if err != nil {
 		return err
 	}
 
-	args := []string{
-		path,
-		"-q", "-F", "/dev/null",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "IdentitiesOnly=yes",
-		"-i", result.SSH.KeyPath,
-		"-P", strconv.Itoa(result.SSH.Port),
+	if jsonOutput() {
+		return printJSON(info)
 	}
 
-	if recurse {
-		args = append(args, "-r")
+	fmt.Printf("Template %s built\n", info.Name)
+	fmt.Printf("Image: %s\n", info.Image)
+	fmt.Printf("Type 'ccloudvm create --from-template %s' to create instances from it.\n", info.Name)
+
+	return nil
+}
+
+// Import creates a workload from an archive created by Export.
+func Import(ctx context.Context, args *types.ImportArgs) error {
+	var name string
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Import", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.ImportResult", id, &name)
+		})
+	if err != nil {
+		return err
 	}
 
-	if host {
-		args = append(args, fmt.Sprintf("%s:%s", result.VMSpec.HostIP.String(), src))
-		args = append(args, dest)
-	} else {
-		args = append(args, src)
-		args = append(args, fmt.Sprintf("%s:%s", result.VMSpec.HostIP.String(), dest))
+	if jsonOutput() {
+		return printJSON(name)
 	}
 
-	return syscall.Exec(path, args, os.Environ())
+	fmt.Printf("Workload %s imported\n", name)
+	fmt.Printf("Type ccloudvm create %s to create an instance from it.\n", name)
+
+	return nil
 }
 
-// Connect opens a shell to the VM via
-func Connect(ctx context.Context, instanceName string) error {
-	return Run(ctx, instanceName, "")
+// ImportVagrant creates a workload from a Vagrantfile.
+func ImportVagrant(ctx context.Context, args *types.ImportVagrantArgs) error {
+	var result types.ImportVagrantResult
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.ImportVagrant", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.ImportVagrantResult", id, &result)
+		})
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(&result)
+	}
+
+	for _, w := range result.Warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	fmt.Printf("Workload %s, inheriting from %s, imported\n", result.Name, result.Inherits)
+	fmt.Printf("Type ccloudvm workload show %s to review it and ccloudvm create %s to create an instance from it.\n",
+		result.Name, result.Name)
+
+	return nil
 }
 
-// Delete the VM
-func Delete(ctx context.Context, instanceName string) error {
-	return issueCommand(ctx,
+// Push pushes an archive created by Export to an OCI registry.
+func Push(ctx context.Context, args *types.PushArgs) error {
+	err := issueCommand(ctx,
 		func(client *rpc.Client) (int, error) {
 			var id int
-			err := client.Call("ServerAPI.Delete", instanceName, &id)
+			err := client.Call("ServerAPI.Push", *args, &id)
 			return id, err
 		},
 		func(client *rpc.Client, id int) error {
 			var result struct{}
-			return client.Call("ServerAPI.DeleteResult", id, &result)
+			return client.Call("ServerAPI.PushResult", id, &result)
 		})
+	if err != nil {
+		return err
+	}
+
+	if !jsonOutput() {
+		fmt.Printf("%s pushed to %s\n", args.Path, args.Reference)
+	}
+
+	return nil
 }
 
-// Instances provides information about all of the current instances
-func Instances(ctx context.Context) error {
-	var instances []string
+// Pull creates a workload from an exported instance stored in an OCI
+// registry.
+func Pull(ctx context.Context, args *types.PullArgs) error {
+	var name string
 	err := issueCommand(ctx,
 		func(client *rpc.Client) (int, error) {
 			var id int
-			err := client.Call("ServerAPI.GetInstances", struct{}{}, &id)
+			err := client.Call("ServerAPI.Pull", *args, &id)
 			return id, err
 		},
 		func(client *rpc.Client, id int) error {
-			return client.Call("ServerAPI.GetInstancesResult", id, &instances)
+			return client.Call("ServerAPI.PullResult", id, &name)
 		})
-
 	if err != nil {
 		return err
 	}
 
-	instanceDetails := make([]types.InstanceDetails, 0, len(instances))
-	for _, name := range instances {
-		details, err := getInstanceDetails(ctx, name)
-		if err != nil {
-			continue
-		}
-		instanceDetails = append(instanceDetails, details)
+	if jsonOutput() {
+		return printJSON(name)
 	}
 
-	if len(instanceDetails) == 0 {
-		return nil
+	fmt.Printf("Workload %s pulled from %s\n", name, args.Reference)
+	fmt.Printf("Type ccloudvm create %s to create an instance from it.\n", name)
+
+	return nil
+}
+
+// Exec executes command in an instance over SSH via the daemon, copying its
+// output to stdout and stderr, and returns its exit code.
+func Exec(ctx context.Context, instanceName, command string) (int, error) {
+	var result types.ExecOutput
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Exec", types.ExecArgs{
+				Name:    instanceName,
+				Command: command,
+			}, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			for {
+				var out types.ExecOutput
+				err := client.Call("ServerAPI.ExecResult", id, &out)
+				if err != nil {
+					return err
+				}
+				if jsonOutput() {
+					result.Stdout += out.Stdout
+					result.Stderr += out.Stderr
+				} else {
+					_, _ = os.Stdout.WriteString(out.Stdout)
+					_, _ = os.Stderr.WriteString(out.Stderr)
+				}
+				if out.Finished {
+					result.Finished = true
+					result.ExitCode = out.ExitCode
+					return nil
+				}
+			}
+		})
+	if err != nil {
+		return 0, err
 	}
 
-	w := new(tabwriter.Writer)
-	w.Init(os.Stdout, 0, 8, 1, '\t', 0)
-	fmt.Fprintln(w, "Name\tHostIP\tWorkload\tVCPUs\tMem\tDisk\t")
-	for _, id := range instanceDetails {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d MiB\t%d Gib\n",
-			id.Name, id.VMSpec.HostIP, id.Workload,
-			id.VMSpec.CPUs, id.VMSpec.MemMiB, id.VMSpec.DiskGiB)
+	if jsonOutput() {
+		return result.ExitCode, printJSON(&result)
 	}
-	_ = w.Flush()
 
-	return nil
+	return result.ExitCode, nil
+}
 
+// Proxy runs a SOCKS proxy, and optionally an HTTP proxy, through an
+// instance until ctx is cancelled.
+func Proxy(ctx context.Context, args *types.ProxyArgs) error {
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.Proxy", *args, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			for {
+				var status types.ProxyStatus
+				err := client.Call("ServerAPI.ProxyResult", id, &status)
+				if err != nil {
+					return err
+				}
+				if status.Finished {
+					return nil
+				}
+				if jsonOutput() {
+					if err := printJSON(&status); err != nil {
+						return err
+					}
+					continue
+				}
+				if status.SOCKS != "" {
+					fmt.Printf("SOCKS proxy listening on %s\n", status.SOCKS)
+				}
+				if status.HTTP != "" {
+					fmt.Printf("HTTP proxy listening on %s\n", status.HTTP)
+				}
+				fmt.Println("Press Ctrl-C to stop the proxy")
+			}
+		})
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
+	return err
 }
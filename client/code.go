@@ -0,0 +1,132 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// Code opens a directory of an instance in an editor that supports VS
+// Code's Remote-SSH URIs.
+func Code(ctx context.Context, instanceName, dir, editor string, printOnly bool) error {
+	remote, err := getRemoteDaemon()
+	if err != nil {
+		return err
+	}
+	if remote != nil {
+		return errors.Errorf("Instances run by %s cannot be opened in a local editor", remote.host)
+	}
+
+	details, err := sshInstanceDetails(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+	if details.SSH.Port == 0 {
+		return errors.Errorf("%s has no SSH port mapping", details.Name)
+	}
+
+	result, err := refreshStatus(ctx, []string{details.Name}, 0)
+	if err != nil {
+		return err
+	}
+	if len(result.Instances) != 1 || !result.Instances[0].Status.Running {
+		fmt.Printf("Starting %s\n", details.Name)
+		if err := Start(ctx, details.Name, &types.VMSpec{}, false); err != nil {
+			return err
+		}
+	}
+
+	err = waitForSSH(ctx, &details, printOnly)
+	if err != nil {
+		return err
+	}
+
+	dir, err = guestDirectory(ctx, &details, dir)
+	if err != nil {
+		return err
+	}
+	uri := fmt.Sprintf("vscode-remote://ssh-remote+%s%s", details.Name, dir)
+	if printOnly {
+		fmt.Println(uri)
+		return nil
+	}
+
+	if err := checkSSHConfigIncluded(); err != nil {
+		return err
+	}
+	path, err := exec.LookPath(editor)
+	if err != nil {
+		return errors.Errorf("Unable to locate %s.  Use --print to obtain the URI of the directory", editor)
+	}
+	cmd := exec.CommandContext(ctx, path, "--folder-uri", uri)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		return errors.Wrapf(err, "Unable to run %s", editor)
+	}
+	return nil
+}
+
+// guestDirectory returns the absolute path of the directory dir of the
+// guest of an instance, resolved relative to the home directory of the
+// user.  It fails if the directory does not exist.
+func guestDirectory(ctx context.Context, details *types.InstanceDetails, dir string) (string, error) {
+	command := "pwd"
+	if dir != "" {
+		command = "cd -- '" + strings.Replace(dir, "'", `'\''`, -1) + "' && pwd"
+	}
+
+	args := append(sshOptions(details), details.SSH.Host.String(),
+		"-p", strconv.Itoa(details.SSH.Port), command)
+	out, err := exec.CommandContext(ctx, "ssh", args...).Output()
+	if err != nil {
+		if dir != "" {
+			return "", errors.Errorf("Directory %s does not exist in %s", dir, details.Name)
+		}
+		return "", errors.Wrapf(err, "Unable to reach %s", details.Name)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// checkSSHConfigIncluded verifies that the ssh_config fragment of the
+// daemon is included in ~/.ssh/config.
+func checkSSHConfigIncluded() error {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return errors.New("HOME is not defined")
+	}
+
+	fragment, err := sshConfigFragment(home)
+	if err != nil {
+		return err
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if _, included := updateSSHConfig(data, sshIncludeLine(fragment), false); !included {
+		return errors.New("The ssh configuration of the instances is not included in ~/.ssh/config.  Run ccloudvm ssh-config --install")
+	}
+	return nil
+}
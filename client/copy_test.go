@@ -0,0 +1,83 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"testing"
+)
+
+func TestSplitInstancePath(t *testing.T) {
+	tests := []struct {
+		arg      string
+		instance string
+		path     string
+		ok       bool
+	}{
+		{"sad-nimue:code/inc", "sad-nimue", "code/inc", true},
+		{"sad-nimue:", "sad-nimue", ".", true},
+		{"local.conf", "", "local.conf", false},
+		{":local.conf", "", ":local.conf", false},
+		{"./a:b", "", "./a:b", false},
+	}
+
+	for _, tt := range tests {
+		instance, path, ok := splitInstancePath(tt.arg)
+		if instance != tt.instance || path != tt.path || ok != tt.ok {
+			t.Errorf("splitInstancePath(%q) returned %q, %q, %v", tt.arg,
+				instance, path, ok)
+		}
+	}
+}
+
+func TestSftpQuote(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"code/inc", `"code/inc"`},
+		{"my file", `"my file"`},
+		{`a"b`, `"a\"b"`},
+		{`a\b`, `"a\\b"`},
+		{"*.log", `"\*.log"`},
+		{"file?[0]", `"file\?\[0\]"`},
+	}
+
+	for _, tt := range tests {
+		if quoted := sftpQuote(tt.path); quoted != tt.expected {
+			t.Errorf("sftpQuote(%q) returned %s, expected %s", tt.path,
+				quoted, tt.expected)
+		}
+	}
+}
+
+func TestSftpBatch(t *testing.T) {
+	tests := []struct {
+		command  string
+		src      string
+		dest     string
+		expected string
+	}{
+		{"put", "inc", "code/inc", "progress\nput -r \"inc\" \"code/inc\"\n"},
+		{"get", "/var/log/*.log", ".", "progress\nget -r \"/var/log/\\*.log\" \".\"\n"},
+	}
+
+	for _, tt := range tests {
+		if batch := sftpBatch(tt.command, tt.src, tt.dest); batch != tt.expected {
+			t.Errorf("Unexpected batch %q, expected %q", batch, tt.expected)
+		}
+	}
+}
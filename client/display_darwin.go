@@ -0,0 +1,35 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/intel/ccloudvm/types"
+)
+
+// displayViewers returns the commands that can show a graphical console
+// listening on host:port, in order of preference.  VNC consoles are shown
+// by the Screen Sharing application.
+func displayViewers(graphics, host string, port int) [][]string {
+	url := graphics + "://" + net.JoinHostPort(host, strconv.Itoa(port))
+	if graphics == types.GraphicsVNC {
+		return [][]string{{"open", url}}
+	}
+	return [][]string{{"remote-viewer", url}}
+}
@@ -0,0 +1,94 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// dockerContextName returns the name of the docker context created for an
+// instance.
+func dockerContextName(instanceName string) string {
+	return "ccloudvm-" + instanceName
+}
+
+// DockerEnv prints the commands that point the docker client of the
+// current shell at the Docker daemon of an instance, or, if unset is true,
+// that point it back at the local daemon.  If createContext is true a
+// docker context called ccloudvm-<instance> is created, or updated, instead.
+func DockerEnv(ctx context.Context, instanceName string, unset, createContext bool) error {
+	if unset {
+		fmt.Println("unset DOCKER_HOST")
+		fmt.Println("# Run this command to configure your shell:")
+		fmt.Println("# eval $(ccloudvm docker-env --unset)")
+		return nil
+	}
+
+	remote, err := getRemoteDaemon()
+	if err != nil {
+		return err
+	}
+	if remote != nil {
+		return errors.Errorf("The Docker sockets of the instances run by %s are not available locally", remote.host)
+	}
+
+	details, err := getInstanceDetails(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+	if details.DockerSocket == "" {
+		return errors.Errorf("The Docker socket of %s is not forwarded.  Create the instance with --docker or with a workload that sets docker: true", details.Name)
+	}
+	if _, err := os.Stat(details.DockerSocket); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: The Docker socket of %s is not available yet.  Is the instance running?\n", details.Name)
+	}
+	host := "unix://" + details.DockerSocket
+
+	if !createContext {
+		fmt.Printf("export DOCKER_HOST=%q\n", host)
+		fmt.Println("# Run this command to configure your shell:")
+		fmt.Printf("# eval $(ccloudvm docker-env %s)\n", details.Name)
+		return nil
+	}
+
+	name := dockerContextName(details.Name)
+	verb := "create"
+	if exec.CommandContext(ctx, "docker", "context", "inspect", name).Run() == nil {
+		verb = "update"
+	}
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", "context", verb, name,
+		"--description", "ccloudvm instance "+details.Name,
+		"--docker", "host="+host)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		if out := strings.TrimSpace(output.String()); out != "" {
+			return errors.Errorf("Unable to %s docker context %s: %s", verb, name, out)
+		}
+		return errors.Wrapf(err, "Unable to %s docker context %s", verb, name)
+	}
+	fmt.Printf("Docker context %s points at %s.  Run docker context use %s to select it\n", name, host, name)
+	return nil
+}
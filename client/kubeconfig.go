@@ -0,0 +1,132 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"path/filepath"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// kubeconfigPath returns the path of the kubeconfig into which the
+// kubeconfigs of the instances are merged, the first file listed in
+// KUBECONFIG or ~/.kube/config.
+func kubeconfigPath() (string, error) {
+	for _, p := range filepath.SplitList(os.Getenv("KUBECONFIG")) {
+		if p != "" {
+			return p, nil
+		}
+	}
+
+	home := os.Getenv("HOME")
+	if home == "" {
+		return "", errors.New("HOME is not defined")
+	}
+	return filepath.Join(home, ".kube", "config"), nil
+}
+
+// Kubeconfig merges the kubeconfig of the cluster run by an instance into
+// the user's kubeconfig, under a context called ccloudvm-<instance>.
+func Kubeconfig(ctx context.Context, instanceName string, use, printOnly bool) error {
+	remote, err := getRemoteDaemon()
+	if err != nil {
+		return err
+	}
+	if remote != nil {
+		return errors.Errorf("The clusters of the instances run by %s cannot be reached by a local kubectl", remote.host)
+	}
+
+	var result types.KubeconfigResult
+	err = issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.GetKubeconfig", instanceName, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.GetKubeconfigResult", id, &result)
+		})
+	if err != nil {
+		return err
+	}
+
+	if printOnly {
+		_, err = os.Stdout.Write(result.Kubeconfig)
+		return err
+	}
+
+	src, err := types.ParseKubeconfig(result.Kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	configPath, err := kubeconfigPath()
+	if err != nil {
+		return err
+	}
+	if p, err := filepath.EvalSymlinks(configPath); err == nil {
+		configPath = p
+	}
+	mode := os.FileMode(0600)
+	data, err := ioutil.ReadFile(configPath)
+	if err == nil {
+		if fi, err := os.Stat(configPath); err == nil {
+			mode = fi.Mode().Perm()
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "Unable to read %s", configPath)
+	}
+
+	dst, err := types.ParseKubeconfig(data)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to merge into %s", configPath)
+	}
+	dst.Merge(src)
+	if use || dst.CurrentContext == "" {
+		dst.CurrentContext = result.Context
+	}
+	data, err = dst.Marshal()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(configPath), 0700); err != nil {
+		return errors.Wrapf(err, "Unable to create %s", filepath.Dir(configPath))
+	}
+	tmp := configPath + ".ccloudvm"
+	if err := ioutil.WriteFile(tmp, data, mode); err != nil {
+		return errors.Wrapf(err, "Unable to write %s", configPath)
+	}
+	if err := os.Rename(tmp, configPath); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrapf(err, "Unable to write %s", configPath)
+	}
+
+	if dst.CurrentContext == result.Context {
+		fmt.Printf("Context %s merged into %s and selected\n", result.Context, configPath)
+	} else {
+		fmt.Printf("Context %s merged into %s.  Select it with kubectl config use-context %s\n",
+			result.Context, configPath, result.Context)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Formats in which the client commands print their results.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var outputFormat = FormatText
+
+// SetFormat selects the format in which the client commands print their
+// results.  In the JSON format, results are printed as the JSON encoding of
+// the corresponding types structures, one value per line.
+func SetFormat(format string) error {
+	switch format {
+	case FormatText, FormatJSON:
+		outputFormat = format
+		return nil
+	}
+
+	return errors.Errorf("Unsupported output format %s", format)
+}
+
+func jsonOutput() bool {
+	return outputFormat == FormatJSON
+}
+
+func printJSON(v interface{}) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
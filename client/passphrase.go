@@ -0,0 +1,110 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The passphrases of encrypted disks are read from the terminal, without
+// echoing them, or from the standard input if it is not a terminal.
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+func readLine(prompt string, terminal bool) (string, error) {
+	if terminal {
+		fmt.Fprint(os.Stderr, prompt)
+		if err := stty("-echo"); err != nil {
+			return "", errors.Wrap(err, "Unable to disable echo")
+		}
+		defer func() {
+			_ = stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.Wrap(err, "Unable to read passphrase")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readPassphrase reads the passphrase of an encrypted disk.  New
+// passphrases are read twice, when read from a terminal, to guard against
+// typos.
+func readPassphrase(prompt string, confirm bool) (string, error) {
+	fi, err := os.Stdin.Stat()
+	terminal := err == nil && fi.Mode()&os.ModeCharDevice != 0
+
+	passphrase, err := readLine(prompt, terminal)
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("Empty passphrase")
+	}
+
+	if confirm && terminal {
+		again, err := readLine("Confirm passphrase: ", terminal)
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", errors.New("Passphrases do not match")
+		}
+	}
+
+	return passphrase, nil
+}
+
+func passphraseRequired(err error) bool {
+	return err != nil && strings.Contains(err.Error(), types.ErrPassphraseRequired)
+}
+
+// withPassphrase runs cmd and, if cmd fails because the passphrase of an
+// encrypted disk is required, prompts for the passphrase and runs cmd again
+// with it.
+func withPassphrase(ctx context.Context, passphrase *string, confirm bool, cmd func() error) error {
+	err := cmd()
+	if !passphraseRequired(err) || *passphrase != "" {
+		return err
+	}
+
+	*passphrase, err = readPassphrase("Disk passphrase: ", confirm)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return cmd()
+}
@@ -0,0 +1,188 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// The client talks to a daemon running on another machine, over mutual TLS,
+// if CCLOUDVM_HOST is set.
+
+// remoteDaemon identifies the daemon designated by CCLOUDVM_HOST.
+type remoteDaemon struct {
+	address string
+	host    string
+}
+
+// getRemoteDaemon returns the daemon designated by CCLOUDVM_HOST, or nil if
+// the local daemon is to be used.
+func getRemoteDaemon() (*remoteDaemon, error) {
+	host := os.Getenv("CCLOUDVM_HOST")
+	if host == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(host)
+	if err != nil || u.Scheme != "tcp" || u.Host == "" {
+		return nil, errors.Errorf("Invalid CCLOUDVM_HOST %s, expected tcp://host:port", host)
+	}
+	if u.Port() == "" {
+		return nil, errors.Errorf("No port in CCLOUDVM_HOST %s", host)
+	}
+
+	return &remoteDaemon{
+		address: u.Host,
+		host:    u.Hostname(),
+	}, nil
+}
+
+func (r *remoteDaemon) tlsConfig(home string) (*tls.Config, error) {
+	certDir := os.Getenv("CCLOUDVM_CERT_PATH")
+	if certDir == "" {
+		certDir = filepath.Join(types.DataDir(home), "tls")
+	}
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(certDir, "cert.pem"),
+		filepath.Join(certDir, "key.pem"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to load client certificate")
+	}
+
+	caPath := filepath.Join(certDir, "ca.pem")
+	caData, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read CA certificate")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, errors.Errorf("No certificates found in %s", caPath)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   r.host,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func (r *remoteDaemon) dial(ctx context.Context, home string) (*rpc.Client, error) {
+	cfg, err := r.tlsConfig(home)
+	if err != nil {
+		return nil, err
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	d := &net.Dialer{}
+	conn, err := d.DialContext(timeoutCtx, "tcp", r.address)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, cfg)
+	deadline, _ := timeoutCtx.Deadline()
+	_ = tlsConn.SetDeadline(deadline)
+	if err := tlsConn.Handshake(); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "TLS handshake failed")
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+
+	return connectRPC(tlsConn)
+}
+
+// jumpHost returns the host through which ssh reaches the instances run
+// by the daemon.
+func (r *remoteDaemon) jumpHost() string {
+	if jump := os.Getenv("CCLOUDVM_SSH_JUMP"); jump != "" {
+		return jump
+	}
+	return r.host
+}
+
+// useRemoteSSHKey retrieves the SSH key of an instance run by a remote
+// daemon and stores it in the remote directory of the ccloudvm directory,
+// updating details to refer to the local copy.
+func useRemoteSSHKey(ctx context.Context, r *remoteDaemon, details *types.InstanceDetails) error {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return errors.New("HOME is not defined")
+	}
+
+	var key types.SSHKeyResult
+	err := issueCommand(ctx,
+		func(client *rpc.Client) (int, error) {
+			var id int
+			err := client.Call("ServerAPI.GetSSHKey", details.Name, &id)
+			return id, err
+		},
+		func(client *rpc.Client, id int) error {
+			return client.Call("ServerAPI.GetSSHKeyResult", id, &key)
+		})
+	if err != nil {
+		return err
+	}
+
+	keyDir := filepath.Join(types.DataDir(home), "remote", r.host, details.Name)
+	err = os.MkdirAll(keyDir, 0700)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to create %s", keyDir)
+	}
+
+	keyPath := filepath.Join(keyDir, "id")
+	err = ioutil.WriteFile(keyPath, key.PrivateKey, 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to write SSH key")
+	}
+	details.SSH.KeyPath = keyPath
+
+	details.SSH.CertPath = ""
+	if len(key.Certificate) > 0 {
+		certPath := keyPath + "-cert.pub"
+		err = ioutil.WriteFile(certPath, key.Certificate, 0600)
+		if err != nil {
+			return errors.Wrap(err, "Unable to write SSH certificate")
+		}
+		details.SSH.CertPath = certPath
+	}
+
+	return nil
+}
+
+// sshJumpHost returns the host through which ssh reaches the instances run
+// by a remote daemon, or an empty string if the local daemon is used.
+func sshJumpHost() string {
+	remote, err := getRemoteDaemon()
+	if err != nil || remote == nil {
+		return ""
+	}
+	return remote.jumpHost()
+}
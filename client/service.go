@@ -0,0 +1,120 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build !darwin
+// +build !darwin
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/ciao-project/ciao/osprepare"
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+const systemdService = `
+[Unit]
+Description=Configurable CloudVM Service
+Requires=ccloudvm.socket
+After=ccloudvm.socket
+
+[Service]
+Type=simple
+ExecStart=%s/bin/ccvm
+KillMode=process
+
+[Install]
+WantedBy=default.target
+`
+
+const systemdSocket = `
+[Socket]
+ListenStream=%s
+SocketMode=0600
+
+[Install]
+WantedBy=sockets.target
+`
+
+func installDeps(ctx context.Context) {
+	osprepare.InstallDeps(ctx, ccloudvmDeps, logger{})
+}
+
+// installService installs and starts a systemd user service that socket
+// activates the ccloudvm daemon.
+func installService(home, goPath string) error {
+	systemdRootPath := filepath.Join(home, ".local/share/systemd/user")
+	err := os.MkdirAll(systemdRootPath, 0700)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create systemd directory")
+	}
+
+	servicePath := filepath.Join(systemdRootPath, "ccloudvm.service")
+	serviceData := fmt.Sprintf(systemdService, goPath)
+	err = ioutil.WriteFile(servicePath, []byte(serviceData), 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to write service file")
+	}
+
+	socketPath := filepath.Join(systemdRootPath, "ccloudvm.socket")
+	socketData := fmt.Sprintf(systemdSocket, filepath.Join(types.DataDir(home), "socket"))
+	err = ioutil.WriteFile(socketPath, []byte(socketData), 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to write service file")
+	}
+
+	err = exec.Command("systemctl", "--user", "daemon-reload").Run()
+	if err != nil {
+		return errors.Wrap(err, "Unable to reload service files")
+	}
+
+	err = exec.Command("systemctl", "--user", "enable", "ccloudvm.socket").Run()
+	if err != nil {
+		return errors.Wrap(err, "Unable to enable ccloudvm.socket")
+	}
+	err = exec.Command("systemctl", "--user", "start", "ccloudvm.socket").Run()
+	if err != nil {
+		return errors.Wrap(err, "Unable to start ccloudvm.socket")
+	}
+
+	return nil
+}
+
+func removeService(home string) {
+	_ = exec.Command("systemctl", "--user", "disable", "ccloudvm.service").Run()
+	_ = exec.Command("systemctl", "--user", "disable", "ccloudvm.socket").Run()
+	_ = exec.Command("systemctl", "--user", "stop", "ccloudvm.service").Run()
+	_ = exec.Command("systemctl", "--user", "stop", "ccloudvm.socket").Run()
+
+	systemdRootPath := filepath.Join(home, ".local/share/systemd/user")
+
+	servicePath := filepath.Join(systemdRootPath, "ccloudvm.service")
+	_ = os.Remove(servicePath)
+
+	socketPath := filepath.Join(systemdRootPath, "ccloudvm.socket")
+	_ = os.Remove(socketPath)
+}
+
+func restartService() error {
+	return exec.Command("systemctl", "--user", "restart", "ccloudvm.socket").Run()
+}
@@ -0,0 +1,109 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+const launchdLabel = "com.github.intel.ccloudvm"
+
+const launchdAgent = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>%s/bin/ccvm</string>
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`
+
+// macOSDeps lists the host binaries required by ccloudvm.  They are not
+// installed automatically but can be obtained from Homebrew.
+var macOSDeps = []string{"qemu-system-x86_64", "qemu-img", "xorriso", "ssh", "ssh-keygen"}
+
+func installDeps(ctx context.Context) {
+	for _, d := range macOSDeps {
+		if _, err := exec.LookPath(d); err != nil {
+			fmt.Printf("%s not found.  Please install it, e.g., brew install qemu xorriso\n", d)
+		}
+	}
+}
+
+func launchdAgentPath(home string) string {
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist")
+}
+
+// installService installs and loads a launchd agent that runs the ccloudvm
+// daemon.
+func installService(home, goPath string) error {
+	dataDir := types.DataDir(home)
+	err := os.MkdirAll(dataDir, 0700)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to create %s", dataDir)
+	}
+
+	agentPath := launchdAgentPath(home)
+	err = os.MkdirAll(filepath.Dir(agentPath), 0755)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create LaunchAgents directory")
+	}
+
+	logPath := filepath.Join(dataDir, "ccvm.log")
+	agentData := fmt.Sprintf(launchdAgent, launchdLabel, goPath, logPath, logPath)
+	err = ioutil.WriteFile(agentPath, []byte(agentData), 0600)
+	if err != nil {
+		return errors.Wrap(err, "Unable to write launchd agent file")
+	}
+
+	err = exec.Command("launchctl", "load", "-w", agentPath).Run()
+	if err != nil {
+		return errors.Wrap(err, "Unable to load launchd agent")
+	}
+
+	return nil
+}
+
+func removeService(home string) {
+	agentPath := launchdAgentPath(home)
+	_ = exec.Command("launchctl", "unload", "-w", agentPath).Run()
+	_ = os.Remove(agentPath)
+}
+
+func restartService() error {
+	return exec.Command("launchctl", "start", launchdLabel).Run()
+}
@@ -0,0 +1,151 @@
+//
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+)
+
+// SSHConfig includes the ssh_config fragment maintained by the daemon at the
+// top of ~/.ssh/config.
+
+// sshConfigFragment returns the path of the ssh_config fragment maintained
+// by the daemon serving the user.
+func sshConfigFragment(home string) (string, error) {
+	dataDir := types.DataDir(home)
+	if _, system := serviceSocket(home); system {
+		u, err := user.Current()
+		if err != nil {
+			return "", errors.Wrap(err, "Unable to determine user")
+		}
+		dataDir = types.SystemUserDataDir(u.Username)
+	}
+	return filepath.Join(dataDir, "ssh_config"), nil
+}
+
+// sshIncludeLine returns the line that includes fragment in ~/.ssh/config.
+func sshIncludeLine(fragment string) string {
+	if strings.ContainsAny(fragment, " \t") {
+		fragment = `"` + fragment + `"`
+	}
+	return "Include " + fragment
+}
+
+// updateSSHConfig returns the contents of an ssh config file, data, with
+// include added at its top or removed, and whether they changed.
+func updateSSHConfig(data []byte, include string, add bool) ([]byte, bool) {
+	lines := strings.SplitAfter(string(data), "\n")
+	var kept bytes.Buffer
+	found := false
+	for _, l := range lines {
+		if strings.TrimSpace(l) == include {
+			found = true
+			continue
+		}
+		kept.WriteString(l)
+	}
+
+	if add {
+		if found {
+			return data, false
+		}
+		return append([]byte(include+"\n\n"), data...), true
+	}
+	// Remove the blank line added after the include along with it.
+	updated := kept.Bytes()
+	if strings.HasPrefix(string(data), include+"\n\n") {
+		updated = updated[1:]
+	}
+	return updated, found
+}
+
+// SSHConfig adds the ssh_config fragment maintained by the daemon to
+// ~/.ssh/config if install is true, removes it if uninstall is true and
+// otherwise reports whether it is included.
+func SSHConfig(install, uninstall bool) error {
+	if install && uninstall {
+		return errors.New("Only one of install and uninstall may be specified")
+	}
+
+	home := os.Getenv("HOME")
+	if home == "" {
+		return errors.New("HOME is not defined")
+	}
+
+	fragment, err := sshConfigFragment(home)
+	if err != nil {
+		return err
+	}
+	include := sshIncludeLine(fragment)
+
+	sshDir := filepath.Join(home, ".ssh")
+	configPath := filepath.Join(sshDir, "config")
+	if p, err := filepath.EvalSymlinks(configPath); err == nil {
+		configPath = p
+	}
+	mode := os.FileMode(0600)
+	data, err := ioutil.ReadFile(configPath)
+	if err == nil {
+		if fi, err := os.Stat(configPath); err == nil {
+			mode = fi.Mode().Perm()
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "Unable to read %s", configPath)
+	}
+
+	if !install && !uninstall {
+		_, included := updateSSHConfig(data, include, false)
+		fmt.Printf("Fragment\t: %s\n", fragment)
+		if included {
+			fmt.Printf("Included\t: yes, in %s\n", configPath)
+		} else {
+			fmt.Println("Included\t: no, run ccloudvm ssh-config --install")
+		}
+		return nil
+	}
+
+	updated, changed := updateSSHConfig(data, include, install)
+	if !changed {
+		return nil
+	}
+
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		return errors.Wrapf(err, "Unable to create %s", sshDir)
+	}
+	tmp := configPath + ".ccloudvm"
+	if err := ioutil.WriteFile(tmp, updated, mode); err != nil {
+		return errors.Wrapf(err, "Unable to write %s", configPath)
+	}
+	if err := os.Rename(tmp, configPath); err != nil {
+		_ = os.Remove(tmp)
+		return errors.Wrapf(err, "Unable to write %s", configPath)
+	}
+
+	if install {
+		fmt.Println("Instances can now be reached with ssh <instance>")
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var apiStdio bool
+
+var apiCmd = &cobra.Command{
+	Use:   "api --stdio",
+	Short: "Serves the daemon API as newline delimited JSON-RPC on stdin and stdout",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !apiStdio {
+			return errors.New("Only --stdio is supported")
+		}
+
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.ServeStdio(ctx, os.Stdin, os.Stdout)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(apiCmd)
+	apiCmd.Flags().BoolVar(&apiStdio, "stdio", false, "Read requests from stdin and write responses to stdout")
+}
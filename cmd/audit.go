@@ -0,0 +1,46 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/spf13/cobra"
+)
+
+var auditSince string
+
+var auditCmd = &cobra.Command{
+	Use:   "audit [instance-name]",
+	Short: "Lists who created, stopped and deleted instances and when",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		instanceName := ""
+		if len(args) == 1 {
+			instanceName = args[0]
+		}
+		return client.AuditLog(ctx, instanceName, auditSince)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+
+	auditCmd.Flags().StringVar(&auditSince, "since", "30d", "Period to list, e.g., 30d or 12h")
+}
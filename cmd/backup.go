@@ -0,0 +1,163 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var backupDir string
+var backupInterval string
+var backupKeep int
+var backupOff bool
+
+// absBackupDir returns the absolute path of the directory specified with
+// --to or --from, or an empty string if none was specified.
+func absBackupDir() (string, error) {
+	if backupDir == "" {
+		return "", nil
+	}
+	return filepath.Abs(backupDir)
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup [instance]",
+	Short: "Backs up the disk of a VM",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		var instanceName string
+		if len(args) > 0 {
+			instanceName = args[0]
+		}
+
+		dir, err := absBackupDir()
+		if err != nil {
+			return err
+		}
+
+		return client.Backup(ctx, &types.BackupArgs{
+			Name: instanceName,
+			Dir:  dir,
+		})
+	},
+}
+
+var backupListCmd = &cobra.Command{
+	Use:   "list [instance]",
+	Short: "Lists the backups of a VM",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		var instanceName string
+		if len(args) > 0 {
+			instanceName = args[0]
+		}
+
+		dir, err := absBackupDir()
+		if err != nil {
+			return err
+		}
+
+		return client.ListBackups(ctx, &types.BackupListArgs{
+			Name: instanceName,
+			Dir:  dir,
+		})
+	},
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore [instance] <backup>",
+	Short: "Replaces the disk of a stopped VM with one of its backups",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		var instanceName string
+		if len(args) > 1 {
+			instanceName = args[0]
+		}
+
+		dir, err := absBackupDir()
+		if err != nil {
+			return err
+		}
+
+		return client.RestoreBackup(ctx, &types.RestoreArgs{
+			Name: instanceName,
+			ID:   args[len(args)-1],
+			Dir:  dir,
+		})
+	},
+}
+
+var backupScheduleCmd = &cobra.Command{
+	Use:   "schedule [instance]",
+	Short: "Schedules automatic backups of a VM",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		var instanceName string
+		if len(args) > 0 {
+			instanceName = args[0]
+		}
+
+		if !backupOff && backupInterval == "" {
+			return errors.New("The interval of the backups must be specified with --every")
+		}
+
+		dir, err := absBackupDir()
+		if err != nil {
+			return err
+		}
+
+		return client.SetBackupPolicy(ctx, &types.BackupPolicy{
+			Name:     instanceName,
+			Interval: backupInterval,
+			Keep:     backupKeep,
+			Dir:      dir,
+			Off:      backupOff,
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	backupCmd.AddCommand(backupListCmd)
+	backupCmd.AddCommand(backupRestoreCmd)
+	backupCmd.AddCommand(backupScheduleCmd)
+
+	backupCmd.Flags().StringVar(&backupDir, "to", "", "Directory in which the backup is stored")
+	backupListCmd.Flags().StringVar(&backupDir, "from", "", "Directory in which the backups are stored")
+	backupRestoreCmd.Flags().StringVar(&backupDir, "from", "", "Directory in which the backup is stored")
+	backupScheduleCmd.Flags().StringVar(&backupDir, "to", "", "Directory in which the backups are stored")
+	backupScheduleCmd.Flags().StringVar(&backupInterval, "every", "", "Interval between backups: hourly, daily, weekly or a duration, e.g., 12h")
+	backupScheduleCmd.Flags().IntVar(&backupKeep, "keep", 0, "Number of scheduled backups to keep.  Defaults to 7")
+	backupScheduleCmd.Flags().BoolVar(&backupOff, "off", false, "Stop backing up the VM automatically")
+}
@@ -0,0 +1,28 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"strings"
+)
+
+// isBatch indicates whether the instances named by args, and the --all
+// flag, select more than one instance, in which case the command is sent
+// to the daemon as a single batch request.
+func isBatch(args []string, all bool) bool {
+	return all || len(args) > 1 || (len(args) == 1 && strings.ContainsAny(args[0], "*?["))
+}
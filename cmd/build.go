@@ -0,0 +1,74 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/spf13/cobra"
+)
+
+var buildTemplate string
+var buildOutput string
+var buildParams workloadParams
+var buildDebug bool
+var buildPackageUpgrade bool
+
+var buildCmd = &cobra.Command{
+	Use:   "build <workload>",
+	Short: "Provisions a workload once and seals the result as a template from which VMs are quickly created",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		template := buildTemplate
+		if template == "" {
+			template = strings.TrimSuffix(filepath.Base(args[0]), ".yaml")
+		}
+
+		var output string
+		if buildOutput != "" {
+			var err error
+			output, err = filepath.Abs(buildOutput)
+			if err != nil {
+				return err
+			}
+		}
+
+		return client.Build(ctx, &types.CreateArgs{
+			WorkloadName: args[0],
+			Debug:        buildDebug,
+			Update:       buildPackageUpgrade,
+			Params:       buildParams,
+			NoProfile:    true,
+		}, template, output)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(buildCmd)
+
+	buildCmd.Flags().StringVar(&buildTemplate, "name", "", "Name of the template.  Defaults to the name of the workload")
+	buildCmd.Flags().StringVarP(&buildOutput, "output", "o", "", "Path to which the qcow2 image of the template is copied, for use outside ccloudvm")
+	buildCmd.Flags().Var(&buildParams, "param", "Value of a workload parameter, e.g., go_version=1.10.  May be repeated")
+	buildCmd.Flags().BoolVar(&buildDebug, "debug", false, "Enable debugging mode")
+	buildCmd.Flags().BoolVar(&buildPackageUpgrade, "package-upgrade", false, "Hint as to whether to upgrade packages before the template is sealed")
+}
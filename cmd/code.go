@@ -0,0 +1,49 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/spf13/cobra"
+)
+
+var codePrint bool
+var codeEditor string
+
+var codeCmd = &cobra.Command{
+	Use:   "code instance [path]",
+	Short: "Opens a directory of an instance in VS Code using Remote-SSH",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		var dir string
+		if len(args) > 1 {
+			dir = args[1]
+		}
+
+		return client.Code(ctx, args[0], dir, codeEditor, codePrint)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(codeCmd)
+
+	codeCmd.Flags().BoolVar(&codePrint, "print", false, "Print the remote URI of the directory rather than launching the editor")
+	codeCmd.Flags().StringVar(&codeEditor, "editor", "code", "Editor launched, e.g., code-insiders")
+}
@@ -0,0 +1,50 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/spf13/cobra"
+)
+
+var consoleLines int
+var consoleFollow bool
+var consoleAttach bool
+
+var consoleCmd = &cobra.Command{
+	Use:   "console [instance]",
+	Short: "Prints the serial console log of a VM, or attaches to its console",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		var instanceName string
+		if len(args) > 0 {
+			instanceName = args[0]
+		}
+
+		return client.Console(ctx, instanceName, consoleLines, consoleFollow, consoleAttach)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(consoleCmd)
+
+	consoleCmd.Flags().IntVarP(&consoleLines, "lines", "n", 0, "Number of lines of the log to print, 0 for the entire log")
+	consoleCmd.Flags().BoolVarP(&consoleFollow, "follow", "f", false, "Print the console's output as it is produced")
+	consoleCmd.Flags().BoolVar(&consoleAttach, "attach", false, "Follow the console and send the lines typed to it")
+}
@@ -25,8 +25,8 @@ var recurse bool
 var host bool
 
 var copyCmd = &cobra.Command{
-	Use:   "copy",
-	Short: "Copy files between the host and the guest using scp",
+	Use:   "copy [instance] <src> <dest> | <src> <instance>:<dest> | <instance>:<src> <dest>",
+	Short: "Copy files between the host and the guest using scp or sftp",
 	Args:  cobra.MinimumNArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx, cancelFunc := getSignalContext()
@@ -18,7 +18,10 @@ package cmd
 
 import (
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"strings"
 
 	"github.com/intel/ccloudvm/client"
 	"github.com/intel/ccloudvm/types"
@@ -49,24 +52,141 @@ func (ip *ipAddr) Set(value string) error {
 	return nil
 }
 
+type workloadParams map[string]string
+
+func (p *workloadParams) String() string {
+	return fmt.Sprint(map[string]string(*p))
+}
+
+func (p *workloadParams) Type() string {
+	return "key=value"
+}
+
+func (p *workloadParams) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 {
+		return errors.Errorf("--param parameter should be of format key=value")
+	}
+	if *p == nil {
+		*p = make(workloadParams)
+	}
+	(*p)[value[:i]] = value[i+1:]
+	return nil
+}
+
+type mirrorFlags map[string]string
+
+func (m *mirrorFlags) String() string {
+	return fmt.Sprint(map[string]string(*m))
+}
+
+func (m *mirrorFlags) Type() string {
+	return "prefix=url"
+}
+
+func (m *mirrorFlags) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 || i == len(value)-1 {
+		return errors.Errorf("--mirror parameter should be of format prefix=url")
+	}
+	if *m == nil {
+		*m = make(mirrorFlags)
+	}
+	(*m)[value[:i]] = value[i+1:]
+	return nil
+}
+
+type labelFlags map[string]string
+
+func (l *labelFlags) String() string {
+	return types.FormatLabels(*l)
+}
+
+func (l *labelFlags) Type() string {
+	return "key=value"
+}
+
+func (l *labelFlags) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 {
+		return errors.Errorf("--label parameter should be of format key=value")
+	}
+	if *l == nil {
+		*l = make(labelFlags)
+	}
+	(*l)[value[:i]] = value[i+1:]
+	return nil
+}
+
 var instanceName string
+var createCount int
+var createNameTemplate string
 var createSpec types.VMSpec
 var createMOptsSpec multiOptions
 var createDebug bool
 var createPackageUpgrade bool
 var createHostIP ipAddr
+var createSSHCA bool
+var createSSHAgent bool
+var createParams workloadParams
+var createMirrors mirrorFlags
+var createEncrypt bool
+var createWaitReady bool
+var createOffline bool
+var createLabels labelFlags
+var createUserData string
+var createUserDataMode string
+var createNoProfile bool
+var createTemplate string
 
 var createCmd = &cobra.Command{
-	Use:   "create",
+	Use:   "create [workload]",
 	Short: "Creates a new VM",
-	Args:  cobra.ExactArgs(1),
+	Args:  cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx, cancelFunc := getSignalContext()
 		defer cancelFunc()
 
+		var workloadName string
+		if len(args) > 0 {
+			workloadName = args[0]
+		}
+		if (workloadName == "") == (createTemplate == "") {
+			return errors.New("Either a workload or a template, with --from-template, must be specified")
+		}
+
 		mergeVMOptions(&createSpec, &createMOptsSpec)
 		createSpec.HostIP = net.IP(createHostIP)
-		return client.Create(ctx, instanceName, args[0], createDebug, createPackageUpgrade, &createSpec)
+
+		var userData []byte
+		if createUserData != "" {
+			var err error
+			userData, err = ioutil.ReadFile(createUserData)
+			if err != nil {
+				return errors.Wrap(err, "Unable to read user data")
+			}
+		}
+
+		return client.Create(ctx, &types.CreateArgs{
+			Name:         instanceName,
+			Count:        createCount,
+			NameTemplate: createNameTemplate,
+			WorkloadName: workloadName,
+			Template:     createTemplate,
+			Debug:        createDebug,
+			Update:       createPackageUpgrade,
+			CustomSpec:   createSpec,
+			SSHCA:        createSSHCA,
+			SSHAgent:     createSSHAgent,
+			Params:       createParams,
+			Mirrors:      createMirrors,
+			Encrypt:      createEncrypt,
+			Offline:      createOffline,
+			Labels:       createLabels,
+			UserData:     userData,
+			UserDataMode: createUserDataMode,
+			NoProfile:    createNoProfile,
+		}, createWaitReady)
 	},
 }
 
@@ -76,10 +196,33 @@ func init() {
 	var flags flag.FlagSet
 	vmFlags(&flags, &createSpec, &createMOptsSpec)
 	flags.IntVar(&createSpec.DiskGiB, "disk", createSpec.DiskGiB, "Gibibytes of disk space allocated to Rootfs")
+	flags.BoolVar(&createSpec.Profiling, "profiling", createSpec.Profiling, "Enable the guest PMU and install perf and bpftrace")
+	flags.BoolVar(&createSpec.TPM, "tpm", createSpec.TPM, "Give the VM an emulated TPM 2.0 device backed by swtpm")
+	flags.BoolVar(&createSpec.Vsock, "vsock", createSpec.Vsock, "Give the VM a vhost-vsock device")
+	flags.BoolVar(&createSpec.Docker, "docker", createSpec.Docker, "Forward the Docker socket of the guest to the host while the VM runs")
+	flags.StringVar(&createSpec.Firmware, "firmware", createSpec.Firmware, "Firmware with which the VM is booted, bios, uefi or uefi-secureboot")
+	flags.StringVar(&createSpec.Hypervisor, "hypervisor", createSpec.Hypervisor, "Hypervisor used to run the VM, qemu, firecracker or cloud-hypervisor")
 
 	createCmd.Flags().AddGoFlagSet(&flags)
 	createCmd.Flags().StringVar(&instanceName, "name", "", "Name of new instance")
+	createCmd.Flags().IntVar(&createCount, "count", 1, "Number of instances to create in parallel")
+	createCmd.Flags().StringVar(&createNameTemplate, "name-template", "", "Template, e.g., node-%d, from which the names of the new instances are generated")
 	createCmd.Flags().BoolVar(&createDebug, "debug", false, "Enable debugging mode")
 	createCmd.Flags().BoolVar(&createPackageUpgrade, "package-upgrade", false, "Hint as to whether to upgrade packages on creation")
 	createCmd.Flags().Var(&createHostIP, "hostip", "Host IP address on which instance services will be exposed")
+	createCmd.Flags().StringVar(&createSpec.Network, "network", "", "Network to which the instance is connected")
+	createCmd.Flags().StringVar(&createSpec.MACAddress, "mac", "", "MAC address of the instance's NIC, e.g., 52:54:00:ab:cd:ef")
+	createCmd.Flags().StringVar(&createSpec.Hostname, "hostname", "", "Hostname of the guest.  Defaults to the name of the instance")
+	createCmd.Flags().BoolVar(&createSSHCA, "ssh-ca", false, "Authenticate SSH connections to the instance with short-lived certificates signed by ccloudvm")
+	createCmd.Flags().BoolVar(&createSSHAgent, "ssh-agent", false, "Authorize the keys of your SSH agent and forward the agent when connecting to the instance")
+	createCmd.Flags().StringVar(&createUserData, "user-data", "", "cloud-init user data, a cloud-config document, a script or a multi-part MIME document, applied to the workload's cloud-init document")
+	createCmd.Flags().StringVar(&createUserDataMode, "user-data-mode", types.UserDataMerge, "Whether the cloud-config documents of the user data are merged into, or replace, the workload's cloud-init document, merge or replace")
+	createCmd.Flags().StringVar(&createTemplate, "from-template", "", "Template, built with ccloudvm build, from which the instance is created instead of a workload")
+	createCmd.Flags().BoolVar(&createNoProfile, "no-profile", false, "Do not apply the customizations of ~/.ccloudvm/profile.yaml to the instance")
+	createCmd.Flags().Var(&createLabels, "label", "Label attached to the instance, e.g., project=kata.  May be repeated")
+	createCmd.Flags().Var(&createParams, "param", "Value of a workload parameter, e.g., go_version=1.10.  May be repeated")
+	createCmd.Flags().BoolVar(&createWaitReady, "wait-ready", false, "Wait for the readiness checks of the workload to pass, rather than just for the instance to be installed")
+	createCmd.Flags().BoolVar(&createOffline, "offline", false, "Fail rather than download anything.  Workloads and images must have been prefetched")
+	createCmd.Flags().BoolVar(&createEncrypt, "encrypt", false, "Encrypt the root disk of the VM with LUKS.  The passphrase is prompted for")
+	createCmd.Flags().Var(&createMirrors, "mirror", "Mirror from which images whose URLs start with a prefix are downloaded, e.g., https://cloud-images.ubuntu.com/=https://artifactory.example.com/ubuntu/.  May be repeated")
 }
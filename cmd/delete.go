@@ -18,16 +18,27 @@ package cmd
 
 import (
 	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
 	"github.com/spf13/cobra"
 )
 
+var deleteAll bool
+
 var deleteCmd = &cobra.Command{
-	Use:   "delete",
-	Short: "Stops and deletes a VM",
+	Use:   "delete [instance|pattern...]",
+	Short: "Stops and deletes VMs",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx, cancelFunc := getSignalContext()
 		defer cancelFunc()
 
+		if isBatch(args, deleteAll) {
+			return client.Batch(ctx, &types.BatchArgs{
+				Action: types.BatchDelete,
+				Names:  args,
+				All:    deleteAll,
+			})
+		}
+
 		var instanceName string
 		if len(args) > 0 {
 			instanceName = args[0]
@@ -39,4 +50,6 @@ var deleteCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(deleteCmd)
+
+	deleteCmd.Flags().BoolVar(&deleteAll, "all", false, "Delete all the instances")
 }
@@ -0,0 +1,75 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/spf13/cobra"
+)
+
+var diskSize string
+var diskVolume string
+var diskDelete bool
+
+var diskCmd = &cobra.Command{
+	Use:   "disk",
+	Short: "Manages the data disks attached to instances",
+}
+
+var diskAttachCmd = &cobra.Command{
+	Use:   "attach <instance> <disk>",
+	Short: "Attaches a data disk to an instance, creating its volume if needed",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		d := types.Disk{Name: args[1], Volume: diskVolume}
+		if diskSize != "" {
+			size, err := types.ParseDiskSize(diskSize)
+			if err != nil {
+				return err
+			}
+			d.SizeGiB = size
+		}
+
+		return client.AttachDisk(ctx, args[0], &d)
+	},
+}
+
+var diskDetachCmd = &cobra.Command{
+	Use:   "detach <instance> <disk>",
+	Short: "Detaches a data disk from an instance",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.DetachDisk(ctx, args[0], args[1], diskDelete)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diskCmd)
+	diskCmd.AddCommand(diskAttachCmd)
+	diskCmd.AddCommand(diskDetachCmd)
+
+	diskAttachCmd.Flags().StringVar(&diskSize, "size", "", "Size of the disk's volume, e.g., 50G.  Required unless a volume was kept when the disk was detached or an existing named volume is used")
+	diskAttachCmd.Flags().StringVar(&diskVolume, "volume", "", "Back the disk with the named volume, which is created if it does not exist and --size is given")
+	diskDetachCmd.Flags().BoolVar(&diskDelete, "delete", false, "Delete the disk's volume rather than keeping it so that it can be attached again")
+}
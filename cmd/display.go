@@ -0,0 +1,47 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/spf13/cobra"
+)
+
+var displayPrint bool
+
+var displayCmd = &cobra.Command{
+	Use:   "display [instance]",
+	Short: "Launches a viewer showing the graphical console of a VM",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		var instanceName string
+		if len(args) > 0 {
+			instanceName = args[0]
+		}
+
+		return client.Display(ctx, instanceName, displayPrint)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(displayCmd)
+
+	displayCmd.Flags().BoolVar(&displayPrint, "print", false, "Print the URL of the console rather than launching a viewer")
+}
@@ -0,0 +1,53 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/spf13/cobra"
+)
+
+var dockerEnvUnset bool
+var dockerEnvContext bool
+
+var dockerEnvCmd = &cobra.Command{
+	Use:   "docker-env instance",
+	Short: "Points the docker client at the Docker daemon of an instance",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if dockerEnvUnset {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		var name string
+		if len(args) > 0 {
+			name = args[0]
+		}
+		return client.DockerEnv(ctx, name, dockerEnvUnset, dockerEnvContext)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dockerEnvCmd)
+
+	dockerEnvCmd.Flags().BoolVar(&dockerEnvUnset, "unset", false, "Print the commands that point the docker client back at the local daemon")
+	dockerEnvCmd.Flags().BoolVar(&dockerEnvContext, "context", false, "Create or update a docker context for the instance rather than printing environment variables")
+}
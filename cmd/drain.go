@@ -0,0 +1,45 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/spf13/cobra"
+)
+
+var drainTimeout time.Duration
+
+var drainCmd = &cobra.Command{
+	Use:   "drain",
+	Short: "Stops the ccloudvm service once the commands in progress have completed",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.Drain(ctx, drainTimeout)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(drainCmd)
+
+	drainCmd.Flags().DurationVar(&drainTimeout, "timeout", 0,
+		"Cancel the commands still in progress after this period, e.g., 10m.  0 waits indefinitely")
+}
@@ -0,0 +1,119 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var exportOutput string
+var importName string
+var importVagrantName string
+var importVagrantInherits string
+
+var exportCmd = &cobra.Command{
+	Use:   "export [instance]",
+	Short: "Exports a stopped VM as an archive from which new VMs can be created",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		var instanceName string
+		if len(args) > 0 {
+			instanceName = args[0]
+		}
+
+		output := exportOutput
+		if output == "" {
+			if instanceName == "" {
+				return errors.New("The path of the archive must be specified with -o")
+			}
+			output = instanceName + ".tar"
+		}
+		path, err := filepath.Abs(output)
+		if err != nil {
+			return err
+		}
+
+		return client.Export(ctx, &types.ExportArgs{
+			Name: instanceName,
+			Path: path,
+		})
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <archive>",
+	Short: "Creates a workload from an archive created by export",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		path, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+
+		return client.Import(ctx, &types.ImportArgs{
+			Path: path,
+			Name: importName,
+		})
+	},
+}
+
+var importVagrantCmd = &cobra.Command{
+	Use:   "vagrant [Vagrantfile]",
+	Short: "Creates a workload from a Vagrantfile, ./Vagrantfile by default",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		vagrantfile := "Vagrantfile"
+		if len(args) > 0 {
+			vagrantfile = args[0]
+		}
+		path, err := filepath.Abs(vagrantfile)
+		if err != nil {
+			return err
+		}
+
+		return client.ImportVagrant(ctx, &types.ImportVagrantArgs{
+			Path:     path,
+			Name:     importVagrantName,
+			Inherits: importVagrantInherits,
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importVagrantCmd)
+
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Path of the archive.  Defaults to <instance>.tar")
+	importCmd.Flags().StringVar(&importName, "name", "", "Name of the workload.  Defaults to the name of the archive without its extension")
+	importVagrantCmd.Flags().StringVar(&importVagrantName, "name", "", "Name of the workload.  Defaults to the name of the directory of the Vagrantfile")
+	importVagrantCmd.Flags().StringVar(&importVagrantInherits, "inherits", "", "Workload from which the new workload inherits.  Defaults to the workload of the Vagrant box")
+}
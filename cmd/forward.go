@@ -0,0 +1,95 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/spf13/cobra"
+)
+
+func parseForward(value string) (types.PortMapping, error) {
+	hostAddr, value, err := splitHostAddr(value)
+	if err != nil {
+		return types.PortMapping{}, err
+	}
+	components := strings.Split(value, ":")
+	if len(components) != 2 {
+		return types.PortMapping{}, fmt.Errorf("port mapping should be of format [[address]:]host:guest")
+	}
+	host, err := strconv.Atoi(components[0])
+	if err != nil {
+		return types.PortMapping{}, fmt.Errorf("host port must be a number")
+	}
+	guest, err := strconv.Atoi(components[1])
+	if err != nil {
+		return types.PortMapping{}, fmt.Errorf("guest port must be a number")
+	}
+	return types.PortMapping{
+		Host:     host,
+		Guest:    guest,
+		HostAddr: hostAddr,
+	}, nil
+}
+
+func runForward(args []string, add bool) error {
+	ctx, cancelFunc := getSignalContext()
+	defer cancelFunc()
+
+	p, err := parseForward(args[1])
+	if err != nil {
+		return err
+	}
+
+	return client.Forward(ctx, &types.ForwardArgs{
+		Name:    args[0],
+		Mapping: p,
+	}, add)
+}
+
+var forwardCmd = &cobra.Command{
+	Use:   "forward",
+	Short: "Adds or removes port mappings of an instance",
+}
+
+var forwardAddCmd = &cobra.Command{
+	Use:   "add <instance> [[address]:]host:guest",
+	Short: "Maps a port on the instance's host IP address to a guest port",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runForward(args, true)
+	},
+}
+
+var forwardDelCmd = &cobra.Command{
+	Use:   "del <instance> [[address]:]host:guest",
+	Short: "Removes a port mapping",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runForward(args, false)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(forwardCmd)
+	forwardCmd.AddCommand(forwardAddCmd)
+	forwardCmd.AddCommand(forwardDelCmd)
+}
@@ -0,0 +1,97 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/spf13/cobra"
+)
+
+var groupName string
+var groupDebug bool
+var groupPackageUpgrade bool
+var groupSSHCA bool
+var groupSSHAgent bool
+var groupParams workloadParams
+var groupOffline bool
+
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Manages groups of VMs created from group workloads",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.Groups(ctx)
+	},
+}
+
+var groupCreateCmd = &cobra.Command{
+	Use:   "create <group-workload>",
+	Short: "Creates the VMs of a group workload",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.CreateGroup(ctx, &types.CreateArgs{
+			Name:         groupName,
+			WorkloadName: args[0],
+			Debug:        groupDebug,
+			Update:       groupPackageUpgrade,
+			SSHCA:        groupSSHCA,
+			SSHAgent:     groupSSHAgent,
+			Params:       groupParams,
+			Offline:      groupOffline,
+		})
+	},
+}
+
+func groupActionCmd(use, short string, fn func(ctx context.Context, group string) error) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " <group>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancelFunc := getSignalContext()
+			defer cancelFunc()
+
+			return fn(ctx, args[0])
+		},
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(groupCmd)
+
+	groupCmd.AddCommand(groupCreateCmd)
+	groupCmd.AddCommand(groupActionCmd("start", "Boots all the stopped VMs of a group", client.StartGroup))
+	groupCmd.AddCommand(groupActionCmd("stop", "Cleanly powers down all the VMs of a group", client.StopGroup))
+	groupCmd.AddCommand(groupActionCmd("quit", "Forceably quits all the running VMs of a group", client.QuitGroup))
+	groupCmd.AddCommand(groupActionCmd("delete", "Stops and deletes all the VMs of a group", client.DeleteGroup))
+
+	groupCreateCmd.Flags().StringVar(&groupName, "name", "", "Name of the new group")
+	groupCreateCmd.Flags().BoolVar(&groupDebug, "debug", false, "Enable debugging mode")
+	groupCreateCmd.Flags().BoolVar(&groupPackageUpgrade, "package-upgrade", false, "Hint as to whether to upgrade packages on creation")
+	groupCreateCmd.Flags().BoolVar(&groupSSHCA, "ssh-ca", false, "Authenticate SSH connections to the instances with short-lived certificates signed by ccloudvm")
+	groupCreateCmd.Flags().BoolVar(&groupSSHAgent, "ssh-agent", false, "Authorize the keys of your SSH agent and forward the agent when connecting to the instances")
+	groupCreateCmd.Flags().BoolVar(&groupOffline, "offline", false, "Fail rather than download anything.  The group workload must have been prefetched")
+	groupCreateCmd.Flags().Var(&groupParams, "param", "Value of a parameter of the workloads of the group, e.g., go_version=1.10.  May be repeated")
+}
@@ -21,6 +21,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var instancesSelector string
+
 var instanceCmd = &cobra.Command{
 	Use:   "instances",
 	Short: "Lists the current instances",
@@ -28,10 +30,11 @@ var instanceCmd = &cobra.Command{
 		ctx, cancelFunc := getSignalContext()
 		defer cancelFunc()
 
-		return client.Instances(ctx)
+		return client.Instances(ctx, instancesSelector)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(instanceCmd)
+	instanceCmd.Flags().StringVarP(&instancesSelector, "selector", "l", "", "Only list the instances whose labels match the selector, e.g., project=kata,tier!=db")
 }
@@ -0,0 +1,44 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/spf13/cobra"
+)
+
+var kubeconfigUse bool
+var kubeconfigPrint bool
+
+var kubeconfigCmd = &cobra.Command{
+	Use:   "kubeconfig instance",
+	Short: "Merges the kubeconfig of a Kubernetes instance into your kubeconfig",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.Kubeconfig(ctx, args[0], kubeconfigUse, kubeconfigPrint)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(kubeconfigCmd)
+
+	kubeconfigCmd.Flags().BoolVar(&kubeconfigUse, "use", false, "Make the context of the instance the current context")
+	kubeconfigCmd.Flags().BoolVar(&kubeconfigPrint, "print", false, "Print the kubeconfig of the instance rather than merging it")
+}
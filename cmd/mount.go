@@ -0,0 +1,64 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/spf13/cobra"
+)
+
+var mountCmd = &cobra.Command{
+	Use:   "mount <instance> <tag> <path>",
+	Short: "Shares a host directory with an instance",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		path, err := filepath.Abs(args[2])
+		if err != nil {
+			return err
+		}
+
+		return client.Mount(ctx, args[0], &types.Mount{
+			Tag:           args[1],
+			SecurityModel: "passthrough",
+			Path:          path,
+			Type:          types.MountTypeVirtiofs,
+		})
+	},
+}
+
+var unmountCmd = &cobra.Command{
+	Use:   "unmount <instance> <tag>",
+	Short: "Stops sharing a host directory with an instance",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.Unmount(ctx, args[0], args[1])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mountCmd)
+	rootCmd.AddCommand(unmountCmd)
+}
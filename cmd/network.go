@@ -0,0 +1,80 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/spf13/cobra"
+)
+
+var networkSpec types.NetworkSpec
+
+var networkCmd = &cobra.Command{
+	Use:   "network",
+	Short: "Manages the networks to which VMs are connected",
+}
+
+var networkCreateCmd = &cobra.Command{
+	Use:   "create <network>",
+	Short: "Creates a named network",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		networkSpec.Name = args[0]
+		return client.CreateNetwork(ctx, &networkSpec)
+	},
+}
+
+var networkDeleteCmd = &cobra.Command{
+	Use:   "delete <network>",
+	Short: "Deletes a named network that is not used by any VM",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.DeleteNetwork(ctx, args[0])
+	},
+}
+
+var networkListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the networks and the VMs connected to them",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.ListNetworks(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(networkCmd)
+	networkCmd.AddCommand(networkCreateCmd)
+	networkCmd.AddCommand(networkDeleteCmd)
+	networkCmd.AddCommand(networkListCmd)
+
+	networkCreateCmd.Flags().StringVar(&networkSpec.Subnet, "subnet", "", "IPv4 subnet seen by the VMs, e.g., 192.168.50.0/24.  Defaults to 10.0.2.0/24")
+	networkCreateCmd.Flags().StringVar(&networkSpec.IPv6Subnet, "ipv6-subnet", "", "IPv6 /64 prefix advertised to the VMs, e.g., fd00:50::/64.  Defaults to fec0::/64")
+	networkCreateCmd.Flags().StringVar(&networkSpec.Mode, "mode", types.NetworkModeNAT, "nat to allow VMs to reach external networks or restricted to only allow port forwards")
+	networkCreateCmd.Flags().StringSliceVar(&networkSpec.DNSSearch, "dns-search", nil, "DNS search domains of the VMs, instead of the host's")
+	networkCreateCmd.Flags().BoolVar(&networkSpec.Isolated, "isolated", false, "Only allow the ports of the VMs to be exposed on loopback addresses")
+}
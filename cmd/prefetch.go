@@ -0,0 +1,49 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/spf13/cobra"
+)
+
+var prefetchParams workloadParams
+var prefetchMirrors mirrorFlags
+
+var prefetchCmd = &cobra.Command{
+	Use:   "prefetch <workload>",
+	Short: "Downloads a workload and its images so that it can be created offline",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.Prefetch(ctx, &types.CreateArgs{
+			WorkloadName: args[0],
+			Params:       prefetchParams,
+			Mirrors:      prefetchMirrors,
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(prefetchCmd)
+
+	prefetchCmd.Flags().Var(&prefetchParams, "param", "Value of a workload parameter, e.g., go_version=1.10.  May be repeated")
+	prefetchCmd.Flags().Var(&prefetchMirrors, "mirror", "Mirror from which images whose URLs start with a prefix are downloaded.  May be repeated")
+}
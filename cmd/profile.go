@@ -0,0 +1,56 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/spf13/cobra"
+)
+
+var profileDuration time.Duration
+var profileOutput string
+var profileFlamegraph bool
+
+var profileCmd = &cobra.Command{
+	Use:   "profile <instance>",
+	Short: "Collect a perf profile in the guest and copy it to the host",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		output := profileOutput
+		if output == "" {
+			output = args[0] + "-perf.txt"
+			if profileFlamegraph {
+				output = args[0] + "-perf.svg"
+			}
+		}
+
+		return client.Profile(ctx, args[0], profileDuration, output, profileFlamegraph)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+
+	profileCmd.Flags().DurationVar(&profileDuration, "duration", 30*time.Second, "Length of time for which samples are collected")
+	profileCmd.Flags().StringVarP(&profileOutput, "output", "o", "", "Host file to which the profile is written")
+	profileCmd.Flags().BoolVar(&profileFlamegraph, "flamegraph", false, "Render the profile as an SVG flame graph using flamegraph.pl")
+}
@@ -0,0 +1,46 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/spf13/cobra"
+)
+
+var proxyArgs types.ProxyArgs
+
+var proxyCmd = &cobra.Command{
+	Use:   "proxy <instance>",
+	Short: "Tunnels traffic through a VM with a SOCKS or HTTP proxy until interrupted",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		proxyArgs.Name = args[0]
+		return client.Proxy(ctx, &proxyArgs)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(proxyCmd)
+
+	proxyCmd.Flags().IntVar(&proxyArgs.SOCKSPort, "socks", 1080, "Port of the SOCKS proxy.  0 disables it")
+	proxyCmd.Flags().IntVar(&proxyArgs.HTTPPort, "http", 0, "Port of the HTTP proxy")
+	proxyCmd.Flags().StringVar(&proxyArgs.Address, "address", "", "Address on which the proxies listen.  Defaults to 127.0.0.1")
+}
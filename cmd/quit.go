@@ -18,16 +18,27 @@ package cmd
 
 import (
 	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
 	"github.com/spf13/cobra"
 )
 
+var quitAll bool
+
 var quitCmd = &cobra.Command{
-	Use:   "quit",
-	Short: "Forceably quits a running VM",
+	Use:   "quit [instance|pattern...]",
+	Short: "Forceably quits running VMs",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx, cancelFunc := getSignalContext()
 		defer cancelFunc()
 
+		if isBatch(args, quitAll) {
+			return client.Batch(ctx, &types.BatchArgs{
+				Action: types.BatchQuit,
+				Names:  args,
+				All:    quitAll,
+			})
+		}
+
 		var instanceName string
 		if len(args) > 0 {
 			instanceName = args[0]
@@ -39,4 +50,6 @@ var quitCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(quitCmd)
+
+	quitCmd.Flags().BoolVar(&quitAll, "all", false, "Quit all the instances")
 }
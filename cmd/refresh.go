@@ -0,0 +1,43 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/spf13/cobra"
+)
+
+var refreshMaxStaleness time.Duration
+
+var refreshCmd = &cobra.Command{
+	Use:   "refresh [instance...]",
+	Short: "Probes the status of one or more VMs",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.RefreshStatus(ctx, args, refreshMaxStaleness)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(refreshCmd)
+
+	refreshCmd.Flags().DurationVar(&refreshMaxStaleness, "max-staleness", 0, "Only probe VMs whose cached status is older than this")
+}
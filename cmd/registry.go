@@ -0,0 +1,75 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/spf13/cobra"
+)
+
+var pushPlainHTTP bool
+var pullName string
+var pullPlainHTTP bool
+
+var pushCmd = &cobra.Command{
+	Use:   "push <reference> <archive>",
+	Short: "Pushes an archive created by export to an OCI registry, e.g., ghcr.io/org/devvm:1.2",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		path, err := filepath.Abs(args[1])
+		if err != nil {
+			return err
+		}
+
+		return client.Push(ctx, &types.PushArgs{
+			Reference: args[0],
+			Path:      path,
+			PlainHTTP: pushPlainHTTP,
+		})
+	},
+}
+
+var pullCmd = &cobra.Command{
+	Use:   "pull <reference>",
+	Short: "Creates a workload from an exported VM stored in an OCI registry",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.Pull(ctx, &types.PullArgs{
+			Reference: args[0],
+			Name:      pullName,
+			PlainHTTP: pullPlainHTTP,
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pushCmd)
+	rootCmd.AddCommand(pullCmd)
+
+	pushCmd.Flags().BoolVar(&pushPlainHTTP, "plain-http", false, "Use HTTP rather than HTTPS to connect to the registry")
+	pullCmd.Flags().StringVar(&pullName, "name", "", "Name of the workload.  Defaults to the last component of the repository")
+	pullCmd.Flags().BoolVar(&pullPlainHTTP, "plain-http", false, "Use HTTP rather than HTTPS to connect to the registry")
+}
@@ -0,0 +1,46 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/spf13/cobra"
+)
+
+var resizeArgs types.ResizeArgs
+
+var resizeCmd = &cobra.Command{
+	Use:   "resize <instance>",
+	Short: "Changes the CPUs, memory or disk assigned to an instance",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		resizeArgs.Name = args[0]
+		return client.Resize(ctx, &resizeArgs)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(resizeCmd)
+
+	resizeCmd.Flags().IntVar(&resizeArgs.CPUs, "cpus", 0, "VCPUs assigned to VM")
+	resizeCmd.Flags().IntVar(&resizeArgs.MemMiB, "mem", 0, "Mebibytes of RAM allocated to VM")
+	resizeCmd.Flags().IntVar(&resizeArgs.DiskGiB, "disk", 0, "Gibibytes of disk space allocated to Rootfs.  Disks can only be grown")
+}
@@ -0,0 +1,63 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"flag"
+	"time"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/spf13/cobra"
+)
+
+var restartSpec types.VMSpec
+var restartMOptsSpec multiOptions
+var restartTimeout time.Duration
+
+var restartCmd = &cobra.Command{
+	Use:   "restart [instance]",
+	Short: "Shuts down a VM and boots it again",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		var instanceName string
+		if len(args) > 0 {
+			instanceName = args[0]
+		}
+
+		mergeVMOptions(&restartSpec, &restartMOptsSpec)
+		return client.Restart(ctx, &types.RestartArgs{
+			Name:    instanceName,
+			VMSpec:  restartSpec,
+			Timeout: restartTimeout,
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restartCmd)
+
+	var flags flag.FlagSet
+	vmFlags(&flags, &restartSpec, &restartMOptsSpec)
+
+	restartCmd.Flags().AddGoFlagSet(&flags)
+	restartCmd.Flags().DurationVar(&restartTimeout, "timeout", 30*time.Second,
+		"Quit the VM if it has not shut down within this period.  0 quits it immediately")
+}
@@ -22,13 +22,23 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/intel/ccloudvm/client"
 	"github.com/spf13/cobra"
 )
 
+var outputFormat string
+
 var rootCmd = &cobra.Command{
 	Use:          "ccloudvm",
 	Short:        "Configurable Cloud VM (ccloudvm) allows the creation and management of VMs from cloud-init files",
 	SilenceUsage: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return client.SetFormat(outputFormat)
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "format", client.FormatText, "Format in which results are printed, text or json")
 }
 
 // Execute is the entry into the cmd package from the main package.
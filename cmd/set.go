@@ -0,0 +1,54 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var setCmd = &cobra.Command{
+	Use:   "set <instance> setting=value...",
+	Short: "Changes the settings of a VM: autostart, autostart_order or autostart_delay",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		settings := make(map[string]string)
+		for _, s := range args[1:] {
+			kv := strings.SplitN(s, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return errors.Errorf("Invalid setting %s, expected setting=value", s)
+			}
+			settings[kv[0]] = kv[1]
+		}
+
+		return client.Set(ctx, &types.SetArgs{
+			Name:     args[0],
+			Settings: settings,
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(setCmd)
+}
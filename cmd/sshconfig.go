@@ -0,0 +1,40 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/spf13/cobra"
+)
+
+var sshConfigInstall bool
+var sshConfigUninstall bool
+
+var sshConfigCmd = &cobra.Command{
+	Use:   "ssh-config",
+	Short: "Manages the inclusion of the instances' ssh configuration in ~/.ssh/config",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return client.SSHConfig(sshConfigInstall, sshConfigUninstall)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sshConfigCmd)
+	sshConfigCmd.Flags().BoolVar(&sshConfigInstall, "install", false, "Include the instances' ssh configuration at the top of ~/.ssh/config")
+	sshConfigCmd.Flags().BoolVar(&sshConfigUninstall, "uninstall", false, "Remove the instances' ssh configuration from ~/.ssh/config")
+}
@@ -26,21 +26,33 @@ import (
 
 var startSpec types.VMSpec
 var startMOptsSpec multiOptions
+var startForce bool
+var startAll bool
 
 var startCmd = &cobra.Command{
-	Use:   "start",
-	Short: "Boots a stopped VM",
+	Use:   "start [instance|pattern...]",
+	Short: "Boots stopped VMs",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx, cancelFunc := getSignalContext()
 		defer cancelFunc()
 
+		mergeVMOptions(&startSpec, &startMOptsSpec)
+		if isBatch(args, startAll) {
+			return client.Batch(ctx, &types.BatchArgs{
+				Action: types.BatchStart,
+				Names:  args,
+				All:    startAll,
+				VMSpec: startSpec,
+				Force:  startForce,
+			})
+		}
+
 		var instanceName string
 		if len(args) > 0 {
 			instanceName = args[0]
 		}
 
-		mergeVMOptions(&startSpec, &startMOptsSpec)
-		return client.Start(ctx, instanceName, &startSpec)
+		return client.Start(ctx, instanceName, &startSpec, startForce)
 	},
 }
 
@@ -51,4 +63,6 @@ func init() {
 	vmFlags(&flags, &startSpec, &startMOptsSpec)
 
 	startCmd.Flags().AddGoFlagSet(&flags)
+	startCmd.Flags().BoolVar(&startForce, "force", false, "Start the VM even if its disk is known to be corrupt")
+	startCmd.Flags().BoolVar(&startAll, "all", false, "Start all the instances")
 }
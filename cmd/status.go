@@ -17,10 +17,15 @@
 package cmd
 
 import (
+	"time"
+
 	"github.com/intel/ccloudvm/client"
 	"github.com/spf13/cobra"
 )
 
+var statusRefresh bool
+var statusMaxStaleness time.Duration
+
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Prints status information about a VM",
@@ -33,10 +38,13 @@ var statusCmd = &cobra.Command{
 			instanceName = args[0]
 		}
 
-		return client.Status(ctx, instanceName)
+		return client.Status(ctx, instanceName, statusRefresh, statusMaxStaleness)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().BoolVar(&statusRefresh, "refresh", false, "Probe the VM rather than reporting its cached status")
+	statusCmd.Flags().DurationVar(&statusMaxStaleness, "max-staleness", 0, "Only probe the VM if its cached status is older than this")
 }
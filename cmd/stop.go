@@ -17,26 +17,61 @@
 package cmd
 
 import (
+	"time"
+
 	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
 	"github.com/spf13/cobra"
 )
 
+// defaultForceStopTimeout is the time --force waits for the VM to shut
+// down before quitting it when --timeout is not given.
+const defaultForceStopTimeout = 30 * time.Second
+
+var stopTimeout time.Duration
+var stopForce bool
+var stopAll bool
+
 var stopCmd = &cobra.Command{
-	Use:   "stop",
-	Short: "Cleanly powers down a running VM",
+	Use:   "stop [instance|pattern...]",
+	Short: "Cleanly powers down running VMs",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx, cancelFunc := getSignalContext()
 		defer cancelFunc()
 
+		if stopForce && !cmd.Flags().Changed("timeout") {
+			stopTimeout = defaultForceStopTimeout
+		}
+
+		if isBatch(args, stopAll) {
+			return client.Batch(ctx, &types.BatchArgs{
+				Action:  types.BatchStop,
+				Names:   args,
+				All:     stopAll,
+				Timeout: stopTimeout,
+				Force:   stopForce,
+			})
+		}
+
 		var instanceName string
 		if len(args) > 0 {
 			instanceName = args[0]
 		}
 
-		return client.Stop(ctx, instanceName)
+		return client.Stop(ctx, &types.StopArgs{
+			Name:    instanceName,
+			Timeout: stopTimeout,
+			Force:   stopForce,
+		})
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(stopCmd)
+
+	stopCmd.Flags().DurationVar(&stopTimeout, "timeout", 0,
+		"Time to wait for the VM to shut down.  0 does not wait")
+	stopCmd.Flags().BoolVar(&stopForce, "force", false,
+		"Quit the VM if it has not shut down within the timeout, 30s unless --timeout is given")
+	stopCmd.Flags().BoolVar(&stopAll, "all", false, "Stop all the instances")
 }
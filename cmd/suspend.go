@@ -0,0 +1,84 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var suspendAll bool
+var resumeAll bool
+var resumeDiscard bool
+
+var suspendCmd = &cobra.Command{
+	Use:   "suspend [instance]",
+	Short: "Saves the state of a VM to disk and quits it",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		var instanceName string
+		if len(args) > 0 {
+			instanceName = args[0]
+		}
+		if suspendAll && instanceName != "" {
+			return errors.New("An instance cannot be named with --all")
+		}
+
+		return client.Suspend(ctx, &types.SuspendArgs{
+			Name: instanceName,
+			All:  suspendAll,
+		})
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume [instance]",
+	Short: "Boots a suspended VM from its saved state",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		var instanceName string
+		if len(args) > 0 {
+			instanceName = args[0]
+		}
+		if resumeAll && instanceName != "" {
+			return errors.New("An instance cannot be named with --all")
+		}
+
+		return client.ResumeFromDisk(ctx, &types.ResumeArgs{
+			Name:    instanceName,
+			All:     resumeAll,
+			Discard: resumeDiscard,
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(suspendCmd)
+	rootCmd.AddCommand(resumeCmd)
+
+	suspendCmd.Flags().BoolVar(&suspendAll, "all", false, "Suspend all the running VMs")
+	resumeCmd.Flags().BoolVar(&resumeAll, "all", false, "Resume all the suspended VMs")
+	resumeCmd.Flags().BoolVar(&resumeDiscard, "discard", false, "Discard the saved state instead, so that the VM boots afresh when next started")
+}
@@ -0,0 +1,46 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/intel/ccloudvm/client"
+	"github.com/spf13/cobra"
+)
+
+var topInterval time.Duration
+var topIterations int
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Displays the CPU, memory and disk usage of all instances",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.Top(ctx, topInterval, topIterations)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(topCmd)
+
+	topCmd.Flags().DurationVar(&topInterval, "interval", 2*time.Second, "Time between refreshes")
+	topCmd.Flags().IntVar(&topIterations, "iterations", 0, "Number of refreshes before exiting.  0 refreshes until interrupted")
+}
@@ -20,6 +20,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -30,11 +31,17 @@ import (
 type mounts []types.Mount
 type ports []types.PortMapping
 type drives []types.Drive
+type syncs []types.Sync
+type usbDevices []types.USBDevice
+type caches []types.Cache
 
 type multiOptions struct {
 	m mounts
 	p ports
 	d drives
+	s syncs
+	u usbDevices
+	c caches
 }
 
 func (m *mounts) String() string {
@@ -43,14 +50,27 @@ func (m *mounts) String() string {
 
 func (m *mounts) Set(value string) error {
 	components := strings.Split(value, ",")
-	if len(components) != 3 {
-		return fmt.Errorf("--mount parameter should be of format tag,security_model,path")
+	if len(components) < 3 || len(components) > 5 {
+		return fmt.Errorf("--mount parameter should be of format tag,security_model,path[,quota_mib[,type]]")
 	}
-	*m = append(*m, types.Mount{
+	var quota int
+	if len(components) >= 4 && components[3] != "" {
+		var err error
+		quota, err = strconv.Atoi(components[3])
+		if err != nil || quota < 0 {
+			return fmt.Errorf("quota must be a positive number of MiB")
+		}
+	}
+	mount := types.Mount{
 		Tag:           components[0],
 		SecurityModel: components[1],
 		Path:          components[2],
-	})
+		QuotaMiB:      quota,
+	}
+	if len(components) == 5 {
+		mount.Type = components[4]
+	}
+	*m = append(*m, mount)
 	return nil
 }
 
@@ -58,10 +78,27 @@ func (p *ports) String() string {
 	return fmt.Sprint(*p)
 }
 
+// splitHostAddr splits the optional host address, enclosed in brackets,
+// from the start of a port mapping, e.g., [::1]:10022-22.
+func splitHostAddr(value string) (string, string, error) {
+	if !strings.HasPrefix(value, "[") {
+		return "", value, nil
+	}
+	end := strings.Index(value, "]:")
+	if end == -1 {
+		return "", "", fmt.Errorf("host address should be of format [address]:")
+	}
+	return value[1:end], value[end+2:], nil
+}
+
 func (p *ports) Set(value string) error {
+	hostAddr, value, err := splitHostAddr(value)
+	if err != nil {
+		return err
+	}
 	components := strings.Split(value, "-")
 	if len(components) != 2 {
-		return fmt.Errorf("--port parameter should be of format host-guest")
+		return fmt.Errorf("--port parameter should be of format [[address]:]host-guest")
 	}
 	host, err := strconv.Atoi(components[0])
 	if err != nil {
@@ -72,8 +109,9 @@ func (p *ports) Set(value string) error {
 		return fmt.Errorf("guest port must be a number")
 	}
 	*p = append(*p, types.PortMapping{
-		Host:  host,
-		Guest: guest,
+		Host:     host,
+		Guest:    guest,
+		HostAddr: hostAddr,
 	})
 	return nil
 }
@@ -99,17 +137,113 @@ func (d *drives) Set(value string) error {
 	return nil
 }
 
+func (s *syncs) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *syncs) Set(value string) error {
+	components := strings.SplitN(value, ":", 2)
+	if len(components) != 2 || components[0] == "" || components[1] == "" {
+		return fmt.Errorf("--sync parameter should be of format source:target")
+	}
+	source, err := filepath.Abs(components[0])
+	if err != nil {
+		return err
+	}
+	*s = append(*s, types.Sync{
+		Source: source,
+		Target: components[1],
+	})
+	return nil
+}
+
+func (u *usbDevices) String() string {
+	return fmt.Sprint(*u)
+}
+
+func (u *usbDevices) Set(value string) error {
+	dev := types.USBDevice{ID: strings.ToLower(value)}
+	if err := dev.Check(); err != nil {
+		return err
+	}
+	*u = append(*u, dev)
+	return nil
+}
+
+func (c *caches) String() string {
+	return fmt.Sprint(*c)
+}
+
+func (c *caches) Set(value string) error {
+	components := strings.SplitN(value, ":", 2)
+	cache := types.Cache{Name: components[0]}
+	if len(components) == 2 {
+		cache.Path = components[1]
+	}
+	if err := cache.Check(); err != nil {
+		return err
+	}
+	*c = append(*c, cache)
+	return nil
+}
+
+// hostPath is a flag whose value is converted to an absolute path, so that
+// it can be used by the daemon.
+type hostPath struct {
+	path *string
+}
+
+func (h hostPath) String() string {
+	if h.path == nil {
+		return ""
+	}
+	return *h.path
+}
+
+func (h hostPath) Set(value string) error {
+	p, err := filepath.Abs(value)
+	if err != nil {
+		return err
+	}
+	*h.path = p
+	return nil
+}
+
 func mergeVMOptions(vmSpec *types.VMSpec, mOpts *multiOptions) {
 	vmSpec.PortMappings = []types.PortMapping(mOpts.p)
 	vmSpec.Drives = []types.Drive(mOpts.d)
 	vmSpec.Mounts = []types.Mount(mOpts.m)
+	vmSpec.Syncs = []types.Sync(mOpts.s)
+	vmSpec.USBDevices = []types.USBDevice(mOpts.u)
+	vmSpec.Caches = []types.Cache(mOpts.c)
 }
 
 func vmFlags(fs *flag.FlagSet, customSpec *types.VMSpec, mOpts *multiOptions) {
 	fs.IntVar(&customSpec.MemMiB, "mem", customSpec.MemMiB, "Mebibytes of RAM allocated to VM")
 	fs.IntVar(&customSpec.CPUs, "cpus", customSpec.CPUs, "VCPUs assigned to VM")
-	fs.Var(&mOpts.m, "mount", "directory to mount in guest VM via 9p. Format is tag,security_model,path")
+	fs.StringVar(&customSpec.CPUModel, "cpu-model", customSpec.CPUModel, "Model of the VM's CPUs, e.g., host, max or Skylake-Server")
+	fs.IntVar(&customSpec.Sockets, "sockets", customSpec.Sockets, "Number of CPU sockets, each of which is a NUMA node")
+	fs.IntVar(&customSpec.Cores, "cores", customSpec.Cores, "Number of cores per CPU socket")
+	fs.IntVar(&customSpec.Threads, "threads", customSpec.Threads, "Number of threads per CPU core")
+	fs.StringVar(&customSpec.Graphics, "graphics", customSpec.Graphics, "Graphical console of the VM: none, vnc or spice")
+	fs.StringVar(&customSpec.Audio, "audio", customSpec.Audio, "Sound card of the VM: none, dummy, pulseaudio or spice")
+	fs.Var(&mOpts.u, "usb", "Host USB device, e.g., a webcam, passed through to the VM.  Format is vendor:product, e.g., 046d:0825")
+	fs.BoolVar(&customSpec.NestedVirt, "nested-virt", customSpec.NestedVirt, "Expose hardware virtualization to the guest")
+	fs.StringVar(&customSpec.MemoryBackend, "memory-backend", customSpec.MemoryBackend, "Backend of the VM's RAM: ram, memfd or hugepages")
+	fs.StringVar(&customSpec.HugepageSize, "hugepage-size", customSpec.HugepageSize, "Size of the hugepages backing the VM's RAM, e.g., 2M or 1G")
+	fs.Var(&mOpts.m, "mount", "directory to mount in guest VM via 9p or virtiofs. Format is tag,security_model,path[,quota_mib[,type]]")
+	fs.Var(&mOpts.c, "cache", "package cache shared with other instances, e.g., apt, dnf, gomod or pip.  Format is name[:guest-path]")
+	fs.Var(&mOpts.s, "sync", "directory mirrored into the guest VM over SSH while it runs.  Format is source:target")
 	fs.Var(&mOpts.d, "drive", "Host accessible resource to appear as block device in guest VM.  Format is path,format[,option]*")
-	fs.Var(&mOpts.p, "port", "port mapping. Format is host_port-guest_port, e.g., -port 10022-22")
+	fs.Var(&mOpts.p, "port", "port mapping. Format is host_port-guest_port, e.g., -port 10022-22.  An IPv6 host address can be given in brackets, e.g., -port [::1]:10022-22")
 	fs.UintVar(&customSpec.Qemuport, "qemuport", customSpec.Qemuport, "Port to follow qemu logs of the guest machine, eg., --qemuport=9999")
+	fs.StringVar(&customSpec.RestartPolicy, "restart", customSpec.RestartPolicy, "Restart policy of the VM: never, on-crash or always")
+	fs.BoolVar(&customSpec.Autostart, "autostart", customSpec.Autostart, "Start the VM when the ccloudvm service starts after the host has booted")
+	fs.IntVar(&customSpec.AutostartOrder, "autostart-order", customSpec.AutostartOrder, "Order in which the VM is started automatically, lowest first")
+	fs.StringVar(&customSpec.AutostartDelay, "autostart-delay", customSpec.AutostartDelay, "Time waited after starting the VM automatically before starting the next one, e.g., 30s")
+	fs.StringVar(&customSpec.ClockOffset, "clock-offset", customSpec.ClockOffset, "Offset of the VM's clock from the host's clock, e.g., --clock-offset=365d.  0 restores the host's clock")
+	fs.StringVar(&customSpec.FrozenTime, "frozen-time", customSpec.FrozenTime, "Time at which the VM's clock is frozen, e.g., --frozen-time=2030-01-01T12:00:00Z")
+	fs.Var(hostPath{&customSpec.Kernel}, "kernel", "Kernel image, e.g., arch/x86/boot/bzImage, with which the VM is booted directly")
+	fs.Var(hostPath{&customSpec.Initrd}, "initrd", "Initrd loaded with the kernel given by --kernel")
+	fs.StringVar(&customSpec.Append, "append", customSpec.Append, "Command line of the kernel given by --kernel")
 }
@@ -0,0 +1,83 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/intel/ccloudvm/types"
+	"github.com/spf13/cobra"
+)
+
+var volumeSize string
+
+var volumeCmd = &cobra.Command{
+	Use:   "volume",
+	Short: "Manages named volumes that can be attached to any instance",
+}
+
+var volumeCreateCmd = &cobra.Command{
+	Use:   "create <volume>",
+	Short: "Creates a named volume",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		size, err := types.ParseDiskSize(volumeSize)
+		if err != nil {
+			return err
+		}
+
+		return client.CreateVolume(ctx, &types.VolumeSpec{
+			Name:    args[0],
+			SizeGiB: size,
+		})
+	},
+}
+
+var volumeDeleteCmd = &cobra.Command{
+	Use:   "delete <volume>",
+	Short: "Deletes a named volume that is not attached to any instance",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.DeleteVolume(ctx, args[0])
+	},
+}
+
+var volumeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the named volumes and the instances to which they are attached",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.ListVolumes(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(volumeCmd)
+	volumeCmd.AddCommand(volumeCreateCmd)
+	volumeCmd.AddCommand(volumeDeleteCmd)
+	volumeCmd.AddCommand(volumeListCmd)
+
+	volumeCreateCmd.Flags().StringVar(&volumeSize, "size", "", "Size of the volume, e.g., 50G")
+}
@@ -0,0 +1,82 @@
+/*
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/intel/ccloudvm/client"
+	"github.com/spf13/cobra"
+)
+
+var workloadCmd = &cobra.Command{
+	Use:   "workload",
+	Short: "Shows, validates and updates workloads",
+}
+
+var workloadUpdateCmd = &cobra.Command{
+	Use:   "update [url...]",
+	Short: "Downloads again cached remote workloads, all of them by default",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.UpdateWorkloads(ctx, args)
+	},
+}
+
+var workloadShowCmd = &cobra.Command{
+	Use:   "show <workload>",
+	Short: "Shows a workload rendered as it would be for a new instance",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.ShowWorkload(ctx, args[0])
+	},
+}
+
+var workloadValidateCmd = &cobra.Command{
+	Use:   "validate <file>",
+	Short: "Checks a workload file for errors",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.ValidateWorkload(ctx, args[0])
+	},
+}
+
+var workloadsCmd = &cobra.Command{
+	Use:   "workloads",
+	Short: "Lists the workloads from which instances can be created",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancelFunc := getSignalContext()
+		defer cancelFunc()
+
+		return client.Workloads(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(workloadCmd)
+	rootCmd.AddCommand(workloadsCmd)
+	workloadCmd.AddCommand(workloadUpdateCmd)
+	workloadCmd.AddCommand(workloadShowCmd)
+	workloadCmd.AddCommand(workloadValidateCmd)
+}
@@ -16,10 +16,19 @@
 
 package types
 
+import (
+	"net"
+	"time"
+)
+
 // CreateArgs contains all the information necessary to create a new
-// ccloudvm instance.
+// ccloudvm instance.  Count instances are created, in parallel, if Count is
+// greater than 1, and named after NameTemplate, which contains a single %d
+// verb.  Group is only set by the service when creating a group.
 type CreateArgs struct {
 	Name         string
+	Count        int
+	NameTemplate string
 	WorkloadName string
 	Debug        bool
 	Update       bool
@@ -28,35 +37,808 @@ type CreateArgs struct {
 	HTTPSProxy   string
 	NoProxy      string
 	GoPath       string
+	SSHCA        bool
+	SSHAgent     bool
+	AgentKeys    []string
+	Params       map[string]string
+	Group        *GroupInfo
+	Mirrors      map[string]string
+	Encrypt      bool
+	Passphrase   string
+	Offline      bool
+	Labels       map[string]string
+	// UserData is a cloud-init user data document, a cloud-config
+	// document, a script or a multi-part MIME document, applied to
+	// the cloud-init document of the workload as UserDataMode, one of
+	// the UserData constants, says.
+	UserData     []byte
+	UserDataMode string
+	// NoProfile disables the customizations of the user's profile,
+	// ~/.ccloudvm/profile.yaml, which are otherwise applied to the
+	// instances.
+	NoProfile bool
+	// Template is the name of a template, built by BuildTemplate, from
+	// which the instance is created instead of the workload WorkloadName.
+	Template string
 }
 
+// The modes in which the user data passed to Create is applied to the
+// cloud-init document of the workload.  An empty mode means UserDataMerge.
+const (
+	UserDataMerge   = "merge"
+	UserDataReplace = "replace"
+)
+
 // CreateResult contains information about the status of an instance
-// creation request.  It has two fields. Finished, if true, indicates
-// that the creation request has finished and Line containing a lines
-// of output.
+// creation request.  Once Finished, Names contains the names of all the
+// instances created and Name the first of them.  Otherwise, Line contains
+// lines of output.
 type CreateResult struct {
 	Name     string
+	Names    []string
 	Finished bool
 	Line     string
 }
 
+// CommandResult is returned by the Result methods of the commands that
+// modify a single instance.  Position is the number of commands queued
+// ahead of the command while it waits for them to complete.
+type CommandResult struct {
+	Position int
+	Finished bool
+}
+
+// StopArgs identifies the instance to be stopped.  Timeout is waited for
+// the VM to shut down, after which it is quit if Force is true.  A zero
+// Timeout does not wait.
+type StopArgs struct {
+	Name    string
+	Timeout time.Duration
+	Force   bool
+}
+
+// Methods by which the VM of an instance is stopped.  StopACPI and
+// StopGuest VMs have shut down, after being asked to by ACPI or by the
+// poweroff command of the guest.  StopQuit VMs have been quit.
+const (
+	StopACPI  = "acpi"
+	StopGuest = "guest"
+	StopQuit  = "quit"
+)
+
+// StopResult is returned by StopResult.  Position and Finished are used
+// as in CommandResult.  Method is the method by which the VM was stopped,
+// one of the Stop constants.
+type StopResult struct {
+	Position int
+	Finished bool
+	Method   string
+}
+
 // StartArgs contain all the information needed to start a stopped
-// instance.
+// instance.  Force starts instances whose disks are known to be corrupt
+// and Passphrase unlocks encrypted disks whose key is not in the keyring.
 type StartArgs struct {
+	Name       string
+	VMSpec     VMSpec
+	Force      bool
+	Passphrase string
+}
+
+// ErrPassphraseRequired is the error returned when an instance whose disk
+// is encrypted is created or started without the passphrase of its disk.
+const ErrPassphraseRequired = "The passphrase of the encrypted disk is required"
+
+// Actions applied to several instances at once by a batch request.
+const (
+	BatchStart  = "start"
+	BatchStop   = "stop"
+	BatchQuit   = "quit"
+	BatchDelete = "delete"
+)
+
+// BatchArgs describes an action, one of the Batch constants, applied in
+// parallel to the instances matched by Names, which may contain shell
+// patterns, or to all the instances if All is true.
+type BatchArgs struct {
+	Action     string
+	Names      []string
+	All        bool
+	VMSpec     VMSpec
+	Timeout    time.Duration
+	Force      bool
+	Passphrase string
+}
+
+// BatchInstanceResult is the outcome of a batch action for the instance
+// Name.  Error is empty if the action succeeded.
+type BatchInstanceResult struct {
+	Name  string
+	Error string
+}
+
+// BatchResult contains the outcome of a batch action for each of the
+// instances to which it was applied, sorted by name.
+type BatchResult struct {
+	Instances []BatchInstanceResult
+}
+
+// RestartArgs contain the information needed to restart an instance.  The
+// VM is shut down, and quit if it has not shut down within Timeout, before
+// being booted again with the resources described by VMSpec, as by Start.
+// A zero Timeout quits the VM immediately.  Passphrase is used as by Start.
+type RestartArgs struct {
+	Name       string
+	VMSpec     VMSpec
+	Timeout    time.Duration
+	Passphrase string
+}
+
+// RenameArgs contains the information needed to rename the instance Name,
+// which must be stopped, to NewName.
+type RenameArgs struct {
+	Name    string
+	NewName string
+}
+
+// FsckArgs identifies an instance whose disk is to be checked and
+// optionally repaired.
+type FsckArgs struct {
+	Name   string
+	Repair bool
+}
+
+// ImageCheck contains the results of the check of a single disk image.
+type ImageCheck struct {
+	Path             string
+	CheckErrors      int
+	Corruptions      int
+	Leaks            int
+	CorruptionsFixed int
+	LeaksFixed       int
+}
+
+// SSHKeyResult contains the private SSH key used to access an instance and,
+// for instances that trust the daemon's SSH CA, a certificate for the key.
+// It allows the clients of remote daemons to access instances.
+type SSHKeyResult struct {
+	PrivateKey  []byte
+	Certificate []byte
+}
+
+// KubeconfigResult contains the kubectl configuration of the Kubernetes
+// cluster run by an instance.  Kubeconfig has a single context, called
+// Context, whose server is the API port forwarded to the host.
+type KubeconfigResult struct {
+	Context    string
+	Kubeconfig []byte
+}
+
+// The statuses of preflight checks.  Instances cannot be created on hosts
+// that fail a check.  Warnings identify features that are not available or
+// problems that may affect some instances.
+const (
+	PreflightOK      = "ok"
+	PreflightWarning = "warning"
+	PreflightFailed  = "failed"
+)
+
+// PreflightArgs contains the arguments of the Preflight command, the proxy
+// settings the client passes to the instances it creates.
+type PreflightArgs struct {
+	HTTPProxy  string
+	HTTPSProxy string
+}
+
+// PreflightCheck contains the outcome of a check of the daemon's host.
+// Detail describes what was found and Fix, for checks that are not ok, how
+// to fix the problem.
+type PreflightCheck struct {
+	Name   string
+	Status string
+	Detail string
+	Fix    string
+}
+
+// PreflightResult contains the outcome of the checks of the Preflight
+// command.
+type PreflightResult struct {
+	Checks []PreflightCheck
+}
+
+// AuditRecord records an operation requested by a client of the daemon.
+// Denied is true if the client was not allowed to perform it.
+type AuditRecord struct {
+	Time      time.Time
+	User      string
+	Token     string
+	Remote    string
+	Operation string
+	Instance  string
+	Denied    bool
+}
+
+// AuditLogArgs contains the arguments of the GetAuditLog command.  Only the
+// records made on or after Since and, if Instance is not empty, those of
+// operations on Instance are returned.
+type AuditLogArgs struct {
+	Since    time.Time
+	Instance string
+}
+
+// FsckResult contains the results of the check of each of the images in the
+// backing chain of an instance's disk, starting with the instance's own
+// overlay.  Corrupt is true if any corruptions remain.
+type FsckResult struct {
+	Images  []ImageCheck
+	Corrupt bool
+}
+
+// ResizeArgs contains the new resources to be assigned to an instance.
+// Fields set to 0 are left unchanged.
+type ResizeArgs struct {
+	Name    string
+	CPUs    int
+	MemMiB  int
+	DiskGiB int
+}
+
+// ResizeResult contains the outcome of a resize request.  RestartRequired
+// is true if some of the changes could not be applied to the running
+// instance and will only take effect when the instance is next started.
+type ResizeResult struct {
+	RestartRequired bool
+}
+
+// ForwardArgs identifies a port mapping to be added to or removed from
+// an instance.
+type ForwardArgs struct {
+	Name    string
+	Mapping PortMapping
+}
+
+// MountArgs identifies a mount to be added to or removed from an instance.
+// Only the Tag field of Mount is used when removing a mount.
+type MountArgs struct {
+	Name  string
+	Mount Mount
+}
+
+// DiskArgs identifies a data disk to be attached to or detached from an
+// instance.  Delete also deletes the volume of a detached disk, unless it
+// is a named volume.
+type DiskArgs struct {
 	Name   string
-	VMSpec VMSpec
+	Disk   Disk
+	Delete bool
 }
 
-// SSHDetails contains SSH connection information for an instance
+// SSHDetails contains SSH connection information for an instance.  CertPath
+// is only set for instances created in SSH CA mode.
 type SSHDetails struct {
-	KeyPath string
-	Port    int
+	KeyPath      string
+	CertPath     string
+	Host         net.IP
+	Port         int
+	ForwardAgent bool
+}
+
+// InstanceStatus describes the state of an instance when it was last
+// probed by ccloudvm.  Checked is the time of the probe.  It is zero if the
+// instance has never been probed.
+type InstanceStatus struct {
+	Running      bool
+	SSHReachable bool
+	Checked      time.Time
+}
+
+// GuestNotification is a message sent by the guest of an instance to the
+// host, e.g., to signal that a job has finished, using the ccvm-guest
+// helper.  Time is the time at which the message was received.
+type GuestNotification struct {
+	Message string
+	Time    time.Time
+}
+
+// ResourceUsage contains the CPU time consumed by an instance's VM and an
+// estimate of the energy used to run it.  EnergyJoules is zero if energy
+// usage cannot be estimated on the host.
+type ResourceUsage struct {
+	CPUSeconds   float64
+	EnergyJoules float64
+}
+
+// LiveUsage contains the resources currently used by an instance.
+// CPUPercent is relative to one host CPU and DiskBytes excludes the
+// backing image of the disk.
+type LiveUsage struct {
+	CPUPercent float64
+	RSSBytes   int64
+	DiskBytes  int64
+}
+
+// CrashInfo describes the last crash of an instance's VM.  Reason is the
+// reason for the crash reported by the hypervisor, if any, and Output
+// contains the last lines written by the VM to its serial console.
+type CrashInfo struct {
+	Time   time.Time
+	Reason string
+	Output string
 }
 
-// InstanceDetails contains information about an instance
+// InstanceDetails contains information about an instance.  Status is the
+// cached status of the instance and may be out of date.  Group and Role
+// are only set for instances that belong to a group.
 type InstanceDetails struct {
+	Name         string
+	SSH          SSHDetails
+	Workload     string
+	VMSpec       VMSpec
+	Status       InstanceStatus
+	Group        string
+	Role         string
+	Notification GuestNotification
+	Usage        ResourceUsage
+	LiveUsage    LiveUsage
+	Crashed      bool
+	LastCrash    CrashInfo
+	Degraded     bool
+	Pressure     PressureInfo
+	GuestIPv6    []string
+	Firmware     string
+	Suspended    bool
+	Ready        string
+	ReadyError   string
+	DisplayPort  int
+	Arch         string
+	Emulated     bool
+	VsockCID     uint32
+	Labels       map[string]string
+	// DockerSocket is the path of the host Unix socket to which the
+	// Docker socket of the guest is forwarded, if any.
+	DockerSocket string
+}
+
+// States of instances reported in InstanceSummary.  StateUnknown
+// instances have a state that cannot be read.
+const (
+	StateRunning   = "running"
+	StateStopped   = "stopped"
+	StateSuspended = "suspended"
+	StateCrashed   = "crashed"
+	StateUnknown   = "unknown"
+)
+
+// InstancesArgs contains the arguments of the GetInstances command.
+// Only the instances whose labels match Selector, parsed by
+// ParseSelector, are listed.  All the instances are listed if Selector
+// is empty.
+type InstancesArgs struct {
+	Selector string
+}
+
+// InstanceSummary describes an instance listed by GetInstances.  State
+// is one of the State constants.
+type InstanceSummary struct {
 	Name     string
-	SSH      SSHDetails
 	Workload string
-	VMSpec   VMSpec
+	State    string
+	Group    string
+	Labels   map[string]string
+}
+
+// Readiness states of an instance, reported in InstanceDetails.Ready.  The
+// state of instances whose VM is not running is empty.
+const (
+	ReadyPending = "pending"
+	ReadyOK      = "ready"
+	ReadyFailed  = "failed"
+)
+
+// PressureInfo describes the memory pressure experienced by an instance.
+// Since is the time at which the instance was first found to be short of
+// memory and Reason describes the signs of pressure last observed, e.g.,
+// OOM kills in the guest or heavy swapping.
+type PressureInfo struct {
+	Since  time.Time
+	Reason string
+}
+
+// RefreshStatusArgs contains the arguments of the RefreshStatus command.  The
+// status of all instances is refreshed if Names is empty.  Instances whose
+// cached status is no older than MaxStaleness are not probed.
+type RefreshStatusArgs struct {
+	Names        []string
+	MaxStaleness time.Duration
+}
+
+// RefreshStatusResult contains the details of the instances whose status was
+// refreshed.  Errors maps the names of the instances that could not be probed
+// to the reason for the failure.
+type RefreshStatusResult struct {
+	Instances []InstanceDetails
+	Errors    map[string]string
+}
+
+// Types of the events delivered by the WatchEvents command.
+const (
+	EventCreated   = "created"
+	EventStarted   = "started"
+	EventStopped   = "stopped"
+	EventDeleted   = "deleted"
+	EventCrashed   = "crashed"
+	EventDegraded  = "degraded"
+	EventRecovered = "recovered"
+	EventRenamed   = "renamed"
+)
+
+// InstanceEvent describes a change in the state of an instance.  Type is
+// one of the Event constants.
+type InstanceEvent struct {
+	Name string
+	Type string
+	Time time.Time
+}
+
+// WatchEventsArgs contains the arguments of the WatchEvents command.  Events
+// are delivered for all instances if Names is empty.
+type WatchEventsArgs struct {
+	Names []string
+}
+
+// ReportArgs contains the arguments of the Report command.  Only the
+// resources consumed on or after the day of Since are reported.
+type ReportArgs struct {
+	Since time.Time
+}
+
+// InstanceReport contains the resources consumed by an instance.  Deleted
+// is true if the instance no longer exists.
+type InstanceReport struct {
+	Name    string
+	Deleted bool
+	Usage   ResourceUsage
+}
+
+// ReportResult contains the resources consumed by each instance, and by
+// all instances, over the period of a Report command.
+type ReportResult struct {
+	Since     time.Time
+	Instances []InstanceReport
+	Total     ResourceUsage
+}
+
+// ConsoleLogArgs contains the arguments of the GetConsoleLog command.  The
+// last Lines lines of the console log of the instance Name, or the entire
+// log if Lines is 0, are returned.  If Follow is true, the output of the
+// instance's serial console continues to be delivered as it is produced.
+type ConsoleLogArgs struct {
+	Name   string
+	Lines  int
+	Follow bool
+}
+
+// ConsoleOutput contains output read from the serial console of an
+// instance.  Finished is true once all the output has been delivered.
+type ConsoleOutput struct {
+	Data     string
+	Finished bool
+}
+
+// ConsoleInputArgs contains the arguments of the SendConsoleInput command.
+type ConsoleInputArgs struct {
+	Name string
+	Data string
+}
+
+// Network modes.  Guests on a NetworkModeNAT network can connect to the host
+// and to external networks.  Guests on a NetworkModeRestricted network can
+// only be reached through their port mappings and can only reach the
+// services exposed to them by reverse port forwards.
+const (
+	NetworkModeNAT        = "nat"
+	NetworkModeRestricted = "restricted"
+)
+
+// DefaultNetwork is the name of the network to which instances are
+// connected if they do not specify a network.  It cannot be deleted.
+const DefaultNetwork = "default"
+
+// NetworkSpec describes a named network.  The port mappings of the
+// instances on an Isolated network can only be exposed on loopback
+// addresses.
+type NetworkSpec struct {
+	Name       string   `yaml:"name"`
+	Subnet     string   `yaml:"subnet"`
+	IPv6Subnet string   `yaml:"ipv6_subnet"`
+	Mode       string   `yaml:"mode"`
+	DNSSearch  []string `yaml:"dns_search"`
+	Isolated   bool     `yaml:"isolated"`
+}
+
+// VolumeSpec describes a named volume to be created.
+type VolumeSpec struct {
+	Name    string
+	SizeGiB int
+}
+
+// VolumeInfo describes a named volume.  Instance is the name of the
+// instance to which the volume is attached, if any, and Disk the name of
+// the disk it backs in that instance.
+type VolumeInfo struct {
+	VolumeSpec
+	Instance string
+	Disk     string
+}
+
+// CacheInfo describes a shared package cache.  SizeBytes is the size of the
+// files it contains and Instances lists the instances that use it.
+type CacheInfo struct {
+	Name      string
+	SizeBytes int64
+	Files     int
+	Instances []string
+}
+
+// NetworkInfo describes a network and lists the instances connected to it.
+type NetworkInfo struct {
+	NetworkSpec
+	Instances []string
+}
+
+// ExecArgs contains the arguments of the Exec command.  Command is executed
+// by the shell of the instance's default user.
+type ExecArgs struct {
+	Name    string
+	Command string
+}
+
+// ExecOutput contains a piece of the output of a command executed by Exec.
+// The last result returned has Finished set to true and contains the exit
+// code of the command.
+type ExecOutput struct {
+	Stdout   string
+	Stderr   string
+	Finished bool
+	ExitCode int
+}
+
+// DrainArgs contains the arguments of the Drain command.  Transactions that
+// are still in progress Timeout after the drain has started are cancelled.
+// A Timeout of 0 waits for all transactions to complete.
+type DrainArgs struct {
+	Timeout time.Duration
+}
+
+// SetLogLevelArgs contains the arguments of the SetLogLevel command.  Level
+// is one of debug, info, warning and error.  The log level of the daemon is
+// left unchanged if Level is empty.
+type SetLogLevelArgs struct {
+	Level string
+}
+
+// WorkloadInfo describes a workload from which instances can be created.
+// Source is bundled for the workloads shipped with ccloudvm, user for those
+// stored in the ccloudvm directory and remote for the cached copies of the
+// workloads downloaded from URLs, in which case Name is the URL.
+type WorkloadInfo struct {
+	Name          string
+	Source        string
+	BaseImageName string
+	Inherits      []string
+	Group         bool
+}
+
+// WorkloadDetails contains the instance specification and the cloud-init
+// document of a workload, rendered as they would be for a new instance.
+// CloudConfig is empty for group workloads.
+type WorkloadDetails struct {
+	Name        string
+	Spec        string
+	CloudConfig string
+}
+
+// ValidateWorkloadArgs contains a workload to be validated.  Name is only
+// used to report errors.
+type ValidateWorkloadArgs struct {
+	Name string
+	Data []byte
+}
+
+// WorkloadProblem describes an error found in a workload.  Line is the
+// number of the line of the workload at which the error was found, or 0 if
+// the error cannot be located.
+type WorkloadProblem struct {
+	Line    int
+	Message string
+}
+
+// ExportArgs identifies a stopped instance to be exported and the path of
+// the archive to which it is exported.
+type ExportArgs struct {
+	Name string
+	Path string
+}
+
+// BuildTemplateArgs identifies a running instance to be sealed and turned
+// into the template Template, from which instances are created without
+// being provisioned again.  The instance is stopped once sealed.  If Output
+// is not empty, the qcow2 image of the template is also copied to Output.
+type BuildTemplateArgs struct {
+	Name     string
+	Template string
+	Output   string
+}
+
+// TemplateInfo describes a template built by BuildTemplate.  Image is the
+// path of its qcow2 image, or of the copy of the image if an output was
+// requested, ImageSHA256 the SHA-256 digest of this image and Workload the
+// path of the workload from which instances are created from the template.
+type TemplateInfo struct {
+	Name        string
+	Image       string
+	ImageSHA256 string
+	Workload    string
+}
+
+// ImportArgs contains the path of an archive created by exporting an
+// instance and the name of the workload to be created from it.  Name
+// defaults to the name of the archive without its extension.
+type ImportArgs struct {
+	Path string
+	Name string
+}
+
+// ImportVagrantArgs contains the path of a Vagrantfile, or of its
+// directory, and the name of the workload to be created from it.
+type ImportVagrantArgs struct {
+	Path     string
+	Name     string
+	Inherits string
+}
+
+// ImportVagrantResult contains the name of the workload created from a
+// Vagrantfile, the workload it inherits from and the settings of the
+// Vagrantfile that could not be translated.
+type ImportVagrantResult struct {
+	Name     string
+	Inherits string
+	Warnings []string
+}
+
+// PushArgs contains the path of an archive created by exporting an instance
+// and the reference, e.g., ghcr.io/org/devvm:1.2, under which it is pushed to
+// an OCI registry.  PlainHTTP allows the use of registries that do not
+// support HTTPS.
+type PushArgs struct {
+	Reference string
+	Path      string
+	PlainHTTP bool
+}
+
+// PullArgs contains the reference of an exported instance stored in an OCI
+// registry and the name of the workload to be created from it.  Name
+// defaults to the last component of the repository of the reference.
+type PullArgs struct {
+	Reference string
+	Name      string
+	PlainHTTP bool
+}
+
+// BackupArgs identifies an instance to be backed up and the directory in
+// which the backup is stored.  Dir defaults to the backups directory of the
+// ccloudvm directory.
+type BackupArgs struct {
+	Name string
+	Dir  string
+}
+
+// BackupInfo describes a backup of the disk of an instance.  Scheduled is
+// true for the backups taken by a backup policy, which are the only ones
+// deleted automatically.
+type BackupInfo struct {
+	Instance  string
+	ID        string
+	Path      string
+	Created   time.Time
+	SizeBytes int64
+	Scheduled bool
+	Encrypted bool
+}
+
+// BackupListArgs identifies the instance whose backups, stored in Dir, are
+// listed.
+type BackupListArgs struct {
+	Name string
+	Dir  string
+}
+
+// BackupList contains the backups of an instance, oldest first, and its
+// backup policy, if any.
+type BackupList struct {
+	Backups []BackupInfo
+	Policy  *BackupPolicy
+}
+
+// RestoreArgs identifies the backup, stored in Dir, whose disk replaces the
+// disk of a stopped instance.
+type RestoreArgs struct {
+	Name string
+	ID   string
+	Dir  string
+}
+
+// BackupPolicy schedules automatic backups of an instance.  Interval is
+// hourly, daily, weekly or a duration of at least one hour.  Off removes
+// the policy of the instance.
+type BackupPolicy struct {
+	Name     string
+	Interval string
+	Keep     int
+	Dir      string
+	Off      bool
+}
+
+// SuspendArgs identifies the instance whose VM is suspended to disk.  All
+// suspends all the running instances instead.
+type SuspendArgs struct {
+	Name string
+	All  bool
+}
+
+// ResumeArgs identifies the suspended instance whose VM is resumed from
+// disk.  Discard deletes the saved state of the VM instead.
+type ResumeArgs struct {
+	Name       string
+	All        bool
+	Passphrase string
+	Discard    bool
+}
+
+// SetArgs identifies the instance whose settings are changed.  Settings
+// contains the new values of the settings, indexed by name.  See
+// VMSpec.Set for the settings that can be changed.
+type SetArgs struct {
+	Name     string
+	Settings map[string]string
+}
+
+// SyncStatus describes the mirroring of a host directory into the guest of
+// an instance.  LastSync is the time at which Source was last mirrored
+// successfully, Pending is true if it has changed since and Error describes
+// the last failure to mirror it, if the last attempt failed.
+type SyncStatus struct {
+	Source   string
+	Target   string
+	LastSync time.Time
+	Pending  bool
+	Error    string
+}
+
+// SyncStatusResult is returned by SyncStatusResult.  Active is true while
+// the directories are being mirrored, i.e., while the VM runs and the
+// syncs are not paused.
+type SyncStatusResult struct {
+	Paused bool
+	Active bool
+	Syncs  []SyncStatus
+}
+
+// ProxyArgs contains the arguments of the Proxy command.  A SOCKS proxy is
+// started on SOCKSPort, and an HTTP proxy on HTTPPort, if they are not 0.
+// The proxies listen on Address, which defaults to 127.0.0.1.
+type ProxyArgs struct {
+	Name      string
+	Address   string
+	SOCKSPort int
+	HTTPPort  int
+}
+
+// ProxyStatus is returned by ProxyResult.  The first result returned
+// contains the addresses on which the proxies are listening.  The last has
+// Finished set to true.
+type ProxyStatus struct {
+	SOCKS    string
+	HTTP     string
+	Finished bool
 }
@@ -0,0 +1,38 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+//go:build !darwin
+// +build !darwin
+
+package types
+
+import "path/filepath"
+
+// DataDir returns the directory, given the user's home directory, in which
+// ccloudvm stores its configuration, downloaded images and instances.
+func DataDir(home string) string {
+	return filepath.Join(home, ".ccloudvm")
+}
+
+// SystemSocket is the socket of the daemon that serves all the users of the
+// host when it is run in system mode.
+const SystemSocket = "/run/ccloudvm/socket"
+
+// SystemUserDataDir returns the directory in which the daemon stores the
+// data of the user username when it is run in system mode.
+func SystemUserDataDir(username string) string {
+	return filepath.Join("/var/lib/ccloudvm", "users", username)
+}
@@ -0,0 +1,36 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package types
+
+import "path/filepath"
+
+// DataDir returns the directory, given the user's home directory, in which
+// ccloudvm stores its configuration, downloaded images and instances.  On
+// macOS this is a folder in the user's Application Support directory.
+func DataDir(home string) string {
+	return filepath.Join(home, "Library", "Application Support", "ccloudvm")
+}
+
+// SystemSocket is the socket of the daemon that serves all the users of the
+// host when it is run in system mode, which is not supported on macOS.
+const SystemSocket = "/var/run/ccloudvm/socket"
+
+// SystemUserDataDir returns the directory in which the daemon stores the
+// data of the user username when it is run in system mode.
+func SystemUserDataDir(username string) string {
+	return filepath.Join("/var/lib/ccloudvm", "users", username)
+}
@@ -0,0 +1,73 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package types
+
+import "net"
+
+// GroupRole describes one of the roles of a group workload.  Count
+// instances of the workload Workload are created for the role.  VM
+// overrides the VM specification of Workload for these instances.
+type GroupRole struct {
+	Name     string `yaml:"name"`
+	Workload string `yaml:"workload"`
+	Count    int    `yaml:"count"`
+	VM       VMSpec `yaml:"vm"`
+}
+
+// GroupSpec describes a group workload, i.e., a workload that creates
+// a cluster of instances.  The instances of each role are created once
+// all the instances of the previous roles have been created.
+type GroupSpec struct {
+	Roles []GroupRole `yaml:"roles"`
+}
+
+// GroupMember identifies an instance that belongs to a group.  HostIP is
+// the host address on which the instance's forwarded ports are exposed.
+type GroupMember struct {
+	Name   string
+	Role   string
+	HostIP net.IP
+}
+
+// GroupInfo is made available to the templates of the workloads of the
+// instances of a group.  It identifies the group and the role of the
+// instance being created.  Index is the index of the instance within its
+// role, starting at 1.
+type GroupInfo struct {
+	Name    string
+	Role    string
+	Index   int
+	Members []GroupMember
+}
+
+// HostIP returns the host address of the first member of the group that
+// has the given role, or an empty string if there is no such member.
+// Services exposed by the instances of previous roles can be reached
+// from within the guest via the ports forwarded to this address.
+func (g *GroupInfo) HostIP(role string) string {
+	for _, m := range g.Members {
+		if m.Role == role {
+			return m.HostIP.String()
+		}
+	}
+	return ""
+}
+
+// GroupArgs identifies a group of instances.
+type GroupArgs struct {
+	Name string
+}
@@ -0,0 +1,114 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package types
+
+import (
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Kubeconfig is a kubectl configuration file.  Only the fields needed to
+// merge configurations are decoded.  The other fields are preserved.
+type Kubeconfig struct {
+	APIVersion     string                 `yaml:"apiVersion,omitempty"`
+	Kind           string                 `yaml:"kind,omitempty"`
+	Clusters       []KubeconfigCluster    `yaml:"clusters"`
+	Contexts       []KubeconfigContext    `yaml:"contexts"`
+	Users          []KubeconfigUser       `yaml:"users"`
+	CurrentContext string                 `yaml:"current-context"`
+	Rest           map[string]interface{} `yaml:",inline"`
+}
+
+// KubeconfigCluster is a named cluster of a Kubeconfig.
+type KubeconfigCluster struct {
+	Name    string                 `yaml:"name"`
+	Cluster map[string]interface{} `yaml:"cluster"`
+}
+
+// KubeconfigContext is a named context of a Kubeconfig.
+type KubeconfigContext struct {
+	Name    string                 `yaml:"name"`
+	Context map[string]interface{} `yaml:"context"`
+}
+
+// KubeconfigUser is a named user of a Kubeconfig.
+type KubeconfigUser struct {
+	Name string                 `yaml:"name"`
+	User map[string]interface{} `yaml:"user"`
+}
+
+// ParseKubeconfig parses a kubectl configuration file.
+func ParseKubeconfig(data []byte) (*Kubeconfig, error) {
+	var k Kubeconfig
+	if err := yaml.Unmarshal(data, &k); err != nil {
+		return nil, errors.Wrap(err, "Unable to parse kubeconfig")
+	}
+	return &k, nil
+}
+
+// Marshal returns the contents of the kubectl configuration file k.
+func (k *Kubeconfig) Marshal() ([]byte, error) {
+	if k.APIVersion == "" {
+		k.APIVersion = "v1"
+	}
+	if k.Kind == "" {
+		k.Kind = "Config"
+	}
+	data, err := yaml.Marshal(k)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to marshal kubeconfig")
+	}
+	return data, nil
+}
+
+// Merge adds the clusters, contexts and users of src to k, replacing those
+// of k that have the same names.  The current context of k is not changed.
+func (k *Kubeconfig) Merge(src *Kubeconfig) {
+	for _, c := range src.Clusters {
+		i := 0
+		for i < len(k.Clusters) && k.Clusters[i].Name != c.Name {
+			i++
+		}
+		if i == len(k.Clusters) {
+			k.Clusters = append(k.Clusters, c)
+		} else {
+			k.Clusters[i] = c
+		}
+	}
+	for _, c := range src.Contexts {
+		i := 0
+		for i < len(k.Contexts) && k.Contexts[i].Name != c.Name {
+			i++
+		}
+		if i == len(k.Contexts) {
+			k.Contexts = append(k.Contexts, c)
+		} else {
+			k.Contexts[i] = c
+		}
+	}
+	for _, u := range src.Users {
+		i := 0
+		for i < len(k.Users) && k.Users[i].Name != u.Name {
+			i++
+		}
+		if i == len(k.Users) {
+			k.Users = append(k.Users, u)
+		} else {
+			k.Users[i] = u
+		}
+	}
+}
@@ -0,0 +1,123 @@
+//
+// Copyright (c) 2018 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Labels are key=value pairs attached to instances, e.g., project=kata, by
+// which instances are selected.
+
+var labelKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,62}$`)
+var labelValueRegexp = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]{0,62})?$`)
+
+// CheckLabels checks that the keys and values of labels are valid.
+func CheckLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if !labelKeyRegexp.MatchString(k) {
+			return fmt.Errorf("Invalid label key %s", k)
+		}
+		if !labelValueRegexp.MatchString(v) {
+			return fmt.Errorf("Invalid value %s for label %s", v, k)
+		}
+	}
+	return nil
+}
+
+// FormatLabels returns labels as a comma separated list of key=value
+// pairs, sorted by key.
+func FormatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// labelRequirement is a condition on the value of the label key.
+// Instances without the label only match negated requirements.  An empty
+// value matches any value.
+type labelRequirement struct {
+	key    string
+	value  string
+	negate bool
+}
+
+func (r *labelRequirement) matches(labels map[string]string) bool {
+	v, ok := labels[r.key]
+	found := ok && (r.value == "" || v == r.value)
+	return found != r.negate
+}
+
+// Selector selects instances by their labels.  It is parsed from a comma
+// separated list of requirements: key=value, key==value, key!=value, key
+// and !key.
+type Selector []labelRequirement
+
+// ParseSelector parses the selector s.
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		var r labelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			i := strings.Index(term, "!=")
+			r = labelRequirement{key: term[:i], value: term[i+2:], negate: true}
+		case strings.Contains(term, "=="):
+			i := strings.Index(term, "==")
+			r = labelRequirement{key: term[:i], value: term[i+2:]}
+		case strings.Contains(term, "="):
+			i := strings.Index(term, "=")
+			r = labelRequirement{key: term[:i], value: term[i+1:]}
+		case strings.HasPrefix(term, "!"):
+			r = labelRequirement{key: term[1:], negate: true}
+		default:
+			r = labelRequirement{key: term}
+		}
+
+		r.key = strings.TrimSpace(r.key)
+		r.value = strings.TrimSpace(r.value)
+		if !labelKeyRegexp.MatchString(r.key) || !labelValueRegexp.MatchString(r.value) {
+			return nil, fmt.Errorf("Invalid label selector %s", term)
+		}
+		if r.value == "" && strings.Contains(term, "=") {
+			return nil, fmt.Errorf("Missing value in label selector %s", term)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Matches indicates whether labels meet all the requirements of s.
+func (s Selector) Matches(labels map[string]string) bool {
+	for i := range s {
+		if !s[i].matches(labels) {
+			return false
+		}
+	}
+	return true
+}
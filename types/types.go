@@ -21,25 +21,103 @@ import (
 	"net"
 	"os"
 	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-// PortMapping exposes a guest resident service on the host
+// PortMapping exposes a guest resident service on the host.  The service is
+// exposed on the instance's host IP address unless HostAddr is set, e.g., to
+// ::1 to expose it over IPv6.  Mappings whose HostAddr is an IPv6 address
+// are forwarded to the guest's IPv6 address.
 type PortMapping struct {
-	Host  int `yaml:"host"`
-	Guest int `yaml:"guest"`
+	Host     int    `yaml:"host"`
+	Guest    int    `yaml:"guest"`
+	HostAddr string `yaml:"host_addr"`
 }
 
 func (p PortMapping) String() string {
+	if p.HostAddr != "" {
+		return fmt.Sprintf("%s-%d", net.JoinHostPort(p.HostAddr, strconv.Itoa(p.Host)), p.Guest)
+	}
 	return fmt.Sprintf("%d-%d", p.Host, p.Guest)
 }
 
+// IPv6 returns true if the service is exposed on an IPv6 address.
+func (p PortMapping) IPv6() bool {
+	ip := net.ParseIP(p.HostAddr)
+	return ip != nil && ip.To4() == nil
+}
+
+// ReverseForwardIP is the address at which services exposed to the guest
+// by reverse port forwards can be reached from inside guests connected to
+// DefaultNetwork.  Guests on other networks use the address at the same
+// offset within their network's subnet.
+const ReverseForwardIP = "10.0.2.100"
+
+// ReverseForward exposes a service reachable from the host, e.g., an
+// artifact cache listening on the host's loopback interface, to the guest.
+// Connections made by the guest to ReverseForwardIP:Guest are forwarded to
+// Host, an address of the form host:port.
+type ReverseForward struct {
+	Guest int    `yaml:"guest"`
+	Host  string `yaml:"host"`
+}
+
+func (r ReverseForward) String() string {
+	return fmt.Sprintf("%d-%s", r.Guest, r.Host)
+}
+
+// Mount types supported by ccloudvm.  Mounts with no type are shared with the
+// guest using 9p.
+const (
+	MountType9P       = "9p"
+	MountTypeVirtiofs = "virtiofs"
+)
+
 // Mount contains information about a host path to be mounted inside the guest
 type Mount struct {
 	Tag           string `yaml:"tag"`
 	SecurityModel string `yaml:"security_model"`
 	Path          string `yaml:"path"`
+	QuotaMiB      int    `yaml:"quota_mib"`
+	Type          string `yaml:"type"`
+}
+
+// FSType returns the type of the file system used to share the mount with
+// the guest, either 9p or virtiofs.
+func (m Mount) FSType() string {
+	if m.Type == "" {
+		return MountType9P
+	}
+	return m.Type
+}
+
+// FstabOptions returns the options used to mount m inside the guest.
+func (m Mount) FstabOptions() string {
+	options := "x-systemd.automount,x-systemd.device-timeout=10,nofail"
+	if m.FSType() == MountType9P {
+		options += ",trans=virtio,version=9p2000.L"
+	}
+	return options
+}
+
+// FstabEntry returns the line that needs to be added to the guest's
+// /etc/fstab to mount m.
+func (m Mount) FstabEntry() string {
+	return fmt.Sprintf("%s %s %s %s 0 0", m.Tag, m.Path, m.FSType(), m.FstabOptions())
+}
+
+// Check verifies that the path of the mount is an existing absolute
+// directory and that its type is supported.
+func (m Mount) Check() error {
+	if m.Type != "" && m.Type != MountType9P && m.Type != MountTypeVirtiofs {
+		return fmt.Errorf("Unsupported mount type %s for %s", m.Type, m.Tag)
+	}
+	return CheckDirectory(m.Path)
 }
 
 func (m Mount) String() string {
@@ -57,16 +135,551 @@ func (d Drive) String() string {
 	return fmt.Sprintf("%s,%s,%s", d.Path, d.Format, d.Options)
 }
 
+// Disk describes a data disk attached to the VM, backed by a qcow2 volume
+// of the instance or by the named volume Volume.
+type Disk struct {
+	Name    string `yaml:"name"`
+	SizeGiB int    `yaml:"size_gib"`
+	Volume  string `yaml:"volume"`
+}
+
+var diskNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,19}$`)
+var volumeNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,63}$`)
+
+// CheckVolumeName verifies that name is a valid volume name.
+func CheckVolumeName(name string) error {
+	if !volumeNameRegexp.MatchString(name) {
+		return fmt.Errorf("Invalid volume name %q", name)
+	}
+	return nil
+}
+
+// CheckCacheName verifies that name is a valid cache name.  Names are
+// limited to 20 characters, so that the 9p tags derived from them are not
+// too long.
+func CheckCacheName(name string) error {
+	if !cacheNameRegexp.MatchString(name) {
+		return fmt.Errorf("Invalid cache name %q", name)
+	}
+	return nil
+}
+
+// CheckDiskName verifies that name is a valid disk name.  Names are limited
+// to 20 characters, the length of virtio disk serial numbers.
+func CheckDiskName(name string) error {
+	if !diskNameRegexp.MatchString(name) {
+		return fmt.Errorf("Invalid disk name %q", name)
+	}
+	return nil
+}
+
+// Check verifies that the name and the size of the disk are valid.  The size
+// of a disk backed by a named volume may be 0, in which case the volume
+// must already exist.
+func (d Disk) Check() error {
+	if err := CheckDiskName(d.Name); err != nil {
+		return err
+	}
+	if d.Volume != "" {
+		if err := CheckVolumeName(d.Volume); err != nil {
+			return err
+		}
+		if d.SizeGiB < 0 {
+			return fmt.Errorf("Invalid size %d for disk %s", d.SizeGiB, d.Name)
+		}
+		return nil
+	}
+	if d.SizeGiB <= 0 {
+		return fmt.Errorf("Invalid size %d for disk %s", d.SizeGiB, d.Name)
+	}
+	return nil
+}
+
+func (d Disk) String() string {
+	if d.Volume != "" {
+		return fmt.Sprintf("%s,%s", d.Name, d.Volume)
+	}
+	return fmt.Sprintf("%s,%dG", d.Name, d.SizeGiB)
+}
+
+// Sync describes a host directory, Source, that is continuously mirrored
+// into the guest directory Target over SSH, as an alternative to sharing it
+// with a mount.  Changes made in Target in the guest are overwritten.
+// Exclude lists rsync patterns of the files that are not mirrored.
+type Sync struct {
+	Source  string   `yaml:"source"`
+	Target  string   `yaml:"target"`
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+// Check verifies that the source of the sync is an existing absolute
+// directory and that its target is an absolute path.
+func (s Sync) Check() error {
+	if s.Source == "" {
+		return fmt.Errorf("No source specified for sync to %s", s.Target)
+	}
+	if !path.IsAbs(s.Target) || path.Clean(s.Target) == "/" {
+		return fmt.Errorf("Invalid sync target %q", s.Target)
+	}
+	return CheckDirectory(s.Source)
+}
+
+func (s Sync) String() string {
+	return fmt.Sprintf("%s:%s", s.Source, s.Target)
+}
+
+// ParseDiskSize parses a disk size, e.g., 50G or 1T, and returns it in
+// gibibytes.  Sizes without a suffix are in gibibytes.
+func ParseDiskSize(size string) (int, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := 1
+	if strings.HasSuffix(s, "T") {
+		multiplier = 1024
+		s = strings.TrimSuffix(s, "T")
+	} else {
+		s = strings.TrimSuffix(s, "G")
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, errors.Errorf("Invalid disk size %s", size)
+	}
+	return n * multiplier, nil
+}
+
+// VGPU describes a mediated device, such as an NVIDIA vGPU or an Intel
+// GVT-g virtual GPU, created on a host GPU and assigned to the VM.  Parent
+// is the PCI address of the host GPU, e.g., 0000:00:02.0, and Type is one
+// of the mdev types it supports, e.g., i915-GVTg_V5_4.
+type VGPU struct {
+	Parent string `yaml:"parent"`
+	Type   string `yaml:"type"`
+}
+
+var pciAddressRegexp = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+// Check verifies that the parent and the type of the vGPU are well formed.
+func (g VGPU) Check() error {
+	if !pciAddressRegexp.MatchString(g.Parent) {
+		return fmt.Errorf("Invalid vGPU parent %s, expected a PCI address such as 0000:00:02.0",
+			g.Parent)
+	}
+	if g.Type == "" || strings.ContainsAny(g.Type, "/") || g.Type == "." || g.Type == ".." {
+		return fmt.Errorf("Invalid vGPU type %q", g.Type)
+	}
+	return nil
+}
+
+func (g VGPU) String() string {
+	return fmt.Sprintf("%s,%s", g.Parent, g.Type)
+}
+
+// GPU describes a host PCI device, typically a GPU, that is passed through
+// to the VM with VFIO.  Address is the PCI address of the device, e.g.,
+// 0000:01:00.0.
+type GPU struct {
+	Address string `yaml:"address"`
+}
+
+// Check verifies that the address of the GPU is well formed.
+func (g GPU) Check() error {
+	if !pciAddressRegexp.MatchString(g.Address) {
+		return fmt.Errorf("Invalid GPU address %s, expected a PCI address such as 0000:01:00.0",
+			g.Address)
+	}
+	return nil
+}
+
+func (g GPU) String() string {
+	return g.Address
+}
+
+var usbIDRegexp = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{4}$`)
+
+// USBDevice describes a host USB device, e.g., a webcam, that is passed
+// through to the VM.  ID is the vendor and product ID of the device, as
+// shown by lsusb, e.g., 046d:0825.
+type USBDevice struct {
+	ID string `yaml:"id"`
+}
+
+// Check verifies that the ID of the USB device is well formed.
+func (u USBDevice) Check() error {
+	if !usbIDRegexp.MatchString(u.ID) {
+		return fmt.Errorf("Invalid USB device ID %s, expected vendor:product such as 046d:0825", u.ID)
+	}
+	return nil
+}
+
+// VendorProduct returns the vendor and product IDs of the USB device.
+func (u USBDevice) VendorProduct() (string, string) {
+	ids := strings.SplitN(u.ID, ":", 2)
+	if len(ids) != 2 {
+		return u.ID, ""
+	}
+	return ids[0], ids[1]
+}
+
+func (u USBDevice) String() string {
+	return u.ID
+}
+
+var cacheNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,19}$`)
+
+// CacheDefaultPaths maps the names of well known package caches to the
+// directories of the guest in which they are kept.  Paths starting with ~/
+// are relative to the home directory of the instance's user.
+var CacheDefaultPaths = map[string]string{
+	"apt":   "/var/cache/apt/archives",
+	"dnf":   "/var/cache/dnf",
+	"gomod": "~/go/pkg/mod",
+	"pip":   "~/.cache/pip",
+}
+
+// Cache describes a package cache, managed by the daemon, that is shared by
+// all the instances that use it.  Name identifies the cache on the host and
+// Path is the directory of the guest in which it appears.  Path defaults to
+// the entry of CacheDefaultPaths for Name.
+type Cache struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+}
+
+// GuestPath returns the directory of the guest in which the cache appears.
+func (c Cache) GuestPath() string {
+	if c.Path != "" {
+		return c.Path
+	}
+	return CacheDefaultPaths[c.Name]
+}
+
+// Check verifies that the name of the cache is valid and that it has an
+// absolute guest path.
+func (c Cache) Check() error {
+	if err := CheckCacheName(c.Name); err != nil {
+		return err
+	}
+	p := c.GuestPath()
+	if p == "" {
+		return fmt.Errorf("No path given for cache %s", c.Name)
+	}
+	if !path.IsAbs(p) && !strings.HasPrefix(p, "~/") {
+		return fmt.Errorf("Path %s of cache %s must be absolute", p, c.Name)
+	}
+	if path.Clean(p) == "/" || p == "~/" {
+		return fmt.Errorf("Cache %s cannot replace the root or home directory", c.Name)
+	}
+	return nil
+}
+
+func (c Cache) String() string {
+	return c.Name + ":" + c.GuestPath()
+}
+
 // VMSpec holds the per-VM state.
 type VMSpec struct {
-	MemMiB       int           `yaml:"mem_mib"`
-	DiskGiB      int           `yaml:"disk_gib"`
-	CPUs         int           `yaml:"cpus"`
-	PortMappings []PortMapping `yaml:"ports"`
-	Mounts       []Mount       `yaml:"mounts"`
-	Drives       []Drive       `yaml:"drives"`
-	Qemuport     uint          `yaml:"qemuport"`
-	HostIP       net.IP        `yaml:"host_ip"`
+	MemMiB       int              `yaml:"mem_mib"`
+	DiskGiB      int              `yaml:"disk_gib"`
+	CPUs         int              `yaml:"cpus"`
+	PortMappings []PortMapping    `yaml:"ports"`
+	ReversePorts []ReverseForward `yaml:"reverse_ports"`
+	Mounts       []Mount          `yaml:"mounts"`
+	Drives       []Drive          `yaml:"drives"`
+	Disks        []Disk           `yaml:"disks"`
+	Qemuport     uint             `yaml:"qemuport"`
+	HostIP       net.IP           `yaml:"host_ip"`
+	Hypervisor   string           `yaml:"hypervisor"`
+	Profiling    bool             `yaml:"profiling"`
+	// Network is the name of the network to which the VM is connected.
+	// An empty name selects DefaultNetwork.
+	Network string `yaml:"network"`
+	// TapSubnet and GuestIP are the subnet of the tap device of VMs
+	// whose hypervisor has no user mode networking and the address of
+	// their guest.
+	TapSubnet string `yaml:"tap_subnet,omitempty"`
+	GuestIP   net.IP `yaml:"guest_ip,omitempty"`
+	// RestartPolicy is one of the Restart constants.  An empty policy
+	// is equivalent to RestartNever.
+	RestartPolicy string `yaml:"restart_policy"`
+	// ClockOffset and FrozenTime give the VM a clock that is offset
+	// from, or frozen relative to, the host's clock.  At most one of
+	// them is set.  See ParseClockOffset and ParseFrozenTime for their
+	// formats.
+	ClockOffset string `yaml:"clock_offset"`
+	FrozenTime  string `yaml:"frozen_time"`
+	// VGPUs lists the mediated devices created for, and assigned to,
+	// the VM each time it is booted.
+	VGPUs []VGPU `yaml:"vgpus"`
+	// GPUs lists the host PCI devices bound to vfio-pci and passed
+	// through to the VM each time it is booted.
+	GPUs []GPU `yaml:"gpus"`
+	// TPM gives the VM an emulated TPM 2.0 device, backed by swtpm.
+	TPM bool `yaml:"tpm"`
+	// Vsock gives the VM a vhost-vsock device, so that programs running
+	// on the host and in the guest can communicate over AF_VSOCK.
+	Vsock bool `yaml:"vsock"`
+	// Docker forwards the Docker socket of the guest to a Unix socket
+	// on the host while the VM runs, so that a docker client running on
+	// the host can use the Docker daemon of the guest.
+	Docker bool `yaml:"docker"`
+	// Encrypt encrypts the root disk of the VM with LUKS.  It can only
+	// be set when the VM is created.
+	Encrypt bool `yaml:"encrypt"`
+	// Firmware is one of the Firmware constants.  An empty firmware is
+	// equivalent to FirmwareBIOS.
+	Firmware string `yaml:"firmware"`
+	// Datasource is one of the Datasource constants.  An empty
+	// datasource is equivalent to DatasourceNoCloud.
+	Datasource string `yaml:"datasource"`
+	// Kernel, Initrd and Append boot the VM directly with a kernel, an
+	// optional initrd and a command line, rather than with the
+	// bootloader of its disk.  Kernel and Initrd are paths on the host
+	// that are read each time the VM is booted.
+	Kernel string `yaml:"kernel"`
+	Initrd string `yaml:"initrd"`
+	Append string `yaml:"append"`
+	// CPUModel is the model of the VM's CPUs, host by default.  Sockets,
+	// Cores and Threads describe their topology.
+	CPUModel   string `yaml:"cpu_model"`
+	Sockets    int    `yaml:"sockets"`
+	Cores      int    `yaml:"cores"`
+	Threads    int    `yaml:"threads"`
+	NestedVirt bool   `yaml:"nested_virt"`
+	// MemoryBackend is one of the MemoryBackend constants.  HugepageSize
+	// defaults to 2M.
+	MemoryBackend string `yaml:"memory_backend"`
+	HugepageSize  string `yaml:"hugepage_size"`
+	// MACAddress is the MAC address of the VM's NIC.  A fixed address
+	// is used if it is empty.  Hostname is the hostname of the guest,
+	// which defaults to the name of the instance.
+	MACAddress string `yaml:"mac_address"`
+	Hostname   string `yaml:"hostname"`
+	// Autostart starts the VM when the service first starts after the
+	// host has booted, in AutostartOrder, waiting AutostartDelay after
+	// each VM.
+	Autostart      bool   `yaml:"autostart"`
+	AutostartOrder int    `yaml:"autostart_order"`
+	AutostartDelay string `yaml:"autostart_delay"`
+	// Syncs lists the host directories mirrored into the guest while
+	// the VM runs.
+	Syncs []Sync `yaml:"syncs"`
+	// Caches lists the shared package caches made available in the
+	// guest.
+	Caches []Cache `yaml:"caches"`
+	// Graphics is one of the Graphics constants.  An empty value is
+	// equivalent to GraphicsNone.
+	Graphics string `yaml:"graphics"`
+	// Audio is one of the Audio constants.  An empty value is
+	// equivalent to AudioNone.
+	Audio string `yaml:"audio"`
+	// USBDevices lists the host USB devices, e.g., webcams, passed
+	// through to the VM each time it is booted.
+	USBDevices []USBDevice `yaml:"usb_devices"`
+	// Arch is one of the Arch constants, the architecture of the guest.
+	// An empty value is equivalent to ArchX86_64.
+	Arch string `yaml:"arch"`
+}
+
+// HasTopology returns true if the topology of the VM's CPUs is specified.
+func (in *VMSpec) HasTopology() bool {
+	return in.Sockets != 0 || in.Cores != 0 || in.Threads != 0
+}
+
+// TopologySize returns the number of sockets, cores per socket and threads
+// per core of the VM, defaulting those that are not specified to 1.
+func (in *VMSpec) TopologySize() (int, int, int) {
+	sockets, cores, threads := in.Sockets, in.Cores, in.Threads
+	if sockets == 0 {
+		sockets = 1
+	}
+	if cores == 0 {
+		cores = 1
+	}
+	if threads == 0 {
+		threads = 1
+	}
+	return sockets, cores, threads
+}
+
+// TopologyCPUs returns the number of CPUs described by the topology of the
+// VM, or 0 if its topology is not specified.
+func (in *VMSpec) TopologyCPUs() int {
+	if !in.HasTopology() {
+		return 0
+	}
+	sockets, cores, threads := in.TopologySize()
+	return sockets * cores * threads
+}
+
+// Restart policies determine whether the VM of an instance is restarted
+// by ccloudvm when it exits without having been asked to.  RestartOnCrash
+// restarts VMs that crash.  RestartAlways also restarts VMs that are shut
+// down from within the guest.
+const (
+	RestartNever   = "never"
+	RestartOnCrash = "on-crash"
+	RestartAlways  = "always"
+)
+
+// Firmware with which VMs are booted.  FirmwareBIOS boots VMs with the
+// hypervisor's legacy BIOS, or with the bios of their workload.
+// FirmwareUEFI and FirmwareUEFISecureBoot boot them with the host's OVMF
+// firmware, without and with secure boot enforced.
+const (
+	FirmwareBIOS           = "bios"
+	FirmwareUEFI           = "uefi"
+	FirmwareUEFISecureBoot = "uefi-secureboot"
+)
+
+// Datasources through which cloud-init is given the cloud-init document
+// of a VM when it is created.
+const (
+	DatasourceNoCloud = "nocloud"
+	DatasourceSMBIOS  = "smbios"
+	DatasourceHTTP    = "http"
+)
+
+// Graphical consoles of VMs.  VMs with a GraphicsVNC or GraphicsSPICE
+// console are given a virtio-gpu display that can be reached with a VNC or
+// SPICE viewer on the host IP address of the instance.  GraphicsNone VMs
+// only have a serial console.
+const (
+	GraphicsNone  = "none"
+	GraphicsVNC   = "vnc"
+	GraphicsSPICE = "spice"
+)
+
+// Sound cards of VMs.
+const (
+	AudioNone       = "none"
+	AudioDummy      = "dummy"
+	AudioPulseAudio = "pulseaudio"
+	AudioSPICE      = "spice"
+)
+
+// Architectures of guests.  Guests whose architecture differs from the
+// host's, or that run on hosts without hardware virtualization, are
+// emulated by qemu's TCG.
+const (
+	ArchX86_64  = "x86_64"
+	ArchAarch64 = "aarch64"
+	ArchRiscv64 = "riscv64"
+)
+
+// Ports on which the graphical consoles of VMs listen.
+const (
+	VNCPort   = 5900
+	SPICEPort = 5930
+)
+
+// Memory backends of the VM's RAM.  MemoryBackendRAM allocates it
+// privately.  MemoryBackendMemfd allocates it with memfd and shares it, as
+// required by virtio-fs and vhost-user devices.  MemoryBackendHugepages
+// also shares it but allocates it from the host's reserved hugepages.
+const (
+	MemoryBackendRAM       = "ram"
+	MemoryBackendMemfd     = "memfd"
+	MemoryBackendHugepages = "hugepages"
+)
+
+// ParseClockOffset parses a clock offset.  Offsets are either durations,
+// e.g., -36h, or a number of days, e.g., 365d.
+func ParseClockOffset(offset string) (time.Duration, error) {
+	if strings.HasSuffix(offset, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(offset, "d"))
+		if err == nil {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(offset); err == nil {
+		return d, nil
+	}
+
+	return 0, errors.Errorf("Invalid clock offset %s", offset)
+}
+
+// ParseAutostartDelay parses the delay waited after starting a VM
+// automatically, a positive duration, e.g., 30s.
+func ParseAutostartDelay(delay string) (time.Duration, error) {
+	if delay == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(delay)
+	if err != nil || d < 0 {
+		return 0, errors.Errorf("Invalid autostart delay %s", delay)
+	}
+	return d, nil
+}
+
+// Set changes the setting key of the VM to value.  The settings are
+// autostart, on or off, autostart_order, an integer, and autostart_delay,
+// a duration.  Turning autostart off resets the order and the delay.
+func (in *VMSpec) Set(key, value string) error {
+	switch key {
+	case "autostart":
+		switch value {
+		case "on":
+			in.Autostart = true
+		case "off":
+			in.Autostart = false
+			in.AutostartOrder = 0
+			in.AutostartDelay = ""
+		default:
+			return errors.Errorf("Invalid value %s for autostart, expected on or off", value)
+		}
+	case "autostart_order":
+		order, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.Errorf("Invalid autostart order %s", value)
+		}
+		in.AutostartOrder = order
+	case "autostart_delay":
+		if _, err := ParseAutostartDelay(value); err != nil {
+			return err
+		}
+		in.AutostartDelay = value
+	default:
+		return errors.Errorf("Unknown setting %s", key)
+	}
+	return nil
+}
+
+// ParseFrozenTime parses a frozen time, given either in RFC 3339 format,
+// e.g., 2030-01-01T12:00:00Z, or as a date, e.g., 2030-01-01, in which
+// case the time is midnight UTC.
+func ParseFrozenTime(frozen string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, frozen); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("Invalid frozen time %s", frozen)
+}
+
+// ClockSetting returns the clock offset and the frozen time of the VM.
+// Both are zero if the VM uses the host's clock.
+func (in *VMSpec) ClockSetting() (time.Duration, time.Time, error) {
+	var offset time.Duration
+	var frozen time.Time
+	var err error
+
+	if in.ClockOffset != "" {
+		offset, err = ParseClockOffset(in.ClockOffset)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+	}
+	if in.FrozenTime != "" {
+		if in.ClockOffset != "" {
+			return 0, time.Time{}, errors.New("A clock offset and a frozen time cannot both be specified")
+		}
+		frozen, err = ParseFrozenTime(in.FrozenTime)
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+	}
+
+	return offset, frozen, nil
 }
 
 // CheckDirectory checks to see if a given absolute path exists and is
@@ -119,7 +732,8 @@ func (in *VMSpec) MergePorts(p []PortMapping) {
 	for _, port := range p {
 		var i int
 		for i = 0; i < portCount; i++ {
-			if port.Guest == in.PortMappings[i].Guest {
+			if port.Guest == in.PortMappings[i].Guest &&
+				port.HostAddr == in.PortMappings[i].HostAddr {
 				break
 			}
 		}
@@ -132,6 +746,27 @@ func (in *VMSpec) MergePorts(p []PortMapping) {
 	}
 }
 
+// MergeReversePorts merges a slice of reverse forwards into an existing
+// VMSpec.  Reverse forwards supplied in the r parameter override existing
+// reverse forwards, with the same guest port, in the VMSpec.
+func (in *VMSpec) MergeReversePorts(r []ReverseForward) {
+	count := len(in.ReversePorts)
+	for _, fwd := range r {
+		var i int
+		for i = 0; i < count; i++ {
+			if fwd.Guest == in.ReversePorts[i].Guest {
+				break
+			}
+		}
+
+		if i == count {
+			in.ReversePorts = append(in.ReversePorts, fwd)
+		} else {
+			in.ReversePorts[i] = fwd
+		}
+	}
+}
+
 // MergeDrives merges a slice of drives into an existing VMSpec.  Drives
 // supplied in the d parameter override existing drives in the VMSpec.
 func (in *VMSpec) MergeDrives(d []Drive) {
@@ -152,13 +787,122 @@ func (in *VMSpec) MergeDrives(d []Drive) {
 	}
 }
 
+// MergeSyncs merges a slice of syncs into an existing VMSpec.  Syncs
+// supplied in the s parameter override existing syncs with the same target.
+func (in *VMSpec) MergeSyncs(s []Sync) {
+	syncCount := len(in.Syncs)
+	for _, sync := range s {
+		var i int
+		for i = 0; i < syncCount; i++ {
+			if sync.Target == in.Syncs[i].Target {
+				break
+			}
+		}
+
+		if i == syncCount {
+			in.Syncs = append(in.Syncs, sync)
+		} else {
+			in.Syncs[i] = sync
+		}
+	}
+}
+
+// Clone returns a copy of the VMSpec that shares none of its slices with
+// in, so that the copy can be modified independently.
+func (in *VMSpec) Clone() VMSpec {
+	c := *in
+	c.PortMappings = append([]PortMapping(nil), in.PortMappings...)
+	c.ReversePorts = append([]ReverseForward(nil), in.ReversePorts...)
+	c.Mounts = append([]Mount(nil), in.Mounts...)
+	c.Drives = append([]Drive(nil), in.Drives...)
+	c.Disks = append([]Disk(nil), in.Disks...)
+	c.HostIP = append(net.IP(nil), in.HostIP...)
+	c.GuestIP = append(net.IP(nil), in.GuestIP...)
+	c.VGPUs = append([]VGPU(nil), in.VGPUs...)
+	c.GPUs = append([]GPU(nil), in.GPUs...)
+	c.Caches = append([]Cache(nil), in.Caches...)
+	c.USBDevices = append([]USBDevice(nil), in.USBDevices...)
+	c.Syncs = append([]Sync(nil), in.Syncs...)
+	for i := range c.Syncs {
+		c.Syncs[i].Exclude = append([]string(nil), in.Syncs[i].Exclude...)
+	}
+	return c
+}
+
+// MergeCaches merges a slice of caches into an existing VMSpec.  Caches
+// supplied in the c parameter override existing caches with the same name.
+func (in *VMSpec) MergeCaches(c []Cache) {
+	cacheCount := len(in.Caches)
+	for _, cache := range c {
+		var i int
+		for i = 0; i < cacheCount; i++ {
+			if cache.Name == in.Caches[i].Name {
+				break
+			}
+		}
+
+		if i == cacheCount {
+			in.Caches = append(in.Caches, cache)
+		} else {
+			in.Caches[i] = cache
+		}
+	}
+}
+
+// MergeUSBDevices adds the USB devices in u that are not already passed
+// through to the VM.
+func (in *VMSpec) MergeUSBDevices(u []USBDevice) {
+	for _, dev := range u {
+		found := false
+		for _, existing := range in.USBDevices {
+			if existing.ID == dev.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			in.USBDevices = append(in.USBDevices, dev)
+		}
+	}
+}
+
+// MergeDisks merges a slice of data disks into an existing VMSpec.  Disks
+// supplied in the d parameter override existing disks with the same name.
+func (in *VMSpec) MergeDisks(d []Disk) {
+	diskCount := len(in.Disks)
+	for _, disk := range d {
+		var i int
+		for i = 0; i < diskCount; i++ {
+			if disk.Name == in.Disks[i].Name {
+				break
+			}
+		}
+
+		if i == diskCount {
+			in.Disks = append(in.Disks, disk)
+		} else {
+			in.Disks[i] = disk
+		}
+	}
+}
+
 // MergeCustom merges one VMSpec into another.  In addition to merging
-// mounts, drives and ports, other fields in the receiver VM spec, such as
+// mounts, drives, disks and ports, other fields in the receiver VM spec, such as
 // MemMiB, are also updated, with values provided by the customSpec parameter,
 // if they are not already defined.
 func (in *VMSpec) MergeCustom(customSpec *VMSpec) error {
 	for i := range customSpec.Mounts {
-		if err := CheckDirectory(customSpec.Mounts[i].Path); err != nil {
+		if err := customSpec.Mounts[i].Check(); err != nil {
+			return err
+		}
+	}
+	for i := range customSpec.Disks {
+		if err := customSpec.Disks[i].Check(); err != nil {
+			return err
+		}
+	}
+	for i := range customSpec.Syncs {
+		if err := customSpec.Syncs[i].Check(); err != nil {
 			return err
 		}
 	}
@@ -175,6 +919,117 @@ func (in *VMSpec) MergeCustom(customSpec *VMSpec) error {
 	if customSpec.Qemuport != 0 {
 		in.Qemuport = customSpec.Qemuport
 	}
+	if customSpec.Hypervisor != "" {
+		in.Hypervisor = customSpec.Hypervisor
+	}
+	if customSpec.Network != "" {
+		in.Network = customSpec.Network
+	}
+	if customSpec.Profiling {
+		in.Profiling = true
+	}
+	if customSpec.TPM {
+		in.TPM = true
+	}
+	if customSpec.Vsock {
+		in.Vsock = true
+	}
+	if customSpec.Docker {
+		in.Docker = true
+	}
+	if customSpec.Firmware != "" {
+		in.Firmware = customSpec.Firmware
+	}
+	if customSpec.Kernel != "" {
+		in.Kernel = customSpec.Kernel
+	}
+	if customSpec.CPUModel != "" {
+		in.CPUModel = customSpec.CPUModel
+	}
+	if customSpec.HasTopology() {
+		in.Sockets = customSpec.Sockets
+		in.Cores = customSpec.Cores
+		in.Threads = customSpec.Threads
+		if customSpec.CPUs == 0 {
+			in.CPUs = customSpec.TopologyCPUs()
+		}
+	}
+	if customSpec.NestedVirt {
+		in.NestedVirt = true
+	}
+	if customSpec.MemoryBackend != "" {
+		in.MemoryBackend = customSpec.MemoryBackend
+		in.HugepageSize = customSpec.HugepageSize
+	}
+	if customSpec.Initrd != "" {
+		in.Initrd = customSpec.Initrd
+	}
+	if customSpec.Append != "" {
+		in.Append = customSpec.Append
+	}
+	if customSpec.MACAddress != "" {
+		in.MACAddress = customSpec.MACAddress
+	}
+	if customSpec.Hostname != "" {
+		in.Hostname = customSpec.Hostname
+	}
+	if customSpec.Autostart {
+		in.Autostart = true
+	}
+	if customSpec.AutostartOrder != 0 {
+		in.AutostartOrder = customSpec.AutostartOrder
+	}
+	if customSpec.AutostartDelay != "" {
+		if _, err := ParseAutostartDelay(customSpec.AutostartDelay); err != nil {
+			return err
+		}
+		in.AutostartDelay = customSpec.AutostartDelay
+	}
+	switch customSpec.RestartPolicy {
+	case "":
+	case RestartNever, RestartOnCrash, RestartAlways:
+		in.RestartPolicy = customSpec.RestartPolicy
+	default:
+		return errors.Errorf("Unknown restart policy %s", customSpec.RestartPolicy)
+	}
+	switch customSpec.Graphics {
+	case "":
+	case GraphicsNone, GraphicsVNC, GraphicsSPICE:
+		in.Graphics = customSpec.Graphics
+	default:
+		return errors.Errorf("Unknown graphics %s, expected %s, %s or %s", customSpec.Graphics,
+			GraphicsNone, GraphicsVNC, GraphicsSPICE)
+	}
+	switch customSpec.Audio {
+	case "":
+	case AudioNone, AudioDummy, AudioPulseAudio, AudioSPICE:
+		in.Audio = customSpec.Audio
+	default:
+		return errors.Errorf("Unknown audio %s, expected %s, %s, %s or %s", customSpec.Audio,
+			AudioNone, AudioDummy, AudioPulseAudio, AudioSPICE)
+	}
+	for _, u := range customSpec.USBDevices {
+		if err := u.Check(); err != nil {
+			return err
+		}
+	}
+	in.MergeUSBDevices(customSpec.USBDevices)
+	for _, c := range customSpec.Caches {
+		if err := c.Check(); err != nil {
+			return err
+		}
+	}
+	in.MergeCaches(customSpec.Caches)
+
+	// Setting either the clock offset or the frozen time replaces any
+	// previous clock setting.  An offset of 0 restores the host's clock.
+	if customSpec.ClockOffset != "" || customSpec.FrozenTime != "" {
+		if _, _, err := customSpec.ClockSetting(); err != nil {
+			return err
+		}
+		in.ClockOffset = customSpec.ClockOffset
+		in.FrozenTime = customSpec.FrozenTime
+	}
 
 	if len(customSpec.HostIP) > 0 {
 		in.HostIP = customSpec.HostIP
@@ -186,16 +1041,31 @@ func (in *VMSpec) MergeCustom(customSpec *VMSpec) error {
 
 	in.MergeMounts(customSpec.Mounts)
 	in.MergePorts(customSpec.PortMappings)
+	in.MergeReversePorts(customSpec.ReversePorts)
 	in.MergeDrives(customSpec.Drives)
+	in.MergeDisks(customSpec.Disks)
+	in.MergeSyncs(customSpec.Syncs)
 
 	return nil
 }
 
+// DisplayPort returns the port of the host IP address of the instance on
+// which its graphical console can be reached, or 0 if it has none.
+func (in *VMSpec) DisplayPort() int {
+	switch in.Graphics {
+	case GraphicsVNC:
+		return VNCPort
+	case GraphicsSPICE:
+		return SPICEPort
+	}
+	return 0
+}
+
 // SSHPort returns the port on the host which can be used to access the instance
 // described by the VMSpec.
 func (in *VMSpec) SSHPort() (int, error) {
 	for _, p := range in.PortMappings {
-		if p.Guest == 22 {
+		if p.Guest == 22 && p.HostAddr == "" {
 			return p.Host, nil
 		}
 	}
@@ -208,17 +1078,109 @@ func (in *VMSpec) Merge(parent *VMSpec) {
 		in.MemMiB = parent.MemMiB
 	}
 
+	if !in.HasTopology() {
+		in.Sockets = parent.Sockets
+		in.Cores = parent.Cores
+		in.Threads = parent.Threads
+	}
+	if in.CPUs == 0 {
+		in.CPUs = in.TopologyCPUs()
+	}
 	if in.CPUs == 0 {
 		in.CPUs = parent.CPUs
 	}
+	if in.CPUModel == "" {
+		in.CPUModel = parent.CPUModel
+	}
+	if !in.NestedVirt {
+		in.NestedVirt = parent.NestedVirt
+	}
+	if in.MemoryBackend == "" {
+		in.MemoryBackend = parent.MemoryBackend
+		in.HugepageSize = parent.HugepageSize
+	}
 	if in.DiskGiB == 0 {
 		in.DiskGiB = parent.DiskGiB
 	}
 	if in.Qemuport == 0 {
 		in.Qemuport = parent.Qemuport
 	}
+	if in.Hypervisor == "" {
+		in.Hypervisor = parent.Hypervisor
+	}
+	if in.Network == "" {
+		in.Network = parent.Network
+	}
+	if !in.Profiling {
+		in.Profiling = parent.Profiling
+	}
+	if in.RestartPolicy == "" {
+		in.RestartPolicy = parent.RestartPolicy
+	}
+	if in.Graphics == "" {
+		in.Graphics = parent.Graphics
+	}
+	if in.ClockOffset == "" && in.FrozenTime == "" {
+		in.ClockOffset = parent.ClockOffset
+		in.FrozenTime = parent.FrozenTime
+	}
+	if len(in.VGPUs) == 0 {
+		in.VGPUs = parent.VGPUs
+	}
+	if len(in.GPUs) == 0 {
+		in.GPUs = parent.GPUs
+	}
+	if in.Audio == "" {
+		in.Audio = parent.Audio
+	}
+	if in.Arch == "" {
+		in.Arch = parent.Arch
+	}
+	if len(in.USBDevices) == 0 {
+		in.USBDevices = parent.USBDevices
+	}
+	if len(in.Caches) == 0 {
+		in.Caches = parent.Caches
+	}
+	if !in.TPM {
+		in.TPM = parent.TPM
+	}
+	if !in.Vsock {
+		in.Vsock = parent.Vsock
+	}
+	if !in.Docker {
+		in.Docker = parent.Docker
+	}
+	if !in.Encrypt {
+		in.Encrypt = parent.Encrypt
+	}
+	if in.Firmware == "" {
+		in.Firmware = parent.Firmware
+	}
+	if in.Datasource == "" {
+		in.Datasource = parent.Datasource
+	}
+	if in.Kernel == "" {
+		in.Kernel = parent.Kernel
+		in.Initrd = parent.Initrd
+		in.Append = parent.Append
+	}
+	if in.MACAddress == "" {
+		in.MACAddress = parent.MACAddress
+	}
+	if in.Hostname == "" {
+		in.Hostname = parent.Hostname
+	}
+	if !in.Autostart {
+		in.Autostart = parent.Autostart
+		in.AutostartOrder = parent.AutostartOrder
+		in.AutostartDelay = parent.AutostartDelay
+	}
 
 	in.MergeMounts(parent.Mounts)
 	in.MergePorts(parent.PortMappings)
+	in.MergeReversePorts(parent.ReversePorts)
 	in.MergeDrives(parent.Drives)
+	in.MergeDisks(parent.Disks)
+	in.MergeSyncs(parent.Syncs)
 }